@@ -0,0 +1,222 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/apache/arrow/go/v10/arrow"
+	"github.com/apache/arrow/go/v10/arrow/array"
+	"github.com/apache/arrow/go/v10/arrow/ipc"
+)
+
+// arrowDecoder decodes the Arrow record batches of a read session into rows
+// with the same Go types as rows read with the REST API.
+type arrowDecoder struct {
+	serializedSchema []byte
+	schema           Schema
+}
+
+func newArrowDecoder(serializedSchema []byte, schema Schema) *arrowDecoder {
+	return &arrowDecoder{serializedSchema: serializedSchema, schema: schema}
+}
+
+// decodeRows decodes a serialized record batch. It is safe to call
+// concurrently.
+func (d *arrowDecoder) decodeRows(serializedBatch []byte) ([][]Value, error) {
+	// A batch can only be read as part of an IPC stream, which starts with
+	// the schema.
+	buf := make([]byte, 0, len(d.serializedSchema)+len(serializedBatch))
+	buf = append(buf, d.serializedSchema...)
+	buf = append(buf, serializedBatch...)
+	r, err := ipc.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Release()
+	var rows [][]Value
+	for r.Next() {
+		rs, err := d.convertRecord(r.Record())
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, rs...)
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// convertRecord converts the rows of a record, whose columns are matched to
+// the schema by name.
+func (d *arrowDecoder) convertRecord(rec arrow.Record) ([][]Value, error) {
+	cols := make([]arrow.Array, len(d.schema))
+	for i, f := range d.schema {
+		idx := rec.Schema().FieldIndices(f.Name)
+		if len(idx) == 0 {
+			return nil, fmt.Errorf("bigquery: Arrow record has no column %q", f.Name)
+		}
+		cols[i] = rec.Column(idx[0])
+	}
+	rows := make([][]Value, rec.NumRows())
+	for i := range rows {
+		row := make([]Value, len(d.schema))
+		for j, f := range d.schema {
+			v, err := convertArrowValue(cols[j], i, f)
+			if err != nil {
+				return nil, err
+			}
+			row[j] = v
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// convertArrowValue converts the i'th value of col, which holds the field f.
+func convertArrowValue(col arrow.Array, i int, f *FieldSchema) (Value, error) {
+	if col.IsNull(i) {
+		return nil, nil
+	}
+	if f.Repeated {
+		list, ok := col.(*array.List)
+		if !ok {
+			return nil, fmt.Errorf("bigquery: got Arrow type %s for repeated field %q", col.DataType(), f.Name)
+		}
+		elem := *f
+		elem.Repeated = false
+		start, end := list.ValueOffsets(i)
+		var values []Value
+		for j := int(start); j < int(end); j++ {
+			v, err := convertArrowValue(list.ListValues(), j, &elem)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	switch f.Type {
+	case RecordFieldType:
+		s, ok := col.(*array.Struct)
+		if !ok {
+			break
+		}
+		st := s.DataType().(*arrow.StructType)
+		var values []Value
+		for _, sf := range f.Schema {
+			idx, ok := st.FieldIdx(sf.Name)
+			if !ok {
+				return nil, fmt.Errorf("bigquery: Arrow struct has no field %q", sf.Name)
+			}
+			v, err := convertArrowValue(s.Field(idx), i, sf)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case IntervalFieldType:
+		switch col := col.(type) {
+		case *array.MonthDayNanoInterval:
+			v := col.Value(i)
+			iv := IntervalValueFromDuration(time.Duration(v.Nanoseconds))
+			iv.Months = v.Months
+			iv.Days = v.Days
+			return iv.Canonicalize(), nil
+		case *array.String:
+			iv, err := ParseInterval(col.Value(i))
+			if err != nil {
+				return nil, fmt.Errorf("bigquery: invalid INTERVAL value %q", col.Value(i))
+			}
+			return iv, nil
+		}
+	default:
+		if v, ok := convertArrowBasicValue(col, i, f.Type); ok {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("bigquery: got Arrow type %s for field %q of type %s", col.DataType(), f.Name, f.Type)
+}
+
+// convertArrowBasicValue converts the i'th value of col, which holds a value
+// of type typ. It returns false if col doesn't have an Arrow type for typ.
+func convertArrowBasicValue(col arrow.Array, i int, typ FieldType) (Value, bool) {
+	switch col := col.(type) {
+	case *array.Int64:
+		if typ == IntegerFieldType {
+			return col.Value(i), true
+		}
+	case *array.Float64:
+		if typ == FloatFieldType {
+			return col.Value(i), true
+		}
+	case *array.Boolean:
+		if typ == BooleanFieldType {
+			return col.Value(i), true
+		}
+	case *array.String:
+		switch typ {
+		case StringFieldType, GeographyFieldType, JSONFieldType:
+			return col.Value(i), true
+		}
+	case *array.Binary:
+		if typ == BytesFieldType {
+			// The value refers to the batch's memory.
+			return append([]byte{}, col.Value(i)...), true
+		}
+	case *array.Timestamp:
+		unit := col.DataType().(*arrow.TimestampType).Unit
+		t := col.Value(i).ToTime(unit).UTC()
+		switch typ {
+		case TimestampFieldType:
+			return t, true
+		case DateTimeFieldType:
+			return civil.DateTimeOf(t), true
+		}
+	case *array.Date32:
+		if typ == DateFieldType {
+			return civil.DateOf(col.Value(i).ToTime()), true
+		}
+	case *array.Time64:
+		if typ == TimeFieldType {
+			unit := col.DataType().(*arrow.Time64Type).Unit
+			return civil.TimeOf(col.Value(i).ToTime(unit)), true
+		}
+	case *array.Decimal128:
+		scale := col.DataType().(*arrow.Decimal128Type).Scale
+		if typ == NumericFieldType || typ == BigNumericFieldType {
+			return decimalRat(col.Value(i).BigInt(), scale), true
+		}
+	case *array.Decimal256:
+		scale := col.DataType().(*arrow.Decimal256Type).Scale
+		if typ == NumericFieldType || typ == BigNumericFieldType {
+			return decimalRat(col.Value(i).BigInt(), scale), true
+		}
+	}
+	return nil, false
+}
+
+// decimalRat returns the value of a decimal with the given unscaled value
+// and scale.
+func decimalRat(unscaled *big.Int, scale int32) *big.Rat {
+	denom := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	return new(big.Rat).SetFrac(unscaled, denom)
+}
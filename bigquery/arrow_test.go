@@ -0,0 +1,175 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/internal/testutil"
+	"github.com/apache/arrow/go/v10/arrow"
+	"github.com/apache/arrow/go/v10/arrow/array"
+	"github.com/apache/arrow/go/v10/arrow/decimal128"
+	"github.com/apache/arrow/go/v10/arrow/decimal256"
+	"github.com/apache/arrow/go/v10/arrow/ipc"
+	"github.com/apache/arrow/go/v10/arrow/memory"
+)
+
+// serializeArrow returns the serialized schema of rec, and rec serialized as
+// a record batch, as the Storage Read API returns them.
+func serializeArrow(t *testing.T, rec arrow.Record) (schema, batch []byte) {
+	t.Helper()
+	const eosLen = 8 // the end-of-stream marker written by Close
+	var buf bytes.Buffer
+	w := ipc.NewWriter(&buf, ipc.WithSchema(rec.Schema()))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	schema = append([]byte{}, buf.Bytes()[:buf.Len()-eosLen]...)
+	buf.Reset()
+	w = ipc.NewWriter(&buf, ipc.WithSchema(rec.Schema()))
+	if err := w.Write(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	batch = buf.Bytes()[len(schema) : buf.Len()-eosLen]
+	return schema, batch
+}
+
+func TestArrowDecoder(t *testing.T) {
+	mem := memory.NewGoAllocator()
+	fields := []arrow.Field{
+		{Name: "int", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+		{Name: "float", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "bool", Type: arrow.FixedWidthTypes.Boolean},
+		{Name: "str", Type: arrow.BinaryTypes.String},
+		{Name: "bytes", Type: arrow.BinaryTypes.Binary},
+		{Name: "ts", Type: &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}},
+		{Name: "dt", Type: &arrow.TimestampType{Unit: arrow.Microsecond}},
+		{Name: "date", Type: arrow.FixedWidthTypes.Date32},
+		{Name: "time", Type: arrow.FixedWidthTypes.Time64us},
+		{Name: "num", Type: &arrow.Decimal128Type{Precision: 38, Scale: 9}},
+		{Name: "bignum", Type: &arrow.Decimal256Type{Precision: 76, Scale: 38}},
+		{Name: "interval", Type: arrow.FixedWidthTypes.MonthDayNanoInterval},
+		{Name: "rec", Type: arrow.StructOf(
+			arrow.Field{Name: "a", Type: arrow.PrimitiveTypes.Int64},
+			arrow.Field{Name: "b", Type: arrow.BinaryTypes.String},
+		)},
+		{Name: "rep", Type: arrow.ListOf(arrow.PrimitiveTypes.Int64)},
+	}
+	b := array.NewRecordBuilder(mem, arrow.NewSchema(fields, nil))
+	defer b.Release()
+
+	ts := time.Date(2023, 4, 5, 6, 7, 8, 9000, time.UTC)
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 0}, []bool{true, false})
+	b.Field(1).(*array.Float64Builder).AppendValues([]float64{1.5, 2}, nil)
+	b.Field(2).(*array.BooleanBuilder).AppendValues([]bool{true, false}, nil)
+	b.Field(3).(*array.StringBuilder).AppendValues([]string{"a", "b"}, nil)
+	b.Field(4).(*array.BinaryBuilder).AppendValues([][]byte{[]byte("x"), nil}, nil)
+	b.Field(5).(*array.TimestampBuilder).AppendValues([]arrow.Timestamp{arrow.Timestamp(ts.UnixMicro()), 0}, nil)
+	b.Field(6).(*array.TimestampBuilder).AppendValues([]arrow.Timestamp{arrow.Timestamp(ts.UnixMicro()), 0}, nil)
+	b.Field(7).(*array.Date32Builder).AppendValues([]arrow.Date32{arrow.Date32FromTime(ts), 0}, nil)
+	b.Field(8).(*array.Time64Builder).AppendValues([]arrow.Time64{arrow.Time64((6*3600 + 7*60 + 8) * 1e6), 0}, nil)
+	b.Field(9).(*array.Decimal128Builder).AppendValues([]decimal128.Num{decimal128.FromI64(1234500000), decimal128.FromI64(0)}, nil)
+	b.Field(10).(*array.Decimal256Builder).AppendValues([]decimal256.Num{decimal256.FromI64(-5), decimal256.FromI64(0)}, nil)
+	b.Field(11).(*array.MonthDayNanoIntervalBuilder).AppendValues([]arrow.MonthDayNanoInterval{
+		{Months: 14, Days: 3, Nanoseconds: int64(90*time.Minute + 500)},
+		{},
+	}, nil)
+	sb := b.Field(12).(*array.StructBuilder)
+	sb.Append(true)
+	sb.FieldBuilder(0).(*array.Int64Builder).Append(7)
+	sb.FieldBuilder(1).(*array.StringBuilder).Append("s")
+	sb.AppendNull()
+	lb := b.Field(13).(*array.ListBuilder)
+	lb.Append(true)
+	lb.ValueBuilder().(*array.Int64Builder).AppendValues([]int64{1, 2}, nil)
+	lb.Append(true)
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	schema := Schema{
+		{Name: "int", Type: IntegerFieldType},
+		{Name: "float", Type: FloatFieldType},
+		{Name: "bool", Type: BooleanFieldType},
+		{Name: "str", Type: StringFieldType},
+		{Name: "bytes", Type: BytesFieldType},
+		{Name: "ts", Type: TimestampFieldType},
+		{Name: "dt", Type: DateTimeFieldType},
+		{Name: "date", Type: DateFieldType},
+		{Name: "time", Type: TimeFieldType},
+		{Name: "num", Type: NumericFieldType},
+		{Name: "bignum", Type: BigNumericFieldType},
+		{Name: "interval", Type: IntervalFieldType},
+		{Name: "rec", Type: RecordFieldType, Schema: Schema{
+			{Name: "a", Type: IntegerFieldType},
+			{Name: "b", Type: StringFieldType},
+		}},
+		{Name: "rep", Type: IntegerFieldType, Repeated: true},
+	}
+	serializedSchema, batch := serializeArrow(t, rec)
+	got, err := newArrowDecoder(serializedSchema, schema).decodeRows(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := time.Unix(0, 0).UTC()
+	want := [][]Value{
+		{
+			int64(1), 1.5, true, "a", []byte("x"), ts,
+			civil.DateTimeOf(ts), civil.DateOf(ts), civil.Time{Hour: 6, Minute: 7, Second: 8},
+			big.NewRat(12345, 10000), new(big.Rat).SetFrac(big.NewInt(-5), new(big.Int).Exp(big.NewInt(10), big.NewInt(38), nil)),
+			&IntervalValue{Years: 1, Months: 2, Days: 3, Hours: 1, Minutes: 30, SubSecondNanos: 500},
+			[]Value{int64(7), "s"},
+			[]Value{int64(1), int64(2)},
+		},
+		{
+			nil, 2.0, false, "b", []byte{}, epoch,
+			civil.DateTimeOf(epoch), civil.DateOf(epoch), civil.Time{},
+			new(big.Rat), new(big.Rat),
+			&IntervalValue{},
+			nil,
+			[]Value(nil),
+		},
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+}
+
+func TestArrowDecoderTypeMismatch(t *testing.T) {
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), arrow.NewSchema([]arrow.Field{
+		{Name: "f", Type: arrow.PrimitiveTypes.Int64},
+	}, nil))
+	defer b.Release()
+	b.Field(0).(*array.Int64Builder).Append(1)
+	rec := b.NewRecord()
+	defer rec.Release()
+	serializedSchema, batch := serializeArrow(t, rec)
+
+	for _, schema := range []Schema{
+		{{Name: "f", Type: StringFieldType}},
+		{{Name: "g", Type: IntegerFieldType}},
+		{{Name: "f", Type: IntegerFieldType, Repeated: true}},
+	} {
+		if _, err := newArrowDecoder(serializedSchema, schema).decodeRows(batch); err == nil {
+			t.Errorf("%v: got nil, want error", schema[0])
+		}
+	}
+}
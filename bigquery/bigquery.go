@@ -57,6 +57,7 @@ type Client struct {
 
 	projectID string
 	bqs       *bq.Service
+	rc        *readClient
 }
 
 // DetectProjectID is a sentinel value that instructs NewClient to detect the
@@ -107,6 +108,9 @@ func (c *Client) Project() string {
 // Close should be called when the client is no longer needed.
 // It need not be called at program exit.
 func (c *Client) Close() error {
+	if c.rc != nil {
+		return c.rc.close()
+	}
 	return nil
 }
 
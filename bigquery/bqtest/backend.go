@@ -0,0 +1,1108 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultLocation is the location of datasets and jobs created without
+	// one.
+	defaultLocation = "US"
+	// anonymousDataset holds the results of queries without a destination
+	// table. Datasets whose IDs start with an underscore are hidden.
+	anonymousDataset = "_bqtest_anonymous"
+)
+
+// maxIDLength is the length limit of dataset and table IDs. (It's too large
+// for a repeat count in a regexp.)
+const maxIDLength = 1024
+
+var (
+	datasetIDRE = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+	// Table IDs are validated loosely: the service allows more characters.
+	tableIDRE = regexp.MustCompile(`^[\p{L}\p{M}\p{N}_\- ]+$`)
+)
+
+func validDatasetID(id string) bool { return len(id) <= maxIDLength && datasetIDRE.MatchString(id) }
+func validTableID(id string) bool   { return len(id) <= maxIDLength && tableIDRE.MatchString(id) }
+
+// backend holds the state of the fake service. The HTTP front end translates
+// requests into calls on it.
+//
+// Datasets and jobs are keyed by project and ID. Resources stored in the
+// backend are never handed out: methods return copies.
+type backend struct {
+	mu       sync.Mutex
+	datasets map[string]*dataset
+	jobs     map[string]*job
+	uploads  map[string]*upload
+	lastID   int64
+	now      func() time.Time
+}
+
+type dataset struct {
+	proto  *bq.Dataset
+	tables map[string]*table
+}
+
+type table struct {
+	proto *bq.Table
+	rows  [][]value
+	// The insert IDs of rows streamed with insertAll, to drop duplicates.
+	insertIDs map[string]bool
+}
+
+type job struct {
+	proto *bq.Job
+	seq   int64 // for listing jobs in the order they were created
+	err   error // why the job failed
+	// The schema and rows of the results of a query job.
+	schema []*bq.TableFieldSchema
+	rows   [][]value
+}
+
+// An upload is a resumable upload of the data of a load job.
+type upload struct {
+	project string
+	job     *bq.Job
+	data    []byte
+}
+
+func newBackend() *backend {
+	return &backend{
+		datasets: map[string]*dataset{},
+		jobs:     map[string]*job{},
+		uploads:  map[string]*upload{},
+		now:      time.Now,
+	}
+}
+
+func key(project, id string) string { return project + ":" + id }
+
+func millis(t time.Time) int64 { return t.UnixNano() / 1e6 }
+
+// nextID returns a number that hasn't been returned before, for generated
+// IDs and etags.
+func (s *backend) nextID() int64 {
+	s.lastID++
+	return s.lastID
+}
+
+func (s *backend) etag() string {
+	return strconv.FormatInt(s.nextID(), 36)
+}
+
+// clone copies src to dst, which must be pointers to the same type of
+// resource.
+func clone(dst, src interface{}) {
+	b, err := json.Marshal(src)
+	if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(b, dst); err != nil {
+		panic(err)
+	}
+}
+
+func cloneDataset(d *bq.Dataset) *bq.Dataset {
+	var c bq.Dataset
+	clone(&c, d)
+	return &c
+}
+
+func cloneJob(j *bq.Job) *bq.Job {
+	var c bq.Job
+	clone(&c, j)
+	return &c
+}
+
+func (t *table) clone() *bq.Table {
+	var c bq.Table
+	clone(&c, t.proto)
+	if t.proto.View == nil {
+		c.NumRows = uint64(len(t.rows))
+	}
+	return &c
+}
+
+func checkEtag(etag, want string) error {
+	if etag != "" && etag != want {
+		return status.Errorf(codes.FailedPrecondition, "Precondition check failed.")
+	}
+	return nil
+}
+
+// merge applies the JSON patch, in the form of a patch request's body, to
+// the resource held in dst. Objects are merged, and null values delete
+// fields.
+func merge(dst interface{}, patch []byte) error {
+	var cur, p map[string]interface{}
+	clone(&cur, dst)
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return status.Errorf(codes.InvalidArgument, "bad request body: %v", err)
+	}
+	mergeMaps(cur, p)
+	b, err := json.Marshal(cur)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, dst); err != nil {
+		return status.Errorf(codes.InvalidArgument, "bad request body: %v", err)
+	}
+	return nil
+}
+
+func mergeMaps(dst, patch map[string]interface{}) {
+	for k, v := range patch {
+		switch v := v.(type) {
+		case nil:
+			delete(dst, k)
+		case map[string]interface{}:
+			if d, ok := dst[k].(map[string]interface{}); ok {
+				mergeMaps(d, v)
+			} else {
+				dst[k] = v
+			}
+		default:
+			dst[k] = v
+		}
+	}
+}
+
+// Datasets.
+
+func (s *backend) dataset(project, id string) (*dataset, error) {
+	d := s.datasets[key(project, id)]
+	if d == nil {
+		return nil, status.Errorf(codes.NotFound, "Not found: Dataset %s:%s", project, id)
+	}
+	return d, nil
+}
+
+func (s *backend) createDataset(project string, pd *bq.Dataset) (*bq.Dataset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref := pd.DatasetReference
+	if ref == nil || ref.DatasetId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Dataset ID must be specified")
+	}
+	if !validDatasetID(ref.DatasetId) {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid dataset ID %q", ref.DatasetId)
+	}
+	if ref.ProjectId != "" && ref.ProjectId != project {
+		return nil, status.Errorf(codes.InvalidArgument, "Dataset project %s does not match the request's project %s", ref.ProjectId, project)
+	}
+	return s.newDataset(project, pd)
+}
+
+func (s *backend) newDataset(project string, pd *bq.Dataset) (*bq.Dataset, error) {
+	id := pd.DatasetReference.DatasetId
+	if s.datasets[key(project, id)] != nil {
+		return nil, status.Errorf(codes.AlreadyExists, "Already Exists: Dataset %s:%s", project, id)
+	}
+	d := &dataset{proto: cloneDataset(pd), tables: map[string]*table{}}
+	now := millis(s.now())
+	d.proto.Kind = "bigquery#dataset"
+	d.proto.Id = key(project, id)
+	d.proto.DatasetReference = &bq.DatasetReference{ProjectId: project, DatasetId: id}
+	d.proto.CreationTime = now
+	d.proto.LastModifiedTime = now
+	d.proto.Etag = s.etag()
+	if d.proto.Location == "" {
+		d.proto.Location = defaultLocation
+	}
+	s.datasets[key(project, id)] = d
+	return cloneDataset(d.proto), nil
+}
+
+func (s *backend) getDataset(project, id string) (*bq.Dataset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.dataset(project, id)
+	if err != nil {
+		return nil, err
+	}
+	return cloneDataset(d.proto), nil
+}
+
+// listDatasets lists the datasets of a project, sorted by ID. Hidden
+// datasets are listed only if all is true. The filter has the form
+// "labels.key" or "labels.key:value", possibly repeated with spaces
+// between.
+func (s *backend) listDatasets(project string, all bool, filter string) ([]*bq.Dataset, error) {
+	type labelFilter struct{ key, value string }
+	var filters []labelFilter
+	for _, f := range strings.Fields(filter) {
+		if !strings.HasPrefix(f, "labels.") {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid filter %q", filter)
+		}
+		k, v, _ := strings.Cut(strings.TrimPrefix(f, "labels."), ":")
+		filters = append(filters, labelFilter{k, v})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ds []*bq.Dataset
+outer:
+	for _, d := range s.datasets {
+		ref := d.proto.DatasetReference
+		if ref.ProjectId != project || !all && strings.HasPrefix(ref.DatasetId, "_") {
+			continue
+		}
+		for _, f := range filters {
+			v, ok := d.proto.Labels[f.key]
+			if !ok || f.value != "" && v != f.value {
+				continue outer
+			}
+		}
+		ds = append(ds, cloneDataset(d.proto))
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i].DatasetReference.DatasetId < ds[j].DatasetReference.DatasetId })
+	return ds, nil
+}
+
+// updateDataset patches or replaces the writable fields of a dataset with
+// those in body.
+func (s *backend) updateDataset(project, id, etag string, body []byte, patch bool) (*bq.Dataset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.dataset(project, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEtag(etag, d.proto.Etag); err != nil {
+		return nil, err
+	}
+	updated := &bq.Dataset{}
+	if patch {
+		updated = cloneDataset(d.proto)
+	}
+	if err := merge(updated, body); err != nil {
+		return nil, err
+	}
+	// The fields that the service sets.
+	updated.Kind = d.proto.Kind
+	updated.Id = d.proto.Id
+	updated.DatasetReference = d.proto.DatasetReference
+	updated.CreationTime = d.proto.CreationTime
+	updated.Location = d.proto.Location
+	updated.LastModifiedTime = millis(s.now())
+	updated.Etag = s.etag()
+	d.proto = updated
+	return cloneDataset(d.proto), nil
+}
+
+func (s *backend) deleteDataset(project, id string, deleteContents bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.dataset(project, id)
+	if err != nil {
+		return err
+	}
+	if len(d.tables) > 0 && !deleteContents {
+		return status.Errorf(codes.InvalidArgument, "Dataset %s:%s is still in use", project, id)
+	}
+	delete(s.datasets, key(project, id))
+	return nil
+}
+
+// Tables.
+
+func (s *backend) table(project, datasetID, tableID string) (*table, error) {
+	d, err := s.dataset(project, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	t := d.tables[tableID]
+	if t == nil {
+		return nil, status.Errorf(codes.NotFound, "Not found: Table %s:%s.%s", project, datasetID, tableID)
+	}
+	return t, nil
+}
+
+func (s *backend) createTable(project, datasetID string, pt *bq.Table) (*bq.Table, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.dataset(project, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	if pt.TableReference == nil || pt.TableReference.TableId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Table ID must be specified")
+	}
+	id := pt.TableReference.TableId
+	if !validTableID(id) {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid table ID %q", id)
+	}
+	if d.tables[id] != nil {
+		return nil, status.Errorf(codes.AlreadyExists, "Already Exists: Table %s:%s.%s", project, datasetID, id)
+	}
+	switch {
+	case pt.ExternalDataConfiguration != nil:
+		return nil, status.Errorf(codes.Unimplemented, "bqtest: external tables are not supported")
+	case pt.MaterializedView != nil:
+		return nil, status.Errorf(codes.Unimplemented, "bqtest: materialized views are not supported")
+	}
+	t := &table{proto: &bq.Table{}, insertIDs: map[string]bool{}}
+	clone(t.proto, pt)
+	now := millis(s.now())
+	t.proto.Kind = "bigquery#table"
+	t.proto.Id = fmt.Sprintf("%s:%s.%s", project, datasetID, id)
+	t.proto.TableReference = &bq.TableReference{ProjectId: project, DatasetId: datasetID, TableId: id}
+	t.proto.CreationTime = now
+	t.proto.LastModifiedTime = uint64(now)
+	t.proto.Location = d.proto.Location
+	t.proto.Etag = s.etag()
+	t.proto.Type = "TABLE"
+	if err := s.setViewSchema(t); err != nil {
+		return nil, err
+	}
+	if t.proto.Schema != nil {
+		if err := validateSchema(t.proto.Schema.Fields); err != nil {
+			return nil, err
+		}
+	}
+	d.tables[id] = t
+	return t.clone(), nil
+}
+
+// setViewSchema sets the type and schema of a view from its query.
+func (s *backend) setViewSchema(t *table) error {
+	v := t.proto.View
+	if v == nil {
+		return nil
+	}
+	t.proto.Type = "VIEW"
+	if v.UseLegacySql {
+		return status.Errorf(codes.Unimplemented, "bqtest: views must use standard SQL")
+	}
+	ref := t.proto.TableReference
+	p, err := compileQuery(s.queryEnv(ref.ProjectId, nil, nil), v.Query)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Invalid view query: %v", err)
+	}
+	t.proto.Schema = &bq.TableSchema{Fields: p.fields}
+	return nil
+}
+
+func (s *backend) getTable(project, datasetID, tableID string) (*bq.Table, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.table(project, datasetID, tableID)
+	if err != nil {
+		return nil, err
+	}
+	return t.clone(), nil
+}
+
+// listTables lists the tables of a dataset, sorted by ID.
+func (s *backend) listTables(project, datasetID string) ([]*bq.Table, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.dataset(project, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	var ts []*bq.Table
+	for _, t := range d.tables {
+		ts = append(ts, t.clone())
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].TableReference.TableId < ts[j].TableReference.TableId })
+	return ts, nil
+}
+
+// updateTable patches or replaces the writable fields of a table with those
+// in body. Columns may be added to the schema, and REQUIRED columns may be
+// relaxed to NULLABLE; the rows already in the table are kept.
+func (s *backend) updateTable(project, datasetID, tableID, etag string, body []byte, patch bool) (*bq.Table, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.table(project, datasetID, tableID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEtag(etag, t.proto.Etag); err != nil {
+		return nil, err
+	}
+	updated := &table{proto: &bq.Table{}, insertIDs: t.insertIDs}
+	if patch {
+		clone(updated.proto, t.proto)
+	}
+	if err := merge(updated.proto, body); err != nil {
+		return nil, err
+	}
+	// The fields that the service sets.
+	p := updated.proto
+	p.Kind = t.proto.Kind
+	p.Id = t.proto.Id
+	p.TableReference = t.proto.TableReference
+	p.CreationTime = t.proto.CreationTime
+	p.Location = t.proto.Location
+	p.Type = t.proto.Type
+	if (p.View == nil) != (t.proto.View == nil) {
+		return nil, status.Errorf(codes.InvalidArgument, "Cannot change the type of table %s", t.proto.Id)
+	}
+	if p.View != nil {
+		if err := s.setViewSchema(updated); err != nil {
+			return nil, err
+		}
+	} else {
+		var oldFields, newFields []*bq.TableFieldSchema
+		if t.proto.Schema != nil {
+			oldFields = t.proto.Schema.Fields
+		}
+		if p.Schema != nil {
+			newFields = p.Schema.Fields
+		}
+		if err := validateSchema(newFields); err != nil {
+			return nil, err
+		}
+		if err := checkSchemaUpdate(t.proto.Id, oldFields, newFields); err != nil {
+			return nil, err
+		}
+		updated.rows = make([][]value, len(t.rows))
+		for i, r := range t.rows {
+			updated.rows[i] = migrateRow(oldFields, newFields, r)
+		}
+	}
+	p.LastModifiedTime = uint64(millis(s.now()))
+	p.Etag = s.etag()
+	d := s.datasets[key(project, datasetID)]
+	d.tables[tableID] = updated
+	return updated.clone(), nil
+}
+
+// checkSchemaUpdate checks that a table's schema can be changed from old to
+// new.
+func checkSchemaUpdate(tableID string, old, new []*bq.TableFieldSchema) error {
+	for _, o := range old {
+		i := fieldIndex(new, o.Name)
+		if i < 0 {
+			return status.Errorf(codes.InvalidArgument, "Provided Schema does not match Table %s. Field %s is missing in new schema", tableID, o.Name)
+		}
+		n := new[i]
+		if canonicalType(o.Type) != canonicalType(n.Type) {
+			return status.Errorf(codes.InvalidArgument, "Provided Schema does not match Table %s. Field %s has changed type from %s to %s", tableID, o.Name, o.Type, n.Type)
+		}
+		om, nm := mode(o), mode(n)
+		if om != nm && !(om == "REQUIRED" && nm == "NULLABLE") {
+			return status.Errorf(codes.InvalidArgument, "Provided Schema does not match Table %s. Field %s has changed mode from %s to %s", tableID, o.Name, om, nm)
+		}
+		if err := checkSchemaUpdate(tableID, o.Fields, n.Fields); err != nil {
+			return err
+		}
+	}
+	for _, n := range new {
+		if fieldIndex(old, n.Name) < 0 && isRequired(n) {
+			return status.Errorf(codes.InvalidArgument, "Provided Schema does not match Table %s. Cannot add required field %s", tableID, n.Name)
+		}
+	}
+	return nil
+}
+
+func mode(f *bq.TableFieldSchema) string {
+	if f.Mode == "" {
+		return "NULLABLE"
+	}
+	return f.Mode
+}
+
+// migrateRow converts a row of a table with the schema old to one with the
+// schema new. Columns are matched by name; new columns are NULL.
+func migrateRow(old, new []*bq.TableFieldSchema, row []value) []value {
+	res := make([]value, len(new))
+	for i, n := range new {
+		j := fieldIndex(old, n.Name)
+		if j < 0 {
+			if isRepeated(n) {
+				res[i] = []value{}
+			}
+			continue
+		}
+		v := row[j]
+		if canonicalType(n.Type) == typeRecord && v != nil {
+			if isRepeated(n) {
+				elems := make([]value, len(v.([]value)))
+				for k, e := range v.([]value) {
+					elems[k] = migrateRow(old[j].Fields, n.Fields, e.([]value))
+				}
+				v = elems
+			} else {
+				v = migrateRow(old[j].Fields, n.Fields, v.([]value))
+			}
+		}
+		res[i] = v
+	}
+	return res
+}
+
+func (s *backend) deleteTable(project, datasetID, tableID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.table(project, datasetID, tableID); err != nil {
+		return err
+	}
+	delete(s.datasets[key(project, datasetID)].tables, tableID)
+	return nil
+}
+
+// insertRows streams rows into a table, and returns the errors of the rows
+// that couldn't be inserted.
+func (s *backend) insertRows(project, datasetID, tableID string, req *bq.TableDataInsertAllRequest) ([]*bq.TableDataInsertAllResponseInsertErrors, error) {
+	if req.TemplateSuffix != "" {
+		return nil, status.Errorf(codes.Unimplemented, "bqtest: template tables are not supported")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.table(project, datasetID, tableID)
+	if err != nil {
+		return nil, err
+	}
+	if t.proto.View != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Cannot insert rows into view %s", t.proto.Id)
+	}
+	var fields []*bq.TableFieldSchema
+	if t.proto.Schema != nil {
+		fields = t.proto.Schema.Fields
+	}
+	var (
+		rows    [][]value
+		ids     []string
+		errs    []*bq.TableDataInsertAllResponseInsertErrors
+		invalid = map[int]bool{}
+	)
+	for i, r := range req.Rows {
+		m := make(map[string]interface{}, len(r.Json))
+		for k, v := range r.Json {
+			m[k] = v
+		}
+		row, err := parseRow(fields, m, req.IgnoreUnknownValues)
+		if err != nil {
+			invalid[i] = true
+			errs = append(errs, &bq.TableDataInsertAllResponseInsertErrors{
+				Index:  int64(i),
+				Errors: []*bq.ErrorProto{{Reason: "invalid", Location: "", Message: err.Error()}},
+			})
+			continue
+		}
+		rows = append(rows, row)
+		ids = append(ids, r.InsertId)
+	}
+	if len(errs) > 0 && !req.SkipInvalidRows {
+		// No rows are inserted, and the valid ones are reported as stopped.
+		for i := range req.Rows {
+			if !invalid[i] {
+				errs = append(errs, &bq.TableDataInsertAllResponseInsertErrors{
+					Index:  int64(i),
+					Errors: []*bq.ErrorProto{{Reason: "stopped"}},
+				})
+			}
+		}
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Index < errs[j].Index })
+		return errs, nil
+	}
+	t.rows = t.rows[:len(t.rows):len(t.rows)]
+	for i, row := range rows {
+		if id := ids[i]; id != "" {
+			if t.insertIDs[id] {
+				continue
+			}
+			t.insertIDs[id] = true
+		}
+		t.rows = append(t.rows, row)
+	}
+	return errs, nil
+}
+
+// tableData returns the schema and rows of a table, or the results of a
+// view's query. It's called with s.mu held.
+func (s *backend) tableData(ref *bq.TableReference, depth int) ([]*bq.TableFieldSchema, [][]value, error) {
+	t, err := s.table(ref.ProjectId, ref.DatasetId, ref.TableId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.proto.View != nil {
+		if depth >= maxViewDepth {
+			return nil, nil, queryErrorf("Views are nested too deeply at %s", t.proto.Id)
+		}
+		env := s.queryEnv(ref.ProjectId, nil, nil)
+		env.depth = depth + 1
+		return runQuery(env, t.proto.View.Query)
+	}
+	var fields []*bq.TableFieldSchema
+	if t.proto.Schema != nil {
+		fields = t.proto.Schema.Fields
+	}
+	return fields, t.rows, nil
+}
+
+// listRows returns the schema and rows of a table, for tabledata.list.
+func (s *backend) listRows(project, datasetID, tableID string) ([]*bq.TableFieldSchema, [][]value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.table(project, datasetID, tableID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.proto.View != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "Cannot list a table of type VIEW.")
+	}
+	return s.tableData(t.proto.TableReference, 0)
+}
+
+func (s *backend) queryEnv(project string, ds *bq.DatasetReference, params []*bq.QueryParameter) *queryEnv {
+	return &queryEnv{
+		projectID:      project,
+		defaultDataset: ds,
+		params:         params,
+		now:            s.now(),
+		table:          s.tableData,
+	}
+}
+
+// writeRows writes the rows of a job's results to a table, following the
+// job's create and write dispositions. The rows' columns are matched with
+// the table's by name. It's called with s.mu held.
+func (s *backend) writeRows(ref *bq.TableReference, fields []*bq.TableFieldSchema, rows [][]value, create, write string) error {
+	d, err := s.dataset(ref.ProjectId, ref.DatasetId)
+	if err != nil {
+		return err
+	}
+	t := d.tables[ref.TableId]
+	if t == nil {
+		if create == "CREATE_NEVER" {
+			return status.Errorf(codes.NotFound, "Not found: Table %s:%s.%s", ref.ProjectId, ref.DatasetId, ref.TableId)
+		}
+		if !validTableID(ref.TableId) {
+			return status.Errorf(codes.InvalidArgument, "Invalid table ID %q", ref.TableId)
+		}
+		now := millis(s.now())
+		t = &table{
+			proto: &bq.Table{
+				Kind:             "bigquery#table",
+				Id:               fmt.Sprintf("%s:%s.%s", ref.ProjectId, ref.DatasetId, ref.TableId),
+				TableReference:   &bq.TableReference{ProjectId: ref.ProjectId, DatasetId: ref.DatasetId, TableId: ref.TableId},
+				Schema:           &bq.TableSchema{Fields: fields},
+				CreationTime:     now,
+				LastModifiedTime: uint64(now),
+				Location:         d.proto.Location,
+				Etag:             s.etag(),
+				Type:             "TABLE",
+			},
+			insertIDs: map[string]bool{},
+		}
+		d.tables[ref.TableId] = t
+		t.rows = rows
+		return nil
+	}
+	if t.proto.View != nil {
+		return status.Errorf(codes.InvalidArgument, "Cannot write to view %s", t.proto.Id)
+	}
+	switch write {
+	case "WRITE_TRUNCATE":
+		t.proto.Schema = &bq.TableSchema{Fields: fields}
+		t.rows = rows
+	case "WRITE_EMPTY", "WRITE_APPEND":
+		if write == "WRITE_EMPTY" && len(t.rows) > 0 {
+			return status.Errorf(codes.AlreadyExists, "Already Exists: Table %s", t.proto.Id)
+		}
+		var tableFields []*bq.TableFieldSchema
+		if t.proto.Schema != nil {
+			tableFields = t.proto.Schema.Fields
+		}
+		if len(tableFields) == 0 {
+			t.proto.Schema = &bq.TableSchema{Fields: fields}
+			tableFields = fields
+		}
+		conformed, err := conformRows(t.proto.Id, tableFields, fields, rows)
+		if err != nil {
+			return err
+		}
+		t.rows = append(t.rows[:len(t.rows):len(t.rows)], conformed...)
+	default:
+		return status.Errorf(codes.InvalidArgument, "Invalid write disposition %q", write)
+	}
+	t.proto.LastModifiedTime = uint64(millis(s.now()))
+	t.proto.Etag = s.etag()
+	return nil
+}
+
+// conformRows converts rows with the schema src to rows of a table with the
+// schema dst.
+func conformRows(tableID string, dst, src []*bq.TableFieldSchema, rows [][]value) ([][]value, error) {
+	for _, f := range src {
+		i := fieldIndex(dst, f.Name)
+		if i < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "Provided Schema does not match Table %s. Cannot add fields (field: %s)", tableID, f.Name)
+		}
+		if !sameType(dst[i], f) {
+			return nil, status.Errorf(codes.InvalidArgument, "Provided Schema does not match Table %s. Field %s has changed type", tableID, f.Name)
+		}
+	}
+	for _, f := range dst {
+		if isRequired(f) && fieldIndex(src, f.Name) < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "Provided Schema does not match Table %s. Field %s is missing", tableID, f.Name)
+		}
+	}
+	res := make([][]value, len(rows))
+	for i, r := range rows {
+		res[i] = migrateRow(src, dst, r)
+		for j, f := range dst {
+			if isRequired(f) && res[i][j] == nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Required field %s cannot be null", f.Name)
+			}
+		}
+	}
+	return res, nil
+}
+
+// sameType reports whether two fields have the same type and, apart from
+// REQUIRED, mode.
+func sameType(a, b *bq.TableFieldSchema) bool {
+	if canonicalType(a.Type) != canonicalType(b.Type) || isRepeated(a) != isRepeated(b) || len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i := range a.Fields {
+		if !strings.EqualFold(a.Fields[i].Name, b.Fields[i].Name) || !sameType(a.Fields[i], b.Fields[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Jobs.
+
+// insertJob creates a job and runs it to completion. Errors running the job
+// are reported in its status; the returned error is for a job that can't be
+// created. data holds the uploaded data of a load job.
+func (s *backend) insertJob(project string, pj *bq.Job, data []byte) (*bq.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, err := s.newJob(project, pj, data)
+	if err != nil {
+		return nil, err
+	}
+	return cloneJob(j.proto), nil
+}
+
+func (s *backend) newJob(project string, pj *bq.Job, data []byte) (*job, error) {
+	cfg := pj.Configuration
+	switch {
+	case cfg == nil:
+		return nil, status.Errorf(codes.InvalidArgument, "Job configuration must be specified")
+	case cfg.Query != nil, cfg.Copy != nil:
+	case cfg.Load != nil:
+		if data == nil {
+			return nil, status.Errorf(codes.Unimplemented, "bqtest: loads from Cloud Storage are not supported; upload the data instead")
+		}
+	case cfg.Extract != nil:
+		return nil, status.Errorf(codes.Unimplemented, "bqtest: extract jobs are not supported")
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "Unknown job type")
+	}
+	j := &job{proto: cloneJob(pj), seq: s.nextID()}
+	ref := j.proto.JobReference
+	if ref == nil {
+		ref = &bq.JobReference{}
+		j.proto.JobReference = ref
+	}
+	if ref.ProjectId == "" {
+		ref.ProjectId = project
+	}
+	if ref.JobId == "" {
+		ref.JobId = fmt.Sprintf("bqtest_job_%d", j.seq)
+	}
+	if ref.Location == "" {
+		ref.Location = defaultLocation
+	}
+	if s.jobs[key(project, ref.JobId)] != nil {
+		return nil, status.Errorf(codes.AlreadyExists, "Already Exists: Job %s:%s.%s", project, ref.Location, ref.JobId)
+	}
+	j.proto.Kind = "bigquery#job"
+	j.proto.Id = fmt.Sprintf("%s:%s.%s", project, ref.Location, ref.JobId)
+	s.jobs[key(project, ref.JobId)] = j
+	s.runJob(j, data)
+	return j, nil
+}
+
+// runJob runs a job to completion.
+func (s *backend) runJob(j *job, data []byte) {
+	start := millis(s.now())
+	stats := &bq.JobStatistics{CreationTime: start, StartTime: start}
+	j.proto.Statistics = stats
+	cfg := j.proto.Configuration
+	switch {
+	case cfg.Query != nil:
+		j.err = s.runQueryJob(j)
+	case cfg.Load != nil:
+		j.err = s.runLoadJob(j, data)
+	case cfg.Copy != nil:
+		j.err = s.runCopyJob(j)
+	}
+	stats.EndTime = millis(s.now())
+	j.proto.Status = &bq.JobStatus{State: "DONE"}
+	if j.err != nil {
+		_, reason, msg := errorDetails(j.err)
+		ep := &bq.ErrorProto{Reason: reason, Message: msg}
+		j.proto.Status.ErrorResult = ep
+		j.proto.Status.Errors = []*bq.ErrorProto{ep}
+	}
+}
+
+func (s *backend) runQueryJob(j *job) error {
+	project := j.proto.JobReference.ProjectId
+	q := j.proto.Configuration.Query
+	stats := &bq.JobStatistics2{StatementType: "SELECT"}
+	j.proto.Statistics.Query = stats
+	if q.UseLegacySql == nil || *q.UseLegacySql {
+		return queryErrorf("bqtest: legacy SQL is not supported; set UseLegacySql to false")
+	}
+	p, err := compileQuery(s.queryEnv(project, q.DefaultDataset, q.QueryParameters), q.Query)
+	if err != nil {
+		return err
+	}
+	stats.Schema = &bq.TableSchema{Fields: p.fields}
+	if j.proto.Configuration.DryRun {
+		return nil
+	}
+	rows, err := p.run()
+	if err != nil {
+		return err
+	}
+	dst := q.DestinationTable
+	if dst == nil {
+		// Results without a destination go to a new table in a hidden
+		// dataset.
+		if _, err := s.dataset(project, anonymousDataset); err != nil {
+			if _, err := s.newDataset(project, &bq.Dataset{DatasetReference: &bq.DatasetReference{DatasetId: anonymousDataset}}); err != nil {
+				return err
+			}
+		}
+		dst = &bq.TableReference{ProjectId: project, DatasetId: anonymousDataset, TableId: "anon" + strconv.FormatInt(j.seq, 10)}
+		q.DestinationTable = dst
+	}
+	if dst.ProjectId == "" {
+		dst.ProjectId = project
+	}
+	create, write := q.CreateDisposition, q.WriteDisposition
+	if create == "" {
+		create = "CREATE_IF_NEEDED"
+	}
+	if write == "" {
+		write = "WRITE_EMPTY"
+	}
+	if err := s.writeRows(dst, p.fields, rows, create, write); err != nil {
+		return err
+	}
+	j.schema, j.rows = p.fields, rows
+	return nil
+}
+
+func (s *backend) runCopyJob(j *job) error {
+	cfg := j.proto.Configuration.Copy
+	srcs := cfg.SourceTables
+	if cfg.SourceTable != nil {
+		srcs = append(srcs, cfg.SourceTable)
+	}
+	if len(srcs) == 0 || cfg.DestinationTable == nil {
+		return status.Errorf(codes.InvalidArgument, "Copy jobs must have source and destination tables")
+	}
+	var (
+		fields []*bq.TableFieldSchema
+		rows   [][]value
+	)
+	for i, ref := range srcs {
+		t, err := s.table(ref.ProjectId, ref.DatasetId, ref.TableId)
+		if err != nil {
+			return err
+		}
+		if t.proto.View != nil {
+			return status.Errorf(codes.InvalidArgument, "Cannot copy view %s", t.proto.Id)
+		}
+		var tf []*bq.TableFieldSchema
+		if t.proto.Schema != nil {
+			tf = t.proto.Schema.Fields
+		}
+		if i == 0 {
+			fields = tf
+		} else if !sameType(&bq.TableFieldSchema{Fields: fields}, &bq.TableFieldSchema{Fields: tf}) {
+			return status.Errorf(codes.InvalidArgument, "Incompatible table schemas: %s", t.proto.Id)
+		}
+		rows = append(rows[:len(rows):len(rows)], t.rows...)
+	}
+	create, write := cfg.CreateDisposition, cfg.WriteDisposition
+	if create == "" {
+		create = "CREATE_IF_NEEDED"
+	}
+	if write == "" {
+		write = "WRITE_EMPTY"
+	}
+	return s.writeRows(cfg.DestinationTable, fields, rows, create, write)
+}
+
+// query runs a query of a jobs.query request. Unlike a query job's, its
+// errors are returned.
+func (s *backend) query(project string, req *bq.QueryRequest) (*bq.Job, [][]value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, err := s.newJob(project, &bq.Job{
+		JobReference: &bq.JobReference{ProjectId: project, Location: req.Location},
+		Configuration: &bq.JobConfiguration{
+			DryRun: req.DryRun,
+			Labels: req.Labels,
+			Query: &bq.JobConfigurationQuery{
+				Query:           req.Query,
+				UseLegacySql:    req.UseLegacySql,
+				QueryParameters: req.QueryParameters,
+				ParameterMode:   req.ParameterMode,
+				DefaultDataset:  req.DefaultDataset,
+			},
+		},
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if j.err != nil {
+		return nil, nil, j.err
+	}
+	res := cloneJob(j.proto)
+	res.Configuration.Query.DestinationTable = nil
+	if res.Statistics.Query.Schema == nil {
+		res.Statistics.Query.Schema = &bq.TableSchema{Fields: j.schema}
+	}
+	return res, j.rows, nil
+}
+
+func (s *backend) job(project, jobID string) (*job, error) {
+	j := s.jobs[key(project, jobID)]
+	if j == nil {
+		return nil, status.Errorf(codes.NotFound, "Not found: Job %s:%s", project, jobID)
+	}
+	return j, nil
+}
+
+func (s *backend) getJob(project, jobID string) (*bq.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, err := s.job(project, jobID)
+	if err != nil {
+		return nil, err
+	}
+	return cloneJob(j.proto), nil
+}
+
+// listJobs lists the jobs of a project, newest first.
+func (s *backend) listJobs(project string) []*bq.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var js []*job
+	for _, j := range s.jobs {
+		if j.proto.JobReference.ProjectId == project {
+			js = append(js, j)
+		}
+	}
+	sort.Slice(js, func(a, b int) bool { return js[a].seq > js[b].seq })
+	res := make([]*bq.Job, len(js))
+	for i, j := range js {
+		res[i] = cloneJob(j.proto)
+	}
+	return res
+}
+
+// queryResults returns a query job, and the schema and rows of its results.
+// If the job failed, it returns why.
+func (s *backend) queryResults(project, jobID string) (*bq.Job, []*bq.TableFieldSchema, [][]value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, err := s.job(project, jobID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if j.proto.Configuration.Query == nil {
+		return nil, nil, nil, status.Errorf(codes.InvalidArgument, "Job %s is not a query", j.proto.Id)
+	}
+	if j.err != nil {
+		return nil, nil, nil, j.err
+	}
+	return cloneJob(j.proto), j.schema, j.rows, nil
+}
+
+// Uploads.
+
+func (s *backend) startUpload(project string, pj *bq.Job) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := "upload" + strconv.FormatInt(s.nextID(), 10)
+	s.uploads[id] = &upload{project: project, job: cloneJob(pj)}
+	return id
+}
+
+// writeUpload appends data at offset to an upload. If finish is true, it
+// runs the upload's job and returns it.
+func (s *backend) writeUpload(id string, offset int64, data []byte, finish bool) (int64, *bq.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.uploads[id]
+	if u == nil {
+		return 0, nil, status.Errorf(codes.NotFound, "No upload session %s", id)
+	}
+	if offset > int64(len(u.data)) {
+		return 0, nil, status.Errorf(codes.InvalidArgument, "upload offset %d is past the %d bytes received", offset, len(u.data))
+	}
+	u.data = append(u.data[:offset], data...)
+	if !finish {
+		return int64(len(u.data)), nil, nil
+	}
+	delete(s.uploads, id)
+	j, err := s.newJob(u.project, u.job, u.data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return int64(len(u.data)), cloneJob(j.proto), nil
+}
+
+// errorDetails returns the HTTP status, reason and message of an error.
+func errorDetails(err error) (code int, reason, msg string) {
+	var qe *queryError
+	if errors.As(err, &qe) {
+		return 400, "invalidQuery", qe.msg
+	}
+	s := status.Convert(err)
+	switch s.Code() {
+	case codes.NotFound:
+		return 404, "notFound", s.Message()
+	case codes.AlreadyExists:
+		return 409, "duplicate", s.Message()
+	case codes.FailedPrecondition:
+		return 412, "conditionNotMet", s.Message()
+	case codes.Unimplemented:
+		return 501, "notImplemented", s.Message()
+	}
+	// The client retries 5xx errors, so the fake's other errors are 400s.
+	return 400, "invalid", s.Message()
+}
@@ -0,0 +1,388 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+const project = "test-project"
+
+func newClient(t *testing.T) *bigquery.Client {
+	t.Helper()
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	c, err := bigquery.NewClient(context.Background(), project, srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func mustCreateDataset(t *testing.T, c *bigquery.Client, id string) *bigquery.Dataset {
+	t.Helper()
+	ds := c.Dataset(id)
+	if err := ds.Create(context.Background(), nil); err != nil {
+		t.Fatalf("creating dataset %q: %v", id, err)
+	}
+	return ds
+}
+
+type person struct {
+	Name string
+	Age  bigquery.NullInt64
+	Born civil.Date
+	Tags []string
+}
+
+var people = []*person{
+	{Name: "alice", Age: bigquery.NullInt64{Int64: 30, Valid: true}, Born: civil.Date{Year: 1993, Month: 4, Day: 1}, Tags: []string{"a"}},
+	{Name: "bob", Age: bigquery.NullInt64{Int64: 25, Valid: true}, Born: civil.Date{Year: 1998, Month: 1, Day: 2}},
+	{Name: "carol", Born: civil.Date{Year: 1988, Month: 7, Day: 3}, Tags: []string{"b", "c"}},
+}
+
+func mustCreatePeople(t *testing.T, ds *bigquery.Dataset) *bigquery.Table {
+	t.Helper()
+	ctx := context.Background()
+	schema, err := bigquery.InferSchema(person{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := ds.Table("people")
+	if err := tbl.Create(ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Inserter().Put(ctx, people); err != nil {
+		t.Fatal(err)
+	}
+	return tbl
+}
+
+func readAll(t *testing.T, it *bigquery.RowIterator) [][]bigquery.Value {
+	t.Helper()
+	var rows [][]bigquery.Value
+	for {
+		var row []bigquery.Value
+		err := it.Next(&row)
+		if err == iterator.Done {
+			return rows
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+func errCode(err error) int {
+	var e *googleapi.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return 0
+}
+
+func TestDatasetsAndTables(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t)
+	ds := mustCreateDataset(t, c, "ds1")
+	mustCreateDataset(t, c, "ds2")
+	if err := c.Dataset("ds1").Create(ctx, nil); errCode(err) != http.StatusConflict {
+		t.Errorf("creating an existing dataset: got %v, want a 409", err)
+	}
+
+	var ids []string
+	it := c.Datasets(ctx)
+	for {
+		d, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, d.DatasetID)
+	}
+	if want := []string{"ds1", "ds2"}; !cmp.Equal(ids, want) {
+		t.Errorf("datasets: got %v, want %v", ids, want)
+	}
+
+	md, err := ds.Update(ctx, bigquery.DatasetMetadataToUpdate{Description: "people"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Description != "people" {
+		t.Errorf("got description %q, want %q", md.Description, "people")
+	}
+	if _, err := ds.Update(ctx, bigquery.DatasetMetadataToUpdate{Description: "x"}, "stale"); errCode(err) != http.StatusPreconditionFailed {
+		t.Errorf("update with a stale etag: got %v, want a 412", err)
+	}
+
+	tbl := mustCreatePeople(t, ds)
+	tmd, err := tbl.Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmd.NumRows != uint64(len(people)) {
+		t.Errorf("got %d rows, want %d", tmd.NumRows, len(people))
+	}
+	tables := ds.Tables(ctx)
+	if tb, err := tables.Next(); err != nil || tb.TableID != "people" {
+		t.Errorf("tables: got %v, %v", tb, err)
+	}
+
+	// Add a column, and relax a REQUIRED one.
+	schema := append(tmd.Schema, &bigquery.FieldSchema{Name: "City", Type: bigquery.StringFieldType})
+	schema[0].Required = false
+	if _, err := tbl.Update(ctx, bigquery.TableMetadataToUpdate{Schema: schema}, tmd.ETag); err != nil {
+		t.Fatal(err)
+	}
+	rows := readAll(t, tbl.Read(ctx))
+	if len(rows) != len(people) || len(rows[0]) != 5 || rows[0][4] != nil {
+		t.Errorf("after adding a column, got rows %v", rows)
+	}
+	// Removing a column isn't allowed.
+	if _, err := tbl.Update(ctx, bigquery.TableMetadataToUpdate{Schema: schema[1:]}, ""); errCode(err) != http.StatusBadRequest {
+		t.Errorf("removing a column: got %v, want a 400", err)
+	}
+
+	if err := ds.Delete(ctx); err == nil {
+		t.Error("deleting a dataset with tables: got nil, want an error")
+	}
+	if err := ds.DeleteWithContents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tbl.Metadata(ctx); errCode(err) != http.StatusNotFound {
+		t.Errorf("after deleting its dataset, got %v, want a 404", err)
+	}
+}
+
+func TestInsertAndRead(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t)
+	tbl := mustCreatePeople(t, mustCreateDataset(t, c, "ds"))
+
+	it := tbl.Read(ctx)
+	it.PageInfo().MaxSize = 2
+	var got []*person
+	for {
+		var p person
+		err := it.Next(&p)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, &p)
+	}
+	if diff := cmp.Diff(people, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// Rows with an insert ID that has been seen are dropped.
+	ins := tbl.Inserter()
+	saver := &bigquery.StructSaver{Struct: person{Name: "dave", Born: civil.Date{Year: 2000, Month: 1, Day: 1}}, InsertID: "dave"}
+	for i := 0; i < 2; i++ {
+		if err := ins.Put(ctx, saver); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(readAll(t, tbl.Read(ctx))); n != len(people)+1 {
+		t.Errorf("got %d rows, want %d", n, len(people)+1)
+	}
+
+	// A row with an unknown field is rejected.
+	err := ins.Put(ctx, &bigquery.ValuesSaver{
+		Schema: bigquery.Schema{{Name: "Nope", Type: bigquery.StringFieldType}},
+		Row:    []bigquery.Value{"x"},
+	})
+	var multi bigquery.PutMultiError
+	if !errors.As(err, &multi) || len(multi) != 1 {
+		t.Errorf("inserting an unknown field: got %v, want a PutMultiError", err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t)
+	mustCreatePeople(t, mustCreateDataset(t, c, "ds"))
+
+	q := c.Query("SELECT Name, ARRAY_LENGTH(Tags) AS n FROM ds.people WHERE Born < @d ORDER BY Name")
+	q.Parameters = []bigquery.QueryParameter{{Name: "d", Value: civil.Date{Year: 1995, Month: 1, Day: 1}}}
+	it, err := q.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]bigquery.Value{{"alice", int64(1)}, {"carol", int64(2)}}
+	if got := readAll(t, it); !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if it.TotalRows != 2 || len(it.Schema) != 2 || it.Schema[1].Name != "n" {
+		t.Errorf("got %d rows with schema %v", it.TotalRows, it.Schema)
+	}
+
+	// Run the query as a job, and write its results to a table.
+	q = c.Query("SELECT COUNT(*) AS n, MIN(Age) AS youngest FROM `test-project.ds.people`")
+	q.Dst = c.Dataset("ds").Table("stats")
+	job, err := q.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := status.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if it, err = job.Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want = [][]bigquery.Value{{int64(3), int64(25)}}
+	if got := readAll(t, it); !cmp.Equal(got, want) {
+		t.Errorf("job results: got %v, want %v", got, want)
+	}
+	if got := readAll(t, q.Dst.Read(ctx)); !cmp.Equal(got, want) {
+		t.Errorf("destination table: got %v, want %v", got, want)
+	}
+
+	// A view is a query.
+	view := c.Dataset("ds").Table("adults")
+	if err := view.Create(ctx, &bigquery.TableMetadata{ViewQuery: "SELECT Name FROM ds.people WHERE Age >= 30"}); err != nil {
+		t.Fatal(err)
+	}
+	if it, err = c.Query("SELECT * FROM ds.adults").Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readAll(t, it), [][]bigquery.Value{{"alice"}}; !cmp.Equal(got, want) {
+		t.Errorf("view: got %v, want %v", got, want)
+	}
+
+	// Errors in queries are bad requests.
+	_, err = c.Query("SELECT Nope FROM ds.people").Read(ctx)
+	if errCode(err) != http.StatusBadRequest || !strings.Contains(err.Error(), "Unrecognized name") {
+		t.Errorf("bad query: got %v", err)
+	}
+	job, err = c.Query("SELECT * FROM ds.missing").Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, err = job.Wait(ctx); err == nil {
+		err = status.Err()
+	}
+	if errCode(err) != http.StatusNotFound {
+		t.Errorf("query of a missing table: got %v, want a 404", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t)
+	ds := mustCreateDataset(t, c, "ds")
+
+	src := bigquery.NewReaderSource(strings.NewReader("name,score\nann,1.5\nben,2\n"))
+	src.SkipLeadingRows = 1
+	src.AutoDetect = true
+	job, err := ds.Table("scores").LoaderFrom(src).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := status.Err(); err != nil {
+		t.Fatal(err)
+	}
+	it, err := c.Query("SELECT SUM(score) FROM ds.scores").Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readAll(t, it), [][]bigquery.Value{{3.5}}; !cmp.Equal(got, want) {
+		t.Errorf("CSV: got %v, want %v", got, want)
+	}
+
+	src = bigquery.NewReaderSource(strings.NewReader(`{"name": "cat", "score": 4}` + "\n"))
+	src.SourceFormat = bigquery.JSON
+	l := ds.Table("scores").LoaderFrom(src)
+	l.WriteDisposition = bigquery.WriteTruncate
+	if job, err = l.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = job.Wait(ctx); err != nil || status.Err() != nil {
+		t.Fatalf("JSON load: got %v, %v", status, err)
+	}
+	rows := readAll(t, ds.Table("scores").Read(ctx))
+	if want := [][]bigquery.Value{{"cat", 4.0}}; !cmp.Equal(rows, want) {
+		t.Errorf("JSON: got %v, want %v", rows, want)
+	}
+
+	// Loads from Cloud Storage aren't supported.
+	job, err = ds.Table("scores").LoaderFrom(bigquery.NewGCSReference("gs://b/o")).Run(ctx)
+	if err == nil {
+		_, err = job.Wait(ctx)
+	}
+	if errCode(err) != http.StatusNotImplemented {
+		t.Errorf("GCS load: got %v, want a 501", err)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t)
+	for i := 0; i < 3; i++ {
+		if _, err := c.Query("SELECT 1").Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	it := c.Jobs(ctx)
+	n := 0
+	for {
+		j, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.LastStatus().State != bigquery.Done {
+			t.Errorf("job %s: got state %v, want Done", j.ID(), j.LastStatus().State)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("got %d jobs, want 3", n)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Error("listing jobs took too long")
+	}
+}
@@ -0,0 +1,1398 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/civil"
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// maxViewDepth is how deeply views may refer to other views.
+const maxViewDepth = 16
+
+// queryEnv is the environment that a query runs in.
+type queryEnv struct {
+	projectID      string
+	defaultDataset *bq.DatasetReference
+	params         []*bq.QueryParameter
+	now            time.Time
+	// table returns the schema and rows of a table or view. depth is the
+	// number of views being evaluated.
+	table func(ref *bq.TableReference, depth int) ([]*bq.TableFieldSchema, [][]value, error)
+	depth int
+}
+
+// A plan is a compiled query.
+type plan struct {
+	fields []*bq.TableFieldSchema // the schema of the results
+	run    func() ([][]value, error)
+}
+
+// runQuery parses, compiles and runs a query.
+func runQuery(env *queryEnv, sql string) ([]*bq.TableFieldSchema, [][]value, error) {
+	p, err := compileQuery(env, sql)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := p.run()
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.fields, rows, nil
+}
+
+func compileQuery(env *queryEnv, sql string) (*plan, error) {
+	q, err := parseQuery(sql)
+	if err != nil {
+		return nil, err
+	}
+	return env.compile(q)
+}
+
+// fieldType is the static type of an expression.
+type fieldType struct {
+	typ      string // empty for the type of a NULL literal
+	repeated bool
+	fields   []*bq.TableFieldSchema // the fields of a RECORD
+}
+
+func (t fieldType) String() string {
+	s := t.typ
+	if s == "" {
+		s = "NULL"
+	}
+	if t.repeated {
+		s = "ARRAY<" + s + ">"
+	}
+	return s
+}
+
+func fieldTypeOf(f *bq.TableFieldSchema) fieldType {
+	return fieldType{typ: canonicalType(f.Type), repeated: isRepeated(f), fields: f.Fields}
+}
+
+// fieldSchema returns the schema of a result column of type t.
+func fieldSchema(name string, t fieldType) *bq.TableFieldSchema {
+	f := &bq.TableFieldSchema{Name: name, Type: t.typ, Mode: "NULLABLE", Fields: t.fields}
+	if f.Type == "" {
+		// The type of a NULL column.
+		f.Type = typeInteger
+	}
+	if t.repeated {
+		f.Mode = "REPEATED"
+	}
+	return f
+}
+
+func isNumeric(typ string) bool {
+	switch typ {
+	case typeInteger, typeFloat, typeNumeric, typeBigNumeric:
+		return true
+	}
+	return false
+}
+
+// evalCtx is the input of an expression: a row, and in an aggregate query,
+// the rows of its group.
+type evalCtx struct {
+	row   []value
+	group [][]value
+}
+
+// A node is a compiled expression.
+type node struct {
+	t    fieldType
+	eval func(*evalCtx) (value, error)
+	// For literals, the literal, so that strings can be coerced to other
+	// types.
+	lit *literal
+}
+
+func constNode(t fieldType, v value) *node {
+	return &node{t: t, eval: func(*evalCtx) (value, error) { return v, nil }}
+}
+
+// compiler compiles the expressions of a query that reads from a source
+// with the given alias and columns.
+type compiler struct {
+	env    *queryEnv
+	alias  string
+	fields []*bq.TableFieldSchema
+	// Whether aggregate functions are allowed, and whether one is being
+	// compiled.
+	aggAllowed bool
+	inAgg      bool
+	sawAgg     bool
+	// The indexes of the columns referred to outside of aggregate
+	// functions, if not nil.
+	bare map[int]bool
+}
+
+func (env *queryEnv) compile(q *selectQuery) (*plan, error) {
+	c := &compiler{env: env}
+	source := func() ([][]value, error) { return [][]value{{}}, nil }
+	if f := q.from; f != nil {
+		c.alias = f.alias
+		if f.sub != nil {
+			sub, err := env.compile(f.sub)
+			if err != nil {
+				return nil, err
+			}
+			c.fields = sub.fields
+			source = sub.run
+		} else {
+			ref, err := env.tableRef(f.table)
+			if err != nil {
+				return nil, err
+			}
+			if c.alias == "" {
+				c.alias = ref.TableId
+			}
+			fields, rows, err := env.table(ref, env.depth)
+			if err != nil {
+				return nil, err
+			}
+			c.fields = fields
+			source = func() ([][]value, error) { return rows, nil }
+		}
+	}
+
+	var where *node
+	if q.where != nil {
+		var err error
+		if where, err = c.compileBool(q.where, "WHERE"); err != nil {
+			return nil, err
+		}
+	}
+
+	// The select list.
+	c.aggAllowed = true
+	selected := map[int]bool{}
+	c.bare = selected
+	var (
+		items  []*node
+		fields []*bq.TableFieldSchema
+		anon   int
+	)
+	for _, item := range q.items {
+		if item.star {
+			if len(c.fields) == 0 {
+				return nil, queryErrorf("SELECT * must have a FROM clause")
+			}
+			for i, f := range c.fields {
+				i := i
+				items = append(items, &node{t: fieldTypeOf(f), eval: func(ctx *evalCtx) (value, error) {
+					if ctx.row == nil {
+						return nil, nil
+					}
+					return ctx.row[i], nil
+				}})
+				fields = append(fields, fieldSchema(f.Name, fieldTypeOf(f)))
+			}
+			continue
+		}
+		n, err := c.compile(item.x)
+		if err != nil {
+			return nil, err
+		}
+		name := item.alias
+		if name == "" {
+			switch x := item.x.(type) {
+			case *columnRef:
+				name = x.path[len(x.path)-1]
+			case *fieldAccess:
+				name = x.name
+			default:
+				name = fmt.Sprintf("f%d_", anon)
+				anon++
+			}
+		}
+		items = append(items, n)
+		fields = append(fields, fieldSchema(name, n.t))
+	}
+
+	// GROUP BY may name the select list's columns by alias or ordinal.
+	c.aggAllowed = false
+	groupedColumns := map[int]bool{}
+	c.bare = groupedColumns
+	var groupBy []*node
+	for _, x := range q.groupBy {
+		if i, ok := c.outputColumn(x, fields); ok {
+			if item := q.items[i]; !item.star {
+				x = item.x
+			}
+		}
+		n, err := c.compile(x)
+		if err != nil {
+			return nil, queryErrorf("GROUP BY: %v", err)
+		}
+		groupBy = append(groupBy, n)
+	}
+
+	c.aggAllowed = true
+	c.bare = selected
+	var having *node
+	if q.having != nil {
+		var err error
+		if having, err = c.compileBool(q.having, "HAVING"); err != nil {
+			return nil, err
+		}
+	}
+
+	// ORDER BY may also name the select list's columns.
+	type orderKey struct {
+		n      *node
+		output int // the index of an output column, or -1
+		desc   bool
+	}
+	var orderBy []orderKey
+	for _, o := range q.orderBy {
+		key := orderKey{output: -1, desc: o.desc}
+		if i, ok := c.outputColumn(o.x, fields); ok {
+			key.output = i
+		} else {
+			n, err := c.compile(o.x)
+			if err != nil {
+				return nil, err
+			}
+			if n.t.repeated || n.t.typ == typeRecord {
+				return nil, queryErrorf("ORDER BY does not support expressions of type %s", n.t)
+			}
+			key.n = n
+		}
+		orderBy = append(orderBy, key)
+	}
+	c.bare = nil
+	grouped := c.sawAgg || len(groupBy) > 0 || having != nil
+	if grouped {
+		// This is looser than the service, which requires each expression to
+		// match a grouping expression, not just use its columns.
+		for i := range c.fields {
+			if selected[i] && !groupedColumns[i] {
+				return nil, queryErrorf("Query references column %s which is neither grouped nor aggregated", c.fields[i].Name)
+			}
+		}
+	}
+
+	limit, offset := int64(-1), int64(0)
+	for _, l := range []struct {
+		x   expr
+		dst *int64
+	}{{q.limit, &limit}, {q.offset, &offset}} {
+		if l.x == nil {
+			continue
+		}
+		n, err := c.compile(l.x)
+		if err != nil {
+			return nil, err
+		}
+		v, err := n.eval(&evalCtx{})
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := v.(int64); !ok || v < 0 {
+			return nil, queryErrorf("LIMIT and OFFSET must be non-negative integers")
+		} else {
+			*l.dst = v
+		}
+	}
+
+	run := func() ([][]value, error) {
+		rows, err := source()
+		if err != nil {
+			return nil, err
+		}
+		if where != nil {
+			var kept [][]value
+			for _, r := range rows {
+				ok, err := where.eval(&evalCtx{row: r})
+				if err != nil {
+					return nil, err
+				}
+				if ok == true {
+					kept = append(kept, r)
+				}
+			}
+			rows = kept
+		}
+
+		var ctxs []*evalCtx
+		if grouped {
+			groups := map[string]*evalCtx{}
+			if len(groupBy) == 0 {
+				// An aggregate over all of the rows has one row of results,
+				// even if there are no input rows.
+				ctx := &evalCtx{group: rows}
+				if len(rows) > 0 {
+					ctx.row = rows[0]
+				}
+				ctxs = append(ctxs, ctx)
+			}
+			for _, r := range rows {
+				if len(groupBy) == 0 {
+					break
+				}
+				key := make([]value, len(groupBy))
+				for i, n := range groupBy {
+					v, err := n.eval(&evalCtx{row: r})
+					if err != nil {
+						return nil, err
+					}
+					key[i] = v
+				}
+				k := valueKey(key)
+				ctx, ok := groups[k]
+				if !ok {
+					ctx = &evalCtx{row: r}
+					groups[k] = ctx
+					ctxs = append(ctxs, ctx)
+				}
+				ctx.group = append(ctx.group, r)
+			}
+			if having != nil {
+				var kept []*evalCtx
+				for _, ctx := range ctxs {
+					ok, err := having.eval(ctx)
+					if err != nil {
+						return nil, err
+					}
+					if ok == true {
+						kept = append(kept, ctx)
+					}
+				}
+				ctxs = kept
+			}
+		} else {
+			for _, r := range rows {
+				ctxs = append(ctxs, &evalCtx{row: r})
+			}
+		}
+
+		type result struct {
+			row  []value
+			keys []value
+		}
+		results := make([]result, len(ctxs))
+		for i, ctx := range ctxs {
+			res := result{row: make([]value, len(items))}
+			for j, n := range items {
+				v, err := n.eval(ctx)
+				if err != nil {
+					return nil, err
+				}
+				res.row[j] = v
+			}
+			for _, o := range orderBy {
+				var v value
+				if o.output >= 0 {
+					v = res.row[o.output]
+				} else if v, err = o.n.eval(ctx); err != nil {
+					return nil, err
+				}
+				res.keys = append(res.keys, v)
+			}
+			results[i] = res
+		}
+		if len(orderBy) > 0 {
+			sort.SliceStable(results, func(i, j int) bool {
+				for k, o := range orderBy {
+					c := compareNullsFirst(results[i].keys[k], results[j].keys[k])
+					if o.desc {
+						c = -c
+					}
+					if c != 0 {
+						return c < 0
+					}
+				}
+				return false
+			})
+		}
+		out := make([][]value, 0, len(results))
+		seen := map[string]bool{}
+		for _, res := range results {
+			if q.distinct {
+				k := valueKey(res.row)
+				if seen[k] {
+					continue
+				}
+				seen[k] = true
+			}
+			out = append(out, res.row)
+		}
+		if offset >= int64(len(out)) {
+			return nil, nil
+		}
+		out = out[offset:]
+		if limit >= 0 && limit < int64(len(out)) {
+			out = out[:limit]
+		}
+		return out, nil
+	}
+	return &plan{fields: fields, run: run}, nil
+}
+
+// outputColumn reports whether x names a column of the select list, by
+// ordinal or by alias, and returns its index.
+func (c *compiler) outputColumn(x expr, fields []*bq.TableFieldSchema) (int, bool) {
+	switch x := x.(type) {
+	case *literal:
+		if n, ok := x.v.(int64); ok && n >= 1 && int(n) <= len(fields) {
+			return int(n) - 1, true
+		}
+	case *columnRef:
+		if len(x.path) == 1 {
+			if i := fieldIndex(fields, x.path[0]); i >= 0 {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// tableRef resolves the parts of a table name in a query.
+func (env *queryEnv) tableRef(parts []string) (*bq.TableReference, error) {
+	switch len(parts) {
+	case 3:
+		return &bq.TableReference{ProjectId: parts[0], DatasetId: parts[1], TableId: parts[2]}, nil
+	case 2:
+		return &bq.TableReference{ProjectId: env.projectID, DatasetId: parts[0], TableId: parts[1]}, nil
+	case 1:
+		ds := env.defaultDataset
+		if ds == nil || ds.DatasetId == "" {
+			return nil, queryErrorf("Table name %q missing dataset while no default dataset is set in the request.", parts[0])
+		}
+		project := ds.ProjectId
+		if project == "" {
+			project = env.projectID
+		}
+		return &bq.TableReference{ProjectId: project, DatasetId: ds.DatasetId, TableId: parts[0]}, nil
+	}
+	return nil, queryErrorf("Invalid table name %q", strings.Join(parts, "."))
+}
+
+func (c *compiler) compileBool(x expr, clause string) (*node, error) {
+	n, err := c.compile(x)
+	if err != nil {
+		return nil, err
+	}
+	if n.t.typ != typeBoolean && n.t.typ != "" || n.t.repeated {
+		return nil, queryErrorf("%s clause should return type BOOL, but returns %s", clause, n.t)
+	}
+	return n, nil
+}
+
+func (c *compiler) compile(x expr) (*node, error) {
+	switch x := x.(type) {
+	case *literal:
+		n := constNode(fieldType{typ: x.typ}, x.v)
+		n.lit = x
+		return n, nil
+	case *columnRef:
+		return c.compileColumn(x)
+	case *paramRef:
+		return c.compileParam(x)
+	case *fieldAccess:
+		n, err := c.compile(x.x)
+		if err != nil {
+			return nil, err
+		}
+		return fieldOf(n, x.name)
+	case *unaryExpr:
+		n, err := c.compile(x.x)
+		if err != nil {
+			return nil, err
+		}
+		if x.op == "NOT" {
+			if err := checkType(n, "NOT", typeBoolean); err != nil {
+				return nil, err
+			}
+			return strict(fieldType{typ: typeBoolean}, []*node{n}, func(vs []value) (value, error) {
+				return !vs[0].(bool), nil
+			}), nil
+		}
+		if n.t.repeated || !isNumeric(n.t.typ) && n.t.typ != "" {
+			return nil, queryErrorf("No matching signature for operator - for argument type %s", n.t)
+		}
+		zero := constNode(fieldType{typ: typeInteger}, int64(0))
+		return arithmetic("-", zero, n)
+	case *binaryExpr:
+		return c.compileBinary(x)
+	case *isExpr:
+		n, err := c.compile(x.x)
+		if err != nil {
+			return nil, err
+		}
+		if x.v.typ != "" {
+			if err := checkType(n, "IS", typeBoolean); err != nil {
+				return nil, err
+			}
+		}
+		return &node{t: fieldType{typ: typeBoolean}, eval: func(ctx *evalCtx) (value, error) {
+			v, err := n.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return (v == x.v.v) != x.not, nil
+		}}, nil
+	case *inExpr:
+		return c.compileIn(x)
+	case *betweenExpr:
+		ge, err := c.compile(&binaryExpr{op: ">=", l: x.x, r: x.lo})
+		if err != nil {
+			return nil, err
+		}
+		le, err := c.compile(&binaryExpr{op: "<=", l: x.x, r: x.hi})
+		if err != nil {
+			return nil, err
+		}
+		and := logical("AND", ge, le)
+		if x.not {
+			return strict(fieldType{typ: typeBoolean}, []*node{and}, func(vs []value) (value, error) {
+				return !vs[0].(bool), nil
+			}), nil
+		}
+		return and, nil
+	case *callExpr:
+		return c.compileCall(x)
+	case *caseExpr:
+		return c.compileCase(x)
+	case *castExpr:
+		n, err := c.compile(x.x)
+		if err != nil {
+			return nil, err
+		}
+		if n.t.repeated || n.t.typ == typeRecord {
+			return nil, queryErrorf("Invalid cast from %s to %s", n.t, x.typ)
+		}
+		from := n.t.typ
+		return &node{t: fieldType{typ: x.typ}, eval: func(ctx *evalCtx) (value, error) {
+			v, err := n.eval(ctx)
+			if err != nil || v == nil {
+				return nil, err
+			}
+			res, err := castValue(v, from, x.typ)
+			if err != nil && x.safe {
+				return nil, nil
+			}
+			return res, err
+		}}, nil
+	case *arrayExpr:
+		var elems []*node
+		t := fieldType{}
+		for _, e := range x.elems {
+			n, err := c.compile(e)
+			if err != nil {
+				return nil, err
+			}
+			if n.t.repeated {
+				return nil, queryErrorf("Cannot construct array with element type %s", n.t)
+			}
+			if t.typ == "" || t.typ == typeInteger && isNumeric(n.t.typ) {
+				t = n.t
+			} else if n.t.typ != "" && n.t.typ != t.typ && !(isNumeric(t.typ) && isNumeric(n.t.typ)) {
+				return nil, queryErrorf("Array elements of types {%s, %s} do not have a common supertype", t, n.t)
+			}
+			elems = append(elems, n)
+		}
+		if t.typ == "" {
+			t.typ = typeInteger
+		}
+		typ := t.typ
+		t.repeated = true
+		return &node{t: t, eval: func(ctx *evalCtx) (value, error) {
+			vs := make([]value, len(elems))
+			for i, n := range elems {
+				v, err := n.eval(ctx)
+				if err != nil {
+					return nil, err
+				}
+				if v == nil {
+					return nil, queryErrorf("Array cannot have a null element")
+				}
+				if vs[i], err = convertNumeric(v, typ); err != nil {
+					return nil, err
+				}
+			}
+			return vs, nil
+		}}, nil
+	}
+	return nil, queryErrorf("unsupported expression %T", x)
+}
+
+func (c *compiler) compileColumn(x *columnRef) (*node, error) {
+	path := x.path
+	i := fieldIndex(c.fields, path[0])
+	if i < 0 && len(path) > 1 && c.alias != "" && strings.EqualFold(path[0], c.alias) {
+		path = path[1:]
+		i = fieldIndex(c.fields, path[0])
+	}
+	if i < 0 {
+		return nil, queryErrorf("Unrecognized name: %s", path[0])
+	}
+	if c.bare != nil && !c.inAgg {
+		c.bare[i] = true
+	}
+	n := &node{t: fieldTypeOf(c.fields[i]), eval: func(ctx *evalCtx) (value, error) {
+		if ctx.row == nil {
+			return nil, nil
+		}
+		return ctx.row[i], nil
+	}}
+	for _, name := range path[1:] {
+		var err error
+		if n, err = fieldOf(n, name); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// fieldOf returns a node for a field of the RECORD value of n.
+func fieldOf(n *node, name string) (*node, error) {
+	if n.t.typ != typeRecord || n.t.repeated {
+		return nil, queryErrorf("Cannot access field %s on a value with type %s", name, n.t)
+	}
+	i := fieldIndex(n.t.fields, name)
+	if i < 0 {
+		return nil, queryErrorf("Field name %s does not exist in STRUCT", name)
+	}
+	return &node{t: fieldTypeOf(n.t.fields[i]), eval: func(ctx *evalCtx) (value, error) {
+		v, err := n.eval(ctx)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		return v.([]value)[i], nil
+	}}, nil
+}
+
+func (c *compiler) compileParam(x *paramRef) (*node, error) {
+	var p *bq.QueryParameter
+	if x.name != "" {
+		for _, qp := range c.env.params {
+			if strings.EqualFold(qp.Name, x.name) {
+				p = qp
+				break
+			}
+		}
+		if p == nil {
+			return nil, queryErrorf("Query parameter '%s' not found", x.name)
+		}
+	} else {
+		if x.index >= len(c.env.params) {
+			return nil, queryErrorf("Query parameter number %d is not defined", x.index+1)
+		}
+		p = c.env.params[x.index]
+	}
+	if p.ParameterType == nil {
+		return nil, queryErrorf("Query parameter %s has no type", x.name)
+	}
+	t, v, err := parseParam(p.ParameterType, p.ParameterValue)
+	if err != nil {
+		return nil, queryErrorf("Invalid value for query parameter %s: %v", p.Name, err)
+	}
+	return constNode(t, v), nil
+}
+
+// parseParam returns the type and value of a query parameter.
+func parseParam(pt *bq.QueryParameterType, pv *bq.QueryParameterValue) (fieldType, value, error) {
+	typ := canonicalType(pt.Type)
+	switch typ {
+	case "ARRAY":
+		if pt.ArrayType == nil || canonicalType(pt.ArrayType.Type) == "ARRAY" {
+			return fieldType{}, nil, fmt.Errorf("bad array type")
+		}
+		t, _, err := parseParam(pt.ArrayType, nil)
+		if err != nil {
+			return fieldType{}, nil, err
+		}
+		t.repeated = true
+		if pv == nil {
+			return t, nil, nil
+		}
+		vs := []value{}
+		for _, e := range pv.ArrayValues {
+			_, v, err := parseParam(pt.ArrayType, e)
+			if err != nil {
+				return fieldType{}, nil, err
+			}
+			vs = append(vs, v)
+		}
+		return t, vs, nil
+	case typeRecord:
+		t := fieldType{typ: typeRecord}
+		var vs []value
+		if pv != nil {
+			vs = []value{}
+		}
+		for _, st := range pt.StructTypes {
+			ft, _, err := parseParam(st.Type, nil)
+			if err != nil {
+				return fieldType{}, nil, err
+			}
+			t.fields = append(t.fields, fieldSchema(st.Name, ft))
+			if pv != nil {
+				fv := pv.StructValues[st.Name]
+				_, v, err := parseParam(st.Type, &fv)
+				if err != nil {
+					return fieldType{}, nil, err
+				}
+				vs = append(vs, v)
+			}
+		}
+		if vs == nil {
+			return t, nil, nil
+		}
+		return t, vs, nil
+	}
+	t := fieldType{typ: typ}
+	if pv == nil || pv.Value == "" && typ != typeString && typ != typeBytes {
+		return t, nil, nil
+	}
+	v, err := parseString(typ, pv.Value)
+	return t, v, err
+}
+
+func checkType(n *node, op, typ string) error {
+	if n.t.repeated || n.t.typ != typ && n.t.typ != "" {
+		return queryErrorf("No matching signature for %s for argument type %s", op, n.t)
+	}
+	return nil
+}
+
+// strict returns a node that calls f with the values of args, unless one
+// of them is NULL, in which case its value is NULL.
+func strict(t fieldType, args []*node, f func([]value) (value, error)) *node {
+	return &node{t: t, eval: func(ctx *evalCtx) (value, error) {
+		vs := make([]value, len(args))
+		for i, a := range args {
+			v, err := a.eval(ctx)
+			if err != nil || v == nil {
+				return nil, err
+			}
+			vs[i] = v
+		}
+		return f(vs)
+	}}
+}
+
+// coerce converts a string literal to typ, as GoogleSQL does when a
+// literal is compared with a value of another type.
+func coerce(n *node, typ string) (*node, error) {
+	if n.lit == nil || n.t.typ != typeString || typ == typeString {
+		return n, nil
+	}
+	switch typ {
+	case typeDate, typeTime, typeDateTime, typeTimestamp, typeNumeric, typeBigNumeric:
+		v, err := parseString(typ, n.lit.v.(string))
+		if err != nil {
+			return nil, queryErrorf("Could not cast literal %q to type %s", n.lit.v, typ)
+		}
+		return constNode(fieldType{typ: typ}, v), nil
+	}
+	return n, nil
+}
+
+// comparable coerces l and r so that they can be compared, or returns an
+// error.
+func comparable(op string, l, r *node) (*node, *node, error) {
+	l, err := coerce(l, r.t.typ)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r, err = coerce(r, l.t.typ); err != nil {
+		return nil, nil, err
+	}
+	ok := !l.t.repeated && !r.t.repeated && l.t.typ != typeRecord && r.t.typ != typeRecord &&
+		(l.t.typ == r.t.typ || l.t.typ == "" || r.t.typ == "" || isNumeric(l.t.typ) && isNumeric(r.t.typ))
+	if !ok {
+		return nil, nil, queryErrorf("No matching signature for operator %s for argument types: %s, %s", op, l.t, r.t)
+	}
+	return l, r, nil
+}
+
+func (c *compiler) compileBinary(x *binaryExpr) (*node, error) {
+	l, err := c.compile(x.l)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.compile(x.r)
+	if err != nil {
+		return nil, err
+	}
+	boolean := fieldType{typ: typeBoolean}
+	switch x.op {
+	case "AND", "OR":
+		for _, n := range []*node{l, r} {
+			if err := checkType(n, "operator "+x.op, typeBoolean); err != nil {
+				return nil, err
+			}
+		}
+		return logical(x.op, l, r), nil
+	case "+", "-", "*", "/":
+		return arithmetic(x.op, l, r)
+	case "||":
+		for _, n := range []*node{l, r} {
+			if err := checkType(n, "operator ||", typeString); err != nil {
+				return nil, err
+			}
+		}
+		return strict(fieldType{typ: typeString}, []*node{l, r}, func(vs []value) (value, error) {
+			return vs[0].(string) + vs[1].(string), nil
+		}), nil
+	case "LIKE":
+		for _, n := range []*node{l, r} {
+			if err := checkType(n, "operator LIKE", typeString); err != nil {
+				return nil, err
+			}
+		}
+		cache := map[string]*regexp.Regexp{}
+		return strict(boolean, []*node{l, r}, func(vs []value) (value, error) {
+			pattern := vs[1].(string)
+			re, ok := cache[pattern]
+			if !ok {
+				re = likeRegexp(pattern)
+				cache[pattern] = re
+			}
+			return re.MatchString(vs[0].(string)), nil
+		}), nil
+	}
+	// A comparison.
+	l, r, err = comparable(x.op, l, r)
+	if err != nil {
+		return nil, err
+	}
+	op := x.op
+	return strict(boolean, []*node{l, r}, func(vs []value) (value, error) {
+		c := compareValues(vs[0], vs[1])
+		switch op {
+		case "=":
+			return c == 0, nil
+		case "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default: // ">="
+			return c >= 0, nil
+		}
+	}), nil
+}
+
+// logical returns a node for AND or OR, with SQL's three-valued logic.
+func logical(op string, l, r *node) *node {
+	// The value that decides the result whatever the other value is.
+	decisive := op == "OR"
+	return &node{t: fieldType{typ: typeBoolean}, eval: func(ctx *evalCtx) (value, error) {
+		lv, err := l.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if lv == decisive {
+			return decisive, nil
+		}
+		rv, err := r.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if rv == decisive {
+			return decisive, nil
+		}
+		if lv == nil || rv == nil {
+			return nil, nil
+		}
+		return !decisive, nil
+	}}
+}
+
+// arithmeticType returns the type of the result of an arithmetic operator.
+func arithmeticType(op string, l, r string) string {
+	switch {
+	case l == typeFloat || r == typeFloat:
+		return typeFloat
+	case l == typeBigNumeric || r == typeBigNumeric:
+		return typeBigNumeric
+	case l == typeNumeric || r == typeNumeric:
+		return typeNumeric
+	case op == "/":
+		return typeFloat
+	}
+	return typeInteger
+}
+
+func arithmetic(op string, l, r *node) (*node, error) {
+	for _, n := range []*node{l, r} {
+		if n.t.repeated || !isNumeric(n.t.typ) && n.t.typ != "" {
+			return nil, queryErrorf("No matching signature for operator %s for argument types: %s, %s", op, l.t, r.t)
+		}
+	}
+	typ := arithmeticType(op, l.t.typ, r.t.typ)
+	return strict(fieldType{typ: typ}, []*node{l, r}, func(vs []value) (value, error) {
+		switch typ {
+		case typeInteger:
+			a, b := vs[0].(int64), vs[1].(int64)
+			switch op {
+			case "+":
+				return a + b, nil
+			case "-":
+				return a - b, nil
+			default:
+				return a * b, nil
+			}
+		case typeFloat:
+			a, b := toFloat(vs[0]), toFloat(vs[1])
+			switch op {
+			case "+":
+				return a + b, nil
+			case "-":
+				return a - b, nil
+			case "*":
+				return a * b, nil
+			default:
+				if b == 0 {
+					return nil, queryErrorf("division by zero: %v / %v", a, b)
+				}
+				return a / b, nil
+			}
+		default:
+			a, b := toRat(vs[0]), toRat(vs[1])
+			res := new(big.Rat)
+			switch op {
+			case "+":
+				res.Add(a, b)
+			case "-":
+				res.Sub(a, b)
+			case "*":
+				res.Mul(a, b)
+			default:
+				if b.Sign() == 0 {
+					return nil, queryErrorf("division by zero: %s / %s", a.RatString(), b.RatString())
+				}
+				res.Quo(a, b)
+			}
+			return roundRat(res, numericScale(typ)), nil
+		}
+	}), nil
+}
+
+func toFloat(v value) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case *big.Rat:
+		f, _ := v.Float64()
+		return f
+	}
+	return v.(float64)
+}
+
+func toRat(v value) *big.Rat {
+	switch v := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(v)
+	case float64:
+		r, _ := new(big.Rat).SetString(fmt.Sprint(v))
+		return r
+	}
+	return v.(*big.Rat)
+}
+
+// convertNumeric converts a numeric value to the numeric type typ. Other
+// values are returned unchanged.
+func convertNumeric(v value, typ string) (value, error) {
+	switch v.(type) {
+	case int64, float64, *big.Rat:
+	default:
+		return v, nil
+	}
+	switch typ {
+	case typeFloat:
+		return toFloat(v), nil
+	case typeNumeric, typeBigNumeric:
+		if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			return nil, queryErrorf("Illegal conversion of non-finite floating point number to %s", typ)
+		}
+		return roundRat(toRat(v), numericScale(typ)), nil
+	case typeInteger:
+		switch v := v.(type) {
+		case float64:
+			if math.IsNaN(v) || math.Abs(v) >= 1<<63 {
+				return nil, queryErrorf("Illegal conversion of %v to INT64", v)
+			}
+			return int64(math.Round(v)), nil
+		case *big.Rat:
+			f, _ := v.Float64()
+			return convertNumeric(f, typ)
+		}
+	}
+	return v, nil
+}
+
+func likeRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func (c *compiler) compileIn(x *inExpr) (*node, error) {
+	n, err := c.compile(x.x)
+	if err != nil {
+		return nil, err
+	}
+	var list []*node
+	if x.unnest != nil {
+		a, err := c.compile(x.unnest)
+		if err != nil {
+			return nil, err
+		}
+		if !a.t.repeated {
+			return nil, queryErrorf("Values referenced in UNNEST must be arrays, but found %s", a.t)
+		}
+		elem := &node{t: fieldType{typ: a.t.typ, fields: a.t.fields}}
+		if _, _, err := comparable("IN", n, elem); err != nil {
+			return nil, err
+		}
+		list = []*node{a}
+	} else {
+		for _, e := range x.list {
+			l, err := c.compile(e)
+			if err != nil {
+				return nil, err
+			}
+			if _, l, err = comparable("IN", n, l); err != nil {
+				return nil, err
+			}
+			list = append(list, l)
+		}
+	}
+	unnest := x.unnest != nil
+	return &node{t: fieldType{typ: typeBoolean}, eval: func(ctx *evalCtx) (value, error) {
+		v, err := n.eval(ctx)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		var candidates []value
+		for _, l := range list {
+			lv, err := l.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if unnest {
+				candidates, _ = lv.([]value)
+			} else {
+				candidates = append(candidates, lv)
+			}
+		}
+		sawNull := false
+		for _, cv := range candidates {
+			if cv == nil {
+				sawNull = true
+			} else if compareValues(v, cv) == 0 {
+				return !x.not, nil
+			}
+		}
+		if sawNull {
+			return nil, nil
+		}
+		return x.not, nil
+	}}, nil
+}
+
+func (c *compiler) compileCase(x *caseExpr) (*node, error) {
+	var operand *node
+	if x.operand != nil {
+		var err error
+		if operand, err = c.compile(x.operand); err != nil {
+			return nil, err
+		}
+	}
+	var conds, results []*node
+	for _, w := range x.whens {
+		cond, err := c.compile(w.cond)
+		if err != nil {
+			return nil, err
+		}
+		if operand != nil {
+			if _, cond, err = comparable("CASE", operand, cond); err != nil {
+				return nil, err
+			}
+		} else if err := checkType(cond, "CASE WHEN", typeBoolean); err != nil {
+			return nil, err
+		}
+		res, err := c.compile(w.result)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+		results = append(results, res)
+	}
+	if x.els != nil {
+		els, err := c.compile(x.els)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, els)
+	}
+	t, err := commonType("CASE", results)
+	if err != nil {
+		return nil, err
+	}
+	return &node{t: t, eval: func(ctx *evalCtx) (value, error) {
+		var ov value
+		if operand != nil {
+			var err error
+			if ov, err = operand.eval(ctx); err != nil {
+				return nil, err
+			}
+		}
+		for i, cond := range conds {
+			cv, err := cond.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			match := cv == true
+			if operand != nil {
+				match = ov != nil && cv != nil && compareValues(ov, cv) == 0
+			}
+			if match {
+				return evalAs(results[i], ctx, t.typ)
+			}
+		}
+		if len(results) > len(conds) {
+			return evalAs(results[len(conds)], ctx, t.typ)
+		}
+		return nil, nil
+	}}, nil
+}
+
+// commonType returns the type that the values of nodes can all be converted
+// to.
+func commonType(what string, nodes []*node) (fieldType, error) {
+	var t fieldType
+	for _, n := range nodes {
+		switch {
+		case n.t.typ == "":
+		case t.typ == "":
+			t = n.t
+		case n.t.repeated != t.repeated:
+			return fieldType{}, queryErrorf("No matching signature for %s for argument types %s and %s", what, t, n.t)
+		case isNumeric(t.typ) && isNumeric(n.t.typ):
+			if n.t.typ == typeFloat || t.typ == typeInteger {
+				t = n.t
+			}
+		case n.t.typ != t.typ:
+			return fieldType{}, queryErrorf("No matching signature for %s for argument types %s and %s", what, t, n.t)
+		}
+	}
+	return t, nil
+}
+
+// evalAs evaluates n, and converts a numeric result to typ.
+func evalAs(n *node, ctx *evalCtx, typ string) (value, error) {
+	v, err := n.eval(ctx)
+	if err != nil || v == nil {
+		return v, err
+	}
+	return convertNumeric(v, typ)
+}
+
+// castValue converts v, of type from, to type to.
+func castValue(v value, from, to string) (value, error) {
+	if from == to {
+		return v, nil
+	}
+	bad := queryErrorf("Invalid cast from %s to %s", from, to)
+	switch to {
+	case typeString:
+		switch v := v.(type) {
+		case []byte:
+			if !utf8.Valid(v) {
+				return nil, queryErrorf("Invalid UTF-8 in BYTES value cast to STRING")
+			}
+			return string(v), nil
+		case time.Time:
+			return v.Format("2006-01-02 15:04:05.999999-07"), nil
+		case civil.DateTime:
+			return v.Date.String() + " " + v.Time.String(), nil
+		}
+		return formatScalar(from, v), nil
+	case typeBytes:
+		if s, ok := v.(string); ok {
+			return []byte(s), nil
+		}
+		return nil, bad
+	}
+	if s, ok := v.(string); ok {
+		res, err := parseString(to, s)
+		if err != nil {
+			return nil, queryErrorf("Bad %s value: %s", to, s)
+		}
+		return res, nil
+	}
+	switch {
+	case isNumeric(from) && isNumeric(to):
+		return convertNumeric(v, to)
+	case from == typeBoolean && to == typeInteger:
+		if v.(bool) {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case from == typeInteger && to == typeBoolean:
+		return v.(int64) != 0, nil
+	}
+	switch v := v.(type) {
+	case time.Time:
+		switch to {
+		case typeDate:
+			return civil.DateOf(v), nil
+		case typeDateTime:
+			return civil.DateTimeOf(v), nil
+		case typeTime:
+			return civil.TimeOf(v), nil
+		}
+	case civil.Date:
+		switch to {
+		case typeDateTime:
+			return civil.DateTime{Date: v}, nil
+		case typeTimestamp:
+			return v.In(time.UTC), nil
+		}
+	case civil.DateTime:
+		switch to {
+		case typeDate:
+			return v.Date, nil
+		case typeTime:
+			return v.Time, nil
+		case typeTimestamp:
+			return v.In(time.UTC), nil
+		}
+	}
+	return nil, bad
+}
+
+// compareNullsFirst compares values that may be NULL, which sort first.
+func compareNullsFirst(a, b value) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return compareValues(a, b)
+}
+
+// compareValues compares two non-NULL values of comparable types.
+func compareValues(a, b value) int {
+	switch a := a.(type) {
+	case int64:
+		if b, ok := b.(int64); ok {
+			return compareInts(a, b)
+		}
+		return compareNumbers(a, b)
+	case float64, *big.Rat:
+		return compareNumbers(a, b)
+	case string:
+		return strings.Compare(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	case bool:
+		b := b.(bool)
+		switch {
+		case a == b:
+			return 0
+		case !a:
+			return -1
+		}
+		return 1
+	case time.Time:
+		return compareInts(a.UnixMicro(), b.(time.Time).UnixMicro())
+	case civil.Date:
+		return compareDates(a, b.(civil.Date))
+	case civil.Time:
+		return compareInts(nanosOfDay(a), nanosOfDay(b.(civil.Time)))
+	case civil.DateTime:
+		b := b.(civil.DateTime)
+		if c := compareDates(a.Date, b.Date); c != 0 {
+			return c
+		}
+		return compareInts(nanosOfDay(a.Time), nanosOfDay(b.Time))
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareNumbers(a, b value) int {
+	_, af := a.(float64)
+	_, bf := b.(float64)
+	if af || bf {
+		x, y := toFloat(a), toFloat(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return toRat(a).Cmp(toRat(b))
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareDates(a, b civil.Date) int {
+	return compareInts(int64(a.Year)*10000+int64(a.Month)*100+int64(a.Day),
+		int64(b.Year)*10000+int64(b.Month)*100+int64(b.Day))
+}
+
+func nanosOfDay(t civil.Time) int64 {
+	return ((int64(t.Hour)*60+int64(t.Minute))*60+int64(t.Second))*1e9 + int64(t.Nanosecond)
+}
@@ -0,0 +1,218 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/google/go-cmp/cmp"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testEnv returns a query environment with one table, d.people.
+func testEnv() *queryEnv {
+	fields := []*bq.TableFieldSchema{
+		{Name: "name", Type: "STRING"},
+		{Name: "age", Type: "INTEGER"},
+		{Name: "city", Type: "STRING"},
+		{Name: "born", Type: "DATE"},
+		{Name: "tags", Type: "STRING", Mode: "REPEATED"},
+	}
+	rows := [][]value{
+		{"alice", int64(30), "paris", civil.Date{Year: 1993, Month: 4, Day: 1}, []value{"a", "b"}},
+		{"bob", int64(25), "london", civil.Date{Year: 1998, Month: 1, Day: 2}, []value{}},
+		{"carol", int64(35), "paris", nil, []value{"c"}},
+		{"dave", nil, nil, nil, []value{}},
+	}
+	return &queryEnv{
+		projectID:      "p",
+		defaultDataset: &bq.DatasetReference{ProjectId: "p", DatasetId: "d"},
+		now:            time.Date(2023, 5, 6, 7, 8, 9, 0, time.UTC),
+		table: func(ref *bq.TableReference, depth int) ([]*bq.TableFieldSchema, [][]value, error) {
+			if ref.ProjectId != "p" || ref.DatasetId != "d" || ref.TableId != "people" {
+				return nil, nil, status.Errorf(codes.NotFound, "Not found: Table %s:%s.%s", ref.ProjectId, ref.DatasetId, ref.TableId)
+			}
+			return fields, rows, nil
+		},
+	}
+}
+
+func TestRunQuery(t *testing.T) {
+	for _, test := range []struct {
+		sql  string
+		want [][]value
+	}{
+		{"SELECT 1 + 2, 'a' || 'b', 7 / 2", [][]value{{int64(3), "ab", 3.5}}},
+		{"SELECT name FROM people WHERE age > 28 ORDER BY name", [][]value{{"alice"}, {"carol"}}},
+		{"SELECT name FROM `p.d.people` WHERE age IS NULL", [][]value{{"dave"}}},
+		{"SELECT name FROM people ORDER BY age DESC LIMIT 2", [][]value{{"carol"}, {"alice"}}},
+		{"SELECT name FROM people ORDER BY age LIMIT 2 OFFSET 1", [][]value{{"bob"}, {"alice"}}},
+		{"SELECT city, COUNT(*) AS n FROM people GROUP BY city ORDER BY n DESC, city",
+			[][]value{{"paris", int64(2)}, {nil, int64(1)}, {"london", int64(1)}}},
+		{"SELECT city, SUM(age) FROM people WHERE city IS NOT NULL GROUP BY 1 HAVING SUM(age) > 30",
+			[][]value{{"paris", int64(65)}}},
+		{"SELECT COUNT(DISTINCT city), MAX(age), AVG(age) FROM people", [][]value{{int64(2), int64(35), 30.0}}},
+		{"SELECT DISTINCT city FROM people WHERE city IS NOT NULL ORDER BY city", [][]value{{"london"}, {"paris"}}},
+		{"SELECT UPPER(name), ARRAY_LENGTH(tags) FROM people WHERE 'a' IN UNNEST(tags)", [][]value{{"ALICE", int64(2)}}},
+		{"SELECT name FROM people WHERE name LIKE '%o%' ORDER BY 1", [][]value{{"bob"}, {"carol"}}},
+		{"SELECT name FROM people WHERE age BETWEEN 25 AND 30 ORDER BY name", [][]value{{"alice"}, {"bob"}}},
+		{"SELECT CASE WHEN age < 30 THEN 'young' ELSE 'old' END FROM people WHERE name = 'bob'", [][]value{{"young"}}},
+		{"SELECT CAST(age AS STRING), SAFE_CAST('x' AS INT64) FROM people WHERE name = 'bob'", [][]value{{"25", nil}}},
+		{"SELECT born FROM people WHERE born > DATE '1995-01-01'", [][]value{{civil.Date{Year: 1998, Month: 1, Day: 2}}}},
+		{"SELECT COALESCE(city, 'none') FROM people WHERE name = 'dave'", [][]value{{"none"}}},
+		{"SELECT n FROM (SELECT age * 2 AS n FROM people) WHERE n > 60", [][]value{{int64(70)}}},
+		{"SELECT CURRENT_DATE()", [][]value{{civil.Date{Year: 2023, Month: 5, Day: 6}}}},
+		{"SELECT STRING_AGG(name, ',' ORDER BY name) FROM people", nil},
+	} {
+		fields, got, err := runQuery(testEnv(), test.sql)
+		if test.want == nil {
+			// Not supported; make sure the error is a query error.
+			var qe *queryError
+			if !errors.As(err, &qe) {
+				t.Errorf("%s: got error %v, want a query error", test.sql, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.sql, err)
+			continue
+		}
+		if len(fields) != len(test.want[0]) {
+			t.Errorf("%s: got %d columns, want %d", test.sql, len(fields), len(test.want[0]))
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%s: mismatch (-want +got):\n%s", test.sql, diff)
+		}
+	}
+}
+
+func TestRunQueryParams(t *testing.T) {
+	env := testEnv()
+	env.params = []*bq.QueryParameter{
+		{
+			Name:           "min",
+			ParameterType:  &bq.QueryParameterType{Type: "INT64"},
+			ParameterValue: &bq.QueryParameterValue{Value: "30"},
+		},
+		{
+			Name:          "cities",
+			ParameterType: &bq.QueryParameterType{Type: "ARRAY", ArrayType: &bq.QueryParameterType{Type: "STRING"}},
+			ParameterValue: &bq.QueryParameterValue{ArrayValues: []*bq.QueryParameterValue{
+				{Value: "paris"}, {Value: "rome"},
+			}},
+		},
+	}
+	_, got, err := runQuery(env, "SELECT name FROM people WHERE age >= @min AND city IN UNNEST(@cities) ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]value{{"alice"}, {"carol"}}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRunQueryErrors(t *testing.T) {
+	for _, test := range []struct {
+		sql, want string
+	}{
+		{"SELECT", "Syntax error"},
+		{"SELECT nope FROM people", "Unrecognized name: nope"},
+		{"SELECT * FROM missing", "Not found"},
+		{"SELECT name, COUNT(*) FROM people", "neither grouped nor aggregated"},
+		{"SELECT 'a' + 1", "No matching signature"},
+		{"SELECT @x", "Query parameter 'x' not found"},
+		{"SELECT a.name FROM people a JOIN people b ON TRUE", "JOIN"},
+	} {
+		_, _, err := runQuery(testEnv(), test.sql)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want one containing %q", test.sql, err, test.want)
+		}
+	}
+}
+
+func TestValueRoundTrip(t *testing.T) {
+	fields := []*bq.TableFieldSchema{
+		{Name: "s", Type: "STRING"},
+		{Name: "b", Type: "BYTES"},
+		{Name: "i", Type: "INTEGER"},
+		{Name: "f", Type: "FLOAT"},
+		{Name: "ts", Type: "TIMESTAMP"},
+		{Name: "dt", Type: "DATETIME"},
+		{Name: "n", Type: "NUMERIC"},
+		{Name: "r", Type: "RECORD", Mode: "REPEATED", Fields: []*bq.TableFieldSchema{{Name: "x", Type: "INTEGER"}}},
+	}
+	in := map[string]interface{}{
+		"s":  "hello",
+		"b":  "AQI=",
+		"i":  "42",
+		"f":  "1.5",
+		"ts": "2023-05-06T07:08:09.123456Z",
+		"dt": "2023-05-06 07:08:09",
+		"n":  "12.5",
+		"r":  []interface{}{map[string]interface{}{"x": "1"}, map[string]interface{}{"x": "2"}},
+	}
+	row, err := parseRow(fields, in, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := formatRow(fields, row)
+	want := []interface{}{
+		"hello", "AQI=", "42", "1.5", "1683356889.123456", "2023-05-06T07:08:09", "12.5",
+		[]interface{}{
+			map[string]interface{}{"v": map[string]interface{}{"f": []interface{}{map[string]interface{}{"v": "1"}}}},
+			map[string]interface{}{"v": map[string]interface{}{"f": []interface{}{map[string]interface{}{"v": "2"}}}},
+		},
+	}
+	for i, c := range out.F {
+		if got := normalize(c.V); !cmp.Equal(got, want[i]) {
+			t.Errorf("%s: got %#v, want %#v", fields[i].Name, got, want[i])
+		}
+	}
+}
+
+// normalize converts the cells of a formatted row into the types that
+// encoding/json decodes them to.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *bq.TableRow:
+		var cells []interface{}
+		for _, c := range v.F {
+			cells = append(cells, map[string]interface{}{"v": normalize(c.V)})
+		}
+		return map[string]interface{}{"f": cells}
+	case []interface{}:
+		var res []interface{}
+		for _, e := range v {
+			res = append(res, normalize(e))
+		}
+		return res
+	case map[string]interface{}:
+		res := map[string]interface{}{}
+		for k, e := range v {
+			res[k] = normalize(e)
+		}
+		return res
+	case string, nil:
+		return v
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,558 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"math"
+	"math/big"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/civil"
+)
+
+// aggregates are the names of the aggregate functions.
+var aggregates = map[string]bool{
+	"ANY_VALUE":   true,
+	"ARRAY_AGG":   true,
+	"AVG":         true,
+	"COUNT":       true,
+	"COUNTIF":     true,
+	"LOGICAL_AND": true,
+	"LOGICAL_OR":  true,
+	"MAX":         true,
+	"MIN":         true,
+	"STRING_AGG":  true,
+	"SUM":         true,
+}
+
+func (c *compiler) compileCall(x *callExpr) (*node, error) {
+	if aggregates[x.name] {
+		return c.compileAggregate(x)
+	}
+	if x.star || x.distinct {
+		return nil, queryErrorf("%s does not support * or DISTINCT", x.name)
+	}
+	args := make([]*node, len(x.args))
+	for i, a := range x.args {
+		n, err := c.compile(a)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = n
+	}
+	nargs := func(min, max int) error {
+		if len(args) < min || max >= 0 && len(args) > max {
+			return queryErrorf("Number of arguments does not match for function %s", x.name)
+		}
+		return nil
+	}
+	typed := func(typs ...string) error {
+		for i, a := range args {
+			typ := typs[len(typs)-1]
+			if i < len(typs) {
+				typ = typs[i]
+			}
+			if err := checkType(a, "function "+x.name, typ); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	str := fieldType{typ: typeString}
+	integer := fieldType{typ: typeInteger}
+	boolean := fieldType{typ: typeBoolean}
+
+	switch x.name {
+	case "UPPER", "LOWER", "TRIM":
+		if err := nargs(1, 1); err != nil {
+			return nil, err
+		}
+		if err := typed(typeString); err != nil {
+			return nil, err
+		}
+		f := map[string]func(string) string{"UPPER": strings.ToUpper, "LOWER": strings.ToLower, "TRIM": strings.TrimSpace}[x.name]
+		return strict(str, args, func(vs []value) (value, error) { return f(vs[0].(string)), nil }), nil
+	case "LENGTH", "CHAR_LENGTH", "CHARACTER_LENGTH":
+		if err := nargs(1, 1); err != nil {
+			return nil, err
+		}
+		return strict(integer, args, func(vs []value) (value, error) {
+			switch v := vs[0].(type) {
+			case string:
+				return int64(utf8.RuneCountInString(v)), nil
+			case []byte:
+				return int64(len(v)), nil
+			}
+			return nil, queryErrorf("No matching signature for function %s", x.name)
+		}), nil
+	case "CONCAT":
+		if err := nargs(1, -1); err != nil {
+			return nil, err
+		}
+		if err := typed(typeString); err != nil {
+			return nil, err
+		}
+		return strict(str, args, func(vs []value) (value, error) {
+			var b strings.Builder
+			for _, v := range vs {
+				b.WriteString(v.(string))
+			}
+			return b.String(), nil
+		}), nil
+	case "STARTS_WITH", "ENDS_WITH":
+		if err := nargs(2, 2); err != nil {
+			return nil, err
+		}
+		if err := typed(typeString); err != nil {
+			return nil, err
+		}
+		f := strings.HasPrefix
+		if x.name == "ENDS_WITH" {
+			f = strings.HasSuffix
+		}
+		return strict(boolean, args, func(vs []value) (value, error) {
+			return f(vs[0].(string), vs[1].(string)), nil
+		}), nil
+	case "REPLACE":
+		if err := nargs(3, 3); err != nil {
+			return nil, err
+		}
+		if err := typed(typeString); err != nil {
+			return nil, err
+		}
+		return strict(str, args, func(vs []value) (value, error) {
+			if vs[1] == "" {
+				return vs[0], nil
+			}
+			return strings.ReplaceAll(vs[0].(string), vs[1].(string), vs[2].(string)), nil
+		}), nil
+	case "SUBSTR", "SUBSTRING":
+		if err := nargs(2, 3); err != nil {
+			return nil, err
+		}
+		if err := typed(typeString, typeInteger); err != nil {
+			return nil, err
+		}
+		return strict(str, args, func(vs []value) (value, error) {
+			rs := []rune(vs[0].(string))
+			pos := vs[1].(int64)
+			switch {
+			case pos > 0:
+				pos--
+			case pos < 0:
+				pos += int64(len(rs))
+				if pos < 0 {
+					pos = 0
+				}
+			}
+			if pos > int64(len(rs)) {
+				return "", nil
+			}
+			end := int64(len(rs))
+			if len(vs) == 3 {
+				n := vs[2].(int64)
+				if n < 0 {
+					return nil, queryErrorf("Third argument in SUBSTR() cannot be negative")
+				}
+				if pos+n < end {
+					end = pos + n
+				}
+			}
+			return string(rs[pos:end]), nil
+		}), nil
+	case "COALESCE", "IFNULL":
+		if x.name == "IFNULL" {
+			if err := nargs(2, 2); err != nil {
+				return nil, err
+			}
+		} else if err := nargs(1, -1); err != nil {
+			return nil, err
+		}
+		t, err := commonType(x.name, args)
+		if err != nil {
+			return nil, err
+		}
+		return &node{t: t, eval: func(ctx *evalCtx) (value, error) {
+			for _, a := range args {
+				v, err := evalAs(a, ctx, t.typ)
+				if err != nil || v != nil {
+					return v, err
+				}
+			}
+			return nil, nil
+		}}, nil
+	case "IF":
+		if err := nargs(3, 3); err != nil {
+			return nil, err
+		}
+		if err := checkType(args[0], "function IF", typeBoolean); err != nil {
+			return nil, err
+		}
+		t, err := commonType(x.name, args[1:])
+		if err != nil {
+			return nil, err
+		}
+		return &node{t: t, eval: func(ctx *evalCtx) (value, error) {
+			cond, err := args[0].eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if cond == true {
+				return evalAs(args[1], ctx, t.typ)
+			}
+			return evalAs(args[2], ctx, t.typ)
+		}}, nil
+	case "NULLIF":
+		if err := nargs(2, 2); err != nil {
+			return nil, err
+		}
+		l, r, err := comparable(x.name, args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &node{t: l.t, eval: func(ctx *evalCtx) (value, error) {
+			lv, err := l.eval(ctx)
+			if err != nil || lv == nil {
+				return nil, err
+			}
+			rv, err := r.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if rv != nil && compareValues(lv, rv) == 0 {
+				return nil, nil
+			}
+			return lv, nil
+		}}, nil
+	case "ABS":
+		if err := nargs(1, 1); err != nil {
+			return nil, err
+		}
+		if args[0].t.repeated || !isNumeric(args[0].t.typ) {
+			return nil, queryErrorf("No matching signature for function ABS for argument type %s", args[0].t)
+		}
+		return strict(args[0].t, args, func(vs []value) (value, error) {
+			switch v := vs[0].(type) {
+			case int64:
+				if v < 0 {
+					return -v, nil
+				}
+				return v, nil
+			case float64:
+				return math.Abs(v), nil
+			}
+			return new(big.Rat).Abs(vs[0].(*big.Rat)), nil
+		}), nil
+	case "ROUND":
+		if err := nargs(1, 2); err != nil {
+			return nil, err
+		}
+		if args[0].t.repeated || !isNumeric(args[0].t.typ) || len(args) == 2 && checkType(args[1], "ROUND", typeInteger) != nil {
+			return nil, queryErrorf("No matching signature for function ROUND")
+		}
+		t := args[0].t
+		if t.typ == typeInteger {
+			t.typ = typeFloat
+		}
+		return strict(t, args, func(vs []value) (value, error) {
+			var digits int64
+			if len(vs) == 2 {
+				digits = vs[1].(int64)
+			}
+			if t.typ == typeFloat {
+				scale := math.Pow(10, float64(digits))
+				return math.Round(toFloat(vs[0])*scale) / scale, nil
+			}
+			if digits < 0 {
+				digits = 0
+			}
+			r := new(big.Rat).Abs(vs[0].(*big.Rat))
+			// FloatString rounds half away from zero, as ROUND does.
+			res, _ := new(big.Rat).SetString(r.FloatString(int(digits)))
+			if vs[0].(*big.Rat).Sign() < 0 {
+				res.Neg(res)
+			}
+			return res, nil
+		}), nil
+	case "MOD":
+		if err := nargs(2, 2); err != nil {
+			return nil, err
+		}
+		if err := typed(typeInteger); err != nil {
+			return nil, err
+		}
+		return strict(integer, args, func(vs []value) (value, error) {
+			if vs[1].(int64) == 0 {
+				return nil, queryErrorf("division by zero: MOD(%d, 0)", vs[0])
+			}
+			return vs[0].(int64) % vs[1].(int64), nil
+		}), nil
+	case "ARRAY_LENGTH":
+		if err := nargs(1, 1); err != nil {
+			return nil, err
+		}
+		if !args[0].t.repeated {
+			return nil, queryErrorf("No matching signature for function ARRAY_LENGTH for argument type %s", args[0].t)
+		}
+		return strict(integer, args, func(vs []value) (value, error) {
+			return int64(len(vs[0].([]value))), nil
+		}), nil
+	case "CURRENT_TIMESTAMP", "CURRENT_DATE", "CURRENT_DATETIME", "CURRENT_TIME":
+		if err := nargs(0, 0); err != nil {
+			return nil, err
+		}
+		now := c.env.now.UTC().Truncate(time.Microsecond)
+		switch x.name {
+		case "CURRENT_DATE":
+			return constNode(fieldType{typ: typeDate}, civil.DateOf(now)), nil
+		case "CURRENT_DATETIME":
+			return constNode(fieldType{typ: typeDateTime}, civil.DateTimeOf(now)), nil
+		case "CURRENT_TIME":
+			return constNode(fieldType{typ: typeTime}, civil.TimeOf(now)), nil
+		}
+		return constNode(fieldType{typ: typeTimestamp}, now), nil
+	}
+	return nil, queryErrorf("Function not found: %s", x.name)
+}
+
+func (c *compiler) compileAggregate(x *callExpr) (*node, error) {
+	if !c.aggAllowed {
+		return nil, queryErrorf("Aggregate function %s not allowed here", x.name)
+	}
+	if c.inAgg {
+		return nil, queryErrorf("Aggregations of aggregations are not allowed")
+	}
+	c.sawAgg = true
+	if x.star {
+		if x.name != "COUNT" {
+			return nil, queryErrorf("%s(*) is not allowed", x.name)
+		}
+		return &node{t: fieldType{typ: typeInteger}, eval: func(ctx *evalCtx) (value, error) {
+			return int64(len(ctx.group)), nil
+		}}, nil
+	}
+	c.inAgg = true
+	args := make([]*node, len(x.args))
+	for i, a := range x.args {
+		n, err := c.compile(a)
+		if err != nil {
+			c.inAgg = false
+			return nil, err
+		}
+		args[i] = n
+	}
+	c.inAgg = false
+	want := 1
+	if x.name == "STRING_AGG" && len(args) == 2 {
+		want = 2
+	}
+	if len(args) != want {
+		return nil, queryErrorf("Number of arguments does not match for aggregate function %s", x.name)
+	}
+	arg := args[0]
+	if arg.t.repeated && x.name != "ANY_VALUE" {
+		return nil, queryErrorf("Aggregate function %s does not support arguments of type %s", x.name, arg.t)
+	}
+
+	// values returns the non-NULL values of the argument in the rows of
+	// the group.
+	values := func(ctx *evalCtx) ([]value, error) {
+		var vs []value
+		seen := map[string]bool{}
+		for _, r := range ctx.group {
+			v, err := arg.eval(&evalCtx{row: r})
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				continue
+			}
+			if x.distinct {
+				k := valueKey(v)
+				if seen[k] {
+					continue
+				}
+				seen[k] = true
+			}
+			vs = append(vs, v)
+		}
+		return vs, nil
+	}
+	aggregate := func(t fieldType, f func([]value) (value, error)) *node {
+		return &node{t: t, eval: func(ctx *evalCtx) (value, error) {
+			vs, err := values(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return f(vs)
+		}}
+	}
+
+	switch x.name {
+	case "COUNT":
+		return aggregate(fieldType{typ: typeInteger}, func(vs []value) (value, error) {
+			return int64(len(vs)), nil
+		}), nil
+	case "COUNTIF", "LOGICAL_AND", "LOGICAL_OR":
+		if err := checkType(arg, "function "+x.name, typeBoolean); err != nil {
+			return nil, err
+		}
+		t := fieldType{typ: typeBoolean}
+		if x.name == "COUNTIF" {
+			t.typ = typeInteger
+		}
+		return &node{t: t, eval: func(ctx *evalCtx) (value, error) {
+			var n, all int64
+			for _, r := range ctx.group {
+				v, err := arg.eval(&evalCtx{row: r})
+				if err != nil {
+					return nil, err
+				}
+				if v == nil {
+					continue
+				}
+				all++
+				if v == true {
+					n++
+				}
+			}
+			switch x.name {
+			case "COUNTIF":
+				return n, nil
+			case "LOGICAL_AND":
+				if all == 0 {
+					return nil, nil
+				}
+				return n == all, nil
+			}
+			if all == 0 {
+				return nil, nil
+			}
+			return n > 0, nil
+		}}, nil
+	case "SUM", "AVG":
+		if !isNumeric(arg.t.typ) && arg.t.typ != "" {
+			return nil, queryErrorf("No matching signature for aggregate function %s for argument type %s", x.name, arg.t)
+		}
+		t := arg.t
+		if t.typ == "" {
+			t.typ = typeInteger
+		}
+		if x.name == "AVG" && t.typ == typeInteger {
+			t.typ = typeFloat
+		}
+		return aggregate(t, func(vs []value) (value, error) {
+			if len(vs) == 0 {
+				return nil, nil
+			}
+			var sum value = int64(0)
+			for _, v := range vs {
+				switch t.typ {
+				case typeInteger:
+					sum = sum.(int64) + v.(int64)
+				case typeFloat:
+					sum = toFloat(sum) + toFloat(v)
+				default:
+					sum = new(big.Rat).Add(toRat(sum), toRat(v))
+				}
+			}
+			if x.name == "SUM" {
+				return sum, nil
+			}
+			if t.typ == typeFloat {
+				return toFloat(sum) / float64(len(vs)), nil
+			}
+			avg := new(big.Rat).Quo(toRat(sum), new(big.Rat).SetInt64(int64(len(vs))))
+			return roundRat(avg, numericScale(t.typ)), nil
+		}), nil
+	case "MIN", "MAX":
+		if arg.t.typ == typeRecord {
+			return nil, queryErrorf("MIN and MAX do not support arguments of type STRUCT")
+		}
+		return aggregate(arg.t, func(vs []value) (value, error) {
+			var res value
+			for _, v := range vs {
+				if res == nil {
+					res = v
+					continue
+				}
+				c := compareValues(v, res)
+				if x.name == "MIN" && c < 0 || x.name == "MAX" && c > 0 {
+					res = v
+				}
+			}
+			return res, nil
+		}), nil
+	case "ANY_VALUE":
+		return aggregate(arg.t, func(vs []value) (value, error) {
+			if len(vs) == 0 {
+				return nil, nil
+			}
+			return vs[0], nil
+		}), nil
+	case "ARRAY_AGG":
+		t := arg.t
+		t.repeated = true
+		if t.typ == "" {
+			t.typ = typeInteger
+		}
+		return &node{t: t, eval: func(ctx *evalCtx) (value, error) {
+			vs := []value{}
+			seen := map[string]bool{}
+			for _, r := range ctx.group {
+				v, err := arg.eval(&evalCtx{row: r})
+				if err != nil {
+					return nil, err
+				}
+				if v == nil {
+					return nil, queryErrorf("Array cannot have a null element; error in writing field %s", x.name)
+				}
+				if x.distinct {
+					k := valueKey(v)
+					if seen[k] {
+						continue
+					}
+					seen[k] = true
+				}
+				vs = append(vs, v)
+			}
+			return vs, nil
+		}}, nil
+	case "STRING_AGG":
+		if err := checkType(arg, "function STRING_AGG", typeString); err != nil {
+			return nil, err
+		}
+		sep := ","
+		if len(args) == 2 {
+			if args[1].lit == nil || args[1].t.typ != typeString {
+				return nil, queryErrorf("The delimiter of STRING_AGG must be a string literal")
+			}
+			sep = args[1].lit.v.(string)
+		}
+		return aggregate(fieldType{typ: typeString}, func(vs []value) (value, error) {
+			if len(vs) == 0 {
+				return nil, nil
+			}
+			ss := make([]string, len(vs))
+			for i, v := range vs {
+				ss[i] = v.(string)
+			}
+			return strings.Join(ss, sep), nil
+		}), nil
+	}
+	return nil, queryErrorf("Function not found: %s", x.name)
+}
@@ -0,0 +1,649 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/internal/testutil"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultPageSize is the number of items or rows in a page when the
+	// request doesn't give a page size.
+	defaultPageSize = 1000
+	// maxPageSize is the largest page of rows that a request may ask for.
+	maxPageSize = 100000
+)
+
+// httpServer serves the BigQuery REST API, and the uploads of load jobs.
+type httpServer struct {
+	backend *backend
+}
+
+func (h *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+	p := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(p, "/upload/bigquery/v2/"):
+		err = h.serveUpload(w, r, p[len("/upload/bigquery/v2/"):])
+	case strings.HasPrefix(p, "/bigquery/v2/"):
+		err = h.serveREST(w, r, p[len("/bigquery/v2/"):])
+	default:
+		err = errNoRoute(r)
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+// writeError writes err in the REST API's error format.
+func writeError(w http.ResponseWriter, err error) {
+	code, reason, msg := errorDetails(err)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": msg,
+			"errors": []map[string]string{{
+				"domain":  "global",
+				"reason":  reason,
+				"message": msg,
+			}},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	return json.NewEncoder(w).Encode(v)
+}
+
+// splitPath splits an escaped URL path into unescaped segments.
+func splitPath(p string) ([]string, error) {
+	segs := strings.Split(strings.Trim(p, "/"), "/")
+	for i, s := range segs {
+		u, err := url.PathUnescape(s)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "bad path segment %q", s)
+		}
+		segs[i] = u
+	}
+	return segs, nil
+}
+
+func errMethod(r *http.Request) error {
+	return status.Errorf(codes.InvalidArgument, "method %s not allowed for %s", r.Method, r.URL.Path)
+}
+
+func errNoRoute(r *http.Request) error {
+	return status.Errorf(codes.NotFound, "no API at %s", r.URL.Path)
+}
+
+// serveREST serves the REST API, at the path p relative to its root.
+func (h *httpServer) serveREST(w http.ResponseWriter, r *http.Request, p string) error {
+	segs, err := splitPath(p)
+	if err != nil {
+		return err
+	}
+	if len(segs) < 3 || segs[0] != "projects" {
+		return errNoRoute(r)
+	}
+	project, rest := segs[1], segs[2:]
+	switch {
+	case rest[0] == "datasets" && len(rest) == 1:
+		return h.serveDatasets(w, r, project)
+	case rest[0] == "datasets" && len(rest) == 2:
+		return h.serveDataset(w, r, project, rest[1])
+	case rest[0] == "datasets" && len(rest) == 3 && rest[2] == "tables":
+		return h.serveTables(w, r, project, rest[1])
+	case rest[0] == "datasets" && len(rest) == 4 && rest[2] == "tables":
+		return h.serveTable(w, r, project, rest[1], rest[3])
+	case rest[0] == "datasets" && len(rest) == 5 && rest[2] == "tables" && rest[4] == "insertAll":
+		if r.Method != http.MethodPost {
+			return errMethod(r)
+		}
+		return h.insertAll(w, r, project, rest[1], rest[3])
+	case rest[0] == "datasets" && len(rest) == 5 && rest[2] == "tables" && rest[4] == "data":
+		if r.Method != http.MethodGet {
+			return errMethod(r)
+		}
+		return h.listRows(w, r, project, rest[1], rest[3])
+	case rest[0] == "jobs" && len(rest) == 1:
+		return h.serveJobs(w, r, project)
+	case rest[0] == "jobs" && len(rest) == 2:
+		if r.Method != http.MethodGet {
+			return errMethod(r)
+		}
+		j, err := h.backend.getJob(project, rest[1])
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, j)
+	case rest[0] == "jobs" && len(rest) == 3 && rest[2] == "cancel":
+		if r.Method != http.MethodPost {
+			return errMethod(r)
+		}
+		// Jobs finish when they're created, so there's nothing to cancel.
+		j, err := h.backend.getJob(project, rest[1])
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, &bq.JobCancelResponse{Kind: "bigquery#jobCancelResponse", Job: j})
+	case rest[0] == "queries" && len(rest) == 1:
+		if r.Method != http.MethodPost {
+			return errMethod(r)
+		}
+		return h.query(w, r, project)
+	case rest[0] == "queries" && len(rest) == 2:
+		if r.Method != http.MethodGet {
+			return errMethod(r)
+		}
+		return h.getQueryResults(w, r, project, rest[1])
+	}
+	return errNoRoute(r)
+}
+
+func (h *httpServer) serveDatasets(w http.ResponseWriter, r *http.Request, project string) error {
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		ds, err := h.backend.listDatasets(project, q.Get("all") == "true", q.Get("filter"))
+		if err != nil {
+			return err
+		}
+		from, to, next, err := pageBounds(q, len(ds))
+		if err != nil {
+			return err
+		}
+		res := &bq.DatasetList{Kind: "bigquery#datasetList", NextPageToken: next}
+		for _, d := range ds[from:to] {
+			res.Datasets = append(res.Datasets, &bq.DatasetListDatasets{
+				Kind:             d.Kind,
+				Id:               d.Id,
+				DatasetReference: d.DatasetReference,
+				FriendlyName:     d.FriendlyName,
+				Labels:           d.Labels,
+				Location:         d.Location,
+			})
+		}
+		return writeJSON(w, res)
+	case http.MethodPost:
+		var d bq.Dataset
+		if err := readJSON(r, &d); err != nil {
+			return err
+		}
+		res, err := h.backend.createDataset(project, &d)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, res)
+	}
+	return errMethod(r)
+}
+
+func (h *httpServer) serveDataset(w http.ResponseWriter, r *http.Request, project, id string) error {
+	switch r.Method {
+	case http.MethodGet:
+		d, err := h.backend.getDataset(project, id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, d)
+	case http.MethodPatch, http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		d, err := h.backend.updateDataset(project, id, r.Header.Get("If-Match"), body, r.Method == http.MethodPatch)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, d)
+	case http.MethodDelete:
+		if err := h.backend.deleteDataset(project, id, r.URL.Query().Get("deleteContents") == "true"); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return errMethod(r)
+}
+
+func (h *httpServer) serveTables(w http.ResponseWriter, r *http.Request, project, datasetID string) error {
+	switch r.Method {
+	case http.MethodGet:
+		ts, err := h.backend.listTables(project, datasetID)
+		if err != nil {
+			return err
+		}
+		from, to, next, err := pageBounds(r.URL.Query(), len(ts))
+		if err != nil {
+			return err
+		}
+		res := &bq.TableList{Kind: "bigquery#tableList", NextPageToken: next, TotalItems: int64(len(ts))}
+		for _, t := range ts[from:to] {
+			res.Tables = append(res.Tables, &bq.TableListTables{
+				Kind:             t.Kind,
+				Id:               t.Id,
+				TableReference:   t.TableReference,
+				Type:             t.Type,
+				FriendlyName:     t.FriendlyName,
+				Labels:           t.Labels,
+				CreationTime:     t.CreationTime,
+				ExpirationTime:   t.ExpirationTime,
+				TimePartitioning: t.TimePartitioning,
+				Clustering:       t.Clustering,
+			})
+		}
+		return writeJSON(w, res)
+	case http.MethodPost:
+		var t bq.Table
+		if err := readJSON(r, &t); err != nil {
+			return err
+		}
+		res, err := h.backend.createTable(project, datasetID, &t)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, res)
+	}
+	return errMethod(r)
+}
+
+func (h *httpServer) serveTable(w http.ResponseWriter, r *http.Request, project, datasetID, tableID string) error {
+	switch r.Method {
+	case http.MethodGet:
+		t, err := h.backend.getTable(project, datasetID, tableID)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, t)
+	case http.MethodPatch, http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		t, err := h.backend.updateTable(project, datasetID, tableID, r.Header.Get("If-Match"), body, r.Method == http.MethodPatch)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, t)
+	case http.MethodDelete:
+		if err := h.backend.deleteTable(project, datasetID, tableID); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return errMethod(r)
+}
+
+func (h *httpServer) insertAll(w http.ResponseWriter, r *http.Request, project, datasetID, tableID string) error {
+	// Decode numbers as json.Numbers, so that large integers are exact.
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	var req bq.TableDataInsertAllRequest
+	if err := dec.Decode(&req); err != nil {
+		return status.Errorf(codes.InvalidArgument, "bad request body: %v", err)
+	}
+	errs, err := h.backend.insertRows(project, datasetID, tableID, &req)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, &bq.TableDataInsertAllResponse{
+		Kind:         "bigquery#tableDataInsertAllResponse",
+		InsertErrors: errs,
+	})
+}
+
+func (h *httpServer) listRows(w http.ResponseWriter, r *http.Request, project, datasetID, tableID string) error {
+	fields, rows, err := h.backend.listRows(project, datasetID, tableID)
+	if err != nil {
+		return err
+	}
+	from, to, next, err := rowPageBounds(r.URL.Query(), len(rows))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, &bq.TableDataList{
+		Kind:      "bigquery#tableDataList",
+		TotalRows: int64(len(rows)),
+		PageToken: next,
+		Rows:      formatRows(fields, rows[from:to]),
+	})
+}
+
+func formatRows(fields []*bq.TableFieldSchema, rows [][]value) []*bq.TableRow {
+	res := make([]*bq.TableRow, len(rows))
+	for i, r := range rows {
+		res[i] = formatRow(fields, r)
+	}
+	return res
+}
+
+func (h *httpServer) serveJobs(w http.ResponseWriter, r *http.Request, project string) error {
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		js := h.backend.listJobs(project)
+		states := map[string]bool{}
+		for _, s := range q["stateFilter"] {
+			states[strings.ToUpper(s)] = true
+		}
+		var kept []*bq.Job
+		for _, j := range js {
+			if len(states) == 0 || states[j.Status.State] {
+				kept = append(kept, j)
+			}
+		}
+		from, to, next, err := pageBounds(q, len(kept))
+		if err != nil {
+			return err
+		}
+		res := &bq.JobList{Kind: "bigquery#jobList", NextPageToken: next}
+		for _, j := range kept[from:to] {
+			res.Jobs = append(res.Jobs, &bq.JobListJobs{
+				Kind:          j.Kind,
+				Id:            j.Id,
+				JobReference:  j.JobReference,
+				Configuration: j.Configuration,
+				Statistics:    j.Statistics,
+				Status:        j.Status,
+				State:         j.Status.State,
+				ErrorResult:   j.Status.ErrorResult,
+				UserEmail:     j.UserEmail,
+			})
+		}
+		return writeJSON(w, res)
+	case http.MethodPost:
+		var j bq.Job
+		if err := readJSON(r, &j); err != nil {
+			return err
+		}
+		res, err := h.backend.insertJob(project, &j, nil)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, res)
+	}
+	return errMethod(r)
+}
+
+// query serves jobs.query, which runs a query and returns its first page of
+// results.
+func (h *httpServer) query(w http.ResponseWriter, r *http.Request, project string) error {
+	var req bq.QueryRequest
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	j, rows, err := h.backend.query(project, &req)
+	if err != nil {
+		return err
+	}
+	res := &bq.QueryResponse{
+		Kind:         "bigquery#queryResponse",
+		JobReference: j.JobReference,
+		JobComplete:  true,
+		Schema:       j.Statistics.Query.Schema,
+		TotalRows:    uint64(len(rows)),
+	}
+	if req.DryRun {
+		return writeJSON(w, res)
+	}
+	n := int64(len(rows))
+	if req.MaxResults > 0 && req.MaxResults < n {
+		n = req.MaxResults
+		res.PageToken = strconv.FormatInt(n, 10)
+	}
+	res.Rows = formatRows(res.Schema.Fields, rows[:n])
+	return writeJSON(w, res)
+}
+
+func (h *httpServer) getQueryResults(w http.ResponseWriter, r *http.Request, project, jobID string) error {
+	j, fields, rows, err := h.backend.queryResults(project, jobID)
+	if err != nil {
+		return err
+	}
+	from, to, next, err := rowPageBounds(r.URL.Query(), len(rows))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, &bq.GetQueryResultsResponse{
+		Kind:         "bigquery#getQueryResultsResponse",
+		JobReference: j.JobReference,
+		JobComplete:  true,
+		Schema:       &bq.TableSchema{Fields: fields},
+		TotalRows:    uint64(len(rows)),
+		PageToken:    next,
+		Rows:         formatRows(fields, rows[from:to]),
+	})
+}
+
+// serveUpload serves the upload endpoint of jobs.insert, at the path p
+// relative to its root.
+func (h *httpServer) serveUpload(w http.ResponseWriter, r *http.Request, p string) error {
+	segs, err := splitPath(p)
+	if err != nil {
+		return err
+	}
+	if len(segs) != 3 || segs[0] != "projects" || segs[2] != "jobs" {
+		return errNoRoute(r)
+	}
+	project := segs[1]
+	q := r.URL.Query()
+	if id := q.Get("upload_id"); id != "" {
+		return h.serveUploadSession(w, r, id)
+	}
+	if r.Method != http.MethodPost {
+		return errMethod(r)
+	}
+	var j bq.Job
+	switch q.Get("uploadType") {
+	case "multipart":
+		mr, err := multipartReader(r)
+		if err != nil {
+			return err
+		}
+		part, err := mr.NextPart()
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "reading metadata part: %v", err)
+		}
+		if err := json.NewDecoder(part).Decode(&j); err != nil {
+			return status.Errorf(codes.InvalidArgument, "bad job metadata: %v", err)
+		}
+		part, err = mr.NextPart()
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "reading media part: %v", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return err
+		}
+		res, err := h.backend.insertJob(project, &j, data)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, res)
+	case "resumable":
+		if err := readJSON(r, &j); err != nil {
+			return err
+		}
+		id := h.backend.startUpload(project, &j)
+		loc := url.URL{
+			Scheme:   "http",
+			Host:     r.Host,
+			Path:     r.URL.Path,
+			RawQuery: url.Values{"uploadType": {"resumable"}, "upload_id": {id}}.Encode(),
+		}
+		w.Header().Set("Location", loc.String())
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	return status.Errorf(codes.InvalidArgument, "unsupported uploadType %q", q.Get("uploadType"))
+}
+
+// serveUploadSession serves requests to the session URI of a resumable
+// upload.
+func (h *httpServer) serveUploadSession(w http.ResponseWriter, r *http.Request, id string) error {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		return errMethod(r)
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	// The Content-Range header is "bytes first-last/total" or
+	// "bytes first-last/*" for a chunk, or "bytes */total" at the end of an
+	// upload. If it's missing, the body is all of the data.
+	var (
+		offset int64
+		total  = int64(-1)
+	)
+	if cr := r.Header.Get("Content-Range"); cr != "" {
+		spec := strings.TrimPrefix(cr, "bytes ")
+		rng, tot, ok := strings.Cut(spec, "/")
+		if spec == cr || !ok {
+			return status.Errorf(codes.InvalidArgument, "bad Content-Range %q", cr)
+		}
+		if tot != "*" {
+			if total, err = strconv.ParseInt(tot, 10, 64); err != nil {
+				return status.Errorf(codes.InvalidArgument, "bad Content-Range %q", cr)
+			}
+		}
+		if rng != "*" {
+			first, _, _ := strings.Cut(rng, "-")
+			if offset, err = strconv.ParseInt(first, 10, 64); err != nil {
+				return status.Errorf(codes.InvalidArgument, "bad Content-Range %q", cr)
+			}
+		} else if total >= 0 {
+			offset = total
+		}
+	} else {
+		total = int64(len(data))
+	}
+	finish := total >= 0 && offset+int64(len(data)) >= total
+	persisted, j, err := h.backend.writeUpload(id, offset, data, finish)
+	if err != nil {
+		return err
+	}
+	if j != nil {
+		return writeJSON(w, j)
+	}
+	if persisted > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", persisted-1))
+	}
+	if r.Header.Get("X-GUploader-No-308") == "yes" {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusPermanentRedirect)
+	}
+	return nil
+}
+
+// multipartReader returns a reader for the parts of a multipart/related
+// request body.
+func multipartReader(r *http.Request) (*multipart.Reader, error) {
+	mt, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mt, "multipart/") || params["boundary"] == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "bad Content-Type %q for a multipart upload", r.Header.Get("Content-Type"))
+	}
+	return multipart.NewReader(r.Body, params["boundary"]), nil
+}
+
+// Parameters.
+
+func readJSON(r *http.Request, v interface{}) error {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return status.Errorf(codes.InvalidArgument, "bad request body: %v", err)
+	}
+	return nil
+}
+
+func intParam(q url.Values, name string) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "bad %s parameter %q", name, v)
+	}
+	return n, nil
+}
+
+// pageBounds returns the bounds of a page of a list of resources.
+func pageBounds(q url.Values, length int) (from, to int, next string, err error) {
+	maxResults, err := intParam(q, "maxResults")
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if maxResults <= 0 || maxResults > defaultPageSize {
+		maxResults = defaultPageSize
+	}
+	return testutil.PageBounds(maxResults, q.Get("pageToken"), length)
+}
+
+// rowPageBounds returns the bounds of a page of rows. A page starts at the
+// page token or at startIndex, and is empty if maxResults is zero.
+func rowPageBounds(q url.Values, length int) (from, to int, next string, err error) {
+	size := defaultPageSize
+	if q.Get("maxResults") != "" {
+		if size, err = intParam(q, "maxResults"); err != nil {
+			return 0, 0, "", err
+		}
+		if size > maxPageSize {
+			size = maxPageSize
+		}
+	}
+	if tok := q.Get("pageToken"); tok != "" {
+		if from, err = strconv.Atoi(tok); err != nil || from < 0 {
+			return 0, 0, "", status.Errorf(codes.InvalidArgument, "bad page token %q", tok)
+		}
+	} else if from, err = intParam(q, "startIndex"); err != nil {
+		return 0, 0, "", err
+	}
+	if from > length {
+		from = length
+	}
+	to = from + size
+	if to >= length {
+		to = length
+	} else if size > 0 {
+		next = strconv.Itoa(to)
+	}
+	return from, to, next, nil
+}
@@ -0,0 +1,336 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// runLoadJob loads the uploaded data of a load job into its destination
+// table. It's called with s.mu held.
+func (s *backend) runLoadJob(j *job, data []byte) error {
+	cfg := j.proto.Configuration.Load
+	dst := cfg.DestinationTable
+	if dst == nil {
+		return status.Errorf(codes.InvalidArgument, "Load jobs must have a destination table")
+	}
+	if dst.ProjectId == "" {
+		dst.ProjectId = j.proto.JobReference.ProjectId
+	}
+	var fields []*bq.TableFieldSchema
+	if cfg.Schema != nil && len(cfg.Schema.Fields) > 0 {
+		fields = cfg.Schema.Fields
+		if err := validateSchema(fields); err != nil {
+			return err
+		}
+	} else if t, err := s.table(dst.ProjectId, dst.DatasetId, dst.TableId); err == nil && t.proto.Schema != nil {
+		fields = t.proto.Schema.Fields
+	}
+	if fields == nil && !cfg.Autodetect {
+		return status.Errorf(codes.InvalidArgument, "No schema specified on job or table.")
+	}
+
+	var (
+		rows [][]value
+		err  error
+	)
+	switch strings.ToUpper(cfg.SourceFormat) {
+	case "", "CSV":
+		fields, rows, err = readCSV(cfg, fields, data)
+	case "NEWLINE_DELIMITED_JSON":
+		fields, rows, err = readJSONLines(cfg, fields, data)
+	default:
+		return status.Errorf(codes.Unimplemented, "bqtest: source format %s is not supported", cfg.SourceFormat)
+	}
+	if err != nil {
+		return err
+	}
+	create, write := cfg.CreateDisposition, cfg.WriteDisposition
+	if create == "" {
+		create = "CREATE_IF_NEEDED"
+	}
+	if write == "" {
+		write = "WRITE_APPEND"
+	}
+	if err := s.writeRows(dst, fields, rows, create, write); err != nil {
+		return err
+	}
+	j.proto.Statistics.Load = &bq.JobStatistics3{
+		InputFiles:     1,
+		InputFileBytes: int64(len(data)),
+		OutputRows:     int64(len(rows)),
+	}
+	return nil
+}
+
+// badRecords counts the rows of a load job that can't be read, and fails
+// the job when there are more than it allows.
+type badRecords struct {
+	max   int64
+	count int64
+}
+
+func (b *badRecords) add(line int, err error) error {
+	b.count++
+	if b.count > b.max {
+		return status.Errorf(codes.InvalidArgument, "Error while reading data, error message: row %d: %v", line, err)
+	}
+	return nil
+}
+
+// readCSV reads the rows of a CSV file. If fields is nil, the schema is
+// detected from the data.
+func readCSV(cfg *bq.JobConfigurationLoad, fields []*bq.TableFieldSchema, data []byte) ([]*bq.TableFieldSchema, [][]value, error) {
+	for _, f := range fields {
+		if isRepeated(f) || canonicalType(f.Type) == typeRecord {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "CSV files cannot hold REPEATED or RECORD field %s", f.Name)
+		}
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	if d := cfg.FieldDelimiter; d != "" {
+		switch d {
+		case `\t`, "tab":
+			r.Comma = '\t'
+		default:
+			r.Comma, _ = utf8.DecodeRuneInString(d)
+		}
+	}
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "Error while reading data, error message: %v", err)
+	}
+	skip := int(cfg.SkipLeadingRows)
+	if skip > len(records) {
+		skip = len(records)
+	}
+	if fields == nil {
+		fields = detectCSVSchema(records, skip)
+	}
+	bad := &badRecords{max: cfg.MaxBadRecords}
+	var rows [][]value
+	for i, rec := range records[skip:] {
+		row, err := parseCSVRecord(cfg, fields, rec)
+		if err != nil {
+			if err := bad.add(skip+i+1, err); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return fields, rows, nil
+}
+
+func parseCSVRecord(cfg *bq.JobConfigurationLoad, fields []*bq.TableFieldSchema, rec []string) ([]value, error) {
+	switch {
+	case len(rec) < len(fields) && !cfg.AllowJaggedRows:
+		return nil, fmt.Errorf("too few columns: expected %d column(s) but got %d", len(fields), len(rec))
+	case len(rec) > len(fields) && !cfg.IgnoreUnknownValues:
+		return nil, fmt.Errorf("too many columns: expected %d column(s) but got %d", len(fields), len(rec))
+	}
+	row := make([]value, len(fields))
+	for i, f := range fields {
+		if i >= len(rec) || rec[i] == cfg.NullMarker {
+			if isRequired(f) {
+				return nil, fmt.Errorf("missing required field: %s", f.Name)
+			}
+			continue
+		}
+		v, err := parseString(canonicalType(f.Type), rec[i])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", f.Name, err)
+		}
+		row[i] = v
+	}
+	return row, nil
+}
+
+// detectCSVSchema returns the schema of CSV records. The names of the
+// columns are in the last of the skipped rows, if any. Each column has the
+// narrowest of the types INTEGER, FLOAT, BOOLEAN and STRING that all of
+// its values have.
+func detectCSVSchema(records [][]string, skip int) []*bq.TableFieldSchema {
+	var header []string
+	if skip > 0 {
+		header = records[skip-1]
+	}
+	var fields []*bq.TableFieldSchema
+	for _, rec := range records[skip:] {
+		for i, s := range rec {
+			if i >= len(fields) {
+				name := fmt.Sprintf("string_field_%d", i)
+				if i < len(header) && header[i] != "" {
+					name = header[i]
+				}
+				fields = append(fields, &bq.TableFieldSchema{Name: name, Mode: "NULLABLE"})
+			}
+			if s != "" {
+				fields[i].Type = widen(fields[i].Type, detectType(s))
+			}
+		}
+	}
+	for _, f := range fields {
+		if f.Type == "" {
+			f.Type = typeString
+		}
+	}
+	return fields
+}
+
+// detectType returns the type of a value in a CSV file.
+func detectType(s string) string {
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return typeInteger
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return typeFloat
+	}
+	if _, err := strconv.ParseBool(s); err == nil && len(s) > 1 {
+		return typeBoolean
+	}
+	return typeString
+}
+
+// widen returns the narrowest type that values of types a and b both have.
+func widen(a, b string) string {
+	switch {
+	case a == "" || a == b:
+		return b
+	case isNumeric(a) && isNumeric(b):
+		return typeFloat
+	}
+	return typeString
+}
+
+// readJSONLines reads the rows of a newline-delimited JSON file. If fields
+// is nil, the schema is detected from the data.
+func readJSONLines(cfg *bq.JobConfigurationLoad, fields []*bq.TableFieldSchema, data []byte) ([]*bq.TableFieldSchema, [][]value, error) {
+	var objs []map[string]interface{}
+	var lines []int
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	bad := &badRecords{max: cfg.MaxBadRecords}
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(sc.Bytes()))
+		dec.UseNumber()
+		var m map[string]interface{}
+		if err := dec.Decode(&m); err != nil {
+			if err := bad.add(line, err); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		objs = append(objs, m)
+		lines = append(lines, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "Error while reading data, error message: %v", err)
+	}
+	if fields == nil {
+		fields = detectJSONSchema(objs)
+	}
+	var rows [][]value
+	for i, m := range objs {
+		row, err := parseRow(fields, m, cfg.IgnoreUnknownValues)
+		if err != nil {
+			if err := bad.add(lines[i], err); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return fields, rows, nil
+}
+
+// detectJSONSchema returns the schema of JSON objects. Columns are in the
+// order that their names first appear, which for each object is in sorted
+// order.
+func detectJSONSchema(objs []map[string]interface{}) []*bq.TableFieldSchema {
+	var fields []*bq.TableFieldSchema
+	for _, m := range objs {
+		fields = mergeJSONFields(fields, m)
+	}
+	return fields
+}
+
+func mergeJSONFields(fields []*bq.TableFieldSchema, m map[string]interface{}) []*bq.TableFieldSchema {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := m[name]
+		if v == nil {
+			continue
+		}
+		i := fieldIndex(fields, name)
+		if i < 0 {
+			fields = append(fields, &bq.TableFieldSchema{Name: name, Mode: "NULLABLE"})
+			i = len(fields) - 1
+		}
+		f := fields[i]
+		if vs, ok := v.([]interface{}); ok {
+			f.Mode = "REPEATED"
+			for _, e := range vs {
+				detectJSONType(f, e)
+			}
+		} else {
+			detectJSONType(f, v)
+		}
+	}
+	return fields
+}
+
+func detectJSONType(f *bq.TableFieldSchema, v interface{}) {
+	var typ string
+	switch v := v.(type) {
+	case nil:
+		return
+	case json.Number:
+		typ = typeFloat
+		if _, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+			typ = typeInteger
+		}
+	case bool:
+		typ = typeBoolean
+	case map[string]interface{}:
+		f.Type = typeRecord
+		f.Fields = mergeJSONFields(f.Fields, v)
+		return
+	default:
+		typ = typeString
+	}
+	if f.Type != typeRecord {
+		f.Type = widen(f.Type, typ)
+	}
+}
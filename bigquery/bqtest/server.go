@@ -0,0 +1,87 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package bqtest provides a fake BigQuery server for testing. It keeps datasets,
+tables and jobs in memory and serves the REST API, so that a bigquery.Client
+can use it without a project.
+
+The fake supports datasets, tables and views, schema updates that add
+columns or relax REQUIRED columns to NULLABLE, the ETag preconditions of
+metadata updates, streaming inserts with insert IDs, listing table data, and
+query, copy and load jobs. Every job finishes when it is created.
+
+Queries are run by a small evaluator for a subset of GoogleSQL: SELECT
+statements over a single table or subquery, with WHERE, GROUP BY, HAVING,
+ORDER BY, LIMIT and OFFSET, named and positional query parameters, the
+common operators, CASE and CAST, and a few scalar and aggregate functions.
+Load jobs read CSV and newline-delimited JSON files that are uploaded with
+the job, such as those from a bigquery.ReaderSource, and can detect their
+schemas.
+
+The fake does not implement legacy SQL, joins, WITH clauses, set
+operations, DML or DDL statements, scripts, loads from Cloud Storage,
+extract jobs, external tables, materialized views, routines, models, row
+access policies or IAM policies. It ignores partitioning and clustering
+except to store them.
+
+To use the fake:
+
+	srv, err := bqtest.NewServer()
+	if err != nil {
+		// TODO: Handle error.
+	}
+	defer srv.Close()
+	client, err := bigquery.NewClient(ctx, "project-id", srv.ClientOptions()...)
+
+This package is EXPERIMENTAL and is subject to change without notice.
+*/
+package bqtest // import "cloud.google.com/go/bigquery/bqtest"
+
+import (
+	"net/http/httptest"
+
+	"google.golang.org/api/option"
+)
+
+// Server is a fake BigQuery server.
+type Server struct {
+	// URL is the base URL of the server, like "http://127.0.0.1:1234".
+	URL string
+
+	http *httptest.Server
+}
+
+// NewServer creates and starts a fake server running in the current process.
+func NewServer() (*Server, error) {
+	hsrv := httptest.NewServer(&httpServer{backend: newBackend()})
+	return &Server{
+		URL:  hsrv.URL,
+		http: hsrv,
+	}, nil
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.http.Close()
+}
+
+// ClientOptions returns the options for a bigquery.Client that uses the
+// server.
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.URL + "/bigquery/v2/"),
+		option.WithoutAuthentication(),
+	}
+}
@@ -0,0 +1,953 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file parses the subset of GoogleSQL that the fake understands: a
+// single SELECT statement, reading from at most one table or subquery,
+// with WHERE, GROUP BY, HAVING, ORDER BY, LIMIT and OFFSET clauses.
+
+// A queryError is an error in the text or meaning of a query.
+type queryError struct {
+	msg string
+}
+
+func (e *queryError) Error() string { return e.msg }
+
+func queryErrorf(format string, args ...interface{}) error {
+	return &queryError{msg: fmt.Sprintf(format, args...)}
+}
+
+type tokenKind int
+
+const (
+	tokEOF         tokenKind = iota
+	tokIdent                 // an identifier or keyword
+	tokQuotedIdent           // an identifier in backquotes
+	tokInt
+	tokFloat
+	tokString
+	tokBytes
+	tokNamedParam      // @name
+	tokPositionalParam // ?
+	tokOp              // punctuation and operators
+)
+
+type token struct {
+	kind tokenKind
+	text string // for strings and quoted identifiers, the unquoted text
+	pos  int
+}
+
+// is reports whether t is the keyword or operator s.
+func (t token) is(s string) bool {
+	switch t.kind {
+	case tokIdent:
+		return strings.EqualFold(t.text, s)
+	case tokOp:
+		return t.text == s
+	}
+	return false
+}
+
+// lex splits a query into tokens.
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || strings.HasPrefix(src[i:], "--"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, queryErrorf("unterminated comment at %d", i)
+			}
+			i += end + 4
+		case c == '`':
+			end := strings.IndexByte(src[i+1:], '`')
+			if end < 0 {
+				return nil, queryErrorf("unterminated quoted identifier at %d", i)
+			}
+			toks = append(toks, token{kind: tokQuotedIdent, text: src[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case c == '\'' || c == '"':
+			s, n, err := lexString(src[i:], false)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{kind: tokString, text: s, pos: i})
+			i += n
+		case (c == 'r' || c == 'R' || c == 'b' || c == 'B') && i+1 < len(src) && (src[i+1] == '\'' || src[i+1] == '"'):
+			s, n, err := lexString(src[i+1:], c == 'r' || c == 'R')
+			if err != nil {
+				return nil, err
+			}
+			kind := tokString
+			if c == 'b' || c == 'B' {
+				kind = tokBytes
+			}
+			toks = append(toks, token{kind: kind, text: s, pos: i})
+			i += n + 1
+		case c >= '0' && c <= '9':
+			start := i
+			kind := tokInt
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.' ||
+				src[i] == 'e' || src[i] == 'E' ||
+				((src[i] == '+' || src[i] == '-') && (src[i-1] == 'e' || src[i-1] == 'E'))) {
+				if !isDigit(src[i]) {
+					kind = tokFloat
+				}
+				i++
+			}
+			toks = append(toks, token{kind: kind, text: src[start:i], pos: start})
+		case c == '@':
+			start := i
+			i++
+			for i < len(src) && isIdentChar(rune(src[i])) {
+				i++
+			}
+			if i == start+1 {
+				return nil, queryErrorf("bad parameter name at %d", start)
+			}
+			toks = append(toks, token{kind: tokNamedParam, text: src[start+1 : i], pos: start})
+		case c == '?':
+			toks = append(toks, token{kind: tokPositionalParam, text: "?", pos: i})
+			i++
+		case isIdentChar(rune(c)):
+			start := i
+			for i < len(src) && isIdentChar(rune(src[i])) {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: src[start:i], pos: start})
+		default:
+			op := string(c)
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "<=", ">=", "<>", "!=", "||":
+					op = two
+				}
+			}
+			if len(op) == 1 && !strings.Contains("(),.*+-/=<>[];", op) {
+				return nil, queryErrorf("unexpected character %q at %d", c, i)
+			}
+			toks = append(toks, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// lexString reads a quoted string at the start of s, and returns its
+// contents and length.
+func lexString(s string, raw bool) (string, int, error) {
+	q := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == q:
+			return b.String(), i + 1, nil
+		case c == '\\' && !raw && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '0':
+				b.WriteByte(0)
+			default:
+				b.WriteByte(s[i])
+			}
+		case c == '\\' && raw && i+1 < len(s) && s[i+1] == q:
+			b.WriteString(s[i : i+2])
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, queryErrorf("unterminated string")
+}
+
+// The syntax tree of a query.
+type (
+	// A selectQuery is a SELECT statement.
+	selectQuery struct {
+		distinct bool
+		items    []selectItem
+		from     *fromItem // nil if there's no FROM clause
+		where    expr
+		groupBy  []expr
+		having   expr
+		orderBy  []orderItem
+		limit    expr
+		offset   expr
+	}
+
+	selectItem struct {
+		star  bool   // * or alias.*
+		x     expr   // if not star
+		alias string // empty if there's no alias
+	}
+
+	fromItem struct {
+		table []string     // the parts of a table name
+		sub   *selectQuery // or a subquery
+		alias string
+	}
+
+	orderItem struct {
+		x    expr
+		desc bool
+	}
+
+	expr interface{}
+
+	literal struct {
+		v   value
+		typ string // empty for NULL
+	}
+	columnRef struct {
+		path []string
+	}
+	paramRef struct {
+		name  string // empty for a positional parameter
+		index int    // the index of a positional parameter
+	}
+	fieldAccess struct {
+		x    expr
+		name string
+	}
+	unaryExpr struct {
+		op string // "-" or "NOT"
+		x  expr
+	}
+	binaryExpr struct {
+		op   string // upper case for keywords, like "AND" and "LIKE"
+		l, r expr
+	}
+	isExpr struct {
+		x   expr
+		not bool
+		v   literal // NULL, TRUE or FALSE
+	}
+	inExpr struct {
+		x      expr
+		list   []expr
+		unnest expr // the array of IN UNNEST(array), instead of list
+		not    bool
+	}
+	betweenExpr struct {
+		x, lo, hi expr
+		not       bool
+	}
+	callExpr struct {
+		name     string // upper case
+		args     []expr
+		star     bool // COUNT(*)
+		distinct bool
+	}
+	caseExpr struct {
+		operand expr // nil for a searched CASE
+		whens   []whenClause
+		els     expr
+	}
+	whenClause struct {
+		cond, result expr
+	}
+	castExpr struct {
+		x    expr
+		typ  string
+		safe bool
+	}
+	arrayExpr struct {
+		elems []expr
+	}
+)
+
+// parser is a recursive descent parser of queries.
+type parser struct {
+	toks []token
+	i    int
+	// The number of positional parameters seen so far.
+	positional int
+}
+
+// parseQuery parses the text of a query.
+func parseQuery(src string) (*selectQuery, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	q, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	p.accept(";")
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", t.text)
+	}
+	return q, nil
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the next token if it's the keyword or operator s.
+func (p *parser) accept(s string) bool {
+	if p.peek().is(s) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.accept(s) {
+		return p.errorf("expected %s", s)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	what := strconv.Quote(t.text)
+	if t.kind == tokEOF {
+		what = "end of input"
+	}
+	return queryErrorf("Syntax error: %s, at %s (offset %d)", fmt.Sprintf(format, args...), what, t.pos)
+}
+
+// reserved are the keywords that can't be used as unquoted aliases.
+var reserved = map[string]bool{
+	"ALL": true, "AND": true, "AS": true, "ASC": true, "BETWEEN": true, "BY": true, "CASE": true,
+	"CAST": true, "CROSS": true, "DESC": true, "DISTINCT": true, "ELSE": true, "END": true,
+	"FALSE": true, "FROM": true, "FULL": true, "GROUP": true, "HAVING": true, "IN": true,
+	"INNER": true, "IS": true, "JOIN": true, "LEFT": true, "LIKE": true, "LIMIT": true,
+	"NOT": true, "NULL": true, "OFFSET": true, "ON": true, "OR": true, "ORDER": true,
+	"RIGHT": true, "SELECT": true, "THEN": true, "TRUE": true, "UNION": true, "UNNEST": true,
+	"WHEN": true, "WHERE": true, "WINDOW": true, "WITH": true,
+}
+
+// identifier consumes an identifier, if the next token is one.
+func (p *parser) identifier() (string, bool) {
+	t := p.peek()
+	if t.kind == tokQuotedIdent || (t.kind == tokIdent && !reserved[strings.ToUpper(t.text)]) {
+		p.i++
+		return t.text, true
+	}
+	return "", false
+}
+
+// alias parses an optional alias, with or without AS.
+func (p *parser) alias() (string, error) {
+	as := p.accept("AS")
+	name, ok := p.identifier()
+	if as && !ok {
+		return "", p.errorf("expected an alias")
+	}
+	return name, nil
+}
+
+func (p *parser) parseSelect() (*selectQuery, error) {
+	if p.peek().is("WITH") {
+		return nil, p.errorf("WITH clauses are not supported")
+	}
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	q := &selectQuery{}
+	if p.accept("DISTINCT") {
+		q.distinct = true
+	} else {
+		p.accept("ALL")
+	}
+	for {
+		item, err := p.parseSelectItem()
+		if err != nil {
+			return nil, err
+		}
+		q.items = append(q.items, item)
+		if !p.accept(",") {
+			break
+		}
+	}
+	var err error
+	if p.accept("FROM") {
+		if q.from, err = p.parseFrom(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("WHERE") {
+		if q.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("GROUP") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		if q.groupBy, err = p.parseExprList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("HAVING") {
+		if q.having, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek().is("UNION") {
+		return nil, p.errorf("set operations are not supported")
+	}
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			item := orderItem{x: x}
+			if p.accept("DESC") {
+				item.desc = true
+			} else {
+				p.accept("ASC")
+			}
+			q.orderBy = append(q.orderBy, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("LIMIT") {
+		if q.limit, err = p.parsePrimary(); err != nil {
+			return nil, err
+		}
+		if p.accept("OFFSET") {
+			if q.offset, err = p.parsePrimary(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return q, nil
+}
+
+func (p *parser) parseSelectItem() (selectItem, error) {
+	if p.accept("*") {
+		return selectItem{star: true}, nil
+	}
+	// alias.*
+	if t := p.peek(); (t.kind == tokIdent || t.kind == tokQuotedIdent) &&
+		p.toks[p.i+1].is(".") && p.toks[p.i+2].is("*") {
+		p.i += 3
+		return selectItem{star: true}, nil
+	}
+	x, err := p.parseExpr()
+	if err != nil {
+		return selectItem{}, err
+	}
+	alias, err := p.alias()
+	if err != nil {
+		return selectItem{}, err
+	}
+	return selectItem{x: x, alias: alias}, nil
+}
+
+func (p *parser) parseFrom() (*fromItem, error) {
+	f := &fromItem{}
+	if p.accept("(") {
+		sub, err := p.parseSelect()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		f.sub = sub
+	} else {
+		for {
+			name, ok := p.identifier()
+			if !ok {
+				return nil, p.errorf("expected a table name")
+			}
+			// A quoted name may hold several parts, like `project.dataset.table`.
+			f.table = append(f.table, strings.Split(name, ".")...)
+			if !p.accept(".") {
+				break
+			}
+		}
+	}
+	alias, err := p.alias()
+	if err != nil {
+		return nil, err
+	}
+	f.alias = alias
+	if t := p.peek(); t.is(",") || t.is("JOIN") || t.is("CROSS") || t.is("INNER") || t.is("LEFT") || t.is("RIGHT") || t.is("FULL") {
+		return nil, p.errorf("joins are not supported")
+	}
+	return f, nil
+}
+
+func (p *parser) parseExprList() ([]expr, error) {
+	var xs []expr
+	for {
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, x)
+		if !p.accept(",") {
+			return xs, nil
+		}
+	}
+}
+
+// Expressions, in increasing order of precedence.
+
+func (p *parser) parseExpr() (expr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: "OR", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: "AND", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.accept("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "NOT", x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	l, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch {
+	case t.is("=") || t.is("!=") || t.is("<>") || t.is("<") || t.is("<=") || t.is(">") || t.is(">="):
+		p.i++
+		r, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		op := t.text
+		if op == "<>" {
+			op = "!="
+		}
+		return &binaryExpr{op: op, l: l, r: r}, nil
+	case t.is("IS"):
+		p.i++
+		x := &isExpr{x: l, not: p.accept("NOT")}
+		switch {
+		case p.accept("NULL"):
+		case p.accept("TRUE"):
+			x.v = literal{v: true, typ: typeBoolean}
+		case p.accept("FALSE"):
+			x.v = literal{v: false, typ: typeBoolean}
+		default:
+			return nil, p.errorf("expected NULL, TRUE or FALSE")
+		}
+		return x, nil
+	}
+	not := false
+	if t.is("NOT") {
+		if n := p.toks[p.i+1]; n.is("IN") || n.is("LIKE") || n.is("BETWEEN") {
+			p.i++
+			not = true
+		}
+	}
+	switch {
+	case p.accept("IN"):
+		x := &inExpr{x: l, not: not}
+		if p.accept("UNNEST") {
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			if x.unnest, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		if x.list, err = p.parseExprList(); err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case p.accept("LIKE"):
+		r, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		var x expr = &binaryExpr{op: "LIKE", l: l, r: r}
+		if not {
+			x = &unaryExpr{op: "NOT", x: x}
+		}
+		return x, nil
+	case p.accept("BETWEEN"):
+		lo, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		hi, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		return &betweenExpr{x: l, lo: lo, hi: hi, not: not}, nil
+	}
+	return l, nil
+}
+
+func (p *parser) parseConcat() (expr, error) {
+	l, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAdditive() (expr, error) {
+	l, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if !t.is("+") && !t.is("-") {
+			return l, nil
+		}
+		p.i++
+		r, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: t.text, l: l, r: r}
+	}
+}
+
+func (p *parser) parseMultiplicative() (expr, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if !t.is("*") && !t.is("/") {
+			return l, nil
+		}
+		p.i++
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: t.text, l: l, r: r}
+	}
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.accept("-") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		// Fold negative numbers, so that the smallest INT64 can be written.
+		if l, ok := x.(*literal); ok {
+			switch v := l.v.(type) {
+			case int64:
+				return &literal{v: -v, typ: l.typ}, nil
+			case float64:
+				return &literal{v: -v, typ: l.typ}, nil
+			}
+		}
+		return &unaryExpr{op: "-", x: x}, nil
+	}
+	if p.accept("+") {
+		return p.parseUnary()
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (expr, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(".") {
+		name, ok := p.identifier()
+		if !ok {
+			return nil, p.errorf("expected a field name")
+		}
+		if c, ok := x.(*columnRef); ok {
+			c.path = append(c.path, name)
+		} else {
+			x = &fieldAccess{x: x, name: name}
+		}
+	}
+	return x, nil
+}
+
+// typedLiterals are the types of literals like DATE '2006-01-02'.
+var typedLiterals = map[string]string{
+	"DATE":       typeDate,
+	"TIME":       typeTime,
+	"DATETIME":   typeDateTime,
+	"TIMESTAMP":  typeTimestamp,
+	"NUMERIC":    typeNumeric,
+	"BIGNUMERIC": typeBigNumeric,
+	"JSON":       typeJSON,
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokInt:
+		p.i++
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			// Too big for an INT64.
+			f, err := strconv.ParseFloat(t.text, 64)
+			if err != nil {
+				return nil, queryErrorf("bad number %q", t.text)
+			}
+			return &literal{v: f, typ: typeFloat}, nil
+		}
+		return &literal{v: n, typ: typeInteger}, nil
+	case tokFloat:
+		p.i++
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, queryErrorf("bad number %q", t.text)
+		}
+		return &literal{v: f, typ: typeFloat}, nil
+	case tokString:
+		p.i++
+		return &literal{v: t.text, typ: typeString}, nil
+	case tokBytes:
+		p.i++
+		return &literal{v: []byte(t.text), typ: typeBytes}, nil
+	case tokNamedParam:
+		p.i++
+		return &paramRef{name: t.text}, nil
+	case tokPositionalParam:
+		p.i++
+		p.positional++
+		return &paramRef{index: p.positional - 1}, nil
+	case tokQuotedIdent:
+		p.i++
+		return &columnRef{path: strings.Split(t.text, ".")}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			p.i++
+			if p.peek().is("SELECT") {
+				return nil, p.errorf("subqueries in expressions are not supported")
+			}
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			p.i++
+			a := &arrayExpr{}
+			if !p.accept("]") {
+				elems, err := p.parseExprList()
+				if err != nil {
+					return nil, err
+				}
+				a.elems = elems
+				if err := p.expect("]"); err != nil {
+					return nil, err
+				}
+			}
+			return a, nil
+		}
+		return nil, p.errorf("unexpected %q", t.text)
+	case tokEOF:
+		return nil, p.errorf("unexpected end of query")
+	}
+
+	// An identifier or keyword.
+	word := strings.ToUpper(t.text)
+	switch word {
+	case "NULL":
+		p.i++
+		return &literal{}, nil
+	case "TRUE", "FALSE":
+		p.i++
+		return &literal{v: word == "TRUE", typ: typeBoolean}, nil
+	case "CASE":
+		p.i++
+		return p.parseCase()
+	case "CAST", "SAFE_CAST":
+		p.i++
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("AS"); err != nil {
+			return nil, err
+		}
+		typ := p.next()
+		if typ.kind != tokIdent {
+			return nil, p.errorf("expected a type")
+		}
+		return &castExpr{x: x, typ: canonicalType(typ.text), safe: word == "SAFE_CAST"}, p.expect(")")
+	case "ARRAY":
+		if p.toks[p.i+1].is("[") {
+			p.i++
+			return p.parsePrimary()
+		}
+	case "CURRENT_TIMESTAMP", "CURRENT_DATE", "CURRENT_DATETIME", "CURRENT_TIME":
+		if !p.toks[p.i+1].is("(") {
+			p.i++
+			return &callExpr{name: word}, nil
+		}
+	}
+	if typ, ok := typedLiterals[word]; ok && p.toks[p.i+1].kind == tokString {
+		s := p.toks[p.i+1].text
+		p.i += 2
+		v, err := parseString(typ, s)
+		if err != nil {
+			return nil, queryErrorf("invalid %s literal: %v", word, err)
+		}
+		return &literal{v: v, typ: typ}, nil
+	}
+	if reserved[word] {
+		return nil, p.errorf("unexpected keyword %s", word)
+	}
+	p.i++
+	if !p.accept("(") {
+		return &columnRef{path: []string{t.text}}, nil
+	}
+	call := &callExpr{name: word}
+	if p.accept(")") {
+		return call, nil
+	}
+	if p.accept("*") {
+		call.star = true
+		return call, p.expect(")")
+	}
+	call.distinct = p.accept("DISTINCT")
+	args, err := p.parseExprList()
+	if err != nil {
+		return nil, err
+	}
+	call.args = args
+	return call, p.expect(")")
+}
+
+func (p *parser) parseCase() (expr, error) {
+	c := &caseExpr{}
+	var err error
+	if !p.peek().is("WHEN") {
+		if c.operand, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	for p.accept("WHEN") {
+		var w whenClause
+		if w.cond, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("THEN"); err != nil {
+			return nil, err
+		}
+		if w.result, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		c.whens = append(c.whens, w)
+	}
+	if len(c.whens) == 0 {
+		return nil, p.errorf("expected WHEN")
+	}
+	if p.accept("ELSE") {
+		if c.els, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return c, p.expect("END")
+}
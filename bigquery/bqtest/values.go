@@ -0,0 +1,461 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A value is a cell of a row, held as a Go value whose type depends on the
+// column's type:
+//
+//	STRING, JSON, GEOGRAPHY, INTERVAL  string
+//	BYTES                              []byte
+//	INTEGER                            int64
+//	FLOAT                              float64
+//	BOOLEAN                            bool
+//	TIMESTAMP                          time.Time, in UTC
+//	DATE                               civil.Date
+//	TIME                               civil.Time
+//	DATETIME                           civil.DateTime
+//	NUMERIC, BIGNUMERIC                *big.Rat
+//	RECORD                             []value, one for each field
+//
+// A repeated column holds a []value of its elements. NULL is nil. Values are
+// never modified once they're stored.
+type value = interface{}
+
+// Column types, as the backend names them.
+const (
+	typeString     = "STRING"
+	typeBytes      = "BYTES"
+	typeInteger    = "INTEGER"
+	typeFloat      = "FLOAT"
+	typeBoolean    = "BOOLEAN"
+	typeTimestamp  = "TIMESTAMP"
+	typeDate       = "DATE"
+	typeTime       = "TIME"
+	typeDateTime   = "DATETIME"
+	typeNumeric    = "NUMERIC"
+	typeBigNumeric = "BIGNUMERIC"
+	typeRecord     = "RECORD"
+	typeJSON       = "JSON"
+	typeGeography  = "GEOGRAPHY"
+	typeInterval   = "INTERVAL"
+)
+
+// canonicalType returns the backend's name for a column or parameter type,
+// which may be given with its Standard SQL name.
+func canonicalType(t string) string {
+	switch t = strings.ToUpper(t); t {
+	case "INT64":
+		return typeInteger
+	case "FLOAT64":
+		return typeFloat
+	case "BOOL":
+		return typeBoolean
+	case "STRUCT":
+		return typeRecord
+	case "DECIMAL":
+		return typeNumeric
+	case "BIGDECIMAL":
+		return typeBigNumeric
+	}
+	return t
+}
+
+func isRepeated(f *bq.TableFieldSchema) bool { return f.Mode == "REPEATED" }
+func isRequired(f *bq.TableFieldSchema) bool { return f.Mode == "REQUIRED" }
+
+// numericScale returns the number of digits after the decimal point kept by
+// values of a NUMERIC or BIGNUMERIC column.
+func numericScale(typ string) int {
+	if typ == typeBigNumeric {
+		return 38
+	}
+	return 9
+}
+
+// validateSchema checks that the fields of a table's schema have names and
+// known types, and that no two have the same name.
+func validateSchema(fields []*bq.TableFieldSchema) error {
+	seen := map[string]bool{}
+	for _, f := range fields {
+		if f.Name == "" {
+			return status.Errorf(codes.InvalidArgument, "a schema field has no name")
+		}
+		if seen[strings.ToLower(f.Name)] {
+			return status.Errorf(codes.InvalidArgument, "duplicate column name %q", f.Name)
+		}
+		seen[strings.ToLower(f.Name)] = true
+		switch f.Mode {
+		case "", "NULLABLE", "REQUIRED", "REPEATED":
+		default:
+			return status.Errorf(codes.InvalidArgument, "field %s has unknown mode %q", f.Name, f.Mode)
+		}
+		switch canonicalType(f.Type) {
+		case typeString, typeBytes, typeInteger, typeFloat, typeBoolean, typeTimestamp, typeDate,
+			typeTime, typeDateTime, typeNumeric, typeBigNumeric, typeJSON, typeGeography, typeInterval:
+			if len(f.Fields) > 0 {
+				return status.Errorf(codes.InvalidArgument, "field %s of type %s has subfields", f.Name, f.Type)
+			}
+		case typeRecord:
+			if len(f.Fields) == 0 {
+				return status.Errorf(codes.InvalidArgument, "field %s of type RECORD has no subfields", f.Name)
+			}
+			if err := validateSchema(f.Fields); err != nil {
+				return err
+			}
+		default:
+			return status.Errorf(codes.InvalidArgument, "field %s has unknown type %q", f.Name, f.Type)
+		}
+	}
+	return nil
+}
+
+// fieldIndex returns the index of the field with the given name, ignoring
+// case, or -1.
+func fieldIndex(fields []*bq.TableFieldSchema, name string) int {
+	for i, f := range fields {
+		if strings.EqualFold(f.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseRow converts a row in the JSON form of insertAll requests and
+// newline-delimited JSON files to a row of values. Numbers must have been
+// decoded as json.Numbers. Unknown fields are an error unless ignoreUnknown
+// is true.
+func parseRow(fields []*bq.TableFieldSchema, m map[string]interface{}, ignoreUnknown bool) ([]value, error) {
+	row := make([]value, len(fields))
+	for name, v := range m {
+		i := fieldIndex(fields, name)
+		if i < 0 {
+			if ignoreUnknown {
+				continue
+			}
+			return nil, fmt.Errorf("no such field: %s", name)
+		}
+		pv, err := parseField(fields[i], v, ignoreUnknown)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = pv
+	}
+	for i, f := range fields {
+		if row[i] == nil && isRequired(f) {
+			return nil, fmt.Errorf("missing required field: %s", f.Name)
+		}
+	}
+	return row, nil
+}
+
+func parseField(f *bq.TableFieldSchema, v interface{}, ignoreUnknown bool) (value, error) {
+	if isRepeated(f) {
+		if v == nil {
+			return []value{}, nil
+		}
+		vs, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("field %s: array expected, got %v", f.Name, v)
+		}
+		elems := make([]value, len(vs))
+		for i, e := range vs {
+			if e == nil {
+				return nil, fmt.Errorf("field %s: NULL array element", f.Name)
+			}
+			pv, err := parseElem(f, e, ignoreUnknown)
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = pv
+		}
+		return elems, nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	return parseElem(f, v, ignoreUnknown)
+}
+
+func parseElem(f *bq.TableFieldSchema, v interface{}, ignoreUnknown bool) (value, error) {
+	typ := canonicalType(f.Type)
+	if typ == typeRecord {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("field %s: object expected, got %v", f.Name, v)
+		}
+		row, err := parseRow(f.Fields, m, ignoreUnknown)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", f.Name, err)
+		}
+		return row, nil
+	}
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	case float64:
+		s = strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		if typ != typeJSON {
+			return nil, fmt.Errorf("field %s: cannot convert %v to %s", f.Name, v, typ)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+	if typ == typeTimestamp {
+		if _, ok := v.(json.Number); ok {
+			// A number of seconds since the epoch.
+			secs, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %v", f.Name, err)
+			}
+			return time.UnixMicro(int64(math.Round(secs * 1e6))).UTC(), nil
+		}
+	}
+	pv, err := parseString(typ, s)
+	if err != nil {
+		return nil, fmt.Errorf("field %s: %v", f.Name, err)
+	}
+	return pv, nil
+}
+
+// Layouts of TIMESTAMP values, after those of time.RFC3339Nano.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999 MST",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parseString converts the string form of a value of a scalar type, as it
+// appears in query parameters, CSV files and literals, to a value.
+func parseString(typ, s string) (value, error) {
+	switch typ {
+	case typeString, typeJSON, typeGeography, typeInterval:
+		return s, nil
+	case typeBytes:
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad BYTES value %q", s)
+		}
+		return b, nil
+	case typeInteger:
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad INTEGER value %q", s)
+		}
+		return n, nil
+	case typeFloat:
+		switch s {
+		case "NaN":
+			return math.NaN(), nil
+		case "Infinity", "inf", "+inf":
+			return math.Inf(1), nil
+		case "-Infinity", "-inf":
+			return math.Inf(-1), nil
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad FLOAT value %q", s)
+		}
+		return f, nil
+	case typeBoolean:
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("bad BOOLEAN value %q", s)
+	case typeTimestamp:
+		s := strings.TrimSpace(s)
+		if strings.HasSuffix(s, " UTC") {
+			s = strings.TrimSuffix(s, " UTC") + "Z"
+			s = strings.Replace(s, " ", "T", 1)
+		}
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t.UTC().Truncate(time.Microsecond), nil
+			}
+		}
+		return nil, fmt.Errorf("bad TIMESTAMP value %q", s)
+	case typeDate:
+		d, err := civil.ParseDate(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad DATE value %q", s)
+		}
+		return d, nil
+	case typeTime:
+		t, err := civil.ParseTime(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad TIME value %q", s)
+		}
+		t.Nanosecond -= t.Nanosecond % 1000
+		return t, nil
+	case typeDateTime:
+		dt, err := civil.ParseDateTime(strings.Replace(s, " ", "T", 1))
+		if err != nil {
+			return nil, fmt.Errorf("bad DATETIME value %q", s)
+		}
+		dt.Time.Nanosecond -= dt.Time.Nanosecond % 1000
+		return dt, nil
+	case typeNumeric, typeBigNumeric:
+		r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+		if !ok {
+			return nil, fmt.Errorf("bad %s value %q", typ, s)
+		}
+		return roundRat(r, numericScale(typ)), nil
+	}
+	return nil, fmt.Errorf("cannot convert a string to %s", typ)
+}
+
+// roundRat rounds r to scale digits after the decimal point.
+func roundRat(r *big.Rat, scale int) *big.Rat {
+	if r.IsInt() {
+		return r
+	}
+	res, _ := new(big.Rat).SetString(r.FloatString(scale))
+	return res
+}
+
+// formatRow returns a row in the form of tabledata.list and getQueryResults
+// responses.
+func formatRow(fields []*bq.TableFieldSchema, row []value) *bq.TableRow {
+	tr := &bq.TableRow{F: make([]*bq.TableCell, len(fields))}
+	for i, f := range fields {
+		tr.F[i] = &bq.TableCell{V: formatField(f, row[i])}
+	}
+	return tr
+}
+
+func formatField(f *bq.TableFieldSchema, v value) interface{} {
+	if isRepeated(f) {
+		vs, _ := v.([]value)
+		cells := make([]interface{}, len(vs))
+		for i, e := range vs {
+			cells[i] = map[string]interface{}{"v": formatElem(f, e)}
+		}
+		return cells
+	}
+	return formatElem(f, v)
+}
+
+func formatElem(f *bq.TableFieldSchema, v value) interface{} {
+	if v == nil {
+		return nil
+	}
+	if canonicalType(f.Type) == typeRecord {
+		return formatRow(f.Fields, v.([]value))
+	}
+	return formatScalar(canonicalType(f.Type), v)
+}
+
+// formatScalar returns the string form of a value of a scalar type, as
+// it's sent in row data.
+func formatScalar(typ string, v value) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		// Seconds since the epoch.
+		return strconv.FormatFloat(float64(v.UnixMicro())/1e6, 'f', 6, 64)
+	case civil.Date, civil.Time, civil.DateTime:
+		return fmt.Sprint(v)
+	case *big.Rat:
+		s := v.FloatString(numericScale(typ))
+		if strings.Contains(s, ".") {
+			s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+		}
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// valueKey returns a string that is the same for two values if and only if
+// they're equal. It's used to group rows and to find distinct rows.
+func valueKey(v value) string {
+	var buf bytes.Buffer
+	writeKey(&buf, v)
+	return buf.String()
+}
+
+func writeKey(buf *bytes.Buffer, v value) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("N;")
+	case []value:
+		buf.WriteString("[")
+		for _, e := range v {
+			writeKey(buf, e)
+		}
+		buf.WriteString("];")
+	case *big.Rat:
+		fmt.Fprintf(buf, "R%s;", v.RatString())
+	case float64:
+		// Integers and floats compare equal when their values are equal.
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			fmt.Fprintf(buf, "R%d;", int64(v))
+		} else {
+			fmt.Fprintf(buf, "F%v;", v)
+		}
+	case int64:
+		fmt.Fprintf(buf, "R%d;", v)
+	case time.Time:
+		fmt.Fprintf(buf, "T%d;", v.UnixMicro())
+	default:
+		fmt.Fprintf(buf, "%T%q;", v, fmt.Sprint(v))
+	}
+}
@@ -105,6 +105,24 @@ func ExampleNewGCSReference() {
 	fmt.Println(gcsRef)
 }
 
+func ExampleClient_EnableStorageReadClient() {
+	ctx := context.Background()
+	client, err := bigquery.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	if err := client.EnableStorageReadClient(ctx); err != nil {
+		// TODO: Handle error.
+	}
+	// Query results are now read with the Storage Read API.
+	q := client.Query("select name, num from t1")
+	it, err := q.Read(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	_ = it // TODO: iterate using Next.
+}
+
 func ExampleClient_Query() {
 	ctx := context.Background()
 	client, err := bigquery.NewClient(ctx, "project-id")
@@ -52,6 +52,15 @@ type RowIterator struct {
 	// is also set, StartIndex is ignored.
 	StartIndex uint64
 
+	// PreserveOrder can be set before the first call to Next. It only
+	// matters when the rows of a table are read with the Storage Read API
+	// (see Client.EnableStorageReadClient), which reads several streams in
+	// parallel and returns their rows in no particular order. If
+	// PreserveOrder is true, the rows are read from a single stream instead,
+	// in the order of the table. The results of a query with an ORDER BY
+	// clause are always read in order.
+	PreserveOrder bool
+
 	// The schema of the table. Available after the first call to Next.
 	Schema Schema
 
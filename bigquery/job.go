@@ -328,12 +328,45 @@ func (j *Job) read(ctx context.Context, waitForQuery func(context.Context, strin
 		jobID:     j.jobID,
 		location:  j.location,
 	}
+	if j.c.isStorageReadAvailable() {
+		dst, ordered, err := j.queryDestination(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if dst != nil {
+			return newStorageIterator(ctx, j.c.rc, &rowSource{j: itJob}, dst, ordered, schema, totalRows), nil
+		}
+	}
 	it := newRowIterator(ctx, &rowSource{j: itJob}, pf)
 	it.Schema = schema
 	it.TotalRows = totalRows
 	return it, nil
 }
 
+// queryDestination returns the table that holds the results of a completed
+// query job, or nil if the results can't be read from a table, as for
+// scripts. ordered is true unless the query is known not to order its
+// results.
+func (j *Job) queryDestination(ctx context.Context) (dst *Table, ordered bool, err error) {
+	cfg := j.config
+	if cfg == nil || cfg.Query == nil || cfg.Query.DestinationTable == nil {
+		bqjob, err := j.c.getJobInternal(ctx, j.jobID, j.location, j.projectID, "configuration", "statistics")
+		if err != nil {
+			return nil, false, err
+		}
+		if bqjob.Statistics != nil && bqjob.Statistics.NumChildJobs > 0 {
+			// The results of a script are those of its last statement.
+			return nil, false, nil
+		}
+		cfg = bqjob.Configuration
+	}
+	if cfg == nil || cfg.Query == nil || cfg.Query.DestinationTable == nil {
+		return nil, false, nil
+	}
+	dst = bqToTable(cfg.Query.DestinationTable, j.c)
+	return dst, cfg.Query.Query == "" || queryMayBeOrdered(cfg.Query.Query), nil
+}
+
 // waitForQuery waits for the query job to complete and returns its schema. It also
 // returns the total number of rows in the result set.
 func (j *Job) waitForQuery(ctx context.Context, projectID string) (Schema, uint64, error) {
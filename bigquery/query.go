@@ -394,7 +394,9 @@ func (q *Query) Read(ctx context.Context) (it *RowIterator, err error) {
 		location:  resp.JobReference.Location,
 		projectID: resp.JobReference.ProjectId,
 	}
-	if resp.JobComplete {
+	// Results of more than one page are read with the Storage Read API, if
+	// it's enabled.
+	if resp.JobComplete && (resp.PageToken == "" || !q.client.isStorageReadAvailable()) {
 		rowSource := &rowSource{
 			j: minimalJob,
 			// RowIterator can precache results from the iterator to save a lookup.
@@ -404,8 +406,8 @@ func (q *Query) Read(ctx context.Context) (it *RowIterator, err error) {
 		}
 		return newRowIterator(ctx, rowSource, fetchPage), nil
 	}
-	// We're on the fastPath, but we need to poll because the job is incomplete.
-	// Fallback to job-based Read().
+	// We're on the fastPath, but we need to poll because the job is incomplete,
+	// or read the results with the Storage Read API. Fallback to job-based Read().
 	//
 	// (Issue 2937) In order to satisfy basic probing of the job in classic path,
 	// we need to supply additional config which is probed for presence, not contents.
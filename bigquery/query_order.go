@@ -0,0 +1,151 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import "strings"
+
+// queryMayBeOrdered reports whether the results of the SQL query q may be
+// ordered, so that they must be read from a single stream to keep their order.
+//
+// It looks for ORDER BY in the tokens of the query, skipping comments, string
+// literals and quoted identifiers. ORDER BY in a window specification or in the
+// arguments of a function, as in ARRAY_AGG(x ORDER BY y), doesn't order the
+// results, and is ignored. Any other ORDER BY, even one in a subquery, may
+// order them.
+func queryMayBeOrdered(q string) bool {
+	var (
+		// For each open parenthesis, whether it starts a window specification
+		// or the arguments of a function.
+		parens []bool
+		inArgs int    // the number of true entries in parens
+		prev   string // the previous token if a word, upper-cased; otherwise ""
+		ident  bool   // whether the previous token was a quoted identifier
+	)
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+			continue // whitespace doesn't separate ORDER from BY
+		case c == '#' || strings.HasPrefix(q[i:], "--"):
+			if n := strings.IndexByte(q[i:], '\n'); n >= 0 {
+				i += n + 1
+			} else {
+				i = len(q)
+			}
+			continue
+		case strings.HasPrefix(q[i:], "/*"):
+			if n := strings.Index(q[i+2:], "*/"); n >= 0 {
+				i += n + 4
+			} else {
+				i = len(q)
+			}
+			continue
+		case c == '\'' || c == '"':
+			i = skipQuoted(q, i)
+			prev, ident = "", false
+			continue
+		case c == '`':
+			i = skipQuoted(q, i)
+			prev, ident = "", true
+			continue
+		case isWordByte(c):
+			j := i
+			for j < len(q) && isWordByte(q[j]) {
+				j++
+			}
+			word := strings.ToUpper(q[i:j])
+			i = j
+			if i < len(q) && (q[i] == '\'' || q[i] == '"') && isStringPrefix(word) {
+				i = skipQuoted(q, i)
+				prev, ident = "", false
+				continue
+			}
+			if word == "BY" && prev == "ORDER" && inArgs == 0 {
+				return true
+			}
+			prev, ident = word, false
+			continue
+		case c == '(':
+			args := ident || prev == "OVER" || (prev != "" && !reservedKeywords[prev])
+			parens = append(parens, args)
+			if args {
+				inArgs++
+			}
+		case c == ')':
+			if n := len(parens); n > 0 {
+				if parens[n-1] {
+					inArgs--
+				}
+				parens = parens[:n-1]
+			}
+		}
+		i++
+		prev, ident = "", false
+	}
+	return false
+}
+
+// skipQuoted returns the index after the quoted string or identifier that
+// starts at q[i], or len(q) if it isn't terminated. Backslashes escape the
+// following character, even in raw strings, which can't contain an
+// unescaped quote either.
+func skipQuoted(q string, i int) int {
+	quote := q[i : i+1]
+	if c := q[i]; c != '`' && strings.HasPrefix(q[i:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+	for i += len(quote); i < len(q); i++ {
+		if q[i] == '\\' {
+			i++
+		} else if strings.HasPrefix(q[i:], quote) {
+			return i + len(quote)
+		}
+	}
+	return len(q)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// isStringPrefix reports whether the upper-cased word is the prefix of a raw
+// or bytes string literal.
+func isStringPrefix(word string) bool {
+	switch word {
+	case "R", "B", "RB", "BR":
+		return true
+	}
+	return false
+}
+
+// reservedKeywords are the reserved keywords of GoogleSQL. A word before an
+// opening parenthesis that isn't one of them is the name of a function.
+var reservedKeywords = map[string]bool{}
+
+func init() {
+	for _, k := range strings.Fields(`
+		ALL AND ANY ARRAY AS ASC ASSERT_ROWS_MODIFIED AT BETWEEN BY CASE CAST
+		COLLATE CONTAINS CREATE CROSS CUBE CURRENT DEFAULT DEFINE DESC DISTINCT
+		ELSE END ENUM ESCAPE EXCEPT EXCLUDE EXISTS EXTRACT FALSE FETCH FOLLOWING
+		FOR FROM FULL GROUP GROUPING GROUPS HASH HAVING IF IGNORE IN INNER
+		INTERSECT INTERVAL INTO IS JOIN LATERAL LEFT LIKE LIMIT LOOKUP MERGE
+		NATURAL NEW NO NOT NULL NULLS OF ON OR ORDER OUTER OVER PARTITION
+		PRECEDING PROTO QUALIFY RANGE RECURSIVE RESPECT RIGHT ROLLUP ROWS SELECT
+		SET SOME STRUCT TABLESAMPLE THEN TO TREAT TRUE UNBOUNDED UNION UNNEST
+		USING WHEN WHERE WINDOW WITH WITHIN`) {
+		reservedKeywords[k] = true
+	}
+}
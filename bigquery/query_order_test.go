@@ -0,0 +1,54 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import "testing"
+
+func TestQueryMayBeOrdered(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT a FROM t", false},
+		{"SELECT a FROM t ORDER BY a", true},
+		{"select a from t order\n\tby a desc limit 10", true},
+		{"SELECT a FROM t ORDER /* c */ BY a", true},
+		{"SELECT a FROM t ORDER -- c\nBY a", true},
+		{"SELECT a FROM t ORDER # c\nBY a", true},
+		{"SELECT a FROM t UNION ALL SELECT b FROM u ORDER BY 1", true},
+		{"(SELECT a FROM t ORDER BY a)", true},
+		{"SELECT * FROM (SELECT a FROM t ORDER BY a LIMIT 5)", true},
+		{"WITH w AS (SELECT a FROM t ORDER BY a) SELECT * FROM w", true},
+		{"SELECT a, ROW_NUMBER() OVER (PARTITION BY b ORDER BY c) FROM t", false},
+		{"SELECT a, ROW_NUMBER() OVER (ORDER BY c) FROM t ORDER BY a", true},
+		{"SELECT ARRAY_AGG(a ORDER BY b) FROM t", false},
+		{"SELECT `my-proj.ds.f`(a ORDER BY b) FROM t", false},
+		{"SELECT STRING_AGG(a, ',' ORDER BY b) AS s FROM t", false},
+		{"SELECT 'ORDER BY' FROM t", false},
+		{`SELECT "order by", r'\' order by' FROM t`, false},
+		{`SELECT """ORDER BY""", '''a ' order by''' FROM t`, false},
+		{"SELECT `order` FROM t ORDER BY `order`", true},
+		{"SELECT `order by` FROM t", false},
+		{"SELECT a AS order_by FROM t", false},
+		{"SELECT a FROM t -- ORDER BY a", false},
+		{"SELECT a FROM t /* ORDER BY a */", false},
+		{"SELECT a FROM t WHERE b IN (SELECT c FROM u ORDER BY c LIMIT 1)", true},
+		{"SELECT a FROM [proj:ds.t] ORDER BY a", true},
+	} {
+		if got := queryMayBeOrdered(test.query); got != test.want {
+			t.Errorf("%q: got %t, want %t", test.query, got, test.want)
+		}
+	}
+}
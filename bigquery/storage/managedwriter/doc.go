@@ -142,6 +142,28 @@ an error.
 			// TODO: Handle error.
 		}
 
+# Writing Go Values
+
+To write rows without defining a protocol buffer message, create a RowWriter from the
+table's schema. Its Append method accepts the same values as the Inserter in
+cloud.google.com/go/bigquery: structs, ValueSavers, and maps of column names to values.
+
+	type Item struct {
+		Name  string
+		Count int
+	}
+	schema, err := bigquery.InferSchema(Item{})
+	if err != nil {
+		// TODO: Handle error.
+	}
+	rowWriter, err := client.NewRowWriter(ctx, schema,
+		WithDestinationTable(tableName),
+		WithType(managedwriter.CommittedStream))
+	if err != nil {
+		// TODO: Handle error.
+	}
+	result, err := rowWriter.Append(ctx, []*Item{{Name: "n1", Count: 3}}, WithOffset(0))
+
 # Buffered Stream Management
 
 For Buffered streams, users control when data is made visible in the destination table/stream
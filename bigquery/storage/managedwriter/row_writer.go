@@ -0,0 +1,506 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package managedwriter
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"cloud.google.com/go/civil"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// RowWriter is a ManagedStream that appends rows of Go values rather than
+// serialized protocol buffer messages. It encodes each row as a message of
+// the descriptor that adapt derives from the table's schema, and registers
+// that descriptor with the stream.
+//
+// The rows are converted with the same rules as the Inserter of
+// cloud.google.com/go/bigquery, so types that can be inserted with an Inserter
+// can be appended with a RowWriter. JSON and INTERVAL columns aren't
+// supported by the Storage Write API descriptors, and insert IDs are ignored;
+// use offsets with a committed or pending stream to append rows exactly once.
+type RowWriter struct {
+	*ManagedStream
+	enc *rowEncoder
+}
+
+// NewRowWriter creates a ManagedStream that appends rows of the table with
+// the given schema. It is configured with opts, as for NewManagedStream,
+// except that the schema descriptor is always the one derived from schema.
+//
+// The schema of an existing table can be found with Table.Metadata, and that
+// of a struct type with bigquery.InferSchema.
+func (c *Client) NewRowWriter(ctx context.Context, schema bigquery.Schema, opts ...WriterOption) (*RowWriter, error) {
+	enc, err := newRowEncoder(schema)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts[:len(opts):len(opts)], WithSchemaDescriptor(enc.descriptorProto))
+	ms, err := c.NewManagedStream(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &RowWriter{ManagedStream: ms, enc: enc}, nil
+}
+
+// Append encodes rows and appends them to the stream, as a single call to
+// AppendRows. src may be one of the following:
+//
+//   - a bigquery.ValueSaver
+//   - a map[string]bigquery.Value, whose keys are column names
+//   - a struct or pointer to struct, as for bigquery.StructSaver
+//   - a slice of any of the above
+//
+// Struct fields are matched to columns as described for
+// bigquery.RowIterator.Next, and unmatched columns are NULL. Map keys must
+// be column names, ignoring case.
+func (w *RowWriter) Append(ctx context.Context, src interface{}, opts ...AppendOption) (*AppendResult, error) {
+	rows, err := w.enc.rows(src)
+	if err != nil {
+		return nil, err
+	}
+	data := make([][]byte, len(rows))
+	for i, row := range rows {
+		if data[i], err = w.enc.encode(row); err != nil {
+			return nil, fmt.Errorf("managedwriter: row %d: %w", i, err)
+		}
+	}
+	return w.AppendRows(ctx, data, opts...)
+}
+
+// rowEncoder encodes rows of a table as serialized proto2 messages.
+type rowEncoder struct {
+	schema          bigquery.Schema
+	descriptor      protoreflect.MessageDescriptor
+	descriptorProto *descriptorpb.DescriptorProto
+}
+
+func newRowEncoder(schema bigquery.Schema) (*rowEncoder, error) {
+	ts, err := adapt.BQSchemaToStorageTableSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	d, err := adapt.StorageSchemaToProto2Descriptor(ts, "root")
+	if err != nil {
+		return nil, err
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("managedwriter: adapt returned %T, not a message descriptor", d)
+	}
+	dp, err := adapt.NormalizeDescriptor(md)
+	if err != nil {
+		return nil, err
+	}
+	return &rowEncoder{schema: schema, descriptor: md, descriptorProto: dp}, nil
+}
+
+// rows converts src, as described for RowWriter.Append, to rows of column
+// values.
+func (e *rowEncoder) rows(src interface{}) ([]map[string]bigquery.Value, error) {
+	if row, ok, err := e.row(src); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return []map[string]bigquery.Value{row}, nil
+	}
+	v := reflect.ValueOf(src)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("managedwriter: %T is not a ValueSaver, map[string]bigquery.Value, struct, struct pointer, or slice", src)
+	}
+	rows := make([]map[string]bigquery.Value, v.Len())
+	for i := range rows {
+		x := v.Index(i).Interface()
+		row, ok, err := e.row(x)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("managedwriter: src[%d] has type %T, which is not a ValueSaver, map[string]bigquery.Value, struct or struct pointer", i, x)
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// row converts a single row, and returns false if x isn't one.
+func (e *rowEncoder) row(x interface{}) (map[string]bigquery.Value, bool, error) {
+	switch x := x.(type) {
+	case bigquery.StructSaver:
+		return nil, false, errors.New("managedwriter: use &StructSaver, not StructSaver")
+	case *bigquery.StructSaver:
+		if x.Schema == nil {
+			// Don't modify the caller's StructSaver.
+			x = &bigquery.StructSaver{Struct: x.Struct, Schema: e.schema}
+		}
+		row, _, err := x.Save()
+		return row, true, err
+	case bigquery.ValueSaver:
+		row, _, err := x.Save()
+		return row, true, err
+	case map[string]bigquery.Value:
+		return x, true, nil
+	}
+	t := reflect.TypeOf(x)
+	if t == nil || !(t.Kind() == reflect.Struct || t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct) {
+		return nil, false, nil
+	}
+	row, _, err := (&bigquery.StructSaver{Struct: x, Schema: e.schema}).Save()
+	return row, true, err
+}
+
+// encode encodes a row as a serialized message.
+func (e *rowEncoder) encode(row map[string]bigquery.Value) ([]byte, error) {
+	m := dynamicpb.NewMessage(e.descriptor)
+	if err := setFields(m, e.schema, row); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(m)
+}
+
+// setFields sets the fields of m, whose descriptor was derived from schema,
+// to the values of row.
+func setFields(m *dynamicpb.Message, schema bigquery.Schema, row map[string]bigquery.Value) error {
+	fields := m.Descriptor().Fields()
+	if fields.Len() != len(schema) {
+		return fmt.Errorf("descriptor has %d fields, schema has %d", fields.Len(), len(schema))
+	}
+	for name := range row {
+		if schemaField(schema, name) == nil {
+			return fmt.Errorf("no column named %q", name)
+		}
+	}
+	for i, f := range schema {
+		v, ok := row[f.Name]
+		if !ok {
+			// Look for the name in another case.
+			for k, kv := range row {
+				if strings.EqualFold(k, f.Name) {
+					v = kv
+					break
+				}
+			}
+		}
+		fd := fields.Get(i)
+		if f.Repeated {
+			if err := setRepeated(m, fd, f, v); err != nil {
+				return err
+			}
+			continue
+		}
+		pv, ok, err := protoValue(m, fd, f, v)
+		if err != nil {
+			return err
+		}
+		if ok {
+			m.Set(fd, pv)
+		}
+	}
+	return nil
+}
+
+// schemaField returns the field of schema with the given name, ignoring
+// case, or nil.
+func schemaField(schema bigquery.Schema, name string) *bigquery.FieldSchema {
+	for _, f := range schema {
+		if strings.EqualFold(f.Name, name) {
+			return f
+		}
+	}
+	return nil
+}
+
+func setRepeated(m *dynamicpb.Message, fd protoreflect.FieldDescriptor, f *bigquery.FieldSchema, v bigquery.Value) error {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return fmt.Errorf("repeated column %q requires a slice or array, got %T", f.Name, v)
+	}
+	list := m.Mutable(fd).List()
+	for i := 0; i < rv.Len(); i++ {
+		pv, ok, err := protoValue(m, fd, f, rv.Index(i).Interface())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("repeated column %q can't have NULL elements", f.Name)
+		}
+		list.Append(pv)
+	}
+	return nil
+}
+
+// protoValue converts v, a value of the column f, to a value of the field fd
+// of m. It returns false if v is NULL.
+func protoValue(m *dynamicpb.Message, fd protoreflect.FieldDescriptor, f *bigquery.FieldSchema, v bigquery.Value) (protoreflect.Value, bool, error) {
+	v, ok := unwrapNull(v)
+	if !ok {
+		return protoreflect.Value{}, false, nil
+	}
+	var pv protoreflect.Value
+	var err error
+	switch f.Type {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType:
+		if s, ok := v.(string); ok {
+			pv = protoreflect.ValueOfString(s)
+		} else {
+			err = errBadType
+		}
+	case bigquery.BytesFieldType:
+		switch v := v.(type) {
+		case []byte:
+			pv = protoreflect.ValueOfBytes(v)
+		case string:
+			// As with an Inserter, a string holds base64-encoded bytes.
+			var b []byte
+			b, err = base64.StdEncoding.DecodeString(v)
+			pv = protoreflect.ValueOfBytes(b)
+		default:
+			err = errBadType
+		}
+	case bigquery.IntegerFieldType:
+		var n int64
+		n, err = toInt64(v)
+		pv = protoreflect.ValueOfInt64(n)
+	case bigquery.FloatFieldType:
+		var x float64
+		x, err = toFloat64(v)
+		pv = protoreflect.ValueOfFloat64(x)
+	case bigquery.BooleanFieldType:
+		switch v := v.(type) {
+		case bool:
+			pv = protoreflect.ValueOfBool(v)
+		case string:
+			var b bool
+			b, err = strconv.ParseBool(v)
+			pv = protoreflect.ValueOfBool(b)
+		default:
+			err = errBadType
+		}
+	case bigquery.TimestampFieldType:
+		if t, ok := v.(time.Time); ok {
+			pv = protoreflect.ValueOfInt64(t.UnixMicro())
+		} else {
+			err = errBadType
+		}
+	case bigquery.DateFieldType:
+		var d civil.Date
+		switch v := v.(type) {
+		case civil.Date:
+			d = v
+		case string:
+			d, err = civil.ParseDate(v)
+		default:
+			err = errBadType
+		}
+		pv = protoreflect.ValueOfInt32(int32(d.DaysSince(civil.Date{Year: 1970, Month: 1, Day: 1})))
+	case bigquery.TimeFieldType:
+		var t civil.Time
+		switch v := v.(type) {
+		case civil.Time:
+			t = v
+		case string:
+			t, err = civil.ParseTime(v)
+		default:
+			err = errBadType
+		}
+		pv = protoreflect.ValueOfInt64(encodePackedTime(t))
+	case bigquery.DateTimeFieldType:
+		var dt civil.DateTime
+		switch v := v.(type) {
+		case civil.DateTime:
+			dt = v
+		case string:
+			dt, err = parseCivilDateTime(v)
+		default:
+			err = errBadType
+		}
+		pv = protoreflect.ValueOfInt64(encodePackedDateTime(dt))
+	case bigquery.NumericFieldType:
+		var b []byte
+		b, err = encodeDecimal(v, bigquery.NumericScaleDigits)
+		pv = protoreflect.ValueOfBytes(b)
+	case bigquery.BigNumericFieldType:
+		var b []byte
+		b, err = encodeDecimal(v, bigquery.BigNumericScaleDigits)
+		pv = protoreflect.ValueOfBytes(b)
+	case bigquery.RecordFieldType:
+		row, ok := v.(map[string]bigquery.Value)
+		if !ok {
+			err = errBadType
+			break
+		}
+		nested := dynamicpb.NewMessage(fd.Message())
+		if err := setFields(nested, f.Schema, row); err != nil {
+			return protoreflect.Value{}, false, fmt.Errorf("column %q: %w", f.Name, err)
+		}
+		pv = protoreflect.ValueOfMessage(nested)
+	default:
+		return protoreflect.Value{}, false, fmt.Errorf("column %q has unsupported type %s", f.Name, f.Type)
+	}
+	if err == errBadType {
+		return protoreflect.Value{}, false, fmt.Errorf("column %q of type %s can't hold a value of type %T", f.Name, f.Type, v)
+	}
+	if err != nil {
+		return protoreflect.Value{}, false, fmt.Errorf("column %q: %w", f.Name, err)
+	}
+	return pv, true, nil
+}
+
+var errBadType = errors.New("bad type")
+
+// unwrapNull returns the value of v, which may be one of the bigquery Null
+// types, or false if v is NULL.
+func unwrapNull(v bigquery.Value) (bigquery.Value, bool) {
+	switch v := v.(type) {
+	case nil:
+		return nil, false
+	case bigquery.NullInt64:
+		return v.Int64, v.Valid
+	case bigquery.NullString:
+		return v.StringVal, v.Valid
+	case bigquery.NullGeography:
+		return v.GeographyVal, v.Valid
+	case bigquery.NullJSON:
+		return v.JSONVal, v.Valid
+	case bigquery.NullFloat64:
+		return v.Float64, v.Valid
+	case bigquery.NullBool:
+		return v.Bool, v.Valid
+	case bigquery.NullTimestamp:
+		return v.Timestamp, v.Valid
+	case bigquery.NullDate:
+		return v.Date, v.Valid
+	case bigquery.NullTime:
+		return v.Time, v.Valid
+	case bigquery.NullDateTime:
+		return v.DateTime, v.Valid
+	case *big.Rat:
+		return v, v != nil
+	case []byte:
+		return v, v != nil
+	}
+	return v, true
+}
+
+func toInt64(v bigquery.Value) (int64, error) {
+	if s, ok := v.(string); ok {
+		return strconv.ParseInt(s, 10, 64)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return int64(rv.Uint()), nil
+	}
+	return 0, errBadType
+}
+
+func toFloat64(v bigquery.Value) (float64, error) {
+	if s, ok := v.(string); ok {
+		return strconv.ParseFloat(s, 64)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	}
+	return 0, errBadType
+}
+
+// parseCivilDateTime parses a DATETIME in the format of
+// bigquery.CivilDateTimeString, or in RFC 3339 format without a time zone.
+func parseCivilDateTime(s string) (civil.DateTime, error) {
+	if parts := strings.Fields(s); len(parts) == 2 {
+		s = parts[0] + "T" + parts[1]
+	}
+	return civil.ParseDateTime(s)
+}
+
+// The packed encodings of TIME and DATETIME values. See
+// https://cloud.google.com/bigquery/docs/write-api#data_type_conversions.
+func encodePackedTime(t civil.Time) int64 {
+	seconds := int64(t.Hour)<<12 | int64(t.Minute)<<6 | int64(t.Second)
+	return seconds<<20 | microseconds(t)
+}
+
+func encodePackedDateTime(dt civil.DateTime) int64 {
+	t := dt.Time
+	seconds := int64(dt.Date.Year)<<26 | int64(dt.Date.Month)<<22 | int64(dt.Date.Day)<<17 |
+		int64(t.Hour)<<12 | int64(t.Minute)<<6 | int64(t.Second)
+	return seconds<<20 | microseconds(t)
+}
+
+// microseconds returns the sub-second part of t in microseconds, rounded as
+// by bigquery.CivilTimeString.
+func microseconds(t civil.Time) int64 {
+	micros := int64(t.Nanosecond+500) / 1000
+	if micros > 999999 {
+		micros = 999999
+	}
+	return micros
+}
+
+// encodeDecimal encodes a NUMERIC or BIGNUMERIC value, rounded to scale
+// digits after the decimal point, as a little-endian two's complement
+// integer of the unscaled value.
+func encodeDecimal(v bigquery.Value, scale int) ([]byte, error) {
+	var r *big.Rat
+	switch v := v.(type) {
+	case *big.Rat:
+		r = v
+	case string:
+		var ok bool
+		if r, ok = new(big.Rat).SetString(v); !ok {
+			return nil, fmt.Errorf("invalid decimal value %q", v)
+		}
+	default:
+		return nil, errBadType
+	}
+	// Round as the string formats of bigquery.NumericString and
+	// bigquery.BigNumericString do.
+	n, ok := new(big.Int).SetString(strings.Replace(r.FloatString(scale), ".", "", 1), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal value %s", r)
+	}
+	// The number of bytes needed for the sign bit and the magnitude.
+	abs := n
+	if n.Sign() < 0 {
+		abs = new(big.Int).Not(n)
+	}
+	size := abs.BitLen()/8 + 1
+	mod := new(big.Int).Lsh(big.NewInt(1), uint(8*size))
+	b := new(big.Int).Mod(n, mod).FillBytes(make([]byte, size))
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b, nil
+}
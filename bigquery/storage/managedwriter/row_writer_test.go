@@ -0,0 +1,266 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package managedwriter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var rowWriterSchema = bigquery.Schema{
+	{Name: "name", Type: bigquery.StringFieldType, Required: true},
+	{Name: "num", Type: bigquery.IntegerFieldType},
+	{Name: "score", Type: bigquery.FloatFieldType},
+	{Name: "ok", Type: bigquery.BooleanFieldType},
+	{Name: "data", Type: bigquery.BytesFieldType},
+	{Name: "ts", Type: bigquery.TimestampFieldType},
+	{Name: "date", Type: bigquery.DateFieldType},
+	{Name: "time", Type: bigquery.TimeFieldType},
+	{Name: "dt", Type: bigquery.DateTimeFieldType},
+	{Name: "numeric", Type: bigquery.NumericFieldType},
+	{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+	{Name: "rec", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+		{Name: "a", Type: bigquery.IntegerFieldType},
+	}},
+}
+
+type rowWriterStruct struct {
+	Name    string
+	Num     bigquery.NullInt64
+	Score   float32
+	OK      bool
+	Data    []byte
+	TS      time.Time
+	Date    civil.Date
+	Time    civil.Time
+	DT      civil.DateTime
+	Numeric *big.Rat
+	Tags    []string
+	Rec     *struct{ A int }
+}
+
+// decodeRow decodes an encoded row to its JSON form.
+func decodeRow(t *testing.T, enc *rowEncoder, b []byte) string {
+	t.Helper()
+	m := dynamicpb.NewMessage(enc.descriptor)
+	if err := proto.Unmarshal(b, m); err != nil {
+		t.Fatal(err)
+	}
+	js, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reformat for a stable comparison.
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, js); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestRowEncoder(t *testing.T) {
+	enc, err := newRowEncoder(rowWriterSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 6000, time.UTC)
+	tm := civil.Time{Hour: 12, Minute: 34, Second: 56, Nanosecond: 789000000}
+	dt := civil.DateTime{Date: civil.Date{Year: 2023, Month: 1, Day: 2}, Time: tm}
+	// Packed TIME: hour, minute and second in bits 32-52, 26-31 and 20-25,
+	// and microseconds in bits 0-19. DATETIME adds the date above them.
+	const wantTime = 12<<32 | 34<<26 | 56<<20 | 789000
+	const wantDateTime = 2023<<46 | 1<<42 | 2<<37 | wantTime
+	// The full JSON of the row with every column set.
+	full := `{"name":"n","num":"7","score":1.5,"ok":true,"data":"AQI=","ts":"1672628645000006",` +
+		`"date":19359,"time":"` + strconv.FormatInt(wantTime, 10) + `","dt":"` + strconv.FormatInt(wantDateTime, 10) + `",` +
+		`"numeric":"AMqaOw==","tags":["a","b"],"rec":{"a":"3"}}`
+
+	for _, test := range []struct {
+		desc string
+		src  interface{}
+		want string
+	}{
+		{
+			desc: "struct",
+			src: &rowWriterStruct{
+				Name: "n", Num: bigquery.NullInt64{Int64: 7, Valid: true}, Score: 1.5, OK: true,
+				Data: []byte{1, 2}, TS: ts, Date: dt.Date, Time: tm, DT: dt, Numeric: big.NewRat(1, 1),
+				Tags: []string{"a", "b"}, Rec: &struct{ A int }{3},
+			},
+			want: full,
+		},
+		{
+			desc: "struct with NULLs",
+			src:  rowWriterStruct{Name: "n", Date: dt.Date, TS: ts, Time: tm, DT: dt},
+			want: `{"name":"n","score":0,"ok":false,"ts":"1672628645000006","date":19359,` +
+				`"time":"` + strconv.FormatInt(wantTime, 10) + `","dt":"` + strconv.FormatInt(wantDateTime, 10) + `"}`,
+		},
+		{
+			desc: "map",
+			src: map[string]bigquery.Value{
+				"Name": "n", "num": int64(7), "score": 1.5, "ok": true, "data": []byte{1, 2}, "ts": ts,
+				"date": "2023-01-02", "time": "12:34:56.789", "dt": "2023-01-02 12:34:56.789",
+				"numeric": "1", "tags": []bigquery.Value{"a", "b"}, "rec": map[string]bigquery.Value{"a": 3},
+			},
+			want: full,
+		},
+		{
+			desc: "ValueSaver",
+			src: &bigquery.ValuesSaver{
+				Schema: bigquery.Schema{rowWriterSchema[0], rowWriterSchema[1]},
+				Row:    []bigquery.Value{"n", nil},
+			},
+			want: `{"name":"n"}`,
+		},
+	} {
+		rows, err := enc.rows(test.src)
+		if err != nil {
+			t.Fatalf("%s: %v", test.desc, err)
+		}
+		if len(rows) != 1 {
+			t.Fatalf("%s: got %d rows, want 1", test.desc, len(rows))
+		}
+		b, err := enc.encode(rows[0])
+		if err != nil {
+			t.Fatalf("%s: %v", test.desc, err)
+		}
+		if got := decodeRow(t, enc, b); got != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestRowEncoderErrors(t *testing.T) {
+	enc, err := newRowEncoder(rowWriterSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []map[string]bigquery.Value{
+		{"num": 1},                        // missing required column
+		{"name": "n", "other": 1},         // unknown column
+		{"name": 1},                       // wrong type
+		{"name": "n", "tags": "a"},        // not a slice
+		{"name": "n", "numeric": "x"},     // bad decimal
+		{"name": "n", "rec": []int{1}},    // not a map
+		{"name": "n", "tags": []int{1}},   // wrong element type
+		{"name": "n", "date": "1/2/2023"}, // bad date
+	} {
+		if _, err := enc.encode(row); err == nil {
+			t.Errorf("%v: got nil, want error", row)
+		}
+	}
+	if _, err := enc.rows(42); err == nil {
+		t.Error("rows(42): got nil, want error")
+	}
+	if _, err := enc.rows(bigquery.StructSaver{}); err == nil {
+		t.Error("rows(StructSaver): got nil, want error")
+	}
+}
+
+func TestEncodeDecimal(t *testing.T) {
+	for _, test := range []struct {
+		in    string
+		scale int
+		want  []byte
+	}{
+		{"0", 9, []byte{0}},
+		{"1", 9, []byte{0x00, 0xca, 0x9a, 0x3b}},
+		{"-1", 9, []byte{0x00, 0x36, 0x65, 0xc4}},
+		{"0.000000001", 9, []byte{1}},
+		{"0.0000000005", 9, []byte{1}}, // rounded
+		{"-0.000000128", 9, []byte{0x80}},
+		{"0.000000128", 9, []byte{0x80, 0x00}},
+		{"-0.000000129", 9, []byte{0x7f, 0xff}},
+	} {
+		got, err := encodeDecimal(test.in, test.scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("%s: got %x, want %x", test.in, got, test.want)
+		}
+	}
+}
+
+func TestRowWriterAppend(t *testing.T) {
+	ctx := context.Background()
+	enc, err := newRowEncoder(rowWriterSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testARC := &testAppendRowsClient{}
+	ms := &ManagedStream{
+		ctx:              ctx,
+		open:             openTestArc(testARC, nil, nil),
+		streamSettings:   defaultStreamSettings(),
+		fc:               newFlowController(0, 0),
+		schemaDescriptor: enc.descriptorProto,
+	}
+	ms.streamSettings.streamID = "FOO"
+	w := &RowWriter{ManagedStream: ms, enc: enc}
+
+	src := []interface{}{
+		struct{ Name string }{"a"},
+		map[string]bigquery.Value{"name": "b"},
+	}
+	res, err := w.Append(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := res.GetResult(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(testARC.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(testARC.requests))
+	}
+	rows := testARC.requests[0].GetProtoRows()
+	if got := len(rows.GetRows().GetSerializedRows()); got != 2 {
+		t.Errorf("got %d rows, want 2", got)
+	}
+	// The rows must decode with the descriptor sent to the service.
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("row.proto"),
+		Syntax:      proto.String("proto2"),
+		MessageType: []*descriptorpb.DescriptorProto{rows.GetWriterSchema().GetProtoDescriptor()},
+	}
+	fd, err := protodesc.NewFile(fdp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := dynamicpb.NewMessage(fd.Messages().Get(0))
+	if err := proto.Unmarshal(rows.GetRows().GetSerializedRows()[1], m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Get(m.Descriptor().Fields().ByName("name")).String(); got != "b" {
+		t.Errorf("got name %q, want %q", got, "b")
+	}
+
+	if _, err := w.Append(ctx, []interface{}{map[string]bigquery.Value{"num": 1}}); err == nil {
+		t.Error("got nil, want error for a row without a required column")
+	}
+}
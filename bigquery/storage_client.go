@@ -0,0 +1,119 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+
+	"cloud.google.com/go/bigquery/internal"
+	storage "cloud.google.com/go/bigquery/storage/apiv1"
+	"cloud.google.com/go/bigquery/storage/apiv1/storagepb"
+	"google.golang.org/api/option"
+)
+
+// readClient reads tables with the BigQuery Storage Read API. A Client has
+// one after EnableStorageReadClient is called.
+type readClient struct {
+	rawClient *storage.BigQueryReadClient
+	projectID string
+
+	// maxStreamCount is the most streams that a read session may have. If
+	// zero, the service chooses.
+	maxStreamCount int
+	// maxWorkerCount is the most streams that are read at once.
+	maxWorkerCount int
+}
+
+// EnableStorageReadClient makes the Client read the rows of tables, jobs and
+// queries with the BigQuery Storage Read API, which is much faster than the
+// REST API for large results. A RowIterator then reads the streams of a read
+// session in parallel, and decodes their rows from the Arrow format. The
+// options configure the connection to the Storage Read API.
+//
+// Results are read with the REST API when the Storage Read API can't read
+// them, such as the results of scripts, views, and query results that were
+// returned in full by the query.
+//
+// The results of a query that may order them, because it has an ORDER BY
+// clause other than in a window specification or function call, are read from
+// a single stream to keep their order. Otherwise the rows are returned in no
+// particular order; to read the rows of a table in order, set
+// RowIterator.PreserveOrder before the first call to Next. Reading a single
+// stream is slower for large results.
+//
+// A RowIterator that uses the Storage Read API doesn't support
+// RowIterator.StartIndex or setting PageInfo().Token. Reading with the
+// Storage Read API is billed separately from queries; see
+// https://cloud.google.com/bigquery/pricing#storage-api. Streams are read in
+// the background until the rows are all returned by Next, so cancel the
+// context of the read if you stop calling Next earlier.
+//
+// EnableStorageReadClient returns an error if it was already called.
+func (c *Client) EnableStorageReadClient(ctx context.Context, opts ...option.ClientOption) error {
+	if c.rc != nil {
+		return errors.New("bigquery: storage read client already enabled")
+	}
+	rc, err := newReadClient(ctx, c.projectID, opts...)
+	if err != nil {
+		return err
+	}
+	c.rc = rc
+	return nil
+}
+
+func newReadClient(ctx context.Context, projectID string, opts ...option.ClientOption) (*readClient, error) {
+	opts = append([]option.ClientOption{
+		option.WithUserAgent(fmt.Sprintf("%s/%s", userAgentPrefix, internal.Version)),
+	}, opts...)
+	raw, err := storage.NewBigQueryReadClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &readClient{
+		rawClient:      raw,
+		projectID:      projectID,
+		maxWorkerCount: runtime.GOMAXPROCS(0),
+	}, nil
+}
+
+func (c *Client) isStorageReadAvailable() bool {
+	return c.rc != nil
+}
+
+// createSession creates a read session for all of the columns of a table. If
+// ordered is true, the session has one stream, so that rows are read in
+// order.
+func (rc *readClient) createSession(ctx context.Context, t *Table, ordered bool) (*storagepb.ReadSession, error) {
+	maxStreams := rc.maxStreamCount
+	if ordered {
+		maxStreams = 1
+	}
+	req := &storagepb.CreateReadSessionRequest{
+		Parent: "projects/" + rc.projectID,
+		ReadSession: &storagepb.ReadSession{
+			Table:      fmt.Sprintf("projects/%s/datasets/%s/tables/%s", t.ProjectID, t.DatasetID, t.TableID),
+			DataFormat: storagepb.DataFormat_ARROW,
+		},
+		MaxStreamCount: int32(maxStreams),
+	}
+	return rc.rawClient.CreateReadSession(ctx, req)
+}
+
+func (rc *readClient) close() error {
+	return rc.rawClient.Close()
+}
@@ -0,0 +1,255 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery/storage/apiv1/storagepb"
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// storageReader reads the rows of a table with the Storage Read API. Its
+// fetchPage method is the pageFetcher of a RowIterator. Each page holds the
+// rows of one record batch, and the pages of all streams are returned in the
+// order that they're read, unless ordered or the RowIterator's PreserveOrder
+// is true, in which case the session has a single stream.
+type storageReader struct {
+	rc      *readClient
+	t       *Table
+	ordered bool
+	it      *RowIterator // the RowIterator that r fetches pages for
+
+	// The schema and number of rows of the table, if known when the reader
+	// is created; otherwise they're read from the table's metadata.
+	schema    Schema
+	totalRows uint64
+
+	// restFallback is true when the table can't be read with the Storage
+	// Read API, so that pages are fetched with the REST API.
+	restFallback bool
+	started      bool
+	nextToken    string
+	pageIndex    int
+	pages        chan [][]Value // closed when all streams have been read
+	cancel       context.CancelFunc
+
+	mu  sync.Mutex
+	err error // the first error reading a stream
+}
+
+// newStorageIterator returns a RowIterator that reads the table t with the
+// Storage Read API. If ordered is true, the rows are returned in order.
+func newStorageIterator(ctx context.Context, rc *readClient, src *rowSource, t *Table, ordered bool, schema Schema, totalRows uint64) *RowIterator {
+	r := &storageReader{
+		rc:        rc,
+		t:         t,
+		ordered:   ordered,
+		schema:    schema,
+		totalRows: totalRows,
+	}
+	it := newRowIterator(ctx, src, r.fetchPage)
+	r.it = it
+	it.Schema = schema
+	it.TotalRows = totalRows
+	return it
+}
+
+func (r *storageReader) fetchPage(ctx context.Context, src *rowSource, schema Schema, startIndex uint64, pageSize int64, pageToken string) (*fetchPageResult, error) {
+	if r.restFallback {
+		return fetchPage(ctx, src, schema, startIndex, pageSize, pageToken)
+	}
+	if startIndex != 0 {
+		return nil, errors.New("bigquery: StartIndex is not supported when reading with the Storage Read API")
+	}
+	if pageToken != r.nextToken {
+		return nil, errors.New("bigquery: page tokens are not supported when reading with the Storage Read API")
+	}
+	if !r.started {
+		if err := r.start(ctx); err != nil {
+			return nil, err
+		}
+		if r.restFallback {
+			return fetchPage(ctx, src, schema, startIndex, pageSize, pageToken)
+		}
+	}
+	res := &fetchPageResult{schema: r.schema, totalRows: r.totalRows}
+	select {
+	case rows, ok := <-r.pages:
+		if !ok {
+			// All streams have been read, and an empty page with no token
+			// ends the iteration.
+			r.cancel()
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if r.err != nil {
+				return nil, r.err
+			}
+			return res, nil
+		}
+		r.pageIndex++
+		r.nextToken = fmt.Sprintf("storage-page-%d", r.pageIndex)
+		res.rows = rows
+		res.pageToken = r.nextToken
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return res, nil
+}
+
+// start creates the read session and starts reading its streams. If the
+// table can't be read with the Storage Read API, it sets restFallback
+// instead.
+func (r *storageReader) start(ctx context.Context) error {
+	if r.schema == nil {
+		md, err := r.t.Metadata(ctx)
+		if err != nil {
+			return err
+		}
+		switch md.Type {
+		case RegularTable, MaterializedView, Snapshot:
+		default:
+			r.restFallback = true
+			return nil
+		}
+		r.schema = md.Schema
+		r.totalRows = md.NumRows
+	}
+	session, err := r.rc.createSession(ctx, r.t, r.ordered || r.it.PreserveOrder)
+	if err != nil {
+		return err
+	}
+	r.started = true
+	r.pages = make(chan [][]Value, r.rc.maxWorkerCount)
+	ctx, r.cancel = context.WithCancel(ctx)
+	dec := newArrowDecoder(session.GetArrowSchema().GetSerializedSchema(), r.schema)
+
+	streams := make(chan string, len(session.Streams))
+	for _, s := range session.Streams {
+		streams <- s.Name
+	}
+	close(streams)
+	workers := r.rc.maxWorkerCount
+	if workers > len(session.Streams) {
+		workers = len(session.Streams)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range streams {
+				if err := r.readStream(ctx, name, dec); err != nil {
+					r.setErr(err)
+					r.cancel()
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(r.pages)
+	}()
+	return nil
+}
+
+// readStream reads all of the rows of a stream, and sends them to r.pages
+// one record batch at a time. It reconnects at the last offset read when the
+// connection is interrupted.
+func (r *storageReader) readStream(ctx context.Context, name string, dec *arrowDecoder) error {
+	var offset int64
+	bo := gax.Backoff{
+		Initial:    100 * time.Millisecond,
+		Multiplier: 2,
+		Max:        10 * time.Second,
+	}
+	for {
+		err := r.readRows(ctx, name, &offset, dec)
+		if err == nil {
+			return nil
+		}
+		if !isRetryableReadError(err) {
+			return err
+		}
+		if err := gax.Sleep(ctx, bo.Pause()); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *storageReader) readRows(ctx context.Context, name string, offset *int64, dec *arrowDecoder) error {
+	stream, err := r.rc.rawClient.ReadRows(ctx, &storagepb.ReadRowsRequest{
+		ReadStream: name,
+		Offset:     *offset,
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rows, err := dec.decodeRows(res.GetArrowRecordBatch().GetSerializedRecordBatch())
+		if err != nil {
+			return err
+		}
+		*offset += res.RowCount
+		if len(rows) == 0 {
+			continue
+		}
+		select {
+		case r.pages <- rows:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (r *storageReader) setErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// isRetryableReadError reports whether reading a stream can continue after
+// err by reconnecting.
+func isRetryableReadError(err error) bool {
+	s, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	switch s.Code() {
+	case codes.Unavailable:
+		return true
+	case codes.Internal:
+		return strings.Contains(s.Message(), "RST_STREAM")
+	}
+	return false
+}
@@ -0,0 +1,290 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/bigquery/storage/apiv1/storagepb"
+	"cloud.google.com/go/internal/testutil"
+	"github.com/apache/arrow/go/v10/arrow"
+	"github.com/apache/arrow/go/v10/arrow/array"
+	"github.com/apache/arrow/go/v10/arrow/memory"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeReadServer serves read sessions whose streams each hold batchesPerStream
+// batches of two rows of the columns "name" and "num".
+type fakeReadServer struct {
+	storagepb.UnimplementedBigQueryReadServer
+	t                *testing.T
+	schema           []byte
+	batchesPerStream int
+
+	mu       sync.Mutex
+	sessions []*storagepb.CreateReadSessionRequest
+	failed   map[string]bool // streams that have failed once
+}
+
+func (s *fakeReadServer) CreateReadSession(ctx context.Context, req *storagepb.CreateReadSessionRequest) (*storagepb.ReadSession, error) {
+	s.mu.Lock()
+	s.sessions = append(s.sessions, req)
+	s.mu.Unlock()
+	n := int(req.MaxStreamCount)
+	if n == 0 {
+		n = 3
+	}
+	session := &storagepb.ReadSession{
+		Name:       "session",
+		Table:      req.ReadSession.Table,
+		DataFormat: storagepb.DataFormat_ARROW,
+		Schema: &storagepb.ReadSession_ArrowSchema{
+			ArrowSchema: &storagepb.ArrowSchema{SerializedSchema: s.schema},
+		},
+	}
+	for i := 0; i < n; i++ {
+		session.Streams = append(session.Streams, &storagepb.ReadStream{Name: fmt.Sprintf("stream%d", i)})
+	}
+	return session, nil
+}
+
+func (s *fakeReadServer) ReadRows(req *storagepb.ReadRowsRequest, stream storagepb.BigQueryRead_ReadRowsServer) error {
+	for b := int(req.Offset / 2); b < s.batchesPerStream; b++ {
+		s.mu.Lock()
+		fail := b == 1 && !s.failed[req.ReadStream]
+		if fail {
+			s.failed[req.ReadStream] = true
+		}
+		s.mu.Unlock()
+		if fail {
+			return status.Error(codes.Unavailable, "try again")
+		}
+		_, batch := s.batch(req.ReadStream, b)
+		if err := stream.Send(&storagepb.ReadRowsResponse{
+			RowCount: 2,
+			Rows: &storagepb.ReadRowsResponse_ArrowRecordBatch{
+				ArrowRecordBatch: &storagepb.ArrowRecordBatch{SerializedRecordBatch: batch, RowCount: 2},
+			},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batch returns the b'th batch of a stream.
+func (s *fakeReadServer) batch(streamName string, b int) (schema, batch []byte) {
+	rb := array.NewRecordBuilder(memory.NewGoAllocator(), arrow.NewSchema([]arrow.Field{
+		{Name: "name", Type: arrow.BinaryTypes.String},
+		{Name: "num", Type: arrow.PrimitiveTypes.Int64},
+	}, nil))
+	defer rb.Release()
+	for i := 0; i < 2; i++ {
+		rb.Field(0).(*array.StringBuilder).Append(fmt.Sprintf("%s-%d", streamName, 2*b+i))
+		rb.Field(1).(*array.Int64Builder).Append(int64(2*b + i))
+	}
+	rec := rb.NewRecord()
+	defer rec.Release()
+	return serializeArrow(s.t, rec)
+}
+
+func newFakeReadClient(t *testing.T, batchesPerStream int) (*Client, *fakeReadServer) {
+	t.Helper()
+	ctx := context.Background()
+	fake := &fakeReadServer{t: t, batchesPerStream: batchesPerStream, failed: map[string]bool{}}
+	fake.schema, _ = fake.batch("", 0)
+	srv, err := testutil.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagepb.RegisterBigQueryReadServer(srv.Gsrv, fake)
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	c := &Client{projectID: "client-project"}
+	c.rc, err = newReadClient(ctx, c.projectID,
+		option.WithEndpoint(srv.Addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithInsecure()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, fake
+}
+
+var storageTestSchema = Schema{
+	{Name: "name", Type: StringFieldType},
+	{Name: "num", Type: IntegerFieldType},
+}
+
+func TestStorageIterator(t *testing.T) {
+	ctx := context.Background()
+	c, fake := newFakeReadClient(t, 3)
+	table := c.DatasetInProject("p", "d").Table("t")
+	it := newStorageIterator(ctx, c.rc, &rowSource{t: table}, table, false, storageTestSchema, 18)
+
+	type row struct {
+		Name string
+		Num  int
+	}
+	var got []string
+	for i := 0; ; i++ {
+		var name string
+		var err error
+		// Use all of the kinds of destinations.
+		switch i % 3 {
+		case 0:
+			var r row
+			err = it.Next(&r)
+			name = r.Name
+		case 1:
+			var m map[string]Value
+			err = it.Next(&m)
+			if err == nil {
+				name = m["name"].(string)
+			}
+		case 2:
+			var vs []Value
+			err = it.Next(&vs)
+			if err == nil {
+				name = vs[0].(string)
+			}
+		}
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	var want []string
+	for s := 0; s < 3; s++ {
+		for r := 0; r < 6; r++ {
+			want = append(want, fmt.Sprintf("stream%d-%d", s, r))
+		}
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+	if it.TotalRows != 18 || !testutil.Equal(it.Schema, storageTestSchema) {
+		t.Errorf("got TotalRows %d and Schema %v", it.TotalRows, it.Schema)
+	}
+	if len(fake.sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(fake.sessions))
+	}
+	req := fake.sessions[0]
+	if req.Parent != "projects/client-project" || req.ReadSession.Table != "projects/p/datasets/d/tables/t" || req.MaxStreamCount != 0 {
+		t.Errorf("got CreateReadSessionRequest %v", req)
+	}
+}
+
+func TestStorageIteratorPaging(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newFakeReadClient(t, 1)
+	table := c.Dataset("d").Table("t")
+
+	it := newStorageIterator(ctx, c.rc, &rowSource{t: table}, table, false, storageTestSchema, 0)
+	it.StartIndex = 1
+	var vs []Value
+	if err := it.Next(&vs); err == nil {
+		t.Error("StartIndex: got nil, want error")
+	}
+
+	it = newStorageIterator(ctx, c.rc, &rowSource{t: table}, table, false, storageTestSchema, 0)
+	it.PageInfo().Token = "x"
+	if err := it.Next(&vs); err == nil {
+		t.Error("Token: got nil, want error")
+	}
+}
+
+func TestJobReadWithStorage(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		query         string
+		preserveOrder bool
+		ordered       bool
+	}{
+		{"SELECT name, num FROM t\nORDER\tBY num", false, true},
+		{"SELECT name, num FROM t", false, false},
+		{"SELECT name, num FROM t", true, true},
+	} {
+		c, fake := newFakeReadClient(t, 2)
+		j := &Job{
+			c:         c,
+			projectID: "p",
+			jobID:     "j",
+			config: &bq.JobConfiguration{
+				Query: &bq.JobConfigurationQuery{
+					Query:            test.query,
+					DestinationTable: &bq.TableReference{ProjectId: "p", DatasetId: "anon", TableId: "results"},
+				},
+			},
+		}
+		waitForQuery := func(context.Context, string) (Schema, uint64, error) {
+			return storageTestSchema, 4, nil
+		}
+		it, err := j.read(ctx, waitForQuery, func(context.Context, *rowSource, Schema, uint64, int64, string) (*fetchPageResult, error) {
+			return nil, fmt.Errorf("read with the REST API")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		it.PreserveOrder = test.preserveOrder
+		var got []Value
+		for {
+			var vs []Value
+			err := it.Next(&vs)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, vs[1])
+		}
+		// An ordered read has one stream, read in order.
+		want := []Value{int64(0), int64(1), int64(2), int64(3)}
+		wantStreams := int32(1)
+		if !test.ordered {
+			// The fake's three streams each hold the same rows, and they
+			// are read in parallel.
+			sort.Slice(got, func(i, k int) bool { return got[i].(int64) < got[k].(int64) })
+			want = []Value{int64(0), int64(0), int64(0), int64(1), int64(1), int64(1),
+				int64(2), int64(2), int64(2), int64(3), int64(3), int64(3)}
+			wantStreams = 0
+		}
+		if diff := testutil.Diff(got, want); diff != "" {
+			t.Errorf("%q, PreserveOrder=%t: got=-, want=+:\n%s", test.query, test.preserveOrder, diff)
+		}
+		if len(fake.sessions) != 1 || fake.sessions[0].MaxStreamCount != wantStreams || fake.sessions[0].ReadSession.Table != "projects/p/datasets/anon/tables/results" {
+			t.Errorf("%q, PreserveOrder=%t: got sessions %v", test.query, test.preserveOrder, fake.sessions)
+		}
+		if it.SourceJob() == nil || it.SourceJob().ID() != "j" {
+			t.Errorf("got SourceJob %v", it.SourceJob())
+		}
+	}
+}
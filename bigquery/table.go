@@ -796,6 +796,9 @@ func (t *Table) Delete(ctx context.Context) (err error) {
 
 // Read fetches the contents of the table.
 func (t *Table) Read(ctx context.Context) *RowIterator {
+	if t.c.isStorageReadAvailable() {
+		return newStorageIterator(ctx, t.c.rc, &rowSource{t: t}, t, false, nil, 0)
+	}
 	return t.read(ctx, fetchPage)
 }
 
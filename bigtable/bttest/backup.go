@@ -0,0 +1,258 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bttest
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	emptypb "github.com/golang/protobuf/ptypes/empty"
+	btapb "google.golang.org/genproto/googleapis/bigtable/admin/v2"
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// minBackupRetention and maxBackupRetention bound how long after its
+	// creation a backup may expire.
+	minBackupRetention = 6 * time.Hour
+	maxBackupRetention = 90 * 24 * time.Hour
+)
+
+// backup is a table backup held by the server.
+type backup struct {
+	proto *btapb.Backup
+	data  *tableCopy
+}
+
+func (b *backup) expired() bool {
+	return !now().Before(b.proto.ExpireTime.AsTime())
+}
+
+// liveBackup returns the named backup, deleting it instead if it has expired.
+// s.mu must be held.
+func (s *server) liveBackup(name string) (*backup, bool) {
+	b, ok := s.backups[name]
+	if ok && b.expired() {
+		delete(s.backups, name)
+		return nil, false
+	}
+	return b, ok
+}
+
+// checkBackupExpiry checks that a backup started at start may expire at expire.
+func checkBackupExpiry(start time.Time, expire *timestamppb.Timestamp) error {
+	if expire == nil {
+		return status.Errorf(codes.InvalidArgument, "backup expire_time is required")
+	}
+	if err := expire.CheckValid(); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid backup expire_time: %v", err)
+	}
+	d := expire.AsTime().Sub(start)
+	if d < minBackupRetention || d > maxBackupRetention {
+		return status.Errorf(codes.InvalidArgument, "backup expire_time must be between %v and %v after the backup is created",
+			minBackupRetention, maxBackupRetention)
+	}
+	return nil
+}
+
+func (s *server) CreateBackup(ctx context.Context, req *btapb.CreateBackupRequest) (*longrunning.Operation, error) {
+	if req.Parent == "" || req.BackupId == "" || req.Backup == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "parent, backup_id and backup are required")
+	}
+	start := now()
+	if err := checkBackupExpiry(start, req.Backup.ExpireTime); err != nil {
+		return nil, err
+	}
+	name := req.Parent + "/backups/" + req.BackupId
+
+	s.mu.Lock()
+	tbl, ok := s.tables[req.Backup.SourceTable]
+	if !ok {
+		s.mu.Unlock()
+		return nil, status.Errorf(codes.NotFound, "table %q not found", req.Backup.SourceTable)
+	}
+	if _, ok := s.liveBackup(name); ok {
+		s.mu.Unlock()
+		return nil, status.Errorf(codes.AlreadyExists, "backup %q already exists", name)
+	}
+	data := copyTable(tbl)
+	end := now()
+	b := &backup{
+		proto: &btapb.Backup{
+			Name:        name,
+			SourceTable: req.Backup.SourceTable,
+			ExpireTime:  req.Backup.ExpireTime,
+			StartTime:   timestamppb.New(start),
+			EndTime:     timestamppb.New(end),
+			SizeBytes:   data.size,
+			State:       btapb.Backup_READY,
+			EncryptionInfo: &btapb.EncryptionInfo{
+				EncryptionType: btapb.EncryptionInfo_GOOGLE_DEFAULT_ENCRYPTION,
+			},
+		},
+		data: data,
+	}
+	if s.backups == nil {
+		s.backups = make(map[string]*backup)
+	}
+	s.backups[name] = b
+	res := proto.Clone(b.proto) // b.proto can change once s.mu is released
+	s.mu.Unlock()
+
+	return s.newOperation(name, &btapb.CreateBackupMetadata{
+		Name:        name,
+		SourceTable: req.Backup.SourceTable,
+		StartTime:   timestamppb.New(start),
+		EndTime:     timestamppb.New(end),
+	}, res)
+}
+
+func (s *server) GetBackup(ctx context.Context, req *btapb.GetBackupRequest) (*btapb.Backup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.liveBackup(req.Name)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "backup %q not found", req.Name)
+	}
+	return proto.Clone(b.proto).(*btapb.Backup), nil
+}
+
+func (s *server) UpdateBackup(ctx context.Context, req *btapb.UpdateBackupRequest) (*btapb.Backup, error) {
+	if req.Backup == nil || req.UpdateMask == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "backup and update_mask are required")
+	}
+	for _, path := range req.UpdateMask.Paths {
+		if path != "expire_time" {
+			return nil, status.Errorf(codes.InvalidArgument, "only expire_time can be updated, not %q", path)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.liveBackup(req.Backup.Name)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "backup %q not found", req.Backup.Name)
+	}
+	if len(req.UpdateMask.Paths) > 0 {
+		if err := checkBackupExpiry(b.proto.StartTime.AsTime(), req.Backup.ExpireTime); err != nil {
+			return nil, err
+		}
+		if !now().Before(req.Backup.ExpireTime.AsTime()) {
+			return nil, status.Errorf(codes.InvalidArgument, "backup expire_time must be in the future")
+		}
+		b.proto.ExpireTime = req.Backup.ExpireTime
+	}
+	return proto.Clone(b.proto).(*btapb.Backup), nil
+}
+
+func (s *server) DeleteBackup(ctx context.Context, req *btapb.DeleteBackupRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.liveBackup(req.Name); !ok {
+		return nil, status.Errorf(codes.NotFound, "backup %q not found", req.Name)
+	}
+	delete(s.backups, req.Name)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *server) ListBackups(ctx context.Context, req *btapb.ListBackupsRequest) (*btapb.ListBackupsResponse, error) {
+	if req.Filter != "" {
+		return nil, status.Errorf(codes.Unimplemented, "the emulator does not currently support filtering backups")
+	}
+	if req.OrderBy != "" && req.OrderBy != "name" && req.OrderBy != "name asc" {
+		return nil, status.Errorf(codes.Unimplemented, "the emulator can only order backups by name")
+	}
+	// A cluster of "-" lists backups in every cluster of the instance.
+	prefix := req.Parent + "/backups/"
+	if strings.HasSuffix(req.Parent, "/clusters/-") {
+		prefix = strings.TrimSuffix(req.Parent, "-")
+	}
+
+	s.mu.Lock()
+	var names []string
+	for name := range s.backups {
+		if _, ok := s.liveBackup(name); ok && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var backups []*btapb.Backup
+	for _, name := range names {
+		backups = append(backups, proto.Clone(s.backups[name].proto).(*btapb.Backup))
+	}
+	s.mu.Unlock()
+
+	page, next, err := paginate(len(backups), req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &btapb.ListBackupsResponse{
+		Backups:       backups[page[0]:page[1]],
+		NextPageToken: next,
+	}, nil
+}
+
+func (s *server) RestoreTable(ctx context.Context, req *btapb.RestoreTableRequest) (*longrunning.Operation, error) {
+	if req.TableId == "" || req.GetBackup() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "table_id and backup are required")
+	}
+	start := now()
+	name := req.Parent + "/tables/" + req.TableId
+
+	s.mu.Lock()
+	b, ok := s.liveBackup(req.GetBackup())
+	if !ok {
+		s.mu.Unlock()
+		return nil, status.Errorf(codes.NotFound, "backup %q not found", req.GetBackup())
+	}
+	info := &btapb.BackupInfo{
+		Backup:      b.proto.Name,
+		StartTime:   b.proto.StartTime,
+		EndTime:     b.proto.EndTime,
+		SourceTable: b.proto.SourceTable,
+	}
+	tbl, err := s.addTableCopy(name, b.data, &btapb.RestoreInfo{
+		SourceType: btapb.RestoreSourceType_BACKUP,
+		SourceInfo: &btapb.RestoreInfo_BackupInfo{BackupInfo: info},
+	})
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.newOperation(name, &btapb.RestoreTableMetadata{
+		Name:       name,
+		SourceType: btapb.RestoreSourceType_BACKUP,
+		SourceInfo: &btapb.RestoreTableMetadata_BackupInfo{BackupInfo: info},
+		Progress: &btapb.OperationProgress{
+			ProgressPercent: 100,
+			StartTime:       timestamppb.New(start),
+			EndTime:         timestamppb.New(now()),
+		},
+	}, &btapb.Table{
+		Name:           name,
+		ColumnFamilies: toColumnFamilies(tbl.columnFamilies()),
+		Granularity:    btapb.Table_MILLIS,
+		RestoreInfo:    tbl.restoreInfo,
+	})
+}
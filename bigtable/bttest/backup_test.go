@@ -0,0 +1,330 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bttest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	btapb "google.golang.org/genproto/googleapis/bigtable/admin/v2"
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestBackupsWithAdminClient(t *testing.T) {
+	ctx := context.Background()
+	ac, client := newTestClients(t)
+
+	if err := ac.CreateTable(ctx, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.CreateColumnFamily(ctx, "src", "cf"); err != nil {
+		t.Fatal(err)
+	}
+	tbl := client.Open("src")
+	set := func(row, value string) {
+		mut := bigtable.NewMutation()
+		mut.Set("cf", "col", 1000, []byte(value))
+		if err := tbl.Apply(ctx, row, mut); err != nil {
+			t.Fatal(err)
+		}
+	}
+	set("a", "a1")
+	set("b", "b1")
+
+	expire := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	if err := ac.CreateBackup(ctx, "src", "cluster", "bak", expire); err != nil {
+		t.Fatalf("CreateBackup: %v", err)
+	}
+	if err := ac.CreateBackup(ctx, "src", "cluster", "bak", expire); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("duplicate CreateBackup: got %v, want AlreadyExists", err)
+	}
+	if err := ac.CreateBackup(ctx, "src", "cluster", "short", time.Now().Add(time.Hour)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateBackup expiring in 1h: got %v, want InvalidArgument", err)
+	}
+	if err := ac.CreateBackup(ctx, "missing", "cluster", "bak2", expire); status.Code(err) != codes.NotFound {
+		t.Errorf("CreateBackup of missing table: got %v, want NotFound", err)
+	}
+	// Changes after the backup must not show up in it.
+	set("a", "a2")
+	set("c", "c1")
+
+	info, err := ac.BackupInfo(ctx, "cluster", "bak")
+	if err != nil {
+		t.Fatalf("BackupInfo: %v", err)
+	}
+	if info.Name != "bak" || info.SourceTable != "src" || info.SizeBytes != 4 || info.State != "READY" {
+		t.Errorf("BackupInfo = %+v, want READY backup of src with 4 bytes", info)
+	}
+	if !info.ExpireTime.Equal(expire) {
+		t.Errorf("backup ExpireTime = %v, want %v", info.ExpireTime, expire)
+	}
+
+	newExpire := expire.Add(48 * time.Hour)
+	if err := ac.UpdateBackup(ctx, "cluster", "bak", newExpire); err != nil {
+		t.Fatalf("UpdateBackup: %v", err)
+	}
+	if info, err := ac.BackupInfo(ctx, "cluster", "bak"); err != nil || !info.ExpireTime.Equal(newExpire) {
+		t.Errorf("BackupInfo after update = %+v, %v; want ExpireTime %v", info, err, newExpire)
+	}
+	if err := ac.UpdateBackup(ctx, "cluster", "bak", time.Now().Add(365*24*time.Hour)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("UpdateBackup to a year away: got %v, want InvalidArgument", err)
+	}
+
+	if err := ac.CreateBackup(ctx, "src", "other", "bak2", expire); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		cluster string
+		want    []string
+	}{
+		{"cluster", []string{"bak"}},
+		{"other", []string{"bak2"}},
+		{"-", []string{"bak", "bak2"}},
+	} {
+		var got []string
+		it := ac.Backups(ctx, test.cluster)
+		for {
+			info, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Backups(%q): %v", test.cluster, err)
+			}
+			got = append(got, info.Name)
+		}
+		if !cmp.Equal(got, test.want) {
+			t.Errorf("Backups(%q) = %v, want %v", test.cluster, got, test.want)
+		}
+	}
+
+	if err := ac.RestoreTable(ctx, "restored", "cluster", "bak"); err != nil {
+		t.Fatalf("RestoreTable: %v", err)
+	}
+	if err := ac.RestoreTable(ctx, "restored", "cluster", "bak"); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("RestoreTable over existing table: got %v, want AlreadyExists", err)
+	}
+	if got, want := readValues(t, client.Open("restored")), map[string]string{"a": "a1", "b": "b1"}; !cmp.Equal(got, want) {
+		t.Errorf("restored table = %v, want %v", got, want)
+	}
+	ti, err := ac.TableInfo(ctx, "restored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(ti.Families, []string{"cf"}) {
+		t.Errorf("restored families = %v, want [cf]", ti.Families)
+	}
+
+	if err := ac.DeleteBackup(ctx, "cluster", "bak"); err != nil {
+		t.Fatalf("DeleteBackup: %v", err)
+	}
+	if _, err := ac.BackupInfo(ctx, "cluster", "bak"); status.Code(err) != codes.NotFound {
+		t.Errorf("BackupInfo after delete: got %v, want NotFound", err)
+	}
+	if err := ac.RestoreTable(ctx, "restored2", "cluster", "bak"); status.Code(err) != codes.NotFound {
+		t.Errorf("RestoreTable of deleted backup: got %v, want NotFound", err)
+	}
+	// The restored table doesn't depend on the backup.
+	if got := readValues(t, client.Open("restored")); len(got) != 2 {
+		t.Errorf("restored table after backup deleted = %v, want 2 rows", got)
+	}
+}
+
+func TestBackupExpiryAndMetadata(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	t0 := time.Now()
+	now = func() time.Time { return t0 }
+
+	ctx := context.Background()
+	s := &server{tables: make(map[string]*table)}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: "instance", TableId: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	const name = "instance/clusters/c/backups/b"
+	op, err := s.CreateBackup(ctx, &btapb.CreateBackupRequest{
+		Parent:   "instance/clusters/c",
+		BackupId: "b",
+		Backup: &btapb.Backup{
+			SourceTable: "instance/tables/t",
+			ExpireTime:  timestamppb.New(t0.Add(12 * time.Hour)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var md btapb.CreateBackupMetadata
+	if err := op.Metadata.UnmarshalTo(&md); err != nil {
+		t.Fatal(err)
+	}
+	if md.Name != name || md.SourceTable != "instance/tables/t" || md.EndTime == nil {
+		t.Errorf("CreateBackup metadata = %v", &md)
+	}
+	if got, err := s.GetOperation(ctx, &longrunning.GetOperationRequest{Name: op.Name}); err != nil || !got.Done {
+		t.Errorf("GetOperation(%q) = %v, %v; want a done operation", op.Name, got, err)
+	}
+
+	op, err = s.RestoreTable(ctx, &btapb.RestoreTableRequest{
+		Parent:  "instance",
+		TableId: "restored",
+		Source:  &btapb.RestoreTableRequest_Backup{Backup: name},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rmd btapb.RestoreTableMetadata
+	if err := op.Metadata.UnmarshalTo(&rmd); err != nil {
+		t.Fatal(err)
+	}
+	if rmd.Name != "instance/tables/restored" || rmd.GetBackupInfo().GetBackup() != name || rmd.Progress.GetProgressPercent() != 100 {
+		t.Errorf("RestoreTable metadata = %v", &rmd)
+	}
+	tbl, err := s.GetTable(ctx, &btapb.GetTableRequest{Name: "instance/tables/restored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tbl.RestoreInfo.GetBackupInfo().GetBackup(); got != name {
+		t.Errorf("restored table's backup = %q, want %q", got, name)
+	}
+
+	// Updating the expiry time must keep it in the future.
+	now = func() time.Time { return t0.Add(10 * time.Hour) }
+	_, err = s.UpdateBackup(ctx, &btapb.UpdateBackupRequest{
+		Backup:     &btapb.Backup{Name: name, ExpireTime: timestamppb.New(t0.Add(8 * time.Hour))},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"expire_time"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("UpdateBackup to a past time: got %v, want InvalidArgument", err)
+	}
+	_, err = s.UpdateBackup(ctx, &btapb.UpdateBackupRequest{
+		Backup:     &btapb.Backup{Name: name, SourceTable: "other"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"source_table"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("UpdateBackup of source_table: got %v, want InvalidArgument", err)
+	}
+
+	now = func() time.Time { return t0.Add(12 * time.Hour) }
+	if _, err := s.GetBackup(ctx, &btapb.GetBackupRequest{Name: name}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetBackup after expiry: got %v, want NotFound", err)
+	}
+	res, err := s.ListBackups(ctx, &btapb.ListBackupsRequest{Parent: "instance/clusters/-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Backups) != 0 {
+		t.Errorf("ListBackups after expiry = %v, want none", res.Backups)
+	}
+}
+
+func TestRestoreTablePersisted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	srv, err := NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := srv.s
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: "instance", TableId: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	const name = "instance/clusters/c/backups/b"
+	if _, err := s.CreateBackup(ctx, &btapb.CreateBackupRequest{
+		Parent:   "instance/clusters/c",
+		BackupId: "b",
+		Backup: &btapb.Backup{
+			SourceTable: "instance/tables/t",
+			ExpireTime:  timestamppb.New(time.Now().Add(12 * time.Hour)),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RestoreTable(ctx, &btapb.RestoreTableRequest{
+		Parent:  "instance",
+		TableId: "restored",
+		Source:  &btapb.RestoreTableRequest_Backup{Backup: name},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	srv, err = NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	tbl, err := srv.s.GetTable(ctx, &btapb.GetTableRequest{Name: "instance/tables/restored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tbl.RestoreInfo.GetBackupInfo().GetBackup(); got != name {
+		t.Errorf("restored table's backup after reload = %q, want %q", got, name)
+	}
+}
+
+// newTestClients starts a Server and returns admin and data clients connected to it.
+// Everything is shut down when the test ends.
+func newTestClients(t *testing.T) (*bigtable.AdminClient, *bigtable.Client) {
+	t.Helper()
+	ctx := context.Background()
+	srv, err := NewServer("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	conn, err := grpc.Dial(srv.Addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	ac, err := bigtable.NewAdminClient(ctx, "proj", "instance", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ac.Close() })
+	client, err := bigtable.NewClient(ctx, "proj", "instance", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return ac, client
+}
+
+// readValues returns the latest value in each row of the table, keyed by row key.
+func readValues(t *testing.T, tbl *bigtable.Table) map[string]string {
+	t.Helper()
+	got := make(map[string]string)
+	err := tbl.ReadRows(context.Background(), bigtable.InfiniteRange(""), func(r bigtable.Row) bool {
+		for _, items := range r {
+			got[r.Key()] = string(items[0].Value)
+		}
+		return true
+	}, bigtable.RowFilter(bigtable.LatestNFilter(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
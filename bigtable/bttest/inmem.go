@@ -27,6 +27,12 @@ To use a Server, create it, and then connect to it with no security:
 	client, err := bigtable.NewClient(ctx, proj, instance,
 	        option.WithGRPCConn(conn))
 	...
+
+By default all data is kept in memory. To keep tables across restarts,
+create the Server with NewServerWithConfig and set ServerConfig.DataDir.
+Table snapshots and backups are kept in memory only, and are deleted
+when they expire. A table restored from a backup keeps its restore info
+across restarts, even though the backup doesn't survive them.
 */
 package bttest // import "cloud.google.com/go/bigtable/bttest"
 
@@ -91,17 +97,49 @@ type server struct {
 	tables    map[string]*table          // keyed by fully qualified name
 	instances map[string]*btapb.Instance // keyed by fully qualified name
 	gcc       chan int                   // set when gcloop starts, closed when server shuts down
+	dataDir   string                     // where tables are persisted; empty to keep them only in memory
+	snapshots map[string]*snapshot       // keyed by fully qualified name
+	backups   map[string]*backup         // keyed by fully qualified name
+
+	operations map[string]*longrunning.Operation // keyed by operation name
+	opCounter  int                               // used to name new operations
 
 	// Any unimplemented methods will cause a panic.
 	btapb.BigtableTableAdminServer
 	btapb.BigtableInstanceAdminServer
 	btpb.BigtableServer
+	longrunning.OperationsServer
+}
+
+// ServerConfig has configurations for the Server.
+type ServerConfig struct {
+	// DataDir, if non-empty, is a directory in which the server persists its
+	// tables, column families and cells. Tables already stored there are
+	// loaded when the server starts, so data survives restarts of the server.
+	// The directory is created if it does not exist.
+	// Only one Server may use a directory at a time.
+	DataDir string
 }
 
 // NewServer creates a new Server.
 // The Server will be listening for gRPC connections, without TLS,
 // on the provided address. The resolved address is named by the Addr field.
 func NewServer(laddr string, opt ...grpc.ServerOption) (*Server, error) {
+	return NewServerWithConfig(laddr, ServerConfig{}, opt...)
+}
+
+// NewServerWithConfig creates a new Server with the given configuration.
+// See NewServer for details.
+func NewServerWithConfig(laddr string, config ServerConfig, opt ...grpc.ServerOption) (*Server, error) {
+	tables := make(map[string]*table)
+	if config.DataDir != "" {
+		var err error
+		tables, err = loadTables(config.DataDir)
+		if err != nil {
+			return nil, fmt.Errorf("bttest: loading data from %s: %v", config.DataDir, err)
+		}
+	}
+
 	l, err := net.Listen("tcp", laddr)
 	if err != nil {
 		return nil, err
@@ -112,13 +150,22 @@ func NewServer(laddr string, opt ...grpc.ServerOption) (*Server, error) {
 		l:    l,
 		srv:  grpc.NewServer(opt...),
 		s: &server{
-			tables:    make(map[string]*table),
+			tables:    tables,
 			instances: make(map[string]*btapb.Instance),
+			dataDir:   config.DataDir,
 		},
 	}
+	for _, tbl := range tables {
+		if len(tbl.families) > 0 {
+			// Reapply GC rules to the loaded data.
+			s.s.needGC()
+			break
+		}
+	}
 	btapb.RegisterBigtableInstanceAdminServer(s.srv, s.s)
 	btapb.RegisterBigtableTableAdminServer(s.srv, s.s)
 	btpb.RegisterBigtableServer(s.srv, s.s)
+	longrunning.RegisterOperationsServer(s.srv, s.s)
 
 	go s.srv.Serve(s.l)
 
@@ -135,6 +182,18 @@ func (s *Server) Close() {
 
 	s.srv.Stop()
 	s.l.Close()
+
+	// Stop persisting tables only once no more RPCs can modify them.
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+	for name, tbl := range s.s.tables {
+		if tbl.store == nil {
+			continue
+		}
+		if err := tbl.store.close(); err != nil {
+			log.Printf("bttest: closing storage for table %q: %v", name, err)
+		}
+	}
 }
 
 func (s *server) CreateTable(ctx context.Context, req *btapb.CreateTableRequest) (*btapb.Table, error) {
@@ -145,7 +204,16 @@ func (s *server) CreateTable(ctx context.Context, req *btapb.CreateTableRequest)
 		s.mu.Unlock()
 		return nil, status.Errorf(codes.AlreadyExists, "table %q already exists", tbl)
 	}
-	s.tables[tbl] = newTable(req)
+	t := newTable(req)
+	if s.dataDir != "" {
+		ts, err := newTableStore(s.dataDir, t, tbl)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, status.Errorf(codes.Internal, "persisting table %q: %v", tbl, err)
+		}
+		t.store = ts
+	}
+	s.tables[tbl] = t
 	s.mu.Unlock()
 
 	ct := &btapb.Table{
@@ -160,10 +228,6 @@ func (s *server) CreateTable(ctx context.Context, req *btapb.CreateTableRequest)
 	return ct, nil
 }
 
-func (s *server) CreateTableFromSnapshot(context.Context, *btapb.CreateTableFromSnapshotRequest) (*longrunning.Operation, error) {
-	return nil, status.Errorf(codes.Unimplemented, "the emulator does not currently support snapshots")
-}
-
 func (s *server) ListTables(ctx context.Context, req *btapb.ListTablesRequest) (*btapb.ListTablesResponse, error) {
 	res := &btapb.ListTablesResponse{}
 	prefix := req.Parent + "/tables/"
@@ -193,6 +257,7 @@ func (s *server) GetTable(ctx context.Context, req *btapb.GetTableRequest) (*bta
 		Name:               tbl,
 		ColumnFamilies:     toColumnFamilies(tblIns.columnFamilies()),
 		DeletionProtection: tblIns.isProtected,
+		RestoreInfo:        tblIns.restoreInfo,
 	}, nil
 }
 
@@ -205,6 +270,11 @@ func (s *server) DeleteTable(ctx context.Context, req *btapb.DeleteTableRequest)
 	if s.tables[req.Name].isProtected {
 		return nil, status.Errorf(codes.FailedPrecondition, "table %q is protected from deletion", req.Name)
 	}
+	if ts := s.tables[req.Name].store; ts != nil {
+		if err := ts.destroy(); err != nil {
+			return nil, status.Errorf(codes.Internal, "deleting storage for table %q: %v", req.Name, err)
+		}
+	}
 	delete(s.tables, req.Name)
 	return &emptypb.Empty{}, nil
 }
@@ -231,6 +301,9 @@ func (s *server) UpdateTable(ctx context.Context, req *btapb.UpdateTableRequest)
 	defer tbl.mu.Unlock()
 
 	tbl.isProtected = req.GetTable().GetDeletionProtection()
+	if err := tbl.saveSchema(req.GetTable().GetName()); err != nil {
+		return nil, err
+	}
 
 	res := &longrunning.Operation_Response{}
 	lro := &longrunning.Operation{
@@ -309,13 +382,20 @@ func (s *server) ModifyColumnFamilies(ctx context.Context, req *btapb.ModifyColu
 			delete(tbl.families, mod.Id)
 
 			// Purge all data for this column family
+			var err error
 			tbl.rows.Ascend(func(i btree.Item) bool {
 				r := i.(*row)
 				r.mu.Lock()
 				defer r.mu.Unlock()
-				delete(r.families, mod.Id)
-				return true
+				if _, ok := r.families[mod.Id]; ok {
+					delete(r.families, mod.Id)
+					err = tbl.saveRow(r)
+				}
+				return err == nil
 			})
+			if err != nil {
+				return nil, err
+			}
 		} else if modify := mod.GetUpdate(); modify != nil {
 			if _, ok := tbl.families[mod.Id]; !ok {
 				return nil, fmt.Errorf("no such family %q", mod.Id)
@@ -330,6 +410,10 @@ func (s *server) ModifyColumnFamilies(ctx context.Context, req *btapb.ModifyColu
 		}
 	}
 
+	if err := tbl.saveSchema(req.Name); err != nil {
+		return nil, err
+	}
+
 	s.needGC()
 	return &btapb.Table{
 		Name:           req.Name,
@@ -349,7 +433,17 @@ func (s *server) DropRowRange(ctx context.Context, req *btapb.DropRowRangeReques
 	tbl.mu.Lock()
 	defer tbl.mu.Unlock()
 	if req.GetDeleteAllDataFromTable() {
+		var keys []string
+		if tbl.store != nil {
+			tbl.rows.Ascend(func(i btree.Item) bool {
+				keys = append(keys, i.(*row).key)
+				return true
+			})
+		}
 		tbl.rows = btree.New(btreeDegree)
+		if err := tbl.saveDeletedRows(keys); err != nil {
+			return nil, err
+		}
 	} else {
 		// Delete rows by prefix.
 		prefixBytes := req.GetRowKeyPrefix()
@@ -370,8 +464,13 @@ func (s *server) DropRowRange(ctx context.Context, req *btapb.DropRowRangeReques
 			}
 			return false // stop iteration
 		})
-		for _, r := range rowsToDelete {
+		keys := make([]string, len(rowsToDelete))
+		for i, r := range rowsToDelete {
 			tbl.rows.Delete(r)
+			keys[i] = r.key
+		}
+		if err := tbl.saveDeletedRows(keys); err != nil {
+			return nil, err
 		}
 	}
 	return &emptypb.Empty{}, nil
@@ -407,22 +506,6 @@ func (s *server) CheckConsistency(ctx context.Context, req *btapb.CheckConsisten
 	}, nil
 }
 
-func (s *server) SnapshotTable(context.Context, *btapb.SnapshotTableRequest) (*longrunning.Operation, error) {
-	return nil, status.Errorf(codes.Unimplemented, "the emulator does not currently support snapshots")
-}
-
-func (s *server) GetSnapshot(context.Context, *btapb.GetSnapshotRequest) (*btapb.Snapshot, error) {
-	return nil, status.Errorf(codes.Unimplemented, "the emulator does not currently support snapshots")
-}
-
-func (s *server) ListSnapshots(context.Context, *btapb.ListSnapshotsRequest) (*btapb.ListSnapshotsResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "the emulator does not currently support snapshots")
-}
-
-func (s *server) DeleteSnapshot(context.Context, *btapb.DeleteSnapshotRequest) (*emptypb.Empty, error) {
-	return nil, status.Errorf(codes.Unimplemented, "the emulator does not currently support snapshots")
-}
-
 func (s *server) ReadRows(req *btpb.ReadRowsRequest, stream btpb.Bigtable_ReadRowsServer) error {
 	start := time.Now()
 	s.mu.Lock()
@@ -944,7 +1027,12 @@ func (s *server) MutateRow(ctx context.Context, req *btpb.MutateRowRequest) (*bt
 	r := tbl.mutableRow(string(req.RowKey))
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if err := applyMutations(tbl, r, req.Mutations, fs); err != nil {
+	err := applyMutations(tbl, r, req.Mutations, fs)
+	// Persist even a partially applied mutation, so storage matches memory.
+	if perr := tbl.saveRow(r); err == nil {
+		err = perr
+	}
+	if err != nil {
 		return nil, err
 	}
 	return &btpb.MutateRowResponse{}, nil
@@ -975,7 +1063,11 @@ func (s *server) MutateRows(req *btpb.MutateRowsRequest, stream btpb.Bigtable_Mu
 		r := tbl.mutableRow(string(entry.RowKey))
 		r.mu.Lock()
 		code, msg := int32(codes.OK), ""
-		if err := applyMutations(tbl, r, entry.Mutations, fs); err != nil {
+		err := applyMutations(tbl, r, entry.Mutations, fs)
+		if perr := tbl.saveRow(r); err == nil {
+			err = perr
+		}
+		if err != nil {
 			code = int32(codes.Internal)
 			msg = err.Error()
 		}
@@ -1025,7 +1117,11 @@ func (s *server) CheckAndMutateRow(ctx context.Context, req *btpb.CheckAndMutate
 		muts = req.TrueMutations
 	}
 
-	if err := applyMutations(tbl, r, muts, fs); err != nil {
+	err := applyMutations(tbl, r, muts, fs)
+	if perr := tbl.saveRow(r); err == nil {
+		err = perr
+	}
+	if err != nil {
 		return nil, err
 	}
 	return res, nil
@@ -1222,6 +1318,9 @@ func (s *server) ReadModifyWriteRow(ctx context.Context, req *btpb.ReadModifyWri
 		resultFamily.cellsByColumn(col)           // create the column
 		resultFamily.cells[col] = []cell{newCell} // overwrite the cells
 	}
+	if err := tbl.saveRow(r); err != nil {
+		return nil, err
+	}
 
 	// Build the response using the result row
 	res := &btpb.Row{
@@ -1329,6 +1428,8 @@ type table struct {
 	families    map[string]*columnFamily // keyed by plain family name
 	rows        *btree.BTree             // indexed by row key
 	isProtected bool                     // whether this table has deletion protection
+	store       *tableStore              // persists the table; nil if the server has no DataDir
+	restoreInfo *btapb.RestoreInfo       // the backup the table was restored from; nil if it wasn't restored
 }
 
 const btreeDegree = 16
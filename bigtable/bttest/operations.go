@@ -0,0 +1,70 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bttest
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+var _ longrunning.OperationsServer = (*server)(nil)
+
+// newOperation records a long-running operation on the named resource.
+// The fake does all its work synchronously, so the operation is already done
+// when it is returned, but it can still be fetched with GetOperation.
+// s.mu must not be held.
+func (s *server) newOperation(resource string, metadata, response proto.Message) (*longrunning.Operation, error) {
+	md, err := anypb.New(metadata)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "marshaling operation metadata: %v", err)
+	}
+	res, err := anypb.New(response)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "marshaling operation response: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opCounter++
+	op := &longrunning.Operation{
+		Name:     fmt.Sprintf("%s/operations/%d", resource, s.opCounter),
+		Metadata: md,
+		Done:     true,
+		Result:   &longrunning.Operation_Response{Response: res},
+	}
+	if s.operations == nil {
+		s.operations = make(map[string]*longrunning.Operation)
+	}
+	s.operations[op.Name] = op
+	return op, nil
+}
+
+func (s *server) GetOperation(ctx context.Context, req *longrunning.GetOperationRequest) (*longrunning.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op, ok := s.operations[req.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "operation %q not found", req.Name)
+	}
+	return op, nil
+}
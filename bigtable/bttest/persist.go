@@ -0,0 +1,562 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bttest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/btree"
+	btapb "google.golang.org/genproto/googleapis/bigtable/admin/v2"
+	btpb "google.golang.org/genproto/googleapis/bigtable/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// This file implements the on-disk storage used when ServerConfig.DataDir is set.
+//
+// Each table is kept in its own subdirectory of the data directory,
+// named by the path-escaped fully qualified table name. It holds:
+//
+//	schema.json  the column families, table settings and restore info
+//	snapshot     the rows of the table as of the last compaction
+//	log          rows written since the last compaction
+//	log.old      the previous log, only present during a compaction,
+//	             or after one that failed to write the snapshot
+//
+// The snapshot and logs are sequences of records, each being a uvarint length
+// followed by a marshaled btpb.Row holding the complete state of that row.
+// A row with no cells records that the row was deleted.
+// Because every record is a full row state, replaying snapshot, log.old and log
+// in that order always reproduces the latest state of every row, even if the
+// emulator stopped partway through a compaction.
+//
+// Cells removed by garbage collection aren't logged; the GC rules are applied
+// again after the table is reloaded.
+
+const (
+	schemaFile   = "schema.json"
+	snapshotFile = "snapshot"
+	logFile      = "log"
+	oldLogFile   = "log.old"
+)
+
+// minCompactRecords is the number of log records required before a table's
+// log is considered for compaction. It is a variable so tests can lower it.
+var minCompactRecords = 10000
+
+// openLogFile opens the named log for appending, renameFile renames a
+// file, and createFile creates one. They are variables so tests can inject
+// failures.
+var (
+	openLogFile = func(name string) (*os.File, error) {
+		return os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	}
+	renameFile = os.Rename
+	createFile = os.Create
+)
+
+// tableSchema is the on-disk form of a table's metadata.
+type tableSchema struct {
+	Name      string         `json:"name"`
+	Counter   uint64         `json:"counter"`
+	Protected bool           `json:"deletionProtection,omitempty"`
+	Families  []familySchema `json:"columnFamilies,omitempty"`
+	Restore   []byte         `json:"restoreInfo,omitempty"` // marshaled btapb.RestoreInfo
+}
+
+type familySchema struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Order  uint64 `json:"order"`
+	GCRule []byte `json:"gcRule,omitempty"` // marshaled btapb.GcRule
+}
+
+// tableStore persists one table in a directory.
+type tableStore struct {
+	dir string
+
+	mu         sync.Mutex
+	log        *os.File // nil once the store is closed
+	nrecs      int      // records in the log since the last compaction
+	nsnap      int      // rows in the last snapshot
+	compacting bool
+	wg         sync.WaitGroup // tracks background compactions
+}
+
+// tableDir returns the directory that holds the named table.
+func tableDir(dataDir, name string) string {
+	return filepath.Join(dataDir, url.PathEscape(name))
+}
+
+// newTableStore creates the storage for a new, empty table.
+func newTableStore(dataDir string, tbl *table, name string) (*tableStore, error) {
+	ts := &tableStore{dir: tableDir(dataDir, name)}
+	if err := os.RemoveAll(ts.dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(ts.dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := ts.writeSchema(tbl, name); err != nil {
+		return nil, err
+	}
+	f, err := openLogFile(filepath.Join(ts.dir, logFile))
+	if err != nil {
+		return nil, err
+	}
+	ts.log = f
+	return ts, nil
+}
+
+// loadTables reads all the tables persisted in dataDir.
+func loadTables(dataDir string) (map[string]*table, error) {
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return nil, err
+	}
+	ents, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	tables := make(map[string]*table)
+	for _, ent := range ents {
+		if !ent.IsDir() {
+			continue
+		}
+		name, tbl, err := loadTable(filepath.Join(dataDir, ent.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("loading table from %s: %v", ent.Name(), err)
+		}
+		tables[name] = tbl
+	}
+	return tables, nil
+}
+
+func loadTable(dir string) (string, *table, error) {
+	buf, err := os.ReadFile(filepath.Join(dir, schemaFile))
+	if err != nil {
+		return "", nil, err
+	}
+	var sch tableSchema
+	if err := json.Unmarshal(buf, &sch); err != nil {
+		return "", nil, err
+	}
+	tbl := &table{
+		families:    make(map[string]*columnFamily),
+		counter:     sch.Counter,
+		rows:        btree.New(btreeDegree),
+		isProtected: sch.Protected,
+	}
+	for _, fs := range sch.Families {
+		cf := &columnFamily{name: fs.Name, order: fs.Order}
+		if fs.GCRule != nil {
+			cf.gcRule = new(btapb.GcRule)
+			if err := proto.Unmarshal(fs.GCRule, cf.gcRule); err != nil {
+				return "", nil, fmt.Errorf("bad GC rule for family %q: %v", fs.ID, err)
+			}
+		}
+		tbl.families[fs.ID] = cf
+	}
+	if sch.Restore != nil {
+		tbl.restoreInfo = new(btapb.RestoreInfo)
+		if err := proto.Unmarshal(sch.Restore, tbl.restoreInfo); err != nil {
+			return "", nil, fmt.Errorf("bad restore info: %v", err)
+		}
+	}
+
+	for _, file := range []string{snapshotFile, oldLogFile, logFile} {
+		if err := replayFile(tbl, filepath.Join(dir, file)); err != nil {
+			return "", nil, fmt.Errorf("replaying %s: %v", file, err)
+		}
+	}
+
+	// Start afresh with everything in the snapshot.
+	ts := &tableStore{dir: dir}
+	if err := ts.writeSnapshot(tbl); err != nil {
+		return "", nil, err
+	}
+	for _, file := range []string{oldLogFile, logFile} {
+		if err := os.Remove(filepath.Join(dir, file)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", nil, err
+		}
+	}
+	f, err := openLogFile(filepath.Join(dir, logFile))
+	if err != nil {
+		return "", nil, err
+	}
+	ts.log = f
+	tbl.store = ts
+	return sch.Name, tbl, nil
+}
+
+// replayFile applies the row records in the named file to tbl.
+// A missing file has no records.
+func replayFile(tbl *table, filename string) error {
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		var buf []byte
+		if err == nil {
+			buf = make([]byte, n)
+			_, err = io.ReadFull(br, buf)
+		}
+		if err == io.ErrUnexpectedEOF {
+			// A truncated final record was never completely written; ignore it.
+			log.Printf("bttest: ignoring truncated record at end of %s", filename)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var pr btpb.Row
+		if err := proto.Unmarshal(buf, &pr); err != nil {
+			return err
+		}
+		r := rowFromProto(&pr, tbl.families)
+		if r.isEmpty() {
+			tbl.rows.Delete(btreeKey(r.key))
+		} else {
+			tbl.rows.ReplaceOrInsert(r)
+		}
+	}
+}
+
+// rowFromProto converts a persisted row record into a row.
+// Cells in families that no longer exist are dropped.
+func rowFromProto(pr *btpb.Row, fams map[string]*columnFamily) *row {
+	r := newRow(string(pr.Key))
+	for _, pf := range pr.Families {
+		cf, ok := fams[pf.Name]
+		if !ok {
+			continue
+		}
+		f := r.getOrCreateFamily(pf.Name, cf.order)
+		for _, pc := range pf.Columns {
+			col := string(pc.Qualifier)
+			var cs []cell
+			for _, c := range pc.Cells {
+				cs = append(cs, cell{ts: c.TimestampMicros, value: c.Value})
+			}
+			f.cellsByColumn(col) // create the column
+			f.cells[col] = cs
+		}
+	}
+	return r
+}
+
+// rowProto returns the record for the current state of a row.
+// r.mu should be held.
+func rowProto(r *row) *btpb.Row {
+	pr := &btpb.Row{Key: []byte(r.key)}
+	for _, fam := range r.sortedFamilies() {
+		pf := &btpb.Family{Name: fam.name}
+		for _, col := range fam.colNames {
+			cs := fam.cells[col]
+			if len(cs) == 0 {
+				continue
+			}
+			pc := &btpb.Column{Qualifier: []byte(col)}
+			for _, c := range cs {
+				pc.Cells = append(pc.Cells, &btpb.Cell{TimestampMicros: c.ts, Value: c.value})
+			}
+			pf.Columns = append(pf.Columns, pc)
+		}
+		if len(pf.Columns) > 0 {
+			pr.Families = append(pr.Families, pf)
+		}
+	}
+	return pr
+}
+
+// appendRecord writes a length-prefixed row record to w.
+func appendRecord(w io.Writer, pr *btpb.Row) error {
+	buf, err := proto.Marshal(pr)
+	if err != nil {
+		return err
+	}
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(buf)))
+	if _, err := w.Write(lenBuf[:n]); err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// writeSchema atomically replaces the table's schema file.
+// tbl.mu should be held, for reading at least.
+func (ts *tableStore) writeSchema(tbl *table, name string) error {
+	sch := tableSchema{
+		Name:      name,
+		Counter:   tbl.counter,
+		Protected: tbl.isProtected,
+	}
+	for id, cf := range tbl.families {
+		fs := familySchema{ID: id, Name: cf.name, Order: cf.order}
+		if cf.gcRule != nil {
+			buf, err := proto.Marshal(cf.gcRule)
+			if err != nil {
+				return err
+			}
+			fs.GCRule = buf
+		}
+		sch.Families = append(sch.Families, fs)
+	}
+	sort.Slice(sch.Families, func(i, j int) bool { return sch.Families[i].Order < sch.Families[j].Order })
+	if tbl.restoreInfo != nil {
+		buf, err := proto.Marshal(tbl.restoreInfo)
+		if err != nil {
+			return err
+		}
+		sch.Restore = buf
+	}
+	buf, err := json.MarshalIndent(sch, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(ts.dir, schemaFile), func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+// writeSnapshot atomically replaces the table's snapshot with its current rows.
+// tbl.mu must not be held.
+func (ts *tableStore) writeSnapshot(tbl *table) error {
+	return writeFileAtomic(filepath.Join(ts.dir, snapshotFile), func(w io.Writer) error {
+		tbl.mu.RLock()
+		defer tbl.mu.RUnlock()
+		var err error
+		n := 0
+		tbl.rows.Ascend(func(i btree.Item) bool {
+			r := i.(*row)
+			r.mu.Lock()
+			pr := rowProto(r)
+			r.mu.Unlock()
+			if len(pr.Families) == 0 {
+				return true
+			}
+			n++
+			err = appendRecord(w, pr)
+			return err == nil
+		})
+		ts.mu.Lock()
+		ts.nsnap = n
+		ts.mu.Unlock()
+		return err
+	})
+}
+
+// writeFileAtomic writes a file by way of a temporary file,
+// so readers see either the old or the new contents.
+func writeFileAtomic(filename string, write func(io.Writer) error) error {
+	tmp := filename + ".tmp"
+	f, err := createFile(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// saveSchema persists the table's metadata, if it is persisted at all.
+// tbl.mu should be held, for reading at least.
+func (tbl *table) saveSchema(name string) error {
+	if tbl.store == nil {
+		return nil
+	}
+	if err := tbl.store.writeSchema(tbl, name); err != nil {
+		return status.Errorf(codes.Internal, "persisting table %q: %v", name, err)
+	}
+	return nil
+}
+
+// saveRow persists the current state of a row, if the table is persisted at all.
+// r.mu should be held.
+func (tbl *table) saveRow(r *row) error {
+	if tbl.store == nil {
+		return nil
+	}
+	return tbl.store.append(tbl, rowProto(r))
+}
+
+// saveDeletedRows records that the rows with the given keys were deleted,
+// if the table is persisted at all.
+func (tbl *table) saveDeletedRows(keys []string) error {
+	if tbl.store == nil {
+		return nil
+	}
+	prs := make([]*btpb.Row, len(keys))
+	for i, key := range keys {
+		prs[i] = &btpb.Row{Key: []byte(key)}
+	}
+	return tbl.store.append(tbl, prs...)
+}
+
+// append adds row records to the log, starting a compaction if the log has grown large.
+func (ts *tableStore) append(tbl *table, prs ...*btpb.Row) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.log == nil {
+		return status.Errorf(codes.Unavailable, "server is closed")
+	}
+	for _, pr := range prs {
+		if err := appendRecord(ts.log, pr); err != nil {
+			return status.Errorf(codes.Internal, "persisting row %q: %v", pr.Key, err)
+		}
+		ts.nrecs++
+	}
+	if !ts.compacting && ts.nrecs >= minCompactRecords && ts.nrecs > 2*ts.nsnap {
+		// Compaction needs the row locks, some of which the caller holds,
+		// so it must happen in the background.
+		ts.compacting = true
+		ts.wg.Add(1)
+		go func() {
+			defer ts.wg.Done()
+			if err := ts.compact(tbl); err != nil {
+				log.Printf("bttest: compacting %s: %v", ts.dir, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// compact rewrites the snapshot of the table and discards the logged records it supersedes.
+func (ts *tableStore) compact(tbl *table) error {
+	defer func() {
+		ts.mu.Lock()
+		ts.compacting = false
+		ts.mu.Unlock()
+	}()
+
+	ts.mu.Lock()
+	if ts.log == nil {
+		ts.mu.Unlock()
+		return nil // closed
+	}
+	logName := filepath.Join(ts.dir, logFile)
+	oldLogName := filepath.Join(ts.dir, oldLogFile)
+	fi, err := os.Stat(oldLogName)
+	if err == nil {
+		if cur, cerr := ts.log.Stat(); cerr == nil && os.SameFile(fi, cur) {
+			// An earlier compaction couldn't put the current log back.
+			err = renameFile(oldLogName, logName)
+		} else {
+			// An earlier compaction failed to write the snapshot, so the old
+			// log holds records that are in neither the snapshot nor the log.
+			// Keep both logs, and just retry the snapshot, which supersedes them.
+			ts.mu.Unlock()
+			if err := ts.writeSnapshot(tbl); err != nil {
+				return err
+			}
+			return os.Remove(oldLogName)
+		}
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		ts.mu.Unlock()
+		return err
+	}
+
+	// Start a new log. Every write from here on lands in it,
+	// and it is replayed after the new snapshot.
+	// The current log stays open until the new one is, so that
+	// the store remains writable if this fails.
+	if err := renameFile(logName, oldLogName); err != nil {
+		ts.mu.Unlock()
+		return err
+	}
+	f, err := openLogFile(logName)
+	if err != nil {
+		// Put the current log back. Even if that fails, ts.log still
+		// appends to it, and the old log is replayed on load.
+		if rerr := renameFile(oldLogName, logName); rerr != nil {
+			err = fmt.Errorf("%v; restoring log: %v", err, rerr)
+		}
+		ts.mu.Unlock()
+		return err
+	}
+	old := ts.log
+	ts.log = f
+	ts.nrecs = 0
+	ts.mu.Unlock()
+	closeErr := old.Close()
+
+	// The snapshot is at least as new as everything in the old log.
+	if err := ts.writeSnapshot(tbl); err != nil {
+		return err
+	}
+	if err := os.Remove(oldLogName); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// close closes the store, then waits for any compaction to finish.
+func (ts *tableStore) close() error {
+	var err error
+	ts.mu.Lock()
+	if ts.log != nil {
+		err = ts.log.Close()
+		ts.log = nil
+	}
+	ts.mu.Unlock()
+	ts.wg.Wait()
+	return err
+}
+
+// destroy closes the store and removes all of its files.
+func (ts *tableStore) destroy() error {
+	if err := ts.close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(ts.dir)
+}
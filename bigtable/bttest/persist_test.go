@@ -0,0 +1,370 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/btree"
+	"github.com/google/go-cmp/cmp"
+	btapb "google.golang.org/genproto/googleapis/bigtable/admin/v2"
+	btpb "google.golang.org/genproto/googleapis/bigtable/v2"
+)
+
+// tableContents returns the cells of tbl, keyed by "row/family:column@timestamp".
+func tableContents(tbl *table) map[string]string {
+	m := make(map[string]string)
+	tbl.rows.Ascend(func(i btree.Item) bool {
+		r := i.(*row)
+		for _, fam := range r.families {
+			for col, cs := range fam.cells {
+				for _, c := range cs {
+					m[fmt.Sprintf("%s/%s:%s@%d", r.key, fam.name, col, c.ts)] = string(c.value)
+				}
+			}
+		}
+		return true
+	})
+	return m
+}
+
+func setCell(fam, col string, ts int64, value string) *btpb.Mutation {
+	return &btpb.Mutation{Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+		FamilyName:      fam,
+		ColumnQualifier: []byte(col),
+		TimestampMicros: ts,
+		Value:           []byte(value),
+	}}}
+}
+
+func TestPersistence(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	srv, err := NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := srv.s
+	const tblName = "cluster/tables/t"
+	_, err = s.CreateTable(ctx, &btapb.CreateTableRequest{
+		Parent:  "cluster",
+		TableId: "t",
+		Table: &btapb.Table{ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 2}}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ModifyColumnFamilies(ctx, &btapb.ModifyColumnFamiliesRequest{
+		Name: tblName,
+		Modifications: []*btapb.ModifyColumnFamiliesRequest_Modification{{
+			Id:  "other",
+			Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Create{Create: &btapb.ColumnFamily{}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []*btpb.MutateRowRequest{
+		{TableName: tblName, RowKey: []byte("a"), Mutations: []*btpb.Mutation{setCell("cf", "col", 1000, "a1"), setCell("other", "x", 1000, "ax")}},
+		{TableName: tblName, RowKey: []byte("a"), Mutations: []*btpb.Mutation{setCell("cf", "col", 2000, "a2")}},
+		{TableName: tblName, RowKey: []byte("b"), Mutations: []*btpb.Mutation{setCell("cf", "col", 1000, "b1")}},
+		{TableName: tblName, RowKey: []byte("b"), Mutations: []*btpb.Mutation{{Mutation: &btpb.Mutation_DeleteFromRow_{DeleteFromRow: &btpb.Mutation_DeleteFromRow{}}}}},
+		{TableName: tblName, RowKey: []byte("c1"), Mutations: []*btpb.Mutation{setCell("cf", "col", 1000, "c1")}},
+		{TableName: tblName, RowKey: []byte("c2"), Mutations: []*btpb.Mutation{setCell("cf", "col", 1000, "c2")}},
+		{TableName: tblName, RowKey: []byte("d"), Mutations: []*btpb.Mutation{setCell("cf", "col", 3000, "d3")}},
+	} {
+		if _, err := s.MutateRow(ctx, req); err != nil {
+			t.Fatalf("MutateRow(%q): %v", req.RowKey, err)
+		}
+	}
+	if _, err := s.DropRowRange(ctx, &btapb.DropRowRangeRequest{
+		Name:   tblName,
+		Target: &btapb.DropRowRangeRequest_RowKeyPrefix{RowKeyPrefix: []byte("c")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ModifyColumnFamilies(ctx, &btapb.ModifyColumnFamiliesRequest{
+		Name: tblName,
+		Modifications: []*btapb.ModifyColumnFamiliesRequest_Modification{{
+			Id:  "other",
+			Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Drop{Drop: true},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: "cluster", TableId: "deleted"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DeleteTable(ctx, &btapb.DeleteTableRequest{Name: "cluster/tables/deleted"}); err != nil {
+		t.Fatal(err)
+	}
+	want := tableContents(s.tables[tblName])
+	srv.Close()
+
+	// Reload the data in a new server.
+	srv, err = NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = srv.s
+	if len(s.tables) != 1 {
+		t.Fatalf("got %d tables after reload, want 1", len(s.tables))
+	}
+	tbl, ok := s.tables[tblName]
+	if !ok {
+		t.Fatalf("table %q missing after reload", tblName)
+	}
+	if diff := cmp.Diff(want, tableContents(tbl)); diff != "" {
+		t.Errorf("table contents after reload mismatch (-want +got):\n%s", diff)
+	}
+	wantContents := map[string]string{
+		"a/cf:col@1000": "a1",
+		"a/cf:col@2000": "a2",
+		"d/cf:col@3000": "d3",
+	}
+	if diff := cmp.Diff(wantContents, tableContents(tbl)); diff != "" {
+		t.Errorf("table contents mismatch (-want +got):\n%s", diff)
+	}
+	gt, err := s.GetTable(ctx, &btapb.GetTableRequest{Name: tblName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gt.ColumnFamilies["other"]; ok || len(gt.ColumnFamilies) != 1 {
+		t.Errorf("column families after reload = %v, want only cf", gt.ColumnFamilies)
+	}
+	if got := gt.ColumnFamilies["cf"].GetGcRule().GetMaxNumVersions(); got != 2 {
+		t.Errorf("GC rule MaxNumVersions after reload = %d, want 2", got)
+	}
+
+	// Writes after a reload persist too.
+	if _, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
+		TableName: tblName, RowKey: []byte("e"), Mutations: []*btpb.Mutation{setCell("cf", "col", 1000, "e1")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	srv, err = NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	wantContents["e/cf:col@1000"] = "e1"
+	if diff := cmp.Diff(wantContents, tableContents(srv.s.tables[tblName])); diff != "" {
+		t.Errorf("table contents after second reload mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPersistenceCompaction(t *testing.T) {
+	defer func(n int) { minCompactRecords = n }(minCompactRecords)
+	minCompactRecords = 10
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	srv, err := NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := srv.s
+	const tblName = "cluster/tables/t"
+	_, err = s.CreateTable(ctx, &btapb.CreateTableRequest{
+		Parent:  "cluster",
+		TableId: "t",
+		Table:   &btapb.Table{ColumnFamilies: map[string]*btapb.ColumnFamily{"cf": {}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("row%d", i%5)
+		ts := int64(i/5+1) * 1000
+		val := fmt.Sprint(i)
+		if _, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
+			TableName: tblName, RowKey: []byte(key), Mutations: []*btpb.Mutation{setCell("cf", "col", ts, val)},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		want[fmt.Sprintf("%s/cf:col@%d", key, ts)] = val
+	}
+	s.tables[tblName].store.wg.Wait() // let compaction finish
+	srv.Close()
+
+	// The log should have been compacted at least once.
+	if fi, err := os.Stat(filepath.Join(tableDir(dir, tblName), snapshotFile)); err != nil || fi.Size() == 0 {
+		t.Errorf("no snapshot written by compaction: %v", err)
+	}
+
+	// Simulate a write that was cut short.
+	f, err := os.OpenFile(filepath.Join(tableDir(dir, tblName), logFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{100, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	srv, err = NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	if diff := cmp.Diff(want, tableContents(srv.s.tables[tblName])); diff != "" {
+		t.Errorf("table contents after reload mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := os.Stat(filepath.Join(tableDir(dir, tblName), oldLogFile)); !os.IsNotExist(err) {
+		t.Errorf("old log still present after reload: %v", err)
+	}
+}
+
+func TestPersistenceCompactionFailure(t *testing.T) {
+	defer func(n int) { minCompactRecords = n }(minCompactRecords)
+	minCompactRecords = 10
+	defer func(open func(string) (*os.File, error), rename func(string, string) error) {
+		openLogFile, renameFile = open, rename
+	}(openLogFile, renameFile)
+	realOpen, realRename := openLogFile, renameFile
+	errInjected := errors.New("injected failure")
+
+	for _, test := range []struct {
+		desc   string
+		inject func()
+	}{
+		{"rename", func() { renameFile = func(string, string) error { return errInjected } }},
+		{"open", func() { openLogFile = func(string) (*os.File, error) { return nil, errInjected } }},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			srv, err := NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := srv.s
+			const tblName = "cluster/tables/t"
+			if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{
+				Parent:  "cluster",
+				TableId: "t",
+				Table:   &btapb.Table{ColumnFamilies: map[string]*btapb.ColumnFamily{"cf": {}}},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			want := make(map[string]string)
+			write := func(i int) {
+				t.Helper()
+				key, ts, val := fmt.Sprintf("row%d", i), int64(1000), fmt.Sprint(i)
+				if _, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
+					TableName: tblName, RowKey: []byte(key), Mutations: []*btpb.Mutation{setCell("cf", "col", ts, val)},
+				}); err != nil {
+					t.Fatalf("write %d: %v", i, err)
+				}
+				want[fmt.Sprintf("%s/cf:col@%d", key, ts)] = val
+			}
+
+			// Compactions fail, but writes keep being persisted.
+			test.inject()
+			for i := 0; i < 50; i++ {
+				write(i)
+			}
+			s.tables[tblName].store.wg.Wait()
+			openLogFile, renameFile = realOpen, realRename
+			for i := 50; i < 60; i++ {
+				write(i)
+			}
+			s.tables[tblName].store.wg.Wait()
+			srv.Close()
+
+			srv, err = NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Close()
+			if diff := cmp.Diff(want, tableContents(srv.s.tables[tblName])); diff != "" {
+				t.Errorf("table contents after reload mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPersistenceSnapshotFailure(t *testing.T) {
+	defer func(n int) { minCompactRecords = n }(minCompactRecords)
+	minCompactRecords = 10
+	defer func(create func(string) (*os.File, error)) { createFile = create }(createFile)
+	realCreate := createFile
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	srv, err := NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := srv.s
+	const tblName = "cluster/tables/t"
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{
+		Parent:  "cluster",
+		TableId: "t",
+		Table:   &btapb.Table{ColumnFamilies: map[string]*btapb.ColumnFamily{"cf": {}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	failures := 0
+	createFile = func(name string) (*os.File, error) {
+		if filepath.Base(name) == snapshotFile+".tmp" {
+			failures++
+			return nil, errors.New("injected failure")
+		}
+		return realCreate(name)
+	}
+	// Each batch of writes starts a compaction, which fails to write the snapshot.
+	want := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		key, ts, val := fmt.Sprintf("row%d", i), int64(1000), fmt.Sprint(i)
+		if _, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
+			TableName: tblName, RowKey: []byte(key), Mutations: []*btpb.Mutation{setCell("cf", "col", ts, val)},
+		}); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+		want[fmt.Sprintf("%s/cf:col@%d", key, ts)] = val
+		if i%10 == 9 {
+			s.tables[tblName].store.wg.Wait()
+		}
+	}
+	if failures != 2 {
+		t.Fatalf("got %d failed snapshots, want 2", failures)
+	}
+	createFile = realCreate
+	srv.Close()
+
+	srv, err = NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	if diff := cmp.Diff(want, tableContents(srv.s.tables[tblName])); diff != "" {
+		t.Errorf("table contents after reload mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,309 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bttest
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	emptypb "github.com/golang/protobuf/ptypes/empty"
+	"github.com/google/btree"
+	btapb "google.golang.org/genproto/googleapis/bigtable/admin/v2"
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// defaultSnapshotTTL is the lifetime of a snapshot when the request doesn't give one.
+	defaultSnapshotTTL = 24 * time.Hour
+	// maxSnapshotTTL is the longest lifetime a snapshot may have.
+	maxSnapshotTTL = 7 * 24 * time.Hour
+)
+
+// now is the time source for snapshot and backup expiry.
+// It is a variable so tests can replace it.
+var now = time.Now
+
+// tableCopy is a point-in-time copy of a table's schema and data,
+// as held by a snapshot or a backup.
+type tableCopy struct {
+	counter  uint64
+	families map[string]*columnFamily // keyed by plain family name
+	rows     *btree.BTree
+	size     int64 // total size of all cell values
+}
+
+// copyTable makes a point-in-time copy of tbl.
+func copyTable(tbl *table) *tableCopy {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+
+	tc := &tableCopy{
+		counter:  tbl.counter,
+		families: make(map[string]*columnFamily),
+		rows:     btree.New(btreeDegree),
+	}
+	for id, cf := range tbl.families {
+		tc.families[id] = cf
+	}
+	tbl.rows.Ascend(func(i btree.Item) bool {
+		r := i.(*row)
+		r.mu.Lock()
+		nr := r.copy()
+		r.mu.Unlock()
+		tc.rows.ReplaceOrInsert(nr)
+		tc.size += int64(nr.size())
+		return true
+	})
+	return tc
+}
+
+// newTable returns a new table holding a copy of the data in tc.
+// The column families are renamed to belong to the named table.
+func (tc *tableCopy) newTable(name string) *table {
+	tbl := &table{
+		counter:  tc.counter,
+		families: make(map[string]*columnFamily),
+		rows:     btree.New(btreeDegree),
+	}
+	for id, cf := range tc.families {
+		tbl.families[id] = &columnFamily{
+			name:   name + "/columnFamilies/" + id,
+			order:  cf.order,
+			gcRule: cf.gcRule,
+		}
+	}
+	tc.rows.Ascend(func(i btree.Item) bool {
+		// Rows in a tableCopy are never modified, so they don't need locking.
+		tbl.rows.ReplaceOrInsert(i.(*row).copy())
+		return true
+	})
+	return tbl
+}
+
+// snapshot is a table snapshot held by the server.
+type snapshot struct {
+	proto *btapb.Snapshot
+	data  *tableCopy
+}
+
+func (s *snapshot) expired() bool {
+	return !now().Before(s.proto.DeleteTime.AsTime())
+}
+
+// liveSnapshot returns the named snapshot, deleting it instead if it has expired.
+// s.mu must be held.
+func (s *server) liveSnapshot(name string) (*snapshot, bool) {
+	snap, ok := s.snapshots[name]
+	if ok && snap.expired() {
+		delete(s.snapshots, name)
+		return nil, false
+	}
+	return snap, ok
+}
+
+func (s *server) SnapshotTable(ctx context.Context, req *btapb.SnapshotTableRequest) (*longrunning.Operation, error) {
+	if req.Cluster == "" || req.SnapshotId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "cluster and snapshot_id are required")
+	}
+	ttl := defaultSnapshotTTL
+	if req.Ttl != nil {
+		ttl = req.Ttl.AsDuration()
+		if ttl <= 0 || ttl > maxSnapshotTTL {
+			return nil, status.Errorf(codes.InvalidArgument, "snapshot TTL %v must be positive and at most %v", ttl, maxSnapshotTTL)
+		}
+	}
+	start := now()
+	name := req.Cluster + "/snapshots/" + req.SnapshotId
+
+	s.mu.Lock()
+	tbl, ok := s.tables[req.Name]
+	if !ok {
+		s.mu.Unlock()
+		return nil, status.Errorf(codes.NotFound, "table %q not found", req.Name)
+	}
+	if _, ok := s.liveSnapshot(name); ok {
+		s.mu.Unlock()
+		return nil, status.Errorf(codes.AlreadyExists, "snapshot %q already exists", name)
+	}
+	data := copyTable(tbl)
+	snap := &snapshot{
+		proto: &btapb.Snapshot{
+			Name: name,
+			SourceTable: &btapb.Table{
+				Name:           req.Name,
+				ColumnFamilies: toColumnFamilies(data.families),
+			},
+			DataSizeBytes: data.size,
+			CreateTime:    timestamppb.New(start),
+			DeleteTime:    timestamppb.New(start.Add(ttl)),
+			State:         btapb.Snapshot_READY,
+			Description:   req.Description,
+		},
+		data: data,
+	}
+	if s.snapshots == nil {
+		s.snapshots = make(map[string]*snapshot)
+	}
+	s.snapshots[name] = snap
+	s.mu.Unlock()
+
+	return s.newOperation(name, &btapb.SnapshotTableMetadata{
+		OriginalRequest: req,
+		RequestTime:     timestamppb.New(start),
+		FinishTime:      timestamppb.New(now()),
+	}, snap.proto)
+}
+
+func (s *server) GetSnapshot(ctx context.Context, req *btapb.GetSnapshotRequest) (*btapb.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.liveSnapshot(req.Name)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "snapshot %q not found", req.Name)
+	}
+	return proto.Clone(snap.proto).(*btapb.Snapshot), nil
+}
+
+func (s *server) ListSnapshots(ctx context.Context, req *btapb.ListSnapshotsRequest) (*btapb.ListSnapshotsResponse, error) {
+	// A cluster of "-" lists snapshots in every cluster of the instance.
+	prefix := req.Parent + "/snapshots/"
+	if strings.HasSuffix(req.Parent, "/clusters/-") {
+		prefix = strings.TrimSuffix(req.Parent, "-")
+	}
+
+	s.mu.Lock()
+	var names []string
+	for name := range s.snapshots {
+		if _, ok := s.liveSnapshot(name); ok && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var snaps []*btapb.Snapshot
+	for _, name := range names {
+		snaps = append(snaps, proto.Clone(s.snapshots[name].proto).(*btapb.Snapshot))
+	}
+	s.mu.Unlock()
+
+	page, next, err := paginate(len(snaps), req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &btapb.ListSnapshotsResponse{
+		Snapshots:     snaps[page[0]:page[1]],
+		NextPageToken: next,
+	}, nil
+}
+
+// paginate returns the range of n list items on the page selected by
+// pageSize and pageToken, and the token for the following page, if any.
+// A page token is the index of the first item on the page.
+func paginate(n int, pageSize int32, pageToken string) (page [2]int, next string, err error) {
+	start := 0
+	if pageToken != "" {
+		start, err = strconv.Atoi(pageToken)
+		if err != nil || start < 0 || start > n {
+			return page, "", status.Errorf(codes.InvalidArgument, "invalid page token %q", pageToken)
+		}
+	}
+	end := n
+	if pageSize > 0 && start+int(pageSize) < n {
+		end = start + int(pageSize)
+		next = strconv.Itoa(end)
+	}
+	return [2]int{start, end}, next, nil
+}
+
+func (s *server) DeleteSnapshot(ctx context.Context, req *btapb.DeleteSnapshotRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.liveSnapshot(req.Name); !ok {
+		return nil, status.Errorf(codes.NotFound, "snapshot %q not found", req.Name)
+	}
+	delete(s.snapshots, req.Name)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *server) CreateTableFromSnapshot(ctx context.Context, req *btapb.CreateTableFromSnapshotRequest) (*longrunning.Operation, error) {
+	if req.TableId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "table_id is required")
+	}
+	start := now()
+	name := req.Parent + "/tables/" + req.TableId
+
+	s.mu.Lock()
+	snap, ok := s.liveSnapshot(req.SourceSnapshot)
+	if !ok {
+		s.mu.Unlock()
+		return nil, status.Errorf(codes.NotFound, "snapshot %q not found", req.SourceSnapshot)
+	}
+	tbl, err := s.addTableCopy(name, snap.data, nil)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.newOperation(name, &btapb.CreateTableFromSnapshotMetadata{
+		OriginalRequest: req,
+		RequestTime:     timestamppb.New(start),
+		FinishTime:      timestamppb.New(now()),
+	}, &btapb.Table{
+		Name:           name,
+		ColumnFamilies: toColumnFamilies(tbl.columnFamilies()),
+		Granularity:    btapb.Table_MILLIS,
+	})
+}
+
+// addTableCopy creates the named table from a copy of a table's data.
+// ri, if not nil, describes the backup the table is restored from.
+// s.mu must be held.
+func (s *server) addTableCopy(name string, tc *tableCopy, ri *btapb.RestoreInfo) (*table, error) {
+	if _, ok := s.tables[name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "table %q already exists", name)
+	}
+	tbl := tc.newTable(name)
+	tbl.restoreInfo = ri
+	if s.dataDir != "" {
+		ts, err := newTableStore(s.dataDir, tbl, name)
+		if err == nil {
+			if err = ts.writeSnapshot(tbl); err != nil {
+				// Don't leave an empty table behind to be loaded on restart.
+				ts.destroy()
+			}
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "persisting table %q: %v", name, err)
+		}
+		tbl.store = ts
+	}
+	s.tables[name] = tbl
+	if len(tbl.families) > 0 {
+		// Apply the GC rules, which the copied data might not satisfy any more.
+		if s.gcc == nil {
+			s.gcc = make(chan int)
+			go s.gcloop(s.gcc)
+		}
+	}
+	return tbl, nil
+}
@@ -0,0 +1,355 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bttest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	btapb "google.golang.org/genproto/googleapis/bigtable/admin/v2"
+	btpb "google.golang.org/genproto/googleapis/bigtable/v2"
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSnapshotsWithAdminClient(t *testing.T) {
+	ctx := context.Background()
+	srv, err := NewServer("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	conn, err := grpc.Dial(srv.Addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ac, err := bigtable.NewAdminClient(ctx, "proj", "instance", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ac.Close()
+	client, err := bigtable.NewClient(ctx, "proj", "instance", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := ac.CreateTable(ctx, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.CreateColumnFamily(ctx, "src", "cf"); err != nil {
+		t.Fatal(err)
+	}
+	tbl := client.Open("src")
+	set := func(row, value string) {
+		mut := bigtable.NewMutation()
+		mut.Set("cf", "col", 1000, []byte(value))
+		if err := tbl.Apply(ctx, row, mut); err != nil {
+			t.Fatal(err)
+		}
+	}
+	set("a", "a1")
+	set("b", "b1")
+
+	if err := ac.SnapshotTable(ctx, "src", "cluster", "snap", time.Hour); err != nil {
+		t.Fatalf("SnapshotTable: %v", err)
+	}
+	if err := ac.SnapshotTable(ctx, "src", "cluster", "snap", time.Hour); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("duplicate SnapshotTable: got %v, want AlreadyExists", err)
+	}
+	// Changes after the snapshot must not show up in it.
+	set("a", "a2")
+	set("c", "c1")
+
+	info, err := ac.SnapshotInfo(ctx, "cluster", "snap")
+	if err != nil {
+		t.Fatalf("SnapshotInfo: %v", err)
+	}
+	if info.Name != "snap" || info.SourceTable != "src" || info.DataSize != 4 {
+		t.Errorf("SnapshotInfo = %+v, want snap of src with 4 bytes", info)
+	}
+	if got := info.DeleteTime.Sub(info.CreateTime); got != time.Hour {
+		t.Errorf("snapshot TTL = %v, want 1h", got)
+	}
+
+	if err := ac.SnapshotTable(ctx, "src", "other", "snap2", bigtable.DefaultSnapshotDuration); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		cluster string
+		want    []string
+	}{
+		{"cluster", []string{"snap"}},
+		{"other", []string{"snap2"}},
+		{"-", []string{"snap", "snap2"}},
+	} {
+		var got []string
+		it := ac.Snapshots(ctx, test.cluster)
+		for {
+			info, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Snapshots(%q): %v", test.cluster, err)
+			}
+			got = append(got, info.Name)
+		}
+		if !cmp.Equal(got, test.want) {
+			t.Errorf("Snapshots(%q) = %v, want %v", test.cluster, got, test.want)
+		}
+	}
+
+	if err := ac.CreateTableFromSnapshot(ctx, "restored", "cluster", "snap"); err != nil {
+		t.Fatalf("CreateTableFromSnapshot: %v", err)
+	}
+	if err := ac.CreateTableFromSnapshot(ctx, "restored", "cluster", "snap"); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateTableFromSnapshot over existing table: got %v, want AlreadyExists", err)
+	}
+	got := make(map[string]string)
+	err = client.Open("restored").ReadRows(ctx, bigtable.InfiniteRange(""), func(r bigtable.Row) bool {
+		for _, item := range r["cf"] {
+			got[r.Key()] = string(item.Value)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"a": "a1", "b": "b1"}; !cmp.Equal(got, want) {
+		t.Errorf("restored table = %v, want %v", got, want)
+	}
+	ti, err := ac.TableInfo(ctx, "restored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(ti.Families, []string{"cf"}) {
+		t.Errorf("restored families = %v, want [cf]", ti.Families)
+	}
+
+	if err := ac.DeleteSnapshot(ctx, "cluster", "snap"); err != nil {
+		t.Fatalf("DeleteSnapshot: %v", err)
+	}
+	if _, err := ac.SnapshotInfo(ctx, "cluster", "snap"); status.Code(err) != codes.NotFound {
+		t.Errorf("SnapshotInfo after delete: got %v, want NotFound", err)
+	}
+	if err := ac.CreateTableFromSnapshot(ctx, "restored2", "cluster", "snap"); status.Code(err) != codes.NotFound {
+		t.Errorf("CreateTableFromSnapshot of deleted snapshot: got %v, want NotFound", err)
+	}
+}
+
+func TestSnapshotExpiry(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	t0 := time.Now()
+	now = func() time.Time { return t0 }
+
+	ctx := context.Background()
+	s := &server{tables: make(map[string]*table)}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: "instance", TableId: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	op, err := s.SnapshotTable(ctx, &btapb.SnapshotTableRequest{
+		Name:       "instance/tables/t",
+		Cluster:    "instance/clusters/c",
+		SnapshotId: "snap",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !op.Done || op.GetResponse() == nil {
+		t.Errorf("SnapshotTable operation = %v, want a done operation with a response", op)
+	}
+	if got, err := s.GetOperation(ctx, &longrunning.GetOperationRequest{Name: op.Name}); err != nil || got.Name != op.Name {
+		t.Errorf("GetOperation(%q) = %v, %v", op.Name, got, err)
+	}
+	const name = "instance/clusters/c/snapshots/snap"
+	if _, err := s.GetSnapshot(ctx, &btapb.GetSnapshotRequest{Name: name}); err != nil {
+		t.Fatal(err)
+	}
+
+	now = func() time.Time { return t0.Add(defaultSnapshotTTL) }
+	if _, err := s.GetSnapshot(ctx, &btapb.GetSnapshotRequest{Name: name}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetSnapshot after expiry: got %v, want NotFound", err)
+	}
+	res, err := s.ListSnapshots(ctx, &btapb.ListSnapshotsRequest{Parent: "instance/clusters/c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Snapshots) != 0 {
+		t.Errorf("ListSnapshots after expiry = %v, want none", res.Snapshots)
+	}
+	// The snapshot ID can be reused once the old snapshot has expired.
+	if _, err := s.SnapshotTable(ctx, &btapb.SnapshotTableRequest{
+		Name:       "instance/tables/t",
+		Cluster:    "instance/clusters/c",
+		SnapshotId: "snap",
+	}); err != nil {
+		t.Errorf("SnapshotTable after expiry: %v", err)
+	}
+}
+
+func TestListSnapshotsPaging(t *testing.T) {
+	ctx := context.Background()
+	s := &server{tables: make(map[string]*table)}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: "instance", TableId: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"s3", "s1", "s2"} {
+		if _, err := s.SnapshotTable(ctx, &btapb.SnapshotTableRequest{
+			Name:       "instance/tables/t",
+			Cluster:    "instance/clusters/c",
+			SnapshotId: id,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	req := &btapb.ListSnapshotsRequest{Parent: "instance/clusters/c", PageSize: 2}
+	for pages := 0; ; pages++ {
+		if pages > 2 {
+			t.Fatal("too many pages")
+		}
+		res, err := s.ListSnapshots(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, snap := range res.Snapshots {
+			got = append(got, snap.Name)
+		}
+		if res.NextPageToken == "" {
+			break
+		}
+		req.PageToken = res.NextPageToken
+	}
+	want := []string{"instance/clusters/c/snapshots/s1", "instance/clusters/c/snapshots/s2", "instance/clusters/c/snapshots/s3"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("ListSnapshots = %v, want %v", got, want)
+	}
+	if _, err := s.ListSnapshots(ctx, &btapb.ListSnapshotsRequest{Parent: "instance/clusters/c", PageToken: "x"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ListSnapshots with bad token: got %v, want InvalidArgument", err)
+	}
+}
+
+func TestCreateTableFromSnapshotPersisted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	srv, err := NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := srv.s
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{
+		Parent:  "instance",
+		TableId: "t",
+		Table:   &btapb.Table{ColumnFamilies: map[string]*btapb.ColumnFamily{"cf": {}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
+		TableName: "instance/tables/t", RowKey: []byte("r"), Mutations: []*btpb.Mutation{setCell("cf", "col", 1000, "v")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SnapshotTable(ctx, &btapb.SnapshotTableRequest{
+		Name:       "instance/tables/t",
+		Cluster:    "instance/clusters/c",
+		SnapshotId: "snap",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateTableFromSnapshot(ctx, &btapb.CreateTableFromSnapshotRequest{
+		Parent:         "instance",
+		TableId:        "copy",
+		SourceSnapshot: "instance/clusters/c/snapshots/snap",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	srv, err = NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	tbl, ok := srv.s.tables["instance/tables/copy"]
+	if !ok {
+		t.Fatal("restored table missing after reload")
+	}
+	if diff := cmp.Diff(map[string]string{"r/cf:col@1000": "v"}, tableContents(tbl)); diff != "" {
+		t.Errorf("restored table contents after reload mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCreateTableFromSnapshotPersistFailure(t *testing.T) {
+	defer func(create func(string) (*os.File, error)) { createFile = create }(createFile)
+	realCreate := createFile
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	srv, err := NewServerWithConfig("localhost:0", ServerConfig{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	s := srv.s
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{
+		Parent:  "instance",
+		TableId: "t",
+		Table:   &btapb.Table{ColumnFamilies: map[string]*btapb.ColumnFamily{"cf": {}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SnapshotTable(ctx, &btapb.SnapshotTableRequest{
+		Name:       "instance/tables/t",
+		Cluster:    "instance/clusters/c",
+		SnapshotId: "snap",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	createFile = func(name string) (*os.File, error) {
+		if filepath.Base(name) == snapshotFile+".tmp" {
+			return nil, errors.New("injected failure")
+		}
+		return realCreate(name)
+	}
+	_, err = s.CreateTableFromSnapshot(ctx, &btapb.CreateTableFromSnapshotRequest{
+		Parent:         "instance",
+		TableId:        "copy",
+		SourceSnapshot: "instance/clusters/c/snapshots/snap",
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("CreateTableFromSnapshot: got %v, want Internal", err)
+	}
+	if _, ok := s.tables["instance/tables/copy"]; ok {
+		t.Error("table added despite the failure")
+	}
+	if _, err := os.Stat(tableDir(dir, "instance/tables/copy")); !os.IsNotExist(err) {
+		t.Errorf("table directory left behind: %v", err)
+	}
+}
@@ -14,6 +14,9 @@
 
 /*
 cbtemulator launches the in-memory Cloud Bigtable server on the given address.
+
+With -data_dir, tables are also persisted in the named directory
+and reloaded the next time the emulator starts.
 */
 package main
 
@@ -27,8 +30,9 @@ import (
 )
 
 var (
-	host = flag.String("host", "localhost", "the address to bind to on the local machine")
-	port = flag.Int("port", 9000, "the port number to bind to on the local machine")
+	host    = flag.String("host", "localhost", "the address to bind to on the local machine")
+	port    = flag.Int("port", 9000, "the port number to bind to on the local machine")
+	dataDir = flag.String("data_dir", "", "if non-empty, the directory in which to persist tables across restarts")
 )
 
 const (
@@ -42,7 +46,8 @@ func main() {
 		grpc.MaxRecvMsgSize(maxMsgSize),
 		grpc.MaxSendMsgSize(maxMsgSize),
 	}
-	srv, err := bttest.NewServer(fmt.Sprintf("%s:%d", *host, *port), opts...)
+	cfg := bttest.ServerConfig{DataDir: *dataDir}
+	srv, err := bttest.NewServerWithConfig(fmt.Sprintf("%s:%d", *host, *port), cfg, opts...)
 	if err != nil {
 		log.Fatalf("failed to start emulator: %v", err)
 	}
@@ -432,6 +432,30 @@ func ExampleQuery_Documents() {
 	_ = iter2 // TODO: Use iter2.
 }
 
+func ExampleQuery_WhereEntity() {
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	defer client.Close()
+
+	// States with a large population, or that are both small and in the west.
+	q := client.Collection("States").WhereEntity(firestore.OrFilter{
+		Filters: []firestore.EntityFilter{
+			firestore.PropertyFilter{Path: "pop", Operator: ">", Value: 10},
+			firestore.AndFilter{
+				Filters: []firestore.EntityFilter{
+					firestore.PropertyFilter{Path: "area", Operator: "<", Value: 1000},
+					firestore.PropertyFilter{Path: "region", Operator: "in", Value: []string{"West", "Pacific"}},
+				},
+			},
+		},
+	})
+	iter := q.Documents(ctx)
+	_ = iter // TODO: Use iter.
+}
+
 // This example is just like the one above, but illustrates
 // how to use the XXXPath methods of Query for field paths
 // that can't be expressed as a dot-separated string.
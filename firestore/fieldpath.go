@@ -235,6 +235,52 @@ func (fp FieldPath) toServiceFieldPath() string {
 	return strings.Join(cs, ".")
 }
 
+// parseServiceFieldPath parses a field path in the form required by the
+// Firestore service, the inverse of toServiceFieldPath.
+func parseServiceFieldPath(s string) (FieldPath, error) {
+	var (
+		fp     FieldPath
+		buf    strings.Builder
+		quoted bool // in a quoted component
+		closed bool // the component was quoted, and has ended
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quoted && c == '\\':
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("firestore: bad escape in field path %q", s)
+			}
+			i++
+			buf.WriteByte(s[i])
+		case quoted && c == '`':
+			quoted, closed = false, true
+		case quoted:
+			buf.WriteByte(c)
+		case c == '.':
+			if buf.Len() == 0 && !closed {
+				return nil, fmt.Errorf("firestore: empty component in field path %q", s)
+			}
+			fp = append(fp, buf.String())
+			buf.Reset()
+			closed = false
+		case c == '`' && buf.Len() == 0 && !closed:
+			quoted = true
+		case closed || c == '`':
+			return nil, fmt.Errorf("firestore: bad field path %q", s)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("firestore: unterminated quote in field path %q", s)
+	}
+	if buf.Len() == 0 && !closed {
+		return nil, fmt.Errorf("firestore: empty component in field path %q", s)
+	}
+	return append(fp, buf.String()), nil
+}
+
 func toServiceFieldPaths(fps []FieldPath) []string {
 	var sfps []string
 	for _, fp := range fps {
@@ -210,6 +210,17 @@ func TestToServiceFieldPath(t *testing.T) {
 		if got != test.want {
 			t.Errorf("%v: got %s, want %s", test.in, got, test.want)
 		}
+		back, err := parseServiceFieldPath(got)
+		if err != nil {
+			t.Errorf("parsing %s: %v", got, err)
+		} else if !back.equal(test.in) {
+			t.Errorf("parsing %s: got %q, want %q", got, back, test.in)
+		}
+	}
+	for _, bad := range []string{"", "a.", ".a", "a..b", "`a", "`a`b", "a`b`", "`a\\"} {
+		if _, err := parseServiceFieldPath(bad); err == nil {
+			t.Errorf("parsing %s: got nil, want error", bad)
+		}
 	}
 }
 
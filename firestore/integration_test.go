@@ -1775,6 +1775,90 @@ func TestIntegration_BulkWriter(t *testing.T) {
 	}
 }
 
+func TestIntegration_RecursiveDelete(t *testing.T) {
+	c := integrationClient(t)
+	ctx := context.Background()
+
+	// A document with two levels of subcollections, one of them under a
+	// document that doesn't exist.
+	root := iColl.NewDoc()
+	docs := []*DocumentRef{
+		root,
+		root.Collection("a").NewDoc(),
+		root.Collection("a").NewDoc(),
+		root.Collection("b").Doc("missing").Collection("c").NewDoc(),
+	}
+	for _, d := range docs {
+		if _, err := d.Create(ctx, integrationTestMap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sibling := iColl.NewDoc()
+	if _, err := sibling.Create(ctx, integrationTestMap); err != nil {
+		t.Fatal(err)
+	}
+	defer sibling.Delete(ctx)
+
+	var deleted int
+	if err := c.RecursiveDelete(ctx, root, WithDeleteProgress(func(*DocumentRef) { deleted++ })); err != nil {
+		t.Fatal(err)
+	}
+	if deleted != len(docs) {
+		t.Errorf("deleted %d documents, want %d", deleted, len(docs))
+	}
+	for _, d := range docs {
+		if _, err := d.Get(ctx); status.Code(err) != codes.NotFound {
+			t.Errorf("%s: got %v, want NotFound", d.Path, err)
+		}
+	}
+	if _, err := sibling.Get(ctx); err != nil {
+		t.Errorf("sibling was deleted: %v", err)
+	}
+}
+
+func TestIntegration_RecursiveDeleteCollection(t *testing.T) {
+	c := integrationClient(t)
+	ctx := context.Background()
+
+	// A collection with a nested subcollection, next to collections whose
+	// IDs sort just before and after it.
+	parent := iColl.NewDoc()
+	defer c.RecursiveDelete(ctx, parent)
+	coll := parent.Collection("b")
+	docs := []*DocumentRef{
+		coll.NewDoc(),
+		coll.NewDoc(),
+		coll.Doc("missing").Collection("c").NewDoc(),
+	}
+	siblings := []*DocumentRef{
+		parent.Collection("a").NewDoc(),
+		parent.Collection("b0").NewDoc(),
+	}
+	for _, d := range append(append([]*DocumentRef(nil), docs...), siblings...) {
+		if _, err := d.Create(ctx, integrationTestMap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var deleted int
+	if err := c.RecursiveDelete(ctx, coll, WithDeleteProgress(func(*DocumentRef) { deleted++ })); err != nil {
+		t.Fatal(err)
+	}
+	if deleted != len(docs) {
+		t.Errorf("deleted %d documents, want %d", deleted, len(docs))
+	}
+	for _, d := range docs {
+		if _, err := d.Get(ctx); status.Code(err) != codes.NotFound {
+			t.Errorf("%s: got %v, want NotFound", d.Path, err)
+		}
+	}
+	for _, d := range siblings {
+		if _, err := d.Get(ctx); err != nil {
+			t.Errorf("%s: sibling was deleted: %v", d.Path, err)
+		}
+	}
+}
+
 func TestIntegration_CountAggregationQuery(t *testing.T) {
 	str := uid.NewSpace("firestore-count", &uid.Options{})
 	datum := str.New()
@@ -141,6 +141,96 @@ func (q Query) WherePath(fp FieldPath, op string, value interface{}) Query {
 	return q
 }
 
+// WhereEntity returns a new Query that filters the set of results.
+// A Query can have multiple filters.
+// The filter argument can be a PropertyFilter, a PropertyPathFilter, or an
+// AndFilter or OrFilter that combines other filters, nested to any depth.
+func (q Query) WhereEntity(ef EntityFilter) Query {
+	proto, err := ef.toProto()
+	if err != nil {
+		q.err = err
+		return q
+	}
+	q.filters = append(append([]*pb.StructuredQuery_Filter(nil), q.filters...), proto)
+	return q
+}
+
+// EntityFilter represents a Firestore filter. It is implemented by
+// PropertyFilter, PropertyPathFilter, AndFilter and OrFilter.
+type EntityFilter interface {
+	toProto() (*pb.StructuredQuery_Filter, error)
+}
+
+// PropertyFilter is a filter on a single field.
+type PropertyFilter struct {
+	// Path is a single field or a dot-separated sequence of fields, and must
+	// not contain any of the runes "˜*/[]".
+	Path string
+	// Operator is one of "==", "!=", "<", "<=", ">", ">=",
+	// "array-contains", "array-contains-any", "in" or "not-in".
+	Operator string
+	Value    interface{}
+}
+
+func (f PropertyFilter) toProto() (*pb.StructuredQuery_Filter, error) {
+	fp, err := parseDotSeparatedString(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	return filter{fp, f.Operator, f.Value}.toProto()
+}
+
+// PropertyPathFilter is a filter on a single field, given by its FieldPath.
+type PropertyPathFilter struct {
+	Path FieldPath
+	// Operator is one of "==", "!=", "<", "<=", ">", ">=",
+	// "array-contains", "array-contains-any", "in" or "not-in".
+	Operator string
+	Value    interface{}
+}
+
+func (f PropertyPathFilter) toProto() (*pb.StructuredQuery_Filter, error) {
+	return filter{f.Path, f.Operator, f.Value}.toProto()
+}
+
+// AndFilter matches the documents that match all of its filters.
+type AndFilter struct {
+	Filters []EntityFilter
+}
+
+func (f AndFilter) toProto() (*pb.StructuredQuery_Filter, error) {
+	return compositeFilterProto(pb.StructuredQuery_CompositeFilter_AND, f.Filters)
+}
+
+// OrFilter matches the documents that match at least one of its filters.
+type OrFilter struct {
+	Filters []EntityFilter
+}
+
+func (f OrFilter) toProto() (*pb.StructuredQuery_Filter, error) {
+	return compositeFilterProto(pb.StructuredQuery_CompositeFilter_OR, f.Filters)
+}
+
+func compositeFilterProto(op pb.StructuredQuery_CompositeFilter_Operator, filters []EntityFilter) (*pb.StructuredQuery_Filter, error) {
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("firestore: %s filter must have at least one filter", op)
+	}
+	cf := &pb.StructuredQuery_CompositeFilter{Op: op}
+	for _, f := range filters {
+		if f == nil {
+			return nil, fmt.Errorf("firestore: nil filter in %s filter", op)
+		}
+		pf, err := f.toProto()
+		if err != nil {
+			return nil, err
+		}
+		cf.Filters = append(cf.Filters, pf)
+	}
+	return &pb.StructuredQuery_Filter{
+		FilterType: &pb.StructuredQuery_Filter_CompositeFilter{CompositeFilter: cf},
+	}, nil
+}
+
 // Direction is the sort direction for result ordering.
 type Direction int32
 
@@ -396,7 +486,9 @@ func (q Query) fromProto(pbQuery *pb.RunQueryRequest) (Query, error) {
 
 	// 	filters                []*pb.StructuredQuery_Filter
 	if w := pbq.GetWhere(); w != nil {
-		if cf := w.GetCompositeFilter(); cf != nil {
+		// toProto combines multiple filters with AND, so only an AND filter
+		// is split into them. An OR filter is kept whole.
+		if cf := w.GetCompositeFilter(); cf != nil && cf.GetOp() == pb.StructuredQuery_CompositeFilter_AND {
 			q.filters = cf.GetFilters()
 		} else {
 			q.filters = []*pb.StructuredQuery_Filter{w}
@@ -428,7 +520,8 @@ func (q Query) toProto() (*pb.StructuredQuery, error) {
 	if q.err != nil {
 		return nil, q.err
 	}
-	if q.collectionID == "" {
+	// Only an all-descendants query may be kind-less, with no collection ID.
+	if q.collectionID == "" && !q.allDescendants {
 		return nil, errors.New("firestore: query created without CollectionRef")
 	}
 	if q.startBefore {
@@ -512,18 +605,45 @@ func (q *Query) adjustOrders() []order {
 	}
 	// If there are no OrderBy clauses but there is an inequality, add an OrderBy clause
 	// for the field of the first inequality.
-	var orders []order
-	for _, f := range q.filters {
-		if fieldFilter := f.GetFieldFilter(); fieldFilter != nil {
-			if fieldFilter.Op != pb.StructuredQuery_FieldFilter_EQUAL {
-				fp := f.GetFieldFilter().Field
-				orders = []order{{fieldReference: fp, dir: Asc}}
-				break
+	orders := q.inequalityOrders()
+	// Add an ascending OrderBy(DocumentID).
+	return append(orders, order{fieldPath: FieldPath{DocumentID}, dir: Asc})
+}
+
+// inequalityOrders returns the ordering that the service gives a query
+// without OrderBy clauses: by the field of its first inequality filter,
+// which may be inside a composite filter.
+func (q *Query) inequalityOrders() []order {
+	if ref := firstInequality(q.filters); ref != nil {
+		return []order{{fieldReference: ref, dir: Asc}}
+	}
+	return nil
+}
+
+func firstInequality(filters []*pb.StructuredQuery_Filter) *pb.StructuredQuery_FieldReference {
+	for _, f := range filters {
+		if cf := f.GetCompositeFilter(); cf != nil {
+			if ref := firstInequality(cf.GetFilters()); ref != nil {
+				return ref
 			}
+		} else if ff := f.GetFieldFilter(); ff != nil && isInequality(ff.Op) {
+			return ff.Field
 		}
 	}
-	// Add an ascending OrderBy(DocumentID).
-	return append(orders, order{fieldPath: FieldPath{DocumentID}, dir: Asc})
+	return nil
+}
+
+func isInequality(op pb.StructuredQuery_FieldFilter_Operator) bool {
+	switch op {
+	case pb.StructuredQuery_FieldFilter_LESS_THAN,
+		pb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL,
+		pb.StructuredQuery_FieldFilter_GREATER_THAN,
+		pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL,
+		pb.StructuredQuery_FieldFilter_NOT_EQUAL,
+		pb.StructuredQuery_FieldFilter_NOT_IN:
+		return true
+	}
+	return false
 }
 
 func (q *Query) toCursor(fieldValues []interface{}, ds *DocumentSnapshot, before bool, orders []order) (*pb.Cursor, error) {
@@ -587,18 +707,11 @@ func (q *Query) docSnapshotToCursorValues(ds *DocumentSnapshot, orders []order)
 			}
 			vals[i] = &pb.Value{ValueType: &pb.Value_ReferenceValue{ds.Ref.Path}}
 		} else {
-			var val *pb.Value
-			var err error
-			if len(ord.fieldPath) > 0 {
-				val, err = valueAtPath(ord.fieldPath, ds.proto.Fields)
-			} else {
-				// parse the field reference field path so we can use it to look up
-				fp, err := parseDotSeparatedString(ord.fieldReference.FieldPath)
-				if err != nil {
-					return nil, err
-				}
-				val, err = valueAtPath(fp, ds.proto.Fields)
+			fp, err := ord.path()
+			if err != nil {
+				return nil, err
 			}
+			val, err := valueAtPath(fp, ds.proto.Fields)
 			if err != nil {
 				return nil, err
 			}
@@ -610,23 +723,33 @@ func (q *Query) docSnapshotToCursorValues(ds *DocumentSnapshot, orders []order)
 
 // Returns a function that compares DocumentSnapshots according to q's ordering.
 func (q Query) compareFunc() func(d1, d2 *DocumentSnapshot) (int, error) {
+	// Without OrderBy clauses, the service sorts by the field of the first
+	// inequality filter.
+	orders := q.copyOrders()
+	if len(orders) == 0 {
+		orders = q.inequalityOrders()
+	}
 	// Add implicit sorting by name, using the last specified direction.
 	lastDir := Asc
-	if len(q.orders) > 0 {
-		lastDir = q.orders[len(q.orders)-1].dir
+	if len(orders) > 0 {
+		lastDir = orders[len(orders)-1].dir
 	}
-	orders := append(q.copyOrders(), order{fieldPath: []string{DocumentID}, dir: lastDir})
+	orders = append(orders, order{fieldPath: []string{DocumentID}, dir: lastDir})
 	return func(d1, d2 *DocumentSnapshot) (int, error) {
 		for _, ord := range orders {
 			var cmp int
-			if len(ord.fieldPath) == 1 && ord.fieldPath[0] == DocumentID {
+			if ord.isDocumentID() {
 				cmp = compareReferences(d1.Ref.Path, d2.Ref.Path)
 			} else {
-				v1, err := valueAtPath(ord.fieldPath, d1.proto.Fields)
+				fp, err := ord.path()
+				if err != nil {
+					return 0, err
+				}
+				v1, err := valueAtPath(fp, d1.proto.Fields)
 				if err != nil {
 					return 0, err
 				}
-				v2, err := valueAtPath(ord.fieldPath, d2.proto.Fields)
+				v2, err := valueAtPath(fp, d2.proto.Fields)
 				if err != nil {
 					return 0, err
 				}
@@ -754,6 +877,15 @@ func (r order) isDocumentID() bool {
 	return len(r.fieldPath) == 1 && r.fieldPath[0] == DocumentID
 }
 
+// path returns the path of the field to order by. A query made by
+// Deserialize has only the field's reference.
+func (r order) path() (FieldPath, error) {
+	if r.fieldReference != nil {
+		return parseServiceFieldPath(r.fieldReference.GetFieldPath())
+	}
+	return r.fieldPath, nil
+}
+
 func (r order) toProto() (*pb.StructuredQuery_Order, error) {
 	if r.fieldReference != nil {
 		return &pb.StructuredQuery_Order{
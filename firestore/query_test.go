@@ -191,6 +191,65 @@ func createTestScenarios(t *testing.T) []toProtoScenario {
 				},
 			},
 		},
+		{
+			desc: `q.WhereEntity(OrFilter{...})`,
+			in: q.WhereEntity(OrFilter{Filters: []EntityFilter{
+				PropertyFilter{Path: "a", Operator: "==", Value: 1},
+				AndFilter{Filters: []EntityFilter{
+					PropertyFilter{Path: "b", Operator: "not-in", Value: []int{2, 3}},
+					PropertyPathFilter{Path: []string{"/", "*"}, Operator: "array-contains-any", Value: []int{4}},
+				}},
+			}}),
+			want: &pb.StructuredQuery{
+				Where: &pb.StructuredQuery_Filter{
+					FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+						&pb.StructuredQuery_CompositeFilter{
+							Op: pb.StructuredQuery_CompositeFilter_OR,
+							Filters: []*pb.StructuredQuery_Filter{
+								filtr([]string{"a"}, "==", 1),
+								{FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+									&pb.StructuredQuery_CompositeFilter{
+										Op: pb.StructuredQuery_CompositeFilter_AND,
+										Filters: []*pb.StructuredQuery_Filter{
+											filtr([]string{"b"}, "not-in", []int{2, 3}),
+											filtr([]string{"/", "*"}, "array-contains-any", []int{4}),
+										},
+									},
+								}},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: `q.Where("a", ">", 5).WhereEntity(OrFilter{...})`,
+			in: q.Where("a", ">", 5).WhereEntity(OrFilter{Filters: []EntityFilter{
+				PropertyFilter{Path: "b", Operator: "==", Value: 1},
+				PropertyFilter{Path: "c", Operator: "==", Value: nil},
+			}}),
+			want: &pb.StructuredQuery{
+				Where: &pb.StructuredQuery_Filter{
+					FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+						&pb.StructuredQuery_CompositeFilter{
+							Op: pb.StructuredQuery_CompositeFilter_AND,
+							Filters: []*pb.StructuredQuery_Filter{
+								filtr([]string{"a"}, ">", 5),
+								{FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+									&pb.StructuredQuery_CompositeFilter{
+										Op: pb.StructuredQuery_CompositeFilter_OR,
+										Filters: []*pb.StructuredQuery_Filter{
+											filtr([]string{"b"}, "==", 1),
+											filtr([]string{"c"}, "==", nil),
+										},
+									},
+								}},
+							},
+						},
+					},
+				},
+			},
+		},
 		{
 			desc: `  q.WherePath([]string{"/", "*"}, ">", 5)`,
 			in:   q.WherePath([]string{"/", "*"}, ">", 5),
@@ -512,21 +571,27 @@ func TestQueryToProtoErrors(t *testing.T) {
 		q.WherePath([]string{"*", ""}, ">", 1), // invalid path
 		q.StartAt(1),                           // no OrderBy
 		q.StartAt(2).OrderBy("x", Asc).OrderBy("y", Desc), // wrong # OrderBy
-		q.Select("*"),                         // invalid path
-		q.SelectPaths([]string{"/", "", "~"}), // invalid path
-		q.OrderBy("[", Asc),                   // invalid path
-		q.OrderByPath([]string{""}, Desc),     // invalid path
-		q.Where("x", "==", st),                // ServerTimestamp in filter
-		q.OrderBy("a", Asc).StartAt(st),       // ServerTimestamp in Start
-		q.OrderBy("a", Asc).EndAt(st),         // ServerTimestamp in End
-		q.Where("x", "==", del),               // Delete in filter
-		q.OrderBy("a", Asc).StartAt(del),      // Delete in Start
-		q.OrderBy("a", Asc).EndAt(del),        // Delete in End
-		q.OrderBy(DocumentID, Asc).StartAt(7), // wrong type for __name__
-		q.OrderBy(DocumentID, Asc).EndAt(7),   // wrong type for __name__
-		q.OrderBy("b", Asc).StartAt(docsnap),  // doc snapshot does not have order-by field
-		q.StartAt(docsnap).EndAt("x"),         // mixed doc snapshot and fields
-		q.StartAfter("x").EndBefore(docsnap),  // mixed doc snapshot and fields
+		q.Select("*"),                                          // invalid path
+		q.SelectPaths([]string{"/", "", "~"}),                  // invalid path
+		q.OrderBy("[", Asc),                                    // invalid path
+		q.OrderByPath([]string{""}, Desc),                      // invalid path
+		q.Where("x", "==", st),                                 // ServerTimestamp in filter
+		q.OrderBy("a", Asc).StartAt(st),                        // ServerTimestamp in Start
+		q.OrderBy("a", Asc).EndAt(st),                          // ServerTimestamp in End
+		q.Where("x", "==", del),                                // Delete in filter
+		q.OrderBy("a", Asc).StartAt(del),                       // Delete in Start
+		q.OrderBy("a", Asc).EndAt(del),                         // Delete in End
+		q.OrderBy(DocumentID, Asc).StartAt(7),                  // wrong type for __name__
+		q.OrderBy(DocumentID, Asc).EndAt(7),                    // wrong type for __name__
+		q.OrderBy("b", Asc).StartAt(docsnap),                   // doc snapshot does not have order-by field
+		q.StartAt(docsnap).EndAt("x"),                          // mixed doc snapshot and fields
+		q.StartAfter("x").EndBefore(docsnap),                   // mixed doc snapshot and fields
+		q.WhereEntity(OrFilter{}),                              // no filters
+		q.WhereEntity(AndFilter{Filters: []EntityFilter{nil}}), // nil filter
+		q.WhereEntity(OrFilter{Filters: []EntityFilter{ // invalid operator in a nested filter
+			AndFilter{Filters: []EntityFilter{PropertyFilter{Path: "x", Operator: "<>", Value: 1}}},
+		}}),
+		q.WhereEntity(PropertyFilter{Path: "~", Operator: ">", Value: 1}), // invalid path
 	} {
 		_, err := query.toProto()
 		if err == nil {
@@ -748,6 +813,46 @@ func TestQueryCompareFunc(t *testing.T) {
 				snap(doc1, mv("foo", mapval(mv("bar", intval(1))))),
 			},
 		},
+		{
+			// Without an OrderBy, the service sorts by the first inequality,
+			// even inside a composite filter.
+			q: coll.WhereEntity(OrFilter{Filters: []EntityFilter{
+				PropertyFilter{Path: "bar", Operator: "==", Value: 1},
+				PropertyFilter{Path: "foo", Operator: ">", Value: 0},
+			}}),
+			in: []*DocumentSnapshot{
+				snap(doc1, mv("foo", intval(2))),
+				snap(doc2, mv("foo", intval(1))),
+				snap(doc3, mv("foo", intval(2))),
+			},
+			want: []*DocumentSnapshot{
+				snap(doc2, mv("foo", intval(1))),
+				snap(doc1, mv("foo", intval(2))),
+				snap(doc3, mv("foo", intval(2))),
+			},
+		},
+		{
+			// A deserialized query's orders have only field references.
+			q: func() Query {
+				b, err := coll.OrderByPath([]string{"a.b"}, Desc).Serialize()
+				if err != nil {
+					t.Fatal(err)
+				}
+				q, err := Query{c: c}.Deserialize(b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return q
+			}(),
+			in: []*DocumentSnapshot{
+				snap(doc1, mv("a.b", intval(1))),
+				snap(doc2, mv("a.b", intval(2))),
+			},
+			want: []*DocumentSnapshot{
+				snap(doc2, mv("a.b", intval(2))),
+				snap(doc1, mv("a.b", intval(1))),
+			},
+		},
 	} {
 		got := append([]*DocumentSnapshot(nil), test.in...)
 		sort.Sort(byQuery{test.q.compareFunc(), got})
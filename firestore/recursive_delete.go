@@ -0,0 +1,199 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/iterator"
+)
+
+// A RecursiveDeleteOption is an option for Client.RecursiveDelete.
+type RecursiveDeleteOption interface {
+	apply(*recursiveDeleteSettings)
+}
+
+type recursiveDeleteSettings struct {
+	onDelete func(*DocumentRef)
+	onError  func(*DocumentRef, error)
+}
+
+type recursiveDeleteOption func(*recursiveDeleteSettings)
+
+func (o recursiveDeleteOption) apply(s *recursiveDeleteSettings) { o(s) }
+
+// WithDeleteProgress returns a RecursiveDeleteOption that calls f with each
+// document that RecursiveDelete deletes. The calls are made from a single
+// goroutine, in the order that the deletes were sent.
+func WithDeleteProgress(f func(doc *DocumentRef)) RecursiveDeleteOption {
+	return recursiveDeleteOption(func(s *recursiveDeleteSettings) { s.onDelete = f })
+}
+
+// WithDeleteErrorHandler returns a RecursiveDeleteOption that calls f with
+// each document that RecursiveDelete fails to delete, and the error. The
+// calls are made from the same goroutine as those of the WithDeleteProgress
+// function. RecursiveDelete keeps going after a failed delete, whether or not
+// there is an error handler.
+func WithDeleteErrorHandler(f func(doc *DocumentRef, err error)) RecursiveDeleteOption {
+	return recursiveDeleteOption(func(s *recursiveDeleteSettings) { s.onError = f })
+}
+
+// RecursiveDelete deletes a document or a collection, and all of the
+// documents in their subcollections, to any depth. The ref argument must be
+// a *DocumentRef or a *CollectionRef.
+//
+// The documents are found with a single query over all of the descendants of
+// ref, and deleted with a BulkWriter, so that large subtrees are deleted
+// without holding them in memory. The deletes are not atomic: if
+// RecursiveDelete fails, some of the documents may have been deleted.
+//
+// RecursiveDelete returns an error if the query fails, or if any document
+// could not be deleted. Use WithDeleteErrorHandler to learn which documents
+// those were.
+func (c *Client) RecursiveDelete(ctx context.Context, ref interface{}, opts ...RecursiveDeleteOption) error {
+	var s recursiveDeleteSettings
+	for _, o := range opts {
+		o.apply(&s)
+	}
+	q, doc, err := c.descendantsQuery(ref)
+	if err != nil {
+		return err
+	}
+
+	type pendingDelete struct {
+		doc *DocumentRef
+		job *BulkWriterJob
+		err error // the error from BulkWriter.Delete, if any
+	}
+	var (
+		pending = make(chan pendingDelete, maxBatchSize)
+		done    = make(chan struct{})
+		failed  int
+		lastErr error
+	)
+	// Wait for the results of the deletes as the query runs, so that the
+	// callbacks track progress.
+	go func() {
+		defer close(done)
+		for p := range pending {
+			err := p.err
+			if err == nil {
+				_, err = p.job.Results()
+			}
+			if err != nil {
+				failed++
+				lastErr = err
+				if s.onError != nil {
+					s.onError(p.doc, err)
+				}
+			} else if s.onDelete != nil {
+				s.onDelete(p.doc)
+			}
+		}
+	}()
+
+	bw := c.BulkWriter(ctx)
+	del := func(d *DocumentRef) {
+		j, err := bw.Delete(d)
+		pending <- pendingDelete{doc: d, job: j, err: err}
+	}
+	iter := q.Documents(ctx)
+	for {
+		var ds *DocumentSnapshot
+		ds, err = iter.Next()
+		if err == iterator.Done {
+			err = nil
+			break
+		}
+		if err != nil {
+			break
+		}
+		del(ds.Ref)
+	}
+	iter.Stop()
+	// Delete the document itself last, so that if RecursiveDelete fails, it
+	// can be run again on the same document.
+	if err == nil && doc != nil {
+		del(doc)
+	}
+	bw.End()
+	close(pending)
+	<-done
+
+	if err != nil {
+		return fmt.Errorf("firestore: RecursiveDelete: listing documents: %w", err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("firestore: RecursiveDelete: %d deletes failed; last error: %w", failed, lastErr)
+	}
+	return nil
+}
+
+// descendantsQuery returns a kind-less, all-descendants query for the
+// documents below ref, which must be a *DocumentRef or a *CollectionRef. The
+// query returns only the documents' names. If ref is a document, it is
+// returned as well, since the query doesn't include it.
+func (c *Client) descendantsQuery(ref interface{}) (Query, *DocumentRef, error) {
+	q := Query{
+		c:              c,
+		allDescendants: true,
+	}
+	var doc *DocumentRef
+	switch r := ref.(type) {
+	case *DocumentRef:
+		if r == nil {
+			return Query{}, nil, errNilDocRef
+		}
+		doc = r
+		q.parentPath = r.Path
+		q.path = r.Path
+	case *CollectionRef:
+		if r == nil {
+			return Query{}, nil, errors.New("firestore: nil CollectionRef")
+		}
+		// A kind-less query from a collection's parent returns the documents of
+		// all of its collections. Limit it to those whose names start with the
+		// collection's path. The bounds must be document names: all of the
+		// documents are ordered at or after the document with ID NUL in the
+		// collection, and before that document in the collection whose ID has
+		// a NUL appended.
+		q.parentPath = r.parentPath
+		q.path = r.Path
+		q.filters = []*pb.StructuredQuery_Filter{
+			nameFilter(pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL, r.Path+"/\x00"),
+			nameFilter(pb.StructuredQuery_FieldFilter_LESS_THAN, r.Path+"\x00/\x00"),
+		}
+	default:
+		return Query{}, nil, fmt.Errorf("firestore: RecursiveDelete needs a *DocumentRef or *CollectionRef, not %T", ref)
+	}
+	return q.Select(), doc, nil
+}
+
+// nameFilter returns a filter that compares the names of documents with
+// path.
+func nameFilter(op pb.StructuredQuery_FieldFilter_Operator, path string) *pb.StructuredQuery_Filter {
+	return &pb.StructuredQuery_Filter{
+		FilterType: &pb.StructuredQuery_Filter_FieldFilter{
+			FieldFilter: &pb.StructuredQuery_FieldFilter{
+				Field: &pb.StructuredQuery_FieldReference{FieldPath: DocumentID},
+				Op:    op,
+				Value: &pb.Value{ValueType: &pb.Value_ReferenceValue{ReferenceValue: path}},
+			},
+		},
+	}
+}
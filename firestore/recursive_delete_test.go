@@ -0,0 +1,152 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// deletesResponse returns the response to a BatchWriteRequest of n
+// successful writes.
+func deletesResponse(n int) *pb.BatchWriteResponse {
+	res := &pb.BatchWriteResponse{}
+	for i := 0; i < n; i++ {
+		res.WriteResults = append(res.WriteResults, &pb.WriteResult{UpdateTime: aTimestamp})
+		res.Status = append(res.Status, &status.Status{Code: int32(codes.OK)})
+	}
+	return res
+}
+
+func deletesRequest(c *Client, names ...string) *pb.BatchWriteRequest {
+	req := &pb.BatchWriteRequest{Database: c.path(), Labels: map[string]string{}}
+	for _, n := range names {
+		req.Writes = append(req.Writes, &pb.Write{Operation: &pb.Write_Delete{Delete: n}})
+	}
+	return req
+}
+
+func TestRecursiveDeleteDocument(t *testing.T) {
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	doc := c.Doc("C/d")
+	sub1 := doc.Path + "/S/a"
+	sub2 := doc.Path + "/S/a/T/b"
+	srv.addRPC(
+		&pb.RunQueryRequest{
+			Parent: doc.Path,
+			QueryType: &pb.RunQueryRequest_StructuredQuery{StructuredQuery: &pb.StructuredQuery{
+				Select: &pb.StructuredQuery_Projection{Fields: []*pb.StructuredQuery_FieldReference{fref1(DocumentID)}},
+				From:   []*pb.StructuredQuery_CollectionSelector{{AllDescendants: true}},
+			}},
+		},
+		[]interface{}{
+			&pb.RunQueryResponse{Document: &pb.Document{Name: sub1, CreateTime: aTimestamp, UpdateTime: aTimestamp}, ReadTime: aTimestamp},
+			&pb.RunQueryResponse{Document: &pb.Document{Name: sub2, CreateTime: aTimestamp, UpdateTime: aTimestamp}, ReadTime: aTimestamp},
+		},
+	)
+	srv.addRPC(deletesRequest(c, sub1, sub2, doc.Path), deletesResponse(3))
+
+	var deleted []string
+	err := c.RecursiveDelete(ctx, doc, WithDeleteProgress(func(d *DocumentRef) {
+		deleted = append(deleted, d.Path)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{sub1, sub2, doc.Path}; !cmp.Equal(deleted, want) {
+		t.Errorf("deleted %v, want %v", deleted, want)
+	}
+}
+
+func TestRecursiveDeleteCollection(t *testing.T) {
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	coll := c.Collection("C/d/S")
+	doc1 := coll.Path + "/a"
+	doc2 := coll.Path + "/b"
+	srv.addRPC(
+		&pb.RunQueryRequest{
+			Parent: coll.parentPath,
+			QueryType: &pb.RunQueryRequest_StructuredQuery{StructuredQuery: &pb.StructuredQuery{
+				Select: &pb.StructuredQuery_Projection{Fields: []*pb.StructuredQuery_FieldReference{fref1(DocumentID)}},
+				From:   []*pb.StructuredQuery_CollectionSelector{{AllDescendants: true}},
+				Where: &pb.StructuredQuery_Filter{FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+					CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+						Op: pb.StructuredQuery_CompositeFilter_AND,
+						Filters: []*pb.StructuredQuery_Filter{
+							nameFilter(pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL, coll.Path+"/\x00"),
+							nameFilter(pb.StructuredQuery_FieldFilter_LESS_THAN, coll.Path+"\x00/\x00"),
+						},
+					},
+				}},
+			}},
+		},
+		[]interface{}{
+			&pb.RunQueryResponse{Document: &pb.Document{Name: doc1, CreateTime: aTimestamp, UpdateTime: aTimestamp}, ReadTime: aTimestamp},
+			&pb.RunQueryResponse{Document: &pb.Document{Name: doc2, CreateTime: aTimestamp, UpdateTime: aTimestamp}, ReadTime: aTimestamp},
+		},
+	)
+	// The batch of deletes fails.
+	srv.addRPC(deletesRequest(c, doc1, doc2), grpcstatus.Error(codes.PermissionDenied, "no"))
+
+	var deleted, failed []string
+	err := c.RecursiveDelete(ctx, coll,
+		WithDeleteProgress(func(d *DocumentRef) { deleted = append(deleted, d.Path) }),
+		WithDeleteErrorHandler(func(d *DocumentRef, err error) {
+			if grpcstatus.Code(err) != codes.PermissionDenied {
+				t.Errorf("%s: got error %v, want PermissionDenied", d.Path, err)
+			}
+			failed = append(failed, d.Path)
+		}))
+	if err == nil || !strings.Contains(err.Error(), "2 deletes failed") {
+		t.Errorf("got error %v, want two failed deletes", err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("deleted %v, want none", deleted)
+	}
+	if want := []string{doc1, doc2}; !cmp.Equal(failed, want) {
+		t.Errorf("failed %v, want %v", failed, want)
+	}
+}
+
+func TestRecursiveDeleteErrors(t *testing.T) {
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	for _, ref := range []interface{}{nil, (*DocumentRef)(nil), (*CollectionRef)(nil), "C/d"} {
+		if err := c.RecursiveDelete(ctx, ref); err == nil {
+			t.Errorf("%#v: got nil, want error", ref)
+		}
+	}
+
+	// A failed query fails RecursiveDelete, and the document isn't deleted.
+	srv.addRPC(nil, []interface{}{grpcstatus.Error(codes.Internal, "")})
+	if err := c.RecursiveDelete(ctx, c.Doc("C/d")); err == nil {
+		t.Error("got nil, want error")
+	}
+}
@@ -111,6 +111,13 @@ You may want use a standard log.Logger in your program.
 	stdlg := lg.StandardLogger(logging.Info)
 	stdlg.Println("some info")
 
+With Go 1.21 or later, the Logger.SlogHandler method returns a log/slog
+Handler that writes to the Logger. Each record becomes an entry with a JSON
+payload holding its message and attributes:
+
+	slogger := slog.New(lg.SlogHandler(nil))
+	slogger.Info("an informative message", "key", "value")
+
 # Log Levels
 
 An Entry may have one of a number of severity levels associated with it.
@@ -24,6 +24,7 @@ import (
 	"cloud.google.com/go/logging"
 	vkit "cloud.google.com/go/logging/apiv2"
 	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"go.opencensus.io/stats/view"
 	"go.opencensus.io/trace"
 )
 
@@ -224,3 +225,18 @@ func ExampleContextFunc() {
 	}))
 	_ = lg // TODO: Use lg
 }
+
+func ExampleDiskBuffer() {
+	ctx := context.Background()
+	client, err := logging.NewClient(ctx, "my-project")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	// Keep up to 100MiB of entries on disk while the logging service is
+	// unreachable.
+	if err := view.Register(logging.SpilledEntriesView, logging.DroppedEntriesView); err != nil {
+		// TODO: Handle error.
+	}
+	lg := client.Logger("audit", logging.DiskBuffer("/var/spool/my-app/audit-log", 100<<20))
+	_ = lg // TODO: Use lg
+}
@@ -159,3 +159,23 @@ func ExampleClient_UpdateSink() {
 	}
 	fmt.Println(sink)
 }
+
+func ExampleClient_Tail() {
+	ctx := context.Background()
+	client, err := logadmin.NewClient(ctx, "my-project")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	it := client.Tail(ctx, `severity >= ERROR`)
+	for {
+		entry, err := it.Next()
+		if err != nil {
+			// TODO: Handle error.
+			break
+		}
+		fmt.Println(entry)
+		for _, s := range it.Suppressed() {
+			fmt.Printf("%d entries were omitted (reason %d)\n", s.Count, s.Reason)
+		}
+	}
+}
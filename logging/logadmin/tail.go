@@ -0,0 +1,285 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logadmin
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"cloud.google.com/go/logging"
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// A TailOption is an option for tailing log entries.
+type TailOption interface {
+	setTail(*logpb.TailLogEntriesRequest)
+}
+
+// TailResourceNames sets the resource names from which to tail log entries,
+// overriding the parent of the client. Examples: "projects/my-project-1A",
+// "organizations/my-org".
+func TailResourceNames(rns []string) TailOption { return tailResourceNames(rns) }
+
+type tailResourceNames []string
+
+func (rn tailResourceNames) setTail(r *logpb.TailLogEntriesRequest) {
+	r.ResourceNames = append([]string(nil), rn...)
+}
+
+// BufferWindow sets how long the service buffers log entries before
+// returning them, so that entries that arrive late can be returned in
+// order. It must be between zero and one minute. The service's default is
+// two seconds.
+func BufferWindow(d time.Duration) TailOption { return bufferWindow(d) }
+
+type bufferWindow time.Duration
+
+func (b bufferWindow) setTail(r *logpb.TailLogEntriesRequest) {
+	r.BufferWindow = durationpb.New(time.Duration(b))
+}
+
+// SuppressionReason is the reason that entries were omitted from a tail
+// session.
+type SuppressionReason int
+
+const (
+	// SuppressionReasonUnspecified means the service gave no reason.
+	SuppressionReasonUnspecified = SuppressionReason(logpb.TailLogEntriesResponse_SuppressionInfo_REASON_UNSPECIFIED)
+	// RateLimited means entries were omitted because they matched faster
+	// than the service's rate limit for tail sessions.
+	RateLimited = SuppressionReason(logpb.TailLogEntriesResponse_SuppressionInfo_RATE_LIMIT)
+	// NotConsumed means entries were omitted because the client did not
+	// read them quickly enough.
+	NotConsumed = SuppressionReason(logpb.TailLogEntriesResponse_SuppressionInfo_NOT_CONSUMED)
+)
+
+// SuppressionInfo reports entries that matched the filter of a tail session,
+// but were not returned.
+type SuppressionInfo struct {
+	Reason SuppressionReason
+	// Count is a lower bound on the number of entries omitted for Reason.
+	Count int
+}
+
+// Backoff settings for reopening tail streams. For testing.
+var (
+	tailInitialBackoff = time.Second
+	tailMaxBackoff     = 30 * time.Second
+)
+
+// tailDedupSize is the number of recent entries that a TailIterator
+// remembers, so that it doesn't return them twice after reconnecting.
+const tailDedupSize = 10000
+
+// Tail returns a TailIterator for the log entries that match filter as they
+// are written. The filter is an advanced logs filter, as for the Filter
+// option; an empty filter matches all entries. By default, the entries are
+// those of the parent passed to NewClient. Requires ReadScope or AdminScope.
+//
+// Tail uses the TailLogEntries streaming RPC. If the stream ends or fails
+// with an error that may be temporary, the iterator opens another one, with
+// the filter restricted to entries at or after the timestamp of the latest
+// entry received, so that entries written while no stream was open are
+// returned. Entries that the new stream returns again are skipped.
+func (c *Client) Tail(ctx context.Context, filter string, opts ...TailOption) *TailIterator {
+	req := &logpb.TailLogEntriesRequest{
+		ResourceNames: []string{c.parent},
+		Filter:        filter,
+	}
+	for _, opt := range opts {
+		opt.setTail(req)
+	}
+	return &TailIterator{
+		ctx:  ctx,
+		c:    c,
+		req:  req,
+		seen: map[entryKey]bool{},
+	}
+}
+
+// A TailIterator iterates over log entries as they are written.
+type TailIterator struct {
+	ctx    context.Context
+	c      *Client
+	req    *logpb.TailLogEntriesRequest
+	stream logpb.LoggingServiceV2_TailLogEntriesClient
+	items  []*logpb.LogEntry
+	supp   []SuppressionInfo
+	err    error
+	latest time.Time // the latest timestamp of the entries received
+
+	// Keys of the most recent entries, for de-duplication.
+	seen    map[entryKey]bool
+	seenLog []entryKey // ring buffer of the keys in seen
+	seenPos int
+}
+
+type entryKey struct {
+	logName, insertID string
+	seconds           int64
+	nanos             int32
+}
+
+// Next returns the next log entry. It blocks until one is written, or ctx
+// is done. Next never returns iterator.Done; after ctx is done, it returns
+// an error. Once Next returns an error, all subsequent calls return the same
+// error.
+func (it *TailIterator) Next() (*logging.Entry, error) {
+	for it.err == nil {
+		if len(it.items) > 0 {
+			le := it.items[0]
+			it.items = it.items[1:]
+			if !it.add(le) {
+				continue
+			}
+			e, err := fromLogEntry(le)
+			if err != nil {
+				it.err = err
+				break
+			}
+			return e, nil
+		}
+		it.err = it.recv()
+	}
+	return nil, it.err
+}
+
+// Suppressed returns information about the entries that were omitted since
+// the last call to Suppressed. There is at most one SuppressionInfo for each
+// reason.
+func (it *TailIterator) Suppressed() []SuppressionInfo {
+	s := it.supp
+	it.supp = nil
+	return s
+}
+
+// recv receives the next response from the stream, opening a new stream if
+// necessary.
+func (it *TailIterator) recv() error {
+	bo := gax.Backoff{Initial: tailInitialBackoff, Max: tailMaxBackoff, Multiplier: 2}
+	for {
+		var err error
+		if it.stream == nil {
+			it.stream, err = it.open()
+		}
+		if err == nil {
+			var res *logpb.TailLogEntriesResponse
+			res, err = it.stream.Recv()
+			if err == nil {
+				it.items = res.Entries
+				for _, le := range res.Entries {
+					if ts := le.GetTimestamp(); ts != nil && ts.AsTime().After(it.latest) {
+						it.latest = ts.AsTime()
+					}
+				}
+				it.addSuppressed(res.SuppressionInfo)
+				return nil
+			}
+			it.stream = nil
+		}
+		if ctxErr := it.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != io.EOF && !retryableTail(err) {
+			return err
+		}
+		if err := gax.Sleep(it.ctx, bo.Pause()); err != nil {
+			return err
+		}
+	}
+}
+
+// open opens a stream. If entries have been received, the stream's filter is
+// restricted to entries at or after the latest of them.
+func (it *TailIterator) open() (logpb.LoggingServiceV2_TailLogEntriesClient, error) {
+	stream, err := it.c.lClient.TailLogEntries(it.ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := it.req
+	if !it.latest.IsZero() {
+		req = proto.Clone(it.req).(*logpb.TailLogEntriesRequest)
+		req.Filter = tailResumeFilter(it.req.Filter, it.latest)
+	}
+	if err := stream.Send(req); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// tailResumeFilter returns filter restricted to entries at or after t.
+func tailResumeFilter(filter string, t time.Time) string {
+	f := `timestamp >= "` + t.UTC().Format(time.RFC3339Nano) + `"`
+	if filter == "" {
+		return f
+	}
+	return "(" + filter + ") AND " + f
+}
+
+// retryableTail reports whether a tail stream that failed with err should be
+// reopened.
+func retryableTail(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.Unknown, codes.Aborted,
+		codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+func (it *TailIterator) addSuppressed(infos []*logpb.TailLogEntriesResponse_SuppressionInfo) {
+outer:
+	for _, si := range infos {
+		r := SuppressionReason(si.Reason)
+		for i := range it.supp {
+			if it.supp[i].Reason == r {
+				it.supp[i].Count += int(si.SuppressedCount)
+				continue outer
+			}
+		}
+		it.supp = append(it.supp, SuppressionInfo{Reason: r, Count: int(si.SuppressedCount)})
+	}
+}
+
+// add records le as seen. It reports whether le is new.
+func (it *TailIterator) add(le *logpb.LogEntry) bool {
+	if le.InsertId == "" {
+		return true
+	}
+	k := entryKey{
+		logName:  le.LogName,
+		insertID: le.InsertId,
+		seconds:  le.GetTimestamp().GetSeconds(),
+		nanos:    le.GetTimestamp().GetNanos(),
+	}
+	if it.seen[k] {
+		return false
+	}
+	if len(it.seenLog) < tailDedupSize {
+		it.seenLog = append(it.seenLog, k)
+	} else {
+		delete(it.seen, it.seenLog[it.seenPos])
+		it.seenLog[it.seenPos] = k
+		it.seenPos = (it.seenPos + 1) % tailDedupSize
+	}
+	it.seen[k] = true
+	return true
+}
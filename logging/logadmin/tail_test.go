@@ -0,0 +1,304 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logadmin
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/internal/testutil"
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// tailSession is the behavior of the fake for one TailLogEntries stream: it
+// sends the responses, then ends the stream with err.
+type tailSession struct {
+	responses []*logpb.TailLogEntriesResponse
+	err       error
+}
+
+type fakeTailServer struct {
+	logpb.UnimplementedLoggingServiceV2Server
+
+	mu       sync.Mutex
+	sessions []tailSession
+	reqs     []*logpb.TailLogEntriesRequest
+}
+
+func (s *fakeTailServer) TailLogEntries(stream logpb.LoggingServiceV2_TailLogEntriesServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.reqs = append(s.reqs, req)
+	if len(s.sessions) == 0 {
+		s.mu.Unlock()
+		// Wait for the client to go away.
+		<-stream.Context().Done()
+		return stream.Context().Err()
+	}
+	sess := s.sessions[0]
+	s.sessions = s.sessions[1:]
+	s.mu.Unlock()
+	for _, res := range sess.responses {
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+	}
+	return sess.err
+}
+
+func newTailClient(t *testing.T, fake logpb.LoggingServiceV2Server) *Client {
+	t.Helper()
+	srv, err := testutil.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logpb.RegisterLoggingServiceV2Server(srv.Gsrv, fake)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	c, err := NewClient(context.Background(), "projects/P", option.WithEndpoint(srv.Addr),
+		option.WithoutAuthentication(), option.WithGRPCDialOption(grpc.WithInsecure()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func tailEntry(id string, sec int64) *logpb.LogEntry {
+	return &logpb.LogEntry{
+		LogName:   "projects/P/logs/l",
+		InsertId:  id,
+		Timestamp: &timestamppb.Timestamp{Seconds: sec},
+		Payload:   &logpb.LogEntry_TextPayload{TextPayload: id},
+	}
+}
+
+func TestTail(t *testing.T) {
+	defer func(d time.Duration) { tailInitialBackoff = d }(tailInitialBackoff)
+	tailInitialBackoff = time.Millisecond
+
+	fake := &fakeTailServer{
+		sessions: []tailSession{
+			{
+				responses: []*logpb.TailLogEntriesResponse{
+					{Entries: []*logpb.LogEntry{tailEntry("a", 1), tailEntry("b", 2)}},
+					{SuppressionInfo: []*logpb.TailLogEntriesResponse_SuppressionInfo{
+						{Reason: logpb.TailLogEntriesResponse_SuppressionInfo_RATE_LIMIT, SuppressedCount: 3},
+					}},
+				},
+				err: status.Error(codes.Unavailable, "try again"),
+			},
+			{
+				// The new stream returns "b" again.
+				responses: []*logpb.TailLogEntriesResponse{
+					{
+						Entries: []*logpb.LogEntry{tailEntry("b", 2), tailEntry("c", 3)},
+						SuppressionInfo: []*logpb.TailLogEntriesResponse_SuppressionInfo{
+							{Reason: logpb.TailLogEntriesResponse_SuppressionInfo_RATE_LIMIT, SuppressedCount: 1},
+							{Reason: logpb.TailLogEntriesResponse_SuppressionInfo_NOT_CONSUMED, SuppressedCount: 2},
+						},
+					},
+				},
+				// The session ends normally.
+			},
+			{
+				responses: []*logpb.TailLogEntriesResponse{
+					{Entries: []*logpb.LogEntry{tailEntry("d", 4)}},
+				},
+				err: status.Error(codes.PermissionDenied, "no"),
+			},
+		},
+	}
+	c := newTailClient(t, fake)
+	it := c.Tail(context.Background(), `severity >= ERROR`, BufferWindow(5*time.Second))
+
+	var got []string
+	for {
+		e, err := it.Next()
+		if err != nil {
+			if status.Code(err) != codes.PermissionDenied {
+				t.Fatalf("got %v, want PermissionDenied", err)
+			}
+			break
+		}
+		got = append(got, e.Payload.(string))
+	}
+	if want := []string{"a", "b", "c", "d"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := it.Next(); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("after error, got %v, want PermissionDenied", err)
+	}
+
+	wantSupp := []SuppressionInfo{{RateLimited, 4}, {NotConsumed, 2}}
+	if diff := cmp.Diff(wantSupp, it.Suppressed()); diff != "" {
+		t.Errorf("suppression info mismatch (-want +got):\n%s", diff)
+	}
+	if got := it.Suppressed(); got != nil {
+		t.Errorf("second call: got %v, want nil", got)
+	}
+
+	// Reopened streams start at the latest entry received.
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.reqs) != 3 {
+		t.Fatalf("got %d streams, want 3", len(fake.reqs))
+	}
+	for i, filter := range []string{
+		`severity >= ERROR`,
+		`(severity >= ERROR) AND timestamp >= "1970-01-01T00:00:02Z"`,
+		`(severity >= ERROR) AND timestamp >= "1970-01-01T00:00:03Z"`,
+	} {
+		wantReq := &logpb.TailLogEntriesRequest{
+			ResourceNames: []string{"projects/P"},
+			Filter:        filter,
+			BufferWindow:  durationpb.New(5 * time.Second),
+		}
+		if !proto.Equal(fake.reqs[i], wantReq) {
+			t.Errorf("stream %d: got request %v, want %v", i, fake.reqs[i], wantReq)
+		}
+	}
+}
+
+// logTailServer streams the entries of its log that match the timestamp
+// restriction of the filter, if any, and breaks each stream after sending
+// three entries.
+type logTailServer struct {
+	logpb.UnimplementedLoggingServiceV2Server
+
+	log []*logpb.LogEntry
+
+	mu      sync.Mutex
+	filters []string
+}
+
+var timestampRestriction = regexp.MustCompile(`timestamp >= "([^"]*)"`)
+
+func (s *logTailServer) TailLogEntries(stream logpb.LoggingServiceV2_TailLogEntriesServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.filters = append(s.filters, req.Filter)
+	s.mu.Unlock()
+	var start time.Time
+	if m := timestampRestriction.FindStringSubmatch(req.Filter); m != nil {
+		if start, err = time.Parse(time.RFC3339Nano, m[1]); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	sent := 0
+	for _, e := range s.log {
+		if e.Timestamp.AsTime().Before(start) {
+			continue
+		}
+		if sent == 3 {
+			return status.Error(codes.Unavailable, "stream broken")
+		}
+		if err := stream.Send(&logpb.TailLogEntriesResponse{Entries: []*logpb.LogEntry{e}}); err != nil {
+			return err
+		}
+		sent++
+	}
+	<-stream.Context().Done()
+	return stream.Context().Err()
+}
+
+func TestTailReopenNoGap(t *testing.T) {
+	defer func(d time.Duration) { tailInitialBackoff = d }(tailInitialBackoff)
+	tailInitialBackoff = time.Millisecond
+
+	// Entries b and c share a timestamp, so reopened streams repeat both.
+	fake := &logTailServer{log: []*logpb.LogEntry{
+		tailEntry("a", 1), tailEntry("b", 2), tailEntry("c", 2),
+		tailEntry("d", 3), tailEntry("e", 4), tailEntry("f", 5),
+	}}
+	c := newTailClient(t, fake)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	it := c.Tail(ctx, "")
+	var got []string
+	for len(got) < len(fake.log) {
+		e, err := it.Next()
+		if err != nil {
+			t.Fatalf("after %v: %v", got, err)
+		}
+		got = append(got, e.Payload.(string))
+	}
+	if want := []string{"a", "b", "c", "d", "e", "f"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	wantFilters := []string{
+		``,
+		`timestamp >= "1970-01-01T00:00:02Z"`,
+		`timestamp >= "1970-01-01T00:00:03Z"`,
+	}
+	if !cmp.Equal(fake.filters, wantFilters) {
+		t.Errorf("got filters %q, want %q", fake.filters, wantFilters)
+	}
+}
+
+func TestTailCancel(t *testing.T) {
+	fake := &fakeTailServer{}
+	c := newTailClient(t, fake)
+	ctx, cancel := context.WithCancel(context.Background())
+	it := c.Tail(ctx, "", TailResourceNames([]string{"projects/A", "folders/B"}))
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err := it.Next(); err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if got, want := fake.reqs[0].ResourceNames, []string{"projects/A", "folders/B"}; !cmp.Equal(got, want) {
+		t.Errorf("got resource names %v, want %v", got, want)
+	}
+}
+
+func TestTailDedupBound(t *testing.T) {
+	it := &TailIterator{seen: map[entryKey]bool{}}
+	for i := int64(0); i < tailDedupSize+10; i++ {
+		if !it.add(tailEntry("x", i)) {
+			t.Fatalf("entry %d is not new", i)
+		}
+	}
+	if len(it.seen) != tailDedupSize {
+		t.Errorf("remembering %d entries, want %d", len(it.seen), tailDedupSize)
+	}
+	// The oldest entries have been forgotten; the newest are remembered.
+	if !it.add(tailEntry("x", 0)) {
+		t.Error("oldest entry is remembered")
+	}
+	if it.add(tailEntry("x", tailDedupSize+9)) {
+		t.Error("newest entry is forgotten")
+	}
+}
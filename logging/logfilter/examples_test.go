@@ -0,0 +1,41 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logfilter_test
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/logfilter"
+)
+
+func ExampleAnd() {
+	f := logfilter.And(
+		logfilter.SeverityAtLeast(logging.Error),
+		logfilter.ResourceType.Eq("gce_instance"),
+		logfilter.JSONPayload("user", "id").Has("alice"),
+	)
+	fmt.Println(f)
+	// Output: severity >= ERROR AND resource.type = "gce_instance" AND jsonPayload.user.id : "alice"
+}
+
+func ExampleParse() {
+	f, err := logfilter.Parse(`resource.type="k8s_container" severity>=WARNING`)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	fmt.Println(logfilter.And(f, logfilter.Label("env").Eq("prod")))
+	// Output: resource.type = "k8s_container" AND severity >= WARNING AND labels.env = "prod"
+}
@@ -0,0 +1,313 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logfilter builds and parses filters in the Logging query
+// language, for use with logadmin.Filter, and the filters of sinks and
+// metrics. See https://cloud.google.com/logging/docs/view/logging-query-language
+// for the language.
+//
+// Filters are built from comparisons of the fields of log entries, combined
+// with And, Or and Not:
+//
+//	f := logfilter.And(
+//		logfilter.SeverityAtLeast(logging.Error),
+//		logfilter.ResourceType.Eq("gce_instance"),
+//		logfilter.JSONPayload("user", "id").Has("alice"),
+//	)
+//	it := client.Entries(ctx, logadmin.Filter(f.String()))
+//
+// Values are quoted and escaped as needed, so the filter matches what the
+// Go values say. Parse turns a filter string back into an Expr, which can be
+// combined with others.
+//
+// This package is EXPERIMENTAL and subject to change or removal without notice.
+package logfilter // import "cloud.google.com/go/logging/logfilter"
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// An Expr is an expression of the Logging query language. Its String method
+// returns the expression as a filter.
+type Expr interface {
+	String() string
+	isExpr()
+}
+
+// A Path names a field of a log entry, such as resource.type, by the names
+// of its components.
+type Path []string
+
+// Paths of commonly used fields.
+var (
+	Severity     = Path{"severity"}
+	Timestamp    = Path{"timestamp"}
+	LogName      = Path{"logName"}
+	ResourceType = Path{"resource", "type"}
+	InsertID     = Path{"insertId"}
+	Trace        = Path{"trace"}
+	TextPayload  = Path{"textPayload"}
+)
+
+// Field returns the path with the given components.
+func Field(names ...string) Path { return Path(names) }
+
+// Label returns the path of the entry label key.
+func Label(key string) Path { return Path{"labels", key} }
+
+// ResourceLabel returns the path of the monitored resource label key.
+func ResourceLabel(key string) Path { return Path{"resource", "labels", key} }
+
+// JSONPayload returns the path of a field of the JSON payload.
+func JSONPayload(names ...string) Path { return append(Path{"jsonPayload"}, names...) }
+
+// String returns p as it appears in a filter. Components that aren't
+// identifiers are quoted.
+func (p Path) String() string {
+	cs := make([]string, len(p))
+	for i, c := range p {
+		if identRegexp.MatchString(c) {
+			cs[i] = c
+		} else {
+			cs[i] = quote(c)
+		}
+	}
+	return strings.Join(cs, ".")
+}
+
+var identRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Eq returns the comparison p = v.
+//
+// In this and the other comparisons, strings and times are quoted, and
+// times are in RFC 3339 format. Severities are written as their names, like
+// ERROR. Numbers and booleans are written as they are in Go. Other values are
+// quoted in their fmt.Sprint form.
+func (p Path) Eq(v interface{}) Expr { return p.compare("=", v) }
+
+// Ne returns the comparison p != v.
+func (p Path) Ne(v interface{}) Expr { return p.compare("!=", v) }
+
+// Lt returns the comparison p < v.
+func (p Path) Lt(v interface{}) Expr { return p.compare("<", v) }
+
+// Le returns the comparison p <= v.
+func (p Path) Le(v interface{}) Expr { return p.compare("<=", v) }
+
+// Gt returns the comparison p > v.
+func (p Path) Gt(v interface{}) Expr { return p.compare(">", v) }
+
+// Ge returns the comparison p >= v.
+func (p Path) Ge(v interface{}) Expr { return p.compare(">=", v) }
+
+// Has returns the comparison p : v, which matches if the field contains v
+// as a substring, ignoring case, or, for a structured field, has v as one of
+// its values.
+func (p Path) Has(v interface{}) Expr { return p.compare(":", v) }
+
+// Exists returns the comparison p:*, which matches if the field is present.
+func (p Path) Exists() Expr {
+	return &comparison{path: p, op: ":", value: literal{s: "*"}}
+}
+
+// Matches returns the comparison p =~ re, which matches if the field
+// matches the RE2 regular expression re.
+func (p Path) Matches(re string) Expr { return p.compare("=~", re) }
+
+// NotMatches returns the comparison p !~ re.
+func (p Path) NotMatches(re string) Expr { return p.compare("!~", re) }
+
+func (p Path) compare(op string, v interface{}) Expr {
+	return &comparison{path: p, op: op, value: toLiteral(v)}
+}
+
+// SeverityAtLeast returns the comparison severity >= s.
+func SeverityAtLeast(s logging.Severity) Expr { return Severity.Ge(s) }
+
+// TimeRange returns the expression for entries whose timestamps are at or
+// after start and before end. A zero start or end leaves the range open on
+// that side. If both are zero, TimeRange returns an empty And.
+func TimeRange(start, end time.Time) Expr {
+	var es []Expr
+	if !start.IsZero() {
+		es = append(es, Timestamp.Ge(start))
+	}
+	if !end.IsZero() {
+		es = append(es, Timestamp.Lt(end))
+	}
+	return And(es...)
+}
+
+// Text returns a global restriction, which matches entries that have any
+// field containing s.
+func Text(s string) Expr { return &text{value: literal{s: s, quoted: true}} }
+
+// And returns an expression that matches entries matched by all of es. An
+// And of no expressions matches all entries, and its String is empty. An And
+// of a single expression is that expression.
+func And(es ...Expr) Expr { return newJunction("AND", es) }
+
+// Or returns an expression that matches entries matched by any of es. An Or
+// of no expressions matches no entries, and its String is a filter that no
+// entry matches. An Or of a single expression is that expression.
+func Or(es ...Expr) Expr { return newJunction("OR", es) }
+
+// matchNone is the String of an empty Or. Every entry has a log name.
+const matchNone = "NOT logName : *"
+
+func newJunction(op string, es []Expr) Expr {
+	var args []Expr
+	for _, e := range es {
+		j, ok := e.(*junction)
+		switch {
+		case ok && j.op == op:
+			// Flatten nested junctions of the same kind. An empty And
+			// in an And, or an empty Or in an Or, adds nothing.
+			args = append(args, j.args...)
+		case ok && len(j.args) == 0:
+			// An And that contains an empty Or matches no entries, and an
+			// Or that contains an empty And matches all of them.
+			return j
+		default:
+			args = append(args, e)
+		}
+	}
+	if len(args) == 1 {
+		return args[0]
+	}
+	return &junction{op: op, args: args}
+}
+
+// Not returns the negation of e. The negation of an empty And is an empty
+// Or, and vice versa.
+func Not(e Expr) Expr {
+	if j, ok := e.(*junction); ok && len(j.args) == 0 {
+		if j.op == "AND" {
+			return Or()
+		}
+		return And()
+	}
+	return &not{e}
+}
+
+type comparison struct {
+	path  Path
+	op    string
+	value literal
+}
+
+func (c *comparison) String() string {
+	return c.path.String() + " " + c.op + " " + c.value.String()
+}
+
+func (*comparison) isExpr() {}
+
+type text struct {
+	value literal
+}
+
+func (t *text) String() string { return t.value.String() }
+func (*text) isExpr()          {}
+
+type junction struct {
+	op   string // "AND" or "OR"
+	args []Expr
+}
+
+func (j *junction) String() string {
+	if len(j.args) == 0 && j.op == "OR" {
+		return matchNone
+	}
+	ss := make([]string, len(j.args))
+	for i, a := range j.args {
+		ss[i] = parenthesize(a)
+	}
+	return strings.Join(ss, " "+j.op+" ")
+}
+
+func (*junction) isExpr() {}
+
+type not struct {
+	e Expr
+}
+
+func (n *not) String() string { return "NOT " + parenthesize(n.e) }
+func (*not) isExpr()          {}
+
+// parenthesize returns the String of e, in parentheses if it is a junction.
+// OR binds more tightly than AND in the Logging query language, so the
+// parentheses are needed for an AND inside an OR, and make the others
+// clearer.
+func parenthesize(e Expr) string {
+	if _, ok := e.(*junction); ok {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+// A literal is a value in a filter.
+type literal struct {
+	s      string
+	quoted bool
+}
+
+func (l literal) String() string {
+	if l.quoted {
+		return quote(l.s)
+	}
+	return l.s
+}
+
+// toLiteral converts a Go value to a literal, as described at Path.Eq.
+func toLiteral(v interface{}) literal {
+	switch v := v.(type) {
+	case string:
+		return literal{s: v, quoted: true}
+	case logging.Severity:
+		return literal{s: strings.ToUpper(v.String())}
+	case time.Time:
+		return literal{s: v.Format(time.RFC3339Nano), quoted: true}
+	case bool:
+		return literal{s: strconv.FormatBool(v)}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return literal{s: fmt.Sprint(v)}
+	case float32:
+		return literal{s: strconv.FormatFloat(float64(v), 'g', -1, 32)}
+	case float64:
+		return literal{s: strconv.FormatFloat(v, 'g', -1, 64)}
+	default:
+		return literal{s: fmt.Sprint(v), quoted: true}
+	}
+}
+
+// quote returns s as a string literal, escaping backslashes and double
+// quotes.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
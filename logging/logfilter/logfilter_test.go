@@ -0,0 +1,153 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logfilter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestString(t *testing.T) {
+	ts := time.Date(2023, 5, 6, 7, 8, 9, 0, time.UTC)
+	for _, test := range []struct {
+		e    Expr
+		want string
+	}{
+		{SeverityAtLeast(logging.Error), `severity >= ERROR`},
+		{ResourceType.Eq("gce_instance"), `resource.type = "gce_instance"`},
+		{ResourceLabel("zone").Ne(`us"east`), `resource.labels.zone != "us\"east"`},
+		{Label("k8s-pod/app").Eq("web"), `labels."k8s-pod/app" = "web"`},
+		{JSONPayload("user", "id").Has("alice"), `jsonPayload.user.id : "alice"`},
+		{JSONPayload("latency").Gt(1.5), `jsonPayload.latency > 1.5`},
+		{JSONPayload("count").Le(int64(3)), `jsonPayload.count <= 3`},
+		{JSONPayload("ok").Eq(true), `jsonPayload.ok = true`},
+		{Field("httpRequest", "status").Lt(500), `httpRequest.status < 500`},
+		{TextPayload.Matches(`^GET \d+`), `textPayload =~ "^GET \\d+"`},
+		{TextPayload.NotMatches("x"), `textPayload !~ "x"`},
+		{Trace.Exists(), `trace : *`},
+		{Text("unicorn"), `"unicorn"`},
+		{TimeRange(ts, ts.Add(time.Hour)),
+			`timestamp >= "2023-05-06T07:08:09Z" AND timestamp < "2023-05-06T08:08:09Z"`},
+		{TimeRange(ts, time.Time{}), `timestamp >= "2023-05-06T07:08:09Z"`},
+		{TimeRange(time.Time{}, time.Time{}), ``},
+		{And(), ``},
+		{And(Text("a")), `"a"`},
+		{And(Text("a"), And(Text("b"), Text("c"))), `"a" AND "b" AND "c"`},
+		{And(Text("a"), Or(Text("b"), Text("c"))), `"a" AND ("b" OR "c")`},
+		{Or(And(Text("a"), Text("b")), Text("c")), `("a" AND "b") OR "c"`},
+		{Or(Text("a"), And()), ``},
+		{Or(), `NOT logName : *`},
+		{Or(Text("a"), Or()), `"a"`},
+		{And(Text("a"), Or()), `NOT logName : *`},
+		{Not(And()), `NOT logName : *`},
+		{Not(Or()), ``},
+		{And(Text("a"), Not(Or())), `"a"`},
+		{Or(Text("a"), Not(And())), `"a"`},
+		{Not(Severity.Eq(logging.Debug)), `NOT severity = DEBUG`},
+		{Not(Or(Text("a"), Text("b"))), `NOT ("a" OR "b")`},
+	} {
+		if got := test.e.String(); got != test.want {
+			t.Errorf("got  %s\nwant %s", got, test.want)
+		}
+	}
+}
+
+// Filters and their canonical forms.
+var parseTests = []struct {
+	in, want string
+}{
+	{``, ``},
+	{`severity>=ERROR`, `severity >= ERROR`},
+	{`resource.type="gce_instance" AND severity>=WARNING`, `resource.type = "gce_instance" AND severity >= WARNING`},
+	{`resource.type = "k8s_container"  labels."k8s-pod/app" = "web"`, `resource.type = "k8s_container" AND labels."k8s-pod/app" = "web"`},
+	{`a=1 OR b=2 AND c=3`, `(a = 1 OR b = 2) AND c = 3`},
+	{`a=1 AND (b=2 OR c=3)`, `a = 1 AND (b = 2 OR c = 3)`},
+	{`NOT a=1 OR -b:*`, `NOT a = 1 OR NOT b : *`},
+	{`NOT (a=1 b=2)`, `NOT (a = 1 AND b = 2)`},
+	{`textPayload=~"^x\\d" textPayload!~"y"`, `textPayload =~ "^x\\d" AND textPayload !~ "y"`},
+	{`unicorn "horn \"x\""`, `unicorn AND "horn \"x\""`},
+	{`severity = (ERROR OR CRITICAL)`, `severity = ERROR OR severity = CRITICAL`},
+	{`labels.x:(a AND b)`, `labels.x : a AND labels.x : b`},
+	{`timestamp >= "2023-01-01T00:00:00Z" timestamp < "2023-01-02T00:00:00Z"`,
+		`timestamp >= "2023-01-01T00:00:00Z" AND timestamp < "2023-01-02T00:00:00Z"`},
+	{`jsonPayload.latency > -1.5`, `jsonPayload.latency > -1.5`},
+	{`ANDROID`, `ANDROID`},
+	{Or().String(), `NOT logName : *`},
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range parseTests {
+		e, err := Parse(test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.in, err)
+			continue
+		}
+		got := e.String()
+		if got != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.in, got, test.want)
+		}
+		// The canonical form parses to the same expression.
+		e2, err := Parse(got)
+		if err != nil {
+			t.Errorf("%s: %v", got, err)
+			continue
+		}
+		if !cmp.Equal(e, e2, cmp.AllowUnexported(comparison{}, text{}, junction{}, not{}, literal{})) {
+			t.Errorf("%s: round trip: got %#v, want %#v", test.in, e2, e)
+		}
+	}
+}
+
+func TestParseBuilt(t *testing.T) {
+	e := And(
+		SeverityAtLeast(logging.Warning),
+		Or(ResourceType.Eq("gce_instance"), ResourceType.Eq("k8s_node")),
+		Not(JSONPayload("a b", `c"d`).Has(`e\f`)),
+		TimeRange(time.Unix(1e9, 5).UTC(), time.Time{}),
+	)
+	got, err := Parse(e.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != e.String() {
+		t.Errorf("got  %s\nwant %s", got, e)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{
+		`a =`,
+		`a = "b`,
+		`(a = 1`,
+		`a = 1)`,
+		`AND a`,
+		`a AND`,
+		`a OR OR b`,
+		`NOT`,
+		`sample(insertId, 0.1)`,
+		`severity = (ERROR OR WARNING AND INFO)`,
+		`severity = (ERROR`,
+	} {
+		_, err := Parse(in)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("%s: got %v, want a ParseError", in, err)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logfilter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Parse parses a filter in the Logging query language.
+//
+// Parse understands comparisons, global restrictions, AND, OR, NOT and
+// parentheses, with the language's precedence: NOT binds most tightly, then
+// OR, then AND. Terms that are separated only by whitespace are ANDed. The
+// right side of a comparison may be a parenthesized list of values joined by
+// OR or AND, as in "severity = (ERROR OR CRITICAL)"; it is parsed as the
+// comparisons joined by OR or AND. Functions, such as sample and ip_in_net,
+// and comments are not supported.
+//
+// An empty filter parses as an empty And.
+func Parse(s string) (Expr, error) {
+	p := &parser{s: s}
+	p.skipSpace()
+	if p.done() {
+		return And(), nil
+	}
+	e, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.errorf("unexpected %q", p.rest())
+	}
+	return e, nil
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+// ParseError is the error returned by Parse for a malformed or unsupported
+// filter.
+type ParseError struct {
+	Filter string // the filter
+	Offset int    // the byte offset in Filter of the error
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("logfilter: parsing %q at offset %d: %s", e.Filter, e.Offset, e.Msg)
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &ParseError{Filter: p.s, Offset: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) done() bool   { return p.pos >= len(p.s) }
+func (p *parser) rest() string { return p.s[p.pos:] }
+
+func (p *parser) skipSpace() {
+	for !p.done() && isSpace(p.s[p.pos]) {
+		p.pos++
+	}
+}
+
+func isSpace(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' }
+
+// keyword reports whether the keyword kw is next, followed by a delimiter,
+// and consumes it if so.
+func (p *parser) keyword(kw string) bool {
+	if !strings.HasPrefix(p.rest(), kw) {
+		return false
+	}
+	end := p.pos + len(kw)
+	if end < len(p.s) && !isSpace(p.s[end]) && p.s[end] != '(' {
+		return false
+	}
+	p.pos = end
+	p.skipSpace()
+	return true
+}
+
+// atEnd reports whether the current expression ends here.
+func (p *parser) atEnd() bool {
+	return p.done() || p.s[p.pos] == ')'
+}
+
+// parseAnd parses terms joined by AND or juxtaposition.
+func (p *parser) parseAnd() (Expr, error) {
+	var es []Expr
+	for {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+		if p.atEnd() {
+			return And(es...), nil
+		}
+		p.keyword("AND")
+	}
+}
+
+// parseOr parses terms joined by OR.
+func (p *parser) parseOr() (Expr, error) {
+	var es []Expr
+	for {
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+		if !p.keyword("OR") {
+			return Or(es...), nil
+		}
+	}
+}
+
+// parseUnary parses a possibly negated primary expression.
+func (p *parser) parseUnary() (Expr, error) {
+	if p.done() {
+		return nil, p.errorf("unexpected end of filter")
+	}
+	if p.keyword("NOT") {
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(e), nil
+	}
+	if p.s[p.pos] == '-' {
+		p.pos++
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(e), nil
+	}
+	if p.s[p.pos] == '(' {
+		p.pos++
+		p.skipSpace()
+		e, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if p.done() || p.s[p.pos] != ')' {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+		p.skipSpace()
+		return e, nil
+	}
+	for _, kw := range []string{"AND", "OR"} {
+		start := p.pos
+		if p.keyword(kw) {
+			p.pos = start
+			return nil, p.errorf("unexpected %s", kw)
+		}
+	}
+	return p.parseTerm()
+}
+
+// parseTerm parses a comparison or a global restriction.
+func (p *parser) parseTerm() (Expr, error) {
+	start := p.pos
+	path, err := p.parsePath()
+	if err == nil {
+		p.skipSpace()
+		if op := p.parseOp(); op != "" {
+			p.skipSpace()
+			return p.parseComparisonValue(path, op)
+		}
+	}
+	// Not a comparison: a global restriction.
+	p.pos = start
+	v, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() && p.s[p.pos] == '(' {
+		return nil, p.errorf("functions are not supported")
+	}
+	p.skipSpace()
+	return &text{value: v}, nil
+}
+
+// parsePath parses a field path: components separated by dots, each an
+// unquoted name or a string.
+func (p *parser) parsePath() (Path, error) {
+	var path Path
+	for {
+		if p.done() {
+			return nil, p.errorf("unexpected end of filter")
+		}
+		if p.s[p.pos] == '"' {
+			c, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, c)
+		} else {
+			start := p.pos
+			for !p.done() && isNameByte(p.s[p.pos]) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, p.errorf("expected a field name")
+			}
+			path = append(path, p.s[start:p.pos])
+		}
+		if p.done() || p.s[p.pos] != '.' {
+			return path, nil
+		}
+		p.pos++
+	}
+}
+
+// isNameByte reports whether c can be part of an unquoted path component.
+func isNameByte(c byte) bool {
+	return !isSpace(c) && !strings.ContainsRune(`.()"=!<>:~`, rune(c))
+}
+
+var ops = []string{"=~", "!~", "!=", "<=", ">=", "=", "<", ">", ":"}
+
+func (p *parser) parseOp() string {
+	for _, op := range ops {
+		if strings.HasPrefix(p.rest(), op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+// parseComparisonValue parses the value of a comparison of path with op,
+// which may be a parenthesized list of values.
+func (p *parser) parseComparisonValue(path Path, op string) (Expr, error) {
+	if p.done() || p.s[p.pos] != '(' {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		return &comparison{path: path, op: op, value: v}, nil
+	}
+	p.pos++
+	p.skipSpace()
+	var (
+		es   []Expr
+		join string
+	)
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, &comparison{path: path, op: op, value: v})
+		p.skipSpace()
+		if !p.done() && p.s[p.pos] == ')' {
+			p.pos++
+			p.skipSpace()
+			break
+		}
+		kw := ""
+		for _, k := range []string{"OR", "AND"} {
+			if p.keyword(k) {
+				kw = k
+				break
+			}
+		}
+		if kw == "" || join != "" && kw != join {
+			return nil, p.errorf("values in parentheses must be joined by a single OR or AND")
+		}
+		join = kw
+	}
+	if join == "AND" {
+		return And(es...), nil
+	}
+	return Or(es...), nil
+}
+
+// parseValue parses a string or an unquoted value.
+func (p *parser) parseValue() (literal, error) {
+	if p.done() {
+		return literal{}, p.errorf("expected a value")
+	}
+	if p.s[p.pos] == '"' {
+		s, err := p.parseString()
+		if err != nil {
+			return literal{}, err
+		}
+		return literal{s: s, quoted: true}, nil
+	}
+	start := p.pos
+	for !p.done() && !isSpace(p.s[p.pos]) && !strings.ContainsRune(`()"`, rune(p.s[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		return literal{}, p.errorf("expected a value")
+	}
+	return literal{s: p.s[start:p.pos]}, nil
+}
+
+// parseString parses a double-quoted string. A backslash escapes the byte
+// after it.
+func (p *parser) parseString() (string, error) {
+	start := p.pos
+	p.pos++ // the opening quote
+	var b strings.Builder
+	for !p.done() {
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.done() {
+				break
+			}
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
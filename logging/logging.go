@@ -254,6 +254,11 @@ type Logger struct {
 	populateSourceLocation int
 	partialSuccess         bool
 	redirectOutputWriter   io.Writer
+
+	// Disk buffer, from the DiskBuffer option.
+	diskBuffer *diskBuffer
+	journal    *journal        // nil if there is no disk buffer
+	statsCtx   context.Context // for recording disk buffer measures
 }
 
 // Logger returns a Logger that will write entries with the given log ID, such as
@@ -285,6 +290,7 @@ func (c *Client) Logger(logID string, opts ...LoggerOption) *Logger {
 	for _, opt := range opts {
 		opt.set(l)
 	}
+	l.startSpill()
 	l.stdLoggers = map[Severity]*log.Logger{}
 	for s := range severityName {
 		l.stdLoggers[s] = log.New(severityWriter{l, s}, "", 0)
@@ -297,6 +303,11 @@ func (c *Client) Logger(logID string, opts ...LoggerOption) *Logger {
 		defer c.loggers.Done()
 		<-c.donec
 		l.bundler.Flush()
+		if l.journal != nil {
+			if err := l.journal.close(); err != nil {
+				c.error(err)
+			}
+		}
 	}()
 	return l
 }
@@ -674,6 +685,10 @@ func (l *Logger) Log(e Entry) {
 	}
 	for _, ent = range entries {
 		if err := l.bundler.Add(ent, proto.Size(ent)); err != nil {
+			if err == ErrOverflow && l.journal != nil {
+				l.spill([]*logpb.LogEntry{ent})
+				continue
+			}
 			l.client.error(err)
 		}
 	}
@@ -691,6 +706,23 @@ func (l *Logger) Flush() error {
 }
 
 func (l *Logger) writeLogEntries(entries []*logpb.LogEntry) {
+	// Keep entries in order behind those in the disk buffer.
+	if l.journal != nil && l.journal.pending() {
+		l.spill(entries)
+		return
+	}
+	err := l.send(entries)
+	if err != nil {
+		if l.journal != nil && spillable(err) {
+			l.spill(entries)
+			return
+		}
+		l.client.error(err)
+	}
+}
+
+// send writes entries to the logging service.
+func (l *Logger) send(entries []*logpb.LogEntry) error {
 	partialSuccess := l.partialSuccess
 	if len(entries) > 1 {
 		partialSuccess = partialSuccess || hasInstrumentation(entries)
@@ -706,12 +738,10 @@ func (l *Logger) writeLogEntries(entries []*logpb.LogEntry) {
 	ctx, cancel := context.WithTimeout(ctx, defaultWriteTimeout)
 	defer cancel()
 	_, err := l.client.client.WriteLogEntries(ctx, req)
-	if err != nil {
-		l.client.error(err)
-	}
 	if afterCall != nil {
 		afterCall()
 	}
+	return err
 }
 
 // StandardLogger returns a *log.Logger for the provided severity.
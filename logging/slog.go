@@ -0,0 +1,289 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.21
+
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"go.opencensus.io/trace"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// SlogHandlerOptions are options for a SlogHandler. A zero value is valid.
+type SlogHandlerOptions struct {
+	// Level is the minimum level of the records that are logged. If nil,
+	// records of slog.LevelInfo and above are logged.
+	Level slog.Leveler
+
+	// AddSource causes the handler to set the SourceLocation of each entry
+	// from the caller of the slog method. The source location is also set
+	// if the Logger was created with the SourceLocationPopulation option.
+	AddSource bool
+}
+
+// A SlogHandler is a slog.Handler that writes records to a Logger.
+//
+// Each record is written as an Entry with a JSON payload. The record's
+// message is in the "message" field of the payload, and its attributes are
+// the other fields, with groups as nested objects. The severity of the entry
+// is derived from the record's level: see SlogSeverity.
+//
+// If the context passed to the slog method holds an OpenCensus span, the
+// entry's Trace, SpanID and TraceSampled fields are set from it. Otherwise,
+// an attribute whose value is an *http.Request or an *HTTPRequest sets the
+// entry's HTTPRequest, and the trace is read from the request's headers, as
+// it is for Logger.Log.
+//
+// Records are written with Logger.Log, so they are buffered, unless the
+// Logger was created with RedirectAsJSON, in which case they are written to
+// its writer immediately. Errors are reported to Client.OnError.
+type SlogHandler struct {
+	l     *Logger
+	opts  SlogHandlerOptions
+	goas  []groupOrAttrs // from WithGroup and WithAttrs, in order
+	level slog.Leveler
+}
+
+// groupOrAttrs holds either a group name or a list of attributes.
+type groupOrAttrs struct {
+	group string
+	attrs []slog.Attr
+}
+
+// SlogHandler returns a slog.Handler that writes to l. If opts is nil, the
+// default options are used.
+func (l *Logger) SlogHandler(opts *SlogHandlerOptions) *SlogHandler {
+	h := &SlogHandler{l: l}
+	if opts != nil {
+		h.opts = *opts
+	}
+	h.level = h.opts.Level
+	if h.level == nil {
+		h.level = slog.LevelInfo
+	}
+	return h
+}
+
+// SlogSeverity returns the Severity that a SlogHandler uses for level. The
+// slog levels Debug, Info, Warn and Error map to the severities of the same
+// names. Levels between Info and Warn at or above Info+2 map to Notice, and
+// levels above Error map to Critical, Alert and Emergency in steps of four.
+func SlogSeverity(level slog.Level) Severity {
+	switch {
+	case level < slog.LevelInfo:
+		return Debug
+	case level < slog.LevelInfo+2:
+		return Info
+	case level < slog.LevelWarn:
+		return Notice
+	case level < slog.LevelError:
+		return Warning
+	case level < slog.LevelError+4:
+		return Error
+	case level < slog.LevelError+8:
+		return Critical
+	case level < slog.LevelError+12:
+		return Alert
+	default:
+		return Emergency
+	}
+}
+
+// Enabled reports whether records at level are logged.
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// WithAttrs returns a handler that adds attrs to each record.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.with(groupOrAttrs{attrs: attrs})
+}
+
+// WithGroup returns a handler that puts the attributes of each record, and
+// those of later calls to WithAttrs, in a group called name.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(groupOrAttrs{group: name})
+}
+
+func (h *SlogHandler) with(goa groupOrAttrs) *SlogHandler {
+	h2 := *h
+	h2.goas = make([]groupOrAttrs, len(h.goas)+1)
+	copy(h2.goas, h.goas)
+	h2.goas[len(h.goas)] = goa
+	return &h2
+}
+
+// Handle writes r to the Logger.
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	e := Entry{
+		Timestamp: r.Time,
+		Severity:  SlogSeverity(r.Level),
+	}
+
+	// Build the payload from the inside out: the record's attributes are in
+	// the innermost group. An attribute only replaces one with the same key
+	// that was added later, so the record's attributes win over those of
+	// WithAttrs.
+	fields := map[string]*structpb.Value{}
+	r.Attrs(func(a slog.Attr) bool {
+		addSlogAttr(fields, a, &e, false)
+		return true
+	})
+	for i := len(h.goas) - 1; i >= 0; i-- {
+		goa := h.goas[i]
+		if goa.group != "" {
+			// Groups without attributes are omitted.
+			if len(fields) > 0 {
+				fields = map[string]*structpb.Value{goa.group: structpb.NewStructValue(&structpb.Struct{Fields: fields})}
+			}
+			continue
+		}
+		for _, a := range goa.attrs {
+			addSlogAttr(fields, a, &e, true)
+		}
+	}
+	fields["message"] = structpb.NewStringValue(r.Message)
+	e.Payload = &structpb.Struct{Fields: fields}
+
+	if span := trace.FromContext(ctx); span != nil {
+		sc := span.SpanContext()
+		e.Trace = fmt.Sprintf("%s/traces/%s", h.l.client.parent, sc.TraceID)
+		e.SpanID = sc.SpanID.String()
+		e.TraceSampled = sc.IsSampled()
+	}
+
+	pop := h.l.populateSourceLocation
+	if r.PC != 0 && (h.opts.AddSource || pop == AlwaysPopulateSourceLocation ||
+		pop == PopulateSourceLocationForDebugEntries && e.Severity == Debug) {
+		f, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		e.SourceLocation = &logpb.LogEntrySourceLocation{
+			File:     f.File,
+			Function: f.Function,
+			Line:     int64(f.Line),
+		}
+	}
+
+	h.l.Log(e)
+	return nil
+}
+
+// addSlogAttr adds a to fields, following the rules of slog.Handler: empty
+// attributes are ignored, and the attributes of groups with empty keys are
+// inlined. If keep is true, an existing field with the same key is kept.
+// Attributes whose values are HTTP requests set e.HTTPRequest instead.
+func addSlogAttr(fields map[string]*structpb.Value, a slog.Attr, e *Entry, keep bool) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return
+		}
+		if a.Key == "" {
+			for _, ga := range attrs {
+				addSlogAttr(fields, ga, e, keep)
+			}
+			return
+		}
+		sub := map[string]*structpb.Value{}
+		if v, ok := fields[a.Key]; ok && keep {
+			if s := v.GetStructValue(); s != nil {
+				sub = s.Fields
+			} else {
+				return
+			}
+		}
+		for _, ga := range attrs {
+			addSlogAttr(sub, ga, e, keep)
+		}
+		if len(sub) > 0 {
+			fields[a.Key] = structpb.NewStructValue(&structpb.Struct{Fields: sub})
+		}
+		return
+	}
+	if a.Value.Kind() == slog.KindAny {
+		switch r := a.Value.Any().(type) {
+		case *http.Request:
+			if e.HTTPRequest == nil {
+				e.HTTPRequest = &HTTPRequest{Request: r}
+			}
+			return
+		case *HTTPRequest:
+			if e.HTTPRequest == nil {
+				e.HTTPRequest = r
+			}
+			return
+		}
+	}
+	if _, ok := fields[a.Key]; ok && keep {
+		return
+	}
+	fields[a.Key] = slogValueToStructValue(a.Value)
+}
+
+// slogValueToStructValue converts a resolved, non-group slog.Value to a
+// Struct value.
+func slogValueToStructValue(v slog.Value) *structpb.Value {
+	switch v.Kind() {
+	case slog.KindString:
+		return structpb.NewStringValue(v.String())
+	case slog.KindInt64:
+		return structpb.NewNumberValue(float64(v.Int64()))
+	case slog.KindUint64:
+		return structpb.NewNumberValue(float64(v.Uint64()))
+	case slog.KindFloat64:
+		return structpb.NewNumberValue(v.Float64())
+	case slog.KindBool:
+		return structpb.NewBoolValue(v.Bool())
+	case slog.KindDuration:
+		return structpb.NewStringValue(v.Duration().String())
+	case slog.KindTime:
+		return structpb.NewStringValue(v.Time().Format(time.RFC3339Nano))
+	}
+	x := v.Any()
+	if err, ok := x.(error); ok {
+		if _, ok := x.(json.Marshaler); !ok {
+			return structpb.NewStringValue(err.Error())
+		}
+	}
+	// Other values are converted with their JSON encoding, as the fields of
+	// a JSON payload are.
+	jb, err := json.Marshal(x)
+	if err != nil {
+		return structpb.NewStringValue(fmt.Sprint(x))
+	}
+	var j interface{}
+	if err := json.Unmarshal(jb, &j); err != nil {
+		return structpb.NewStringValue(fmt.Sprint(x))
+	}
+	return jsonValueToStructValue(j)
+}
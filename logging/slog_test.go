@@ -0,0 +1,207 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.21
+
+package logging_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/trace"
+)
+
+// slogOutput logs with a SlogHandler for a Logger that redirects to a
+// buffer, and returns the decoded entries.
+func slogOutput(t *testing.T, opts *logging.SlogHandlerOptions, f func(*slog.Logger)) []map[string]interface{} {
+	t.Helper()
+	var buf strings.Builder
+	lg := client.Logger("test-slog", logging.RedirectAsJSON(&buf))
+	f(slog.New(lg.SlogHandler(opts)))
+	var res []map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(buf.String()))
+	for dec.More() {
+		var m map[string]interface{}
+		if err := dec.Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+func TestSlogHandlerPayload(t *testing.T) {
+	got := slogOutput(t, nil, func(l *slog.Logger) {
+		l = l.With("a", 1).WithGroup("g").With("b", true)
+		l.Info("hello", "c", "x", slog.Group("h", "d", 1.5, "e", time.Second), slog.Group("empty"))
+		l.Warn("error", "err", errors.New("bad"))
+		l.WithGroup("unused").Warn("no attrs")
+		l.Debug("not logged")
+	})
+	want := []map[string]interface{}{
+		{
+			"severity": "INFO",
+			"message": map[string]interface{}{
+				"message": "hello",
+				"a":       1.0,
+				"g": map[string]interface{}{
+					"b": true,
+					"c": "x",
+					"h": map[string]interface{}{"d": 1.5, "e": "1s"},
+				},
+			},
+		},
+		{
+			"severity": "WARNING",
+			"message": map[string]interface{}{
+				"message": "error",
+				"a":       1.0,
+				"g":       map[string]interface{}{"b": true, "err": "bad"},
+			},
+		},
+		{
+			"severity": "WARNING",
+			"message": map[string]interface{}{
+				"message": "no attrs",
+				"a":       1.0,
+				"g":       map[string]interface{}{"b": true},
+			},
+		},
+	}
+	for _, m := range got {
+		delete(m, "timestamp")
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSlogSeverity(t *testing.T) {
+	for _, test := range []struct {
+		level slog.Level
+		want  logging.Severity
+	}{
+		{slog.LevelDebug - 4, logging.Debug},
+		{slog.LevelDebug, logging.Debug},
+		{slog.LevelInfo, logging.Info},
+		{slog.LevelInfo + 2, logging.Notice},
+		{slog.LevelWarn, logging.Warning},
+		{slog.LevelError, logging.Error},
+		{slog.LevelError + 4, logging.Critical},
+		{slog.LevelError + 8, logging.Alert},
+		{slog.LevelError + 12, logging.Emergency},
+		{slog.LevelError + 100, logging.Emergency},
+	} {
+		if got := logging.SlogSeverity(test.level); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.level, got, test.want)
+		}
+	}
+}
+
+func TestSlogHandlerLevel(t *testing.T) {
+	got := slogOutput(t, &logging.SlogHandlerOptions{Level: slog.LevelDebug}, func(l *slog.Logger) {
+		l.Debug("debug")
+		l.Log(context.Background(), slog.LevelError+4, "critical")
+	})
+	var sevs []string
+	for _, m := range got {
+		sevs = append(sevs, m["severity"].(string))
+	}
+	if want := []string{"DEBUG", "CRITICAL"}; !cmp.Equal(sevs, want) {
+		t.Errorf("got %v, want %v", sevs, want)
+	}
+}
+
+func TestSlogHandlerSource(t *testing.T) {
+	got := slogOutput(t, &logging.SlogHandlerOptions{AddSource: true}, func(l *slog.Logger) {
+		l.Info("here")
+	})
+	loc, ok := got[0]["logging.googleapis.com/sourceLocation"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no source location in %v", got[0])
+	}
+	if file := loc["file"].(string); !strings.HasSuffix(file, "slog_test.go") {
+		t.Errorf("got file %q, want slog_test.go", file)
+	}
+	if fn := loc["function"].(string); !strings.HasSuffix(fn, "TestSlogHandlerSource.func1") {
+		t.Errorf("got function %q, want TestSlogHandlerSource.func1", fn)
+	}
+}
+
+func TestSlogHandlerTrace(t *testing.T) {
+	const (
+		traceID = "105445aa7843bc8bf206b12000100000"
+		spanID  = "74"
+	)
+	var sc trace.SpanContext
+	got := slogOutput(t, nil, func(l *slog.Logger) {
+		// From an OpenCensus span in the context.
+		ctx, span := trace.StartSpan(context.Background(), "test", trace.WithSampler(trace.AlwaysSample()))
+		l.InfoContext(ctx, "span")
+		span.End()
+		sc = span.SpanContext()
+
+		// From the headers of a request.
+		req, _ := http.NewRequest("GET", "http://example.com/x", nil)
+		req.Header.Set("X-Cloud-Trace-Context", traceID+"/"+spanID+";o=1")
+		l.Info("request", "req", req)
+	})
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	for i, want := range []map[string]interface{}{
+		{
+			"logging.googleapis.com/trace":         "projects/" + testProjectID + "/traces/" + sc.TraceID.String(),
+			"logging.googleapis.com/spanId":        sc.SpanID.String(),
+			"logging.googleapis.com/trace_sampled": true,
+		},
+		{
+			"logging.googleapis.com/trace":         "projects/" + testProjectID + "/traces/" + traceID,
+			"logging.googleapis.com/spanId":        spanID,
+			"logging.googleapis.com/trace_sampled": true,
+		},
+	} {
+		for k, w := range want {
+			if got[i][k] != w {
+				t.Errorf("entry %d: %s: got %v, want %v", i, k, got[i][k], w)
+			}
+		}
+	}
+	if _, ok := got[1]["httpRequest"]; !ok {
+		t.Errorf("no httpRequest in %v", got[1])
+	}
+	if _, ok := got[1]["message"].(map[string]interface{})["req"]; ok {
+		t.Error("request is in the payload")
+	}
+}
+
+func ExampleLogger_SlogHandler() {
+	ctx := context.Background()
+	client, err := logging.NewClient(ctx, "my-project")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	lg := client.Logger("my-log")
+	logger := slog.New(lg.SlogHandler(&logging.SlogHandlerOptions{AddSource: true}))
+	logger.InfoContext(ctx, "request handled", "path", "/index.html", "status", 200)
+}
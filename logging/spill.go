@@ -0,0 +1,449 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	gax "github.com/googleapis/gax-go/v2"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const statsPrefix = "cloud.google.com/go/logging/"
+
+// keyLogName tags the disk buffer measures with the name of the log.
+var keyLogName = tag.MustNewKey("log_name")
+
+// The following are measures recorded by Loggers with a disk buffer. See
+// the DiskBuffer option.
+var (
+	// SpilledEntries is a measure of the number of log entries written to a
+	// disk buffer.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	SpilledEntries = stats.Int64(statsPrefix+"spilled_entries", "Number of log entries written to the disk buffer", stats.UnitDimensionless)
+
+	// ReplayedEntries is a measure of the number of log entries from a disk
+	// buffer that were written to the logging service.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	ReplayedEntries = stats.Int64(statsPrefix+"replayed_entries", "Number of log entries replayed from the disk buffer", stats.UnitDimensionless)
+
+	// DroppedEntries is a measure of the number of log entries that a Logger
+	// with a disk buffer failed to write, either because the disk buffer was
+	// full or because the logging service rejected them.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	DroppedEntries = stats.Int64(statsPrefix+"dropped_entries", "Number of log entries dropped", stats.UnitDimensionless)
+)
+
+var (
+	// SpilledEntriesView is a cumulative sum of SpilledEntries, by log name.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	SpilledEntriesView = &view.View{
+		Name:        statsPrefix + "spilled_entries",
+		Description: SpilledEntries.Description(),
+		Measure:     SpilledEntries,
+		TagKeys:     []tag.Key{keyLogName},
+		Aggregation: view.Sum(),
+	}
+
+	// ReplayedEntriesView is a cumulative sum of ReplayedEntries, by log name.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	ReplayedEntriesView = &view.View{
+		Name:        statsPrefix + "replayed_entries",
+		Description: ReplayedEntries.Description(),
+		Measure:     ReplayedEntries,
+		TagKeys:     []tag.Key{keyLogName},
+		Aggregation: view.Sum(),
+	}
+
+	// DroppedEntriesView is a cumulative sum of DroppedEntries, by log name.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	DroppedEntriesView = &view.View{
+		Name:        statsPrefix + "dropped_entries",
+		Description: DroppedEntries.Description(),
+		Measure:     DroppedEntries,
+		TagKeys:     []tag.Key{keyLogName},
+		Aggregation: view.Sum(),
+	}
+)
+
+// ErrDiskBufferFull signals that a Logger dropped entries because its disk
+// buffer had reached its byte limit.
+var ErrDiskBufferFull = errors.New("logging: disk buffer is full")
+
+const (
+	// maxSegmentBytes is the size after which a new segment file of a disk
+	// buffer is started.
+	maxSegmentBytes = 1 << 22 // 4MiB
+
+	segmentSuffix = ".spill"
+)
+
+// For testing:
+var (
+	replayInitialBackoff = time.Second
+	replayMaxBackoff     = time.Minute
+)
+
+// DiskBuffer makes the Logger write entries that it can't send to the
+// logging service to a journal in the directory dir, and replay them once
+// the service is reachable again. Entries are written to the journal when
+// the buffer set by BufferedByteLimit overflows, and when a write to the
+// service fails with an error that may be temporary, such as codes.Unavailable.
+// While the journal holds entries, new entries are added to it too, so that
+// entries are sent in the order they were buffered.
+//
+// The journal holds at most byteLimit bytes; entries that don't fit are
+// dropped and reported to Client.OnError as ErrDiskBufferFull. Entries left
+// in the journal when the Client is closed are replayed by the next Logger
+// created with the same directory, which must not be shared by two Loggers
+// at the same time. Entries in the journal may be sent more than once if
+// the process exits while they are being replayed.
+//
+// The SpilledEntries, ReplayedEntries and DroppedEntries measures report on
+// the journal. If the directory can't be used, the error is reported to
+// Client.OnError and the Logger runs without a disk buffer.
+//
+// This option is ignored if RedirectAsJSON is also used.
+func DiskBuffer(dir string, byteLimit int64) LoggerOption {
+	return diskBuffer{dir: dir, limit: byteLimit}
+}
+
+type diskBuffer struct {
+	dir   string
+	limit int64
+}
+
+func (d diskBuffer) set(l *Logger) { l.diskBuffer = &d }
+
+// startSpill opens the disk buffer of l, if it has one, and starts
+// replaying its entries.
+func (l *Logger) startSpill() {
+	if l.diskBuffer == nil || l.redirectOutputWriter != nil {
+		return
+	}
+	j, err := openJournal(l.diskBuffer.dir, l.diskBuffer.limit)
+	if err != nil {
+		l.client.error(fmt.Errorf("logging: opening disk buffer: %w", err))
+		return
+	}
+	l.journal = j
+	l.statsCtx, _ = tag.New(context.Background(), tag.Upsert(keyLogName, l.logName))
+	l.client.loggers.Add(1)
+	go func() {
+		defer l.client.loggers.Done()
+		l.replaySpilled()
+	}()
+}
+
+// spill writes entries to the disk buffer of l.
+func (l *Logger) spill(entries []*logpb.LogEntry) {
+	if err := l.journal.write(entries); err != nil {
+		stats.Record(l.statsCtx, DroppedEntries.M(int64(len(entries))))
+		l.client.error(err)
+		return
+	}
+	stats.Record(l.statsCtx, SpilledEntries.M(int64(len(entries))))
+}
+
+// replaySpilled sends the entries of the disk buffer to the logging
+// service, oldest first, until the client is closed.
+func (l *Logger) replaySpilled() {
+	bo := gax.Backoff{Initial: replayInitialBackoff, Max: replayMaxBackoff, Multiplier: 2}
+	for {
+		entries, n, err := l.journal.next()
+		if err != nil {
+			// The rest of the segment is unreadable, and has been removed.
+			l.client.error(fmt.Errorf("logging: reading disk buffer: %w", err))
+			continue
+		}
+		if entries == nil {
+			select {
+			case <-l.journal.wake:
+				continue
+			case <-l.client.donec:
+				return
+			}
+		}
+		err = l.send(entries)
+		if err == nil || !spillable(err) {
+			if cerr := l.journal.commit(n); cerr != nil {
+				l.client.error(cerr)
+			}
+			if err != nil {
+				stats.Record(l.statsCtx, DroppedEntries.M(int64(len(entries))))
+				l.client.error(err)
+			} else {
+				stats.Record(l.statsCtx, ReplayedEntries.M(int64(len(entries))))
+				bo = gax.Backoff{Initial: replayInitialBackoff, Max: replayMaxBackoff, Multiplier: 2}
+			}
+			continue
+		}
+		select {
+		case <-time.After(bo.Pause()):
+		case <-l.client.donec:
+			return
+		}
+	}
+}
+
+// spillable reports whether entries that failed to be written with err
+// should be written to the disk buffer.
+func spillable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted,
+		codes.Aborted, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
+
+// A journal is a bounded, on-disk queue of bundles of log entries. It is a
+// sequence of segment files, whose names are increasing sequence numbers.
+// Each segment holds records of a 4-byte big-endian length followed by a
+// WriteLogEntriesRequest holding the entries.
+type journal struct {
+	dir   string
+	limit int64
+	wake  chan struct{} // signaled when a record is written
+
+	mu      sync.Mutex
+	segs    []segment // oldest first
+	size    int64     // total size of segs
+	w       *os.File  // the last segment, if it is being written
+	nextSeq int64
+	readOff int64 // offset in segs[0] of the next record to read
+}
+
+type segment struct {
+	seq  int64
+	size int64
+}
+
+func openJournal(dir string, limit int64) (*journal, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{dir: dir, limit: limit, wake: make(chan struct{}, 1)}
+	for _, de := range des {
+		name := de.Name()
+		if de.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil, err
+		}
+		j.segs = append(j.segs, segment{seq: seq, size: info.Size()})
+		j.size += info.Size()
+	}
+	sort.Slice(j.segs, func(a, b int) bool { return j.segs[a].seq < j.segs[b].seq })
+	if n := len(j.segs); n > 0 {
+		j.nextSeq = j.segs[n-1].seq + 1
+		j.signal()
+	}
+	return j, nil
+}
+
+func (j *journal) segmentPath(seq int64) string {
+	return filepath.Join(j.dir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
+
+func (j *journal) signal() {
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pending reports whether the journal holds any entries.
+func (j *journal) pending() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.size > 0
+}
+
+// write appends a record holding entries to the journal.
+func (j *journal) write(entries []*logpb.LogEntry) error {
+	b, err := proto.Marshal(&logpb.WriteLogEntriesRequest{Entries: entries})
+	if err != nil {
+		return err
+	}
+	rec := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(rec, uint32(len(b)))
+	copy(rec[4:], b)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.size+int64(len(rec)) > j.limit {
+		return ErrDiskBufferFull
+	}
+	if j.w != nil && j.segs[len(j.segs)-1].size+int64(len(rec)) > maxSegmentBytes {
+		if err := j.closeSegment(); err != nil {
+			return err
+		}
+	}
+	if j.w == nil {
+		f, err := os.OpenFile(j.segmentPath(j.nextSeq), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		j.w = f
+		j.segs = append(j.segs, segment{seq: j.nextSeq})
+		j.nextSeq++
+	}
+	n, err := j.w.Write(rec)
+	j.segs[len(j.segs)-1].size += int64(n)
+	j.size += int64(n)
+	if err != nil {
+		// Don't append to a segment with a partial record.
+		j.closeSegment()
+		return err
+	}
+	j.signal()
+	return nil
+}
+
+// closeSegment finishes writing the last segment. j.mu must be held.
+func (j *journal) closeSegment() error {
+	if j.w == nil {
+		return nil
+	}
+	err := j.w.Sync()
+	if err2 := j.w.Close(); err == nil {
+		err = err2
+	}
+	j.w = nil
+	return err
+}
+
+// next returns the entries of the oldest record in the journal, and the
+// size of the record, to pass to commit. It returns nil entries if the
+// journal is empty. If the record can't be read, next removes the segment
+// that holds it and returns an error.
+func (j *journal) next() ([]*logpb.LogEntry, int64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for len(j.segs) > 0 {
+		seg := j.segs[0]
+		if j.readOff >= seg.size {
+			if len(j.segs) == 1 && j.w != nil {
+				// The segment is still being written, but it has been read.
+				return nil, 0, nil
+			}
+			if err := j.removeOldest(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if len(j.segs) == 1 {
+			// Finish the segment, so it isn't read as it is written.
+			if err := j.closeSegment(); err != nil {
+				return nil, 0, err
+			}
+		}
+		req, n, err := j.read(seg)
+		if err != nil {
+			if err2 := j.removeOldest(); err2 != nil {
+				return nil, 0, err2
+			}
+			return nil, 0, err
+		}
+		return req.Entries, n, nil
+	}
+	return nil, 0, nil
+}
+
+// read reads the record at j.readOff in seg. j.mu must be held.
+func (j *journal) read(seg segment) (*logpb.WriteLogEntriesRequest, int64, error) {
+	f, err := os.Open(j.segmentPath(seg.seq))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	var hdr [4]byte
+	if _, err := f.ReadAt(hdr[:], j.readOff); err != nil {
+		return nil, 0, fmt.Errorf("segment %d: %w", seg.seq, err)
+	}
+	n := int64(binary.BigEndian.Uint32(hdr[:]))
+	if j.readOff+4+n > seg.size {
+		return nil, 0, fmt.Errorf("segment %d: %w", seg.seq, io.ErrUnexpectedEOF)
+	}
+	b := make([]byte, n)
+	if _, err := f.ReadAt(b, j.readOff+4); err != nil {
+		return nil, 0, fmt.Errorf("segment %d: %w", seg.seq, err)
+	}
+	req := &logpb.WriteLogEntriesRequest{}
+	if err := proto.Unmarshal(b, req); err != nil {
+		return nil, 0, fmt.Errorf("segment %d: %w", seg.seq, err)
+	}
+	return req, 4 + n, nil
+}
+
+// commit marks the record returned by the last call to next as done.
+func (j *journal) commit(n int64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.readOff += n
+	if len(j.segs) > 0 && j.readOff >= j.segs[0].size && (len(j.segs) > 1 || j.w == nil) {
+		return j.removeOldest()
+	}
+	return nil
+}
+
+// removeOldest removes the oldest segment. j.mu must be held.
+func (j *journal) removeOldest() error {
+	seg := j.segs[0]
+	if len(j.segs) == 1 {
+		if err := j.closeSegment(); err != nil {
+			return err
+		}
+	}
+	j.segs = j.segs[1:]
+	j.size -= seg.size
+	j.readOff = 0
+	return os.Remove(j.segmentPath(seg.seq))
+}
+
+// close finishes writing the journal.
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.closeSegment()
+}
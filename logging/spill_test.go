@@ -0,0 +1,297 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/internal/testutil"
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/stats/view"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// textEntries returns entries whose text payloads are the strings.
+func textEntries(ss ...string) []*logpb.LogEntry {
+	var es []*logpb.LogEntry
+	for _, s := range ss {
+		es = append(es, &logpb.LogEntry{Payload: &logpb.LogEntry_TextPayload{TextPayload: s}})
+	}
+	return es
+}
+
+func payloads(es []*logpb.LogEntry) []string {
+	var ss []string
+	for _, e := range es {
+		ss = append(ss, e.GetTextPayload())
+	}
+	return ss
+}
+
+// readJournal reads and commits all the records of j.
+func readJournal(t *testing.T, j *journal) []string {
+	t.Helper()
+	var got []string
+	for {
+		es, n, err := j.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if es == nil {
+			return got
+		}
+		got = append(got, payloads(es)...)
+		if err := j.commit(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	j, err := openJournal(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.pending() {
+		t.Fatal("new journal is pending")
+	}
+	for _, es := range [][]*logpb.LogEntry{textEntries("a", "b"), textEntries("c")} {
+		if err := j.write(es); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Read one record, then write another while the journal is partly read.
+	es, n, err := j.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := payloads(es), []string{"a", "b"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := j.commit(n); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.write(textEntries("d")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readJournal(t, j), []string{"c", "d"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if j.pending() {
+		t.Error("empty journal is pending")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix)); len(files) != 0 {
+		t.Errorf("segments left after reading: %v", files)
+	}
+}
+
+func TestJournalReopen(t *testing.T) {
+	dir := t.TempDir()
+	j, err := openJournal(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := j.write(textEntries(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+		// Start a new segment for each record.
+		j.mu.Lock()
+		j.closeSegment()
+		j.mu.Unlock()
+	}
+	if err := j.close(); err != nil {
+		t.Fatal(err)
+	}
+	// A truncated record at the end of the last segment is dropped.
+	f, err := os.OpenFile(j.segmentPath(2), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 'x'})
+	f.Close()
+
+	j, err = openJournal(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !j.pending() {
+		t.Fatal("reopened journal is not pending")
+	}
+	var got []string
+	for {
+		es, n, err := j.next()
+		if err != nil {
+			continue // the truncated record
+		}
+		if es == nil {
+			break
+		}
+		got = append(got, payloads(es)...)
+		j.commit(n)
+	}
+	if want := []string{"0", "1", "2"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// New segments follow the old ones.
+	if err := j.write(textEntries("3")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(j.segmentPath(3)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJournalLimit(t *testing.T) {
+	j, err := openJournal(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.write(textEntries("small")); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.write(textEntries(string(make([]byte, 100)))); err != ErrDiskBufferFull {
+		t.Errorf("got %v, want ErrDiskBufferFull", err)
+	}
+	if got, want := readJournal(t, j), []string{"small"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// flakyServer is a fake logging service whose writes fail while it is down.
+type flakyServer struct {
+	logpb.UnimplementedLoggingServiceV2Server
+
+	mu      sync.Mutex
+	down    bool
+	entries []*logpb.LogEntry
+}
+
+func (s *flakyServer) WriteLogEntries(_ context.Context, req *logpb.WriteLogEntriesRequest) (*logpb.WriteLogEntriesResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return nil, status.Error(codes.Unavailable, "down")
+	}
+	s.entries = append(s.entries, req.Entries...)
+	return &logpb.WriteLogEntriesResponse{}, nil
+}
+
+func (s *flakyServer) setDown(b bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = b
+}
+
+func (s *flakyServer) payloads() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return payloads(s.entries)
+}
+
+func TestDiskBuffer(t *testing.T) {
+	defer func(d time.Duration) { replayInitialBackoff = d }(replayInitialBackoff)
+	replayInitialBackoff = 10 * time.Millisecond
+	if err := view.Register(SpilledEntriesView, ReplayedEntriesView); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(SpilledEntriesView, ReplayedEntriesView)
+
+	fake := &flakyServer{down: true}
+	srv, err := testutil.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logpb.RegisterLoggingServiceV2Server(srv.Gsrv, fake)
+	srv.Start()
+	defer srv.Close()
+
+	ctx := context.Background()
+	c, err := NewClient(ctx, "projects/P", option.WithEndpoint(srv.Addr),
+		option.WithoutAuthentication(), option.WithGRPCDialOption(grpc.WithInsecure()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.OnError = func(err error) { t.Errorf("OnError: %v", err) }
+	// The client retries writes that fail with Unavailable, so give up on
+	// them quickly.
+	ctxFunc := func() (context.Context, func()) {
+		return context.WithTimeout(context.Background(), 100*time.Millisecond)
+	}
+	lg := c.Logger("spill", DiskBuffer(t.TempDir(), 1<<20), EntryCountThreshold(1), ContextFunc(ctxFunc))
+
+	// While the service is down, entries go to the disk buffer.
+	var want []string
+	for i := 0; i < 5; i++ {
+		s := fmt.Sprint(i)
+		lg.Log(Entry{Payload: s})
+		want = append(want, s)
+	}
+	lg.Flush()
+	if !lg.journal.pending() {
+		t.Fatal("no entries in the disk buffer")
+	}
+	if got := fake.payloads(); len(got) != 0 {
+		t.Fatalf("service got %v while down", got)
+	}
+
+	// Once it is up, they are replayed in order, followed by new entries.
+	fake.setDown(false)
+	lg.Log(Entry{Payload: "5"})
+	want = append(want, "5")
+	deadline := time.Now().Add(10 * time.Second)
+	for len(fake.payloads()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		lg.Flush()
+	}
+	if got := fake.payloads(); !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		v    *view.View
+		want int
+	}{
+		{SpilledEntriesView, len(want)},
+		{ReplayedEntriesView, len(want)},
+	} {
+		rows, err := view.RetrieveData(test.v.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got int
+		for _, r := range rows {
+			got += int(r.Data.(*view.SumData).Value)
+		}
+		// Entry "5" may have been sent directly.
+		if got != test.want && got != test.want-1 {
+			t.Errorf("%s: got %d, want %d", test.v.Name, got, test.want)
+		}
+	}
+}
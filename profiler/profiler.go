@@ -25,6 +25,10 @@
 // Calling Start will start a goroutine to collect profiles and upload to
 // the profiler server, at the rhythm specified by the server.
 //
+// To profile without the profiler server, for example in deployments that
+// cannot reach Google Cloud, set Config.Sink. The profiles are then written to
+// the Sink, such as a local directory returned by DirSink.
+//
 // The caller must provide the service string in the config, and may provide
 // other information as well. See Config for details.
 //
@@ -182,6 +186,21 @@ type Config struct {
 	// the metadata server is present but is flaky or otherwise misbehave.
 	Zone string
 
+	// Sink, if set, receives the collected profiles instead of the profiler
+	// server, for example to profile deployments that cannot reach Google
+	// Cloud. The agent then schedules profiles itself: it collects a profile
+	// of each enabled type in turn, one every SinkInterval, and the CPU,
+	// allocation and mutex profiles cover 10 seconds, as when the server
+	// schedules them. ProjectID is not required, and APIAddr and the client
+	// options passed to Start are ignored. See DirSink and WriterSink.
+	//
+	// Sink is EXPERIMENTAL and subject to change or removal without notice.
+	Sink Sink
+
+	// SinkInterval is the time between the starts of consecutive profiles
+	// collected for Sink. It defaults to one minute.
+	SinkInterval time.Duration
+
 	// numProfiles is the number of profiles which should be collected before
 	// the profile collection loop exits.When numProfiles is 0, profiles will
 	// be collected for the duration of the program. For testing only.
@@ -238,6 +257,16 @@ func start(cfg Config, options ...option.ClientOption) error {
 
 	ctx := context.Background()
 
+	if config.Sink != nil {
+		a, err := initializeAgent(nil)
+		if err != nil {
+			debugLog("failed to start the profiling agent: %v", err)
+			return err
+		}
+		go pollProfilerService(ctx, a)
+		return nil
+	}
+
 	opts := []option.ClientOption{
 		option.WithEndpoint(config.APIAddr),
 		option.WithScopes(scope),
@@ -276,6 +305,12 @@ type agent struct {
 	deployment    *pb.Deployment
 	profileLabels map[string]string
 	profileTypes  []pb.ProfileType
+
+	// Used instead of client when Config.Sink is set.
+	sink         Sink
+	sinkInterval time.Duration
+	sinkNext     time.Time // when the next profile is due
+	sinkTurn     int       // index into profileTypes of the next profile
 }
 
 // abortedBackoffDuration retrieves the retry duration from gRPC trailing
@@ -376,6 +411,7 @@ func (a *agent) profileAndUpload(ctx context.Context, p *pb.Profile) {
 		return
 	}
 
+	start := time.Now()
 	switch pt {
 	case pb.ProfileType_CPU:
 		duration, err := ptypes.Duration(p.Duration)
@@ -426,6 +462,10 @@ func (a *agent) profileAndUpload(ctx context.Context, p *pb.Profile) {
 
 	p.ProfileBytes = prof.Bytes()
 	p.Labels = a.profileLabels
+	if a.sink != nil {
+		a.writeToSink(ctx, p, start)
+		return
+	}
 	req := pb.UpdateProfileRequest{Profile: p}
 
 	// Upload profile, discard profile in case of error.
@@ -529,12 +569,20 @@ func initializeAgent(c pb.ProfilerServiceClient) (*agent, error) {
 		return nil, fmt.Errorf("collection is not enabled for any profile types")
 	}
 
-	return &agent{
+	a := &agent{
 		client:        c,
 		deployment:    d,
 		profileLabels: profileLabels,
 		profileTypes:  profileTypes,
-	}, nil
+	}
+	if config.Sink != nil {
+		a.sink = config.Sink
+		a.sinkInterval = config.SinkInterval
+		if a.sinkInterval <= 0 {
+			a.sinkInterval = defaultSinkInterval
+		}
+	}
+	return a, nil
 }
 
 func initializeConfig(cfg Config) error {
@@ -596,7 +644,7 @@ func initializeConfig(cfg Config) error {
 			}
 		}
 	} else {
-		if config.ProjectID == "" {
+		if config.ProjectID == "" && config.Sink == nil {
 			return fmt.Errorf("project ID must be specified in the configuration if running outside of GCP")
 		}
 	}
@@ -614,7 +662,12 @@ func pollProfilerService(ctx context.Context, a *agent) {
 	debugLog("Cloud Profiler Go Agent version: %s", internal.Version)
 	debugLog("profiler has started")
 	for i := 0; config.numProfiles == 0 || i < config.numProfiles; i++ {
-		p := a.createProfile(ctx)
+		var p *pb.Profile
+		if a.sink != nil {
+			p = a.nextSinkProfile(ctx)
+		} else {
+			p = a.createProfile(ctx)
+		}
 		a.profileAndUpload(ctx, p)
 	}
 
@@ -23,3 +23,13 @@ func ExampleStart() {
 		//TODO: Handle error.
 	}
 }
+
+func ExampleDirSink() {
+	// Keep the last day of profiles in a local directory.
+	if err := profiler.Start(profiler.Config{
+		Service: "my-service",
+		Sink:    profiler.DirSink("/var/lib/my-service/profiles", 24*60),
+	}); err != nil {
+		//TODO: Handle error.
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profiler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	pb "google.golang.org/genproto/googleapis/devtools/cloudprofiler/v2"
+)
+
+const (
+	// defaultSinkInterval is the default time between the starts of
+	// consecutive profiles collected for a Sink.
+	defaultSinkInterval = time.Minute
+	// sinkProfileDuration is the duration of the CPU, allocation and mutex
+	// profiles collected for a Sink, which matches the duration requested by
+	// the profiler server.
+	sinkProfileDuration = 10 * time.Second
+)
+
+// A Sink receives the profiles collected by the agent when Config.Sink is
+// set. Its methods are called from a single goroutine.
+//
+// Sink is EXPERIMENTAL and subject to change or removal without notice.
+type Sink interface {
+	// WriteProfile stores p. If it returns an error, the profile is
+	// discarded.
+	WriteProfile(ctx context.Context, p *Profile) error
+}
+
+// A Profile is a profile collected for a Sink.
+type Profile struct {
+	// Type is the profile type: "cpu", "heap", "alloc", "goroutine" or
+	// "mutex".
+	Type string
+
+	// Start is when the collection of the profile started.
+	Start time.Time
+
+	// Duration is the time over which the profile was collected. It is zero
+	// for the heap and goroutine profiles, which are snapshots.
+	Duration time.Duration
+
+	// Labels describe the deployment that was profiled: the service, and
+	// the version, zone and instance, if known.
+	Labels map[string]string
+
+	// Data is the profile, in the gzip-compressed protocol buffer format
+	// read by pprof.
+	Data []byte
+}
+
+// sinkProfileTypes maps profile types to the names used in Profile.Type.
+var sinkProfileTypes = map[pb.ProfileType]string{
+	pb.ProfileType_CPU:        "cpu",
+	pb.ProfileType_HEAP:       "heap",
+	pb.ProfileType_HEAP_ALLOC: "alloc",
+	pb.ProfileType_THREADS:    "goroutine",
+	pb.ProfileType_CONTENTION: "mutex",
+}
+
+// WriterSink returns a Sink that writes the data of each profile to a writer
+// returned by newWriter, and then closes it.
+func WriterSink(newWriter func(p *Profile) (io.WriteCloser, error)) Sink {
+	return writerSink(newWriter)
+}
+
+type writerSink func(p *Profile) (io.WriteCloser, error)
+
+func (s writerSink) WriteProfile(_ context.Context, p *Profile) error {
+	w, err := s(p)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(p.Data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// DirSink returns a Sink that writes each profile to a file in dir, which is
+// created if necessary. The files are named after the start time and type of
+// the profiles, as in "20230102T150405.000000000Z-cpu.pb.gz", so that they
+// sort in the order the profiles were collected. If maxFiles is positive, the
+// oldest files are removed so that no more than maxFiles profiles remain.
+func DirSink(dir string, maxFiles int) Sink {
+	return &dirSink{dir: dir, maxFiles: maxFiles}
+}
+
+type dirSink struct {
+	dir      string
+	maxFiles int
+
+	mu sync.Mutex
+}
+
+const dirSinkSuffix = ".pb.gz"
+
+func (s *dirSink) WriteProfile(_ context.Context, p *Profile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s%s", p.Start.UTC().Format("20060102T150405.000000000Z"), p.Type, dirSinkSuffix)
+	// Write to a temporary file first, so that readers of the directory
+	// never see a partial profile.
+	tmp := filepath.Join(s.dir, "."+name)
+	if err := os.WriteFile(tmp, p.Data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return s.rotate()
+}
+
+// rotate removes the oldest profiles in the directory beyond maxFiles.
+func (s *dirSink) rotate() error {
+	if s.maxFiles <= 0 {
+		return nil
+	}
+	des, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, de := range des {
+		if n := de.Name(); !de.IsDir() && !strings.HasPrefix(n, ".") && strings.HasSuffix(n, dirSinkSuffix) {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	for len(names) > s.maxFiles {
+		if err := os.Remove(filepath.Join(s.dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// nextSinkProfile plays the part of the profiler server for an agent with a
+// Sink. It waits until the next profile is due, and returns a profile of the
+// next enabled type, in turn.
+func (a *agent) nextSinkProfile(ctx context.Context) *pb.Profile {
+	if !a.sinkNext.IsZero() {
+		sleep(ctx, time.Until(a.sinkNext))
+	}
+	a.sinkNext = time.Now().Add(a.sinkInterval)
+	pt := a.profileTypes[a.sinkTurn%len(a.profileTypes)]
+	a.sinkTurn++
+	p := &pb.Profile{ProfileType: pt}
+	switch pt {
+	case pb.ProfileType_CPU, pb.ProfileType_HEAP_ALLOC, pb.ProfileType_CONTENTION:
+		p.Duration = ptypes.DurationProto(sinkProfileDuration)
+	}
+	return p
+}
+
+// writeToSink passes the collected profile p, which started at start, to the
+// agent's Sink.
+func (a *agent) writeToSink(ctx context.Context, p *pb.Profile, start time.Time) {
+	labels := map[string]string{"service": a.deployment.Target}
+	for k, v := range a.deployment.Labels {
+		labels[k] = v
+	}
+	for k, v := range p.Labels {
+		labels[k] = v
+	}
+	sp := &Profile{
+		Type:   sinkProfileTypes[p.ProfileType],
+		Start:  start,
+		Labels: labels,
+		Data:   p.ProfileBytes,
+	}
+	if p.Duration != nil {
+		sp.Duration, _ = ptypes.Duration(p.Duration)
+	}
+	debugLog("start writing %s profile to sink", sp.Type)
+	if err := a.sink.WriteProfile(ctx, sp); err != nil {
+		debugLog("failed to write profile to sink: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profiler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/internal/testutil"
+)
+
+type fakeSink struct {
+	mu       sync.Mutex
+	profiles []*Profile
+}
+
+func (s *fakeSink) WriteProfile(_ context.Context, p *Profile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles = append(s.profiles, p)
+	return nil
+}
+
+func TestAgentWithSink(t *testing.T) {
+	oldConfig, oldProfilingDone, oldOnGCE, oldStartCPUProfile, oldStopCPUProfile, oldSleep := config, profilingDone, onGCE, startCPUProfile, stopCPUProfile, sleep
+	defer func() {
+		config, profilingDone, onGCE, startCPUProfile, stopCPUProfile, sleep = oldConfig, oldProfilingDone, oldOnGCE, oldStartCPUProfile, oldStopCPUProfile, oldSleep
+	}()
+
+	profilingDone = make(chan bool)
+	onGCE = func() bool { return false }
+	startCPUProfile = func(w io.Writer) error {
+		w.Write([]byte{1})
+		return nil
+	}
+	stopCPUProfile = func() {}
+	var (
+		mu     sync.Mutex
+		sleeps []time.Duration
+	)
+	sleep = func(ctx context.Context, d time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sleeps = append(sleeps, d)
+		return nil
+	}
+
+	sink := &fakeSink{}
+	// No project ID is needed outside of GCP.
+	if err := start(Config{
+		Service:      testService,
+		Instance:     testInstance,
+		Zone:         testZone,
+		Sink:         sink,
+		SinkInterval: time.Hour,
+		numProfiles:  5,
+	}); err != nil {
+		t.Fatalf("start(): %v", err)
+	}
+	select {
+	case <-profilingDone:
+	case <-time.After(testProfileCollectionTimeout):
+		t.Fatalf("got timeout after %v, want profile collection done", testProfileCollectionTimeout)
+	}
+
+	var gotTypes []string
+	for _, p := range sink.profiles {
+		gotTypes = append(gotTypes, p.Type)
+		if len(p.Data) == 0 {
+			t.Errorf("%s profile has no data", p.Type)
+		}
+		var wantDuration time.Duration
+		if p.Type == "cpu" || p.Type == "alloc" {
+			wantDuration = sinkProfileDuration
+		}
+		if p.Duration != wantDuration {
+			t.Errorf("%s profile: got duration %v, want %v", p.Type, p.Duration, wantDuration)
+		}
+		wantLabels := map[string]string{
+			"service":     testService,
+			languageLabel: "go",
+			zoneNameLabel: testZone,
+			instanceLabel: testInstance,
+		}
+		if diff := testutil.Diff(wantLabels, p.Labels); diff != "" {
+			t.Errorf("%s profile: labels mismatch (-want +got):\n%s", p.Type, diff)
+		}
+	}
+	if want := []string{"cpu", "heap", "goroutine", "alloc", "cpu"}; !testutil.Equal(gotTypes, want) {
+		t.Errorf("got profile types %v, want %v", gotTypes, want)
+	}
+	if got := sink.profiles[0].Data; !bytes.Equal(got, []byte{1}) {
+		t.Errorf("got CPU profile %v, want [1]", got)
+	}
+
+	// The agent waits for the interval between profiles, as well as
+	// collecting the CPU and allocation profiles for their duration.
+	var waits int
+	for _, d := range sleeps {
+		if d > 59*time.Minute {
+			waits++
+		}
+	}
+	if waits != 4 {
+		t.Errorf("got %d waits between profiles in %v, want 4", waits, sleeps)
+	}
+}
+
+func TestDirSink(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "profiles")
+	s := DirSink(dir, 2)
+	start := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+	for i, typ := range []string{"cpu", "heap", "goroutine"} {
+		p := &Profile{Type: typ, Start: start.Add(time.Duration(i) * time.Minute), Data: []byte(typ)}
+		if err := s.WriteProfile(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, de := range des {
+		got = append(got, de.Name())
+	}
+	want := []string{
+		"20230102T150505.000000000Z-heap.pb.gz",
+		"20230102T150605.000000000Z-goroutine.pb.gz",
+	}
+	if !testutil.Equal(got, want) {
+		t.Fatalf("got files %v, want %v", got, want)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, want[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "goroutine" {
+		t.Errorf("got %q, want %q", data, "goroutine")
+	}
+}
+
+type nopCloser struct{ *bytes.Buffer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	var gotType string
+	s := WriterSink(func(p *Profile) (io.WriteCloser, error) {
+		gotType = p.Type
+		return nopCloser{&buf}, nil
+	})
+	if err := s.WriteProfile(context.Background(), &Profile{Type: "mutex", Data: []byte("data")}); err != nil {
+		t.Fatal(err)
+	}
+	if gotType != "mutex" || buf.String() != "data" {
+		t.Errorf("got type %q and data %q, want %q and %q", gotType, buf.String(), "mutex", "data")
+	}
+}
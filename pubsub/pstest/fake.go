@@ -486,6 +486,10 @@ func (s *GServer) CreateSubscription(_ context.Context, ps *pb.Subscription) (*p
 		ps.BigqueryConfig.State = pb.BigQueryConfig_ACTIVE
 	}
 	ps.TopicMessageRetentionDuration = top.proto.MessageRetentionDuration
+	filter, err := parseFilter(ps.Filter)
+	if err != nil {
+		return nil, err
+	}
 	var deadLetterTopic *topic
 	if ps.DeadLetterPolicy != nil {
 		dlTopic, ok := s.topics[ps.DeadLetterPolicy.DeadLetterTopic]
@@ -496,6 +500,7 @@ func (s *GServer) CreateSubscription(_ context.Context, ps *pb.Subscription) (*p
 	}
 
 	sub := newSubscription(top, &s.mu, s.timeNowFunc, deadLetterTopic, ps)
+	sub.filter = filter
 	top.subs[ps.Name] = sub
 	s.subs[ps.Name] = sub
 	sub.start(&s.wg)
@@ -627,7 +632,12 @@ func (s *GServer) UpdateSubscription(_ context.Context, req *pb.UpdateSubscripti
 			sub.proto.RetryPolicy = req.Subscription.RetryPolicy
 
 		case "filter":
+			filter, err := parseFilter(req.Subscription.Filter)
+			if err != nil {
+				return nil, err
+			}
 			sub.proto.Filter = req.Subscription.Filter
+			sub.filter = filter
 
 		case "enable_exactly_once_delivery":
 			sub.proto.EnableExactlyOnceDelivery = req.Subscription.EnableExactlyOnceDelivery
@@ -773,6 +783,9 @@ func (t *topic) deleteSub(sub *subscription) {
 
 func (t *topic) publish(pm *pb.PubsubMessage, m *Message) {
 	for _, s := range t.subs {
+		if !s.matches(m) {
+			continue
+		}
 		s.msgs[pm.MessageId] = &message{
 			publishTime: m.PublishTime,
 			proto: &pb.ReceivedMessage{
@@ -796,6 +809,19 @@ type subscription struct {
 	streams         []*stream
 	done            chan struct{}
 	timeNowFunc     func() time.Time
+	filter          messageFilter   // nil if the subscription has no filter
+	wg              *sync.WaitGroup // the server's, for tracking push requests
+}
+
+// matches reports whether m passes the subscription's filter. Like the
+// service, it acknowledges messages that don't, so they are never delivered.
+// Must be called with the lock held.
+func (s *subscription) matches(m *Message) bool {
+	if s.filter == nil || s.filter(m.Attributes) {
+		return true
+	}
+	m.acks++
+	return false
 }
 
 func newSubscription(t *topic, mu *sync.Mutex, timeNowFunc func() time.Time, deadLetterTopic *topic, ps *pb.Subscription) *subscription {
@@ -817,6 +843,7 @@ func newSubscription(t *topic, mu *sync.Mutex, timeNowFunc func() time.Time, dea
 }
 
 func (s *subscription) start(wg *sync.WaitGroup) {
+	s.wg = wg
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -975,7 +1002,8 @@ func (s *GServer) Seek(ctx context.Context, req *pb.SeekRequest) (*pb.SeekRespon
 	// Un-ack any already-acked messages after this time;
 	// redelivering them to the subscription is the closest analogue here.
 	for _, m := range s.msgs {
-		if m.PublishTime.Before(target) {
+		// Messages that don't match the filter were acknowledged when they were published.
+		if m.PublishTime.Before(target) || (sub.filter != nil && !sub.filter(m.Attributes)) {
 			continue
 		}
 		sub.msgs[m.ID] = &message{
@@ -1076,6 +1104,10 @@ func (s *subscription) deliver() {
 			s.publishToDeadLetter(m)
 			continue
 		}
+		if isPush(s.proto) {
+			s.push(m, now)
+			continue
+		}
 		// If the message was never delivered before, start with the stream at
 		// curIndex. If it was delivered before, start with the stream after the one
 		// that owned it.
@@ -1212,6 +1244,7 @@ type message struct {
 	deliveries  *int
 	acks        *int
 	streamIndex int // index of stream that currently owns msg, for round-robin delivery
+	pushes      int // number of times the message was sent to the push endpoint
 }
 
 // A message is outstanding if it is owned by some stream.
@@ -1054,14 +1054,14 @@ func TestUpdateFilter(t *testing.T) {
 		AckDeadlineSeconds: minAckDeadlineSecs,
 		Name:               "projects/P/subscriptions/S",
 		Topic:              top.Name,
-		Filter:             "some-filter",
+		Filter:             `attributes.some = "filter"`,
 	})
 
 	update := &pb.Subscription{
 		AckDeadlineSeconds: sub.AckDeadlineSeconds,
 		Name:               sub.Name,
 		Topic:              top.Name,
-		Filter:             "new-filter",
+		Filter:             `attributes:new`,
 	}
 
 	updated := mustUpdateSubscription(ctx, t, sclient, &pb.UpdateSubscriptionRequest{
@@ -1074,6 +1074,97 @@ func TestUpdateFilter(t *testing.T) {
 	}
 }
 
+func TestSubscriptionFilter(t *testing.T) {
+	ctx := context.Background()
+	pclient, sclient, srv, cleanup := newFake(ctx, t)
+	defer cleanup()
+
+	top := mustCreateTopic(ctx, t, pclient, &pb.Topic{Name: "projects/P/topics/T"})
+	_, err := sclient.CreateSubscription(ctx, &pb.Subscription{
+		Name:               "projects/P/subscriptions/bad",
+		Topic:              top.Name,
+		AckDeadlineSeconds: 10,
+		Filter:             `attributes.lang = en`,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateSubscription with invalid filter: got %v, want InvalidArgument", err)
+	}
+	sub := mustCreateSubscription(ctx, t, sclient, &pb.Subscription{
+		Name:               "projects/P/subscriptions/S",
+		Topic:              top.Name,
+		AckDeadlineSeconds: 10,
+		Filter:             `attributes.lang = "en" AND NOT attributes:skip`,
+	})
+	all := mustCreateSubscription(ctx, t, sclient, &pb.Subscription{
+		Name:               "projects/P/subscriptions/all",
+		Topic:              top.Name,
+		AckDeadlineSeconds: 10,
+	})
+
+	msgs := publish(t, pclient, top, []*pb.PubsubMessage{
+		{Data: []byte("d1"), Attributes: map[string]string{"lang": "en"}},
+		{Data: []byte("d2"), Attributes: map[string]string{"lang": "fr"}},
+		{Data: []byte("d3")},
+		{Data: []byte("d4"), Attributes: map[string]string{"lang": "en", "skip": ""}},
+		{Data: []byte("d5"), Attributes: map[string]string{"lang": "en"}},
+	})
+	want := map[string]*pb.PubsubMessage{}
+	for id, m := range msgs {
+		if d := string(m.Data); d == "d1" || d == "d5" {
+			want[id] = m
+		}
+	}
+	got := pubsubMessages(pullN(ctx, t, len(want), sclient, sub))
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Error(diff)
+	}
+	res, err := sclient.Pull(ctx, &pb.PullRequest{Subscription: sub.Name, ReturnImmediately: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.ReceivedMessages) != 0 {
+		t.Errorf("got %d more messages, want zero", len(res.ReceivedMessages))
+	}
+	// The subscription without a filter gets everything.
+	if got := pullN(ctx, t, len(msgs), sclient, all); len(got) != len(msgs) {
+		t.Errorf("unfiltered subscription got %d messages, want %d", len(got), len(msgs))
+	}
+
+	// Messages that don't match are acknowledged by the server.
+	for id, m := range msgs {
+		wantAcks := 0
+		if _, ok := want[id]; !ok {
+			wantAcks = 1
+		}
+		if got := srv.Message(id).Acks; got != wantAcks {
+			t.Errorf("message %s got %d acks, want %d", m.Data, got, wantAcks)
+		}
+	}
+
+	// A new filter applies to messages published after the update.
+	mustUpdateSubscription(ctx, t, sclient, &pb.UpdateSubscriptionRequest{
+		Subscription: &pb.Subscription{Name: sub.Name, Filter: `hasPrefix(attributes.lang, "f")`},
+		UpdateMask:   &field_mask.FieldMask{Paths: []string{"filter"}},
+	})
+	msgs = publish(t, pclient, top, []*pb.PubsubMessage{
+		{Data: []byte("d6"), Attributes: map[string]string{"lang": "en"}},
+		{Data: []byte("d7"), Attributes: map[string]string{"lang": "fr"}},
+	})
+	got = pubsubMessages(pullN(ctx, t, 1, sclient, sub))
+	for _, m := range got {
+		if string(m.Data) != "d7" {
+			t.Errorf("after update got message %s, want d7", m.Data)
+		}
+	}
+	_, err = sclient.UpdateSubscription(ctx, &pb.UpdateSubscriptionRequest{
+		Subscription: &pb.Subscription{Name: sub.Name, Filter: `hasPrefix(`},
+		UpdateMask:   &field_mask.FieldMask{Paths: []string{"filter"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("UpdateSubscription with invalid filter: got %v, want InvalidArgument", err)
+	}
+}
+
 func TestUpdateEnableExactlyOnceDelivery(t *testing.T) {
 	ctx := context.Background()
 	pclient, sclient, _, cleanup := newFake(ctx, t)
@@ -0,0 +1,291 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pstest
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxFilterLength is the longest subscription filter the service accepts, in bytes.
+const maxFilterLength = 256
+
+// A messageFilter reports whether a message with the given attributes
+// matches a subscription filter.
+type messageFilter func(attrs map[string]string) bool
+
+// parseFilter parses a subscription filter, as described at
+// https://cloud.google.com/pubsub/docs/subscription-message-filter.
+// An empty filter matches every message, and is returned as nil.
+//
+// The grammar is:
+//
+//	filter  = expr
+//	expr    = term { ("AND" | "OR") term }   // AND and OR can't be mixed without parentheses
+//	term    = [ "NOT" | "-" ] primary
+//	primary = "(" expr ")"
+//	        | "attributes:" key
+//	        | "attributes." key ( "=" | "!=" ) string
+//	        | "hasPrefix(" "attributes." key "," string ")"
+//	key     = identifier | string
+func parseFilter(filter string) (messageFilter, error) {
+	if filter == "" {
+		return nil, nil
+	}
+	if len(filter) > maxFilterLength {
+		return nil, status.Errorf(codes.InvalidArgument, "filter is %d bytes long, more than the maximum of %d", len(filter), maxFilterLength)
+	}
+	p := &filterParser{s: filter}
+	f, err := p.parseExpr()
+	if err == nil && !p.atEnd() {
+		err = p.errorf("unexpected %q", p.s[p.pos:])
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid filter %q: %v", filter, err)
+	}
+	return f, nil
+}
+
+type filterParser struct {
+	s   string
+	pos int
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *filterParser) skipSpace() {
+	for p.pos < len(p.s) && strings.ContainsRune(" \t\r\n", rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *filterParser) atEnd() bool {
+	p.skipSpace()
+	return p.pos >= len(p.s)
+}
+
+// consume skips tok, and reports whether it was there.
+func (p *filterParser) consume(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.s[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(tok string) error {
+	if !p.consume(tok) {
+		return p.errorf("expected %q", tok)
+	}
+	return nil
+}
+
+func isIdentByte(c byte, first bool) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '_':
+		return true
+	case c == '-':
+		return !first
+	}
+	return false
+}
+
+// peekIdent returns the identifier at the current position, without consuming it.
+func (p *filterParser) peekIdent() string {
+	p.skipSpace()
+	end := p.pos
+	for end < len(p.s) && isIdentByte(p.s[end], end == p.pos) {
+		end++
+	}
+	return p.s[p.pos:end]
+}
+
+// consumeKeyword consumes the keyword kw, which must not be followed by
+// further identifier characters.
+func (p *filterParser) consumeKeyword(kw string) bool {
+	if p.peekIdent() == kw {
+		p.pos += len(kw)
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseExpr() (messageFilter, error) {
+	f, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	op := ""
+	for {
+		var next string
+		switch {
+		case p.consumeKeyword("AND"):
+			next = "AND"
+		case p.consumeKeyword("OR"):
+			next = "OR"
+		default:
+			return f, nil
+		}
+		if op != "" && op != next {
+			return nil, p.errorf("AND and OR must be separated by parentheses")
+		}
+		op = next
+		g, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left := f
+		if op == "AND" {
+			f = func(attrs map[string]string) bool { return left(attrs) && g(attrs) }
+		} else {
+			f = func(attrs map[string]string) bool { return left(attrs) || g(attrs) }
+		}
+	}
+}
+
+func (p *filterParser) parseTerm() (messageFilter, error) {
+	if p.consumeKeyword("NOT") || p.consume("-") {
+		f, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return func(attrs map[string]string) bool { return !f(attrs) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (messageFilter, error) {
+	if p.consume("(") {
+		f, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	if p.consumeKeyword("hasPrefix") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		if !p.consumeKeyword("attributes") {
+			return nil, p.errorf("expected attributes")
+		}
+		if err := p.expect("."); err != nil {
+			return nil, err
+		}
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		prefix, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return func(attrs map[string]string) bool {
+			v, ok := attrs[key]
+			return ok && strings.HasPrefix(v, prefix)
+		}, nil
+	}
+	if !p.consumeKeyword("attributes") {
+		return nil, p.errorf("expected attributes, hasPrefix, NOT or (")
+	}
+	if p.consume(":") {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		return func(attrs map[string]string) bool {
+			_, ok := attrs[key]
+			return ok
+		}, nil
+	}
+	if err := p.expect("."); err != nil {
+		return nil, err
+	}
+	key, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+	equal := true
+	switch {
+	case p.consume("!="):
+		equal = false
+	case p.consume("="):
+	default:
+		return nil, p.errorf("expected = or !=")
+	}
+	val, err := p.parseString()
+	if err != nil {
+		return nil, err
+	}
+	return func(attrs map[string]string) bool {
+		// Neither = nor != matches a message without the attribute.
+		v, ok := attrs[key]
+		return ok && (v == val) == equal
+	}, nil
+}
+
+func (p *filterParser) parseKey() (string, error) {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		return p.parseString()
+	}
+	id := p.peekIdent()
+	if id == "" {
+		return "", p.errorf("expected attribute key")
+	}
+	p.pos += len(id)
+	return id, nil
+}
+
+// parseString parses a double-quoted string literal. Backslash escapes the
+// following character.
+func (p *filterParser) parseString() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) || p.s[p.pos] != '"' {
+		return "", p.errorf("expected string")
+	}
+	var b strings.Builder
+	for i := p.pos + 1; i < len(p.s); i++ {
+		switch c := p.s[i]; c {
+		case '"':
+			p.pos = i + 1
+			return b.String(), nil
+		case '\\':
+			i++
+			if i == len(p.s) {
+				break
+			}
+			b.WriteByte(p.s[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pstest
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseFilter(t *testing.T) {
+	attrs := map[string]string{
+		"lang":     "en-US",
+		"priority": "high",
+		"empty":    "",
+		"a.b":      `quoted "value"`,
+	}
+	for _, test := range []struct {
+		filter string
+		want   bool
+	}{
+		{`attributes:lang`, true},
+		{`attributes:missing`, false},
+		{`attributes:empty`, true},
+		{`attributes.lang = "en-US"`, true},
+		{`attributes.lang="en-GB"`, false},
+		{`attributes.lang != "en-GB"`, true},
+		{`attributes.missing != "x"`, false},
+		{`attributes.missing = ""`, false},
+		{`attributes.empty = ""`, true},
+		{`attributes."a.b" = "quoted \"value\""`, true},
+		{`hasPrefix(attributes.lang, "en")`, true},
+		{`hasPrefix(attributes.lang, "fr")`, false},
+		{`hasPrefix(attributes.missing, "")`, false},
+		{`NOT attributes:lang`, false},
+		{`NOT attributes:missing`, true},
+		{`-attributes:missing`, true},
+		{`NOT hasPrefix(attributes.lang, "fr")`, true},
+		{`attributes:lang AND attributes.priority = "high"`, true},
+		{`attributes:lang AND attributes.priority = "low"`, false},
+		{`attributes:missing OR attributes.priority = "high"`, true},
+		{`attributes:missing OR attributes.priority = "low" OR attributes:other`, false},
+		{`(attributes:missing OR attributes:lang) AND NOT attributes.priority = "low"`, true},
+		{`NOT (attributes:lang AND attributes:missing)`, true},
+		{"attributes:lang\n\tAND attributes:priority", true},
+	} {
+		f, err := parseFilter(test.filter)
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", test.filter, err)
+			continue
+		}
+		if got := f(attrs); got != test.want {
+			t.Errorf("filter %q matched %t, want %t", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, filter := range []string{
+		`some-filter`,
+		`attributes`,
+		`attributes.`,
+		`attributes.lang`,
+		`attributes.lang = en`,
+		`attributes.lang = "en`,
+		`attributes.lang == "en"`,
+		`attributes:lang AND`,
+		`attributes:a AND attributes:b OR attributes:c`,
+		`(attributes:a`,
+		`attributes:a)`,
+		`hasPrefix(attributes.lang)`,
+		`hasPrefix(lang, "en")`,
+		`attributes:a and attributes:b`,
+		`ANDattributes:a`,
+		`attributes:` + strings.Repeat("x", maxFilterLength),
+	} {
+		if _, err := parseFilter(filter); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("parseFilter(%q): got %v, want InvalidArgument", filter, err)
+		}
+	}
+	if f, err := parseFilter(""); f != nil || err != nil {
+		t.Errorf(`parseFilter(""): got %v, %v, want nil, nil`, f, err)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pstest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	pb "cloud.google.com/go/pubsub/apiv1/pubsubpb"
+)
+
+const (
+	// Backoff between push attempts for a subscription without a retry policy.
+	defaultPushMinBackoff = 100 * time.Millisecond
+	defaultPushMaxBackoff = 60 * time.Second
+
+	// Backoff between push attempts when a retry policy leaves out a bound.
+	defaultRetryMinBackoff = 10 * time.Second
+	defaultRetryMaxBackoff = 600 * time.Second
+)
+
+// pushRequest is the JSON body the service POSTs to a push endpoint.
+type pushRequest struct {
+	Message         pushMessage `json:"message"`
+	Subscription    string      `json:"subscription"`
+	DeliveryAttempt int32       `json:"deliveryAttempt,omitempty"`
+}
+
+// pushMessage is a message in a push request. Like the service, it gives
+// the ID and publish time in both camel and snake case.
+type pushMessage struct {
+	Attributes       map[string]string `json:"attributes,omitempty"`
+	Data             []byte            `json:"data,omitempty"`
+	MessageID        string            `json:"messageId"`
+	MessageIDSnake   string            `json:"message_id"`
+	PublishTime      string            `json:"publishTime"`
+	PublishTimeSnake string            `json:"publish_time"`
+	OrderingKey      string            `json:"orderingKey,omitempty"`
+}
+
+// push sends m to the subscription's push endpoint. The message is
+// outstanding until the endpoint responds.
+//
+// Must be called with the lock held.
+func (s *subscription) push(m *message, now time.Time) {
+	(*m.deliveries)++
+	m.pushes++
+	if s.proto.DeadLetterPolicy != nil {
+		m.proto.DeliveryAttempt = int32(*m.deliveries)
+	}
+	m.ackDeadline = now.Add(s.ackTimeout)
+
+	pm := m.proto.Message
+	publishTime := pm.PublishTime.AsTime().Format(time.RFC3339Nano)
+	body, err := json.Marshal(&pushRequest{
+		Message: pushMessage{
+			Attributes:       pm.Attributes,
+			Data:             pm.Data,
+			MessageID:        pm.MessageId,
+			MessageIDSnake:   pm.MessageId,
+			PublishTime:      publishTime,
+			PublishTimeSnake: publishTime,
+			OrderingKey:      pm.OrderingKey,
+		},
+		Subscription:    s.proto.Name,
+		DeliveryAttempt: m.proto.DeliveryAttempt,
+	})
+	if err != nil {
+		// Can't happen: everything in the request can be marshaled.
+		panic(err)
+	}
+	endpoint := s.proto.PushConfig.PushEndpoint
+	timeout := s.ackTimeout
+	ackID := m.proto.AckId
+	attempt := m.pushes
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ok := s.postPush(endpoint, body, timeout)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.pushDone(ackID, attempt, ok)
+	}()
+}
+
+// postPush POSTs body to endpoint, and reports whether the endpoint
+// acknowledged it. The request is abandoned if the subscription is deleted,
+// or if the endpoint takes longer than timeout.
+func (s *subscription) postPush(endpoint string, body []byte, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	return res.StatusCode >= 200 && res.StatusCode < 300
+}
+
+// pushDone records the result of a push attempt. A success acks the
+// message; a failure makes it available for redelivery after a backoff.
+//
+// Must be called with the lock held.
+func (s *subscription) pushDone(ackID string, attempt int, ok bool) {
+	if ok {
+		s.ack(ackID)
+		return
+	}
+	m := s.msgs[ackID]
+	if m == nil || m.pushes != attempt {
+		// Already acked, or pushed again since.
+		return
+	}
+	m.ackDeadline = s.timeNowFunc().Add(s.pushBackoff(attempt))
+}
+
+// pushBackoff returns how long to wait after the given number of failed
+// push attempts before trying again. It grows exponentially between the
+// bounds of the subscription's retry policy.
+//
+// Must be called with the lock held.
+func (s *subscription) pushBackoff(attempts int) time.Duration {
+	min, max := defaultPushMinBackoff, defaultPushMaxBackoff
+	if rp := s.proto.RetryPolicy; rp != nil {
+		min, max = defaultRetryMinBackoff, defaultRetryMaxBackoff
+		if rp.MinimumBackoff != nil {
+			min = rp.MinimumBackoff.AsDuration()
+		}
+		if rp.MaximumBackoff != nil {
+			max = rp.MaximumBackoff.AsDuration()
+		}
+	}
+	d := min
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// isPush reports whether messages are pushed to subscribers, rather than pulled.
+func isPush(ps *pb.Subscription) bool {
+	return ps.GetPushConfig().GetPushEndpoint() != ""
+}
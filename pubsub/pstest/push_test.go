@@ -0,0 +1,205 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pstest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/internal/testutil"
+	pb "cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestPushDelivery(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		mu   sync.Mutex
+		reqs []pushRequest
+	)
+	const failures = 2
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pr pushRequest
+		if err := json.NewDecoder(r.Body).Decode(&pr); err != nil {
+			t.Errorf("decoding push request: %v", err)
+		}
+		mu.Lock()
+		reqs = append(reqs, pr)
+		n := len(reqs)
+		mu.Unlock()
+		if n <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	pclient, sclient, srv, cleanup := newFake(ctx, t)
+	defer cleanup()
+	top := mustCreateTopic(ctx, t, pclient, &pb.Topic{Name: "projects/P/topics/T"})
+	sub := mustCreateSubscription(ctx, t, sclient, &pb.Subscription{
+		Name:               "projects/P/subscriptions/S",
+		Topic:              top.Name,
+		AckDeadlineSeconds: 10,
+		PushConfig:         &pb.PushConfig{PushEndpoint: ts.URL},
+		RetryPolicy: &pb.RetryPolicy{
+			MinimumBackoff: durationpb.New(10 * time.Millisecond),
+			MaximumBackoff: durationpb.New(20 * time.Millisecond),
+		},
+	})
+	want := publish(t, pclient, top, []*pb.PubsubMessage{
+		{Data: []byte("hello"), Attributes: map[string]string{"k": "v"}},
+	})
+	var id string
+	var pm *pb.PubsubMessage
+	for k, v := range want {
+		id, pm = k, v
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for srv.Message(id).Acks == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("message was not acknowledged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give the server a chance to push again, which it shouldn't.
+	time.Sleep(100 * time.Millisecond)
+
+	m := srv.Message(id)
+	if m.Acks != 1 || m.Deliveries != failures+1 {
+		t.Errorf("got %d acks and %d deliveries, want 1 and %d", m.Acks, m.Deliveries, failures+1)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reqs) != failures+1 {
+		t.Fatalf("got %d push requests, want %d", len(reqs), failures+1)
+	}
+	publishTime := pm.PublishTime.AsTime().Format(time.RFC3339Nano)
+	wantReq := pushRequest{
+		Message: pushMessage{
+			Attributes:       map[string]string{"k": "v"},
+			Data:             []byte("hello"),
+			MessageID:        id,
+			MessageIDSnake:   id,
+			PublishTime:      publishTime,
+			PublishTimeSnake: publishTime,
+		},
+		Subscription: sub.Name,
+	}
+	for i, got := range reqs {
+		if diff := testutil.Diff(got, wantReq); diff != "" {
+			t.Errorf("push request %d mismatch (-got +want):\n%s", i, diff)
+		}
+	}
+}
+
+func TestPushDeadLetter(t *testing.T) {
+	ctx := context.Background()
+
+	attempts := make(chan int32, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pr pushRequest
+		if err := json.NewDecoder(r.Body).Decode(&pr); err != nil {
+			t.Errorf("decoding push request: %v", err)
+		}
+		attempts <- pr.DeliveryAttempt
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	pclient, sclient, srv, cleanup := newFake(ctx, t)
+	defer cleanup()
+	top := mustCreateTopic(ctx, t, pclient, &pb.Topic{Name: "projects/P/topics/T"})
+	dlTopic := mustCreateTopic(ctx, t, pclient, &pb.Topic{Name: "projects/P/topics/DL"})
+	dlSub := mustCreateSubscription(ctx, t, sclient, &pb.Subscription{
+		Name:               "projects/P/subscriptions/DL",
+		Topic:              dlTopic.Name,
+		AckDeadlineSeconds: 10,
+	})
+	mustCreateSubscription(ctx, t, sclient, &pb.Subscription{
+		Name:               "projects/P/subscriptions/S",
+		Topic:              top.Name,
+		AckDeadlineSeconds: 10,
+		PushConfig:         &pb.PushConfig{PushEndpoint: ts.URL},
+		RetryPolicy: &pb.RetryPolicy{
+			MinimumBackoff: durationpb.New(0),
+			MaximumBackoff: durationpb.New(0),
+		},
+		DeadLetterPolicy: &pb.DeadLetterPolicy{
+			DeadLetterTopic:     dlTopic.Name,
+			MaxDeliveryAttempts: 3,
+		},
+	})
+	srv.Publish(top.Name, []byte("doomed"), nil)
+
+	got := pullN(ctx, t, 1, sclient, dlSub)
+	for _, m := range got {
+		if string(m.Message.Data) != "doomed" {
+			t.Errorf("dead-lettered message data = %q, want doomed", m.Message.Data)
+		}
+	}
+	for want := int32(1); want <= 3; want++ {
+		if got := <-attempts; got != want {
+			t.Errorf("push deliveryAttempt = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestPushBackoff(t *testing.T) {
+	s := &subscription{proto: &pb.Subscription{}}
+	for _, test := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, defaultPushMinBackoff},
+		{2, 2 * defaultPushMinBackoff},
+		{100, defaultPushMaxBackoff},
+	} {
+		if got := s.pushBackoff(test.attempts); got != test.want {
+			t.Errorf("no retry policy: pushBackoff(%d) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+
+	s.proto.RetryPolicy = &pb.RetryPolicy{}
+	if got := s.pushBackoff(1); got != defaultRetryMinBackoff {
+		t.Errorf("empty retry policy: pushBackoff(1) = %v, want %v", got, defaultRetryMinBackoff)
+	}
+	s.proto.RetryPolicy = &pb.RetryPolicy{
+		MinimumBackoff: durationpb.New(time.Second),
+		MaximumBackoff: durationpb.New(5 * time.Second),
+	}
+	for _, test := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{50, 5 * time.Second},
+	} {
+		if got := s.pushBackoff(test.attempts); got != test.want {
+			t.Errorf("pushBackoff(%d) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
@@ -19,12 +19,14 @@ package spannertest
 // This file contains the implementation of the Spanner fake itself,
 // namely the part behind the RPC interface.
 
-// TODO: missing transactionality in a serious way!
-
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math/big"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
@@ -47,7 +49,19 @@ type database struct {
 	indexes map[spansql.ID]struct{} // only record their existence
 	views   map[spansql.ID]struct{} // only record their existence
 
-	rwMu sync.Mutex // held by read-write transactions
+	rwMu sync.Mutex // held by read-write transactions while committing
+
+	// These fields support detecting conflicts between read-write transactions.
+	// They are protected by mu.
+	commitSeq int64                 // number of read-write transactions committed
+	commits   []commitRecord        // recent commits that live transactions may conflict with
+	live      map[*transaction]bool // read-write transactions not yet committed or rolled back
+}
+
+// commitRecord records the rows written by a committed read-write transaction.
+type commitRecord struct {
+	seq    int64
+	writes map[spansql.ID][][]interface{} // primary keys, by table
 }
 
 type table struct {
@@ -88,7 +102,8 @@ type constraintInfo struct {
 var commitTimestampSentinel = &struct{}{}
 
 // transaction records information about a running transaction.
-// This is not safe for concurrent use.
+// The data written by a read-write transaction is kept in private copies
+// of the affected tables until it commits.
 type transaction struct {
 	// readOnly is whether this transaction was constructed
 	// for read-only use, and should yield errors if used
@@ -98,6 +113,27 @@ type transaction struct {
 	d               *database
 	commitTimestamp time.Time // not set if readOnly
 	unlock          func()    // may be nil
+
+	mu       sync.Mutex
+	tables   map[spansql.ID]*txTable // private copies of tables written by this transaction
+	reads    map[spansql.ID]*readSet // what this transaction has read
+	started  bool                    // whether this transaction has read or written anything
+	startSeq int64                   // value of d.commitSeq when this transaction first read or wrote
+}
+
+// readSet records what a transaction has read from a table.
+type readSet struct {
+	all  bool            // whether the whole table was read
+	keys [][]interface{} // primary keys of individual rows read
+}
+
+// txTable is a transaction's private copy of a table.
+// Reads and writes within the transaction use t;
+// base and orig are used to merge the changes when the transaction commits.
+type txTable struct {
+	t    *table
+	base *table // the database's table that t was copied from
+	orig []row  // base.rows at the time of the copy
 }
 
 func (d *database) NewReadOnlyTransaction() *transaction {
@@ -107,14 +143,23 @@ func (d *database) NewReadOnlyTransaction() *transaction {
 }
 
 func (d *database) NewTransaction() *transaction {
-	return &transaction{
+	tx := &transaction{
 		d: d,
 	}
+
+	d.mu.Lock()
+	if d.live == nil {
+		d.live = make(map[*transaction]bool)
+	}
+	d.live[tx] = true
+	d.mu.Unlock()
+
+	return tx
 }
 
 // Start starts the transaction and commits to a specific commit timestamp.
 // This also locks out any other read-write transaction on this database
-// until Commit/Rollback are called.
+// from committing until Commit/Rollback are called.
 func (tx *transaction) Start() {
 	// Commit timestamps are only guaranteed to be unique
 	// when transactions write to overlapping sets of fields.
@@ -146,18 +191,78 @@ func (tx *transaction) checkMutable() error {
 	return nil
 }
 
-func (tx *transaction) Commit() (time.Time, error) {
+// recordRead records that the transaction read the rows with the given primary keys,
+// or the whole table if no keys are given.
+func (tx *transaction) recordRead(tbl spansql.ID, pks ...[]interface{}) {
+	if tx == nil || tx.readOnly {
+		return
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.reads == nil {
+		tx.reads = make(map[spansql.ID]*readSet)
+	}
+	rs, ok := tx.reads[tbl]
+	if !ok {
+		rs = &readSet{}
+		tx.reads[tbl] = rs
+	}
+	tx.markStarted()
+	if len(pks) == 0 {
+		rs.all = true
+		return
+	}
+	rs.keys = append(rs.keys, pks...)
+}
+
+// markStarted records the point from which other transactions' commits may conflict with this one.
+// Transactions are often begun well before they are used, so this happens on the first read or write.
+// The caller must hold tx.mu.
+func (tx *transaction) markStarted() {
+	if tx.started {
+		return
+	}
+	tx.d.mu.Lock()
+	tx.started = true
+	tx.startSeq = tx.d.commitSeq
+	tx.d.mu.Unlock()
+}
+
+// end releases any resources held by the transaction.
+// It is safe to call more than once.
+func (tx *transaction) end() {
 	if tx.unlock != nil {
 		tx.unlock()
+		tx.unlock = nil
+	}
+	tx.mu.Lock()
+	tx.tables = nil
+	tx.reads = nil
+	tx.mu.Unlock()
+
+	if tx.d != nil {
+		tx.d.mu.Lock()
+		delete(tx.d.live, tx)
+		tx.d.pruneCommits()
+		tx.d.mu.Unlock()
+	}
+}
+
+func (tx *transaction) Commit() (time.Time, error) {
+	defer tx.end()
+	if !tx.readOnly {
+		if err := tx.d.mergeTables(tx); err != nil {
+			return time.Time{}, err
+		}
 	}
 	return tx.commitTimestamp, nil
 }
 
 func (tx *transaction) Rollback() {
-	if tx.unlock != nil {
-		tx.unlock()
-	}
-	// TODO: actually rollback
+	// Dropping the private table copies is all that's needed.
+	tx.end()
 }
 
 /*
@@ -172,8 +277,10 @@ The mapping between Spanner types and Go types internal to this package are:
 	BYTES		[]byte
 	DATE		civil.Date
 	TIMESTAMP	time.Time (location set to UTC)
+	NUMERIC		*big.Rat (never modified once created)
+	JSON		jsonValue
 	ARRAY<T>	[]interface{}
-	STRUCT		TODO
+	STRUCT		[]interface{} (only in query results)
 */
 type row []interface{}
 
@@ -416,13 +523,387 @@ func (d *database) table(tbl spansql.ID) (*table, error) {
 	return t, nil
 }
 
-// writeValues executes a write option (Insert, Update, etc.).
-func (d *database) writeValues(tx *transaction, tbl spansql.ID, cols []spansql.ID, values []*structpb.ListValue, f func(t *table, colIndexes []int, r row) error) error {
+// tableForTx returns the named table as seen by tx.
+// If tx has written to the table, this is its private copy.
+// tx may be nil.
+func (d *database) tableForTx(tx *transaction, tbl spansql.ID) (*table, error) {
+	if tx != nil {
+		tx.mu.Lock()
+		tt, ok := tx.tables[tbl]
+		tx.mu.Unlock()
+		if ok {
+			return tt.t, nil
+		}
+	}
+	return d.table(tbl)
+}
+
+// writableTable returns tx's private copy of the named table,
+// making the copy if this is the first write to that table.
+func (d *database) writableTable(tx *transaction, tbl spansql.ID) (*table, error) {
 	if err := tx.checkMutable(); err != nil {
-		return err
+		return nil, err
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tt, ok := tx.tables[tbl]; ok {
+		return tt.t, nil
 	}
+	tx.markStarted()
 
 	t, err := d.table(tbl)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	tt := &txTable{
+		t:    t.clone(),
+		base: t,
+		orig: append([]row(nil), t.rows...),
+	}
+	t.mu.Unlock()
+
+	if tx.tables == nil {
+		tx.tables = make(map[spansql.ID]*txTable)
+	}
+	tx.tables[tbl] = tt
+	return tt.t, nil
+}
+
+// mergeTables applies the writes held in the transaction's private table copies
+// to the database's tables. Either all of the writes are applied, or none are.
+//
+// Transactions are optimistic: if another transaction has committed a write
+// to a row that this transaction read or wrote since this transaction began,
+// this transaction loses and an Aborted error is returned.
+//
+// FOREIGN KEY and CHECK constraints are enforced on the result.
+func (d *database) mergeTables(tx *transaction) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	// d.mu is held throughout so the schema can't change under us.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var names []spansql.ID
+	for name := range tx.tables {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+
+	written := make(map[spansql.ID]bool)
+	for _, name := range names {
+		t, ok := d.tables[name]
+		if !ok || t != tx.tables[name].base {
+			return status.Errorf(codes.Aborted, "table %s was dropped or recreated during the transaction", name)
+		}
+		written[name] = true
+	}
+
+	// Constraint checks may need to look at other tables:
+	// the tables referenced by foreign keys of the written tables,
+	// and the tables with foreign keys referencing the written tables.
+	// Take all the locks in name order.
+	locked := make(map[spansql.ID]bool)
+	for name, t := range d.tables {
+		if written[name] {
+			locked[name] = true
+		}
+		for _, fk := range t.foreignKeys() {
+			if written[name] || written[fk.RefTable] {
+				locked[name] = true
+				if _, ok := d.tables[fk.RefTable]; ok {
+					locked[fk.RefTable] = true
+				}
+			}
+		}
+	}
+	var lockNames []spansql.ID
+	for name := range locked {
+		lockNames = append(lockNames, name)
+	}
+	sort.Slice(lockNames, func(i, j int) bool { return lockNames[i] < lockNames[j] })
+	for _, name := range lockNames {
+		t := d.tables[name]
+		t.mu.Lock()
+		defer t.mu.Unlock()
+	}
+
+	type tableChanges struct {
+		deleted [][]interface{}
+		written []row
+	}
+	changes := make(map[spansql.ID]tableChanges)
+	writes := make(map[spansql.ID][][]interface{})
+	for _, name := range names {
+		tt, t := tx.tables[name], d.tables[name]
+		if !sameColumns(tt.t.cols, t.cols) {
+			return status.Errorf(codes.Aborted, "schema of table %s changed during the transaction", name)
+		}
+		deleted, written := tt.changes()
+		changes[name] = tableChanges{deleted: deleted, written: written}
+		pks := append([][]interface{}(nil), deleted...)
+		for _, r := range written {
+			pks = append(pks, r[:t.pkCols])
+		}
+		if len(pks) > 0 {
+			writes[name] = pks
+		}
+	}
+
+	if err := d.checkConflicts(tx, writes); err != nil {
+		return err
+	}
+
+	// Apply the changes, keeping the old rows in case a constraint is violated.
+	// Rows are replaced rather than modified, so a shallow copy suffices.
+	oldRows := make(map[spansql.ID][]row)
+	for _, name := range names {
+		t := d.tables[name]
+		oldRows[name] = append([]row(nil), t.rows...)
+		for _, pk := range changes[name].deleted {
+			if rowNum, found := t.rowForPK(pk); found {
+				t.deleteRow(rowNum)
+			}
+		}
+		for _, r := range changes[name].written {
+			rowNum, found := t.rowForPK(r[:t.pkCols])
+			if found {
+				t.rows[rowNum] = r
+			} else {
+				t.insertRow(rowNum, r)
+			}
+		}
+	}
+
+	var err error
+	for _, name := range lockNames {
+		if err = d.checkConstraints(name, changes[name].written, written); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		for name, rows := range oldRows {
+			d.tables[name].rows = rows
+		}
+		return err
+	}
+
+	if len(writes) > 0 {
+		d.commitSeq++
+		d.commits = append(d.commits, commitRecord{
+			seq:    d.commitSeq,
+			writes: writes,
+		})
+	}
+	return nil
+}
+
+// checkConstraints checks the FOREIGN KEY and CHECK constraints of the named table.
+// Rows in rows are checked against all constraints. If the table references
+// a table in changed, all of its rows are checked against that foreign key.
+// The caller must hold d.mu, and the locks of the table and any referenced tables.
+func (d *database) checkConstraints(name spansql.ID, rows []row, changed map[spansql.ID]bool) error {
+	t := d.tables[name]
+	for _, ci := range t.constraints {
+		switch c := ci.Constraint.(type) {
+		case spansql.Check:
+			for _, r := range rows {
+				ec := evalContext{
+					cols: t.cols,
+					row:  r,
+				}
+				b, err := ec.evalBoolExpr(c.Expr)
+				if err != nil {
+					return err
+				}
+				// A NULL result satisfies the constraint.
+				if b != nil && !*b {
+					return status.Errorf(codes.OutOfRange, "Check constraint `%s`.`%s` is violated for key %v", name, ci.Name, r[:t.pkCols])
+				}
+			}
+		case spansql.ForeignKey:
+			toCheck := rows
+			if changed[c.RefTable] {
+				toCheck = t.rows
+			}
+			if len(toCheck) == 0 {
+				continue
+			}
+			if err := d.checkForeignKey(name, ci.Name, c, toCheck); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkForeignKey checks that the given rows of the named table
+// reference existing rows, as required by the foreign key.
+func (d *database) checkForeignKey(name, fkName spansql.ID, fk spansql.ForeignKey, rows []row) error {
+	t := d.tables[name]
+	violation := func() error {
+		return status.Errorf(codes.FailedPrecondition, "Foreign key constraint `%s` is violated on table `%s`. Cannot find referenced values in %s(%s).",
+			fkName, name, fk.RefTable, idListSQL(fk.RefColumns))
+	}
+	ref, ok := d.tables[fk.RefTable]
+	if !ok {
+		return violation()
+	}
+	colIndexes, err := t.colIndexes(fk.Columns)
+	if err != nil {
+		return err
+	}
+	refIndexes, err := ref.colIndexes(fk.RefColumns)
+	if err != nil {
+		return err
+	}
+	if len(colIndexes) != len(refIndexes) {
+		return status.Errorf(codes.FailedPrecondition, "foreign key %s has %d columns but references %d", fkName, len(colIndexes), len(refIndexes))
+	}
+
+rows:
+	for _, r := range rows {
+		vals := r.copyData(colIndexes)
+		for _, v := range vals {
+			// A NULL in any column means the row doesn't reference anything.
+			if v == nil {
+				continue rows
+			}
+		}
+		for _, refRow := range ref.rows {
+			if rowEqual(vals, refRow.copyData(refIndexes)) {
+				continue rows
+			}
+		}
+		return violation()
+	}
+	return nil
+}
+
+// foreignKeys returns the foreign key constraints of the table.
+func (t *table) foreignKeys() []spansql.ForeignKey {
+	var fks []spansql.ForeignKey
+	for _, ci := range t.constraints {
+		if fk, ok := ci.Constraint.(spansql.ForeignKey); ok {
+			fks = append(fks, fk)
+		}
+	}
+	return fks
+}
+
+func idListSQL(ids []spansql.ID) string {
+	var s []string
+	for _, id := range ids {
+		s = append(s, id.SQL())
+	}
+	return strings.Join(s, ", ")
+}
+
+// checkConflicts reports an Aborted error if any transaction that committed
+// after tx began wrote to a row that tx has read, or is about to write.
+// The caller must hold d.mu and tx.mu.
+func (d *database) checkConflicts(tx *transaction, writes map[spansql.ID][][]interface{}) error {
+	for _, cr := range d.commits {
+		if !tx.started || cr.seq <= tx.startSeq {
+			continue
+		}
+		for name, pks := range cr.writes {
+			rs := tx.reads[name]
+			if rs != nil && rs.all {
+				return status.Errorf(codes.Aborted, "transaction was aborted: table %s was modified by another transaction", name)
+			}
+			// Rows this transaction depends on.
+			var mine [][]interface{}
+			if rs != nil {
+				mine = append(mine, rs.keys...)
+			}
+			mine = append(mine, writes[name]...)
+			for _, pk := range pks {
+				for _, key := range mine {
+					if rowEqual(pk, key) {
+						return status.Errorf(codes.Aborted, "transaction was aborted: row %v in table %s was modified by another transaction", pk, name)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// pruneCommits discards commit records that no live transaction could conflict with.
+// The caller must hold d.mu.
+func (d *database) pruneCommits() {
+	minSeq := d.commitSeq
+	for tx := range d.live {
+		if tx.started && tx.startSeq < minSeq {
+			minSeq = tx.startSeq
+		}
+	}
+	i := 0
+	for i < len(d.commits) && d.commits[i].seq <= minSeq {
+		i++
+	}
+	d.commits = d.commits[i:]
+}
+
+// changes reports the primary keys of rows deleted from the private copy,
+// and the rows that were inserted or updated in it.
+func (tt *txTable) changes() (deleted [][]interface{}, written []row) {
+	t := tt.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Both lists of rows are in primary key order, so walk them together.
+	i, j := 0, 0
+	for i < len(tt.orig) || j < len(t.rows) {
+		var cmp int
+		switch {
+		case i == len(tt.orig):
+			cmp = 1
+		case j == len(t.rows):
+			cmp = -1
+		default:
+			cmp = rowCmp(tt.orig[i][:t.pkCols], t.rows[j][:t.pkCols], t.pkDesc)
+		}
+		switch {
+		case cmp < 0:
+			deleted = append(deleted, tt.orig[i][:t.pkCols])
+			i++
+		case cmp > 0:
+			written = append(written, t.rows[j].copyAllData())
+			j++
+		default:
+			if !reflect.DeepEqual(tt.orig[i], t.rows[j]) {
+				written = append(written, t.rows[j].copyAllData())
+			}
+			i++
+			j++
+		}
+	}
+	return deleted, written
+}
+
+// sameColumns reports whether two lists of table columns have the same names and types.
+func sameColumns(a, b []colInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || !reflect.DeepEqual(a[i].Type, b[i].Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeValues executes a write option (Insert, Update, etc.).
+func (d *database) writeValues(tx *transaction, tbl spansql.ID, cols []spansql.ID, values []*structpb.ListValue, f func(t *table, colIndexes []int, r row) error) error {
+	t, err := d.writableTable(tx, tbl)
 	if err != nil {
 		return err
 	}
@@ -434,6 +915,30 @@ func (d *database) writeValues(tx *transaction, tbl spansql.ID, cols []spansql.I
 	if err != nil {
 		return err
 	}
+
+	var rows []row
+	for _, vs := range values {
+		if len(vs.Values) != len(colIndexes) {
+			return status.Errorf(codes.InvalidArgument, "row of %d values can't be written to %d columns", len(vs.Values), len(colIndexes))
+		}
+
+		r := make(row, len(colIndexes))
+		for j, v := range vs.Values {
+			x, err := valForType(v, t.cols[colIndexes[j]].Type)
+			if err != nil {
+				return err
+			}
+			r[j] = x
+		}
+		rows = append(rows, r)
+	}
+	return t.writeRows(tx, tbl, colIndexes, rows, f)
+}
+
+// writeRows writes rows of internal values to the named columns of the table,
+// using f to place each full row in the table.
+// The caller must hold t.mu.
+func (t *table) writeRows(tx *transaction, tbl spansql.ID, colIndexes []int, values []row, f func(t *table, colIndexes []int, r row) error) error {
 	revIndex := make(map[int]int) // table index to col index
 	for j, i := range colIndexes {
 		revIndex[i] = j
@@ -447,21 +952,13 @@ func (d *database) writeValues(tx *transaction, tbl spansql.ID, cols []spansql.I
 	}
 
 	for _, vs := range values {
-		if len(vs.Values) != len(colIndexes) {
-			return status.Errorf(codes.InvalidArgument, "row of %d values can't be written to %d columns", len(vs.Values), len(colIndexes))
-		}
-
 		r := make(row, len(t.cols))
-		for j, v := range vs.Values {
+		for j, x := range vs {
 			i := colIndexes[j]
 
 			if t.cols[i].Generated != nil {
 				return status.Error(codes.InvalidArgument, "values can't be written to a generated column")
 			}
-			x, err := valForType(v, t.cols[i].Type)
-			if err != nil {
-				return err
-			}
 			if x == commitTimestampSentinel {
 				x = tx.commitTimestamp
 			}
@@ -486,39 +983,49 @@ func (d *database) writeValues(tx *transaction, tbl spansql.ID, cols []spansql.I
 		if !found {
 			return status.Error(codes.Internal, "row failed to be inserted")
 		}
-		row := t.rows[rowNum]
-		ec := evalContext{
-			cols: t.cols,
-			row:  row,
+		if err := t.computeGenerated(t.rows[rowNum]); err != nil {
+			return err
 		}
+	}
 
-		// TODO: We would need to do a topological sort on dependencies
-		// (i.e. what other columns the expression references) to ensure we
-		// can handle generated columns which reference other generated columns
-		for i, col := range t.cols {
-			if col.Generated != nil {
-				res, err := ec.evalExpr(col.Generated)
-				if err != nil {
-					return err
-				}
-				row[i] = res
+	return nil
+}
+
+// computeGenerated evaluates the generated columns of a row in the table.
+func (t *table) computeGenerated(r row) error {
+	ec := evalContext{
+		cols: t.cols,
+		row:  r,
+	}
+
+	// TODO: We would need to do a topological sort on dependencies
+	// (i.e. what other columns the expression references) to ensure we
+	// can handle generated columns which reference other generated columns
+	for i, col := range t.cols {
+		if col.Generated != nil {
+			res, err := ec.evalExpr(col.Generated)
+			if err != nil {
+				return err
 			}
+			r[i] = res
 		}
 	}
-
 	return nil
 }
 
 func (d *database) Insert(tx *transaction, tbl spansql.ID, cols []spansql.ID, values []*structpb.ListValue) error {
-	return d.writeValues(tx, tbl, cols, values, func(t *table, colIndexes []int, r row) error {
-		pk := r[:t.pkCols]
-		rowNum, found := t.rowForPK(pk)
-		if found {
-			return status.Errorf(codes.AlreadyExists, "row already in table")
-		}
-		t.insertRow(rowNum, r)
-		return nil
-	})
+	return d.writeValues(tx, tbl, cols, values, (*table).insertNew)
+}
+
+// insertNew inserts a row that must not already be in the table.
+func (t *table) insertNew(colIndexes []int, r row) error {
+	pk := r[:t.pkCols]
+	rowNum, found := t.rowForPK(pk)
+	if found {
+		return status.Errorf(codes.AlreadyExists, "row already in table")
+	}
+	t.insertRow(rowNum, r)
+	return nil
 }
 
 func (d *database) Update(tx *transaction, tbl spansql.ID, cols []spansql.ID, values []*structpb.ListValue) error {
@@ -560,11 +1067,7 @@ func (d *database) InsertOrUpdate(tx *transaction, tbl spansql.ID, cols []spansq
 // TODO: Replace
 
 func (d *database) Delete(tx *transaction, table spansql.ID, keys []*structpb.ListValue, keyRanges keyRangeList, all bool) error {
-	if err := tx.checkMutable(); err != nil {
-		return err
-	}
-
-	t, err := d.table(table)
+	t, err := d.writableTable(tx, table)
 	if err != nil {
 		return err
 	}
@@ -585,8 +1088,7 @@ func (d *database) Delete(tx *transaction, table spansql.ID, keys []*structpb.Li
 		// Not an error if the key does not exist.
 		rowNum, found := t.rowForPK(pk)
 		if found {
-			copy(t.rows[rowNum:], t.rows[rowNum+1:])
-			t.rows = t.rows[:len(t.rows)-1]
+			t.deleteRow(rowNum)
 		}
 	}
 
@@ -610,8 +1112,8 @@ func (d *database) Delete(tx *transaction, table spansql.ID, keys []*structpb.Li
 }
 
 // readTable executes a read option (Read, ReadAll).
-func (d *database) readTable(table spansql.ID, cols []spansql.ID, f func(*table, *rawIter, []int) error) (*rawIter, error) {
-	t, err := d.table(table)
+func (d *database) readTable(tx *transaction, table spansql.ID, cols []spansql.ID, f func(*table, *rawIter, []int) error) (*rawIter, error) {
+	t, err := d.tableForTx(tx, table)
 	if err != nil {
 		return nil, err
 	}
@@ -631,7 +1133,7 @@ func (d *database) readTable(table spansql.ID, cols []spansql.ID, f func(*table,
 	return ri, f(t, ri, colIndexes)
 }
 
-func (d *database) Read(tbl spansql.ID, cols []spansql.ID, keys []*structpb.ListValue, keyRanges keyRangeList, limit int64) (rowIter, error) {
+func (d *database) Read(tx *transaction, tbl spansql.ID, cols []spansql.ID, keys []*structpb.ListValue, keyRanges keyRangeList, limit int64) (rowIter, error) {
 	// The real Cloud Spanner returns an error if the key set is empty by definition.
 	// That doesn't seem to be well-defined, but it is a common error to attempt a read with no keys,
 	// so catch that here and return a representative error.
@@ -639,7 +1141,19 @@ func (d *database) Read(tbl spansql.ID, cols []spansql.ID, keys []*structpb.List
 		return nil, status.Error(codes.Unimplemented, "Cloud Spanner does not support reading no keys")
 	}
 
-	return d.readTable(tbl, cols, func(t *table, ri *rawIter, colIndexes []int) error {
+	// Reads are recorded once the table is unlocked.
+	// Conflicts are only tracked for individual keys or whole tables.
+	var readKeys [][]interface{}
+	if len(keyRanges) > 0 {
+		tx.recordRead(tbl)
+	}
+	defer func() {
+		if len(readKeys) > 0 {
+			tx.recordRead(tbl, readKeys...)
+		}
+	}()
+
+	return d.readTable(tx, tbl, cols, func(t *table, ri *rawIter, colIndexes []int) error {
 		// "If the same key is specified multiple times in the set (for
 		// example if two ranges, two keys, or a key and a range
 		// overlap), Cloud Spanner behaves as if the key were only
@@ -652,7 +1166,9 @@ func (d *database) Read(tbl spansql.ID, cols []spansql.ID, keys []*structpb.List
 			if err != nil {
 				return err
 			}
-			// Not an error if the key does not exist.
+			// Not an error if the key does not exist,
+			// but a later write of that row is still a conflict.
+			readKeys = append(readKeys, pk)
 			rowNum, found := t.rowForPK(pk)
 			if !found {
 				continue
@@ -695,8 +1211,9 @@ func (d *database) Read(tbl spansql.ID, cols []spansql.ID, keys []*structpb.List
 	})
 }
 
-func (d *database) ReadAll(tbl spansql.ID, cols []spansql.ID, limit int64) (*rawIter, error) {
-	return d.readTable(tbl, cols, func(t *table, ri *rawIter, colIndexes []int) error {
+func (d *database) ReadAll(tx *transaction, tbl spansql.ID, cols []spansql.ID, limit int64) (*rawIter, error) {
+	tx.recordRead(tbl)
+	return d.readTable(tx, tbl, cols, func(t *table, ri *rawIter, colIndexes []int) error {
 		for _, r := range t.rows {
 			ri.add(r, colIndexes)
 			if limit > 0 && len(ri.rows) >= int(limit) {
@@ -871,7 +1388,7 @@ func (t *table) alterColumn(alt spansql.AlterColumn) *status.Status {
 		} else if oldT.Base == spansql.String && newT.Base == spansql.Bytes {
 			conv = func(x interface{}) interface{} { return []byte(x.(string)) }
 		}
-	} else if oldT == newT {
+	} else if reflect.DeepEqual(oldT, newT) {
 		// Same type; only NOT NULL changes.
 	} else { // TODO: Support other alterations.
 		return status.Newf(codes.InvalidArgument, "unsupported ALTER COLUMN %s", alt.SQL())
@@ -947,6 +1464,36 @@ func (t *table) insertRow(rowNum int, r row) {
 	t.rows[rowNum] = r
 }
 
+func (t *table) deleteRow(rowNum int) {
+	copy(t.rows[rowNum:], t.rows[rowNum+1:])
+	t.rows = t.rows[:len(t.rows)-1]
+}
+
+// clone returns a copy of the table, including a deep copy of its data.
+// The caller must hold t.mu.
+func (t *table) clone() *table {
+	nt := &table{
+		cols:        append([]colInfo(nil), t.cols...),
+		colIndex:    make(map[spansql.ID]int),
+		origIndex:   make(map[spansql.ID]int),
+		pkCols:      t.pkCols,
+		pkDesc:      append([]bool(nil), t.pkDesc...),
+		constraints: append([]constraintInfo(nil), t.constraints...),
+		rdw:         t.rdw,
+		rows:        make([]row, 0, len(t.rows)),
+	}
+	for name, i := range t.colIndex {
+		nt.colIndex[name] = i
+	}
+	for name, i := range t.origIndex {
+		nt.origIndex[name] = i
+	}
+	for _, r := range t.rows {
+		nt.rows = append(nt.rows, r.copyAllData())
+	}
+	return nt
+}
+
 // findRange finds the rows included in the key range,
 // reporting it as a half-open interval.
 // r.startKey and r.endKey should be populated.
@@ -1134,6 +1681,35 @@ func valForType(v *structpb.Value, t spansql.Type) (interface{}, error) {
 			}
 			return t, nil
 		}
+	case spansql.Numeric:
+		// The Spanner protocol encodes NUMERIC as a decimal string.
+		sv, ok := v.Kind.(*structpb.Value_StringValue)
+		if ok {
+			return parseAsNumeric(sv.StringValue)
+		}
+	case spansql.JSON:
+		sv, ok := v.Kind.(*structpb.Value_StringValue)
+		if ok {
+			j, err := parseAsJSON(sv.StringValue)
+			if err != nil {
+				return nil, fmt.Errorf("bad JSON string %q: %w", sv.StringValue, err)
+			}
+			return j, nil
+		}
+	case spansql.Struct:
+		// The Spanner protocol encodes STRUCT as a list of field values.
+		lv, ok := v.Kind.(*structpb.Value_ListValue)
+		if ok && len(lv.ListValue.Values) == len(t.Fields) {
+			st := make([]interface{}, 0, len(t.Fields))
+			for i, v := range lv.ListValue.Values {
+				x, err := valForType(v, t.Fields[i].Type)
+				if err != nil {
+					return nil, err
+				}
+				st = append(st, x)
+			}
+			return st, nil
+		}
 	}
 	return nil, fmt.Errorf("unsupported inserting value kind %T into column of type %s", v.Kind, t.SQL())
 }
@@ -1165,21 +1741,101 @@ func (r *keyRange) String() string {
 
 type keyRangeList []*keyRange
 
-// Execute runs a DML statement.
+// Execute runs a DML statement in the given transaction.
 // It returns the number of affected rows.
-func (d *database) Execute(stmt spansql.DMLStmt, params queryParams) (int, error) { // TODO: return *status.Status instead?
+// If the statement fails, none of its changes are kept.
+func (d *database) Execute(tx *transaction, stmt spansql.DMLStmt, params queryParams) (int, error) { // TODO: return *status.Status instead?
+	var tbl spansql.ID
 	switch stmt := stmt.(type) {
 	default:
 		return 0, status.Errorf(codes.Unimplemented, "unhandled DML statement type %T", stmt)
 	case *spansql.Delete:
-		t, err := d.table(stmt.Table)
+		tbl = stmt.Table
+	case *spansql.Update:
+		tbl = stmt.Table
+	case *spansql.Insert:
+		tbl = stmt.Table
+	}
+
+	// The input of INSERT ... SELECT is evaluated before the target table is locked,
+	// since the query may read from that same table.
+	var input []row
+	if ins, ok := stmt.(*spansql.Insert); ok {
+		var err error
+		input, err = d.evalInsertInput(tx, ins, params)
 		if err != nil {
 			return 0, err
 		}
+	}
 
-		t.mu.Lock()
-		defer t.mu.Unlock()
+	t, err := d.writableTable(tx, tbl)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := stmt.(*spansql.Insert); !ok {
+		// UPDATE and DELETE scan the whole table.
+		tx.recordRead(tbl)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// DML statements are atomic, so keep a copy of the data to restore if this fails.
+	saved := make([]row, 0, len(t.rows))
+	for _, r := range t.rows {
+		saved = append(saved, r.copyAllData())
+	}
+	n, err := t.execute(tx, stmt, params, input)
+	if err != nil {
+		t.rows = saved
+		return 0, err
+	}
+	return n, nil
+}
+
+// evalInsertInput evaluates the rows to be written by an INSERT statement.
+func (d *database) evalInsertInput(tx *transaction, stmt *spansql.Insert, params queryParams) ([]row, error) {
+	var rows []row
+	switch input := stmt.Input.(type) {
+	default:
+		return nil, status.Errorf(codes.Unimplemented, "unhandled INSERT input type %T", input)
+	case spansql.Values:
+		ec := evalContext{
+			params: params,
+		}
+		for _, exprs := range input {
+			vals, err := ec.evalExprList(exprs)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, vals)
+		}
+	case spansql.Select:
+		ri, err := d.Query(tx, spansql.Query{Select: input}, params)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := toRawIter(ri)
+		if err != nil {
+			return nil, err
+		}
+		rows = raw.rows
+	}
+	for _, r := range rows {
+		if len(r) != len(stmt.Columns) {
+			return nil, status.Errorf(codes.InvalidArgument, "INSERT has %d columns but %d values", len(stmt.Columns), len(r))
+		}
+	}
+	return rows, nil
+}
 
+// execute runs a DML statement on the table.
+// The caller must hold t.mu.
+func (t *table) execute(tx *transaction, stmt spansql.DMLStmt, params queryParams, input []row) (int, error) {
+	switch stmt := stmt.(type) {
+	default:
+		return 0, status.Errorf(codes.Unimplemented, "unhandled DML statement type %T", stmt)
+	case *spansql.Delete:
 		n := 0
 		for i := 0; i < len(t.rows); {
 			ec := evalContext{
@@ -1192,8 +1848,7 @@ func (d *database) Execute(stmt spansql.DMLStmt, params queryParams) (int, error
 				return 0, err
 			}
 			if b != nil && *b {
-				copy(t.rows[i:], t.rows[i+1:])
-				t.rows = t.rows[:len(t.rows)-1]
+				t.deleteRow(i)
 				n++
 				continue
 			}
@@ -1201,14 +1856,6 @@ func (d *database) Execute(stmt spansql.DMLStmt, params queryParams) (int, error
 		}
 		return n, nil
 	case *spansql.Update:
-		t, err := d.table(stmt.Table)
-		if err != nil {
-			return 0, err
-		}
-
-		t.mu.Lock()
-		defer t.mu.Unlock()
-
 		ec := evalContext{
 			cols:   t.cols,
 			params: params,
@@ -1226,6 +1873,9 @@ func (d *database) Execute(stmt spansql.DMLStmt, params queryParams) (int, error
 			if i < t.pkCols {
 				return 0, status.Errorf(codes.InvalidArgument, "cannot update primary key %s", ui.Column)
 			}
+			if t.cols[i].Generated != nil {
+				return 0, status.Errorf(codes.InvalidArgument, "cannot update generated column %s", ui.Column)
+			}
 			dstIndex = append(dstIndex, i)
 			expr = append(expr, ui.Value)
 		}
@@ -1249,20 +1899,174 @@ func (d *database) Execute(stmt spansql.DMLStmt, params queryParams) (int, error
 					if err != nil {
 						return 0, err
 					}
+					col := t.cols[dstIndex[j]]
+					v, err = coerceForType(v, col.Type)
+					if err != nil {
+						return 0, err
+					}
 					values[j] = v
 				}
 				// Write them to the row.
 				for j, v := range values {
+					col := t.cols[dstIndex[j]]
+					if v == nil && col.NotNull {
+						return 0, status.Errorf(codes.FailedPrecondition, "%s must not be NULL in table %s", col.Name, stmt.Table)
+					}
 					t.rows[i][dstIndex[j]] = v
 				}
+				if err := t.computeGenerated(t.rows[i]); err != nil {
+					return 0, err
+				}
 				n++
 			}
 		}
 		return n, nil
+	case *spansql.Insert:
+		colIndexes, err := t.colIndexes(stmt.Columns)
+		if err != nil {
+			return 0, err
+		}
+		for _, r := range input {
+			for j, x := range r {
+				r[j], err = coerceForType(x, t.cols[colIndexes[j]].Type)
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+		if err := t.writeRows(tx, stmt.Table, colIndexes, input, (*table).insertNew); err != nil {
+			return 0, err
+		}
+		return len(input), nil
 	}
 }
 
+// coerceForType converts a value produced by evaluating an expression
+// so that it can be stored in a column of the given type.
+func coerceForType(x interface{}, typ spansql.Type) (interface{}, error) {
+	if x == nil {
+		return nil, nil
+	}
+	if typ.Array {
+		arr, ok := x.([]interface{})
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "value of type %T can't be assigned to column of type %s", x, typ.SQL())
+		}
+		et := typ // element type
+		et.Array = false
+		out := make([]interface{}, 0, len(arr))
+		for _, elem := range arr {
+			v, err := coerceForType(elem, et)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	}
+
+	var ok bool
+	switch typ.Base {
+	case spansql.Bool:
+		_, ok = x.(bool)
+	case spansql.Int64:
+		_, ok = x.(int64)
+	case spansql.Float64:
+		switch v := x.(type) {
+		case int64:
+			return float64(v), nil
+		case float64:
+			ok = true
+		}
+	case spansql.String:
+		_, ok = x.(string)
+	case spansql.Bytes:
+		_, ok = x.([]byte)
+	case spansql.Date:
+		switch v := x.(type) {
+		case string:
+			d, err := parseAsDate(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "coercing %q to DATE: %v", v, err)
+			}
+			return d, nil
+		case civil.Date:
+			ok = true
+		}
+	case spansql.Timestamp:
+		switch v := x.(type) {
+		case string:
+			t, err := parseAsTimestamp(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "coercing %q to TIMESTAMP: %v", v, err)
+			}
+			return t, nil
+		case time.Time:
+			ok = true
+		}
+	case spansql.Numeric:
+		switch v := x.(type) {
+		case int64:
+			return new(big.Rat).SetInt64(v), nil
+		case *big.Rat:
+			ok = true
+		}
+	case spansql.JSON:
+		_, ok = x.(jsonValue)
+	}
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "value of type %T can't be assigned to column of type %s", x, typ.SQL())
+	}
+	return x, nil
+}
+
 func parseAsDate(s string) (civil.Date, error) { return civil.ParseDate(s) }
 func parseAsTimestamp(s string) (time.Time, error) {
 	return time.Parse("2006-01-02T15:04:05.999999999Z", s)
 }
+
+func parseAsNumeric(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid NUMERIC value %q", s)
+	}
+	return roundNumeric(r)
+}
+
+// jsonValue is the internal representation of a JSON value.
+// It holds normalized JSON text, so equal documents have equal representations.
+type jsonValue string
+
+func parseAsJSON(s string) (jsonValue, error) {
+	v, err := decodeJSON(s)
+	if err != nil {
+		return "", err
+	}
+	return encodeJSON(v)
+}
+
+// decodeJSON parses JSON text, keeping the full precision of numbers.
+func decodeJSON(s string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+// encodeJSON returns the normalized JSON text for a decoded value.
+// Object keys are sorted and insignificant whitespace is removed.
+func encodeJSON(v interface{}) (jsonValue, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return jsonValue(strings.TrimSuffix(buf.String(), "\n")), nil
+}
@@ -21,6 +21,8 @@ package spannertest
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"math/big"
 	"regexp"
 	"strconv"
 	"strings"
@@ -42,6 +44,12 @@ type evalContext struct {
 	aliases map[spansql.ID]spansql.Expr
 
 	params queryParams
+
+	// qc is set when evaluating within a query, which permits subqueries.
+	qc *queryContext
+	// outer is set when evaluating a subquery. It is the context of the
+	// enclosing query, and resolves column references that aren't local.
+	outer *evalContext
 }
 
 // coercedValue represents a literal value that has been coerced to a different type.
@@ -74,7 +82,7 @@ func (ec evalContext) evalBoolExpr(be spansql.BoolExpr) (*bool, error) {
 	case spansql.BoolLiteral:
 		b := bool(be)
 		return &b, nil
-	case spansql.ID, spansql.Param, spansql.Paren, spansql.Func, spansql.InOp: // InOp is a bit weird.
+	case spansql.ID, spansql.Param, spansql.Paren, spansql.Func, spansql.InOp, spansql.ScalarSubquery: // InOp is a bit weird.
 		e, err := ec.evalExpr(be)
 		if err != nil {
 			return nil, err
@@ -206,6 +214,17 @@ func (ec evalContext) evalBoolExpr(be spansql.BoolExpr) (*bool, error) {
 			}
 		}
 		return &b, nil
+	case spansql.ExistsOp:
+		ri, err := ec.evalSubquery(be.Query)
+		if err != nil {
+			return nil, err
+		}
+		_, err = ri.Next()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		b := err == nil
+		return &b, nil
 	case spansql.IsOp:
 		lhs, err := ec.evalExpr(be.LHS)
 		if err != nil {
@@ -252,8 +271,10 @@ func (ec evalContext) evalArithOp(e spansql.ArithOp) (interface{}, error) {
 			return -rhs, nil
 		case int64:
 			return -rhs, nil
+		case *big.Rat:
+			return new(big.Rat).Neg(rhs), nil
 		}
-		return nil, fmt.Errorf("RHS of %s evaluates to %T, want FLOAT64 or INT64", e.SQL(), rhs)
+		return nil, fmt.Errorf("RHS of %s evaluates to %T, want FLOAT64, INT64 or NUMERIC", e.SQL(), rhs)
 	case spansql.BitNot:
 		rhs, err := ec.evalExpr(e.RHS)
 		if err != nil {
@@ -274,19 +295,36 @@ func (ec evalContext) evalArithOp(e spansql.ArithOp) (interface{}, error) {
 		}
 		return nil, fmt.Errorf("RHS of %s evaluates to %T, want INT64 or BYTES", e.SQL(), rhs)
 	case spansql.Div:
-		lhs, err := ec.evalFloat64(e.LHS)
+		lhs, err := ec.evalExpr(e.LHS)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := ec.evalExpr(e.RHS)
 		if err != nil {
 			return nil, err
 		}
-		rhs, err := ec.evalFloat64(e.RHS)
+		if lhs == nil || rhs == nil {
+			return nil, nil
+		}
+		if r1, r2, ok := asNumerics(lhs, rhs); ok {
+			if r2.Sign() == 0 {
+				return nil, status.Errorf(codes.OutOfRange, "division by zero: %s", e.SQL())
+			}
+			return roundNumeric(new(big.Rat).Quo(r1, r2))
+		}
+		f1, err := asFloat64(e.LHS, lhs)
+		if err != nil {
+			return nil, err
+		}
+		f2, err := asFloat64(e.RHS, rhs)
 		if err != nil {
 			return nil, err
 		}
-		if rhs == 0 {
+		if f2 == 0 {
 			// TODO: Does real Spanner use a specific error code here?
 			return nil, fmt.Errorf("divide by zero")
 		}
-		return lhs / rhs, nil
+		return f1 / f2, nil
 	case spansql.Add, spansql.Sub, spansql.Mul:
 		lhs, err := ec.evalExpr(e.LHS)
 		if err != nil {
@@ -314,6 +352,18 @@ func (ec evalContext) evalArithOp(e spansql.ArithOp) (interface{}, error) {
 				return i1 * i2, nil
 			}
 		}
+		if r1, r2, ok := asNumerics(lhs, rhs); ok {
+			r := new(big.Rat)
+			switch e.Op {
+			case spansql.Add:
+				r.Add(r1, r2)
+			case spansql.Sub:
+				r.Sub(r1, r2)
+			case spansql.Mul:
+				r.Mul(r1, r2)
+			}
+			return roundNumeric(r)
+		}
 		f1, err := asFloat64(e.LHS, lhs)
 		if err != nil {
 			return nil, err
@@ -415,14 +465,66 @@ func (ec evalContext) evalFloat64(e spansql.Expr) (float64, error) {
 func asFloat64(e spansql.Expr, v interface{}) (float64, error) {
 	switch v := v.(type) {
 	default:
-		return 0, fmt.Errorf("expression %s evaluates to %T, want FLOAT64 or INT64", e.SQL(), v)
+		return 0, fmt.Errorf("expression %s evaluates to %T, want FLOAT64, INT64 or NUMERIC", e.SQL(), v)
 	case float64:
 		return v, nil
 	case int64:
 		return float64(v), nil
+	case *big.Rat:
+		f, _ := v.Float64()
+		return f, nil
 	}
 }
 
+// asNumerics converts a pair of operands to NUMERIC.
+// It succeeds only if at least one is NUMERIC and the other is NUMERIC or INT64;
+// mixing NUMERIC and FLOAT64 yields FLOAT64, which is handled by asFloat64.
+func asNumerics(x, y interface{}) (*big.Rat, *big.Rat, bool) {
+	_, xn := x.(*big.Rat)
+	_, yn := y.(*big.Rat)
+	if !xn && !yn {
+		return nil, nil, false
+	}
+	toRat := func(v interface{}) (*big.Rat, bool) {
+		switch v := v.(type) {
+		case *big.Rat:
+			return v, true
+		case int64:
+			return new(big.Rat).SetInt64(v), true
+		}
+		return nil, false
+	}
+	r1, ok1 := toRat(x)
+	r2, ok2 := toRat(y)
+	return r1, r2, ok1 && ok2
+}
+
+// numericScale is the number of decimal digits after the point that a NUMERIC holds.
+const numericScale = 9
+
+// maxNumeric is the exclusive bound on the magnitude of a NUMERIC (10^29).
+var maxNumeric = new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(29), nil))
+
+// roundNumeric rounds r to NUMERIC precision, rounding half away from zero,
+// and reports an error if the result is out of range.
+// It never modifies r.
+func roundNumeric(r *big.Rat) (*big.Rat, error) {
+	// FloatString rounds half away from zero, which is what Spanner does.
+	rr, _ := new(big.Rat).SetString(r.FloatString(numericScale))
+	if new(big.Rat).Abs(rr).Cmp(maxNumeric) >= 0 {
+		return nil, status.Errorf(codes.OutOfRange, "numeric overflow: %s", r.FloatString(numericScale))
+	}
+	return rr, nil
+}
+
+// numericString formats a NUMERIC value in its canonical form,
+// without trailing zeros after the decimal point.
+func numericString(r *big.Rat) string {
+	s := r.FloatString(numericScale)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
 func (ec evalContext) evalExpr(e spansql.Expr) (interface{}, error) {
 	// Several cases below are handled by this.
 	// It evaluates a BoolExpr (which returns *bool for a tri-state BOOL)
@@ -461,6 +563,16 @@ func (ec evalContext) evalExpr(e spansql.Expr) (interface{}, error) {
 		return string(e), nil
 	case spansql.BytesLiteral:
 		return []byte(e), nil
+	case spansql.NumericLiteral:
+		return parseAsNumeric(string(e))
+	case spansql.JSONLiteral:
+		j, err := parseAsJSON(string(e))
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid JSON literal %s: %v", e.SQL(), err)
+		}
+		return j, nil
+	case spansql.StructLiteral:
+		return ec.evalStructLiteral(e)
 	case spansql.NullLiteral:
 		return nil, nil
 	case spansql.BoolLiteral:
@@ -502,6 +614,9 @@ func (ec evalContext) evalExpr(e spansql.Expr) (interface{}, error) {
 		// The docs are a bit confusing here, so there's probably some bugs here around NULL handling.
 		// TODO: Can this now simplify using evalBool?
 
+		if e.Query != nil {
+			return ec.evalInSubquery(e)
+		}
 		if len(e.RHS) == 0 {
 			// "IN with an empty right side expression is always FALSE".
 			return e.Neg, nil
@@ -521,7 +636,12 @@ func (ec evalContext) evalExpr(e spansql.Expr) (interface{}, error) {
 				return false, err
 			}
 			if !e.Unnest {
-				if lhs == rhs {
+				if _, ok := lhs.(*big.Rat); ok && rhs != nil {
+					// NUMERIC values must be compared by value.
+					if compareVals(lhs, rhs) == 0 {
+						b = true
+					}
+				} else if lhs == rhs {
 					b = true
 				}
 			} else {
@@ -547,6 +667,44 @@ func (ec evalContext) evalExpr(e spansql.Expr) (interface{}, error) {
 		return b, nil
 	case spansql.IsOp:
 		return evalBool(e)
+	case spansql.ExistsOp:
+		return evalBool(e)
+	case spansql.ScalarSubquery:
+		ri, err := ec.evalSubquery(e.Query)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := toRawIter(ri)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw.cols) != 1 {
+			return nil, fmt.Errorf("scalar subquery must have exactly one column, got %d", len(raw.cols))
+		}
+		switch len(raw.rows) {
+		case 0:
+			return nil, nil
+		case 1:
+			return raw.rows[0].copyDataElem(0), nil
+		}
+		return nil, status.Errorf(codes.OutOfRange, "a scalar subquery produced more than one element")
+	case spansql.ArraySubquery:
+		ri, err := ec.evalSubquery(e.Query)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := toRawIter(ri)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw.cols) != 1 {
+			return nil, fmt.Errorf("ARRAY subquery must have exactly one column, got %d", len(raw.cols))
+		}
+		arr := make([]interface{}, 0, len(raw.rows)) // an empty result is an empty array, not NULL
+		for _, r := range raw.rows {
+			arr = append(arr, r.copyDataElem(0))
+		}
+		return arr, nil
 	case aggSentinel:
 		// Match up e.AggIndex with the column.
 		// They might have been reordered.
@@ -564,6 +722,74 @@ func (ec evalContext) evalExpr(e spansql.Expr) (interface{}, error) {
 	}
 }
 
+// evalSubquery starts evaluating a subquery.
+// The receiver becomes the context of the enclosing query.
+func (ec evalContext) evalSubquery(q spansql.Query) (rowIter, error) {
+	if ec.qc == nil {
+		return nil, fmt.Errorf("subquery not supported here: %s", q.SQL())
+	}
+	outer := ec
+	return ec.qc.d.evalQuery(q, ec.qc, &outer)
+}
+
+// evalInSubquery evaluates the subquery form of an IN expression.
+func (ec evalContext) evalInSubquery(e spansql.InOp) (interface{}, error) {
+	ri, err := ec.evalSubquery(*e.Query)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := toRawIter(ri)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw.cols) != 1 {
+		return nil, fmt.Errorf("IN subquery must have exactly one column, got %d", len(raw.cols))
+	}
+	if len(raw.rows) == 0 {
+		// "IN with an empty right side expression is always FALSE".
+		return e.Neg, nil
+	}
+	lhs, err := ec.evalExpr(e.LHS)
+	if err != nil {
+		return nil, err
+	}
+	if lhs == nil {
+		return nil, nil
+	}
+	// If there's no match but there is a NULL, the result is NULL.
+	var sawNull bool
+	for _, r := range raw.rows {
+		if r[0] == nil {
+			sawNull = true
+			continue
+		}
+		if compareVals(lhs, r[0]) == 0 {
+			return !e.Neg, nil
+		}
+	}
+	if sawNull {
+		return nil, nil
+	}
+	return e.Neg, nil
+}
+
+// evalCorrelated evaluates a column reference in a subquery
+// that refers to the enclosing query whose context is ec.
+func (ec evalContext) evalCorrelated(e spansql.Expr) (interface{}, error) {
+	if i, err := ec.resolveColumnIndex(e); err == nil {
+		if i >= len(ec.row) {
+			// There's no current row, such as when the
+			// column types of the subquery are being deduced.
+			return nil, nil
+		}
+		return ec.row.copyDataElem(i), nil
+	}
+	if ec.outer != nil {
+		return ec.outer.evalCorrelated(e)
+	}
+	return nil, fmt.Errorf("couldn't resolve [%s] as a table column", e.SQL())
+}
+
 // resolveColumnIndex turns an ID or PathExp into a table column index.
 func (ec evalContext) resolveColumnIndex(e spansql.Expr) (int, error) {
 	switch e := e.(type) {
@@ -584,15 +810,129 @@ func (ec evalContext) resolveColumnIndex(e spansql.Expr) (int, error) {
 }
 
 func (ec evalContext) evalPathExp(pe spansql.PathExp) (interface{}, error) {
-	// TODO: support more than only naming an aliased table column.
 	if i, err := ec.resolveColumnIndex(pe); err == nil {
+		if i >= len(ec.row) {
+			// There's no current row, such as when column types are being deduced.
+			return nil, nil
+		}
 		return ec.row.copyDataElem(i), nil
 	}
+	if i, fields, _, ok := ec.structFieldPath(pe); ok {
+		if i >= len(ec.row) {
+			// There's no current row, such as when column types are being deduced.
+			return nil, nil
+		}
+		v := ec.row.copyDataElem(i)
+		for _, fi := range fields {
+			st, _ := v.([]interface{})
+			if st == nil {
+				// Field access on a NULL struct yields NULL.
+				return nil, nil
+			}
+			v = st[fi]
+		}
+		return v, nil
+	}
+	if ec.outer != nil {
+		return ec.outer.evalCorrelated(pe)
+	}
 	return nil, fmt.Errorf("couldn't resolve path expression %s", pe.SQL())
 }
 
+// structFieldPath resolves a path expression that names a field of a STRUCT-typed column,
+// such as "s.a" or "t.s.a.b". It returns the column index, the indexes of the successive
+// fields to select, and the type of the final field.
+func (ec evalContext) structFieldPath(pe spansql.PathExp) (col int, fields []int, typ spansql.Type, ok bool) {
+	// Find the longest prefix that names a column.
+	for n := len(pe) - 1; n >= 1; n-- {
+		var prefix spansql.Expr = pe[:n]
+		if n == 1 {
+			prefix = pe[0]
+		}
+		i, err := ec.resolveColumnIndex(prefix)
+		if err != nil {
+			continue
+		}
+		typ := ec.cols[i].Type
+		var fields []int
+		for _, name := range pe[n:] {
+			if typ.Base != spansql.Struct || typ.Array {
+				return 0, nil, spansql.Type{}, false
+			}
+			fi := -1
+			for j, f := range typ.Fields {
+				if strings.EqualFold(string(f.Name), string(name)) {
+					fi = j
+					break
+				}
+			}
+			if fi < 0 {
+				return 0, nil, spansql.Type{}, false
+			}
+			fields = append(fields, fi)
+			typ = typ.Fields[fi].Type
+		}
+		return i, fields, typ, true
+	}
+	return 0, nil, spansql.Type{}, false
+}
+
+// structLiteralType returns the type of a STRUCT literal.
+// Untyped literals take their field types from the values,
+// and their field names from aliases or the values themselves.
+func (ec evalContext) structLiteralType(sl spansql.StructLiteral) (spansql.Type, error) {
+	if len(sl.Fields) > 0 {
+		return spansql.Type{Base: spansql.Struct, Fields: sl.Fields}, nil
+	}
+	t := spansql.Type{Base: spansql.Struct}
+	for i, v := range sl.Values {
+		ci, err := ec.colInfo(v)
+		if err != nil {
+			return spansql.Type{}, err
+		}
+		var name spansql.ID
+		if len(sl.Aliases) > 0 {
+			name = sl.Aliases[i]
+		}
+		if name == "" {
+			switch v := v.(type) {
+			case spansql.ID:
+				name = v
+			case spansql.PathExp:
+				name = v[len(v)-1]
+			}
+		}
+		t.Fields = append(t.Fields, spansql.StructField{Name: name, Type: ci.Type})
+	}
+	return t, nil
+}
+
+func (ec evalContext) evalStructLiteral(sl spansql.StructLiteral) (interface{}, error) {
+	if len(sl.Fields) > 0 && len(sl.Fields) != len(sl.Values) {
+		return nil, status.Errorf(codes.InvalidArgument, "STRUCT type has %d fields but %d values were given", len(sl.Fields), len(sl.Values))
+	}
+	vals, err := ec.evalExprList(sl.Values)
+	if err != nil {
+		return nil, err
+	}
+	st := make([]interface{}, len(sl.Values))
+	for i, v := range vals {
+		if len(sl.Fields) > 0 {
+			if v, err = coerceForType(v, sl.Fields[i].Type); err != nil {
+				return nil, err
+			}
+		}
+		st[i] = v
+	}
+	return st, nil
+}
+
 func (ec evalContext) evalID(id spansql.ID) (interface{}, error) {
 	if i, err := ec.resolveColumnIndex(id); err == nil {
+		if i >= len(ec.row) {
+			// There's no current row, such as when column types are being deduced.
+			return nil, nil
+		}
 		return ec.row.copyDataElem(i), nil
 	}
 	if e, ok := ec.aliases[id]; ok {
@@ -607,6 +947,9 @@ func (ec evalContext) evalID(id spansql.ID) (interface{}, error) {
 		}
 		return innerEC.evalExpr(e)
 	}
+	if ec.outer != nil {
+		return ec.outer.evalCorrelated(id)
+	}
 	return nil, fmt.Errorf("couldn't resolve identifier %s", id)
 }
 
@@ -816,6 +1159,10 @@ func compareVals(x, y interface{}) int {
 			// Coersion from INT64 to FLOAT64 is allowed.
 			return compareVals(x, f)
 		}
+		if r, ok := y.(*big.Rat); ok {
+			// Coersion from INT64 to NUMERIC is allowed.
+			return -compareVals(r, x)
+		}
 		y := y.(int64)
 		if x < y {
 			return -1
@@ -825,8 +1172,11 @@ func compareVals(x, y interface{}) int {
 		return 0
 	case float64:
 		// Coersion from INT64 to FLOAT64 is allowed.
-		if i, ok := y.(int64); ok {
-			y = float64(i)
+		switch yv := y.(type) {
+		case int64:
+			y = float64(yv)
+		case *big.Rat:
+			y, _ = yv.Float64()
 		}
 		y := y.(float64)
 		if x < y {
@@ -855,12 +1205,35 @@ func compareVals(x, y interface{}) int {
 		return 0
 	case []byte:
 		return bytes.Compare(x, y.([]byte))
+	case *big.Rat:
+		switch y := y.(type) {
+		case int64:
+			return x.Cmp(new(big.Rat).SetInt64(y))
+		case float64:
+			f, _ := x.Float64()
+			return compareVals(f, y)
+		}
+		return x.Cmp(y.(*big.Rat))
+	case jsonValue:
+		// JSON isn't orderable in Spanner, but this is enough for equality.
+		return strings.Compare(string(x), string(y.(jsonValue)))
+	case []interface{}:
+		// STRUCT values compare field by field.
+		y := y.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareVals(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return len(x) - len(y)
 	}
 }
 
 var (
 	boolType    = spansql.Type{Base: spansql.Bool}
 	int64Type   = spansql.Type{Base: spansql.Int64}
+	numericType = spansql.Type{Base: spansql.Numeric}
+	jsonType    = spansql.Type{Base: spansql.JSON}
 	float64Type = spansql.Type{Base: spansql.Float64}
 	stringType  = spansql.Type{Base: spansql.String}
 )
@@ -876,13 +1249,23 @@ func (ec evalContext) colInfo(e spansql.Expr) (colInfo, error) {
 		return colInfo{Type: stringType}, nil
 	case spansql.BytesLiteral:
 		return colInfo{Type: spansql.Type{Base: spansql.Bytes}}, nil
+	case spansql.NumericLiteral:
+		return colInfo{Type: numericType}, nil
+	case spansql.JSONLiteral:
+		return colInfo{Type: jsonType}, nil
+	case spansql.StructLiteral:
+		t, err := ec.structLiteralType(e)
+		if err != nil {
+			return colInfo{}, err
+		}
+		return colInfo{Type: t}, nil
 	case spansql.ArithOp:
 		t, err := ec.arithColType(e)
 		if err != nil {
 			return colInfo{}, err
 		}
 		return colInfo{Type: t}, nil
-	case spansql.LogicalOp, spansql.ComparisonOp, spansql.IsOp:
+	case spansql.LogicalOp, spansql.ComparisonOp, spansql.IsOp, spansql.InOp, spansql.ExistsOp:
 		return colInfo{Type: spansql.Type{Base: spansql.Bool}}, nil
 	case spansql.PathExp, spansql.ID:
 		// TODO: support more than only naming a table column.
@@ -890,7 +1273,40 @@ func (ec evalContext) colInfo(e spansql.Expr) (colInfo, error) {
 		if err == nil {
 			return ec.cols[i], nil
 		}
+		if pe, ok := e.(spansql.PathExp); ok {
+			if _, _, t, ok := ec.structFieldPath(pe); ok {
+				return colInfo{Name: pe[len(pe)-1], Type: t}, nil
+			}
+		}
+		if ec.outer != nil {
+			return ec.outer.colInfo(e)
+		}
 		// Let errors fall through.
+	case spansql.ScalarSubquery:
+		ri, err := ec.evalSubquery(e.Query)
+		if err != nil {
+			return colInfo{}, err
+		}
+		cols := ri.Cols()
+		if len(cols) != 1 {
+			return colInfo{}, fmt.Errorf("scalar subquery must have exactly one column, got %d", len(cols))
+		}
+		return colInfo{Type: cols[0].Type}, nil
+	case spansql.ArraySubquery:
+		ri, err := ec.evalSubquery(e.Query)
+		if err != nil {
+			return colInfo{}, err
+		}
+		cols := ri.Cols()
+		if len(cols) != 1 {
+			return colInfo{}, fmt.Errorf("ARRAY subquery must have exactly one column, got %d", len(cols))
+		}
+		if cols[0].Type.Array {
+			return colInfo{}, fmt.Errorf("ARRAY subquery can't produce an array of arrays")
+		}
+		t := cols[0].Type
+		t.Array = true
+		return colInfo{Type: t}, nil
 	case spansql.Param:
 		qp, ok := ec.params[string(e)]
 		if !ok {
@@ -954,12 +1370,17 @@ func (ec evalContext) arithColType(ao spansql.ArithOp) (spansql.Type, error) {
 		return spansql.Type{}, fmt.Errorf("can't deduce column type from ArithOp [%s]", ao.SQL())
 	case spansql.Neg, spansql.BitNot:
 		return rhs, nil
-	case spansql.Add, spansql.Sub, spansql.Mul:
-		if lhs == int64Type && rhs == int64Type {
+	case spansql.Add, spansql.Sub, spansql.Mul, spansql.Div:
+		if lhs.Array || rhs.Array {
+			return spansql.Type{}, fmt.Errorf("can't do arithmetic on arrays in [%s]", ao.SQL())
+		}
+		if lhs.Base == spansql.Int64 && rhs.Base == spansql.Int64 && ao.Op != spansql.Div {
 			return int64Type, nil
 		}
-		return float64Type, nil
-	case spansql.Div:
+		// NUMERIC combined with INT64 or NUMERIC yields NUMERIC; with FLOAT64 it yields FLOAT64.
+		if (lhs.Base == spansql.Numeric || rhs.Base == spansql.Numeric) && lhs.Base != spansql.Float64 && rhs.Base != spansql.Float64 {
+			return numericType, nil
+		}
 		return float64Type, nil
 	case spansql.Concat:
 		if !lhs.Array {
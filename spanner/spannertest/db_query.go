@@ -31,16 +31,20 @@ pulling from a table (FROM tbl), filtering (WHERE expr), re-ordering (ORDER BY e
 or other transformations.
 
 The order of operations among those supported by Cloud Spanner is
-	FROM + JOIN + set ops [TODO: set ops]
+	FROM + JOIN
 	WHERE
 	GROUP BY
 	aggregation
 	HAVING [TODO]
 	SELECT
 	DISTINCT
+	set ops
 	ORDER BY
 	OFFSET
 	LIMIT
+
+Subqueries are evaluated as part of expression evaluation, once for each row
+of the enclosing query, since they may refer to that row's columns.
 */
 
 // rowIter represents some iteration over rows of data.
@@ -194,6 +198,8 @@ type selIter struct {
 
 	distinct bool // whether this is a SELECT DISTINCT
 	seen     []row
+
+	asStruct bool // whether this is a SELECT AS STRUCT
 }
 
 func (si *selIter) Cols() []colInfo { return si.cis }
@@ -230,6 +236,10 @@ func (si *selIter) next() (row, error) {
 			out = append(out, v)
 		}
 	}
+	if si.asStruct {
+		// The whole row is the single STRUCT value.
+		out = row{[]interface{}(out)}
+	}
 	return out, nil
 }
 
@@ -295,6 +305,7 @@ type queryParam struct {
 type queryParams map[string]queryParam // TODO: change key to spansql.Param?
 
 type queryContext struct {
+	d      *database
 	params queryParams
 
 	tables     []*table // sorted by name
@@ -317,9 +328,11 @@ func (qc *queryContext) Unlock() {
 	}
 }
 
-func (d *database) Query(q spansql.Query, params queryParams) (ri rowIter, err error) {
+// Query evaluates a query.
+// If tx is non-nil, the query sees any writes made so far in that transaction.
+func (d *database) Query(tx *transaction, q spansql.Query, params queryParams) (ri rowIter, err error) {
 	// Figure out the context of the query and take any required locks.
-	qc, err := d.queryContext(q, params)
+	qc, err := d.queryContext(tx, q, params)
 	if err != nil {
 		return nil, err
 	}
@@ -335,6 +348,15 @@ func (d *database) Query(q spansql.Query, params queryParams) (ri rowIter, err e
 		}()
 	}
 
+	return d.evalQuery(q, qc, nil)
+}
+
+// evalQuery evaluates a query whose tables have been locked.
+// For a subquery, outer is the context of the enclosing query,
+// which supplies the values of correlated column references.
+func (d *database) evalQuery(q spansql.Query, qc *queryContext, outer *evalContext) (rowIter, error) {
+	params := qc.params
+
 	// Prepare auxiliary expressions to evaluate for ORDER BY.
 	var aux []spansql.Expr
 	var desc []bool
@@ -343,14 +365,34 @@ func (d *database) Query(q spansql.Query, params queryParams) (ri rowIter, err e
 		desc = append(desc, o.Desc)
 	}
 
-	si, err := d.evalSelect(q.Select, qc)
+	si, err := d.evalSelect(q.Select, qc, outer)
 	if err != nil {
 		return nil, err
 	}
-	ri = si
+	var ri rowIter = si
 
-	// Apply ORDER BY.
-	if len(q.Order) > 0 {
+	// Apply set operations, and ORDER BY.
+	if len(q.SetOps) > 0 {
+		raw, err := d.evalSetOps(si, q.SetOps, qc, outer)
+		if err != nil {
+			return nil, err
+		}
+		if len(q.Order) > 0 {
+			// The ORDER BY of a compound query can only refer to its output columns.
+			ec := evalContext{cols: raw.cols, params: params, qc: qc, outer: outer}
+			var keys [][]interface{}
+			for _, r := range raw.rows {
+				ec.row = r
+				key, err := ec.evalExprList(aux)
+				if err != nil {
+					return nil, err
+				}
+				keys = append(keys, key)
+			}
+			sort.Sort(externalRowSorter{rows: raw.rows, keys: keys, desc: desc})
+		}
+		ri = raw
+	} else if len(q.Order) > 0 {
 		// Evaluate the selIter completely, and sort the rows by the auxiliary expressions.
 		rows, keys, err := evalSelectOrder(si, aux)
 		if err != nil {
@@ -380,8 +422,79 @@ func (d *database) Query(q spansql.Query, params queryParams) (ri rowIter, err e
 	return ri, nil
 }
 
-func (d *database) queryContext(q spansql.Query, params queryParams) (*queryContext, error) {
+// evalSetOps combines the output of a SELECT with the SELECTs of a sequence of set operations.
+// https://cloud.google.com/spanner/docs/query-syntax#set_operators
+func (d *database) evalSetOps(si *selIter, ops []spansql.SetOperation, qc *queryContext, outer *evalContext) (*rawIter, error) {
+	res, err := toRawIter(si)
+	if err != nil {
+		return nil, err
+	}
+	for _, so := range ops {
+		rsi, err := d.evalSelect(so.Select, qc, outer)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := toRawIter(rsi)
+		if err != nil {
+			return nil, err
+		}
+		// TODO: Check that the column types are compatible.
+		if len(rhs.cols) != len(res.cols) {
+			return nil, fmt.Errorf("queries in set operation have mismatched column count; %d vs %d", len(res.cols), len(rhs.cols))
+		}
+
+		var rows []row
+		switch so.Op {
+		default:
+			return nil, fmt.Errorf("unhandled set operation %d", so.Op)
+		case spansql.Union:
+			rows = append(res.rows, rhs.rows...)
+		case spansql.Intersect, spansql.Except:
+			// With ALL, each RHS row matches at most one LHS row,
+			// so duplicates are retained according to their counts on each side.
+			used := make([]bool, len(rhs.rows))
+			for _, r := range res.rows {
+				found := false
+				for i, r2 := range rhs.rows {
+					if used[i] || !rowEqual(r, r2) {
+						continue
+					}
+					found = true
+					used[i] = so.All
+					break
+				}
+				if found == (so.Op == spansql.Intersect) {
+					rows = append(rows, r)
+				}
+			}
+		}
+		if !so.All {
+			rows = distinctRows(rows)
+		}
+		res = &rawIter{cols: res.cols, rows: rows}
+	}
+	return res, nil
+}
+
+// distinctRows returns the rows with any duplicates removed.
+func distinctRows(rows []row) []row {
+	var out []row
+outer:
+	for _, r := range rows {
+		// Like selIter.keep, this is O(N^2) in the number of rows.
+		for _, prev := range out {
+			if rowEqual(prev, r) {
+				continue outer
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (d *database) queryContext(tx *transaction, q spansql.Query, params queryParams) (*queryContext, error) {
 	qc := &queryContext{
+		d:      d,
 		params: params,
 	}
 
@@ -390,16 +503,30 @@ func (d *database) queryContext(q spansql.Query, params queryParams) (*queryCont
 		if _, ok := qc.tableIndex[name]; ok {
 			return nil // Already found this table.
 		}
-		t, err := d.table(name)
+		t, err := d.tableForTx(tx, name)
 		if err != nil {
 			return err
 		}
+		tx.recordRead(name)
 		if qc.tableIndex == nil {
 			qc.tableIndex = make(map[spansql.ID]*table)
 		}
 		qc.tableIndex[name] = t
 		return nil
 	}
+	// Subqueries may appear in most expressions, and need their tables too.
+	var findQuery func(q spansql.Query) error
+	findExprs := func(exprs ...spansql.Expr) error {
+		for _, e := range exprs {
+			if e == nil {
+				continue
+			}
+			if err := forEachSubquery(e, findQuery); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	var findTables func(sf spansql.SelectFrom) error
 	findTables = func(sf spansql.SelectFrom) error {
 		switch sf := sf.(type) {
@@ -411,16 +538,47 @@ func (d *database) queryContext(q spansql.Query, params queryParams) (*queryCont
 			if err := findTables(sf.LHS); err != nil {
 				return err
 			}
-			return findTables(sf.RHS)
+			if err := findTables(sf.RHS); err != nil {
+				return err
+			}
+			return findExprs(sf.On)
 		case spansql.SelectFromUnnest:
 			// TODO: if array paths get supported, this will need more work.
-			return nil
+			return findExprs(sf.Expr)
 		}
 	}
-	for _, sf := range q.Select.From {
-		if err := findTables(sf); err != nil {
-			return nil, err
+	findSelect := func(sel spansql.Select) error {
+		for _, sf := range sel.From {
+			if err := findTables(sf); err != nil {
+				return err
+			}
+		}
+		if err := findExprs(sel.List...); err != nil {
+			return err
 		}
+		if err := findExprs(sel.Where); err != nil {
+			return err
+		}
+		return findExprs(sel.GroupBy...)
+	}
+	findQuery = func(q spansql.Query) error {
+		if err := findSelect(q.Select); err != nil {
+			return err
+		}
+		for _, so := range q.SetOps {
+			if err := findSelect(so.Select); err != nil {
+				return err
+			}
+		}
+		for _, o := range q.Order {
+			if err := findExprs(o.Expr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := findQuery(q); err != nil {
+		return nil, err
 	}
 
 	// Build qc.tables in name order so we can take locks in a well-defined order.
@@ -436,20 +594,94 @@ func (d *database) queryContext(q spansql.Query, params queryParams) (*queryCont
 	return qc, nil
 }
 
-func (d *database) evalSelect(sel spansql.Select, qc *queryContext) (si *selIter, evalErr error) {
+// forEachSubquery calls f for each subquery in e, excluding those nested in other subqueries.
+func forEachSubquery(e spansql.Expr, f func(spansql.Query) error) error {
+	var sub []spansql.Expr
+	switch e := e.(type) {
+	case spansql.ScalarSubquery:
+		return f(e.Query)
+	case spansql.ExistsOp:
+		return f(e.Query)
+	case spansql.ArraySubquery:
+		return f(e.Query)
+	case spansql.InOp:
+		if e.Query != nil {
+			if err := f(*e.Query); err != nil {
+				return err
+			}
+		}
+		sub = append([]spansql.Expr{e.LHS}, e.RHS...)
+	case spansql.ArithOp:
+		sub = []spansql.Expr{e.LHS, e.RHS}
+	case spansql.LogicalOp:
+		sub = []spansql.Expr{e.LHS, e.RHS}
+	case spansql.ComparisonOp:
+		sub = []spansql.Expr{e.LHS, e.RHS, e.RHS2}
+	case spansql.IsOp:
+		sub = []spansql.Expr{e.LHS}
+	case spansql.Func:
+		sub = e.Args
+	case spansql.TypedExpr:
+		sub = []spansql.Expr{e.Expr}
+	case spansql.ExtractExpr:
+		sub = []spansql.Expr{e.Expr}
+	case spansql.AtTimeZoneExpr:
+		sub = []spansql.Expr{e.Expr}
+	case spansql.IntervalExpr:
+		sub = []spansql.Expr{e.Expr}
+	case spansql.Paren:
+		sub = []spansql.Expr{e.Expr}
+	case spansql.Array:
+		sub = e
+	case spansql.StructLiteral:
+		sub = e.Values
+	case spansql.Case:
+		sub = []spansql.Expr{e.Expr, e.ElseResult}
+		for _, w := range e.WhenClauses {
+			sub = append(sub, w.Cond, w.Result)
+		}
+	case spansql.Coalesce:
+		sub = e.ExprList
+	case spansql.If:
+		sub = []spansql.Expr{e.Expr, e.TrueResult, e.ElseResult}
+	case spansql.IfNull:
+		sub = []spansql.Expr{e.Expr, e.NullResult}
+	case spansql.NullIf:
+		sub = []spansql.Expr{e.Expr, e.ExprToMatch}
+	}
+	for _, e := range sub {
+		if e == nil {
+			continue
+		}
+		if err := forEachSubquery(e, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *database) evalSelect(sel spansql.Select, qc *queryContext, outer *evalContext) (si *selIter, evalErr error) {
 	var ri rowIter = &nullIter{}
 	ec := evalContext{
 		params: qc.params,
+		qc:     qc,
+		outer:  outer,
 	}
 
+	// The SELECT list is modified during aggregation below,
+	// so work on a copy; a subquery may be evaluated many times.
+	sel.List = append([]spansql.Expr(nil), sel.List...)
+
 	// First stage is to identify the data source.
 	// If there's a FROM then that names a table to use.
-	if len(sel.From) > 1 {
-		return nil, fmt.Errorf("selecting with more than one FROM clause not yet supported")
-	}
-	if len(sel.From) == 1 {
+	// Multiple FROM items are an implicit CROSS JOIN.
+	if len(sel.From) > 0 {
+		sf := sel.From[0]
+		for _, rhs := range sel.From[1:] {
+			sf = spansql.SelectFromJoin{Type: spansql.CrossJoin, LHS: sf, RHS: rhs}
+		}
 		var err error
-		ec, ri, err = d.evalSelectFrom(qc, ec, sel.From[0])
+		ec, ri, err = d.evalSelectFrom(qc, ec, sf)
 		if err != nil {
 			return nil, err
 		}
@@ -657,14 +889,23 @@ func (d *database) evalSelect(sel spansql.Select, qc *queryContext) (si *selIter
 		}
 	}
 
-	return &selIter{
+	out := &selIter{
 		ri:   ri,
 		ec:   ec,
 		cis:  colInfos,
 		list: sel.List,
 
 		distinct: sel.Distinct, // Apply DISTINCT.
-	}, nil
+	}
+	if sel.AsStruct {
+		t := spansql.Type{Base: spansql.Struct}
+		for _, ci := range colInfos {
+			t.Fields = append(t.Fields, spansql.StructField{Name: ci.Name, Type: ci.Type})
+		}
+		out.cis = []colInfo{{Type: t}}
+		out.asStruct = true
+	}
+	return out, nil
 }
 
 func (d *database) evalSelectFrom(qc *queryContext, ec evalContext, sf spansql.SelectFrom) (evalContext, rowIter, error) {
@@ -122,7 +122,7 @@ func TestTableDescendingKey(t *testing.T) {
 	if err != nil {
 		t.Fatalf("ParseQuery: %v", err)
 	}
-	ri, err := db.Query(q, nil)
+	ri, err := db.Query(nil, q, nil)
 	if err != nil {
 		t.Fatalf("Query: %v", err)
 	}
@@ -197,7 +197,7 @@ func TestTableSchemaConvertNull(t *testing.T) {
 	if err != nil {
 		t.Fatalf("ParseQuery: %v", err)
 	}
-	ri, err := db.Query(q, nil)
+	ri, err := db.Query(nil, q, nil)
 	if err != nil {
 		t.Fatalf("Query: %v", err)
 	}
@@ -332,7 +332,7 @@ func TestConcurrentReadInsert(t *testing.T) {
 	go func() {
 		defer wg.Done()
 
-		ri, err := db.Query(q, nil)
+		ri, err := db.Query(nil, q, nil)
 		if err != nil {
 			t.Errorf("Query: %v", err)
 			return
@@ -363,6 +363,205 @@ func TestConcurrentReadInsert(t *testing.T) {
 	}
 }
 
+func TestDMLReadYourWrites(t *testing.T) {
+	var db database
+	ddl, err := spansql.ParseDDL("filename", `CREATE TABLE Tablino (A INT64 NOT NULL, B STRING(MAX)) PRIMARY KEY (A)`)
+	if err != nil {
+		t.Fatalf("Bad DDL: %v", err)
+	}
+	if st := db.ApplyDDL(ddl.List[0]); st.Code() != codes.OK {
+		t.Fatalf("Creating table: %v", st.Err())
+	}
+
+	exec := func(tx *transaction, sql string) (int, error) {
+		t.Helper()
+		stmt, err := spansql.ParseDMLStmt(sql)
+		if err != nil {
+			t.Fatalf("ParseDMLStmt(%q): %v", sql, err)
+		}
+		return db.Execute(tx, stmt, nil)
+	}
+	query := func(tx *transaction) [][]interface{} {
+		t.Helper()
+		q, err := spansql.ParseQuery(`SELECT A, B FROM Tablino ORDER BY A`)
+		if err != nil {
+			t.Fatalf("ParseQuery: %v", err)
+		}
+		ri, err := db.Query(tx, q, nil)
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		return slurp(t, ri)
+	}
+
+	tx := db.NewTransaction()
+	n, err := exec(tx, `INSERT INTO Tablino (A, B) VALUES (1, "one"), (2, "two"), (3, "three")`)
+	if err != nil {
+		t.Fatalf("INSERT: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("INSERT affected %d rows, want 3", n)
+	}
+	n, err = exec(tx, `UPDATE Tablino SET B = "TWO" WHERE A = 2`)
+	if err != nil {
+		t.Fatalf("UPDATE: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("UPDATE affected %d rows, want 1", n)
+	}
+	n, err = exec(tx, `DELETE FROM Tablino WHERE A = 3`)
+	if err != nil {
+		t.Fatalf("DELETE: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("DELETE affected %d rows, want 1", n)
+	}
+	// A failed statement must not leave any of its changes behind.
+	if _, err := exec(tx, `INSERT INTO Tablino (A, B) VALUES (4, "four"), (1, "dup")`); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("INSERT of duplicate row: got %v, want AlreadyExists", err)
+	}
+
+	want := [][]interface{}{
+		{int64(1), "one"},
+		{int64(2), "TWO"},
+	}
+	if got := query(tx); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query within transaction:\n got %v\nwant %v", got, want)
+	}
+	if got := query(nil); len(got) != 0 {
+		t.Errorf("Query outside transaction saw uncommitted data: %v", got)
+	}
+
+	tx.Start()
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("Committing changes: %v", err)
+	}
+	if got := query(nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query after commit:\n got %v\nwant %v", got, want)
+	}
+
+	// Rolled back changes should be discarded.
+	tx = db.NewTransaction()
+	if _, err := exec(tx, `INSERT INTO Tablino (A, B) SELECT A + 10, B FROM Tablino`); err != nil {
+		t.Fatalf("INSERT ... SELECT: %v", err)
+	}
+	if got := query(tx); len(got) != 4 {
+		t.Errorf("Query within transaction returned %d rows, want 4", len(got))
+	}
+	tx.Rollback()
+	if got := query(nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query after rollback:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestTransactionConflicts(t *testing.T) {
+	var db database
+	ddl, err := spansql.ParseDDL("filename", `CREATE TABLE Tablino (A INT64 NOT NULL, B INT64) PRIMARY KEY (A)`)
+	if err != nil {
+		t.Fatalf("Bad DDL: %v", err)
+	}
+	if st := db.ApplyDDL(ddl.List[0]); st.Code() != codes.OK {
+		t.Fatalf("Creating table: %v", st.Err())
+	}
+	tx := db.NewTransaction()
+	tx.Start()
+	err = db.Insert(tx, "Tablino", []spansql.ID{"A", "B"}, []*structpb.ListValue{
+		listV(stringV("1"), stringV("10")),
+		listV(stringV("2"), stringV("20")),
+	})
+	if err != nil {
+		t.Fatalf("Inserting data: %v", err)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("Committing changes: %v", err)
+	}
+
+	read := func(tx *transaction, key string) {
+		t.Helper()
+		ri, err := db.Read(tx, "Tablino", []spansql.ID{"B"}, []*structpb.ListValue{listV(stringV(key))}, nil, 0)
+		if err != nil {
+			t.Fatalf("Reading row %s: %v", key, err)
+		}
+		slurp(t, ri)
+	}
+	update := func(tx *transaction, key, value string) {
+		t.Helper()
+		err := db.Update(tx, "Tablino", []spansql.ID{"A", "B"}, []*structpb.ListValue{listV(stringV(key), stringV(value))})
+		if err != nil {
+			t.Fatalf("Updating row %s: %v", key, err)
+		}
+	}
+	commit := func(tx *transaction) error {
+		tx.Start()
+		_, err := tx.Commit()
+		return err
+	}
+
+	// Two transactions that read and write the same row; the second to commit loses.
+	tx1, tx2 := db.NewTransaction(), db.NewTransaction()
+	read(tx1, "1")
+	read(tx2, "1")
+	update(tx1, "1", "11")
+	update(tx2, "1", "12")
+	if err := commit(tx1); err != nil {
+		t.Fatalf("Committing first transaction: %v", err)
+	}
+	if err := commit(tx2); status.Code(err) != codes.Aborted {
+		t.Errorf("Committing conflicting transaction: got %v, want Aborted", err)
+	}
+
+	// Transactions that touch different rows don't conflict.
+	tx1, tx2 = db.NewTransaction(), db.NewTransaction()
+	read(tx1, "1")
+	read(tx2, "2")
+	update(tx1, "1", "13")
+	update(tx2, "2", "21")
+	if err := commit(tx1); err != nil {
+		t.Errorf("Committing first transaction: %v", err)
+	}
+	if err := commit(tx2); err != nil {
+		t.Errorf("Committing non-conflicting transaction: %v", err)
+	}
+
+	// A query reads the whole table, so any write to it is a conflict.
+	q, err := spansql.ParseQuery(`SELECT SUM(B) FROM Tablino`)
+	if err != nil {
+		t.Fatalf("ParseQuery: %v", err)
+	}
+	tx1, tx2 = db.NewTransaction(), db.NewTransaction()
+	ri, err := db.Query(tx1, q, nil)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	slurp(t, ri)
+	update(tx1, "1", "14")
+	update(tx2, "2", "22")
+	if err := commit(tx2); err != nil {
+		t.Fatalf("Committing blind write: %v", err)
+	}
+	if err := commit(tx1); status.Code(err) != codes.Aborted {
+		t.Errorf("Committing transaction with stale query: got %v, want Aborted", err)
+	}
+
+	// The aborted transactions must not have changed anything.
+	tx = db.NewReadOnlyTransaction()
+	ri, err = db.ReadAll(tx, "Tablino", []spansql.ID{"A", "B"}, 0)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	got := slurp(t, ri)
+	want := [][]interface{}{
+		{int64(1), int64(13)},
+		{int64(2), int64(22)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Table contents after conflicts:\n got %v\nwant %v", got, want)
+	}
+	if n := len(db.commits); n != 0 {
+		t.Errorf("%d commit records retained with no live transactions", n)
+	}
+}
+
 func TestGeneratedColumn(t *testing.T) {
 	sql := `CREATE TABLE Songwriters (
 		Id INT64 NOT NULL,
@@ -392,6 +591,7 @@ func TestGeneratedColumn(t *testing.T) {
 	}
 
 	tx := db.NewTransaction()
+	tx.Start()
 	err = db.Insert(tx, "Songwriters",
 		[]spansql.ID{"Id", "Over18"},
 		[]*structpb.ListValue{
@@ -421,7 +621,7 @@ func TestGeneratedColumn(t *testing.T) {
 	}
 
 	var kr keyRangeList
-	iter, err := db.Read("Songwriters", []spansql.ID{"Id", "CanonicalName", "Over18"},
+	iter, err := db.Read(tx, "Songwriters", []spansql.ID{"Id", "CanonicalName", "Over18"},
 		[]*structpb.ListValue{
 			listV(stringV("3")),
 		}, kr, 0)
@@ -436,6 +636,10 @@ func TestGeneratedColumn(t *testing.T) {
 		t.Fatalf("Generated value for Over18 mismatch\n Got: %v\n Want: true", rows[0][2].(bool))
 	}
 
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("Committing changes: %v", err)
+	}
+
 	addColSQL = `ALTER TABLE Songwriters ADD COLUMN Under18 BOOL AS (Age < 18) STORED;`
 	ddl, err = spansql.ParseDDL("filename", addColSQL)
 	if err != nil {
@@ -715,6 +919,105 @@ func TestForeignKeyAddAndAlterConstraint(t *testing.T) {
 	}
 }
 
+func TestConstraintEnforcement(t *testing.T) {
+	var db database
+	ddl, err := spansql.ParseDDL("filename", `CREATE TABLE Customers (
+	  CustomerID INT64 NOT NULL,
+	  Name STRING(MAX),
+	) PRIMARY KEY (CustomerID);
+	CREATE TABLE Orders (
+	  OrderID INT64 NOT NULL,
+	  CustomerID INT64,
+	  Quantity INT64,
+	  CONSTRAINT FK_CustomerOrder FOREIGN KEY (CustomerID) REFERENCES Customers (CustomerID),
+	  CONSTRAINT CK_Quantity CHECK (Quantity > 0),
+	) PRIMARY KEY (OrderID);`)
+	if err != nil {
+		t.Fatalf("Bad DDL: %v", err)
+	}
+	for _, stmt := range ddl.List {
+		if st := db.ApplyDDL(stmt); st.Code() != codes.OK {
+			t.Fatalf("ApplyDDL failed: %v", st)
+		}
+	}
+
+	apply := func(f func(tx *transaction) error) error {
+		t.Helper()
+		tx := db.NewTransaction()
+		tx.Start()
+		if err := f(tx); err != nil {
+			tx.Rollback()
+			t.Fatalf("Writing data: %v", err)
+		}
+		_, err := tx.Commit()
+		return err
+	}
+	insert := func(tbl spansql.ID, cols []spansql.ID, values ...*structpb.ListValue) error {
+		t.Helper()
+		return apply(func(tx *transaction) error {
+			return db.Insert(tx, tbl, cols, values)
+		})
+	}
+	deleteKey := func(tbl spansql.ID, key *structpb.ListValue) error {
+		t.Helper()
+		return apply(func(tx *transaction) error {
+			return db.Delete(tx, tbl, []*structpb.ListValue{key}, nil, false)
+		})
+	}
+	customerCols := []spansql.ID{"CustomerID", "Name"}
+	orderCols := []spansql.ID{"OrderID", "CustomerID", "Quantity"}
+
+	if err := insert("Customers", customerCols, listV(stringV("1"), stringV("Alice"))); err != nil {
+		t.Fatalf("Inserting customer: %v", err)
+	}
+	if err := insert("Orders", orderCols, listV(stringV("100"), stringV("1"), stringV("5"))); err != nil {
+		t.Errorf("Inserting valid order: %v", err)
+	}
+	// NULL values satisfy both constraints.
+	if err := insert("Orders", orderCols, listV(stringV("101"), nullV(), nullV())); err != nil {
+		t.Errorf("Inserting order with NULLs: %v", err)
+	}
+	if err := insert("Orders", orderCols, listV(stringV("102"), stringV("2"), stringV("5"))); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Inserting order for missing customer: got %v, want FailedPrecondition", err)
+	}
+	if err := insert("Orders", orderCols, listV(stringV("103"), stringV("1"), stringV("0"))); status.Code(err) != codes.OutOfRange {
+		t.Errorf("Inserting order with bad quantity: got %v, want OutOfRange", err)
+	}
+	if err := deleteKey("Customers", listV(stringV("1"))); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Deleting referenced customer: got %v, want FailedPrecondition", err)
+	}
+	// A single transaction may insert both rows.
+	err = apply(func(tx *transaction) error {
+		if err := db.Insert(tx, "Orders", orderCols, []*structpb.ListValue{listV(stringV("104"), stringV("2"), stringV("1"))}); err != nil {
+			return err
+		}
+		return db.Insert(tx, "Customers", customerCols, []*structpb.ListValue{listV(stringV("2"), stringV("Bob"))})
+	})
+	if err != nil {
+		t.Errorf("Inserting customer and order together: %v", err)
+	}
+
+	// Rejected commits must not leave any data behind.
+	ri, err := db.ReadAll(nil, "Orders", []spansql.ID{"OrderID"}, 0)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	got := slurp(t, ri)
+	want := [][]interface{}{{int64(100)}, {int64(101)}, {int64(104)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Orders after constraint violations:\n got %v\nwant %v", got, want)
+	}
+	ri, err = db.ReadAll(nil, "Customers", []spansql.ID{"CustomerID"}, 0)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	got = slurp(t, ri)
+	want = [][]interface{}{{int64(1)}, {int64(2)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Customers after constraint violations:\n got %v\nwant %v", got, want)
+	}
+}
+
 func TestAddBackQuoteForHypen(t *testing.T) {
 	ddl, err := spansql.ParseDDL("filename", "ALTER DATABASE `test-db` SET OPTIONS (optimizer_version=4, version_retention_period = '7d', enable_key_visualizer=true)")
 	if err != nil {
@@ -17,8 +17,10 @@ limitations under the License.
 package spannertest
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
+	"math/big"
 	"strconv"
 	"strings"
 	"time"
@@ -90,21 +92,78 @@ var functions = map[string]function{
 	},
 	"JSON_VALUE": {
 		Eval: func(values []interface{}, types []spansql.Type) (interface{}, spansql.Type, error) {
-			if len(values) != 2 {
-				return nil, spansql.Type{}, status.Error(codes.InvalidArgument, "No matching signature for function JSON_VALUE for the given argument types")
+			v, ok, err := jsonFuncArgs("JSON_VALUE", values)
+			if err != nil || !ok {
+				return nil, spansql.Type{Base: spansql.String}, err
 			}
-			if values[0] == nil || values[1] == nil {
-				return nil, spansql.Type{Base: spansql.String}, nil
+			// Only scalars are extracted; objects, arrays and JSON null yield NULL.
+			switch v := v.(type) {
+			case string:
+				return v, spansql.Type{Base: spansql.String}, nil
+			case json.Number:
+				return string(v), spansql.Type{Base: spansql.String}, nil
+			case bool:
+				return strconv.FormatBool(v), spansql.Type{Base: spansql.String}, nil
 			}
-			_, okArg1 := values[0].(string)
-			_, okArg2 := values[1].(string)
-			if !(okArg1 && okArg2) {
-				return nil, spansql.Type{}, status.Error(codes.InvalidArgument, "No matching signature for function JSON_VALUE for the given argument types")
+			return nil, spansql.Type{Base: spansql.String}, nil
+		},
+	},
+	"JSON_QUERY": {
+		Eval: func(values []interface{}, types []spansql.Type) (interface{}, spansql.Type, error) {
+			// The result has the same type as the input document.
+			typ := spansql.Type{Base: spansql.JSON}
+			if len(values) > 0 {
+				if _, ok := values[0].(string); ok {
+					typ.Base = spansql.String
+				}
 			}
-			// This function currently has no implementation and always returns
-			// an empty string, as it would otherwise require an XPath query
-			// engine.
-			return "", spansql.Type{Base: spansql.String}, nil
+			v, ok, err := jsonFuncArgs("JSON_QUERY", values)
+			if err != nil || !ok {
+				return nil, typ, err
+			}
+			j, err := encodeJSON(v)
+			if err != nil {
+				return nil, spansql.Type{}, status.Errorf(codes.Internal, "encoding JSON: %v", err)
+			}
+			if typ.Base == spansql.String {
+				return string(j), typ, nil
+			}
+			return j, typ, nil
+		},
+	},
+	"PARSE_JSON": {
+		Eval: func(values []interface{}, types []spansql.Type) (interface{}, spansql.Type, error) {
+			if len(values) != 1 {
+				return nil, spansql.Type{}, status.Error(codes.InvalidArgument, "No matching signature for function PARSE_JSON for the given argument types")
+			}
+			if values[0] == nil {
+				return nil, jsonType, nil
+			}
+			s, ok := values[0].(string)
+			if !ok {
+				return nil, spansql.Type{}, status.Error(codes.InvalidArgument, "No matching signature for function PARSE_JSON for the given argument types")
+			}
+			j, err := parseAsJSON(s)
+			if err != nil {
+				return nil, spansql.Type{}, status.Errorf(codes.InvalidArgument, "invalid input to PARSE_JSON: %v", err)
+			}
+			return j, jsonType, nil
+		},
+	},
+	"TO_JSON_STRING": {
+		Eval: func(values []interface{}, types []spansql.Type) (interface{}, spansql.Type, error) {
+			if len(values) != 1 {
+				return nil, spansql.Type{}, status.Error(codes.InvalidArgument, "No matching signature for function TO_JSON_STRING for the given argument types")
+			}
+			v, err := toJSON(values[0])
+			if err != nil {
+				return nil, spansql.Type{}, err
+			}
+			j, err := encodeJSON(v)
+			if err != nil {
+				return nil, spansql.Type{}, status.Errorf(codes.Internal, "encoding JSON: %v", err)
+			}
+			return string(j), stringType, nil
 		},
 	},
 	"EXTRACT": {
@@ -211,6 +270,7 @@ func convert(val interface{}, tp spansql.Type) (interface{}, error) {
 	case spansql.Timestamp:
 		res, convertErr, err = convertToTimestamp(val)
 	case spansql.Numeric:
+		res, convertErr, err = convertToNumeric(val)
 	case spansql.JSON:
 	}
 	if err != nil {
@@ -230,6 +290,13 @@ func convertToInt64(val interface{}) (res int64, convertErr error, err error) {
 	switch v := val.(type) {
 	case int64:
 		return v, nil, nil
+	case *big.Rat:
+		// Round half away from zero.
+		i, ok := new(big.Int).SetString(v.FloatString(0), 10)
+		if !ok || !i.IsInt64() {
+			return 0, status.Errorf(codes.OutOfRange, "INT64 overflow: %s", numericString(v)), nil
+		}
+		return i.Int64(), nil, nil
 	case string:
 		res, err := strconv.ParseInt(v, 10, 64)
 		if err != nil {
@@ -246,6 +313,9 @@ func convertToFloat64(val interface{}) (res float64, convertErr error, err error
 		return float64(v), nil, nil
 	case float64:
 		return v, nil, nil
+	case *big.Rat:
+		f, _ := v.Float64()
+		return f, nil, nil
 	case string:
 		res, err := strconv.ParseFloat(v, 64)
 		if err != nil {
@@ -262,6 +332,8 @@ func convertToString(val interface{}) (res string, convertErr error, err error)
 		return v, nil, nil
 	case bool, int64, float64:
 		return fmt.Sprintf("%v", v), nil, nil
+	case *big.Rat:
+		return numericString(v), nil, nil
 	case civil.Date:
 		return v.String(), nil, nil
 	case time.Time:
@@ -312,6 +384,169 @@ func convertToTimestamp(val interface{}) (res time.Time, convertErr error, err e
 	return time.Time{}, nil, status.Errorf(codes.Unimplemented, "unsupported conversion for %v to TIMESTAMP", val)
 }
 
+func convertToNumeric(val interface{}) (res *big.Rat, convertErr error, err error) {
+	var r *big.Rat
+	switch v := val.(type) {
+	case *big.Rat:
+		return v, nil, nil
+	case int64:
+		r = new(big.Rat).SetInt64(v)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid value for NUMERIC: %v", v), nil
+		}
+		r = new(big.Rat).SetFloat64(v)
+	case string:
+		var ok bool
+		if r, ok = new(big.Rat).SetString(strings.TrimSpace(v)); !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid value for NUMERIC: %q", v), nil
+		}
+	default:
+		return nil, nil, status.Errorf(codes.Unimplemented, "unsupported conversion for %v to NUMERIC", val)
+	}
+	res, convertErr = roundNumeric(r)
+	return res, convertErr, nil
+}
+
+// jsonFuncArgs checks the arguments of a JSON function that takes a document
+// (either JSON or a STRING holding JSON) and an optional JSONPath, which defaults to "$".
+// It returns the selected part of the document, and whether there is one;
+// a NULL argument or a path that matches nothing both report false.
+func jsonFuncArgs(name string, values []interface{}) (v interface{}, ok bool, err error) {
+	if len(values) < 1 || len(values) > 2 {
+		return nil, false, status.Errorf(codes.InvalidArgument, "No matching signature for function %s for the given argument types", name)
+	}
+	path := "$"
+	if len(values) == 2 {
+		if values[1] == nil {
+			return nil, false, nil
+		}
+		p, ok := values[1].(string)
+		if !ok {
+			return nil, false, status.Errorf(codes.InvalidArgument, "No matching signature for function %s for the given argument types", name)
+		}
+		path = p
+	}
+	var doc string
+	switch d := values[0].(type) {
+	case nil:
+		return nil, false, nil
+	case jsonValue:
+		doc = string(d)
+	case string:
+		doc = d
+	default:
+		return nil, false, status.Errorf(codes.InvalidArgument, "No matching signature for function %s for the given argument types", name)
+	}
+	dv, err := decodeJSON(doc)
+	if err != nil {
+		// A STRING document that isn't valid JSON yields NULL.
+		return nil, false, nil
+	}
+	return evalJSONPath(dv, path)
+}
+
+// evalJSONPath returns the part of a decoded JSON value selected by a JSONPath,
+// and whether that part exists. It supports the subset of JSONPath that Spanner does:
+// "$" followed by member accesses (".name", ".\"quoted name\"", "['name']")
+// and array subscripts ("[0]").
+func evalJSONPath(v interface{}, path string) (interface{}, bool, error) {
+	badPath := func() error {
+		return status.Errorf(codes.InvalidArgument, "invalid JSONPath %q", path)
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, false, badPath()
+	}
+	p := path[1:]
+	for p != "" {
+		var member string
+		isMember := false
+		switch p[0] {
+		default:
+			return nil, false, badPath()
+		case '.':
+			p = p[1:]
+			if strings.HasPrefix(p, `"`) {
+				end := strings.Index(p[1:], `"`)
+				if end < 0 {
+					return nil, false, badPath()
+				}
+				member, p = p[1:end+1], p[end+2:]
+			} else {
+				end := strings.IndexAny(p, ".[")
+				if end < 0 {
+					end = len(p)
+				}
+				member, p = p[:end], p[end:]
+			}
+			if member == "" {
+				return nil, false, badPath()
+			}
+			isMember = true
+		case '[':
+			end := strings.Index(p, "]")
+			if end < 0 {
+				return nil, false, badPath()
+			}
+			sub := strings.TrimSpace(p[1:end])
+			p = p[end+1:]
+			if len(sub) >= 2 && (sub[0] == '\'' || sub[0] == '"') && sub[len(sub)-1] == sub[0] {
+				member, isMember = sub[1:len(sub)-1], true
+				break
+			}
+			n, err := strconv.Atoi(sub)
+			if err != nil || n < 0 {
+				return nil, false, badPath()
+			}
+			arr, ok := v.([]interface{})
+			if !ok || n >= len(arr) {
+				return nil, false, nil
+			}
+			v = arr[n]
+		}
+		if isMember {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false, nil
+			}
+			if v, ok = obj[member]; !ok {
+				return nil, false, nil
+			}
+		}
+	}
+	return v, true, nil
+}
+
+// toJSON converts a value to a form suitable for encodeJSON.
+func toJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, bool, string:
+		return v, nil
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			// JSON has no representation for these; Spanner uses strings.
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64)), nil
+	case *big.Rat:
+		return json.Number(numericString(v)), nil
+	case jsonValue:
+		return decodeJSON(string(v))
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, x := range v {
+			var err error
+			if arr[i], err = toJSON(x); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return nil, status.Errorf(codes.Unimplemented, "TO_JSON_STRING of %T is not implemented", v)
+}
+
 type aggregateFunc struct {
 	// Whether the function can take a * arg (only COUNT).
 	AcceptStar bool
@@ -374,8 +609,16 @@ var aggregateFuncs = map[string]aggregateFunc{
 	}},
 	"SUM": {
 		Eval: func(values []interface{}, typ spansql.Type) (interface{}, spansql.Type, error) {
-			if typ.Array || !(typ.Base == spansql.Int64 || typ.Base == spansql.Float64) {
-				return nil, spansql.Type{}, fmt.Errorf("SUM only supports arguments of INT64 or FLOAT64 type, not %s", typ.SQL())
+			if typ.Array || !(typ.Base == spansql.Int64 || typ.Base == spansql.Float64 || typ.Base == spansql.Numeric) {
+				return nil, spansql.Type{}, fmt.Errorf("SUM only supports arguments of INT64, FLOAT64 or NUMERIC type, not %s", typ.SQL())
+			}
+			if typ.Base == spansql.Numeric {
+				sum, n, err := sumNumeric(values)
+				if err != nil || n == 0 {
+					// "Returns NULL if the input contains only NULLs".
+					return nil, typ, err
+				}
+				return sum, typ, nil
 			}
 			if typ.Base == spansql.Int64 {
 				var seen bool
@@ -411,8 +654,17 @@ var aggregateFuncs = map[string]aggregateFunc{
 	},
 	"AVG": {
 		Eval: func(values []interface{}, typ spansql.Type) (interface{}, spansql.Type, error) {
-			if typ.Array || !(typ.Base == spansql.Int64 || typ.Base == spansql.Float64) {
-				return nil, spansql.Type{}, fmt.Errorf("AVG only supports arguments of INT64 or FLOAT64 type, not %s", typ.SQL())
+			if typ.Array || !(typ.Base == spansql.Int64 || typ.Base == spansql.Float64 || typ.Base == spansql.Numeric) {
+				return nil, spansql.Type{}, fmt.Errorf("AVG only supports arguments of INT64, FLOAT64 or NUMERIC type, not %s", typ.SQL())
+			}
+			if typ.Base == spansql.Numeric {
+				sum, n, err := sumNumeric(values)
+				if err != nil || n == 0 {
+					// "Returns NULL if the input contains only NULLs".
+					return nil, typ, err
+				}
+				avg, err := roundNumeric(sum.Quo(sum, new(big.Rat).SetInt64(n)))
+				return avg, typ, err
 			}
 			if typ.Base == spansql.Int64 {
 				var sum int64
@@ -475,3 +727,24 @@ func evalMinMax(name string, isMin bool, values []interface{}, typ spansql.Type)
 	}
 	return minMax, typ, nil
 }
+
+// sumNumeric returns the sum of the non-NULL NUMERIC values, and how many there were.
+func sumNumeric(values []interface{}) (*big.Rat, int64, error) {
+	sum := new(big.Rat)
+	var n int64
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		sum.Add(sum, v.(*big.Rat))
+		n++
+	}
+	if n == 0 {
+		return nil, 0, nil
+	}
+	// The sum may not exceed the NUMERIC range.
+	if _, err := roundNumeric(sum); err != nil {
+		return nil, 0, err
+	}
+	return sum, n, nil
+}
@@ -53,6 +53,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/big"
 	"math/rand"
 	"net"
 	"strconv"
@@ -97,9 +98,10 @@ type server struct {
 	db    database
 	start time.Time
 
-	mu       sync.Mutex
-	sessions map[string]*session
-	lros     map[string]*lro
+	mu           sync.Mutex
+	sessions     map[string]*session
+	lros         map[string]*lro
+	abortCommits int // number of upcoming commits to abort
 
 	// Any unimplemented methods will cause a panic.
 	// TODO: Switch to Unimplemented at some point? spannerpb would need regenerating.
@@ -220,6 +222,16 @@ func NewServer(laddr string) (*Server, error) {
 // from the execution of the server.
 func (s *Server) SetLogger(l Logger) { s.s.logf = l }
 
+// AbortNextCommit causes the next commit of a read-write transaction to fail
+// with codes.Aborted, as if it had lost a conflict with another transaction.
+// Each call aborts one more commit. This is useful for checking that
+// transaction functions behave correctly when they are retried.
+func (s *Server) AbortNextCommit() {
+	s.s.mu.Lock()
+	s.s.abortCommits++
+	s.s.mu.Unlock()
+}
+
 // Close shuts down the server.
 func (s *Server) Close() {
 	s.srv.Stop()
@@ -415,6 +427,14 @@ func (s *server) DeleteSession(ctx context.Context, req *spannerpb.DeleteSession
 	// Terminate any operations in this session.
 	sess.cancel()
 
+	// Roll back any transactions left in this session.
+	sess.mu.Lock()
+	for _, tx := range sess.transactions {
+		tx.Rollback()
+	}
+	sess.transactions = nil
+	sess.mu.Unlock()
+
 	return &emptypb.Empty{}, nil
 }
 
@@ -501,43 +521,86 @@ func (s *server) ExecuteSql(ctx context.Context, req *spannerpb.ExecuteSqlReques
 
 	// If it is a single-use transaction we assume it is a query.
 	if req.Transaction.GetSelector() == nil || req.Transaction.GetSingleUse().GetReadOnly() != nil {
-		ri, err := s.executeQuery(req)
+		ri, err := s.executeQuery(nil, req)
 		if err != nil {
 			return nil, err
 		}
 		return s.resultSet(ri)
 	}
 
-	obj, ok := req.Transaction.Selector.(*spannerpb.TransactionSelector_Id)
-	if !ok {
-		return nil, fmt.Errorf("unsupported transaction type %T", req.Transaction.Selector)
+	tx, err := s.dmlTx(ctx, req.Session, req.Transaction)
+	if err != nil {
+		return nil, err
 	}
-	tid := string(obj.Id)
-	_ = tid // TODO: lookup an existing transaction by ID.
+	n, err := s.executeDML(tx, req.Sql, req.GetParams(), req.ParamTypes)
+	if err != nil {
+		return nil, err
+	}
+	return &spannerpb.ResultSet{
+		Stats: &spannerpb.ResultSetStats{
+			RowCount: &spannerpb.ResultSetStats_RowCountExact{int64(n)},
+		},
+	}, nil
+}
 
-	stmt, err := spansql.ParseDMLStmt(req.Sql)
+func (s *server) ExecuteBatchDml(ctx context.Context, req *spannerpb.ExecuteBatchDmlRequest) (*spannerpb.ExecuteBatchDmlResponse, error) {
+	tx, err := s.dmlTx(ctx, req.Session, req.Transaction)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "bad DML: %v", err)
+		return nil, err
 	}
-	params, err := parseQueryParams(req.GetParams(), req.ParamTypes)
+
+	resp := &spannerpb.ExecuteBatchDmlResponse{}
+	for _, stmt := range req.Statements {
+		n, err := s.executeDML(tx, stmt.Sql, stmt.GetParams(), stmt.ParamTypes)
+		if err != nil {
+			// Execution stops at the first failed statement. The changes made
+			// by the earlier statements are kept, and the error is reported
+			// in the response rather than failing the RPC.
+			resp.Status = status.Convert(err).Proto()
+			return resp, nil
+		}
+		resp.ResultSets = append(resp.ResultSets, &spannerpb.ResultSet{
+			Stats: &spannerpb.ResultSetStats{
+				RowCount: &spannerpb.ResultSetStats_RowCountExact{int64(n)},
+			},
+		})
+	}
+	resp.Status = status.New(codes.OK, "").Proto()
+	return resp, nil
+}
+
+// dmlTx returns the read-write transaction for the given session and transaction selector.
+// It is used by DML operations (ExecuteSql, ExecuteBatchDml).
+func (s *server) dmlTx(ctx context.Context, session string, tsel *spannerpb.TransactionSelector) (*transaction, error) {
+	if _, ok := tsel.GetSelector().(*spannerpb.TransactionSelector_Id); !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "DML requires a read-write transaction, got selector type %T", tsel.GetSelector())
+	}
+	tx, _, err := s.readTx(ctx, session, tsel)
 	if err != nil {
 		return nil, err
 	}
+	if err := tx.checkMutable(); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (s *server) executeDML(tx *transaction, sql string, p *structpb.Struct, types map[string]*spannerpb.Type) (int, error) {
+	stmt, err := spansql.ParseDMLStmt(sql)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "bad DML: %v", err)
+	}
+	params, err := parseQueryParams(p, types)
+	if err != nil {
+		return 0, err
+	}
 
 	s.logf("Executing: %s", stmt.SQL())
 	if len(params) > 0 {
 		s.logf("        ▹ %v", params)
 	}
 
-	n, err := s.db.Execute(stmt, params)
-	if err != nil {
-		return nil, err
-	}
-	return &spannerpb.ResultSet{
-		Stats: &spannerpb.ResultSetStats{
-			RowCount: &spannerpb.ResultSetStats_RowCountExact{int64(n)},
-		},
-	}, nil
+	return s.db.Execute(tx, stmt, params)
 }
 
 func (s *server) ExecuteStreamingSql(req *spannerpb.ExecuteSqlRequest, stream spannerpb.Spanner_ExecuteStreamingSqlServer) error {
@@ -547,14 +610,14 @@ func (s *server) ExecuteStreamingSql(req *spannerpb.ExecuteSqlRequest, stream sp
 	}
 	defer cleanup()
 
-	ri, err := s.executeQuery(req)
+	ri, err := s.executeQuery(tx, req)
 	if err != nil {
 		return err
 	}
 	return s.readStream(stream.Context(), tx, stream.Send, ri)
 }
 
-func (s *server) executeQuery(req *spannerpb.ExecuteSqlRequest) (ri rowIter, err error) {
+func (s *server) executeQuery(tx *transaction, req *spannerpb.ExecuteSqlRequest) (ri rowIter, err error) {
 	q, err := spansql.ParseQuery(req.Sql)
 	if err != nil {
 		// TODO: check what code the real Spanner returns here.
@@ -571,7 +634,7 @@ func (s *server) executeQuery(req *spannerpb.ExecuteSqlRequest) (ri rowIter, err
 		s.logf("        ▹ %v", params)
 	}
 
-	return s.db.Query(q, params)
+	return s.db.Query(tx, q, params)
 }
 
 // TODO: Read
@@ -599,10 +662,10 @@ func (s *server) StreamingRead(req *spannerpb.ReadRequest, stream spannerpb.Span
 	var ri rowIter
 	if req.KeySet.All {
 		s.logf("Reading all from %s (cols: %v)", req.Table, req.Columns)
-		ri, err = s.db.ReadAll(spansql.ID(req.Table), idList(req.Columns), req.Limit)
+		ri, err = s.db.ReadAll(tx, spansql.ID(req.Table), idList(req.Columns), req.Limit)
 	} else {
 		s.logf("Reading rows from %d keys and %d ranges from %s (cols: %v)", len(req.KeySet.Keys), len(req.KeySet.Ranges), req.Table, req.Columns)
-		ri, err = s.db.Read(spansql.ID(req.Table), idList(req.Columns), req.KeySet.Keys, makeKeyRangeList(req.KeySet.Ranges), req.Limit)
+		ri, err = s.db.Read(tx, spansql.ID(req.Table), idList(req.Columns), req.KeySet.Keys, makeKeyRangeList(req.KeySet.Ranges), req.Limit)
 	}
 	if err != nil {
 		return err
@@ -749,6 +812,17 @@ func (s *server) Commit(ctx context.Context, req *spannerpb.CommitRequest) (resp
 			tx.Rollback()
 		}
 	}()
+
+	s.mu.Lock()
+	abort := s.abortCommits > 0
+	if abort {
+		s.abortCommits--
+	}
+	s.mu.Unlock()
+	if abort {
+		return nil, status.Errorf(codes.Aborted, "transaction was aborted by AbortNextCommit")
+	}
+
 	tx.Start()
 
 	for _, m := range req.Mutations {
@@ -847,6 +921,18 @@ func parseQueryParam(v *structpb.Value, typ *spannerpb.Type) (queryParam, error)
 		}
 		return queryParam{Value: val, Type: t}, nil
 	case *structpb.Value_ListValue:
+		if typ.GetCode() == spannerpb.TypeCode_STRUCT || typ.GetArrayElementType().GetCode() == spannerpb.TypeCode_STRUCT {
+			// Struct fields need their declared types to be parsed correctly.
+			t, err := typeFromSpannerType(typ)
+			if err != nil {
+				return queryParam{}, err
+			}
+			val, err := valForType(rawv, t)
+			if err != nil {
+				return queryParam{}, err
+			}
+			return queryParam{Value: val, Type: t}, nil
+		}
 		var list []interface{}
 		for _, elem := range v.ListValue.Values {
 			// TODO: Change the type parameter passed through? We only look at the code.
@@ -882,6 +968,20 @@ func typeFromSpannerType(st *spannerpb.Type) (spansql.Type, error) {
 		return spansql.Type{Base: spansql.String}, nil // no len
 	case spannerpb.TypeCode_BYTES:
 		return spansql.Type{Base: spansql.Bytes}, nil // no len
+	case spannerpb.TypeCode_NUMERIC:
+		return spansql.Type{Base: spansql.Numeric}, nil
+	case spannerpb.TypeCode_JSON:
+		return spansql.Type{Base: spansql.JSON}, nil
+	case spannerpb.TypeCode_STRUCT:
+		typ := spansql.Type{Base: spansql.Struct}
+		for _, f := range st.GetStructType().GetFields() {
+			ft, err := typeFromSpannerType(f.Type)
+			if err != nil {
+				return spansql.Type{}, err
+			}
+			typ.Fields = append(typ.Fields, spansql.StructField{Name: spansql.ID(f.Name), Type: ft})
+		}
+		return typ, nil
 	case spannerpb.TypeCode_ARRAY:
 		typ, err := typeFromSpannerType(st.ArrayElementType)
 		if err != nil {
@@ -911,8 +1011,27 @@ func spannerTypeFromType(typ spansql.Type) (*spannerpb.Type, error) {
 		code = spannerpb.TypeCode_DATE
 	case spansql.Timestamp:
 		code = spannerpb.TypeCode_TIMESTAMP
+	case spansql.Numeric:
+		code = spannerpb.TypeCode_NUMERIC
+	case spansql.JSON:
+		code = spannerpb.TypeCode_JSON
+	case spansql.Struct:
+		code = spannerpb.TypeCode_STRUCT
 	}
 	st := &spannerpb.Type{Code: code}
+	if typ.Base == spansql.Struct {
+		st.StructType = &spannerpb.StructType{}
+		for _, f := range typ.Fields {
+			ft, err := spannerTypeFromType(f.Type)
+			if err != nil {
+				return nil, err
+			}
+			st.StructType.Fields = append(st.StructType.Fields, &spannerpb.StructType_Field{
+				Name: string(f.Name),
+				Type: ft,
+			})
+		}
+	}
 	if typ.Array {
 		st = &spannerpb.Type{
 			Code:             spannerpb.TypeCode_ARRAY,
@@ -938,6 +1057,10 @@ func spannerValueFromValue(x interface{}) (*structpb.Value, error) {
 		return &structpb.Value{Kind: &structpb.Value_StringValue{x}}, nil
 	case []byte:
 		return &structpb.Value{Kind: &structpb.Value_StringValue{base64.StdEncoding.EncodeToString(x)}}, nil
+	case *big.Rat:
+		return &structpb.Value{Kind: &structpb.Value_StringValue{numericString(x)}}, nil
+	case jsonValue:
+		return &structpb.Value{Kind: &structpb.Value_StringValue{string(x)}}, nil
 	case civil.Date:
 		// RFC 3339 date format.
 		return &structpb.Value{Kind: &structpb.Value_StringValue{x.String()}}, nil
@@ -28,8 +28,11 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"math/big"
 	"reflect"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -45,6 +48,7 @@ import (
 
 	dbadminpb "cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
 	"cloud.google.com/go/spanner/apiv1/spannerpb"
+	"cloud.google.com/go/spanner/spansql"
 	structpb "google.golang.org/protobuf/types/known/structpb"
 )
 
@@ -755,6 +759,13 @@ func TestIntegration_ReadsAndQueries(t *testing.T) {
 	}
 	rows.Stop()
 
+	rows = client.Single().Query(ctx, spanner.NewStatement("SELECT (SELECT y FROM JoinB)"))
+	_, err = rows.Next()
+	if g, w := spanner.ErrCode(err), codes.OutOfRange; g != w {
+		t.Errorf("error code mismatch for scalar subquery with many rows\n Got: %v\nWant: %v", g, w)
+	}
+	rows.Stop()
+
 	// Do some complex queries.
 	tests := []struct {
 		q      string
@@ -1148,6 +1159,125 @@ func TestIntegration_ReadsAndQueries(t *testing.T) {
 				{"a2", "b1", "c2"},
 			},
 		},
+		{
+			`SELECT a, b, d FROM JoinA JOIN JoinB ON JoinA.w = JoinB.y JOIN JoinD ON JoinD.x = JoinB.y AND JoinD.z = JoinB.z ORDER BY a, b`,
+			nil,
+			[][]interface{}{
+				{"a2", "b1", "d1"},
+				{"a3", "b2", "d2"},
+				{"a3", "b3", "d3"},
+				{"a4", "b2", "d2"},
+				{"a4", "b3", "d3"},
+			},
+		},
+		{
+			`SELECT a, b FROM JoinA, JoinB WHERE JoinA.w = JoinB.y AND JoinA.x = "b"`,
+			nil,
+			[][]interface{}{
+				{"a2", "b1"},
+			},
+		},
+		// Subqueries.
+		{
+			`SELECT a FROM JoinA WHERE w IN (SELECT y FROM JoinB) ORDER BY a`,
+			nil,
+			[][]interface{}{
+				{"a2"},
+				{"a3"},
+				{"a4"},
+			},
+		},
+		{
+			`SELECT a FROM JoinA WHERE w NOT IN (SELECT y FROM JoinB)`,
+			nil,
+			[][]interface{}{
+				{"a1"},
+			},
+		},
+		{
+			`SELECT b FROM JoinB WHERE EXISTS (SELECT 1 FROM JoinA WHERE JoinA.w = JoinB.y) ORDER BY b`,
+			nil,
+			[][]interface{}{
+				{"b1"},
+				{"b2"},
+				{"b3"},
+			},
+		},
+		{
+			`SELECT b FROM JoinB WHERE NOT EXISTS (SELECT 1 FROM JoinA WHERE JoinA.w = JoinB.y)`,
+			nil,
+			[][]interface{}{
+				{"b4"},
+			},
+		},
+		{
+			`SELECT a, (SELECT COUNT(*) FROM JoinB WHERE JoinB.y = JoinA.w) FROM JoinA ORDER BY a`,
+			nil,
+			[][]interface{}{
+				{"a1", int64(0)},
+				{"a2", int64(1)},
+				{"a3", int64(2)},
+				{"a4", int64(2)},
+			},
+		},
+		{
+			`SELECT (SELECT MAX(y) FROM JoinB), (SELECT b FROM JoinB WHERE y = 9)`,
+			nil,
+			[][]interface{}{
+				{int64(4), nil},
+			},
+		},
+		// Set operations.
+		{
+			`SELECT w FROM JoinA UNION ALL SELECT y FROM JoinB ORDER BY w`,
+			nil,
+			[][]interface{}{
+				{int64(1)},
+				{int64(2)},
+				{int64(2)},
+				{int64(3)},
+				{int64(3)},
+				{int64(3)},
+				{int64(3)},
+				{int64(4)},
+			},
+		},
+		{
+			`SELECT w FROM JoinA UNION DISTINCT SELECT y FROM JoinB UNION DISTINCT SELECT 7 ORDER BY w DESC`,
+			nil,
+			[][]interface{}{
+				{int64(7)},
+				{int64(4)},
+				{int64(3)},
+				{int64(2)},
+				{int64(1)},
+			},
+		},
+		{
+			`SELECT w FROM JoinA INTERSECT ALL SELECT y FROM JoinB ORDER BY w`,
+			nil,
+			[][]interface{}{
+				{int64(2)},
+				{int64(3)},
+				{int64(3)},
+			},
+		},
+		{
+			`SELECT w FROM JoinA EXCEPT DISTINCT SELECT y FROM JoinB`,
+			nil,
+			[][]interface{}{
+				{int64(1)},
+			},
+		},
+		{
+			`SELECT y FROM JoinB EXCEPT ALL SELECT w FROM JoinA WHERE w < 3 ORDER BY y`,
+			nil,
+			[][]interface{}{
+				{int64(3)},
+				{int64(3)},
+				{int64(4)},
+			},
+		},
 		// Check the output of the UPDATE DML.
 		{
 			`SELECT id, first, last FROM Updateable ORDER BY id`,
@@ -1416,6 +1546,451 @@ func TestIntegration_GeneratedColumns(t *testing.T) {
 	}
 }
 
+func TestIntegration_DML(t *testing.T) {
+	client, adminClient, _, cleanup := makeClient(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := dropTable(t, adminClient, "Accounts"); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateDDL(t, adminClient,
+		`CREATE TABLE Accounts (
+			ID INT64 NOT NULL,
+			Owner STRING(MAX),
+			Balance INT64,
+		) PRIMARY KEY (ID)`); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+
+	// Changes made by DML should be visible to later statements and queries in the same transaction.
+	var counts []int64
+	var inTx [][]interface{}
+	_, err := client.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+		n, err := tx.Update(ctx, spanner.NewStatement(`INSERT INTO Accounts (ID, Owner, Balance) VALUES (1, "alice", 100), (2, "bob", 50)`))
+		if err != nil {
+			return err
+		}
+		counts = append(counts, n)
+		bc, err := tx.BatchUpdate(ctx, []spanner.Statement{
+			spanner.NewStatement(`INSERT INTO Accounts (ID, Owner, Balance) SELECT ID + 10, Owner, 0 FROM Accounts`),
+			spanner.NewStatement(`UPDATE Accounts SET Balance = Balance - 30 WHERE ID = 1`),
+			spanner.NewStatement(`UPDATE Accounts SET Balance = Balance + 30 WHERE ID = 2`),
+			spanner.NewStatement(`DELETE FROM Accounts WHERE ID = 12`),
+		})
+		if err != nil {
+			return err
+		}
+		counts = append(counts, bc...)
+		inTx, err = slurpRows(t, tx.Query(ctx, spanner.NewStatement(`SELECT ID, Balance FROM Accounts ORDER BY ID`)))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Running DML: %v", err)
+	}
+	if want := []int64{2, 2, 1, 1, 1}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("DML row counts mismatch\n Got: %v\nWant: %v", counts, want)
+	}
+	want := [][]interface{}{
+		{int64(1), int64(70)},
+		{int64(2), int64(80)},
+		{int64(11), int64(0)},
+	}
+	if !reflect.DeepEqual(inTx, want) {
+		t.Errorf("Query within transaction mismatch\n Got: %v\nWant: %v", inTx, want)
+	}
+	got := mustSlurpRows(t, client.Single().Query(ctx, spanner.NewStatement(`SELECT ID, Balance FROM Accounts ORDER BY ID`)))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Query after commit mismatch\n Got: %v\nWant: %v", got, want)
+	}
+
+	// A failing statement in a batch reports the counts of the statements before it.
+	_, err = client.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+		counts, err = tx.BatchUpdate(ctx, []spanner.Statement{
+			spanner.NewStatement(`UPDATE Accounts SET Balance = 0 WHERE ID = 1`),
+			spanner.NewStatement(`INSERT INTO Accounts (ID, Owner, Balance) VALUES (2, "mallory", 1000)`),
+		})
+		return err
+	})
+	if g, w := spanner.ErrCode(err), codes.AlreadyExists; g != w {
+		t.Errorf("Batch DML with duplicate insert error code mismatch\n Got: %v\nWant: %v", g, w)
+	}
+	if want := []int64{1}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("Batch DML row counts mismatch\n Got: %v\nWant: %v", counts, want)
+	}
+	// The transaction failed, so nothing should have changed.
+	got = mustSlurpRows(t, client.Single().Query(ctx, spanner.NewStatement(`SELECT ID, Balance FROM Accounts ORDER BY ID`)))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Query after failed transaction mismatch\n Got: %v\nWant: %v", got, want)
+	}
+}
+
+func TestIntegration_TransactionConflicts(t *testing.T) {
+	client, adminClient, _, cleanup := makeClient(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := dropTable(t, adminClient, "Counters"); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateDDL(t, adminClient, `CREATE TABLE Counters ( Name STRING(MAX), Value INT64 ) PRIMARY KEY (Name)`); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	if _, err := client.Apply(ctx, []*spanner.Mutation{
+		spanner.Insert("Counters", []string{"Name", "Value"}, []interface{}{"c", 0}),
+	}); err != nil {
+		t.Fatalf("Inserting data: %v", err)
+	}
+
+	// Run two read-modify-write transactions so that both read the counter
+	// before either writes it. One of them must be retried.
+	var wg, readers sync.WaitGroup
+	readers.Add(2)
+	var attempts int32
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			first := true
+			_, err := client.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+				atomic.AddInt32(&attempts, 1)
+				row, err := tx.ReadRow(ctx, "Counters", spanner.Key{"c"}, []string{"Value"})
+				if err != nil {
+					return err
+				}
+				var v int64
+				if err := row.Column(0, &v); err != nil {
+					return err
+				}
+				if first {
+					// Wait for the other transaction to also read.
+					first = false
+					readers.Done()
+					readers.Wait()
+				}
+				return tx.BufferWrite([]*spanner.Mutation{
+					spanner.Update("Counters", []string{"Name", "Value"}, []interface{}{"c", v + 1}),
+				})
+			})
+			if err != nil {
+				t.Errorf("Incrementing counter: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	row, err := client.Single().ReadRow(ctx, "Counters", spanner.Key{"c"}, []string{"Value"})
+	if err != nil {
+		t.Fatalf("Reading counter: %v", err)
+	}
+	var v int64
+	if err := row.Column(0, &v); err != nil {
+		t.Fatalf("Decoding counter: %v", err)
+	}
+	if v != 2 {
+		t.Errorf("Counter is %d after two increments, want 2", v)
+	}
+	if n := atomic.LoadInt32(&attempts); n < 3 {
+		t.Errorf("Transaction functions ran %d times, want at least 3", n)
+	}
+}
+
+func TestAbortNextCommit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	srv, err := NewServer("localhost:0")
+	if err != nil {
+		t.Fatalf("Starting in-memory fake: %v", err)
+	}
+	defer srv.Close()
+	srv.SetLogger(t.Logf)
+	if err := srv.UpdateDDL(&spansql.DDL{List: []spansql.DDLStmt{&spansql.CreateTable{
+		Name: "Tablino",
+		Columns: []spansql.ColumnDef{
+			{Name: "A", Type: spansql.Type{Base: spansql.Int64}},
+		},
+		PrimaryKey: []spansql.KeyPart{{Column: "A"}},
+	}}}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	conn, err := grpc.DialContext(ctx, srv.Addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Dialing in-memory fake: %v", err)
+	}
+	defer conn.Close()
+	client, err := spanner.NewClient(ctx, dbName(), option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatalf("Connecting to in-memory fake: %v", err)
+	}
+	defer client.Close()
+
+	srv.AbortNextCommit()
+	srv.AbortNextCommit()
+	attempts := 0
+	_, err = client.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+		attempts++
+		_, err := tx.Update(ctx, spanner.NewStatement("INSERT INTO Tablino (A) VALUES (1)"))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Running transaction: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Transaction function ran %d times, want 3", attempts)
+	}
+	rows := mustSlurpRows(t, client.Single().Query(ctx, spanner.NewStatement("SELECT A FROM Tablino")))
+	if want := [][]interface{}{{int64(1)}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("Table contents mismatch\n Got: %v\nWant: %v", rows, want)
+	}
+}
+
+func TestIntegration_Constraints(t *testing.T) {
+	client, adminClient, _, cleanup := makeClient(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, table := range []string{"Tickets", "Events"} {
+		if err := dropTable(t, adminClient, table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := updateDDL(t, adminClient,
+		`CREATE TABLE Events (
+			EventID INT64 NOT NULL,
+			Capacity INT64,
+		) PRIMARY KEY (EventID)`,
+		`CREATE TABLE Tickets (
+			TicketID INT64 NOT NULL,
+			EventID INT64,
+			Price FLOAT64,
+			CONSTRAINT FK_TicketEvent FOREIGN KEY (EventID) REFERENCES Events (EventID),
+			CONSTRAINT CK_Price CHECK (Price >= 0),
+		) PRIMARY KEY (TicketID)`); err != nil {
+		t.Fatalf("Creating tables: %v", err)
+	}
+
+	if _, err := client.Apply(ctx, []*spanner.Mutation{
+		spanner.Insert("Events", []string{"EventID", "Capacity"}, []interface{}{1, 100}),
+		spanner.Insert("Tickets", []string{"TicketID", "EventID", "Price"}, []interface{}{1, 1, 9.5}),
+	}); err != nil {
+		t.Fatalf("Inserting valid data: %v", err)
+	}
+
+	tests := []struct {
+		desc string
+		ms   []*spanner.Mutation
+		code codes.Code
+	}{
+		{
+			"ticket for missing event",
+			[]*spanner.Mutation{spanner.Insert("Tickets", []string{"TicketID", "EventID", "Price"}, []interface{}{2, 2, 1.0})},
+			codes.FailedPrecondition,
+		},
+		{
+			"negative price",
+			[]*spanner.Mutation{spanner.Update("Tickets", []string{"TicketID", "Price"}, []interface{}{1, -1.0})},
+			codes.OutOfRange,
+		},
+		{
+			"deleting referenced event",
+			[]*spanner.Mutation{spanner.Delete("Events", spanner.Key{1})},
+			codes.FailedPrecondition,
+		},
+	}
+	for _, test := range tests {
+		_, err := client.Apply(ctx, test.ms)
+		if g, w := spanner.ErrCode(err), test.code; g != w {
+			t.Errorf("%s: error code mismatch\n Got: %v\nWant: %v", test.desc, g, w)
+		}
+	}
+
+	got := mustSlurpRows(t, client.Single().Query(ctx, spanner.NewStatement(`SELECT TicketID, EventID, Price FROM Tickets`)))
+	want := [][]interface{}{{int64(1), int64(1), 9.5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tickets after rejected writes mismatch\n Got: %v\nWant: %v", got, want)
+	}
+}
+
+func TestIntegration_NumericJSONStruct(t *testing.T) {
+	client, adminClient, _, cleanup := makeClient(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := dropTable(t, adminClient, "Prices"); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateDDL(t, adminClient,
+		`CREATE TABLE Prices (
+			ID INT64 NOT NULL,
+			Amount NUMERIC,
+			Meta JSON,
+		) PRIMARY KEY (ID)`); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+
+	mustRat := func(s string) *big.Rat {
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			t.Fatalf("bad rat %q", s)
+		}
+		return r
+	}
+	meta := map[string]interface{}{"color": "red", "sizes": []interface{}{1.0, 2.0}}
+	if _, err := client.Apply(ctx, []*spanner.Mutation{
+		spanner.Insert("Prices", []string{"ID", "Amount", "Meta"}, []interface{}{1, mustRat("1.5"), spanner.NullJSON{Value: meta, Valid: true}}),
+		spanner.Insert("Prices", []string{"ID", "Amount", "Meta"}, []interface{}{2, spanner.NullNumeric{}, spanner.NullJSON{}}),
+		spanner.Insert("Prices", []string{"ID", "Amount"}, []interface{}{3, mustRat("12345678901234567890.123456789")}),
+	}); err != nil {
+		t.Fatalf("Inserting data: %v", err)
+	}
+
+	// Values must round-trip through the Null types.
+	iter := client.Single().Read(ctx, "Prices", spanner.AllKeys(), []string{"ID", "Amount", "Meta"})
+	var ids []int64
+	amounts := make(map[int64]spanner.NullNumeric)
+	metas := make(map[int64]spanner.NullJSON)
+	err := iter.Do(func(r *spanner.Row) error {
+		var id int64
+		var amount spanner.NullNumeric
+		var m spanner.NullJSON
+		if err := r.Columns(&id, &amount, &m); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		amounts[id], metas[id] = amount, m
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Reading rows: %v", err)
+	}
+	if want := []int64{1, 2, 3}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("Read IDs = %v, want %v", ids, want)
+	}
+	if a := amounts[1]; !a.Valid || a.Numeric.Cmp(mustRat("1.5")) != 0 {
+		t.Errorf("Amount for ID 1 = %v, want 1.5", a)
+	}
+	if a := amounts[2]; a.Valid {
+		t.Errorf("Amount for ID 2 = %v, want NULL", a)
+	}
+	if a := amounts[3]; !a.Valid || a.Numeric.Cmp(mustRat("12345678901234567890.123456789")) != 0 {
+		t.Errorf("Amount for ID 3 = %v, want 12345678901234567890.123456789", a)
+	}
+	if m := metas[1]; !m.Valid || !reflect.DeepEqual(m.Value, meta) {
+		t.Errorf("Meta for ID 1 = %v, want %v", m, meta)
+	}
+	if m := metas[2]; m.Valid {
+		t.Errorf("Meta for ID 2 = %v, want NULL", m)
+	}
+
+	// NUMERIC arithmetic must be exact to nine decimal places.
+	numTests := []struct {
+		q    string
+		want string
+	}{
+		{`SELECT NUMERIC '0.1' + NUMERIC '0.2'`, "0.3"},
+		{`SELECT NUMERIC '1' / 3`, "0.333333333"},
+		{`SELECT NUMERIC '2' / 3`, "0.666666667"},
+		{`SELECT Amount * 3 FROM Prices WHERE ID = 1`, "4.5"},
+		{`SELECT Amount - 1 FROM Prices WHERE ID = 3`, "12345678901234567889.123456789"},
+		{`SELECT -Amount FROM Prices WHERE ID = 1`, "-1.5"},
+		{`SELECT SUM(Amount) FROM Prices`, "12345678901234567891.623456789"},
+		{`SELECT CAST("2.5" AS NUMERIC) * 2`, "5"},
+	}
+	for _, test := range numTests {
+		var got spanner.NullNumeric
+		if err := client.Single().Query(ctx, spanner.NewStatement(test.q)).Do(func(r *spanner.Row) error {
+			return r.Column(0, &got)
+		}); err != nil {
+			t.Errorf("Query(%q): %v", test.q, err)
+			continue
+		}
+		if !got.Valid || got.Numeric.Cmp(mustRat(test.want)) != 0 {
+			t.Errorf("Query(%q) = %v, want %s", test.q, got, test.want)
+		}
+	}
+	err = client.Single().Query(ctx, spanner.NewStatement(`SELECT NUMERIC '99999999999999999999999999999' + 1`)).Do(func(r *spanner.Row) error { return nil })
+	if g, w := spanner.ErrCode(err), codes.OutOfRange; g != w {
+		t.Errorf("NUMERIC overflow error code = %v, want %v", g, w)
+	}
+
+	// JSON functions.
+	jsonTests := []struct {
+		q    string
+		want []interface{}
+	}{
+		{`SELECT JSON_VALUE(Meta, '$.color') FROM Prices WHERE ID = 1`, []interface{}{"red"}},
+		{`SELECT JSON_VALUE(Meta, '$.sizes[1]') FROM Prices WHERE ID = 1`, []interface{}{"2"}},
+		{`SELECT JSON_VALUE(Meta, '$.sizes') FROM Prices WHERE ID = 1`, []interface{}{nil}},
+		{`SELECT JSON_VALUE(Meta, '$.missing') FROM Prices WHERE ID = 1`, []interface{}{nil}},
+		{`SELECT JSON_VALUE('{"a": {"b": true}}', '$.a.b')`, []interface{}{"true"}},
+		{`SELECT TO_JSON_STRING(JSON_QUERY(Meta, '$.sizes')) FROM Prices WHERE ID = 1`, []interface{}{"[1,2]"}},
+		{`SELECT TO_JSON_STRING(JSON '{"b": 1, "a": [true, null]}')`, []interface{}{`{"a":[true,null],"b":1}`}},
+	}
+	for _, test := range jsonTests {
+		got, err := slurpRows(t, client.Single().Query(ctx, spanner.NewStatement(test.q)))
+		if err != nil {
+			t.Errorf("Query(%q): %v", test.q, err)
+			continue
+		}
+		if want := [][]interface{}{test.want}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Query(%q) = %v, want %v", test.q, got, want)
+		}
+	}
+	var sizes spanner.NullJSON
+	if err := client.Single().Query(ctx, spanner.NewStatement(`SELECT JSON_QUERY(Meta, '$.sizes') FROM Prices WHERE ID = 1`)).Do(func(r *spanner.Row) error {
+		return r.Column(0, &sizes)
+	}); err != nil {
+		t.Errorf("Querying JSON_QUERY: %v", err)
+	} else if want := []interface{}{1.0, 2.0}; !sizes.Valid || !reflect.DeepEqual(sizes.Value, want) {
+		t.Errorf("JSON_QUERY result = %v, want %v", sizes, want)
+	}
+
+	// STRUCT results.
+	type pair struct {
+		A int64  `spanner:"a"`
+		B string `spanner:"b"`
+	}
+	var pairs []*pair
+	if err := client.Single().Query(ctx, spanner.NewStatement(`SELECT [STRUCT(1 AS a, "x" AS b), STRUCT<a INT64, b STRING>(2, "y")]`)).Do(func(r *spanner.Row) error {
+		return r.Column(0, &pairs)
+	}); err != nil {
+		t.Errorf("Querying STRUCT literals: %v", err)
+	} else if len(pairs) != 2 || *pairs[0] != (pair{1, "x"}) || *pairs[1] != (pair{2, "y"}) {
+		t.Errorf("STRUCT literals = %+v, want [{1 x} {2 y}]", pairs)
+	}
+	type price struct {
+		ID     int64
+		Amount spanner.NullNumeric
+	}
+	var prices []*price
+	stmt := spanner.NewStatement(`SELECT ARRAY(SELECT AS STRUCT ID, Amount FROM Prices WHERE Amount IS NOT NULL ORDER BY ID DESC)`)
+	if err := client.Single().Query(ctx, stmt).Do(func(r *spanner.Row) error {
+		return r.Column(0, &prices)
+	}); err != nil {
+		t.Errorf("Querying ARRAY of STRUCT: %v", err)
+	} else if len(prices) != 2 || prices[0].ID != 3 || prices[1].ID != 1 || prices[1].Amount.Numeric.Cmp(mustRat("1.5")) != 0 {
+		t.Errorf("ARRAY of STRUCT = %+v, want IDs 3 and 1", prices)
+	}
+	var n int64
+	stmt = spanner.NewStatement(`SELECT s.a FROM UNNEST(ARRAY(SELECT AS STRUCT 7 AS a, "y" AS b)) AS s`)
+	if err := client.Single().Query(ctx, stmt).Do(func(r *spanner.Row) error {
+		return r.Column(0, &n)
+	}); err != nil {
+		t.Errorf("Querying STRUCT field: %v", err)
+	} else if n != 7 {
+		t.Errorf("STRUCT field = %d, want 7", n)
+	}
+}
+
 func TestIntegration_Views(t *testing.T) {
 	_, adminClient, _, cleanup := makeClient(t)
 	defer cleanup()
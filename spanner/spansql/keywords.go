@@ -243,4 +243,7 @@ var allFuncs = []string{
 
 	// JSON functions.
 	"JSON_VALUE",
+	"JSON_QUERY",
+	"PARSE_JSON",
+	"TO_JSON_STRING",
 }
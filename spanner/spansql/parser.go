@@ -45,6 +45,7 @@ This file is structured as follows:
 
 import (
 	"fmt"
+	"math/big"
 	"os"
 	"strconv"
 	"strings"
@@ -2271,7 +2272,7 @@ func (p *parser) parseBaseOrParameterizedType(withParam bool) (Type, *parseError
 	}
 
 	if t.Array {
-		if err := p.expect(">"); err != nil {
+		if err := p.expectCloseAngle(); err != nil {
 			return Type{}, err
 		}
 	}
@@ -2293,7 +2294,7 @@ func (p *parser) parseQuery() (Query, *parseError) {
 			[ LIMIT count [ OFFSET skip_rows ] ]
 	*/
 
-	// TODO: sub-selects, etc.
+	// TODO: parenthesized query expressions.
 
 	if err := p.expect("SELECT"); err != nil {
 		return Query{}, err
@@ -2305,6 +2306,21 @@ func (p *parser) parseQuery() (Query, *parseError) {
 	}
 	q := Query{Select: sel}
 
+	for {
+		so, ok, err := p.parseSetOperation()
+		if err != nil {
+			return Query{}, err
+		}
+		if !ok {
+			break
+		}
+		// Different set operations may not be mixed without parentheses.
+		if len(q.SetOps) > 0 && (so.Op != q.SetOps[0].Op || so.All != q.SetOps[0].All) {
+			return Query{}, p.errorf("different set operations may not be combined without parentheses")
+		}
+		q.SetOps = append(q.SetOps, so)
+	}
+
 	if p.eat("ORDER", "BY") {
 		for {
 			o, err := p.parseOrder()
@@ -2341,6 +2357,41 @@ func (p *parser) parseQuery() (Query, *parseError) {
 	return q, nil
 }
 
+// parseSetOperation parses a set operator and the SELECT that follows it.
+// It reports false if there is no set operator next.
+func (p *parser) parseSetOperation() (SetOperation, bool, *parseError) {
+	var so SetOperation
+	switch {
+	case p.eat("UNION"):
+		so.Op = Union
+	case p.eat("INTERSECT"):
+		so.Op = Intersect
+	case p.eat("EXCEPT"):
+		so.Op = Except
+	default:
+		return SetOperation{}, false, nil
+	}
+
+	// Cloud Spanner requires one of ALL or DISTINCT.
+	tok := p.next()
+	switch {
+	case tok.err != nil:
+		return SetOperation{}, false, tok.err
+	case tok.caseEqual("ALL"):
+		so.All = true
+	case tok.caseEqual("DISTINCT"):
+	default:
+		return SetOperation{}, false, p.errorf("got %q, want ALL or DISTINCT", tok.value)
+	}
+
+	sel, err := p.parseSelect()
+	if err != nil {
+		return SetOperation{}, false, err
+	}
+	so.Select = sel
+	return so, true, nil
+}
+
 func (p *parser) parseSelect() (Select, *parseError) {
 	debugf("parseSelect: %v", p)
 
@@ -2359,6 +2410,9 @@ func (p *parser) parseSelect() (Select, *parseError) {
 
 	var sel Select
 
+	if p.eat("AS", "STRUCT") {
+		sel.AsStruct = true
+	}
 	if p.eat("ALL") {
 		// Nothing to do; this is the default.
 	} else if p.eat("DISTINCT") {
@@ -3065,6 +3119,17 @@ func (p *parser) parseInOp() (Expr, *parseError) {
 
 	if p.eat("UNNEST") {
 		inOp.Unnest = true
+	} else if p.sniff("(", "SELECT") {
+		p.eat("(")
+		q, err := p.parseQuery()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		inOp.Query = &q
+		return inOp, nil
 	}
 
 	inOp.RHS, err = p.parseParenExprList()
@@ -3217,18 +3282,58 @@ func (p *parser) parseLit() (Expr, *parseError) {
 		return BytesLiteral(tok.string), nil
 	}
 
-	// Handle parenthesized expressions.
+	// Handle scalar subqueries and parenthesized expressions.
+	if tok.value == "(" && p.sniff("SELECT") {
+		q, err := p.parseQuery()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return ScalarSubquery{Query: q}, nil
+	}
 	if tok.value == "(" {
 		e, err := p.parseExpr()
 		if err != nil {
 			return nil, err
 		}
+		if p.eat(",") {
+			// This is a STRUCT in tuple syntax.
+			sl := StructLiteral{Values: []Expr{e}}
+			for {
+				e, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				sl.Values = append(sl.Values, e)
+				if !p.eat(",") {
+					break
+				}
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return sl, nil
+		}
 		if err := p.expect(")"); err != nil {
 			return nil, err
 		}
 		return Paren{Expr: e}, nil
 	}
 
+	if tok.caseEqual("ARRAY") && p.sniff("(", "SELECT") {
+		p.eat("(")
+		q, err := p.parseQuery()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return ArraySubquery{Query: q}, nil
+	}
+
 	// If the literal was an identifier, and there's an open paren next,
 	// this is a function invocation.
 	// The `funcs` map is keyed by upper case strings.
@@ -3249,6 +3354,20 @@ func (p *parser) parseLit() (Expr, *parseError) {
 		}, nil
 	}
 
+	if tok.caseEqual("EXISTS") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		q, err := p.parseQuery()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return ExistsOp{Query: q}, nil
+	}
+
 	// Handle some reserved keywords and special tokens that become specific values.
 	switch {
 	case tok.caseEqual("TRUE"):
@@ -3284,6 +3403,9 @@ func (p *parser) parseLit() (Expr, *parseError) {
 
 	// Handle typed literals.
 	switch {
+	case tok.caseEqual("STRUCT"):
+		p.back()
+		return p.parseStructLit()
 	case tok.caseEqual("ARRAY") || tok.value == "[":
 		p.back()
 		return p.parseArrayLit()
@@ -3302,10 +3424,13 @@ func (p *parser) parseLit() (Expr, *parseError) {
 			p.back()
 			return p.parseJSONLit()
 		}
+	case tok.caseEqual("NUMERIC"):
+		if p.sniffTokenType(stringToken) {
+			p.back()
+			return p.parseNumericLit()
+		}
 	}
 
-	// TODO: struct literals
-
 	// Try a parameter.
 	// TODO: check character sets.
 	if strings.HasPrefix(tok.value, "@") {
@@ -3571,6 +3696,153 @@ func (p *parser) parseJSONLit() (JSONLiteral, *parseError) {
 	return JSONLiteral(s), nil
 }
 
+func (p *parser) parseNumericLit() (NumericLiteral, *parseError) {
+	if err := p.expect("NUMERIC"); err != nil {
+		return "", err
+	}
+	s, err := p.parseStringLit()
+	if err != nil {
+		return "", err
+	}
+	str := strings.TrimSpace(string(s))
+	if _, ok := new(big.Rat).SetString(str); !ok {
+		return "", p.errorf("invalid NUMERIC literal %q", s)
+	}
+	return NumericLiteral(str), nil
+}
+
+func (p *parser) parseStructLit() (StructLiteral, *parseError) {
+	if err := p.expect("STRUCT"); err != nil {
+		return StructLiteral{}, err
+	}
+
+	var sl StructLiteral
+	if p.sniff("<") {
+		t, err := p.parseStructType()
+		if err != nil {
+			return StructLiteral{}, err
+		}
+		sl.Fields = t.Fields
+	}
+
+	padAliases := func() {
+		for len(sl.Aliases) < len(sl.Values) {
+			sl.Aliases = append(sl.Aliases, "")
+		}
+	}
+	err := p.parseCommaList("(", ")", func(p *parser) *parseError {
+		e, err := p.parseExpr()
+		if err != nil {
+			return err
+		}
+		sl.Values = append(sl.Values, e)
+		if sl.Fields == nil && p.eat("AS") {
+			alias, err := p.parseAlias()
+			if err != nil {
+				return err
+			}
+			padAliases()
+			sl.Aliases[len(sl.Aliases)-1] = alias
+		}
+		return nil
+	})
+	if err != nil {
+		return StructLiteral{}, err
+	}
+	if sl.Aliases != nil {
+		padAliases()
+	}
+	if sl.Fields != nil && len(sl.Fields) != len(sl.Values) {
+		return StructLiteral{}, p.errorf("STRUCT type has %d fields but %d values", len(sl.Fields), len(sl.Values))
+	}
+	return sl, nil
+}
+
+// parseStructType parses the <...> part of a STRUCT type.
+func (p *parser) parseStructType() (Type, *parseError) {
+	debugf("parseStructType: %v", p)
+
+	/*
+		struct_type:
+			STRUCT< [ field_name ] field_type [, ...] >
+	*/
+
+	if err := p.expect("<"); err != nil {
+		return Type{}, err
+	}
+	t := Type{Base: Struct}
+	for {
+		var sf StructField
+		// A field name is optional, so look ahead to see
+		// whether the field starts with its type.
+		tok := p.next()
+		if tok.err != nil {
+			return Type{}, tok.err
+		}
+		_, isBase := baseTypes[strings.ToUpper(tok.value)]
+		isType := isBase || tok.caseEqual("ARRAY") || tok.caseEqual("STRUCT")
+		p.back()
+		if !isType || !(p.sniff(tok.value, ",") || p.sniff(tok.value, ">") || p.sniff(tok.value, ">>") || p.sniff(tok.value, "<")) {
+			name, err := p.parseAlias()
+			if err != nil {
+				return Type{}, err
+			}
+			sf.Name = name
+		}
+		typ, err := p.parseFieldType()
+		if err != nil {
+			return Type{}, err
+		}
+		sf.Type = typ
+		t.Fields = append(t.Fields, sf)
+
+		if !p.eat(",") {
+			break
+		}
+	}
+	if err := p.expectCloseAngle(); err != nil {
+		return Type{}, err
+	}
+	return t, nil
+}
+
+// parseFieldType parses the type of a STRUCT field, which may itself be a STRUCT.
+func (p *parser) parseFieldType() (Type, *parseError) {
+	if p.eat("STRUCT") {
+		return p.parseStructType()
+	}
+	if p.eat("ARRAY", "<", "STRUCT") {
+		t, err := p.parseStructType()
+		if err != nil {
+			return Type{}, err
+		}
+		if err := p.expectCloseAngle(); err != nil {
+			return Type{}, err
+		}
+		t.Array = true
+		return t, nil
+	}
+	return p.parseBaseType()
+}
+
+// expectCloseAngle consumes a ">" that closes a type parameter list.
+// A ">>" token is split in two, since it may close nested lists.
+func (p *parser) expectCloseAngle() *parseError {
+	tok := p.next()
+	if tok.err != nil {
+		return tok.err
+	}
+	switch tok.value {
+	case ">":
+		return nil
+	case ">>":
+		p.s = ">" + p.s
+		p.offset--
+		return nil
+	}
+	return p.errorf("got %q while expecting %q", tok.value, ">")
+}
+
 func (p *parser) parseStringLit() (StringLiteral, *parseError) {
 	tok := p.next()
 	if tok.err != nil {
@@ -304,6 +304,96 @@ func TestParseQuery(t *testing.T) {
 				},
 			},
 		},
+		// Set operations.
+		{
+			`SELECT A FROM T1 UNION ALL SELECT B FROM T2 UNION ALL SELECT C FROM T3 ORDER BY A`,
+			Query{
+				Select: Select{
+					List: []Expr{ID("A")},
+					From: []SelectFrom{SelectFromTable{Table: "T1"}},
+				},
+				SetOps: []SetOperation{
+					{Op: Union, All: true, Select: Select{
+						List: []Expr{ID("B")},
+						From: []SelectFrom{SelectFromTable{Table: "T2"}},
+					}},
+					{Op: Union, All: true, Select: Select{
+						List: []Expr{ID("C")},
+						From: []SelectFrom{SelectFromTable{Table: "T3"}},
+					}},
+				},
+				Order: []Order{{Expr: ID("A")}},
+			},
+		},
+		{
+			`SELECT A FROM T1 EXCEPT DISTINCT SELECT A FROM T2`,
+			Query{
+				Select: Select{
+					List: []Expr{ID("A")},
+					From: []SelectFrom{SelectFromTable{Table: "T1"}},
+				},
+				SetOps: []SetOperation{
+					{Op: Except, Select: Select{
+						List: []Expr{ID("A")},
+						From: []SelectFrom{SelectFromTable{Table: "T2"}},
+					}},
+				},
+			},
+		},
+		// Subqueries.
+		{
+			`SELECT A, (SELECT MAX(B) FROM T2 WHERE T2.A = T1.A) FROM T1 WHERE EXISTS (SELECT 1 FROM T3)`,
+			Query{
+				Select: Select{
+					List: []Expr{
+						ID("A"),
+						ScalarSubquery{Query: Query{
+							Select: Select{
+								List: []Expr{Func{Name: "MAX", Args: []Expr{ID("B")}}},
+								From: []SelectFrom{SelectFromTable{Table: "T2"}},
+								Where: ComparisonOp{
+									Op:  Eq,
+									LHS: PathExp{"T2", "A"},
+									RHS: PathExp{"T1", "A"},
+								},
+							},
+						}},
+					},
+					From: []SelectFrom{SelectFromTable{Table: "T1"}},
+					Where: ExistsOp{Query: Query{
+						Select: Select{
+							List: []Expr{IntegerLiteral(1)},
+							From: []SelectFrom{SelectFromTable{Table: "T3"}},
+						},
+					}},
+				},
+			},
+		},
+		{
+			`SELECT A FROM T1 WHERE A NOT IN (SELECT A FROM T2 INTERSECT ALL SELECT A FROM T3)`,
+			Query{
+				Select: Select{
+					List: []Expr{ID("A")},
+					From: []SelectFrom{SelectFromTable{Table: "T1"}},
+					Where: InOp{
+						LHS: ID("A"),
+						Neg: true,
+						Query: &Query{
+							Select: Select{
+								List: []Expr{ID("A")},
+								From: []SelectFrom{SelectFromTable{Table: "T2"}},
+							},
+							SetOps: []SetOperation{
+								{Op: Intersect, All: true, Select: Select{
+									List: []Expr{ID("A")},
+									From: []SelectFrom{SelectFromTable{Table: "T3"}},
+								}},
+							},
+						},
+					},
+				},
+			},
+		},
 	}
 	for _, test := range tests {
 		got, err := ParseQuery(test.in)
@@ -528,6 +618,33 @@ func TestParseExpr(t *testing.T) {
 		// JSON literals:
 		// https://cloud.google.com/spanner/docs/reference/standard-sql/lexical#json_literals
 		{`JSON '{"a": 1}'`, JSONLiteral(`{"a": 1}`)},
+		// NUMERIC literals:
+		{`NUMERIC '-12.5'`, NumericLiteral("-12.5")},
+		// STRUCT literals:
+		// https://cloud.google.com/spanner/docs/reference/standard-sql/data-types#constructing_a_struct
+		{`(1, 'x')`, StructLiteral{Values: []Expr{IntegerLiteral(1), StringLiteral("x")}}},
+		{`STRUCT(1 AS a, b)`, StructLiteral{Values: []Expr{IntegerLiteral(1), ID("b")}, Aliases: []ID{"a", ""}}},
+		{
+			`STRUCT<a INT64, STRING, c ARRAY<STRUCT<date DATE>>>(1, 'x', [])`,
+			StructLiteral{
+				Fields: []StructField{
+					{Name: "a", Type: Type{Base: Int64}},
+					{Type: Type{Base: String}},
+					{Name: "c", Type: Type{Array: true, Base: Struct, Fields: []StructField{
+						{Name: "date", Type: Type{Base: Date}},
+					}}},
+				},
+				Values: []Expr{IntegerLiteral(1), StringLiteral("x"), Array(nil)},
+			},
+		},
+		{
+			`ARRAY(SELECT AS STRUCT A, B FROM T)`,
+			ArraySubquery{Query: Query{Select: Select{
+				AsStruct: true,
+				List:     []Expr{ID("A"), ID("B")},
+				From:     []SelectFrom{SelectFromTable{Table: "T"}},
+			}}},
+		},
 
 		// OR is lower precedence than AND.
 		{`A AND B OR C`, LogicalOp{LHS: LogicalOp{LHS: ID("A"), Op: And, RHS: ID("B")}, Op: Or, RHS: ID("C")}},
@@ -1340,6 +1457,9 @@ func TestParseFailures(t *testing.T) {
 		{expr, `"""\"""`, "unterminated triple-quoted string by last backslash (double quote)"},
 		{expr, `'''\'''`, "unterminated triple-quoted string by last backslash (single quote)"},
 		{expr, `"foo" AND "bar"`, "logical operation on string literals"},
+		{query, `SELECT A FROM T1 UNION SELECT A FROM T2`, "set operation without ALL or DISTINCT"},
+		{query, `SELECT A FROM T1 UNION ALL SELECT A FROM T2 EXCEPT ALL SELECT A FROM T3`, "mixed set operations"},
+		{expr, `EXISTS (SELECT 1`, "unterminated EXISTS subquery"},
 		// Found by fuzzing.
 		// https://github.com/googleapis/google-cloud-go/issues/2196
 		{query, `/*/*/`, "invalid comment termination"},
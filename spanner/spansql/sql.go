@@ -389,12 +389,26 @@ func (t Type) SQL() string {
 		}
 		str += ")"
 	}
+	if t.Base == Struct {
+		var fields []string
+		for _, f := range t.Fields {
+			fields = append(fields, f.SQL())
+		}
+		str += "<" + strings.Join(fields, ", ") + ">"
+	}
 	if t.Array {
 		str = "ARRAY<" + str + ">"
 	}
 	return str
 }
 
+func (sf StructField) SQL() string {
+	if sf.Name == "" {
+		return sf.Type.SQL()
+	}
+	return sf.Name.SQL() + " " + sf.Type.SQL()
+}
+
 func (tb TypeBase) SQL() string {
 	switch tb {
 	case Bool:
@@ -415,6 +429,8 @@ func (tb TypeBase) SQL() string {
 		return "TIMESTAMP"
 	case JSON:
 		return "JSON"
+	case Struct:
+		return "STRUCT"
 	}
 	panic("unknown TypeBase")
 }
@@ -430,6 +446,9 @@ func (kp KeyPart) SQL() string {
 func (q Query) SQL() string { return buildSQL(q) }
 func (q Query) addSQL(sb *strings.Builder) {
 	q.Select.addSQL(sb)
+	for _, so := range q.SetOps {
+		so.addSQL(sb)
+	}
 	if len(q.Order) > 0 {
 		sb.WriteString(" ORDER BY ")
 		for i, o := range q.Order {
@@ -449,9 +468,30 @@ func (q Query) addSQL(sb *strings.Builder) {
 	}
 }
 
+func (so SetOperation) SQL() string { return buildSQL(so) }
+func (so SetOperation) addSQL(sb *strings.Builder) {
+	switch so.Op {
+	case Union:
+		sb.WriteString(" UNION ")
+	case Intersect:
+		sb.WriteString(" INTERSECT ")
+	case Except:
+		sb.WriteString(" EXCEPT ")
+	}
+	if so.All {
+		sb.WriteString("ALL ")
+	} else {
+		sb.WriteString("DISTINCT ")
+	}
+	so.Select.addSQL(sb)
+}
+
 func (sel Select) SQL() string { return buildSQL(sel) }
 func (sel Select) addSQL(sb *strings.Builder) {
 	sb.WriteString("SELECT ")
+	if sel.AsStruct {
+		sb.WriteString("AS STRUCT ")
+	}
 	if sel.Distinct {
 		sb.WriteString("DISTINCT ")
 	}
@@ -651,7 +691,11 @@ func (io InOp) addSQL(sb *strings.Builder) {
 		sb.WriteString("UNNEST")
 	}
 	sb.WriteString("(")
-	addExprList(sb, io.RHS, ", ")
+	if io.Query != nil {
+		io.Query.addSQL(sb)
+	} else {
+		addExprList(sb, io.RHS, ", ")
+	}
 	sb.WriteString(")")
 }
 
@@ -741,6 +785,20 @@ func (p Paren) addSQL(sb *strings.Builder) {
 	sb.WriteString(")")
 }
 
+func (sq ScalarSubquery) SQL() string { return buildSQL(sq) }
+func (sq ScalarSubquery) addSQL(sb *strings.Builder) {
+	sb.WriteString("(")
+	sq.Query.addSQL(sb)
+	sb.WriteString(")")
+}
+
+func (eo ExistsOp) SQL() string { return buildSQL(eo) }
+func (eo ExistsOp) addSQL(sb *strings.Builder) {
+	sb.WriteString("EXISTS (")
+	eo.Query.addSQL(sb)
+	sb.WriteString(")")
+}
+
 func (a Array) SQL() string { return buildSQL(a) }
 func (a Array) addSQL(sb *strings.Builder) {
 	sb.WriteString("[")
@@ -871,3 +929,35 @@ func (jl JSONLiteral) SQL() string { return buildSQL(jl) }
 func (jl JSONLiteral) addSQL(sb *strings.Builder) {
 	fmt.Fprintf(sb, "JSON '%s'", jl)
 }
+
+func (nl NumericLiteral) SQL() string { return buildSQL(nl) }
+func (nl NumericLiteral) addSQL(sb *strings.Builder) {
+	fmt.Fprintf(sb, "NUMERIC '%s'", string(nl))
+}
+
+func (sl StructLiteral) SQL() string { return buildSQL(sl) }
+func (sl StructLiteral) addSQL(sb *strings.Builder) {
+	sb.WriteString("STRUCT")
+	if len(sl.Fields) > 0 {
+		sb.WriteString(Type{Base: Struct, Fields: sl.Fields}.SQL()[len("STRUCT"):])
+	}
+	sb.WriteString("(")
+	for i, v := range sl.Values {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		v.addSQL(sb)
+		if len(sl.Aliases) > 0 && sl.Aliases[i] != "" {
+			sb.WriteString(" AS ")
+			sb.WriteString(sl.Aliases[i].SQL())
+		}
+	}
+	sb.WriteString(")")
+}
+
+func (as ArraySubquery) SQL() string { return buildSQL(as) }
+func (as ArraySubquery) addSQL(sb *strings.Builder) {
+	sb.WriteString("ARRAY(")
+	as.Query.addSQL(sb)
+	sb.WriteString(")")
+}
@@ -573,6 +573,41 @@ func TestSQL(t *testing.T) {
 			`JSON '{"a": 1}'`,
 			reparseExpr,
 		},
+		{
+			NumericLiteral("3.14"),
+			`NUMERIC '3.14'`,
+			reparseExpr,
+		},
+		{
+			StructLiteral{
+				Values:  []Expr{IntegerLiteral(1), ID("b")},
+				Aliases: []ID{"a", ""},
+			},
+			`STRUCT(1 AS a, b)`,
+			reparseExpr,
+		},
+		{
+			StructLiteral{
+				Fields: []StructField{
+					{Name: "a", Type: Type{Base: Int64}},
+					{Name: "b", Type: Type{Array: true, Base: Struct, Fields: []StructField{
+						{Type: Type{Base: String}},
+					}}},
+				},
+				Values: []Expr{IntegerLiteral(1), Null},
+			},
+			`STRUCT<a INT64, b ARRAY<STRUCT<STRING>>>(1, NULL)`,
+			reparseExpr,
+		},
+		{
+			ArraySubquery{Query: Query{Select: Select{
+				AsStruct: true,
+				List:     []Expr{ID("A"), ID("B")},
+				From:     []SelectFrom{SelectFromTable{Table: "T"}},
+			}}},
+			`ARRAY(SELECT AS STRUCT A, B FROM T)`,
+			reparseExpr,
+		},
 		{
 			Query{
 				Select: Select{
@@ -624,6 +659,59 @@ func TestSQL(t *testing.T) {
 			"SELECT A, B FROM Table1 INNER JOIN Table2 ON Table1.A = Table2.A INNER JOIN Table3 USING (X)",
 			reparseQuery,
 		},
+		{
+			Query{
+				Select: Select{
+					List: []Expr{ID("A")},
+					From: []SelectFrom{SelectFromTable{Table: "Table1"}},
+					Where: InOp{
+						LHS: ID("A"),
+						Query: &Query{
+							Select: Select{
+								List: []Expr{ID("A")},
+								From: []SelectFrom{SelectFromTable{Table: "Table2"}},
+								Where: ExistsOp{Query: Query{
+									Select: Select{
+										List: []Expr{ScalarSubquery{Query: Query{
+											Select: Select{List: []Expr{IntegerLiteral(1)}},
+										}}},
+									},
+								}},
+							},
+						},
+					},
+				},
+				SetOps: []SetOperation{
+					{Op: Union, All: true, Select: Select{
+						List: []Expr{ID("B")},
+						From: []SelectFrom{SelectFromTable{Table: "Table3"}},
+					}},
+					{Op: Union, All: true, Select: Select{
+						List: []Expr{ID("C")},
+						From: []SelectFrom{SelectFromTable{Table: "Table4"}},
+					}},
+				},
+				Order: []Order{{Expr: ID("A")}},
+			},
+			"SELECT A FROM Table1 WHERE A IN (SELECT A FROM Table2 WHERE EXISTS (SELECT (SELECT 1))) UNION ALL SELECT B FROM Table3 UNION ALL SELECT C FROM Table4 ORDER BY A",
+			reparseQuery,
+		},
+		{
+			Query{
+				Select: Select{
+					List: []Expr{ID("A")},
+					From: []SelectFrom{SelectFromTable{Table: "Table1"}},
+				},
+				SetOps: []SetOperation{
+					{Op: Except, Select: Select{
+						List: []Expr{ID("A")},
+						From: []SelectFrom{SelectFromTable{Table: "Table2"}},
+					}},
+				},
+			},
+			"SELECT A FROM Table1 EXCEPT DISTINCT SELECT A FROM Table2",
+			reparseQuery,
+		},
 		{
 			Query{
 				Select: Select{
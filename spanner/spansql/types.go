@@ -406,8 +406,17 @@ func (Check) isConstraint()   {}
 // Type represents a column type.
 type Type struct {
 	Array bool
-	Base  TypeBase // Bool, Int64, Float64, Numeric, String, Bytes, Date, Timestamp
+	Base  TypeBase // Bool, Int64, Float64, Numeric, String, Bytes, Date, Timestamp, JSON, Struct
 	Len   int64    // if Base is String or Bytes; may be MaxLen
+
+	// Fields holds the fields of a STRUCT type, which only appears in queries.
+	Fields []StructField
+}
+
+// StructField represents a field of a STRUCT type.
+type StructField struct {
+	Name ID // empty if the field is anonymous
+	Type Type
 }
 
 // MaxLen is a sentinel for Type's Len field, representing the MAX value.
@@ -425,6 +434,7 @@ const (
 	Date
 	Timestamp
 	JSON
+	Struct
 )
 
 // KeyPart represents a column specification as part of a primary key or index definition.
@@ -437,15 +447,33 @@ type KeyPart struct {
 // https://cloud.google.com/spanner/docs/query-syntax#sql-syntax
 type Query struct {
 	Select Select
+	SetOps []SetOperation // further SELECTs combined with Select, in order
 	Order  []Order
 
 	Limit, Offset LiteralOrParam
 }
 
+// SetOperation represents a set operator and the SELECT on its right hand side.
+// https://cloud.google.com/spanner/docs/query-syntax#set_operators
+type SetOperation struct {
+	Op     SetOp
+	All    bool // ALL rather than DISTINCT
+	Select Select
+}
+
+type SetOp int
+
+const (
+	Union SetOp = iota
+	Intersect
+	Except
+)
+
 // Select represents a SELECT statement.
 // https://cloud.google.com/spanner/docs/query-syntax#select-list
 type Select struct {
 	Distinct bool
+	AsStruct bool // SELECT AS STRUCT
 	List     []Expr
 	From     []SelectFrom
 	Where    BoolExpr
@@ -636,7 +664,9 @@ type InOp struct {
 	RHS    []Expr
 	Unnest bool
 
-	// TODO: support subquery form
+	// Query is set for the subquery form ("IN (SELECT ...)"),
+	// in which case RHS is empty.
+	Query *Query
 }
 
 func (InOp) isBoolExpr() {} // usually
@@ -718,6 +748,24 @@ type Paren struct {
 func (Paren) isBoolExpr() {} // possibly bool
 func (Paren) isExpr()     {}
 
+// ScalarSubquery represents a subquery that yields a single value.
+// https://cloud.google.com/spanner/docs/subqueries#scalar_subquery_concepts
+type ScalarSubquery struct {
+	Query Query
+}
+
+func (ScalarSubquery) isBoolExpr() {} // possibly bool
+func (ScalarSubquery) isExpr()     {}
+
+// ExistsOp represents an EXISTS subquery.
+// https://cloud.google.com/spanner/docs/subqueries#exists_subquery_concepts
+type ExistsOp struct {
+	Query Query
+}
+
+func (ExistsOp) isBoolExpr() {}
+func (ExistsOp) isExpr()     {}
+
 // Array represents an array literal.
 type Array []Expr
 
@@ -844,6 +892,36 @@ type JSONLiteral []byte
 
 func (JSONLiteral) isExpr() {}
 
+// NumericLiteral represents a NUMERIC literal.
+// https://cloud.google.com/spanner/docs/reference/standard-sql/lexical#numeric_literals
+type NumericLiteral string
+
+func (NumericLiteral) isExpr() {}
+
+// StructLiteral represents a STRUCT constructor,
+// either STRUCT<a INT64, b STRING>(1, "x"), STRUCT(1 AS a, "x" AS b) or (1, "x").
+// https://cloud.google.com/spanner/docs/reference/standard-sql/data-types#constructing_a_struct
+type StructLiteral struct {
+	// Fields is set for the typed form, and is populated 1:1 with Values.
+	Fields []StructField
+	Values []Expr
+
+	// If the typeless form has explicit aliases ("AS alias"),
+	// Aliases will be populated 1:1 with Values;
+	// aliases that are present will be non-empty.
+	Aliases []ID
+}
+
+func (StructLiteral) isExpr() {}
+
+// ArraySubquery represents an ARRAY subquery, which yields the rows of the subquery as an array.
+// https://cloud.google.com/spanner/docs/subqueries#array_subquery_concepts
+type ArraySubquery struct {
+	Query Query
+}
+
+func (ArraySubquery) isExpr() {}
+
 type StarExpr int
 
 // Star represents a "*" in an expression.
@@ -87,6 +87,7 @@ type storageClient interface {
 
 	NewRangeReader(ctx context.Context, params *newRangeReaderParams, opts ...storageOption) (*Reader, error)
 	OpenWriter(params *openWriterParams, opts ...storageOption) (*io.PipeWriter, error)
+	QueryUpload(ctx context.Context, sessionURI string, encryptionKey []byte, opts ...storageOption) (int64, *ObjectAttrs, error)
 
 	// IAM methods.
 
@@ -263,6 +264,17 @@ type openWriterParams struct {
 	// sendCRC32C - see `Writer.SendCRC32C`.
 	// Optional.
 	sendCRC32C bool
+	// trackSession - see `Writer.TrackSession`.
+	// Optional.
+	trackSession bool
+	// sessionURI is the resumable upload session to continue - see
+	// `ObjectHandle.ResumeWriter`.
+	// Optional.
+	sessionURI string
+	// offset is the number of bytes that the session has persisted. Only
+	// used with sessionURI.
+	// Optional.
+	offset int64
 
 	// Writer callbacks
 
@@ -278,6 +290,10 @@ type openWriterParams struct {
 	// setObj callback for reporting the resulting object - see `Writer.obj`.
 	// Required.
 	setObj func(*ObjectAttrs)
+	// setSession callback for reporting a new resumable upload session - see
+	// `Writer.SessionURI`.
+	// Required.
+	setSession func(string)
 }
 
 type newRangeReaderParams struct {
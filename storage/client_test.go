@@ -806,13 +806,14 @@ func TestOpenWriterEmulated(t *testing.T) {
 
 		var gotAttrs *ObjectAttrs
 		params := &openWriterParams{
-			attrs:    want,
-			bucket:   bucket,
-			ctx:      context.Background(),
-			donec:    make(chan struct{}),
-			setError: func(_ error) {}, // no-op
-			progress: func(_ int64) {}, // no-op
-			setObj:   func(o *ObjectAttrs) { gotAttrs = o },
+			attrs:      want,
+			bucket:     bucket,
+			ctx:        context.Background(),
+			donec:      make(chan struct{}),
+			setError:   func(_ error) {}, // no-op
+			progress:   func(_ int64) {}, // no-op
+			setObj:     func(o *ObjectAttrs) { gotAttrs = o },
+			setSession: func(_ string) {}, // no-op
 		}
 		pw, err := client.OpenWriter(params)
 		if err != nil {
@@ -182,6 +182,18 @@ func ExampleBucketHandle_Objects() {
 	_ = it // TODO: iterate using Next or iterator.Pager.
 }
 
+func ExampleBucketHandle_FS() {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	// Serve the contents of the bucket over HTTP.
+	fsys := client.Bucket("my-bucket").FS(ctx)
+	http.Handle("/", http.FileServer(http.FS(fsys)))
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
+
 func ExampleBucketHandle_AddNotification() {
 	ctx := context.Background()
 	client, err := storage.NewClient(ctx)
@@ -0,0 +1,395 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/iterator"
+)
+
+// BucketFS is a read-only file system view of a bucket, which implements
+// fs.FS, fs.ReadDirFS and fs.StatFS. Object names are paths, and the
+// directories are the prefixes that a listing with Query.Delimiter "/"
+// returns. For example, the object "a/b/c.txt" is the file c.txt in the
+// directory a/b.
+//
+// Objects whose names aren't valid paths, according to fs.ValidPath, can't be
+// opened, and are left out of directory listings. That includes objects whose
+// names end in "/", which are often used as placeholders for directories. If
+// the name of an object is also a directory, such as "a" when "a/b" exists,
+// the name refers to the object.
+//
+// Files are read with ObjectHandle.NewRangeReader. They implement io.Seeker
+// and io.ReaderAt with ranged reads, and always read the generation of the
+// object that was current when they were opened. They hold the stored bytes
+// of objects, so objects with gzip content encoding aren't decompressed. The Sys method of their
+// fs.FileInfo returns the *ObjectAttrs of the object.
+//
+// Use BucketHandle.FS to create a BucketFS.
+type BucketFS struct {
+	ctx context.Context
+	b   *BucketHandle
+}
+
+// FS returns a read-only file system view of the bucket. All operations on
+// the file system and its files use ctx.
+func (b *BucketHandle) FS(ctx context.Context) *BucketFS {
+	return &BucketFS{ctx: ctx, b: b}
+}
+
+// Open opens the named file or directory.
+func (f *BucketFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := f.stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if info.IsDir() {
+		return &bucketDir{fsys: f, name: name, info: info}, nil
+	}
+	attrs := info.attrs
+	return &bucketFile{
+		ctx:  f.ctx,
+		o:    f.b.Object(attrs.Name).Generation(attrs.Generation).ReadCompressed(true),
+		info: info,
+	}, nil
+}
+
+// Stat returns a fs.FileInfo describing the named file or directory.
+func (f *BucketFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := f.stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+func (f *BucketFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries, err := f.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+// stat returns the fileInfo of a valid path.
+func (f *BucketFS) stat(name string) (*fileInfo, error) {
+	if name == "." {
+		return dirInfo("."), nil
+	}
+	attrs, err := f.b.Object(name).Attrs(f.ctx)
+	if err == nil {
+		return objectInfo(attrs), nil
+	}
+	if err != ErrObjectNotExist {
+		return nil, err
+	}
+	// The name is a directory if any object names start with it and a slash.
+	it := f.b.Objects(f.ctx, &Query{Prefix: name + "/", Delimiter: "/"})
+	it.PageInfo().MaxSize = 1
+	if _, err := it.Next(); err == iterator.Done {
+		return nil, fs.ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	return dirInfo(path.Base(name)), nil
+}
+
+// readDir returns the entries of the directory with a valid path, sorted by
+// name.
+func (f *BucketFS) readDir(name string) ([]fs.DirEntry, error) {
+	var prefix string
+	if name != "." {
+		prefix = name + "/"
+	}
+	files := map[string]bool{}
+	var entries []fs.DirEntry
+	it := f.b.Objects(f.ctx, &Query{Prefix: prefix, Delimiter: "/"})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var info *fileInfo
+		if attrs.Prefix != "" {
+			base := strings.TrimSuffix(strings.TrimPrefix(attrs.Prefix, prefix), "/")
+			if !fs.ValidPath(base) {
+				continue
+			}
+			info = dirInfo(base)
+		} else {
+			if !fs.ValidPath(attrs.Name) {
+				continue
+			}
+			info = objectInfo(attrs)
+			files[info.name] = true
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	if len(entries) == 0 && name != "." {
+		// The directory may not exist, or it may be a file, or it may hold
+		// only a placeholder object named name+"/".
+		info, err := f.stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, errors.New("not a directory")
+		}
+	}
+	// Leave out directories with the names of files.
+	n := 0
+	for _, e := range entries {
+		if !e.IsDir() || !files[e.Name()] {
+			entries[n] = e
+			n++
+		}
+	}
+	entries = entries[:n]
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// fileInfo is the fs.FileInfo of an object or a directory.
+type fileInfo struct {
+	name  string
+	attrs *ObjectAttrs // nil for a directory
+}
+
+func objectInfo(attrs *ObjectAttrs) *fileInfo {
+	return &fileInfo{name: path.Base(attrs.Name), attrs: attrs}
+}
+
+func dirInfo(name string) *fileInfo {
+	return &fileInfo{name: name}
+}
+
+func (i *fileInfo) Name() string { return i.name }
+func (i *fileInfo) IsDir() bool  { return i.attrs == nil }
+
+func (i *fileInfo) Size() int64 {
+	if i.attrs == nil {
+		return 0
+	}
+	return i.attrs.Size
+}
+
+func (i *fileInfo) Mode() fs.FileMode {
+	if i.attrs == nil {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i *fileInfo) ModTime() time.Time {
+	if i.attrs == nil {
+		return time.Time{}
+	}
+	return i.attrs.Updated
+}
+
+// Sys returns the *ObjectAttrs of an object, or nil for a directory.
+func (i *fileInfo) Sys() interface{} {
+	if i.attrs == nil {
+		return nil
+	}
+	return i.attrs
+}
+
+// bucketFile is an open object of a BucketFS.
+type bucketFile struct {
+	ctx  context.Context
+	o    *ObjectHandle
+	info *fileInfo
+
+	r      *Reader // reads from offset, or nil
+	offset int64
+	closed bool
+}
+
+func (f *bucketFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *bucketFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.r == nil {
+		r, err := f.o.NewRangeReader(f.ctx, f.offset, -1)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+		}
+		f.r = r
+	}
+	n, err := f.r.Read(p)
+	f.offset += int64(n)
+	if err != nil && err != io.EOF {
+		err = &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read. The next Read after a Seek starts
+// a new ranged read, unless the offset didn't change.
+func (f *bucketFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	if offset != f.offset && f.r != nil {
+		f.r.Close()
+		f.r = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// ReadAt reads len(p) bytes at offset off with a ranged read. It doesn't
+// change the offset of Read.
+func (f *bucketFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	size := f.info.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	length := int64(len(p))
+	if off+length > size {
+		length = size - off
+	}
+	if length == 0 {
+		return 0, nil
+	}
+	r, err := f.o.NewRangeReader(f.ctx, off, length)
+	if err != nil {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+	}
+	defer r.Close()
+	n, err := io.ReadFull(r, p[:length])
+	if err != nil {
+		return n, &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *bucketFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.r != nil {
+		return f.r.Close()
+	}
+	return nil
+}
+
+// bucketDir is an open directory of a BucketFS.
+type bucketDir struct {
+	fsys *BucketFS
+	name string
+	info *fileInfo
+
+	entries []fs.DirEntry // nil until the first ReadDir
+	closed  bool
+}
+
+func (d *bucketDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *bucketDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir lists the directory on the first call, and returns the entries
+// in order over this and later calls.
+func (d *bucketDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+	if d.entries == nil {
+		entries, err := d.fsys.readDir(d.name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: err}
+		}
+		if entries == nil {
+			entries = []fs.DirEntry{}
+		}
+		d.entries = entries
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = d.entries[len(d.entries):]
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *bucketDir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
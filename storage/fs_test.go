@@ -0,0 +1,133 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestBucketFS(t *testing.T) {
+	ctx := context.Background()
+	b := newFakeBucket(t, false)
+	objects := map[string]string{
+		"a.txt":         "hello",
+		"empty":         "",
+		"dir/b.txt":     strings.Repeat("abcdefghij", 1000),
+		"dir/sub/c.txt": "c",
+		"placeholder/":  "",
+		"shadow":        "a file",
+		"shadow/d.txt":  "hidden by the file",
+		"bad//name":     "not a valid path",
+	}
+	for name, contents := range objects {
+		w := b.Object(name).NewWriter(ctx)
+		io.WriteString(w, contents)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys := b.FS(ctx)
+	if err := fstest.TestFS(fsys, "a.txt", "empty", "dir/b.txt", "dir/sub/c.txt", "placeholder", "shadow"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fs.ReadFile(fsys, "dir/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != objects["dir/b.txt"] {
+		t.Errorf("dir/b.txt: got %d bytes that differ from the object", len(got))
+	}
+	info, err := fs.Stat(fsys, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs, ok := info.Sys().(*ObjectAttrs); !ok || attrs.Name != "a.txt" || info.Size() != 5 {
+		t.Errorf("a.txt: got info %+v", info)
+	}
+	if info, err := fs.Stat(fsys, "shadow"); err != nil || info.IsDir() {
+		t.Errorf("shadow: got %v, %v; want a file", info, err)
+	}
+	entries, err := fs.ReadDir(fsys, "placeholder")
+	if err != nil || len(entries) != 0 {
+		t.Errorf("placeholder: got %v, %v; want an empty directory", entries, err)
+	}
+	for _, name := range []string{"missing", "dir/missing", "a.txt/x"} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open(%q): got %v, want fs.ErrNotExist", name, err)
+		}
+	}
+	if _, err := fsys.Open("/a.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Open of an invalid path: got %v, want fs.ErrInvalid", err)
+	}
+	if _, err := fs.ReadDir(fsys, "a.txt"); err == nil {
+		t.Error("ReadDir of a file: got nil, want error")
+	}
+}
+
+func TestBucketFSSeek(t *testing.T) {
+	ctx := context.Background()
+	b := newFakeBucket(t, false)
+	w := b.Object("f").NewWriter(ctx)
+	io.WriteString(w, "0123456789")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := b.FS(ctx).Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := f.(io.ReadSeeker)
+
+	read := func(n int) string {
+		t.Helper()
+		buf := make([]byte, n)
+		n, err := io.ReadFull(s, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+	if got := read(3); got != "012" {
+		t.Errorf("got %q, want 012", got)
+	}
+	if _, err := s.Seek(5, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(5); got != "89" {
+		t.Errorf("after SeekCurrent: got %q, want 89", got)
+	}
+	if _, err := s.Seek(-4, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(2); got != "67" {
+		t.Errorf("after SeekEnd: got %q, want 67", got)
+	}
+	if _, err := s.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seek before the start: got nil, want error")
+	}
+	buf := make([]byte, 4)
+	if n, err := f.(io.ReaderAt).ReadAt(buf, 8); n != 2 || err != io.EOF || string(buf[:n]) != "89" {
+		t.Errorf("ReadAt: got %d, %v, %q", n, err, buf[:n])
+	}
+}
@@ -989,6 +989,10 @@ func (c *grpcStorageClient) OpenWriter(params *openWriterParams, opts ...storage
 	pr, pw := io.Pipe()
 	gw := newGRPCWriter(c, params, pr)
 	gw.settings = s
+	if params.sessionURI != "" {
+		gw.upid = params.sessionURI
+		offset = params.offset
+	}
 	if s.userProject != "" {
 		gw.ctx = setUserProjectMetadata(gw.ctx, s.userProject)
 	}
@@ -1022,6 +1026,9 @@ func (c *grpcStorageClient) OpenWriter(params *openWriterParams, opts ...storage
 					pr.CloseWithError(err)
 					return
 				}
+				if params.trackSession {
+					params.setSession(gw.upid)
+				}
 			}
 
 			o, off, finalized, err := gw.uploadBuffer(recvd, offset, doneReading)
@@ -1050,6 +1057,30 @@ func (c *grpcStorageClient) OpenWriter(params *openWriterParams, opts ...storage
 	return pw, nil
 }
 
+func (c *grpcStorageClient) QueryUpload(ctx context.Context, sessionURI string, encryptionKey []byte, opts ...storageOption) (int64, *ObjectAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	if s.userProject != "" {
+		ctx = setUserProjectMetadata(ctx, s.userProject)
+	}
+	req := &storagepb.QueryWriteStatusRequest{
+		UploadId:                  sessionURI,
+		CommonObjectRequestParams: toProtoCommonObjectRequestParams(encryptionKey),
+	}
+	var resp *storagepb.QueryWriteStatusResponse
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.QueryWriteStatus(ctx, req, s.gax...)
+		return err
+	}, s.retry, true, setRetryHeaderGRPC(ctx))
+	if err != nil {
+		return 0, nil, err
+	}
+	if o := resp.GetResource(); o != nil {
+		return o.GetSize(), newObjectFromProto(o), nil
+	}
+	return resp.GetPersistedSize(), nil, nil
+}
+
 // IAM methods.
 
 func (c *grpcStorageClient) GetIamPolicy(ctx context.Context, resource string, version int32, opts ...storageOption) (*iampb.Policy, error) {
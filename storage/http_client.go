@@ -15,6 +15,7 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"errors"
@@ -31,6 +32,7 @@ import (
 
 	"cloud.google.com/go/internal/optional"
 	"cloud.google.com/go/internal/trace"
+	gax "github.com/googleapis/gax-go/v2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
@@ -979,79 +981,23 @@ func (c *httpStorageClient) OpenWriter(params *openWriterParams, opts ...storage
 	s := callSettings(c.settings, opts...)
 	errorf := params.setError
 	setObj := params.setObj
-	progress := params.progress
 	attrs := params.attrs
 
-	mediaOpts := []googleapi.MediaOption{
-		googleapi.ChunkSize(params.chunkSize),
-	}
-	if c := attrs.ContentType; c != "" {
-		mediaOpts = append(mediaOpts, googleapi.ContentType(c))
-	}
-	if params.chunkRetryDeadline != 0 {
-		mediaOpts = append(mediaOpts, googleapi.ChunkRetryDeadline(params.chunkRetryDeadline))
-	}
-
 	pr, pw := io.Pipe()
 
 	go func() {
 		defer close(params.donec)
 
-		rawObj := attrs.toRawObject(params.bucket)
-		if params.sendCRC32C {
-			rawObj.Crc32c = encodeUint32(attrs.CRC32C)
-		}
-		if attrs.MD5 != nil {
-			rawObj.Md5Hash = base64.StdEncoding.EncodeToString(attrs.MD5)
-		}
-		call := c.raw.Objects.Insert(params.bucket, rawObj).
-			Media(pr, mediaOpts...).
-			Projection("full").
-			Context(params.ctx).
-			Name(params.attrs.Name)
-		call.ProgressUpdater(func(n, _ int64) { progress(n) })
-
-		if attrs.KMSKeyName != "" {
-			call.KmsKeyName(attrs.KMSKeyName)
-		}
-		if attrs.PredefinedACL != "" {
-			call.PredefinedAcl(attrs.PredefinedACL)
-		}
-		if err := setEncryptionHeaders(call.Header(), params.encryptionKey, false); err != nil {
-			errorf(err)
-			pr.CloseWithError(err)
-			return
-		}
-		var resp *raw.Object
-		err := applyConds("NewWriter", defaultGen, params.conds, call)
-		if err == nil {
-			if s.userProject != "" {
-				call.UserProject(s.userProject)
-			}
-			// TODO(tritone): Remove this code when Uploads begin to support
-			// retry attempt header injection with "client header" injection.
-			setClientHeader(call.Header())
-
-			// The internals that perform call.Do automatically retry both the initial
-			// call to set up the upload as well as calls to upload individual chunks
-			// for a resumable upload (as long as the chunk size is non-zero). Hence
-			// there is no need to add retries here.
-
-			// Retry only when the operation is idempotent or the retry policy is RetryAlways.
-			var useRetry bool
-			if (s.retry == nil || s.retry.policy == RetryIdempotent) && s.idempotent {
-				useRetry = true
-			} else if s.retry != nil && s.retry.policy == RetryAlways {
-				useRetry = true
-			}
-			if useRetry {
-				if s.retry != nil {
-					call.WithRetry(s.retry.backoff, s.retry.shouldRetry)
-				} else {
-					call.WithRetry(nil, nil)
-				}
-			}
-			resp, err = call.Do()
+		var (
+			resp *raw.Object
+			err  error
+		)
+		// Only Writers that track their session use uploadChunks; the others
+		// upload with the google.golang.org/api upload code.
+		if !params.trackSession {
+			resp, err = c.insertObject(params, s, pr, attrs.ContentType)
+		} else {
+			resp, err = c.uploadChunks(params, s, pr)
 		}
 		if err != nil {
 			errorf(err)
@@ -1064,6 +1010,137 @@ func (c *httpStorageClient) OpenWriter(params *openWriterParams, opts ...storage
 	return pw, nil
 }
 
+// uploadChunks uploads the data read from r in chunks of params.chunkSize,
+// reporting the resumable upload session with params.setSession.
+// Data that fits in one chunk is uploaded with a single request. Otherwise
+// uploadChunks uses a resumable upload session, either a new one or the one
+// in params.sessionURI.
+func (c *httpStorageClient) uploadChunks(params *openWriterParams, s *settings, r io.Reader) (*raw.Object, error) {
+	chunkSize := params.chunkSize
+	if rem := chunkSize % googleapi.MinUploadChunkSize; rem != 0 {
+		chunkSize += googleapi.MinUploadChunkSize - rem
+	}
+	contentType := params.attrs.ContentType
+	if contentType == "" && params.sessionURI == "" {
+		r, contentType = gax.DetermineContentType(r)
+	}
+	buf := make([]byte, chunkSize)
+	read := func() (int, bool, error) {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return n, true, nil
+		}
+		return n, false, err
+	}
+	n, done, err := read()
+	if err != nil {
+		return nil, err
+	}
+
+	var up *resumableUpload
+	if params.sessionURI != "" {
+		up = c.newResumableUpload(params, s, params.sessionURI, params.offset)
+	} else if done {
+		return c.insertObject(params, s, bytes.NewReader(buf[:n]), contentType)
+	} else {
+		up, err = c.startResumableUpload(params, s, contentType)
+		if err != nil {
+			return nil, err
+		}
+		params.setSession(up.uri)
+	}
+	for {
+		obj, err := up.upload(buf[:n], done)
+		if err != nil {
+			return nil, err
+		}
+		params.progress(up.offset)
+		if done {
+			return obj, nil
+		}
+		if n, done, err = read(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// insertObject uploads the object with a single call to the Objects.Insert
+// method. media must fit in one chunk unless params.chunkSize is zero.
+func (c *httpStorageClient) insertObject(params *openWriterParams, s *settings, media io.Reader, contentType string) (*raw.Object, error) {
+	attrs := params.attrs
+	mediaOpts := []googleapi.MediaOption{
+		googleapi.ChunkSize(params.chunkSize),
+	}
+	if contentType != "" {
+		mediaOpts = append(mediaOpts, googleapi.ContentType(contentType))
+	}
+	if params.chunkRetryDeadline != 0 {
+		mediaOpts = append(mediaOpts, googleapi.ChunkRetryDeadline(params.chunkRetryDeadline))
+	}
+
+	rawObj := attrs.toRawObject(params.bucket)
+	if params.sendCRC32C {
+		rawObj.Crc32c = encodeUint32(attrs.CRC32C)
+	}
+	if attrs.MD5 != nil {
+		rawObj.Md5Hash = base64.StdEncoding.EncodeToString(attrs.MD5)
+	}
+	call := c.raw.Objects.Insert(params.bucket, rawObj).
+		Media(media, mediaOpts...).
+		Projection("full").
+		Context(params.ctx).
+		Name(params.attrs.Name)
+	call.ProgressUpdater(func(n, _ int64) { params.progress(n) })
+
+	if attrs.KMSKeyName != "" {
+		call.KmsKeyName(attrs.KMSKeyName)
+	}
+	if attrs.PredefinedACL != "" {
+		call.PredefinedAcl(attrs.PredefinedACL)
+	}
+	if err := setEncryptionHeaders(call.Header(), params.encryptionKey, false); err != nil {
+		return nil, err
+	}
+	if err := applyConds("NewWriter", defaultGen, params.conds, call); err != nil {
+		return nil, err
+	}
+	if s.userProject != "" {
+		call.UserProject(s.userProject)
+	}
+	// TODO(tritone): Remove this code when Uploads begin to support
+	// retry attempt header injection with "client header" injection.
+	setClientHeader(call.Header())
+
+	// Retry only when the operation is idempotent or the retry policy is RetryAlways.
+	var useRetry bool
+	if (s.retry == nil || s.retry.policy == RetryIdempotent) && s.idempotent {
+		useRetry = true
+	} else if s.retry != nil && s.retry.policy == RetryAlways {
+		useRetry = true
+	}
+	if useRetry {
+		if s.retry != nil {
+			call.WithRetry(s.retry.backoff, s.retry.shouldRetry)
+		} else {
+			call.WithRetry(nil, nil)
+		}
+	}
+	return call.Do()
+}
+
+func (c *httpStorageClient) QueryUpload(ctx context.Context, sessionURI string, encryptionKey []byte, opts ...storageOption) (int64, *ObjectAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	up := c.newResumableUpload(&openWriterParams{ctx: ctx, encryptionKey: encryptionKey}, s, sessionURI, 0)
+	obj, persisted, err := up.query()
+	if err != nil {
+		return 0, nil, err
+	}
+	if obj != nil {
+		return int64(obj.Size), newObject(obj), nil
+	}
+	return persisted, nil, nil
+}
+
 // IAM methods.
 
 func (c *httpStorageClient) GetIamPolicy(ctx context.Context, resource string, version int32, opts ...storageOption) (*iampb.Policy, error) {
@@ -0,0 +1,396 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxComposeSources is the most objects that a compose request can
+	// concatenate.
+	maxComposeSources = 32
+
+	// maxComponentCount is the most parts that a composite object can have,
+	// counting the parts of any composite objects it was composed from.
+	maxComponentCount = 1024
+
+	defaultParallelPartSize = 32 * 1024 * 1024
+	defaultParallelWorkers  = 8
+
+	// DefaultParallelUploadPrefix is the default prefix of the names of the
+	// temporary objects of a ParallelWriter.
+	DefaultParallelUploadPrefix = "gcs-go-parallel-upload/"
+)
+
+// A ParallelWriter writes a Cloud Storage object with a parallel composite
+// upload. It splits the data written into parts, uploads each part
+// concurrently as a temporary object in the same bucket, and composes the
+// parts into the destination object when it is closed. The temporary
+// objects are deleted by Close, whether or not the upload succeeds.
+//
+// Parallel composite uploads can be faster than a Writer for large objects,
+// but the resulting object is a composite object, which has no MD5 hash.
+// Temporary objects count towards the bucket's storage while they exist,
+// and are subject to the bucket's retention policy and soft-delete rules.
+// Customer-supplied encryption keys aren't supported.
+type ParallelWriter struct {
+	// ObjectAttrs are optional attributes to set on the object. Any attributes
+	// must be initialized before the first Write call. Nil or zero-valued
+	// attributes are ignored.
+	ObjectAttrs
+
+	// PartSize is the size of each part. It must be set before the first
+	// Write call. The default is 32MiB. The Writer buffers up to Workers
+	// parts in memory. An object can have at most 1024 parts.
+	PartSize int
+
+	// Workers is the maximum number of parts that are uploaded at once. It
+	// must be set before the first Write call. The default is 8.
+	Workers int
+
+	// TempPrefix is the prefix of the names of the temporary objects. It
+	// must be set before the first Write call. The default is
+	// DefaultParallelUploadPrefix.
+	TempPrefix string
+
+	ctx context.Context
+	o   *ObjectHandle
+
+	opened   bool
+	closed   bool
+	tempName string // the prefix of this upload's temporary objects
+	buf      []byte
+	nparts   int
+	crc      hash.Hash32
+	size     int64
+	sem      chan struct{}
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	err   error
+	parts []*ObjectHandle // uploaded parts, by index
+	temps []*ObjectHandle // every temporary object that may exist
+	obj   *ObjectAttrs
+}
+
+// NewParallelWriter returns a ParallelWriter that writes to the object
+// associated with this ObjectHandle. Preconditions set with If apply to the
+// destination object.
+//
+// It is the caller's responsibility to call Close when writing is done. To
+// stop writing without saving the data, cancel the context; Close still
+// deletes the temporary objects.
+func (o *ObjectHandle) NewParallelWriter(ctx context.Context) *ParallelWriter {
+	return &ParallelWriter{
+		ctx:         ctx,
+		o:           o,
+		ObjectAttrs: ObjectAttrs{Name: o.object},
+	}
+}
+
+func (w *ParallelWriter) open() error {
+	if err := w.o.validate(); err != nil {
+		return err
+	}
+	if w.o.gen != defaultGen {
+		return fmt.Errorf("storage: generation cannot be specified on a ParallelWriter, got %v", w.o.gen)
+	}
+	if w.o.encryptionKey != nil {
+		return errors.New("storage: ParallelWriter does not support customer-supplied encryption keys")
+	}
+	if w.PartSize < 0 || w.Workers < 0 {
+		return fmt.Errorf("storage: invalid ParallelWriter PartSize %d or Workers %d", w.PartSize, w.Workers)
+	}
+	if w.PartSize == 0 {
+		w.PartSize = defaultParallelPartSize
+	}
+	if w.Workers == 0 {
+		w.Workers = defaultParallelWorkers
+	}
+	if w.TempPrefix == "" {
+		w.TempPrefix = DefaultParallelUploadPrefix
+	}
+	w.tempName = fmt.Sprintf("%s%s/%s/", w.TempPrefix, w.o.object, uuid.New().String())
+	w.crc = crc32.New(crc32cTable)
+	w.sem = make(chan struct{}, w.Workers)
+	w.opened = true
+	return nil
+}
+
+func (w *ParallelWriter) error() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *ParallelWriter) setError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// Write appends to w. It implements the io.Writer interface.
+//
+// Parts are uploaded in the background, so Write may return a nil error
+// even though the upload failed (or will fail). Always use the error
+// returned from Close to determine if the upload was successful. Write
+// blocks while Workers parts are being uploaded.
+func (w *ParallelWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("storage: ParallelWriter is closed")
+	}
+	if err := w.error(); err != nil {
+		return 0, err
+	}
+	if !w.opened {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	if w.ContentType == "" && w.size == 0 && len(p) > 0 {
+		w.ContentType = http.DetectContentType(p)
+	}
+	n := 0
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, w.PartSize)
+		}
+		m := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		w.crc.Write(p[:m])
+		w.size += int64(m)
+		n += m
+		p = p[m:]
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush starts uploading the buffered data as the next part.
+func (w *ParallelWriter) flush() error {
+	if w.nparts == maxComponentCount {
+		err := fmt.Errorf("storage: ParallelWriter can't write more than %d parts; increase PartSize", maxComponentCount)
+		w.setError(err)
+		return err
+	}
+	select {
+	case w.sem <- struct{}{}:
+	case <-w.ctx.Done():
+		w.setError(w.ctx.Err())
+		return w.ctx.Err()
+	}
+	i, data := w.nparts, w.buf
+	w.nparts++
+	w.buf = nil
+	part := w.tempObject(fmt.Sprintf("part-%06d", i))
+	w.mu.Lock()
+	w.temps = append(w.temps, part)
+	w.parts = append(w.parts, nil)
+	w.mu.Unlock()
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() { <-w.sem }()
+		attrs, err := w.upload(part, data)
+		if err != nil {
+			w.setError(err)
+			return
+		}
+		w.mu.Lock()
+		w.parts[i] = part.Generation(attrs.Generation)
+		w.mu.Unlock()
+	}()
+	return nil
+}
+
+// upload writes a temporary object with the given contents.
+func (w *ParallelWriter) upload(o *ObjectHandle, data []byte) (*ObjectAttrs, error) {
+	ow := o.If(Conditions{DoesNotExist: true}).NewWriter(w.ctx)
+	// Send the part in a single request, which is retried on transient errors.
+	ow.ChunkSize = len(data)
+	ow.ContentType = "application/octet-stream"
+	ow.CRC32C = crc32.Checksum(data, crc32cTable)
+	ow.SendCRC32C = true
+	if _, err := ow.Write(data); err != nil {
+		ow.Close()
+		return nil, err
+	}
+	if err := ow.Close(); err != nil {
+		return nil, err
+	}
+	return ow.Attrs(), nil
+}
+
+// Close uploads the last part, composes the parts into the destination
+// object, checks the object's CRC32C checksum, and deletes the temporary
+// objects. It returns the first error of the upload. If the object was
+// written but a temporary object couldn't be deleted, Close returns an
+// error and Attrs returns the object's attributes.
+func (w *ParallelWriter) Close() error {
+	if w.closed {
+		return w.error()
+	}
+	w.closed = true
+	if !w.opened {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	if w.nparts == 0 {
+		// The object fits in a single part, so write it directly.
+		return w.writeDirect()
+	}
+	if len(w.buf) > 0 {
+		w.flush()
+	}
+	w.wg.Wait()
+	err := w.error()
+	if err == nil {
+		w.obj, err = w.compose()
+	}
+	if err == nil && (w.obj.CRC32C != w.crc.Sum32() || w.obj.Size != w.size) {
+		err = fmt.Errorf("storage: composed object has CRC32C %d and size %d, want %d and %d", w.obj.CRC32C, w.obj.Size, w.crc.Sum32(), w.size)
+	}
+	if cerr := w.cleanup(); err == nil && cerr != nil {
+		err = cerr
+	}
+	w.setError(err)
+	return err
+}
+
+func (w *ParallelWriter) writeDirect() error {
+	ow := w.o.NewWriter(w.ctx)
+	ow.ObjectAttrs = w.ObjectAttrs
+	ow.CRC32C = w.crc.Sum32()
+	ow.SendCRC32C = true
+	if _, err := ow.Write(w.buf); err != nil {
+		ow.Close()
+		w.setError(err)
+		return err
+	}
+	err := ow.Close()
+	if err == nil {
+		w.obj = ow.Attrs()
+	}
+	w.setError(err)
+	return err
+}
+
+// compose concatenates the parts into the destination object. If there are
+// more parts than a compose request allows, it first composes them into
+// temporary objects, as many times as necessary.
+func (w *ParallelWriter) compose() (*ObjectAttrs, error) {
+	srcs := w.parts
+	for level := 0; len(srcs) > maxComposeSources; level++ {
+		n := (len(srcs) + maxComposeSources - 1) / maxComposeSources
+		next := make([]*ObjectHandle, n)
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			end := (i + 1) * maxComposeSources
+			if end > len(srcs) {
+				end = len(srcs)
+			}
+			dst := w.tempObject(fmt.Sprintf("compose-%d-%06d", level, i))
+			w.temps = append(w.temps, dst)
+			wg.Add(1)
+			w.sem <- struct{}{}
+			go func(i int, dst *ObjectHandle, srcs []*ObjectHandle) {
+				defer wg.Done()
+				defer func() { <-w.sem }()
+				c := dst.If(Conditions{DoesNotExist: true}).ComposerFrom(srcs...)
+				c.ContentType = "application/octet-stream"
+				attrs, err := c.Run(w.ctx)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				next[i] = dst.Generation(attrs.Generation)
+			}(i, dst, srcs[i*maxComposeSources:end])
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+		srcs = next
+	}
+	c := w.o.ComposerFrom(srcs...)
+	c.ObjectAttrs = w.ObjectAttrs
+	c.CRC32C = w.crc.Sum32()
+	c.SendCRC32C = true
+	return c.Run(w.ctx)
+}
+
+// tempObject returns a handle for the named temporary object. It has the
+// user project and retry settings of the target, so that requests for it are
+// billed and retried the same way.
+func (w *ParallelWriter) tempObject(name string) *ObjectHandle {
+	b := &BucketHandle{c: w.o.c, name: w.o.bucket, userProject: w.o.userProject, retry: w.o.retry}
+	return b.Object(w.tempName + name)
+}
+
+// cleanup deletes the temporary objects. It uses a context that isn't
+// canceled with the Writer's, so that it runs even if the upload was
+// canceled.
+func (w *ParallelWriter) cleanup() error {
+	ctx := context.Background()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, w.Workers)
+	for _, o := range w.temps {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(o *ObjectHandle) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := o.Retryer(WithPolicy(RetryAlways)).Delete(ctx)
+			if err != nil && err != ErrObjectNotExist {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("storage: deleting temporary object %q: %w", o.object, err)
+				}
+				mu.Unlock()
+			}
+		}(o)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// Attrs returns metadata about a successfully-written object.
+// It's only valid to call it after Close returns.
+func (w *ParallelWriter) Attrs() *ObjectAttrs {
+	return w.obj
+}
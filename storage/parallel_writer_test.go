@@ -0,0 +1,239 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage/storagetest"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// newFakeBucket returns a bucket of a fake server, using gRPC if useGRPC is
+// true and HTTP otherwise.
+func newFakeBucket(t *testing.T, useGRPC bool) *BucketHandle {
+	t.Helper()
+	ctx := context.Background()
+	srv, err := storagetest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	opts := srv.HTTPClientOptions()
+	if useGRPC {
+		t.Setenv("STORAGE_USE_GRPC", "true")
+		opts = srv.GRPCClientOptions()
+	}
+	c, err := NewClient(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	b := c.Bucket("bucket")
+	if err := b.Create(ctx, "project", nil); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// objectNames returns the names of the objects in a bucket.
+func objectNames(t *testing.T, b *BucketHandle) []string {
+	t.Helper()
+	var names []string
+	it := b.Objects(context.Background(), nil)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, attrs.Name)
+	}
+}
+
+func TestParallelWriter(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		desc string
+		size int
+	}{
+		{"empty", 0},
+		{"one part", 50},
+		{"exactly one part", 100},
+		{"one compose", 100*maxComposeSources - 1},
+		{"recursive compose", 100*maxComposeSources + 1},
+		{"most parts", 100 * maxComponentCount},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			b := newFakeBucket(t, false)
+			data := make([]byte, test.size)
+			rand.New(rand.NewSource(1)).Read(data)
+
+			w := b.Object("obj").NewParallelWriter(ctx)
+			w.PartSize = 100
+			w.Workers = 4
+			w.ContentType = "text/plain"
+			w.Metadata = map[string]string{"k": "v"}
+			if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			attrs := w.Attrs()
+			if attrs.Size != int64(test.size) || attrs.ContentType != "text/plain" || attrs.Metadata["k"] != "v" {
+				t.Errorf("got attrs %+v", attrs)
+			}
+
+			r, err := b.Object("obj").NewReader(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("read %d bytes that differ from the %d written", len(got), len(data))
+			}
+			if names := objectNames(t, b); len(names) != 1 {
+				t.Errorf("got objects %q, want only the destination", names)
+			}
+		})
+	}
+}
+
+func TestParallelWriterTooManyParts(t *testing.T) {
+	b := newFakeBucket(t, false)
+	w := b.Object("obj").NewParallelWriter(context.Background())
+	w.PartSize = 1
+	if _, err := w.Write(make([]byte, maxComponentCount+1)); err == nil {
+		t.Error("Write: got nil, want error")
+	}
+	if err := w.Close(); err == nil {
+		t.Error("Close: got nil, want error")
+	}
+	if names := objectNames(t, b); len(names) != 0 {
+		t.Errorf("got objects %q, want none", names)
+	}
+}
+
+func TestParallelWriterCleansUpOnFailure(t *testing.T) {
+	ctx := context.Background()
+	b := newFakeBucket(t, false)
+	o := b.Object("obj")
+	old := o.NewWriter(ctx)
+	io.WriteString(old, "old")
+	if err := old.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := o.If(Conditions{DoesNotExist: true}).NewParallelWriter(ctx)
+	w.PartSize = 10
+	if _, err := w.Write(bytes.Repeat([]byte("x"), 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil {
+		t.Fatal("got nil, want a precondition error")
+	}
+	if names := objectNames(t, b); len(names) != 1 || names[0] != "obj" {
+		t.Errorf("got objects %q, want only the original", names)
+	}
+}
+
+// userProjectRecorder is an http.RoundTripper that records the requests it
+// sends that don't have the wanted userProject parameter.
+type userProjectRecorder struct {
+	want string
+
+	mu      sync.Mutex
+	counts  map[string]int // requests by kind
+	missing []string
+}
+
+func (r *userProjectRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	kind := "other"
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/upload/"):
+		kind = "upload"
+	case strings.HasSuffix(req.URL.Path, "/compose"):
+		kind = "compose"
+	case req.Method == http.MethodDelete:
+		kind = "delete"
+	}
+	r.mu.Lock()
+	r.counts[kind]++
+	if got := req.URL.Query().Get("userProject"); got != r.want {
+		r.missing = append(r.missing, req.Method+" "+req.URL.Path)
+	}
+	r.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestParallelWriterUserProject(t *testing.T) {
+	ctx := context.Background()
+	srv, err := storagetest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	rec := &userProjectRecorder{want: "billed", counts: make(map[string]int)}
+	c, err := NewClient(ctx, append(srv.HTTPClientOptions(), option.WithHTTPClient(&http.Client{Transport: rec}))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Bucket("bucket").Create(ctx, "project", nil); err != nil {
+		t.Fatal(err)
+	}
+	rec.mu.Lock()
+	rec.counts = make(map[string]int)
+	rec.missing = nil
+	rec.mu.Unlock()
+
+	// Enough parts for intermediate composes.
+	w := c.Bucket("bucket").UserProject("billed").Object("obj").NewParallelWriter(ctx)
+	w.PartSize = 10
+	w.Workers = 4
+	if _, err := w.Write(make([]byte, 10*maxComposeSources+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := maxComposeSources + 1; rec.counts["upload"] != want {
+		t.Errorf("got %d uploads, want %d", rec.counts["upload"], want)
+	}
+	if want := 3; rec.counts["compose"] != want {
+		t.Errorf("got %d composes, want %d", rec.counts["compose"], want)
+	}
+	if rec.counts["delete"] == 0 {
+		t.Error("no temporary objects deleted")
+	}
+	if len(rec.missing) > 0 {
+		t.Errorf("requests without userProject=%s: %q", rec.want, rec.missing)
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/googleapi"
+	raw "google.golang.org/api/storage/v1"
+)
+
+// defaultChunkRetryDeadline is the default Writer.ChunkRetryDeadline, as in
+// the google.golang.org/api upload code that Writer otherwise uses.
+const defaultChunkRetryDeadline = 32 * time.Second
+
+// resumableUpload is a resumable upload session of the JSON API. The Writer
+// uses one for uploads of more than one chunk, so that the session URI can
+// be reported to the user, and so that an upload can be continued by a later
+// Writer. See https://cloud.google.com/storage/docs/performing-resumable-uploads.
+type resumableUpload struct {
+	c             *httpStorageClient
+	ctx           context.Context
+	settings      *settings
+	uri           string
+	encryptionKey []byte
+	retryDeadline time.Duration
+	useRetry      bool
+
+	// offset is the number of bytes persisted by the service.
+	offset int64
+}
+
+// uploadQuery sets the preconditions of an upload as query parameters. It
+// has the methods that applyConds looks for.
+type uploadQuery url.Values
+
+func (q uploadQuery) IfGenerationMatch(gen int64) {
+	url.Values(q).Set("ifGenerationMatch", strconv.FormatInt(gen, 10))
+}
+
+func (q uploadQuery) IfGenerationNotMatch(gen int64) {
+	url.Values(q).Set("ifGenerationNotMatch", strconv.FormatInt(gen, 10))
+}
+
+func (q uploadQuery) IfMetagenerationMatch(gen int64) {
+	url.Values(q).Set("ifMetagenerationMatch", strconv.FormatInt(gen, 10))
+}
+
+func (q uploadQuery) IfMetagenerationNotMatch(gen int64) {
+	url.Values(q).Set("ifMetagenerationNotMatch", strconv.FormatInt(gen, 10))
+}
+
+// newResumableUpload returns a resumableUpload for the session with the
+// given URI, which has persisted offset bytes.
+func (c *httpStorageClient) newResumableUpload(params *openWriterParams, s *settings, uri string, offset int64) *resumableUpload {
+	u := &resumableUpload{
+		c:             c,
+		ctx:           params.ctx,
+		settings:      s,
+		uri:           uri,
+		encryptionKey: params.encryptionKey,
+		retryDeadline: params.chunkRetryDeadline,
+		offset:        offset,
+	}
+	if u.retryDeadline == 0 {
+		u.retryDeadline = defaultChunkRetryDeadline
+	}
+	// Retry only when the operation is idempotent or the retry policy is
+	// RetryAlways, as for uploads by the google.golang.org/api code.
+	if (s.retry == nil || s.retry.policy == RetryIdempotent) && s.idempotent {
+		u.useRetry = true
+	} else if s.retry != nil && s.retry.policy == RetryAlways {
+		u.useRetry = true
+	}
+	return u
+}
+
+// startResumableUpload starts a resumable upload session for the object
+// described by params. contentType is the content type of the data.
+func (c *httpStorageClient) startResumableUpload(params *openWriterParams, s *settings, contentType string) (*resumableUpload, error) {
+	attrs := params.attrs
+	rawObj := attrs.toRawObject(params.bucket)
+	if params.sendCRC32C {
+		rawObj.Crc32c = encodeUint32(attrs.CRC32C)
+	}
+	if attrs.MD5 != nil {
+		rawObj.Md5Hash = base64.StdEncoding.EncodeToString(attrs.MD5)
+	}
+	body, err := json.Marshal(rawObj)
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{
+		"alt":         {"json"},
+		"prettyPrint": {"false"},
+		"uploadType":  {"resumable"},
+		"name":        {attrs.Name},
+		"projection":  {"full"},
+	}
+	if attrs.KMSKeyName != "" {
+		q.Set("kmsKeyName", attrs.KMSKeyName)
+	}
+	if attrs.PredefinedACL != "" {
+		q.Set("predefinedAcl", attrs.PredefinedACL)
+	}
+	if s.userProject != "" {
+		q.Set("userProject", s.userProject)
+	}
+	if err := applyConds("NewWriter", defaultGen, params.conds, uploadQuery(q)); err != nil {
+		return nil, err
+	}
+	u := googleapi.ResolveRelative(c.raw.BasePath, "/upload/storage/v1/b/"+url.PathEscape(params.bucket)+"/o") + "?" + q.Encode()
+
+	req, err := http.NewRequest("POST", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	if contentType != "" {
+		req.Header.Set("X-Upload-Content-Type", contentType)
+	}
+	if err := setEncryptionHeaders(req.Header, params.encryptionKey, false); err != nil {
+		return nil, err
+	}
+
+	up := c.newResumableUpload(params, s, "", 0)
+	err = run(params.ctx, func() error {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		res, err := c.hc.Do(req.WithContext(params.ctx))
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if err := googleapi.CheckResponse(res); err != nil {
+			return err
+		}
+		up.uri = res.Header.Get("Location")
+		if up.uri == "" {
+			return errors.New("storage: resumable upload response has no Location header")
+		}
+		return nil
+	}, s.retry, s.idempotent, setRetryHeaderHTTP(headerer(req.Header)))
+	if err != nil {
+		return nil, err
+	}
+	return up, nil
+}
+
+// headerer adapts an http.Header for setRetryHeaderHTTP.
+type headerer http.Header
+
+func (h headerer) Header() http.Header { return http.Header(h) }
+
+// upload sends data, which starts at the offset persisted by the service. If
+// final is true, data is the end of the object, and upload returns the new
+// object. Otherwise the length of data must be a multiple of
+// googleapi.MinUploadChunkSize.
+func (u *resumableUpload) upload(data []byte, final bool) (*raw.Object, error) {
+	bo := gax.Backoff{}
+	shouldRetry := ShouldRetry
+	if r := u.settings.retry; r != nil {
+		if r.backoff != nil {
+			bo.Multiplier = r.backoff.Multiplier
+			bo.Initial = r.backoff.Initial
+			bo.Max = r.backoff.Max
+		}
+		if r.shouldRetry != nil {
+			shouldRetry = r.shouldRetry
+		}
+	}
+	end := u.offset + int64(len(data))
+	deadline := time.Now().Add(u.retryDeadline)
+	for {
+		obj, persisted, err := u.send(data, end, final)
+		if err == nil {
+			if obj != nil {
+				u.offset = end
+				return obj, nil
+			}
+			// The service may persist only some of the data, and says how
+			// much in the Range header. Without one, assume that it
+			// persisted all of it, as the google.golang.org/api code does.
+			if persisted < 0 {
+				persisted = end
+			}
+			if persisted < u.offset || persisted > end {
+				return nil, fmt.Errorf("storage: resumable upload persisted %d bytes, want between %d and %d", persisted, u.offset, end)
+			}
+			data = data[persisted-u.offset:]
+			u.offset = persisted
+			if len(data) == 0 && !final {
+				return nil, nil
+			}
+			continue
+		}
+		if !u.useRetry || !shouldRetry(err) || time.Now().After(deadline) {
+			return nil, err
+		}
+		if err := gax.Sleep(u.ctx, bo.Pause()); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// send sends one request with data, which starts at u.offset, and returns
+// either the finished object or the number of bytes persisted, which is -1
+// if the service didn't say.
+func (u *resumableUpload) send(data []byte, end int64, final bool) (*raw.Object, int64, error) {
+	total := "*"
+	if final {
+		total = strconv.FormatInt(end, 10)
+	}
+	contentRange := fmt.Sprintf("bytes %d-%d/%s", u.offset, end-1, total)
+	if len(data) == 0 {
+		contentRange = "bytes */" + total
+	}
+	return u.do(bytes.NewReader(data), int64(len(data)), contentRange)
+}
+
+// query asks the service for the status of the upload, and returns either
+// the finished object or the number of bytes persisted.
+func (u *resumableUpload) query() (*raw.Object, int64, error) {
+	var (
+		obj       *raw.Object
+		persisted int64
+	)
+	err := run(u.ctx, func() error {
+		var err error
+		obj, persisted, err = u.do(nil, 0, "bytes */*")
+		return err
+	}, u.settings.retry, true, setRetryHeaderHTTP(nil))
+	if persisted < 0 {
+		// Nothing has been persisted.
+		persisted = 0
+	}
+	return obj, persisted, err
+}
+
+func (u *resumableUpload) do(body *bytes.Reader, size int64, contentRange string) (*raw.Object, int64, error) {
+	req, err := http.NewRequest("PUT", u.uri, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if body != nil {
+		req.Body = io.NopCloser(body)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Range", contentRange)
+	// Ask for 200 and X-HTTP-Status-Code-Override instead of the
+	// nonstandard use of 308, which the http package may treat as a redirect.
+	req.Header.Set("X-GUploader-No-308", "yes")
+	setClientHeader(req.Header)
+	if err := setEncryptionHeaders(req.Header, u.encryptionKey, false); err != nil {
+		return nil, 0, err
+	}
+	res, err := u.c.hc.Do(req.WithContext(u.ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusPermanentRedirect || res.Header.Get("X-Http-Status-Code-Override") == "308" {
+		persisted, err := parseUploadRange(res.Header.Get("Range"))
+		return nil, persisted, err
+	}
+	if err := googleapi.CheckResponse(res); err != nil {
+		return nil, 0, err
+	}
+	obj := &raw.Object{}
+	if err := json.NewDecoder(res.Body).Decode(obj); err != nil {
+		return nil, 0, err
+	}
+	return obj, 0, nil
+}
+
+// parseUploadRange returns the number of bytes persisted according to the
+// Range header of an incomplete upload's response, of the form "bytes=0-N",
+// or -1 if there is no header.
+func parseUploadRange(r string) (int64, error) {
+	if r == "" {
+		return -1, nil
+	}
+	last := strings.TrimPrefix(r, "bytes=0-")
+	n, err := strconv.ParseInt(last, 10, 64)
+	if last == r || err != nil {
+		return 0, fmt.Errorf("storage: bad Range header %q in resumable upload response", r)
+	}
+	return n + 1, nil
+}
@@ -0,0 +1,1055 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"hash/crc32"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/internal/testutil"
+	storagepb "cloud.google.com/go/storage/internal/apiv2/stubs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// maxComposeSources is the most source objects a single compose request may name.
+	maxComposeSources = 32
+	// maxComponentCount is the most components a composite object may have.
+	maxComponentCount = 1024
+	// defaultPageSize is the number of items in a list page when the request
+	// doesn't give a page size.
+	defaultPageSize = 1000
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Bucket names are validated loosely: the service has many more rules.
+var bucketNameRE = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,220}[a-z0-9]$`)
+
+// backend holds the state of the fake service. The HTTP and gRPC front ends
+// translate their requests into calls on it.
+//
+// Buckets are keyed by bucket ID. Protos stored in the backend are never
+// handed out: methods return clones.
+type backend struct {
+	mu           sync.Mutex
+	buckets      map[string]*bucket
+	uploads      map[string]*upload
+	lastGen      int64
+	nextUploadID int
+}
+
+type bucket struct {
+	proto *storagepb.Bucket
+	// The versions of each object, oldest first. Only the last version can be
+	// live; noncurrent versions have a DeleteTime.
+	objects       map[string][]*object
+	notifications map[string]*storagepb.Notification // by ID
+	nextNotifID   int
+}
+
+type object struct {
+	proto *storagepb.Object
+	data  []byte // never modified
+}
+
+// An upload is a resumable upload session.
+type upload struct {
+	bucket        string
+	resource      *storagepb.Object
+	conds         conditions
+	predefinedACL string
+	checksums     *storagepb.ObjectChecksums
+	size          int64 // expected object size, or -1 if unknown
+	data          []byte
+	result        *storagepb.Object // set when the upload is finished
+}
+
+func newBackend() *backend {
+	return &backend{
+		buckets: map[string]*bucket{},
+		uploads: map[string]*upload{},
+	}
+}
+
+// conditions are preconditions on an object or bucket. Nil fields are not checked.
+type conditions struct {
+	generationMatch        *int64
+	generationNotMatch     *int64
+	metagenerationMatch    *int64
+	metagenerationNotMatch *int64
+}
+
+// check reports an error unless an object with the given generation and
+// metageneration satisfies the conditions. A generation of zero means the
+// object doesn't exist.
+func (c conditions) check(gen, metagen int64) error {
+	if c.generationMatch != nil && *c.generationMatch != gen {
+		return status.Errorf(codes.FailedPrecondition, "generation is %d, want %d", gen, *c.generationMatch)
+	}
+	if c.generationNotMatch != nil && *c.generationNotMatch == gen {
+		return status.Errorf(codes.FailedPrecondition, "generation is %d", gen)
+	}
+	if c.metagenerationMatch != nil && (gen == 0 || *c.metagenerationMatch != metagen) {
+		return status.Errorf(codes.FailedPrecondition, "metageneration is %d, want %d", metagen, *c.metagenerationMatch)
+	}
+	if c.metagenerationNotMatch != nil && gen != 0 && *c.metagenerationNotMatch == metagen {
+		return status.Errorf(codes.FailedPrecondition, "metageneration is %d", metagen)
+	}
+	return nil
+}
+
+func (c conditions) checkObject(o *object) error {
+	if o == nil {
+		return c.check(0, 0)
+	}
+	return c.check(o.proto.Generation, o.proto.Metageneration)
+}
+
+func (c conditions) checkBucket(b *bucket) error {
+	if c.generationMatch != nil || c.generationNotMatch != nil {
+		return status.Error(codes.InvalidArgument, "buckets don't have generations")
+	}
+	return c.check(1, b.proto.Metageneration)
+}
+
+func bucketResourceName(id string) string {
+	return "projects/_/buckets/" + id
+}
+
+// nextGeneration returns a new object generation. Like the service's, it is
+// based on the current time in microseconds.
+//
+// Must be called with the lock held.
+func (s *backend) nextGeneration() int64 {
+	g := time.Now().UnixMicro()
+	if g <= s.lastGen {
+		g = s.lastGen + 1
+	}
+	s.lastGen = g
+	return g
+}
+
+func etag(gen, metagen int64) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%d/%d", gen, metagen)))
+}
+
+// Must be called with the lock held.
+func (s *backend) bucket(id string) (*bucket, error) {
+	b := s.buckets[id]
+	if b == nil {
+		return nil, status.Errorf(codes.NotFound, "bucket %q not found", id)
+	}
+	return b, nil
+}
+
+func projectACL(project string) []*storagepb.BucketAccessControl {
+	return []*storagepb.BucketAccessControl{
+		{Entity: "project-owners-" + project, Role: "OWNER"},
+		{Entity: "project-editors-" + project, Role: "OWNER"},
+		{Entity: "project-viewers-" + project, Role: "READER"},
+	}
+}
+
+// predefinedBucketACL returns the ACL for the given predefined bucket ACL name.
+func predefinedBucketACL(project, name string) ([]*storagepb.BucketAccessControl, error) {
+	owner := &storagepb.BucketAccessControl{Entity: "project-owners-" + project, Role: "OWNER"}
+	switch name {
+	case "":
+		return projectACL(project), nil
+	case "private":
+		return []*storagepb.BucketAccessControl{owner}, nil
+	case "projectPrivate":
+		return projectACL(project), nil
+	case "publicRead":
+		return []*storagepb.BucketAccessControl{owner, {Entity: "allUsers", Role: "READER"}}, nil
+	case "publicReadWrite":
+		return []*storagepb.BucketAccessControl{owner, {Entity: "allUsers", Role: "WRITER"}}, nil
+	case "authenticatedRead":
+		return []*storagepb.BucketAccessControl{owner, {Entity: "allAuthenticatedUsers", Role: "READER"}}, nil
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "unknown predefined ACL %q", name)
+}
+
+// predefinedObjectACL returns the ACL for the given predefined object ACL name.
+func predefinedObjectACL(project, name string) ([]*storagepb.ObjectAccessControl, error) {
+	owner := &storagepb.ObjectAccessControl{Entity: "project-owners-" + project, Role: "OWNER"}
+	switch name {
+	case "private", "bucketOwnerFullControl":
+		return []*storagepb.ObjectAccessControl{owner}, nil
+	case "bucketOwnerRead":
+		return []*storagepb.ObjectAccessControl{{Entity: "project-owners-" + project, Role: "READER"}}, nil
+	case "", "projectPrivate":
+		var acl []*storagepb.ObjectAccessControl
+		for _, a := range projectACL(project) {
+			acl = append(acl, &storagepb.ObjectAccessControl{Entity: a.Entity, Role: a.Role})
+		}
+		return acl, nil
+	case "publicRead":
+		return []*storagepb.ObjectAccessControl{owner, {Entity: "allUsers", Role: "READER"}}, nil
+	case "authenticatedRead":
+		return []*storagepb.ObjectAccessControl{owner, {Entity: "allAuthenticatedUsers", Role: "READER"}}, nil
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "unknown predefined ACL %q", name)
+}
+
+// projectOf returns the project a bucket was created in.
+func projectOf(b *storagepb.Bucket) string {
+	return strings.TrimPrefix(b.Project, "projects/")
+}
+
+func cloneBucket(b *storagepb.Bucket) *storagepb.Bucket {
+	return proto.Clone(b).(*storagepb.Bucket)
+}
+
+func cloneObject(o *storagepb.Object) *storagepb.Object {
+	return proto.Clone(o).(*storagepb.Object)
+}
+
+// Buckets.
+
+func (s *backend) createBucket(project string, pb *storagepb.Bucket, predefinedACL, predefinedDefaultObjectACL string) (*storagepb.Bucket, error) {
+	id := pb.GetBucketId()
+	if id == "" {
+		id = pb.GetName()
+	}
+	if !bucketNameRE.MatchString(id) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid bucket name %q", id)
+	}
+	if project == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing project")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[id] != nil {
+		return nil, status.Errorf(codes.AlreadyExists, "bucket %q already exists", id)
+	}
+	b := cloneBucket(pb)
+	b.Name = bucketResourceName(id)
+	b.BucketId = id
+	b.Project = "projects/" + project
+	b.Metageneration = 1
+	if b.Location == "" {
+		b.Location = "US"
+	}
+	b.Location = strings.ToUpper(b.Location)
+	if b.LocationType == "" {
+		b.LocationType = "multi-region"
+	}
+	if b.StorageClass == "" {
+		b.StorageClass = "STANDARD"
+	}
+	if predefinedACL != "" || len(b.Acl) == 0 {
+		acl, err := predefinedBucketACL(project, predefinedACL)
+		if err != nil {
+			return nil, err
+		}
+		b.Acl = acl
+	}
+	if predefinedDefaultObjectACL != "" || len(b.DefaultObjectAcl) == 0 {
+		acl, err := predefinedObjectACL(project, predefinedDefaultObjectACL)
+		if err != nil {
+			return nil, err
+		}
+		b.DefaultObjectAcl = acl
+	}
+	if rp := b.RetentionPolicy; rp != nil {
+		rp.EffectiveTime = timestamppb.Now()
+		rp.IsLocked = false
+	}
+	now := timestamppb.Now()
+	b.CreateTime = now
+	b.UpdateTime = now
+	b.Etag = etag(1, b.Metageneration)
+	s.buckets[id] = &bucket{
+		proto:         b,
+		objects:       map[string][]*object{},
+		notifications: map[string]*storagepb.Notification{},
+	}
+	return cloneBucket(b), nil
+}
+
+func (s *backend) getBucket(id string, conds conditions) (*storagepb.Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := conds.checkBucket(b); err != nil {
+		return nil, err
+	}
+	return cloneBucket(b.proto), nil
+}
+
+// listBuckets returns the buckets of a project whose IDs begin with prefix,
+// sorted by ID.
+func (s *backend) listBuckets(project, prefix string) []*storagepb.Bucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var bs []*storagepb.Bucket
+	for id, b := range s.buckets {
+		if projectOf(b.proto) == project && strings.HasPrefix(id, prefix) {
+			bs = append(bs, cloneBucket(b.proto))
+		}
+	}
+	sort.Slice(bs, func(i, j int) bool { return bs[i].BucketId < bs[j].BucketId })
+	return bs
+}
+
+// updateBucket calls update on a copy of the bucket, and stores the result
+// as the bucket's next metageneration.
+func (s *backend) updateBucket(id string, conds conditions, update func(*storagepb.Bucket) error) (*storagepb.Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := conds.checkBucket(b); err != nil {
+		return nil, err
+	}
+	nb := cloneBucket(b.proto)
+	if err := update(nb); err != nil {
+		return nil, err
+	}
+	// Fields the update can't change.
+	old := b.proto
+	nb.Name, nb.BucketId, nb.Project = old.Name, old.BucketId, old.Project
+	nb.Location, nb.LocationType, nb.CreateTime = old.Location, old.LocationType, old.CreateTime
+	if rp := nb.RetentionPolicy; rp != nil {
+		orp := old.RetentionPolicy
+		if orp.GetIsLocked() && rp.GetRetentionPeriod() < orp.GetRetentionPeriod() {
+			return nil, status.Error(codes.FailedPrecondition, "can't reduce a locked retention policy")
+		}
+		if !proto.Equal(rp, orp) {
+			rp.EffectiveTime = timestamppb.Now()
+			rp.IsLocked = orp.GetIsLocked()
+		}
+	} else if old.RetentionPolicy.GetIsLocked() {
+		return nil, status.Error(codes.FailedPrecondition, "can't remove a locked retention policy")
+	}
+	nb.Metageneration = old.Metageneration + 1
+	nb.UpdateTime = timestamppb.Now()
+	nb.Etag = etag(1, nb.Metageneration)
+	b.proto = nb
+	return cloneBucket(nb), nil
+}
+
+// deleteBucket deletes an empty bucket.
+func (s *backend) deleteBucket(id string, conds conditions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(id)
+	if err != nil {
+		return err
+	}
+	if err := conds.checkBucket(b); err != nil {
+		return err
+	}
+	if len(b.objects) > 0 {
+		return status.Errorf(codes.Aborted, "bucket %q is not empty", id)
+	}
+	delete(s.buckets, id)
+	return nil
+}
+
+func (s *backend) lockRetentionPolicy(id string, metagen int64) (*storagepb.Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := (conditions{metagenerationMatch: &metagen}).checkBucket(b); err != nil {
+		return nil, err
+	}
+	if b.proto.RetentionPolicy == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "bucket %q has no retention policy", id)
+	}
+	if !b.proto.RetentionPolicy.IsLocked {
+		b.proto.RetentionPolicy.IsLocked = true
+		b.proto.Metageneration++
+		b.proto.UpdateTime = timestamppb.Now()
+		b.proto.Etag = etag(1, b.proto.Metageneration)
+	}
+	return cloneBucket(b.proto), nil
+}
+
+// Objects.
+
+// live returns the live version of the named object, or nil if there isn't one.
+func (b *bucket) live(name string) *object {
+	vs := b.objects[name]
+	if len(vs) == 0 {
+		return nil
+	}
+	if o := vs[len(vs)-1]; o.proto.DeleteTime == nil {
+		return o
+	}
+	return nil
+}
+
+// version returns the given generation of the named object, or its live
+// version if gen is zero.
+func (b *bucket) version(name string, gen int64) *object {
+	if gen == 0 {
+		return b.live(name)
+	}
+	for _, o := range b.objects[name] {
+		if o.proto.Generation == gen {
+			return o
+		}
+	}
+	return nil
+}
+
+// Must be called with the lock held.
+func (s *backend) lookup(bucketID, name string, gen int64, conds conditions) (*bucket, *object, error) {
+	b, err := s.bucket(bucketID)
+	if err != nil {
+		return nil, nil, err
+	}
+	o := b.version(name, gen)
+	if o == nil {
+		return nil, nil, status.Errorf(codes.NotFound, "object %q not found in bucket %q", name, bucketID)
+	}
+	if err := conds.checkObject(o); err != nil {
+		return nil, nil, err
+	}
+	return b, o, nil
+}
+
+// getObject returns the given generation of an object, or its live version
+// if gen is zero. The returned object's proto is a copy.
+func (s *backend) getObject(bucketID, name string, gen int64, conds conditions) (*object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, o, err := s.lookup(bucketID, name, gen, conds)
+	if err != nil {
+		return nil, err
+	}
+	return &object{proto: cloneObject(o.proto), data: o.data}, nil
+}
+
+// put stores o as the live version of its object. The previous live
+// version becomes noncurrent if the bucket has versioning enabled, and is
+// deleted otherwise.
+//
+// Must be called with the lock held.
+func (s *backend) put(b *bucket, o *object) {
+	vs := b.objects[o.proto.Name]
+	if prev := b.live(o.proto.Name); prev != nil {
+		if b.proto.GetVersioning().GetEnabled() {
+			prev.proto.DeleteTime = o.proto.CreateTime
+		} else {
+			vs = vs[:len(vs)-1]
+		}
+	}
+	b.objects[o.proto.Name] = append(vs, o)
+}
+
+// newObject completes the fields of a new object with the given resource
+// and contents, checking that it matches the given checksums.
+//
+// Must be called with the lock held.
+func (s *backend) newObject(b *bucket, resource *storagepb.Object, data []byte, predefinedACL string, want *storagepb.ObjectChecksums) (*object, error) {
+	if resource.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing object name")
+	}
+	crc := crc32.Checksum(data, crc32cTable)
+	if want != nil && want.Crc32C != nil && *want.Crc32C != crc {
+		return nil, status.Errorf(codes.InvalidArgument, "CRC32C checksum mismatch: got %d, want %d", crc, *want.Crc32C)
+	}
+	sum := md5.Sum(data)
+	if len(want.GetMd5Hash()) > 0 && !bytes.Equal(want.Md5Hash, sum[:]) {
+		return nil, status.Error(codes.InvalidArgument, "MD5 checksum mismatch")
+	}
+	o := cloneObject(resource)
+	o.Bucket = b.proto.Name
+	o.Generation = s.nextGeneration()
+	o.Metageneration = 1
+	o.Size = int64(len(data))
+	o.Checksums = &storagepb.ObjectChecksums{Crc32C: &crc, Md5Hash: sum[:]}
+	o.ComponentCount = 0
+	o.DeleteTime = nil
+	now := timestamppb.Now()
+	o.CreateTime = now
+	o.UpdateTime = now
+	o.UpdateStorageClassTime = now
+	if o.StorageClass == "" {
+		o.StorageClass = b.proto.StorageClass
+	}
+	if o.ContentType == "" {
+		o.ContentType = "application/octet-stream"
+	}
+	if o.EventBasedHold == nil && b.proto.DefaultEventBasedHold {
+		o.EventBasedHold = proto.Bool(true)
+	}
+	switch {
+	case predefinedACL != "":
+		acl, err := predefinedObjectACL(projectOf(b.proto), predefinedACL)
+		if err != nil {
+			return nil, err
+		}
+		o.Acl = acl
+	case len(o.Acl) == 0:
+		for _, a := range b.proto.DefaultObjectAcl {
+			o.Acl = append(o.Acl, proto.Clone(a).(*storagepb.ObjectAccessControl))
+		}
+	}
+	o.Owner = &storagepb.Owner{Entity: "project-owners-" + projectOf(b.proto)}
+	o.Etag = etag(o.Generation, o.Metageneration)
+	return &object{proto: o, data: data}, nil
+}
+
+// insertObject creates a new generation of an object with the given
+// resource and contents.
+func (s *backend) insertObject(bucketID string, resource *storagepb.Object, data []byte, conds conditions, predefinedACL string, checksums *storagepb.ObjectChecksums) (*storagepb.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucketID)
+	if err != nil {
+		return nil, err
+	}
+	if err := conds.checkObject(b.live(resource.GetName())); err != nil {
+		return nil, err
+	}
+	o, err := s.newObject(b, resource, data, predefinedACL, checksums)
+	if err != nil {
+		return nil, err
+	}
+	s.put(b, o)
+	return cloneObject(o.proto), nil
+}
+
+// updateObject calls update on a copy of an object's metadata, and stores
+// the result as the object's next metageneration.
+func (s *backend) updateObject(bucketID, name string, gen int64, conds conditions, update func(*storagepb.Object) error) (*storagepb.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, o, err := s.lookup(bucketID, name, gen, conds)
+	if err != nil {
+		return nil, err
+	}
+	no := cloneObject(o.proto)
+	if err := update(no); err != nil {
+		return nil, err
+	}
+	// Fields the update can't change.
+	old := o.proto
+	no.Name, no.Bucket, no.Generation, no.Size = old.Name, old.Bucket, old.Generation, old.Size
+	no.Checksums, no.ComponentCount, no.CreateTime = old.Checksums, old.ComponentCount, old.CreateTime
+	no.DeleteTime, no.StorageClass, no.Owner = old.DeleteTime, old.StorageClass, old.Owner
+	no.Metageneration = old.Metageneration + 1
+	no.UpdateTime = timestamppb.Now()
+	no.Etag = etag(no.Generation, no.Metageneration)
+	o.proto = no
+	return cloneObject(no), nil
+}
+
+// deleteObject deletes the given generation of an object, or its live
+// version if gen is zero. Deleting the live version of an object in a
+// bucket with versioning enabled makes it noncurrent.
+func (s *backend) deleteObject(bucketID, name string, gen int64, conds conditions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, o, err := s.lookup(bucketID, name, gen, conds)
+	if err != nil {
+		return err
+	}
+	if o.proto.GetEventBasedHold() || o.proto.TemporaryHold {
+		return status.Errorf(codes.FailedPrecondition, "object %q is under a hold", name)
+	}
+	if gen == 0 && b.proto.GetVersioning().GetEnabled() {
+		o.proto.DeleteTime = timestamppb.Now()
+		return nil
+	}
+	vs := b.objects[name]
+	for i, v := range vs {
+		if v == o {
+			vs = append(vs[:i:i], vs[i+1:]...)
+			break
+		}
+	}
+	if len(vs) == 0 {
+		delete(b.objects, name)
+	} else {
+		b.objects[name] = vs
+	}
+	return nil
+}
+
+// A listQuery describes the objects to list.
+type listQuery struct {
+	prefix                   string
+	delimiter                string
+	versions                 bool
+	startOffset, endOffset   string
+	includeTrailingDelimiter bool
+	pageSize                 int
+	pageToken                string
+}
+
+// listObjects returns a page of the objects and prefixes in a bucket that
+// match q, and the token for the next page.
+func (s *backend) listObjects(bucketID string, q listQuery) (objs []*storagepb.Object, prefixes []string, nextPageToken string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucketID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	names := make([]string, 0, len(b.objects))
+	for name := range b.objects {
+		if strings.HasPrefix(name, q.prefix) &&
+			(q.startOffset == "" || name >= q.startOffset) &&
+			(q.endOffset == "" || name < q.endOffset) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// A list entry is either an object or a prefix.
+	type entry struct {
+		obj    *storagepb.Object
+		prefix string
+	}
+	var entries []entry
+	seen := map[string]bool{}
+	for _, name := range names {
+		if q.delimiter != "" {
+			rest := name[len(q.prefix):]
+			if i := strings.Index(rest, q.delimiter); i >= 0 {
+				p := name[:len(q.prefix)+i+len(q.delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					entries = append(entries, entry{prefix: p})
+				}
+				if !q.includeTrailingDelimiter || p != name {
+					continue
+				}
+			}
+		}
+		if q.versions {
+			for _, o := range b.objects[name] {
+				entries = append(entries, entry{obj: o.proto})
+			}
+		} else if o := b.live(name); o != nil {
+			entries = append(entries, entry{obj: o.proto})
+		}
+	}
+	pageSize := q.pageSize
+	if pageSize <= 0 || pageSize > defaultPageSize {
+		pageSize = defaultPageSize
+	}
+	from, to, next, err := testutil.PageBounds(pageSize, q.pageToken, len(entries))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	for _, e := range entries[from:to] {
+		if e.obj != nil {
+			objs = append(objs, cloneObject(e.obj))
+		} else {
+			prefixes = append(prefixes, e.prefix)
+		}
+	}
+	return objs, prefixes, next, nil
+}
+
+// A composeSource is an object to concatenate with composeObject.
+type composeSource struct {
+	name       string
+	generation int64
+	conds      conditions
+}
+
+// composeObject writes the concatenation of source objects in a bucket to
+// the destination object.
+func (s *backend) composeObject(bucketID string, dst *storagepb.Object, srcs []composeSource, conds conditions, predefinedACL string, checksums *storagepb.ObjectChecksums) (*storagepb.Object, error) {
+	if len(srcs) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no source objects")
+	}
+	if len(srcs) > maxComposeSources {
+		return nil, status.Errorf(codes.InvalidArgument, "%d source objects, more than the maximum of %d", len(srcs), maxComposeSources)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucketID)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	var components int32
+	for _, src := range srcs {
+		_, o, err := s.lookup(bucketID, src.name, src.generation, src.conds)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, o.data...)
+		if n := o.proto.ComponentCount; n > 0 {
+			components += n
+		} else {
+			components++
+		}
+	}
+	if components > maxComponentCount {
+		return nil, status.Errorf(codes.InvalidArgument, "composite object would have %d components, more than the maximum of %d", components, maxComponentCount)
+	}
+	if err := conds.checkObject(b.live(dst.GetName())); err != nil {
+		return nil, err
+	}
+	o, err := s.newObject(b, dst, data, predefinedACL, checksums)
+	if err != nil {
+		return nil, err
+	}
+	// Composite objects have no MD5 hash.
+	o.proto.Checksums.Md5Hash = nil
+	o.proto.ComponentCount = components
+	s.put(b, o)
+	return cloneObject(o.proto), nil
+}
+
+// A rewriteRequest copies an object, possibly between buckets. Fields set
+// in dst override the source object's metadata.
+type rewriteRequest struct {
+	srcBucket, srcName string
+	srcGeneration      int64
+	srcConds           conditions
+	dstBucket, dstName string
+	dst                *storagepb.Object
+	dstConds           conditions
+	predefinedACL      string
+}
+
+// rewriteObject copies an object. Unlike the service, which may take
+// several calls to copy a large object, it always finishes in one call.
+func (s *backend) rewriteObject(r rewriteRequest) (*storagepb.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, src, err := s.lookup(r.srcBucket, r.srcName, r.srcGeneration, r.srcConds)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(r.dstBucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.dstConds.checkObject(b.live(r.dstName)); err != nil {
+		return nil, err
+	}
+	res := cloneObject(src.proto)
+	res.Acl = nil
+	res.EventBasedHold = nil
+	res.TemporaryHold = false
+	if d := r.dst; d != nil {
+		mergeObject(res, d)
+	}
+	res.Name = r.dstName
+	o, err := s.newObject(b, res, src.data, r.predefinedACL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if src.proto.ComponentCount > 0 {
+		o.proto.Checksums.Md5Hash = nil
+		o.proto.ComponentCount = src.proto.ComponentCount
+	}
+	s.put(b, o)
+	return cloneObject(o.proto), nil
+}
+
+// mergeObject sets the user-settable fields of dst that are set in src.
+func mergeObject(dst, src *storagepb.Object) {
+	set := func(d *string, s string) {
+		if s != "" {
+			*d = s
+		}
+	}
+	set(&dst.ContentType, src.ContentType)
+	set(&dst.ContentEncoding, src.ContentEncoding)
+	set(&dst.ContentDisposition, src.ContentDisposition)
+	set(&dst.ContentLanguage, src.ContentLanguage)
+	set(&dst.CacheControl, src.CacheControl)
+	set(&dst.StorageClass, src.StorageClass)
+	set(&dst.KmsKey, src.KmsKey)
+	if src.Metadata != nil {
+		dst.Metadata = src.Metadata
+	}
+	if len(src.Acl) > 0 {
+		dst.Acl = src.Acl
+	}
+	if src.CustomTime != nil {
+		dst.CustomTime = src.CustomTime
+	}
+	if src.GetEventBasedHold() {
+		dst.EventBasedHold = proto.Bool(true)
+	}
+	if src.TemporaryHold {
+		dst.TemporaryHold = true
+	}
+}
+
+// Resumable uploads.
+
+// startUpload starts a resumable upload of an object, returning the upload ID.
+// size is the expected size of the object, or -1 if it isn't known.
+func (s *backend) startUpload(bucketID string, resource *storagepb.Object, conds conditions, predefinedACL string, checksums *storagepb.ObjectChecksums, size int64) (string, error) {
+	if resource.GetName() == "" {
+		return "", status.Error(codes.InvalidArgument, "missing object name")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucketID)
+	if err != nil {
+		return "", err
+	}
+	// The service checks preconditions when the upload starts, and again
+	// when it finishes.
+	if err := conds.checkObject(b.live(resource.Name)); err != nil {
+		return "", err
+	}
+	s.nextUploadID++
+	id := fmt.Sprintf("upload-%d", s.nextUploadID)
+	s.uploads[id] = &upload{
+		bucket:        bucketID,
+		resource:      cloneObject(resource),
+		conds:         conds,
+		predefinedACL: predefinedACL,
+		checksums:     checksums,
+		size:          size,
+	}
+	return id, nil
+}
+
+// Must be called with the lock held.
+func (s *backend) upload(id string) (*upload, error) {
+	u := s.uploads[id]
+	if u == nil {
+		return nil, status.Errorf(codes.NotFound, "upload %q not found", id)
+	}
+	return u, nil
+}
+
+// writeUpload writes data at the given offset of an upload. The offset may
+// be before the end of the data already written, when a write is retried,
+// but not after it. If finish is true, the upload's object is created.
+//
+// writeUpload returns the number of bytes persisted, and the object if the
+// upload is finished.
+func (s *backend) writeUpload(id string, offset int64, data []byte, checksums *storagepb.ObjectChecksums, finish bool) (int64, *storagepb.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.upload(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	if u.result != nil {
+		return int64(len(u.data)), cloneObject(u.result), nil
+	}
+	persisted := int64(len(u.data))
+	if offset > persisted {
+		return 0, nil, status.Errorf(codes.InvalidArgument, "write offset %d is after the persisted size %d", offset, persisted)
+	}
+	if skip := persisted - offset; skip < int64(len(data)) {
+		u.data = append(u.data, data[skip:]...)
+	}
+	if checksums != nil {
+		u.checksums = checksums
+	}
+	if !finish {
+		return int64(len(u.data)), nil, nil
+	}
+	if u.size >= 0 && u.size != int64(len(u.data)) {
+		return 0, nil, status.Errorf(codes.InvalidArgument, "upload has %d bytes, want %d", len(u.data), u.size)
+	}
+	b, err := s.bucket(u.bucket)
+	if err == nil {
+		err = u.conds.checkObject(b.live(u.resource.Name))
+	}
+	var o *object
+	if err == nil {
+		o, err = s.newObject(b, u.resource, u.data, u.predefinedACL, u.checksums)
+	}
+	if err != nil {
+		// A failed upload can't be resumed.
+		delete(s.uploads, id)
+		return 0, nil, err
+	}
+	s.put(b, o)
+	u.result = o.proto
+	return int64(len(u.data)), cloneObject(o.proto), nil
+}
+
+// queryUpload returns the number of bytes persisted by an upload, and the
+// object if the upload is finished.
+func (s *backend) queryUpload(id string) (int64, *storagepb.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.upload(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	if u.result != nil {
+		return int64(len(u.data)), cloneObject(u.result), nil
+	}
+	return int64(len(u.data)), nil, nil
+}
+
+func (s *backend) cancelUpload(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.upload(id); err != nil {
+		return err
+	}
+	delete(s.uploads, id)
+	return nil
+}
+
+// Notifications.
+
+func notificationName(bucketID, id string) string {
+	return bucketResourceName(bucketID) + "/notificationConfigs/" + id
+}
+
+func (s *backend) createNotification(bucketID string, n *storagepb.Notification) (*storagepb.Notification, error) {
+	if !strings.HasPrefix(n.GetTopic(), "//pubsub.googleapis.com/projects/") {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid topic %q", n.GetTopic())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucketID)
+	if err != nil {
+		return nil, err
+	}
+	b.nextNotifID++
+	id := strconv.Itoa(b.nextNotifID)
+	n = proto.Clone(n).(*storagepb.Notification)
+	n.Name = notificationName(bucketID, id)
+	if n.PayloadFormat == "" {
+		n.PayloadFormat = "JSON_API_V1"
+	}
+	n.Etag = id
+	b.notifications[id] = n
+	return proto.Clone(n).(*storagepb.Notification), nil
+}
+
+func (s *backend) getNotification(bucketID, id string) (*storagepb.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucketID)
+	if err != nil {
+		return nil, err
+	}
+	n := b.notifications[id]
+	if n == nil {
+		return nil, status.Errorf(codes.NotFound, "notification %q not found in bucket %q", id, bucketID)
+	}
+	return proto.Clone(n).(*storagepb.Notification), nil
+}
+
+// listNotifications returns the notifications of a bucket, in the order
+// they were created.
+func (s *backend) listNotifications(bucketID string) ([]*storagepb.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucketID)
+	if err != nil {
+		return nil, err
+	}
+	var ns []*storagepb.Notification
+	for _, n := range b.notifications {
+		ns = append(ns, proto.Clone(n).(*storagepb.Notification))
+	}
+	sort.Slice(ns, func(i, j int) bool {
+		a, _ := strconv.Atoi(ns[i].Etag)
+		b, _ := strconv.Atoi(ns[j].Etag)
+		return a < b
+	})
+	return ns, nil
+}
+
+func (s *backend) deleteNotification(bucketID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucketID)
+	if err != nil {
+		return err
+	}
+	if b.notifications[id] == nil {
+		return status.Errorf(codes.NotFound, "notification %q not found in bucket %q", id, bucketID)
+	}
+	delete(b.notifications, id)
+	return nil
+}
+
+// ACLs.
+
+// setBucketACLEntry gives entity the role in acl, returning the new entry.
+func setBucketACLEntry(acl *[]*storagepb.BucketAccessControl, entity, role string) *storagepb.BucketAccessControl {
+	for _, a := range *acl {
+		if a.Entity == entity {
+			a.Role = role
+			return a
+		}
+	}
+	a := &storagepb.BucketAccessControl{Entity: entity, Role: role}
+	*acl = append(*acl, a)
+	return a
+}
+
+// setObjectACLEntry gives entity the role in acl, returning the new entry.
+func setObjectACLEntry(acl *[]*storagepb.ObjectAccessControl, entity, role string) *storagepb.ObjectAccessControl {
+	for _, a := range *acl {
+		if a.Entity == entity {
+			a.Role = role
+			return a
+		}
+	}
+	a := &storagepb.ObjectAccessControl{Entity: entity, Role: role}
+	*acl = append(*acl, a)
+	return a
+}
+
+func deleteBucketACLEntry(acl *[]*storagepb.BucketAccessControl, entity string) error {
+	for i, a := range *acl {
+		if a.Entity == entity {
+			*acl = append((*acl)[:i:i], (*acl)[i+1:]...)
+			return nil
+		}
+	}
+	return status.Errorf(codes.NotFound, "no ACL entry for %q", entity)
+}
+
+func deleteObjectACLEntry(acl *[]*storagepb.ObjectAccessControl, entity string) error {
+	for i, a := range *acl {
+		if a.Entity == entity {
+			*acl = append((*acl)[:i:i], (*acl)[i+1:]...)
+			return nil
+		}
+	}
+	return status.Errorf(codes.NotFound, "no ACL entry for %q", entity)
+}
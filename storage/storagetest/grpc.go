@@ -0,0 +1,529 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"context"
+	"hash/crc32"
+	"io"
+	"path"
+	"strings"
+
+	storagepb "cloud.google.com/go/storage/internal/apiv2/stubs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// maxReadChunkSize is the largest amount of object data in a ReadObject
+// response, like the service.
+const maxReadChunkSize = 2 * 1024 * 1024
+
+// grpcServer serves the gRPC API.
+type grpcServer struct {
+	storagepb.UnimplementedStorageServer
+	backend *backend
+}
+
+// bucketID returns the ID of the bucket with the given resource name.
+func bucketID(name string) (string, error) {
+	const prefix = "projects/_/buckets/"
+	if !strings.HasPrefix(name, prefix) || len(name) == len(prefix) {
+		return "", status.Errorf(codes.InvalidArgument, "bad bucket name %q", name)
+	}
+	return name[len(prefix):], nil
+}
+
+func protoConditions(genMatch, genNotMatch, metagenMatch, metagenNotMatch *int64) conditions {
+	return conditions{
+		generationMatch:        genMatch,
+		generationNotMatch:     genNotMatch,
+		metagenerationMatch:    metagenMatch,
+		metagenerationNotMatch: metagenNotMatch,
+	}
+}
+
+// Updatable fields, by name in update masks.
+var (
+	updatableBucketFields = map[string]bool{
+		"storage_class": true, "rpo": true, "acl": true, "default_object_acl": true,
+		"lifecycle": true, "cors": true, "default_event_based_hold": true, "labels": true,
+		"website": true, "versioning": true, "logging": true, "encryption": true,
+		"billing": true, "retention_policy": true, "iam_config": true, "autoclass": true,
+	}
+	updatableObjectFields = map[string]bool{
+		"content_encoding": true, "content_disposition": true, "cache_control": true,
+		"acl": true, "content_language": true, "content_type": true, "metadata": true,
+		"event_based_hold": true, "temporary_hold": true, "custom_time": true,
+	}
+)
+
+// applyMask copies the fields in mask from src to dst. A path may name a
+// top-level field, or a key of a map field, like "labels.env". A field or
+// key that isn't set in src is cleared in dst.
+func applyMask(dst, src proto.Message, mask *fieldmaskpb.FieldMask, updatable map[string]bool) error {
+	paths := mask.GetPaths()
+	if len(paths) == 1 && paths[0] == "*" {
+		paths = paths[:0]
+		for p := range updatable {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return status.Error(codes.InvalidArgument, "empty update mask")
+	}
+	d, s := dst.ProtoReflect(), src.ProtoReflect()
+	for _, p := range paths {
+		name, key, hasKey := strings.Cut(p, ".")
+		if !updatable[name] {
+			return status.Errorf(codes.InvalidArgument, "field %q can't be updated", p)
+		}
+		fd := d.Descriptor().Fields().ByName(protoreflect.Name(name))
+		switch {
+		case hasKey:
+			if !fd.IsMap() {
+				return status.Errorf(codes.InvalidArgument, "field %q isn't a map", name)
+			}
+			k := protoreflect.ValueOfString(key).MapKey()
+			if v := s.Get(fd).Map().Get(k); v.IsValid() {
+				d.Mutable(fd).Map().Set(k, v)
+			} else if d.Has(fd) {
+				d.Mutable(fd).Map().Clear(k)
+			}
+		case s.Has(fd):
+			d.Set(fd, s.Get(fd))
+		default:
+			d.Clear(fd)
+		}
+	}
+	return nil
+}
+
+// Buckets.
+
+func (g *grpcServer) CreateBucket(_ context.Context, req *storagepb.CreateBucketRequest) (*storagepb.Bucket, error) {
+	project := strings.TrimPrefix(req.Parent, "projects/")
+	if project == req.Parent || project == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "bad parent %q", req.Parent)
+	}
+	b := &storagepb.Bucket{}
+	if req.Bucket != nil {
+		b = proto.Clone(req.Bucket).(*storagepb.Bucket)
+	}
+	b.Name = req.BucketId
+	return g.backend.createBucket(project, b, req.PredefinedAcl, req.PredefinedDefaultObjectAcl)
+}
+
+func (g *grpcServer) GetBucket(_ context.Context, req *storagepb.GetBucketRequest) (*storagepb.Bucket, error) {
+	id, err := bucketID(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return g.backend.getBucket(id, protoConditions(nil, nil, req.IfMetagenerationMatch, req.IfMetagenerationNotMatch))
+}
+
+func (g *grpcServer) ListBuckets(_ context.Context, req *storagepb.ListBucketsRequest) (*storagepb.ListBucketsResponse, error) {
+	bs := g.backend.listBuckets(strings.TrimPrefix(req.Parent, "projects/"), req.Prefix)
+	from, to, next, err := pageBoundsParam(int(req.PageSize), req.PageToken, len(bs))
+	if err != nil {
+		return nil, err
+	}
+	return &storagepb.ListBucketsResponse{Buckets: bs[from:to], NextPageToken: next}, nil
+}
+
+func (g *grpcServer) UpdateBucket(_ context.Context, req *storagepb.UpdateBucketRequest) (*storagepb.Bucket, error) {
+	id, err := bucketID(req.Bucket.GetName())
+	if err != nil {
+		return nil, err
+	}
+	conds := protoConditions(nil, nil, req.IfMetagenerationMatch, req.IfMetagenerationNotMatch)
+	return g.backend.updateBucket(id, conds, func(b *storagepb.Bucket) error {
+		if err := applyMask(b, req.Bucket, req.UpdateMask, updatableBucketFields); err != nil {
+			return err
+		}
+		return applyPredefinedBucketACLs(b, req.PredefinedAcl, req.PredefinedDefaultObjectAcl)
+	})
+}
+
+func (g *grpcServer) DeleteBucket(_ context.Context, req *storagepb.DeleteBucketRequest) (*emptypb.Empty, error) {
+	id, err := bucketID(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.backend.deleteBucket(id, protoConditions(nil, nil, req.IfMetagenerationMatch, req.IfMetagenerationNotMatch)); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (g *grpcServer) LockBucketRetentionPolicy(_ context.Context, req *storagepb.LockBucketRetentionPolicyRequest) (*storagepb.Bucket, error) {
+	id, err := bucketID(req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	return g.backend.lockRetentionPolicy(id, req.IfMetagenerationMatch)
+}
+
+// Objects.
+
+func (g *grpcServer) GetObject(_ context.Context, req *storagepb.GetObjectRequest) (*storagepb.Object, error) {
+	id, err := bucketID(req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	conds := protoConditions(req.IfGenerationMatch, req.IfGenerationNotMatch, req.IfMetagenerationMatch, req.IfMetagenerationNotMatch)
+	o, err := g.backend.getObject(id, req.Object, req.Generation, conds)
+	if err != nil {
+		return nil, err
+	}
+	return o.proto, nil
+}
+
+func (g *grpcServer) UpdateObject(_ context.Context, req *storagepb.UpdateObjectRequest) (*storagepb.Object, error) {
+	id, err := bucketID(req.Object.GetBucket())
+	if err != nil {
+		return nil, err
+	}
+	conds := protoConditions(req.IfGenerationMatch, req.IfGenerationNotMatch, req.IfMetagenerationMatch, req.IfMetagenerationNotMatch)
+	var acl []*storagepb.ObjectAccessControl
+	if req.PredefinedAcl != "" {
+		b, err := g.backend.getBucket(id, conditions{})
+		if err != nil {
+			return nil, err
+		}
+		if acl, err = predefinedObjectACL(projectOf(b), req.PredefinedAcl); err != nil {
+			return nil, err
+		}
+	}
+	return g.backend.updateObject(id, req.Object.Name, req.Object.Generation, conds, func(o *storagepb.Object) error {
+		if ct := req.Object.GetCustomTime(); ct != nil && o.CustomTime != nil && ct.AsTime().Before(o.CustomTime.AsTime()) {
+			return status.Error(codes.InvalidArgument, "custom time can't be decreased")
+		}
+		if err := applyMask(o, req.Object, req.UpdateMask, updatableObjectFields); err != nil {
+			return err
+		}
+		if acl != nil {
+			o.Acl = acl
+		}
+		return nil
+	})
+}
+
+func (g *grpcServer) DeleteObject(_ context.Context, req *storagepb.DeleteObjectRequest) (*emptypb.Empty, error) {
+	id, err := bucketID(req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	conds := protoConditions(req.IfGenerationMatch, req.IfGenerationNotMatch, req.IfMetagenerationMatch, req.IfMetagenerationNotMatch)
+	if err := g.backend.deleteObject(id, req.Object, req.Generation, conds); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (g *grpcServer) ListObjects(_ context.Context, req *storagepb.ListObjectsRequest) (*storagepb.ListObjectsResponse, error) {
+	id, err := bucketID(req.Parent)
+	if err != nil {
+		return nil, err
+	}
+	objs, prefixes, next, err := g.backend.listObjects(id, listQuery{
+		prefix:                   req.Prefix,
+		delimiter:                req.Delimiter,
+		versions:                 req.Versions,
+		startOffset:              req.LexicographicStart,
+		endOffset:                req.LexicographicEnd,
+		includeTrailingDelimiter: req.IncludeTrailingDelimiter,
+		pageSize:                 int(req.PageSize),
+		pageToken:                req.PageToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &storagepb.ListObjectsResponse{Objects: objs, Prefixes: prefixes, NextPageToken: next}, nil
+}
+
+// ReadObject streams the requested range of an object. The first response
+// holds the object's metadata and checksums.
+func (g *grpcServer) ReadObject(req *storagepb.ReadObjectRequest, stream storagepb.Storage_ReadObjectServer) error {
+	id, err := bucketID(req.Bucket)
+	if err != nil {
+		return err
+	}
+	conds := protoConditions(req.IfGenerationMatch, req.IfGenerationNotMatch, req.IfMetagenerationMatch, req.IfMetagenerationNotMatch)
+	o, err := g.backend.getObject(id, req.Object, req.Generation, conds)
+	if err != nil {
+		return err
+	}
+	size := int64(len(o.data))
+	start := req.ReadOffset
+	if start < 0 {
+		// A negative offset is relative to the end of the object.
+		start += size
+		if start < 0 {
+			start = 0
+		}
+	}
+	if start > size || start == size && size > 0 {
+		return status.Errorf(codes.OutOfRange, "read offset %d is past the end of the %d-byte object", req.ReadOffset, size)
+	}
+	end := size
+	if req.ReadLimit < 0 {
+		return status.Errorf(codes.InvalidArgument, "negative read limit %d", req.ReadLimit)
+	}
+	if req.ReadLimit > 0 && start+req.ReadLimit < end {
+		end = start + req.ReadLimit
+	}
+
+	res := &storagepb.ReadObjectResponse{
+		Metadata:        o.proto,
+		ObjectChecksums: o.proto.Checksums,
+	}
+	if req.ReadOffset != 0 || req.ReadLimit != 0 {
+		res.ContentRange = &storagepb.ContentRange{Start: start, End: end - 1, CompleteLength: size}
+	}
+	for first := true; first || start < end; first = false {
+		n := end - start
+		if n > maxReadChunkSize {
+			n = maxReadChunkSize
+		}
+		chunk := o.data[start : start+n]
+		res.ChecksummedData = &storagepb.ChecksummedData{
+			Content: chunk,
+			Crc32C:  proto.Uint32(crc32.Checksum(chunk, crc32cTable)),
+		}
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+		start += n
+		res = &storagepb.ReadObjectResponse{}
+	}
+	return nil
+}
+
+// WriteObject writes an object, or the next part of a resumable upload.
+// Like the service, it replies only when the write is finished, and
+// otherwise ends the stream without a response.
+func (g *grpcServer) WriteObject(stream storagepb.Storage_WriteObjectServer) error {
+	var (
+		uploadID string
+		resumed  bool
+	)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if uploadID != "" && !resumed {
+				g.backend.cancelUpload(uploadID)
+			}
+			return err
+		}
+		if uploadID == "" {
+			// The first message either continues a resumable upload, or
+			// starts a new upload, which is resumable only within this
+			// stream.
+			switch {
+			case req.GetUploadId() != "":
+				uploadID, resumed = req.GetUploadId(), true
+			case req.GetWriteObjectSpec() != nil:
+				if uploadID, err = g.startUpload(req.GetWriteObjectSpec(), req.ObjectChecksums); err != nil {
+					return err
+				}
+			default:
+				return status.Error(codes.InvalidArgument, "the first message must have an upload ID or a WriteObjectSpec")
+			}
+		}
+		_, o, err := g.backend.writeUpload(uploadID, req.WriteOffset, req.GetChecksummedData().GetContent(), req.ObjectChecksums, req.FinishWrite)
+		if err != nil {
+			return err
+		}
+		if o != nil {
+			return stream.SendAndClose(&storagepb.WriteObjectResponse{
+				WriteStatus: &storagepb.WriteObjectResponse_Resource{Resource: o},
+			})
+		}
+	}
+	if uploadID == "" {
+		return status.Error(codes.InvalidArgument, "no messages")
+	}
+	if !resumed {
+		g.backend.cancelUpload(uploadID)
+		return status.Error(codes.InvalidArgument, "the stream ended without finishing the write")
+	}
+	return nil
+}
+
+// startUpload starts an upload described by spec.
+func (g *grpcServer) startUpload(spec *storagepb.WriteObjectSpec, checksums *storagepb.ObjectChecksums) (string, error) {
+	id, err := bucketID(spec.GetResource().GetBucket())
+	if err != nil {
+		return "", err
+	}
+	size := int64(-1)
+	if spec.ObjectSize != nil {
+		size = *spec.ObjectSize
+	}
+	conds := protoConditions(spec.IfGenerationMatch, spec.IfGenerationNotMatch, spec.IfMetagenerationMatch, spec.IfMetagenerationNotMatch)
+	return g.backend.startUpload(id, spec.Resource, conds, spec.PredefinedAcl, checksums, size)
+}
+
+func (g *grpcServer) StartResumableWrite(_ context.Context, req *storagepb.StartResumableWriteRequest) (*storagepb.StartResumableWriteResponse, error) {
+	id, err := g.startUpload(req.WriteObjectSpec, req.ObjectChecksums)
+	if err != nil {
+		return nil, err
+	}
+	return &storagepb.StartResumableWriteResponse{UploadId: id}, nil
+}
+
+func (g *grpcServer) QueryWriteStatus(_ context.Context, req *storagepb.QueryWriteStatusRequest) (*storagepb.QueryWriteStatusResponse, error) {
+	persisted, o, err := g.backend.queryUpload(req.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	if o != nil {
+		return &storagepb.QueryWriteStatusResponse{
+			WriteStatus: &storagepb.QueryWriteStatusResponse_Resource{Resource: o},
+		}, nil
+	}
+	return &storagepb.QueryWriteStatusResponse{
+		WriteStatus: &storagepb.QueryWriteStatusResponse_PersistedSize{PersistedSize: persisted},
+	}, nil
+}
+
+func (g *grpcServer) CancelResumableWrite(_ context.Context, req *storagepb.CancelResumableWriteRequest) (*storagepb.CancelResumableWriteResponse, error) {
+	if err := g.backend.cancelUpload(req.UploadId); err != nil {
+		return nil, err
+	}
+	return &storagepb.CancelResumableWriteResponse{}, nil
+}
+
+func (g *grpcServer) ComposeObject(_ context.Context, req *storagepb.ComposeObjectRequest) (*storagepb.Object, error) {
+	id, err := bucketID(req.Destination.GetBucket())
+	if err != nil {
+		return nil, err
+	}
+	var srcs []composeSource
+	for _, s := range req.SourceObjects {
+		src := composeSource{name: s.Name, generation: s.Generation}
+		if p := s.ObjectPreconditions; p != nil {
+			src.conds.generationMatch = p.IfGenerationMatch
+		}
+		srcs = append(srcs, src)
+	}
+	checksums := req.ObjectChecksums
+	if checksums == nil && req.Destination.GetChecksums().GetCrc32C() != 0 {
+		checksums = req.Destination.Checksums
+	}
+	conds := protoConditions(req.IfGenerationMatch, nil, req.IfMetagenerationMatch, nil)
+	return g.backend.composeObject(id, req.Destination, srcs, conds, req.DestinationPredefinedAcl, checksums)
+}
+
+// RewriteObject copies an object in a single call.
+func (g *grpcServer) RewriteObject(_ context.Context, req *storagepb.RewriteObjectRequest) (*storagepb.RewriteResponse, error) {
+	srcBucket, err := bucketID(req.SourceBucket)
+	if err != nil {
+		return nil, err
+	}
+	dstBucket, err := bucketID(req.DestinationBucket)
+	if err != nil {
+		return nil, err
+	}
+	o, err := g.backend.rewriteObject(rewriteRequest{
+		srcBucket:     srcBucket,
+		srcName:       req.SourceObject,
+		srcGeneration: req.SourceGeneration,
+		srcConds:      protoConditions(req.IfSourceGenerationMatch, req.IfSourceGenerationNotMatch, req.IfSourceMetagenerationMatch, req.IfSourceMetagenerationNotMatch),
+		dstBucket:     dstBucket,
+		dstName:       req.DestinationName,
+		dst:           req.Destination,
+		dstConds:      protoConditions(req.IfGenerationMatch, req.IfGenerationNotMatch, req.IfMetagenerationMatch, req.IfMetagenerationNotMatch),
+		predefinedACL: req.DestinationPredefinedAcl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &storagepb.RewriteResponse{
+		TotalBytesRewritten: o.Size,
+		ObjectSize:          o.Size,
+		Done:                true,
+		Resource:            o,
+	}, nil
+}
+
+// Notifications.
+
+// notificationID returns the bucket and ID of the notification with the
+// given resource name.
+func notificationID(name string) (bucket, id string, err error) {
+	b, id := path.Split(name)
+	if !strings.HasSuffix(b, "/notificationConfigs/") || id == "" {
+		return "", "", status.Errorf(codes.InvalidArgument, "bad notification name %q", name)
+	}
+	bucket, err = bucketID(strings.TrimSuffix(b, "/notificationConfigs/"))
+	return bucket, id, err
+}
+
+func (g *grpcServer) CreateNotification(_ context.Context, req *storagepb.CreateNotificationRequest) (*storagepb.Notification, error) {
+	id, err := bucketID(req.Parent)
+	if err != nil {
+		return nil, err
+	}
+	return g.backend.createNotification(id, req.Notification)
+}
+
+func (g *grpcServer) GetNotification(_ context.Context, req *storagepb.GetNotificationRequest) (*storagepb.Notification, error) {
+	b, id, err := notificationID(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return g.backend.getNotification(b, id)
+}
+
+func (g *grpcServer) ListNotifications(_ context.Context, req *storagepb.ListNotificationsRequest) (*storagepb.ListNotificationsResponse, error) {
+	id, err := bucketID(req.Parent)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := g.backend.listNotifications(id)
+	if err != nil {
+		return nil, err
+	}
+	from, to, next, err := pageBoundsParam(int(req.PageSize), req.PageToken, len(ns))
+	if err != nil {
+		return nil, err
+	}
+	return &storagepb.ListNotificationsResponse{Notifications: ns[from:to], NextPageToken: next}, nil
+}
+
+func (g *grpcServer) DeleteNotification(_ context.Context, req *storagepb.DeleteNotificationRequest) (*emptypb.Empty, error) {
+	b, id, err := notificationID(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.backend.deleteNotification(b, id); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (g *grpcServer) GetServiceAccount(_ context.Context, req *storagepb.GetServiceAccountRequest) (*storagepb.ServiceAccount, error) {
+	return &storagepb.ServiceAccount{
+		EmailAddress: serviceAccount(strings.TrimPrefix(req.Project, "projects/")),
+	}, nil
+}
@@ -0,0 +1,1493 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/internal/testutil"
+	storagepb "cloud.google.com/go/storage/internal/apiv2/stubs"
+	raw "google.golang.org/api/storage/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// httpServer serves the JSON API, and object downloads with the XML API.
+type httpServer struct {
+	backend *backend
+}
+
+func (h *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+	p := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(p, "/upload/storage/v1/"):
+		err = h.serveUpload(w, r, p[len("/upload/storage/v1/"):])
+	case strings.HasPrefix(p, "/download/storage/v1/"):
+		err = h.serveJSON(w, r, p[len("/download/storage/v1/"):])
+	case strings.HasPrefix(p, "/storage/v1/"):
+		err = h.serveJSON(w, r, p[len("/storage/v1/"):])
+	default:
+		err = h.serveXML(w, r)
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+// httpStatus maps the backend's error codes to HTTP status codes.
+var httpStatus = map[codes.Code]int{
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.Aborted:            http.StatusConflict,
+	codes.FailedPrecondition: http.StatusPreconditionFailed,
+	codes.OutOfRange:         http.StatusRequestedRangeNotSatisfiable,
+	codes.Unimplemented:      http.StatusNotImplemented,
+}
+
+// writeError writes err in the JSON API's error format.
+func writeError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+	code, ok := httpStatus[s.Code()]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": s.Message(),
+			"errors": []map[string]string{{
+				"domain":  "global",
+				"reason":  strings.ToLower(s.Code().String()),
+				"message": s.Message(),
+			}},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	return json.NewEncoder(w).Encode(v)
+}
+
+// splitPath splits an escaped URL path into unescaped segments.
+func splitPath(p string) ([]string, error) {
+	segs := strings.Split(strings.Trim(p, "/"), "/")
+	for i, s := range segs {
+		u, err := url.PathUnescape(s)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "bad path segment %q", s)
+		}
+		segs[i] = u
+	}
+	return segs, nil
+}
+
+func errMethod(r *http.Request) error {
+	return status.Errorf(codes.InvalidArgument, "method %s not allowed for %s", r.Method, r.URL.Path)
+}
+
+func errNoRoute(r *http.Request) error {
+	return status.Errorf(codes.NotFound, "no API at %s", r.URL.Path)
+}
+
+// serveJSON serves the JSON API, at the path p relative to its root.
+func (h *httpServer) serveJSON(w http.ResponseWriter, r *http.Request, p string) error {
+	segs, err := splitPath(p)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(segs) == 3 && segs[0] == "projects" && segs[2] == "serviceAccount":
+		if r.Method != http.MethodGet {
+			return errMethod(r)
+		}
+		return writeJSON(w, &raw.ServiceAccount{
+			Kind:         "storage#serviceAccount",
+			EmailAddress: serviceAccount(segs[1]),
+		})
+	case len(segs) == 1 && segs[0] == "b":
+		return h.serveBuckets(w, r)
+	case len(segs) >= 2 && segs[0] == "b":
+		return h.serveBucket(w, r, segs[1], segs[2:])
+	}
+	return errNoRoute(r)
+}
+
+// serviceAccount returns the email address of a project's service account.
+func serviceAccount(project string) string {
+	return fmt.Sprintf("service-%s@gs-project-accounts.iam.gserviceaccount.com", project)
+}
+
+func (h *httpServer) serveBuckets(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		bs := h.backend.listBuckets(q.Get("project"), q.Get("prefix"))
+		maxResults, err := intParam(q, "maxResults")
+		if err != nil {
+			return err
+		}
+		from, to, next, err := pageBoundsParam(int(maxResults), q.Get("pageToken"), len(bs))
+		if err != nil {
+			return err
+		}
+		res := &raw.Buckets{Kind: "storage#buckets", NextPageToken: next, Items: []*raw.Bucket{}}
+		for _, b := range bs[from:to] {
+			res.Items = append(res.Items, bucketToRaw(b))
+		}
+		return writeJSON(w, res)
+	case http.MethodPost:
+		var rb raw.Bucket
+		if err := readJSON(r, &rb); err != nil {
+			return err
+		}
+		b, err := h.backend.createBucket(q.Get("project"), bucketFromRaw(&rb), q.Get("predefinedAcl"), q.Get("predefinedDefaultObjectAcl"))
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, bucketToRaw(b))
+	}
+	return errMethod(r)
+}
+
+func pageBoundsParam(maxResults int, pageToken string, length int) (from, to int, next string, err error) {
+	if maxResults <= 0 || maxResults > defaultPageSize {
+		maxResults = defaultPageSize
+	}
+	return testutil.PageBounds(maxResults, pageToken, length)
+}
+
+func (h *httpServer) serveBucket(w http.ResponseWriter, r *http.Request, bucketID string, rest []string) error {
+	q := r.URL.Query()
+	if len(rest) == 0 {
+		conds, err := queryConditions(q, "")
+		if err != nil {
+			return err
+		}
+		switch r.Method {
+		case http.MethodGet:
+			b, err := h.backend.getBucket(bucketID, conds)
+			if err != nil {
+				return err
+			}
+			return writeJSON(w, bucketToRaw(b))
+		case http.MethodPatch, http.MethodPut:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return err
+			}
+			b, err := h.backend.updateBucket(bucketID, conds, func(b *storagepb.Bucket) error {
+				if err := patchBucket(b, body); err != nil {
+					return err
+				}
+				return applyPredefinedBucketACLs(b, q.Get("predefinedAcl"), q.Get("predefinedDefaultObjectAcl"))
+			})
+			if err != nil {
+				return err
+			}
+			return writeJSON(w, bucketToRaw(b))
+		case http.MethodDelete:
+			if err := h.backend.deleteBucket(bucketID, conds); err != nil {
+				return err
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		return errMethod(r)
+	}
+
+	switch rest[0] {
+	case "o":
+		if len(rest) == 1 {
+			if r.Method != http.MethodGet {
+				return errMethod(r)
+			}
+			return h.listObjects(w, r, bucketID)
+		}
+		return h.serveObject(w, r, bucketID, rest[1], rest[2:])
+	case "acl", "defaultObjectAcl":
+		if len(rest) > 2 {
+			break
+		}
+		var entity string
+		if len(rest) == 2 {
+			entity = rest[1]
+		}
+		return h.serveBucketACL(w, r, bucketID, rest[0] == "defaultObjectAcl", entity)
+	case "lockRetentionPolicy":
+		if len(rest) > 1 {
+			break
+		}
+		if r.Method != http.MethodPost {
+			return errMethod(r)
+		}
+		metagen, err := int64Param(q, "ifMetagenerationMatch")
+		if err != nil {
+			return err
+		}
+		b, err := h.backend.lockRetentionPolicy(bucketID, metagen)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, bucketToRaw(b))
+	case "notificationConfigs":
+		if len(rest) > 2 {
+			break
+		}
+		var id string
+		if len(rest) == 2 {
+			id = rest[1]
+		}
+		return h.serveNotifications(w, r, bucketID, id)
+	}
+	return errNoRoute(r)
+}
+
+func applyPredefinedBucketACLs(b *storagepb.Bucket, predefinedACL, predefinedDefaultObjectACL string) error {
+	if predefinedACL != "" {
+		acl, err := predefinedBucketACL(projectOf(b), predefinedACL)
+		if err != nil {
+			return err
+		}
+		b.Acl = acl
+	}
+	if predefinedDefaultObjectACL != "" {
+		acl, err := predefinedObjectACL(projectOf(b), predefinedDefaultObjectACL)
+		if err != nil {
+			return err
+		}
+		b.DefaultObjectAcl = acl
+	}
+	return nil
+}
+
+func (h *httpServer) serveBucketACL(w http.ResponseWriter, r *http.Request, bucketID string, defaultObjectACL bool, entity string) error {
+	var noConds conditions
+	if entity == "" {
+		switch r.Method {
+		case http.MethodGet:
+			b, err := h.backend.getBucket(bucketID, noConds)
+			if err != nil {
+				return err
+			}
+			if defaultObjectACL {
+				return writeJSON(w, &raw.ObjectAccessControls{
+					Kind:  "storage#objectAccessControls",
+					Items: objectACLToRaw(bucketID, "", 0, b.DefaultObjectAcl),
+				})
+			}
+			return writeJSON(w, &raw.BucketAccessControls{
+				Kind:  "storage#bucketAccessControls",
+				Items: bucketACLToRaw(bucketID, b.Acl),
+			})
+		case http.MethodPost:
+			var a raw.ObjectAccessControl
+			if err := readJSON(r, &a); err != nil {
+				return err
+			}
+			return h.setBucketACL(w, bucketID, defaultObjectACL, a.Entity, a.Role)
+		}
+		return errMethod(r)
+	}
+	switch r.Method {
+	case http.MethodGet:
+		b, err := h.backend.getBucket(bucketID, noConds)
+		if err != nil {
+			return err
+		}
+		if defaultObjectACL {
+			for _, a := range objectACLToRaw(bucketID, "", 0, b.DefaultObjectAcl) {
+				if a.Entity == entity {
+					return writeJSON(w, a)
+				}
+			}
+		} else {
+			for _, a := range bucketACLToRaw(bucketID, b.Acl) {
+				if a.Entity == entity {
+					return writeJSON(w, a)
+				}
+			}
+		}
+		return status.Errorf(codes.NotFound, "no ACL entry for %q", entity)
+	case http.MethodPut, http.MethodPatch:
+		var a raw.ObjectAccessControl
+		if err := readJSON(r, &a); err != nil {
+			return err
+		}
+		return h.setBucketACL(w, bucketID, defaultObjectACL, entity, a.Role)
+	case http.MethodDelete:
+		_, err := h.backend.updateBucket(bucketID, noConds, func(b *storagepb.Bucket) error {
+			if defaultObjectACL {
+				return deleteObjectACLEntry(&b.DefaultObjectAcl, entity)
+			}
+			return deleteBucketACLEntry(&b.Acl, entity)
+		})
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return errMethod(r)
+}
+
+func (h *httpServer) setBucketACL(w http.ResponseWriter, bucketID string, defaultObjectACL bool, entity, role string) error {
+	if entity == "" || role == "" {
+		return status.Error(codes.InvalidArgument, "an ACL entry needs an entity and a role")
+	}
+	var res interface{}
+	_, err := h.backend.updateBucket(bucketID, conditions{}, func(b *storagepb.Bucket) error {
+		if defaultObjectACL {
+			res = objectACLToRaw(bucketID, "", 0, []*storagepb.ObjectAccessControl{setObjectACLEntry(&b.DefaultObjectAcl, entity, role)})[0]
+		} else {
+			res = bucketACLToRaw(bucketID, []*storagepb.BucketAccessControl{setBucketACLEntry(&b.Acl, entity, role)})[0]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, res)
+}
+
+func (h *httpServer) serveNotifications(w http.ResponseWriter, r *http.Request, bucketID, id string) error {
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			ns, err := h.backend.listNotifications(bucketID)
+			if err != nil {
+				return err
+			}
+			res := &raw.Notifications{Kind: "storage#notifications", Items: []*raw.Notification{}}
+			for _, n := range ns {
+				res.Items = append(res.Items, notificationToRaw(n))
+			}
+			return writeJSON(w, res)
+		case http.MethodPost:
+			var rn raw.Notification
+			if err := readJSON(r, &rn); err != nil {
+				return err
+			}
+			n, err := h.backend.createNotification(bucketID, &storagepb.Notification{
+				Topic:            rn.Topic,
+				EventTypes:       rn.EventTypes,
+				CustomAttributes: rn.CustomAttributes,
+				ObjectNamePrefix: rn.ObjectNamePrefix,
+				PayloadFormat:    rn.PayloadFormat,
+			})
+			if err != nil {
+				return err
+			}
+			return writeJSON(w, notificationToRaw(n))
+		}
+		return errMethod(r)
+	}
+	switch r.Method {
+	case http.MethodGet:
+		n, err := h.backend.getNotification(bucketID, id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, notificationToRaw(n))
+	case http.MethodDelete:
+		if err := h.backend.deleteNotification(bucketID, id); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return errMethod(r)
+}
+
+func (h *httpServer) listObjects(w http.ResponseWriter, r *http.Request, bucketID string) error {
+	q := r.URL.Query()
+	maxResults, err := intParam(q, "maxResults")
+	if err != nil {
+		return err
+	}
+	objs, prefixes, next, err := h.backend.listObjects(bucketID, listQuery{
+		prefix:                   q.Get("prefix"),
+		delimiter:                q.Get("delimiter"),
+		versions:                 q.Get("versions") == "true",
+		startOffset:              q.Get("startOffset"),
+		endOffset:                q.Get("endOffset"),
+		includeTrailingDelimiter: q.Get("includeTrailingDelimiter") == "true",
+		pageSize:                 int(maxResults),
+		pageToken:                q.Get("pageToken"),
+	})
+	if err != nil {
+		return err
+	}
+	res := &raw.Objects{Kind: "storage#objects", NextPageToken: next, Prefixes: prefixes, Items: []*raw.Object{}}
+	for _, o := range objs {
+		res.Items = append(res.Items, objectToRaw(o))
+	}
+	return writeJSON(w, res)
+}
+
+func (h *httpServer) serveObject(w http.ResponseWriter, r *http.Request, bucketID, name string, rest []string) error {
+	q := r.URL.Query()
+	gen, err := int64Param(q, "generation")
+	if err != nil {
+		return err
+	}
+	conds, err := queryConditions(q, "")
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			o, err := h.backend.getObject(bucketID, name, gen, conds)
+			if err != nil {
+				return err
+			}
+			if q.Get("alt") == "media" {
+				return serveMedia(w, r, o)
+			}
+			return writeJSON(w, objectToRaw(o.proto))
+		case http.MethodPatch, http.MethodPut:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return err
+			}
+			o, err := h.backend.updateObject(bucketID, name, gen, conds, func(o *storagepb.Object) error {
+				if err := patchObject(o, body); err != nil {
+					return err
+				}
+				if p := q.Get("predefinedAcl"); p != "" {
+					b := strings.TrimPrefix(o.Bucket, "projects/_/buckets/")
+					acl, err := h.predefinedObjectACL(b, p)
+					if err != nil {
+						return err
+					}
+					o.Acl = acl
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			return writeJSON(w, objectToRaw(o))
+		case http.MethodDelete:
+			if err := h.backend.deleteObject(bucketID, name, gen, conds); err != nil {
+				return err
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		return errMethod(r)
+	}
+
+	switch {
+	case rest[0] == "acl" && len(rest) <= 2:
+		var entity string
+		if len(rest) == 2 {
+			entity = rest[1]
+		}
+		return h.serveObjectACL(w, r, bucketID, name, gen, entity)
+	case rest[0] == "compose" && len(rest) == 1:
+		if r.Method != http.MethodPost {
+			return errMethod(r)
+		}
+		return h.compose(w, r, bucketID, name, conds)
+	case (rest[0] == "rewriteTo" || rest[0] == "copyTo") && len(rest) == 5 && rest[1] == "b" && rest[3] == "o":
+		if r.Method != http.MethodPost {
+			return errMethod(r)
+		}
+		return h.rewrite(w, r, bucketID, name, rest[2], rest[4], rest[0] == "copyTo")
+	}
+	return errNoRoute(r)
+}
+
+func (h *httpServer) predefinedObjectACL(bucketID, name string) ([]*storagepb.ObjectAccessControl, error) {
+	b, err := h.backend.getBucket(bucketID, conditions{})
+	if err != nil {
+		return nil, err
+	}
+	return predefinedObjectACL(projectOf(b), name)
+}
+
+func (h *httpServer) serveObjectACL(w http.ResponseWriter, r *http.Request, bucketID, name string, gen int64, entity string) error {
+	var noConds conditions
+	if entity == "" {
+		switch r.Method {
+		case http.MethodGet:
+			o, err := h.backend.getObject(bucketID, name, gen, noConds)
+			if err != nil {
+				return err
+			}
+			return writeJSON(w, &raw.ObjectAccessControls{
+				Kind:  "storage#objectAccessControls",
+				Items: objectACLToRaw(bucketID, name, o.proto.Generation, o.proto.Acl),
+			})
+		case http.MethodPost:
+			var a raw.ObjectAccessControl
+			if err := readJSON(r, &a); err != nil {
+				return err
+			}
+			return h.setObjectACL(w, bucketID, name, gen, a.Entity, a.Role)
+		}
+		return errMethod(r)
+	}
+	switch r.Method {
+	case http.MethodGet:
+		o, err := h.backend.getObject(bucketID, name, gen, noConds)
+		if err != nil {
+			return err
+		}
+		for _, a := range objectACLToRaw(bucketID, name, o.proto.Generation, o.proto.Acl) {
+			if a.Entity == entity {
+				return writeJSON(w, a)
+			}
+		}
+		return status.Errorf(codes.NotFound, "no ACL entry for %q", entity)
+	case http.MethodPut, http.MethodPatch:
+		var a raw.ObjectAccessControl
+		if err := readJSON(r, &a); err != nil {
+			return err
+		}
+		return h.setObjectACL(w, bucketID, name, gen, entity, a.Role)
+	case http.MethodDelete:
+		_, err := h.backend.updateObject(bucketID, name, gen, noConds, func(o *storagepb.Object) error {
+			return deleteObjectACLEntry(&o.Acl, entity)
+		})
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return errMethod(r)
+}
+
+func (h *httpServer) setObjectACL(w http.ResponseWriter, bucketID, name string, gen int64, entity, role string) error {
+	if entity == "" || role == "" {
+		return status.Error(codes.InvalidArgument, "an ACL entry needs an entity and a role")
+	}
+	var a *storagepb.ObjectAccessControl
+	o, err := h.backend.updateObject(bucketID, name, gen, conditions{}, func(o *storagepb.Object) error {
+		a = setObjectACLEntry(&o.Acl, entity, role)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, objectACLToRaw(bucketID, name, o.Generation, []*storagepb.ObjectAccessControl{a})[0])
+}
+
+func (h *httpServer) compose(w http.ResponseWriter, r *http.Request, bucketID, name string, conds conditions) error {
+	var req raw.ComposeRequest
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	dst := &storagepb.Object{}
+	var checksums *storagepb.ObjectChecksums
+	if req.Destination != nil {
+		var err error
+		if dst, checksums, err = objectFromRaw(req.Destination); err != nil {
+			return err
+		}
+	}
+	dst.Name = name
+	var srcs []composeSource
+	for _, s := range req.SourceObjects {
+		src := composeSource{name: s.Name, generation: s.Generation}
+		if p := s.ObjectPreconditions; p != nil {
+			src.conds.generationMatch = proto.Int64(p.IfGenerationMatch)
+		}
+		srcs = append(srcs, src)
+	}
+	o, err := h.backend.composeObject(bucketID, dst, srcs, conds, r.URL.Query().Get("destinationPredefinedAcl"), checksums)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, objectToRaw(o))
+}
+
+func (h *httpServer) rewrite(w http.ResponseWriter, r *http.Request, srcBucket, srcName, dstBucket, dstName string, copy bool) error {
+	q := r.URL.Query()
+	var ro raw.Object
+	if err := readJSON(r, &ro); err != nil {
+		return err
+	}
+	dst, _, err := objectFromRaw(&ro)
+	if err != nil {
+		return err
+	}
+	srcGen, err := int64Param(q, "sourceGeneration")
+	if err != nil {
+		return err
+	}
+	srcConds, err := queryConditions(q, "Source")
+	if err != nil {
+		return err
+	}
+	dstConds, err := queryConditions(q, "")
+	if err != nil {
+		return err
+	}
+	o, err := h.backend.rewriteObject(rewriteRequest{
+		srcBucket:     srcBucket,
+		srcName:       srcName,
+		srcGeneration: srcGen,
+		srcConds:      srcConds,
+		dstBucket:     dstBucket,
+		dstName:       dstName,
+		dst:           dst,
+		dstConds:      dstConds,
+		predefinedACL: q.Get("destinationPredefinedAcl"),
+	})
+	if err != nil {
+		return err
+	}
+	if copy {
+		return writeJSON(w, objectToRaw(o))
+	}
+	return writeJSON(w, &raw.RewriteResponse{
+		Kind:                "storage#rewriteResponse",
+		Done:                true,
+		ObjectSize:          o.Size,
+		TotalBytesRewritten: o.Size,
+		Resource:            objectToRaw(o),
+	})
+}
+
+// Downloads.
+
+// serveXML serves object downloads with the XML API, at /bucket/object.
+func (h *httpServer) serveXML(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return errMethod(r)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return errNoRoute(r)
+	}
+	gen, err := int64Param(r.URL.Query(), "generation")
+	if err != nil {
+		return err
+	}
+	var conds conditions
+	for _, c := range []struct {
+		header string
+		field  **int64
+	}{
+		{"X-Goog-If-Generation-Match", &conds.generationMatch},
+		{"X-Goog-If-Metageneration-Match", &conds.metagenerationMatch},
+	} {
+		if v := r.Header.Get(c.header); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "bad %s header %q", c.header, v)
+			}
+			*c.field = &n
+		}
+	}
+	o, err := h.backend.getObject(parts[0], parts[1], gen, conds)
+	if err != nil {
+		return err
+	}
+	return serveMedia(w, r, o)
+}
+
+// serveMedia writes an object's contents, honoring any Range header.
+func serveMedia(w http.ResponseWriter, r *http.Request, o *object) error {
+	p := o.proto
+	hdr := w.Header()
+	hdr.Set("Content-Type", p.ContentType)
+	if p.ContentEncoding != "" {
+		hdr.Set("Content-Encoding", p.ContentEncoding)
+		hdr.Set("X-Goog-Stored-Content-Encoding", p.ContentEncoding)
+	} else {
+		hdr.Set("X-Goog-Stored-Content-Encoding", "identity")
+	}
+	for k, v := range map[string]string{
+		"Cache-Control":       p.CacheControl,
+		"Content-Disposition": p.ContentDisposition,
+		"Content-Language":    p.ContentLanguage,
+	} {
+		if v != "" {
+			hdr.Set(k, v)
+		}
+	}
+	for k, v := range p.Metadata {
+		hdr.Set("X-Goog-Meta-"+k, v)
+	}
+	hdr.Set("Last-Modified", p.UpdateTime.AsTime().UTC().Format(http.TimeFormat))
+	hdr.Set("ETag", `"`+p.Etag+`"`)
+	hdr.Set("X-Goog-Generation", strconv.FormatInt(p.Generation, 10))
+	hdr.Set("X-Goog-Metageneration", strconv.FormatInt(p.Metageneration, 10))
+	hdr.Set("X-Goog-Stored-Content-Length", strconv.FormatInt(p.Size, 10))
+	hdr.Add("X-Goog-Hash", "crc32c="+encodeCRC32C(p.Checksums.GetCrc32C()))
+	if md5 := p.Checksums.GetMd5Hash(); len(md5) > 0 {
+		hdr.Add("X-Goog-Hash", "md5="+base64.StdEncoding.EncodeToString(md5))
+	}
+	hdr.Set("Accept-Ranges", "bytes")
+
+	data := o.data
+	code := http.StatusOK
+	// Like the service, serve the whole of gzip-encoded objects.
+	if rng := r.Header.Get("Range"); rng != "" && p.ContentEncoding != "gzip" && len(data) > 0 {
+		start, end, err := parseRange(rng, int64(len(data)))
+		if err != nil {
+			hdr.Set("Content-Range", fmt.Sprintf("bytes */%d", len(data)))
+			return err
+		}
+		hdr.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		code = http.StatusPartialContent
+	}
+	hdr.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(code)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+	return nil
+}
+
+// parseRange parses a Range header with a single byte range, returning the
+// first and last bytes of the range in an object of the given size.
+func parseRange(rng string, size int64) (start, end int64, err error) {
+	spec := strings.TrimPrefix(rng, "bytes=")
+	if spec == rng || strings.Contains(spec, ",") {
+		return 0, 0, status.Errorf(codes.InvalidArgument, "unsupported Range %q", rng)
+	}
+	first, last, hasDash := strings.Cut(spec, "-")
+	switch {
+	case first == "" || !hasDash && strings.HasPrefix(spec, "-"):
+		// A suffix range: the last n bytes.
+		n, err := strconv.ParseInt(strings.TrimPrefix(spec, "-"), 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, status.Errorf(codes.InvalidArgument, "bad Range %q", rng)
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, status.Errorf(codes.InvalidArgument, "bad Range %q", rng)
+	}
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, status.Errorf(codes.InvalidArgument, "bad Range %q", rng)
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, status.Errorf(codes.OutOfRange, "Range %q is not satisfiable for an object of %d bytes", rng, size)
+	}
+	return start, end, nil
+}
+
+// Uploads.
+
+// serveUpload serves the JSON API's upload endpoint, at the path p relative
+// to its root.
+func (h *httpServer) serveUpload(w http.ResponseWriter, r *http.Request, p string) error {
+	segs, err := splitPath(p)
+	if err != nil {
+		return err
+	}
+	if len(segs) != 3 || segs[0] != "b" || segs[2] != "o" {
+		return errNoRoute(r)
+	}
+	bucketID := segs[1]
+	q := r.URL.Query()
+	if id := q.Get("upload_id"); id != "" {
+		return h.serveUploadSession(w, r, id)
+	}
+	if r.Method != http.MethodPost {
+		return errMethod(r)
+	}
+	conds, err := queryConditions(q, "")
+	if err != nil {
+		return err
+	}
+	var (
+		resource  = &storagepb.Object{}
+		checksums *storagepb.ObjectChecksums
+		data      []byte
+	)
+	readMetadata := func(r io.Reader) error {
+		var ro raw.Object
+		if err := json.NewDecoder(r).Decode(&ro); err != nil && err != io.EOF {
+			return status.Errorf(codes.InvalidArgument, "bad object metadata: %v", err)
+		}
+		resource, checksums, err = objectFromRaw(&ro)
+		return err
+	}
+	switch q.Get("uploadType") {
+	case "media":
+		if data, err = io.ReadAll(r.Body); err != nil {
+			return err
+		}
+		resource.ContentType = r.Header.Get("Content-Type")
+	case "multipart":
+		mr, err := multipartReader(r)
+		if err != nil {
+			return err
+		}
+		part, err := mr.NextPart()
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "reading metadata part: %v", err)
+		}
+		if err := readMetadata(part); err != nil {
+			return err
+		}
+		part, err = mr.NextPart()
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "reading media part: %v", err)
+		}
+		if data, err = io.ReadAll(part); err != nil {
+			return err
+		}
+		if resource.ContentType == "" {
+			resource.ContentType = part.Header.Get("Content-Type")
+		}
+	case "resumable":
+		if err := readMetadata(r.Body); err != nil {
+			return err
+		}
+		if resource.ContentType == "" {
+			resource.ContentType = r.Header.Get("X-Upload-Content-Type")
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "unsupported uploadType %q", q.Get("uploadType"))
+	}
+	if name := q.Get("name"); name != "" {
+		resource.Name = name
+	}
+	predefinedACL := q.Get("predefinedAcl")
+
+	if q.Get("uploadType") != "resumable" {
+		o, err := h.backend.insertObject(bucketID, resource, data, conds, predefinedACL, checksums)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, objectToRaw(o))
+	}
+	size := int64(-1)
+	if v := r.Header.Get("X-Upload-Content-Length"); v != "" {
+		if size, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return status.Errorf(codes.InvalidArgument, "bad X-Upload-Content-Length %q", v)
+		}
+	}
+	id, err := h.backend.startUpload(bucketID, resource, conds, predefinedACL, checksums, size)
+	if err != nil {
+		return err
+	}
+	loc := url.URL{
+		Scheme:   "http",
+		Host:     r.Host,
+		Path:     r.URL.Path,
+		RawQuery: url.Values{"uploadType": {"resumable"}, "upload_id": {id}}.Encode(),
+	}
+	w.Header().Set("Location", loc.String())
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// serveUploadSession serves requests to the session URI of a resumable upload.
+func (h *httpServer) serveUploadSession(w http.ResponseWriter, r *http.Request, id string) error {
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+	case http.MethodDelete:
+		if err := h.backend.cancelUpload(id); err != nil {
+			return err
+		}
+		// The service uses the non-standard status 499 for a cancelled upload.
+		w.WriteHeader(499)
+		return nil
+	default:
+		return errMethod(r)
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	// The Content-Range header is one of
+	//	bytes first-last/total   a chunk, which is final if last+1 = total
+	//	bytes first-last/*       a chunk of an upload of unknown size
+	//	bytes */total            the end of an upload of the given size
+	//	bytes */*                a status query
+	// If it's missing, the body is all of the object.
+	var (
+		offset int64
+		total  = int64(-1)
+		query  bool
+	)
+	if cr := r.Header.Get("Content-Range"); cr != "" {
+		spec := strings.TrimPrefix(cr, "bytes ")
+		rng, tot, ok := strings.Cut(spec, "/")
+		if spec == cr || !ok {
+			return status.Errorf(codes.InvalidArgument, "bad Content-Range %q", cr)
+		}
+		if tot != "*" {
+			if total, err = strconv.ParseInt(tot, 10, 64); err != nil {
+				return status.Errorf(codes.InvalidArgument, "bad Content-Range %q", cr)
+			}
+		}
+		if rng == "*" {
+			if len(data) > 0 {
+				return status.Errorf(codes.InvalidArgument, "Content-Range %q with a non-empty body", cr)
+			}
+			query = total < 0
+			persisted, _, err := h.backend.queryUpload(id)
+			if err != nil {
+				return err
+			}
+			offset = persisted
+		} else {
+			first, last, ok := strings.Cut(rng, "-")
+			f, err1 := strconv.ParseInt(first, 10, 64)
+			l, err2 := strconv.ParseInt(last, 10, 64)
+			if !ok || err1 != nil || err2 != nil || l-f+1 != int64(len(data)) {
+				return status.Errorf(codes.InvalidArgument, "bad Content-Range %q for %d bytes", cr, len(data))
+			}
+			offset = f
+		}
+	} else {
+		total = int64(len(data))
+	}
+
+	var (
+		persisted int64
+		o         *storagepb.Object
+	)
+	if query {
+		persisted, o, err = h.backend.queryUpload(id)
+	} else {
+		finish := total >= 0 && offset+int64(len(data)) >= total
+		if finish && offset+int64(len(data)) != total {
+			return status.Errorf(codes.InvalidArgument, "upload ends at %d, want %d", offset+int64(len(data)), total)
+		}
+		persisted, o, err = h.backend.writeUpload(id, offset, data, nil, finish)
+	}
+	if err != nil {
+		return err
+	}
+	if o != nil {
+		return writeJSON(w, objectToRaw(o))
+	}
+	// The upload is incomplete. The service replies with 308, unless the
+	// client asks for 200 with a header instead.
+	if persisted > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", persisted-1))
+	}
+	if r.Header.Get("X-GUploader-No-308") == "yes" {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusPermanentRedirect)
+	}
+	return nil
+}
+
+// multipartReader returns a reader for the parts of a multipart/related
+// request body.
+func multipartReader(r *http.Request) (*multipart.Reader, error) {
+	mt, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mt, "multipart/") || params["boundary"] == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "bad Content-Type %q for a multipart upload", r.Header.Get("Content-Type"))
+	}
+	return multipart.NewReader(r.Body, params["boundary"]), nil
+}
+
+// Parameters.
+
+func readJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		return status.Errorf(codes.InvalidArgument, "bad request body: %v", err)
+	}
+	return nil
+}
+
+func int64Param(q url.Values, name string) (int64, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "bad %s parameter %q", name, v)
+	}
+	return n, nil
+}
+
+func intParam(q url.Values, name string) (int, error) {
+	n, err := int64Param(q, name)
+	return int(n), err
+}
+
+// queryConditions returns the preconditions in a request's query
+// parameters. The source object's conditions of a rewrite have the infix
+// "Source".
+func queryConditions(q url.Values, infix string) (conditions, error) {
+	var c conditions
+	for _, p := range []struct {
+		name  string
+		field **int64
+	}{
+		{"if" + infix + "GenerationMatch", &c.generationMatch},
+		{"if" + infix + "GenerationNotMatch", &c.generationNotMatch},
+		{"if" + infix + "MetagenerationMatch", &c.metagenerationMatch},
+		{"if" + infix + "MetagenerationNotMatch", &c.metagenerationNotMatch},
+	} {
+		if q.Get(p.name) == "" {
+			continue
+		}
+		n, err := int64Param(q, p.name)
+		if err != nil {
+			return c, err
+		}
+		*p.field = &n
+	}
+	return c, nil
+}
+
+// Conversions between the protos stored by the backend and the JSON API's
+// resources.
+
+func formatTime(ts *timestamppb.Timestamp) string {
+	if ts == nil {
+		return ""
+	}
+	return ts.AsTime().UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (*timestamppb.Timestamp, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "bad time %q", s)
+	}
+	return timestamppb.New(t), nil
+}
+
+func encodeCRC32C(c uint32) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], c)
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+func bucketToRaw(b *storagepb.Bucket) *raw.Bucket {
+	rb := &raw.Bucket{
+		Kind:                  "storage#bucket",
+		Id:                    b.BucketId,
+		Name:                  b.BucketId,
+		Etag:                  b.Etag,
+		Metageneration:        b.Metageneration,
+		Location:              b.Location,
+		LocationType:          b.LocationType,
+		StorageClass:          b.StorageClass,
+		Rpo:                   b.Rpo,
+		TimeCreated:           formatTime(b.CreateTime),
+		Updated:               formatTime(b.UpdateTime),
+		Labels:                b.Labels,
+		DefaultEventBasedHold: b.DefaultEventBasedHold,
+		Acl:                   bucketACLToRaw(b.BucketId, b.Acl),
+		DefaultObjectAcl:      objectACLToRaw(b.BucketId, "", 0, b.DefaultObjectAcl),
+	}
+	if n, err := strconv.ParseUint(projectOf(b), 10, 64); err == nil {
+		rb.ProjectNumber = n
+	}
+	if v := b.Versioning; v != nil {
+		rb.Versioning = &raw.BucketVersioning{Enabled: v.Enabled}
+	}
+	if bl := b.Billing; bl != nil {
+		rb.Billing = &raw.BucketBilling{RequesterPays: bl.RequesterPays}
+	}
+	if rp := b.RetentionPolicy; rp != nil {
+		rb.RetentionPolicy = &raw.BucketRetentionPolicy{
+			EffectiveTime:   formatTime(rp.EffectiveTime),
+			IsLocked:        rp.IsLocked,
+			RetentionPeriod: rp.GetRetentionPeriod(),
+		}
+	}
+	for _, c := range b.Cors {
+		rb.Cors = append(rb.Cors, &raw.BucketCors{
+			Origin:         c.Origin,
+			Method:         c.Method,
+			ResponseHeader: c.ResponseHeader,
+			MaxAgeSeconds:  int64(c.MaxAgeSeconds),
+		})
+	}
+	if ws := b.Website; ws != nil {
+		rb.Website = &raw.BucketWebsite{MainPageSuffix: ws.MainPageSuffix, NotFoundPage: ws.NotFoundPage}
+	}
+	if l := b.Logging; l != nil {
+		rb.Logging = &raw.BucketLogging{LogBucket: l.LogBucket, LogObjectPrefix: l.LogObjectPrefix}
+	}
+	return rb
+}
+
+// bucketFromRaw returns the bucket fields the fake supports.
+func bucketFromRaw(rb *raw.Bucket) *storagepb.Bucket {
+	b := &storagepb.Bucket{
+		Name:                  rb.Name,
+		Location:              rb.Location,
+		StorageClass:          rb.StorageClass,
+		Rpo:                   rb.Rpo,
+		Labels:                rb.Labels,
+		DefaultEventBasedHold: rb.DefaultEventBasedHold,
+		Acl:                   bucketACLFromRaw(rb.Acl),
+		DefaultObjectAcl:      objectACLFromRaw(rb.DefaultObjectAcl),
+	}
+	if v := rb.Versioning; v != nil {
+		b.Versioning = &storagepb.Bucket_Versioning{Enabled: v.Enabled}
+	}
+	if bl := rb.Billing; bl != nil {
+		b.Billing = &storagepb.Bucket_Billing{RequesterPays: bl.RequesterPays}
+	}
+	if rp := rb.RetentionPolicy; rp != nil {
+		b.RetentionPolicy = &storagepb.Bucket_RetentionPolicy{RetentionPeriod: proto.Int64(rp.RetentionPeriod)}
+	}
+	for _, c := range rb.Cors {
+		b.Cors = append(b.Cors, &storagepb.Bucket_Cors{
+			Origin:         c.Origin,
+			Method:         c.Method,
+			ResponseHeader: c.ResponseHeader,
+			MaxAgeSeconds:  int32(c.MaxAgeSeconds),
+		})
+	}
+	if ws := rb.Website; ws != nil {
+		b.Website = &storagepb.Bucket_Website{MainPageSuffix: ws.MainPageSuffix, NotFoundPage: ws.NotFoundPage}
+	}
+	if l := rb.Logging; l != nil {
+		b.Logging = &storagepb.Bucket_Logging{LogBucket: l.LogBucket, LogObjectPrefix: l.LogObjectPrefix}
+	}
+	return b
+}
+
+// patchBucket applies the fields in the JSON body of a bucket patch request
+// to b. A null field is cleared, and a null label is deleted.
+func patchBucket(b *storagepb.Bucket, body []byte) error {
+	var fields map[string]json.RawMessage
+	var rb raw.Bucket
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return status.Errorf(codes.InvalidArgument, "bad request body: %v", err)
+	}
+	if err := json.Unmarshal(body, &rb); err != nil {
+		return status.Errorf(codes.InvalidArgument, "bad request body: %v", err)
+	}
+	nb := bucketFromRaw(&rb)
+	for k, v := range fields {
+		switch k {
+		case "storageClass":
+			if nb.StorageClass != "" {
+				b.StorageClass = nb.StorageClass
+			}
+		case "rpo":
+			if nb.Rpo != "" {
+				b.Rpo = nb.Rpo
+			}
+		case "labels":
+			var labels map[string]*string
+			if err := json.Unmarshal(v, &labels); err != nil {
+				return status.Errorf(codes.InvalidArgument, "bad labels: %v", err)
+			}
+			if labels == nil {
+				b.Labels = nil
+			}
+			for l, val := range labels {
+				if val == nil {
+					delete(b.Labels, l)
+					continue
+				}
+				if b.Labels == nil {
+					b.Labels = map[string]string{}
+				}
+				b.Labels[l] = *val
+			}
+		case "defaultEventBasedHold":
+			b.DefaultEventBasedHold = nb.DefaultEventBasedHold
+		case "versioning":
+			b.Versioning = nb.Versioning
+		case "billing":
+			b.Billing = nb.Billing
+		case "retentionPolicy":
+			b.RetentionPolicy = nb.RetentionPolicy
+		case "cors":
+			b.Cors = nb.Cors
+		case "website":
+			b.Website = nb.Website
+		case "logging":
+			b.Logging = nb.Logging
+		case "acl":
+			b.Acl = nb.Acl
+		case "defaultObjectAcl":
+			b.DefaultObjectAcl = nb.DefaultObjectAcl
+		}
+	}
+	return nil
+}
+
+func bucketACLToRaw(bucketID string, acl []*storagepb.BucketAccessControl) []*raw.BucketAccessControl {
+	var ras []*raw.BucketAccessControl
+	for _, a := range acl {
+		ras = append(ras, &raw.BucketAccessControl{
+			Kind:     "storage#bucketAccessControl",
+			Id:       bucketID + "/" + a.Entity,
+			Bucket:   bucketID,
+			Entity:   a.Entity,
+			Role:     a.Role,
+			Email:    a.Email,
+			Domain:   a.Domain,
+			EntityId: a.EntityId,
+		})
+	}
+	return ras
+}
+
+func bucketACLFromRaw(ras []*raw.BucketAccessControl) []*storagepb.BucketAccessControl {
+	var acl []*storagepb.BucketAccessControl
+	for _, a := range ras {
+		acl = append(acl, &storagepb.BucketAccessControl{
+			Entity:   a.Entity,
+			Role:     a.Role,
+			Email:    a.Email,
+			Domain:   a.Domain,
+			EntityId: a.EntityId,
+		})
+	}
+	return acl
+}
+
+// objectACLToRaw converts an object's ACL, or a bucket's default object ACL
+// if object is empty.
+func objectACLToRaw(bucketID, object string, gen int64, acl []*storagepb.ObjectAccessControl) []*raw.ObjectAccessControl {
+	var ras []*raw.ObjectAccessControl
+	for _, a := range acl {
+		ra := &raw.ObjectAccessControl{
+			Kind:       "storage#objectAccessControl",
+			Bucket:     bucketID,
+			Object:     object,
+			Generation: gen,
+			Entity:     a.Entity,
+			Role:       a.Role,
+			Email:      a.Email,
+			Domain:     a.Domain,
+			EntityId:   a.EntityId,
+		}
+		if object != "" {
+			ra.Id = fmt.Sprintf("%s/%s/%d/%s", bucketID, object, gen, a.Entity)
+		}
+		ras = append(ras, ra)
+	}
+	return ras
+}
+
+func objectACLFromRaw(ras []*raw.ObjectAccessControl) []*storagepb.ObjectAccessControl {
+	var acl []*storagepb.ObjectAccessControl
+	for _, a := range ras {
+		acl = append(acl, &storagepb.ObjectAccessControl{
+			Entity:   a.Entity,
+			Role:     a.Role,
+			Email:    a.Email,
+			Domain:   a.Domain,
+			EntityId: a.EntityId,
+		})
+	}
+	return acl
+}
+
+func objectToRaw(o *storagepb.Object) *raw.Object {
+	bucketID := path.Base(o.Bucket)
+	ro := &raw.Object{
+		Kind:                    "storage#object",
+		Id:                      fmt.Sprintf("%s/%s/%d", bucketID, o.Name, o.Generation),
+		Name:                    o.Name,
+		Bucket:                  bucketID,
+		Generation:              o.Generation,
+		Metageneration:          o.Metageneration,
+		Etag:                    o.Etag,
+		Size:                    uint64(o.Size),
+		StorageClass:            o.StorageClass,
+		ContentType:             o.ContentType,
+		ContentEncoding:         o.ContentEncoding,
+		ContentDisposition:      o.ContentDisposition,
+		ContentLanguage:         o.ContentLanguage,
+		CacheControl:            o.CacheControl,
+		Metadata:                o.Metadata,
+		ComponentCount:          int64(o.ComponentCount),
+		EventBasedHold:          o.GetEventBasedHold(),
+		TemporaryHold:           o.TemporaryHold,
+		KmsKeyName:              o.KmsKey,
+		TimeCreated:             formatTime(o.CreateTime),
+		Updated:                 formatTime(o.UpdateTime),
+		TimeDeleted:             formatTime(o.DeleteTime),
+		TimeStorageClassUpdated: formatTime(o.UpdateStorageClassTime),
+		CustomTime:              formatTime(o.CustomTime),
+		RetentionExpirationTime: formatTime(o.RetentionExpireTime),
+		Acl:                     objectACLToRaw(bucketID, o.Name, o.Generation, o.Acl),
+	}
+	if cs := o.Checksums; cs != nil {
+		if cs.Crc32C != nil {
+			ro.Crc32c = encodeCRC32C(*cs.Crc32C)
+		}
+		if len(cs.Md5Hash) > 0 {
+			ro.Md5Hash = base64.StdEncoding.EncodeToString(cs.Md5Hash)
+		}
+	}
+	if ow := o.Owner; ow != nil {
+		ro.Owner = &raw.ObjectOwner{Entity: ow.Entity, EntityId: ow.EntityId}
+	}
+	return ro
+}
+
+// objectFromRaw returns the user-settable fields of an object resource, and
+// the checksums it gives for the object's contents.
+func objectFromRaw(ro *raw.Object) (*storagepb.Object, *storagepb.ObjectChecksums, error) {
+	o := &storagepb.Object{
+		Name:               ro.Name,
+		ContentType:        ro.ContentType,
+		ContentEncoding:    ro.ContentEncoding,
+		ContentDisposition: ro.ContentDisposition,
+		ContentLanguage:    ro.ContentLanguage,
+		CacheControl:       ro.CacheControl,
+		StorageClass:       ro.StorageClass,
+		Metadata:           ro.Metadata,
+		TemporaryHold:      ro.TemporaryHold,
+		KmsKey:             ro.KmsKeyName,
+		Acl:                objectACLFromRaw(ro.Acl),
+	}
+	if ro.EventBasedHold {
+		o.EventBasedHold = proto.Bool(true)
+	}
+	var err error
+	if o.CustomTime, err = parseTime(ro.CustomTime); err != nil {
+		return nil, nil, err
+	}
+	var cs *storagepb.ObjectChecksums
+	if ro.Crc32c != "" {
+		b, err := base64.StdEncoding.DecodeString(ro.Crc32c)
+		if err != nil || len(b) != 4 {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "bad crc32c %q", ro.Crc32c)
+		}
+		cs = &storagepb.ObjectChecksums{Crc32C: proto.Uint32(binary.BigEndian.Uint32(b))}
+	}
+	if ro.Md5Hash != "" {
+		b, err := base64.StdEncoding.DecodeString(ro.Md5Hash)
+		if err != nil {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "bad md5Hash %q", ro.Md5Hash)
+		}
+		if cs == nil {
+			cs = &storagepb.ObjectChecksums{}
+		}
+		cs.Md5Hash = b
+	}
+	return o, cs, nil
+}
+
+// patchObject applies the fields in the JSON body of an object patch request
+// to o. A null field is cleared, and a null metadata entry is deleted.
+func patchObject(o *storagepb.Object, body []byte) error {
+	var fields map[string]json.RawMessage
+	var ro raw.Object
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return status.Errorf(codes.InvalidArgument, "bad request body: %v", err)
+	}
+	if err := json.Unmarshal(body, &ro); err != nil {
+		return status.Errorf(codes.InvalidArgument, "bad request body: %v", err)
+	}
+	no, _, err := objectFromRaw(&ro)
+	if err != nil {
+		return err
+	}
+	for k, v := range fields {
+		switch k {
+		case "contentType":
+			o.ContentType = no.ContentType
+		case "contentEncoding":
+			o.ContentEncoding = no.ContentEncoding
+		case "contentDisposition":
+			o.ContentDisposition = no.ContentDisposition
+		case "contentLanguage":
+			o.ContentLanguage = no.ContentLanguage
+		case "cacheControl":
+			o.CacheControl = no.CacheControl
+		case "customTime":
+			if o.CustomTime != nil && no.CustomTime != nil && no.CustomTime.AsTime().Before(o.CustomTime.AsTime()) {
+				return status.Error(codes.InvalidArgument, "custom time can't be decreased")
+			}
+			o.CustomTime = no.CustomTime
+		case "eventBasedHold":
+			o.EventBasedHold = proto.Bool(ro.EventBasedHold)
+		case "temporaryHold":
+			o.TemporaryHold = ro.TemporaryHold
+		case "acl":
+			o.Acl = no.Acl
+		case "metadata":
+			var md map[string]*string
+			if err := json.Unmarshal(v, &md); err != nil {
+				return status.Errorf(codes.InvalidArgument, "bad metadata: %v", err)
+			}
+			if md == nil {
+				o.Metadata = nil
+			}
+			for mk, mv := range md {
+				if mv == nil {
+					delete(o.Metadata, mk)
+					continue
+				}
+				if o.Metadata == nil {
+					o.Metadata = map[string]string{}
+				}
+				o.Metadata[mk] = *mv
+			}
+		}
+	}
+	return nil
+}
+
+func notificationToRaw(n *storagepb.Notification) *raw.Notification {
+	return &raw.Notification{
+		Kind:             "storage#notification",
+		Id:               path.Base(n.Name),
+		Topic:            n.Topic,
+		Etag:             n.Etag,
+		EventTypes:       n.EventTypes,
+		CustomAttributes: n.CustomAttributes,
+		ObjectNamePrefix: n.ObjectNamePrefix,
+		PayloadFormat:    n.PayloadFormat,
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package storagetest provides a fake Cloud Storage server for testing. It
+keeps buckets and objects in memory, and serves the JSON API, object
+downloads with the XML API, and the gRPC API, so that a storage.Client can
+use it in place of the real service.
+
+The fake supports buckets, objects with generations and metagenerations,
+object versioning, the preconditions of storage.Conditions and
+storage.BucketConditions, simple, multipart and resumable uploads, ranged
+reads, compose, copy and rewrite, bucket and object ACLs, and Pub/Sub
+notification configurations.
+
+It does not implement IAM policies, HMAC keys, signed URLs, customer-supplied
+encryption keys, or object lifecycle management. Over the JSON API it keeps
+only a subset of bucket fields: versioning, labels, storage class, location,
+default event-based hold, requester pays, retention policy, CORS, website,
+logging, RPO and ACLs. Every rewrite finishes in a single call.
+
+To use the fake with a client that uses the JSON API:
+
+	srv, err := storagetest.NewServer()
+	if err != nil {
+		// TODO: Handle error.
+	}
+	defer srv.Close()
+	client, err := storage.NewClient(ctx, srv.HTTPClientOptions()...)
+
+To use the gRPC API, set the STORAGE_USE_GRPC environment variable and
+pass srv.GRPCClientOptions() to storage.NewClient instead.
+
+This package is EXPERIMENTAL and is subject to change without notice.
+*/
+package storagetest // import "cloud.google.com/go/storage/storagetest"
+
+import (
+	"net/http/httptest"
+
+	"cloud.google.com/go/internal/testutil"
+	storagepb "cloud.google.com/go/storage/internal/apiv2/stubs"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+// Server is a fake Cloud Storage server.
+type Server struct {
+	// URL is the base URL of the JSON and XML APIs, like
+	// "http://127.0.0.1:1234".
+	URL string
+	// Addr is the address of the gRPC API.
+	Addr string
+
+	http *httptest.Server
+	grpc *testutil.Server
+}
+
+// NewServer creates and starts a fake server running in the current process.
+func NewServer() (*Server, error) {
+	b := newBackend()
+	gsrv, err := testutil.NewServer()
+	if err != nil {
+		return nil, err
+	}
+	storagepb.RegisterStorageServer(gsrv.Gsrv, &grpcServer{backend: b})
+	gsrv.Start()
+	hsrv := httptest.NewServer(&httpServer{backend: b})
+	return &Server{
+		URL:  hsrv.URL,
+		Addr: gsrv.Addr,
+		http: hsrv,
+		grpc: gsrv,
+	}, nil
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.http.Close()
+	s.grpc.Close()
+}
+
+// HTTPClientOptions returns the options for a storage.Client that uses the
+// server's JSON and XML APIs.
+func (s *Server) HTTPClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.URL + "/storage/v1/"),
+		option.WithoutAuthentication(),
+	}
+}
+
+// GRPCClientOptions returns the options for a storage.Client that uses the
+// server's gRPC API.
+func (s *Server) GRPCClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.Addr),
+		option.WithGRPCDialOption(grpc.WithInsecure()),
+		option.WithoutAuthentication(),
+	}
+}
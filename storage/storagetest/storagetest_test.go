@@ -0,0 +1,500 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const project = "123456"
+
+// forEachTransport runs test with a client for each of the server's APIs.
+func forEachTransport(t *testing.T, test func(t *testing.T, c *storage.Client)) {
+	for _, transport := range []string{"http", "grpc"} {
+		t.Run(transport, func(t *testing.T) {
+			srv, err := NewServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Close()
+			opts := srv.HTTPClientOptions()
+			if transport == "grpc" {
+				t.Setenv("STORAGE_USE_GRPC", "true")
+				opts = srv.GRPCClientOptions()
+			}
+			c, err := storage.NewClient(context.Background(), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			test(t, c)
+		})
+	}
+}
+
+func mustCreateBucket(t *testing.T, c *storage.Client, name string, attrs *storage.BucketAttrs) *storage.BucketHandle {
+	t.Helper()
+	b := c.Bucket(name)
+	if err := b.Create(context.Background(), project, attrs); err != nil {
+		t.Fatalf("creating bucket %q: %v", name, err)
+	}
+	return b
+}
+
+func write(t *testing.T, o *storage.ObjectHandle, contents string) *storage.ObjectAttrs {
+	t.Helper()
+	w := o.NewWriter(context.Background())
+	w.ContentType = "text/plain"
+	if _, err := io.WriteString(w, contents); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("writing %q: %v", o.ObjectName(), err)
+	}
+	return w.Attrs()
+}
+
+func read(t *testing.T, o *storage.ObjectHandle) string {
+	t.Helper()
+	r, err := o.NewReader(context.Background())
+	if err != nil {
+		t.Fatalf("reading %q: %v", o.ObjectName(), err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading %q: %v", o.ObjectName(), err)
+	}
+	return string(b)
+}
+
+// isPreconditionFailed reports whether err is a failed precondition from
+// either API.
+func isPreconditionFailed(err error) bool {
+	var e *googleapi.Error
+	if errors.As(err, &e) {
+		return e.Code == http.StatusPreconditionFailed
+	}
+	return status.Code(err) == codes.FailedPrecondition
+}
+
+func TestBuckets(t *testing.T) {
+	forEachTransport(t, func(t *testing.T, c *storage.Client) {
+		ctx := context.Background()
+		b := mustCreateBucket(t, c, "bucket-1", &storage.BucketAttrs{Labels: map[string]string{"env": "test"}})
+		mustCreateBucket(t, c, "bucket-2", nil)
+		if err := b.Create(ctx, project, nil); err == nil {
+			t.Error("creating an existing bucket: got nil, want error")
+		}
+
+		attrs, err := b.Attrs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Name != "bucket-1" || attrs.Labels["env"] != "test" || attrs.StorageClass != "STANDARD" || attrs.Location != "US" {
+			t.Errorf("got attrs %+v", attrs)
+		}
+
+		var uattrs storage.BucketAttrsToUpdate
+		uattrs.VersioningEnabled = true
+		attrs, err = b.If(storage.BucketConditions{MetagenerationMatch: attrs.MetaGeneration}).Update(ctx, uattrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !attrs.VersioningEnabled || attrs.MetaGeneration != 2 {
+			t.Errorf("got versioning %t, metageneration %d; want true, 2", attrs.VersioningEnabled, attrs.MetaGeneration)
+		}
+		_, err = b.If(storage.BucketConditions{MetagenerationMatch: 1}).Update(ctx, uattrs)
+		if !isPreconditionFailed(err) {
+			t.Errorf("update with a stale metageneration: got %v, want a failed precondition", err)
+		}
+
+		var names []string
+		it := c.Buckets(ctx, project)
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, attrs.Name)
+		}
+		if got, want := strings.Join(names, ","), "bucket-1,bucket-2"; got != want {
+			t.Errorf("got buckets %s, want %s", got, want)
+		}
+
+		b2 := c.Bucket("bucket-2")
+		write(t, b2.Object("obj"), "x")
+		if err := b2.Delete(ctx); err == nil {
+			t.Error("deleting a non-empty bucket: got nil, want error")
+		}
+		if err := b2.Object("obj").Delete(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := b2.Delete(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b2.Attrs(ctx); err != storage.ErrBucketNotExist {
+			t.Errorf("got %v, want ErrBucketNotExist", err)
+		}
+	})
+}
+
+func TestObjects(t *testing.T) {
+	forEachTransport(t, func(t *testing.T, c *storage.Client) {
+		ctx := context.Background()
+		b := mustCreateBucket(t, c, "bucket", nil)
+		o := b.Object("dir/hello.txt")
+		const contents = "hello, world"
+		attrs := write(t, o, contents)
+		if attrs.Size != int64(len(contents)) || attrs.CRC32C != crc32.Checksum([]byte(contents), crc32cTable) || attrs.ContentType != "text/plain" {
+			t.Errorf("got attrs %+v", attrs)
+		}
+		if got := read(t, o); got != contents {
+			t.Errorf("got %q, want %q", got, contents)
+		}
+
+		r, err := o.NewRangeReader(ctx, 7, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "wor" {
+			t.Errorf("range read: got %q, want %q", got, "wor")
+		}
+
+		uattrs, err := o.Update(ctx, storage.ObjectAttrsToUpdate{ContentLanguage: "en"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uattrs.ContentLanguage != "en" || uattrs.Metageneration != 2 || uattrs.Generation != attrs.Generation {
+			t.Errorf("got updated attrs %+v", uattrs)
+		}
+
+		if _, err := b.Object("missing").Attrs(ctx); err != storage.ErrObjectNotExist {
+			t.Errorf("got %v, want ErrObjectNotExist", err)
+		}
+		if _, err := b.Object("missing").NewReader(ctx); err != storage.ErrObjectNotExist {
+			t.Errorf("got %v, want ErrObjectNotExist", err)
+		}
+	})
+}
+
+func TestPreconditions(t *testing.T) {
+	forEachTransport(t, func(t *testing.T, c *storage.Client) {
+		ctx := context.Background()
+		b := mustCreateBucket(t, c, "bucket", nil)
+		o := b.Object("obj")
+
+		// DoesNotExist lets only the first write succeed.
+		w := o.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+		io.WriteString(w, "first")
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		gen := w.Attrs().Generation
+		w = o.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+		io.WriteString(w, "second")
+		if err := w.Close(); !isPreconditionFailed(err) {
+			t.Errorf("second write: got %v, want a failed precondition", err)
+		}
+
+		if _, err := o.If(storage.Conditions{GenerationMatch: gen + 1}).Attrs(ctx); !isPreconditionFailed(err) {
+			t.Errorf("attrs with the wrong generation: got %v, want a failed precondition", err)
+		}
+		if _, err := o.If(storage.Conditions{GenerationMatch: gen, MetagenerationMatch: 1}).Update(ctx, storage.ObjectAttrsToUpdate{CacheControl: "no-cache"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := o.If(storage.Conditions{MetagenerationMatch: 1}).Update(ctx, storage.ObjectAttrsToUpdate{CacheControl: "public"}); !isPreconditionFailed(err) {
+			t.Errorf("update with a stale metageneration: got %v, want a failed precondition", err)
+		}
+		if err := o.If(storage.Conditions{GenerationNotMatch: gen}).Delete(ctx); !isPreconditionFailed(err) {
+			t.Errorf("delete: got %v, want a failed precondition", err)
+		}
+		if got := read(t, o); got != "first" {
+			t.Errorf("got %q, want %q", got, "first")
+		}
+	})
+}
+
+func TestVersioning(t *testing.T) {
+	forEachTransport(t, func(t *testing.T, c *storage.Client) {
+		ctx := context.Background()
+		b := mustCreateBucket(t, c, "bucket", &storage.BucketAttrs{VersioningEnabled: true})
+		o := b.Object("obj")
+		gen1 := write(t, o, "v1").Generation
+		gen2 := write(t, o, "v2").Generation
+		if gen2 <= gen1 {
+			t.Fatalf("generation %d isn't after %d", gen2, gen1)
+		}
+		if got := read(t, o.Generation(gen1)); got != "v1" {
+			t.Errorf("generation %d: got %q, want %q", gen1, got, "v1")
+		}
+		if err := o.Delete(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := o.Attrs(ctx); err != storage.ErrObjectNotExist {
+			t.Errorf("got %v, want ErrObjectNotExist", err)
+		}
+
+		var gens []int64
+		it := b.Objects(ctx, &storage.Query{Versions: true})
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attrs.Deleted.IsZero() {
+				t.Errorf("generation %d isn't deleted", attrs.Generation)
+			}
+			gens = append(gens, attrs.Generation)
+		}
+		if len(gens) != 2 || gens[0] != gen1 || gens[1] != gen2 {
+			t.Errorf("got generations %v, want [%d %d]", gens, gen1, gen2)
+		}
+		if got := read(t, o.Generation(gen2)); got != "v2" {
+			t.Errorf("generation %d: got %q, want %q", gen2, got, "v2")
+		}
+	})
+}
+
+func TestResumableUpload(t *testing.T) {
+	forEachTransport(t, func(t *testing.T, c *storage.Client) {
+		ctx := context.Background()
+		b := mustCreateBucket(t, c, "bucket", nil)
+		o := b.Object("big")
+		data := bytes.Repeat([]byte("0123456789abcdef"), 100*1024)
+		w := o.NewWriter(ctx)
+		w.ChunkSize = 256 * 1024
+		w.SendCRC32C = true
+		w.CRC32C = crc32.Checksum(data, crc32cTable)
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := w.Attrs().Size, int64(len(data)); got != want {
+			t.Errorf("got size %d, want %d", got, want)
+		}
+		if got := read(t, o); got != string(data) {
+			t.Errorf("got %d bytes, differing from the %d written", len(got), len(data))
+		}
+
+		// A mismatched checksum fails the upload.
+		w = b.Object("bad").NewWriter(ctx)
+		w.ChunkSize = 256 * 1024
+		w.SendCRC32C = true
+		w.CRC32C = crc32.Checksum(data, crc32cTable) + 1
+		w.Write(data)
+		if err := w.Close(); err == nil {
+			t.Error("upload with a bad checksum: got nil, want error")
+		}
+		if _, err := b.Object("bad").Attrs(ctx); err != storage.ErrObjectNotExist {
+			t.Errorf("got %v, want ErrObjectNotExist", err)
+		}
+	})
+}
+
+func TestComposeAndCopy(t *testing.T) {
+	forEachTransport(t, func(t *testing.T, c *storage.Client) {
+		ctx := context.Background()
+		b := mustCreateBucket(t, c, "bucket", nil)
+		b2 := mustCreateBucket(t, c, "bucket-2", nil)
+		a, bb := b.Object("a"), b.Object("b")
+		write(t, a, "abc")
+		write(t, bb, "def")
+
+		attrs, err := b.Object("ab").ComposerFrom(a, bb).Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Size != 6 {
+			t.Errorf("got size %d, want 6", attrs.Size)
+		}
+		if got := read(t, b.Object("ab")); got != "abcdef" {
+			t.Errorf("got %q, want %q", got, "abcdef")
+		}
+
+		cp := b2.Object("copy").CopierFrom(b.Object("ab"))
+		cp.ContentType = "text/csv"
+		attrs, err = cp.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Bucket != "bucket-2" || attrs.ContentType != "text/csv" {
+			t.Errorf("got copy attrs %+v", attrs)
+		}
+		if got := read(t, b2.Object("copy")); got != "abcdef" {
+			t.Errorf("got %q, want %q", got, "abcdef")
+		}
+
+		_, err = b.Object("x").CopierFrom(a.If(storage.Conditions{GenerationMatch: 1})).Run(ctx)
+		if !isPreconditionFailed(err) {
+			t.Errorf("copy with a source precondition: got %v, want a failed precondition", err)
+		}
+	})
+}
+
+func TestListObjects(t *testing.T) {
+	forEachTransport(t, func(t *testing.T, c *storage.Client) {
+		ctx := context.Background()
+		b := mustCreateBucket(t, c, "bucket", nil)
+		for _, name := range []string{"a/1", "a/2", "b/1", "c", "d"} {
+			write(t, b.Object(name), name)
+		}
+		var got []string
+		it := b.Objects(ctx, &storage.Query{Delimiter: "/"})
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attrs.Prefix != "" {
+				got = append(got, attrs.Prefix)
+			} else {
+				got = append(got, attrs.Name)
+			}
+		}
+		sort.Strings(got)
+		if got, want := strings.Join(got, ","), "a/,b/,c,d"; got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+
+		got = nil
+		it = b.Objects(ctx, &storage.Query{Prefix: "a/"})
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, attrs.Name)
+		}
+		if got, want := strings.Join(got, ","), "a/1,a/2"; got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	})
+}
+
+func TestACLs(t *testing.T) {
+	forEachTransport(t, func(t *testing.T, c *storage.Client) {
+		ctx := context.Background()
+		b := mustCreateBucket(t, c, "bucket", nil)
+		o := b.Object("obj")
+		write(t, o, "x")
+
+		const entity = storage.ACLEntity("user-alice@example.com")
+		if err := o.ACL().Set(ctx, entity, storage.RoleReader); err != nil {
+			t.Fatal(err)
+		}
+		hasRole := func(rules []storage.ACLRule, role storage.ACLRole) bool {
+			for _, r := range rules {
+				if r.Entity == entity {
+					return r.Role == role
+				}
+			}
+			return false
+		}
+		rules, err := o.ACL().List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hasRole(rules, storage.RoleReader) {
+			t.Errorf("object ACL %v has no reader %s", rules, entity)
+		}
+		if err := o.ACL().Delete(ctx, entity); err != nil {
+			t.Fatal(err)
+		}
+		rules, err = o.ACL().List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hasRole(rules, storage.RoleReader) {
+			t.Errorf("object ACL %v still has %s", rules, entity)
+		}
+
+		if err := b.ACL().Set(ctx, entity, storage.RoleWriter); err != nil {
+			t.Fatal(err)
+		}
+		rules, err = b.ACL().List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hasRole(rules, storage.RoleWriter) {
+			t.Errorf("bucket ACL %v has no writer %s", rules, entity)
+		}
+	})
+}
+
+func TestNotifications(t *testing.T) {
+	forEachTransport(t, func(t *testing.T, c *storage.Client) {
+		ctx := context.Background()
+		b := mustCreateBucket(t, c, "bucket", nil)
+		n, err := b.AddNotification(ctx, &storage.Notification{
+			TopicProjectID: "proj",
+			TopicID:        "topic",
+			PayloadFormat:  storage.JSONPayload,
+			EventTypes:     []string{storage.ObjectFinalizeEvent},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.ID == "" || n.TopicID != "topic" || n.TopicProjectID != "proj" {
+			t.Errorf("got notification %+v", n)
+		}
+		ns, err := b.Notifications(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ns) != 1 || ns[n.ID] == nil {
+			t.Errorf("got notifications %v, want only %s", ns, n.ID)
+		}
+		if err := b.DeleteNotification(ctx, n.ID); err != nil {
+			t.Fatal(err)
+		}
+		if ns, err = b.Notifications(ctx); err != nil || len(ns) != 0 {
+			t.Errorf("got %v, %v; want no notifications", ns, err)
+		}
+	})
+}
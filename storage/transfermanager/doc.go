@@ -0,0 +1,55 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package transfermanager moves many objects, or large objects, to and from
+Google Cloud Storage concurrently.
+
+A [Downloader] downloads objects into an [io.WriterAt], such as an
+[os.File]. It splits objects larger than the part size into ranges, which
+it downloads in parallel, and checks the CRC32C checksum of the whole object
+once all of its ranges have been written. An [Uploader] uploads objects
+from [io.Reader]s. Both run at most a fixed number of requests at a time,
+and report the result of each transfer separately.
+
+	d, err := transfermanager.NewDownloader(client, transfermanager.WithWorkers(8))
+	if err != nil {
+		// TODO: Handle error.
+	}
+	for _, name := range names {
+		f, err := os.Create(name)
+		if err != nil {
+			// TODO: Handle error.
+		}
+		defer f.Close()
+		err = d.DownloadObject(ctx, &transfermanager.DownloadObjectInput{
+			Bucket:      "my-bucket",
+			Object:      name,
+			Destination: f,
+		})
+		if err != nil {
+			// TODO: Handle error.
+		}
+	}
+	results, err := d.WaitAndClose()
+	if err != nil {
+		// At least one download failed. See the results for details.
+	}
+	for _, r := range results {
+		// TODO: Use r.
+	}
+
+This package is EXPERIMENTAL and is subject to change without notice.
+*/
+package transfermanager // import "cloud.google.com/go/storage/transfermanager"
@@ -0,0 +1,290 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// Downloader downloads objects concurrently. Add downloads with
+// DownloadObject, then call WaitAndClose to wait for them to finish and get
+// their results.
+type Downloader struct {
+	client *storage.Client
+	config *transferManagerConfig
+	pool   *workerPool
+
+	mu      sync.Mutex
+	results []DownloadOutput // in the order the downloads were added
+}
+
+// NewDownloader returns a Downloader that uses client.
+func NewDownloader(client *storage.Client, opts ...Option) (*Downloader, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Downloader{
+		client: client,
+		config: c,
+		pool:   newWorkerPool(c.workers),
+	}, nil
+}
+
+// DownloadObjectInput describes an object to download.
+type DownloadObjectInput struct {
+	// Bucket and Object name the object. Required.
+	Bucket, Object string
+
+	// Generation is the generation of the object to download. If zero, the
+	// live version is downloaded.
+	Generation int64
+
+	// Conditions are preconditions on the object, if not nil.
+	Conditions *storage.Conditions
+
+	// EncryptionKey is the customer-supplied encryption key of the object,
+	// if any.
+	EncryptionKey []byte
+
+	// Destination receives the object's contents, at the same offsets as in
+	// the object. Ranges of a sliced download are written concurrently.
+	// Required.
+	Destination io.WriterAt
+
+	// Callback, if not nil, is called with the result of the download when
+	// it finishes. It may be called concurrently with other callbacks.
+	Callback func(*DownloadOutput)
+}
+
+// DownloadOutput is the result of a download.
+type DownloadOutput struct {
+	Bucket, Object string
+
+	// Attrs are the attributes of the object that was downloaded. They may
+	// be nil if Err is not nil.
+	Attrs *storage.ObjectAttrs
+
+	// Err is the error of the download, if it failed. Part of the object
+	// may have been written to its destination.
+	Err error
+}
+
+// DownloadObject adds a download. It returns an error if the input is
+// invalid or WaitAndClose has been called; errors of the download itself
+// are reported in its DownloadOutput. The download is canceled if ctx is.
+func (d *Downloader) DownloadObject(ctx context.Context, input *DownloadObjectInput) error {
+	if input.Bucket == "" || input.Object == "" {
+		return errors.New("transfermanager: DownloadObjectInput needs a bucket and an object")
+	}
+	if input.Destination == nil {
+		return errors.New("transfermanager: DownloadObjectInput needs a destination")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	i := len(d.results)
+	err := d.pool.start(func() {
+		attrs, err := d.download(ctx, input)
+		d.mu.Lock()
+		out := &d.results[i]
+		out.Attrs, out.Err = attrs, err
+		res := *out
+		d.mu.Unlock()
+		if input.Callback != nil {
+			input.Callback(&res)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	d.results = append(d.results, DownloadOutput{Bucket: input.Bucket, Object: input.Object})
+	return nil
+}
+
+// WaitAndClose waits for all of the downloads to finish, and returns their
+// results in the order they were added. If any download failed, the error
+// summarizes the failures. No downloads can be added afterwards.
+func (d *Downloader) WaitAndClose() ([]DownloadOutput, error) {
+	d.pool.close()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var errs []error
+	for _, r := range d.results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("gs://%s/%s: %w", r.Bucket, r.Object, r.Err))
+		}
+	}
+	return d.results, joinErrors("download", errs, len(d.results))
+}
+
+// download downloads an object, slicing it into ranges if it is larger than
+// the part size.
+func (d *Downloader) download(ctx context.Context, input *DownloadObjectInput) (*storage.ObjectAttrs, error) {
+	if d.config.perOpTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.config.perOpTimeout)
+		defer cancel()
+	}
+	o := d.client.Bucket(input.Bucket).Object(input.Object)
+	if input.Generation != 0 {
+		o = o.Generation(input.Generation)
+	}
+	if input.Conditions != nil {
+		o = o.If(*input.Conditions)
+	}
+	if input.EncryptionKey != nil {
+		o = o.Key(input.EncryptionKey)
+	}
+
+	if err := d.pool.acquire(ctx); err != nil {
+		return nil, err
+	}
+	attrs, err := o.Attrs(ctx)
+	d.pool.release()
+	if err != nil {
+		return nil, err
+	}
+	// Read every range from the same generation, even if the object is
+	// overwritten during the download.
+	o = o.Generation(attrs.Generation)
+
+	// A range of a gzip-encoded object is a range of its compressed bytes,
+	// but the service decompresses those objects when serving them. Download
+	// them with a single request, and let the client check them.
+	if attrs.ContentEncoding == "gzip" {
+		if err := d.pool.acquire(ctx); err != nil {
+			return attrs, err
+		}
+		defer d.pool.release()
+		r, err := o.NewReader(ctx)
+		if err != nil {
+			return attrs, err
+		}
+		defer r.Close()
+		_, err = io.Copy(&offsetWriter{w: input.Destination}, r)
+		return attrs, err
+	}
+
+	partSize := d.config.partSize
+	if partSize == 0 || attrs.Size <= partSize {
+		partSize = attrs.Size
+	}
+	crc, err := d.downloadParts(ctx, o, input.Destination, attrs.Size, partSize)
+	if err != nil {
+		return attrs, err
+	}
+	if crc != attrs.CRC32C {
+		return attrs, fmt.Errorf("transfermanager: bad CRC32C checksum: got %d, want %d", crc, attrs.CRC32C)
+	}
+	return attrs, nil
+}
+
+// downloadParts downloads an object of the given size to dst in parts of
+// partSize bytes, concurrently, and returns the CRC32C checksum of the
+// whole object. It stops at the first error.
+func (d *Downloader) downloadParts(ctx context.Context, o *storage.ObjectHandle, dst io.WriterAt, size, partSize int64) (uint32, error) {
+	nparts := 1
+	if partSize > 0 {
+		nparts = int((size + partSize - 1) / partSize)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		crcs     = make([]uint32, nparts)
+	)
+	for i := 0; i < nparts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			off := int64(i) * partSize
+			length := size - off
+			if length > partSize {
+				length = partSize
+			}
+			crc, err := d.downloadRange(ctx, o, dst, off, length)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+				return
+			}
+			crcs[i] = crc
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	crc := crcs[0]
+	for i := 1; i < nparts; i++ {
+		length := size - int64(i)*partSize
+		if length > partSize {
+			length = partSize
+		}
+		crc = crc32cCombine(crc, crcs[i], length)
+	}
+	return crc, nil
+}
+
+// downloadRange downloads length bytes of an object, starting at off, to the
+// same offset of dst, and returns their CRC32C checksum.
+func (d *Downloader) downloadRange(ctx context.Context, o *storage.ObjectHandle, dst io.WriterAt, off, length int64) (uint32, error) {
+	if length == 0 {
+		return 0, nil
+	}
+	if err := d.pool.acquire(ctx); err != nil {
+		return 0, err
+	}
+	defer d.pool.release()
+	r, err := o.NewRangeReader(ctx, off, length)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	h := crc32.New(crc32cTable)
+	n, err := io.Copy(io.MultiWriter(&offsetWriter{w: dst, off: off}, h), r)
+	if err != nil {
+		return 0, err
+	}
+	if n != length {
+		return 0, fmt.Errorf("transfermanager: got %d bytes at offset %d, want %d: %w", n, off, length, io.ErrUnexpectedEOF)
+	}
+	return h.Sum32(), nil
+}
+
+// An offsetWriter writes to an io.WriterAt sequentially from an offset.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
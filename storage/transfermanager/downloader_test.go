@@ -0,0 +1,200 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"cloud.google.com/go/storage/storagetest"
+)
+
+const bucket = "bucket"
+
+// newTestClient returns a client of a fake server with a bucket.
+func newTestClient(t *testing.T) *storage.Client {
+	t.Helper()
+	ctx := context.Background()
+	srv, err := storagetest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	c, err := storage.NewClient(ctx, srv.HTTPClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.Bucket(bucket).Create(ctx, "project", nil); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func writeObject(t *testing.T, c *storage.Client, name string, data []byte) {
+	t.Helper()
+	w := c.Bucket(bucket).Object(name).NewWriter(context.Background())
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+// buffer is an in-memory io.WriterAt.
+type buffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *buffer) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if end := int(off) + len(p); end > len(b.buf) {
+		b.buf = append(b.buf, make([]byte, end-len(b.buf))...)
+	}
+	return copy(b.buf[off:], p), nil
+}
+
+func TestCRC32CCombine(t *testing.T) {
+	data := randomBytes(10000)
+	want := crc32.Checksum(data, crc32cTable)
+	for _, split := range []int{0, 1, 17, 4096, 9999, 10000} {
+		crc1 := crc32.Checksum(data[:split], crc32cTable)
+		crc2 := crc32.Checksum(data[split:], crc32cTable)
+		if got := crc32cCombine(crc1, crc2, int64(len(data)-split)); got != want {
+			t.Errorf("split at %d: got %d, want %d", split, got, want)
+		}
+	}
+}
+
+func TestDownloader(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t)
+	objects := map[string][]byte{
+		"empty": {},
+		"small": []byte("hello"),
+		"exact": randomBytes(4 * 1000),
+		"large": randomBytes(10*1000 + 7),
+	}
+	for name, data := range objects {
+		writeObject(t, c, name, data)
+	}
+
+	var (
+		mu        sync.Mutex
+		callbacks = map[string]bool{}
+	)
+	d, err := NewDownloader(c, WithWorkers(3), WithPartSize(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"empty", "small", "exact", "large", "missing"}
+	bufs := map[string]*buffer{}
+	for _, name := range names {
+		bufs[name] = &buffer{}
+		err := d.DownloadObject(ctx, &DownloadObjectInput{
+			Bucket:      bucket,
+			Object:      name,
+			Destination: bufs[name],
+			Callback: func(out *DownloadOutput) {
+				mu.Lock()
+				callbacks[out.Object] = true
+				mu.Unlock()
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	results, err := d.WaitAndClose()
+	if err == nil {
+		t.Error("got nil error, want an error for the missing object")
+	}
+	if len(results) != len(names) {
+		t.Fatalf("got %d results, want %d", len(results), len(names))
+	}
+	for i, r := range results {
+		if r.Object != names[i] {
+			t.Errorf("result %d is for %q, want %q", i, r.Object, names[i])
+		}
+		if !callbacks[r.Object] {
+			t.Errorf("no callback for %q", r.Object)
+		}
+		if r.Object == "missing" {
+			if !errors.Is(r.Err, storage.ErrObjectNotExist) {
+				t.Errorf("missing object: got %v, want ErrObjectNotExist", r.Err)
+			}
+			continue
+		}
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Object, r.Err)
+			continue
+		}
+		if got, want := bufs[r.Object].buf, objects[r.Object]; !bytes.Equal(got, want) {
+			t.Errorf("%s: got %d bytes that differ from the %d in the object", r.Object, len(got), len(want))
+		}
+		if r.Attrs.Size != int64(len(objects[r.Object])) {
+			t.Errorf("%s: got size %d", r.Object, r.Attrs.Size)
+		}
+	}
+
+	if err := d.DownloadObject(ctx, &DownloadObjectInput{Bucket: bucket, Object: "small", Destination: &buffer{}}); err != errClosed {
+		t.Errorf("download after closing: got %v, want %v", err, errClosed)
+	}
+}
+
+func TestDownloaderCanceled(t *testing.T) {
+	c := newTestClient(t)
+	writeObject(t, c, "obj", randomBytes(5000))
+	d, err := NewDownloader(c, WithPartSize(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := d.DownloadObject(ctx, &DownloadObjectInput{Bucket: bucket, Object: "obj", Destination: &buffer{}}); err != nil {
+		t.Fatal(err)
+	}
+	results, err := d.WaitAndClose()
+	if err == nil || !errors.Is(results[0].Err, context.Canceled) {
+		t.Errorf("got %v, %v; want context.Canceled", results[0].Err, err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithWorkers(0)},
+		{WithPartSize(-1)},
+		{WithPerOpTimeout(-1)},
+	} {
+		if _, err := NewDownloader(nil, opts...); err == nil {
+			t.Errorf("%v: got nil, want error", opts)
+		}
+	}
+}
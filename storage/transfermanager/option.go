@@ -0,0 +1,95 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultWorkers  = 16
+	defaultPartSize = 32 * 1024 * 1024
+)
+
+// An Option configures a Downloader or an Uploader.
+type Option interface {
+	apply(*transferManagerConfig)
+}
+
+type transferManagerConfig struct {
+	// workers is the maximum number of requests in flight.
+	workers int
+
+	// partSize is the size of the ranges of a sliced download, or zero if
+	// downloads aren't sliced.
+	partSize int64
+
+	// perOpTimeout is the timeout of each transfer, or zero for none.
+	perOpTimeout time.Duration
+}
+
+func newConfig(opts []Option) (*transferManagerConfig, error) {
+	c := &transferManagerConfig{
+		workers:  defaultWorkers,
+		partSize: defaultPartSize,
+	}
+	for _, o := range opts {
+		o.apply(c)
+	}
+	if c.workers < 1 {
+		return nil, fmt.Errorf("transfermanager: %d workers; there must be at least one", c.workers)
+	}
+	if c.partSize < 0 {
+		return nil, fmt.Errorf("transfermanager: negative part size %d", c.partSize)
+	}
+	if c.perOpTimeout < 0 {
+		return nil, fmt.Errorf("transfermanager: negative timeout %v", c.perOpTimeout)
+	}
+	return c, nil
+}
+
+type withWorkers int
+
+func (w withWorkers) apply(c *transferManagerConfig) { c.workers = int(w) }
+
+// WithWorkers sets the maximum number of requests that are in flight at
+// once, across all of the transfers. The default is 16.
+func WithWorkers(n int) Option {
+	return withWorkers(n)
+}
+
+type withPartSize int64
+
+func (p withPartSize) apply(c *transferManagerConfig) { c.partSize = int64(p) }
+
+// WithPartSize sets the size of the ranges that a Downloader downloads in
+// parallel. Objects no larger than the part size are downloaded with a
+// single request. A part size of zero disables sliced downloads. The
+// default is 32 MiB.
+func WithPartSize(bytes int64) Option {
+	return withPartSize(bytes)
+}
+
+type withPerOpTimeout time.Duration
+
+func (t withPerOpTimeout) apply(c *transferManagerConfig) { c.perOpTimeout = time.Duration(t) }
+
+// WithPerOpTimeout sets a timeout on the transfer of each object, including
+// all of the ranges of a sliced download. By default there is no timeout
+// beyond that of the context passed with each object.
+func WithPerOpTimeout(d time.Duration) Option {
+	return withPerOpTimeout(d)
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var errClosed = errors.New("transfermanager: WaitAndClose has been called")
+
+// A workerPool runs transfers, limiting the number of requests in flight.
+type workerPool struct {
+	sem chan struct{} // holds a token for each request in flight
+
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+}
+
+func newWorkerPool(workers int) *workerPool {
+	return &workerPool{sem: make(chan struct{}, workers)}
+}
+
+// start runs f in a new goroutine, unless the pool is closed. f must call
+// acquire before each request.
+func (p *workerPool) start(f func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return errClosed
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		f()
+	}()
+	return nil
+}
+
+// acquire waits for a worker to be free, and reserves it. The caller must
+// call release when its request is done.
+func (p *workerPool) acquire(ctx context.Context) error {
+	select {
+	case p.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *workerPool) release() {
+	<-p.sem
+}
+
+// close stops the pool accepting transfers, and waits for those already
+// started to finish.
+func (p *workerPool) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// joinErrors summarizes the errors of a batch of n transfers.
+func joinErrors(op string, errs []error, n int) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("transfermanager: 1 of %d %ss failed: %w", n, op, errs[0])
+	}
+	return fmt.Errorf("transfermanager: %d of %d %ss failed; the first error was: %w", len(errs), n, op, errs[0])
+}
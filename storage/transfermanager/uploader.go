@@ -0,0 +1,179 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// Uploader uploads objects concurrently. Add uploads with UploadObject, then
+// call WaitAndClose to wait for them to finish and get their results.
+type Uploader struct {
+	client *storage.Client
+	config *transferManagerConfig
+	pool   *workerPool
+
+	mu      sync.Mutex
+	results []UploadOutput // in the order the uploads were added
+}
+
+// NewUploader returns an Uploader that uses client. The part size option
+// doesn't apply to uploads.
+func NewUploader(client *storage.Client, opts ...Option) (*Uploader, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Uploader{
+		client: client,
+		config: c,
+		pool:   newWorkerPool(c.workers),
+	}, nil
+}
+
+// UploadObjectInput describes an object to upload.
+type UploadObjectInput struct {
+	// Bucket and Object name the object. Required.
+	Bucket, Object string
+
+	// Source supplies the object's contents. Required.
+	Source io.Reader
+
+	// Attrs, if not nil, are the attributes of the new object, as for
+	// storage.Writer.ObjectAttrs. Their Bucket and Name are ignored.
+	Attrs *storage.ObjectAttrs
+
+	// Conditions are preconditions on the object, if not nil.
+	Conditions *storage.Conditions
+
+	// EncryptionKey is a customer-supplied key to encrypt the object with,
+	// if any.
+	EncryptionKey []byte
+
+	// ChunkSize is the chunk size of the upload, as for
+	// storage.Writer.ChunkSize. If zero, the storage package's default is
+	// used.
+	ChunkSize int
+
+	// Callback, if not nil, is called with the result of the upload when it
+	// finishes. It may be called concurrently with other callbacks.
+	Callback func(*UploadOutput)
+}
+
+// UploadOutput is the result of an upload.
+type UploadOutput struct {
+	Bucket, Object string
+
+	// Attrs are the attributes of the new object. They are nil if Err is not
+	// nil.
+	Attrs *storage.ObjectAttrs
+
+	// Err is the error of the upload, if it failed.
+	Err error
+}
+
+// UploadObject adds an upload. It returns an error if the input is invalid
+// or WaitAndClose has been called; errors of the upload itself are reported
+// in its UploadOutput. The upload is canceled if ctx is.
+func (u *Uploader) UploadObject(ctx context.Context, input *UploadObjectInput) error {
+	if input.Bucket == "" || input.Object == "" {
+		return errors.New("transfermanager: UploadObjectInput needs a bucket and an object")
+	}
+	if input.Source == nil {
+		return errors.New("transfermanager: UploadObjectInput needs a source")
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	i := len(u.results)
+	err := u.pool.start(func() {
+		attrs, err := u.upload(ctx, input)
+		u.mu.Lock()
+		out := &u.results[i]
+		out.Attrs, out.Err = attrs, err
+		res := *out
+		u.mu.Unlock()
+		if input.Callback != nil {
+			input.Callback(&res)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	u.results = append(u.results, UploadOutput{Bucket: input.Bucket, Object: input.Object})
+	return nil
+}
+
+// WaitAndClose waits for all of the uploads to finish, and returns their
+// results in the order they were added. If any upload failed, the error
+// summarizes the failures. No uploads can be added afterwards.
+func (u *Uploader) WaitAndClose() ([]UploadOutput, error) {
+	u.pool.close()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var errs []error
+	for _, r := range u.results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("gs://%s/%s: %w", r.Bucket, r.Object, r.Err))
+		}
+	}
+	return u.results, joinErrors("upload", errs, len(u.results))
+}
+
+func (u *Uploader) upload(ctx context.Context, input *UploadObjectInput) (*storage.ObjectAttrs, error) {
+	if u.config.perOpTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.config.perOpTimeout)
+		defer cancel()
+	}
+	if err := u.pool.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer u.pool.release()
+
+	o := u.client.Bucket(input.Bucket).Object(input.Object)
+	if input.Conditions != nil {
+		o = o.If(*input.Conditions)
+	}
+	if input.EncryptionKey != nil {
+		o = o.Key(input.EncryptionKey)
+	}
+	// Cancel the write if the copy fails, so that the object isn't created.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := o.NewWriter(ctx)
+	if input.Attrs != nil {
+		w.ObjectAttrs = *input.Attrs
+		w.ObjectAttrs.Bucket = input.Bucket
+		w.ObjectAttrs.Name = input.Object
+	}
+	if input.ChunkSize != 0 {
+		w.ChunkSize = input.ChunkSize
+	}
+	if _, err := io.Copy(w, input.Source); err != nil {
+		cancel()
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return w.Attrs(), nil
+}
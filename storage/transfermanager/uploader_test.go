@@ -0,0 +1,97 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestUploader(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t)
+	writeObject(t, c, "exists", []byte("old"))
+
+	u, err := NewUploader(c, WithWorkers(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("obj-%d", i)
+		names = append(names, name)
+		err := u.UploadObject(ctx, &UploadObjectInput{
+			Bucket: bucket,
+			Object: name,
+			Source: bytes.NewReader(randomBytes(1000 * i)),
+			Attrs:  &storage.ObjectAttrs{ContentType: "application/x-test", Name: "ignored"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	names = append(names, "exists")
+	if err := u.UploadObject(ctx, &UploadObjectInput{
+		Bucket:     bucket,
+		Object:     "exists",
+		Source:     bytes.NewReader([]byte("new")),
+		Conditions: &storage.Conditions{DoesNotExist: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := u.WaitAndClose()
+	if err == nil {
+		t.Error("got nil error, want an error for the failed precondition")
+	}
+	if len(results) != len(names) {
+		t.Fatalf("got %d results, want %d", len(results), len(names))
+	}
+	for i, r := range results {
+		if r.Object != names[i] {
+			t.Errorf("result %d is for %q, want %q", i, r.Object, names[i])
+		}
+		if r.Object == "exists" {
+			if r.Err == nil {
+				t.Error("overwriting with DoesNotExist: got nil, want error")
+			}
+			continue
+		}
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Object, r.Err)
+			continue
+		}
+		if r.Attrs.Name != r.Object || r.Attrs.ContentType != "application/x-test" || r.Attrs.Size != int64(1000*i) {
+			t.Errorf("%s: got attrs %+v", r.Object, r.Attrs)
+		}
+		rd, err := c.Bucket(bucket).Object(r.Object).NewReader(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rd)
+		rd.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, randomBytes(1000*i)) {
+			t.Errorf("%s: contents differ", r.Object)
+		}
+	}
+}
@@ -91,11 +91,22 @@ type Writer struct {
 	// calls to the underlying service (see
 	// https://cloud.google.com/storage/docs/json_api/v1/how-tos/resumable-upload),
 	// then ProgressFunc will be invoked after each call with the number of bytes of
-	// content copied so far.
+	// content copied so far. For a Writer returned by ResumeWriter, the count
+	// includes the bytes written before the upload was resumed.
 	//
 	// ProgressFunc should return quickly without blocking.
 	ProgressFunc func(int64)
 
+	// TrackSession makes the Writer record its resumable upload session, so
+	// that SessionURI and PersistedSize report it and an interrupted upload
+	// can be continued with ObjectHandle.ResumeWriter. With the JSON API, the
+	// Writer then runs the resumable upload itself rather than with the
+	// upload code of google.golang.org/api. ChunkSize must not be zero.
+	// Writers returned by ResumeWriter always track their session.
+	//
+	// TrackSession must be set before the first Write call.
+	TrackSession bool
+
 	ctx context.Context
 	o   *ObjectHandle
 
@@ -105,8 +116,14 @@ type Writer struct {
 	donec chan struct{} // closed after err and obj are set.
 	obj   *ObjectAttrs
 
-	mu  sync.Mutex
-	err error
+	// resumed is the object of a resumed upload session that had already
+	// finished, if any.
+	resumed *ObjectAttrs
+
+	mu         sync.Mutex
+	err        error
+	sessionURI string
+	persisted  int64
 }
 
 // Write appends to w. It implements the io.Writer interface.
@@ -185,14 +202,30 @@ func (w *Writer) openWriter() (err error) {
 		conds:              w.o.conds,
 		encryptionKey:      w.o.encryptionKey,
 		sendCRC32C:         w.SendCRC32C,
+		trackSession:       w.TrackSession,
 		donec:              w.donec,
 		setError:           w.error,
 		progress:           w.progress,
 		setObj:             func(o *ObjectAttrs) { w.obj = o },
+		setSession:         w.setSession,
+	}
+	w.mu.Lock()
+	params.sessionURI, params.offset = w.sessionURI, w.persisted
+	w.mu.Unlock()
+	if params.sessionURI != "" && w.ChunkSize == 0 {
+		return errors.New("storage: Writer.ChunkSize must not be zero when resuming an upload")
+	}
+	if w.TrackSession && w.ChunkSize == 0 {
+		return errors.New("storage: Writer.ChunkSize must not be zero when TrackSession is set")
 	}
 	if err := w.ctx.Err(); err != nil {
 		return err // short-circuit
 	}
+	if w.resumed != nil {
+		w.pw = w.openFinished()
+		w.opened = true
+		return nil
+	}
 	w.pw, err = w.o.c.tc.OpenWriter(params, opts...)
 	if err != nil {
 		return err
@@ -256,9 +289,110 @@ func (w *Writer) validateWriteAttrs() error {
 	return nil
 }
 
+// SessionURI returns the URI of the resumable upload session of the Writer,
+// or the empty string if it hasn't started one or TrackSession is not set.
+// The Writer starts a session when it has more than ChunkSize bytes to
+// upload; the URI is available by the time ProgressFunc is first called. When the client uses gRPC, the URI
+// is the upload ID of the session.
+//
+// Save the URI and the Writer's PersistedSize to continue the upload with
+// ObjectHandle.ResumeWriter if the Writer is interrupted, for example if the
+// process exits. Anyone with the URI can upload to the session, so treat it
+// like a credential.
+func (w *Writer) SessionURI() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sessionURI
+}
+
+// PersistedSize returns the number of bytes that Cloud Storage has persisted
+// in the Writer's resumable upload session, as of the last chunk that was
+// uploaded.
+func (w *Writer) PersistedSize() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.persisted
+}
+
+// ResumeWriter returns a Writer that continues the resumable upload session
+// with the given URI, as returned by Writer.SessionURI. It asks Cloud Storage
+// how many bytes the session has persisted, and seeks r to that offset. Write
+// the rest of r to the Writer and close it to finish the upload:
+//
+//	w, err := obj.ResumeWriter(ctx, sessionURI, f)
+//	if err != nil {
+//		// TODO: Handle error.
+//	}
+//	if _, err := io.Copy(w, f); err != nil {
+//		// TODO: Handle error.
+//	}
+//	if err := w.Close(); err != nil {
+//		// TODO: Handle error.
+//	}
+//
+// r must hold the same data that was written to the session. The object's
+// attributes and preconditions were set when the session was started, so the
+// Writer's ObjectAttrs and the ObjectHandle's conditions are ignored. If the
+// object was written with a customer-supplied encryption key, the
+// ObjectHandle must have the same key. ChunkSize must not be zero.
+//
+// If the session has already finished, r is seeked to its end, and Close
+// returns nil without uploading anything.
+func (o *ObjectHandle) ResumeWriter(ctx context.Context, sessionURI string, r io.ReadSeeker) (*Writer, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if sessionURI == "" {
+		return nil, errors.New("storage: session URI is empty")
+	}
+	opts := makeStorageOpts(true, o.retry, o.userProject)
+	persisted, obj, err := o.c.tc.QueryUpload(ctx, sessionURI, o.encryptionKey, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(persisted, io.SeekStart); err != nil {
+		return nil, err
+	}
+	w := o.NewWriter(ctx)
+	w.TrackSession = true
+	w.sessionURI = sessionURI
+	w.persisted = persisted
+	w.resumed = obj
+	return w, nil
+}
+
+// openFinished returns a pipe for a Writer whose resumed upload session had
+// already finished. Writing any data to it is an error.
+func (w *Writer) openFinished() *io.PipeWriter {
+	pr, pw := io.Pipe()
+	go func() {
+		defer close(w.donec)
+		var buf [1]byte
+		if n, _ := pr.Read(buf[:]); n > 0 {
+			err := errors.New("storage: resumed upload session has already finished")
+			w.error(err)
+			pr.CloseWithError(err)
+			return
+		}
+		w.obj = w.resumed
+	}()
+	return pw
+}
+
+// setSession records the URI of the Writer's resumable upload session.
+func (w *Writer) setSession(uri string) {
+	w.mu.Lock()
+	w.sessionURI = uri
+	w.mu.Unlock()
+}
+
 // progress is a convenience wrapper that reports write progress to the Writer
-// ProgressFunc if it is set and progress is non-zero.
+// ProgressFunc if it is set and progress is non-zero. It also records the
+// progress as the persisted size.
 func (w *Writer) progress(p int64) {
+	w.mu.Lock()
+	w.persisted = p
+	w.mu.Unlock()
 	if w.ProgressFunc != nil && p != 0 {
 		w.ProgressFunc(p)
 	}
@@ -15,10 +15,13 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"io"
+	"math/rand"
 	"net/http"
 	"strings"
 	"testing"
@@ -194,3 +197,141 @@ func TestCloseDoesNotLeak(t *testing.T) {
 
 	wc.Close()
 }
+
+func TestResumeWriter(t *testing.T) {
+	for _, transport := range []string{"http", "grpc"} {
+		t.Run(transport, func(t *testing.T) {
+			b := newFakeBucket(t, transport == "grpc")
+			obj := b.Object("obj")
+			data := make([]byte, 5*googleapi.MinUploadChunkSize+100)
+			rand.New(rand.NewSource(1)).Read(data)
+
+			// Interrupt an upload after its first chunk.
+			ctx, cancel := context.WithCancel(context.Background())
+			w := obj.NewWriter(ctx)
+			w.ChunkSize = googleapi.MinUploadChunkSize
+			w.ContentType = "application/x-test"
+			w.TrackSession = true
+			var (
+				uri       string
+				persisted int64
+			)
+			w.ProgressFunc = func(int64) {
+				if uri == "" {
+					uri, persisted = w.SessionURI(), w.PersistedSize()
+					cancel()
+				}
+			}
+			io.Copy(w, bytes.NewReader(data))
+			if err := w.Close(); !errors.Is(err, context.Canceled) {
+				t.Fatalf("interrupted Close: got %v, want context.Canceled", err)
+			}
+			if uri == "" || persisted != googleapi.MinUploadChunkSize {
+				t.Fatalf("got session %q with %d bytes persisted", uri, persisted)
+			}
+			if _, err := obj.Attrs(context.Background()); err != ErrObjectNotExist {
+				t.Fatalf("object exists before the upload is resumed: %v", err)
+			}
+
+			ctx = context.Background()
+			r := bytes.NewReader(data)
+			w, err := obj.ResumeWriter(ctx, uri, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.PersistedSize(); got != persisted {
+				t.Errorf("PersistedSize: got %d, want %d", got, persisted)
+			}
+			if got := r.Len(); got != len(data)-int(persisted) {
+				t.Errorf("reader has %d bytes left, want %d", got, len(data)-int(persisted))
+			}
+			w.ChunkSize = googleapi.MinUploadChunkSize
+			var progress int64
+			w.ProgressFunc = func(n int64) { progress = n }
+			if _, err := io.Copy(w, r); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if progress != int64(len(data)) {
+				t.Errorf("final progress: got %d, want %d", progress, len(data))
+			}
+			if attrs := w.Attrs(); attrs.Size != int64(len(data)) || attrs.ContentType != "application/x-test" {
+				t.Errorf("got attrs %+v", attrs)
+			}
+			rd, err := obj.NewReader(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(rd)
+			rd.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("read %d bytes that differ from the %d written", len(got), len(data))
+			}
+
+			// Resuming a finished session writes nothing.
+			r = bytes.NewReader(data)
+			w, err = obj.ResumeWriter(ctx, uri, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Len() != 0 {
+				t.Errorf("reader has %d bytes left, want 0", r.Len())
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if attrs := w.Attrs(); attrs == nil || attrs.Size != int64(len(data)) {
+				t.Errorf("got attrs %+v for the finished session", attrs)
+			}
+		})
+	}
+}
+
+func TestWriterSessionURI(t *testing.T) {
+	ctx := context.Background()
+	b := newFakeBucket(t, false)
+
+	// An object that fits in one chunk is uploaded without a session.
+	w := b.Object("small").NewWriter(ctx)
+	w.ChunkSize = googleapi.MinUploadChunkSize
+	w.TrackSession = true
+	w.Write([]byte("hello"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if uri := w.SessionURI(); uri != "" {
+		t.Errorf("got session %q, want none", uri)
+	}
+
+	// Without TrackSession, the session of a multi-chunk upload isn't
+	// reported.
+	w = b.Object("large").NewWriter(ctx)
+	w.ChunkSize = googleapi.MinUploadChunkSize
+	w.Write(make([]byte, 2*googleapi.MinUploadChunkSize+1))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if uri := w.SessionURI(); uri != "" {
+		t.Errorf("without TrackSession: got session %q, want none", uri)
+	}
+	if got, want := w.Attrs().Size, int64(2*googleapi.MinUploadChunkSize+1); got != want {
+		t.Errorf("without TrackSession: got size %d, want %d", got, want)
+	}
+
+	// TrackSession needs chunks.
+	w = b.Object("unchunked").NewWriter(ctx)
+	w.ChunkSize = 0
+	w.TrackSession = true
+	if _, err := w.Write([]byte("hello")); err == nil {
+		t.Error("TrackSession without ChunkSize: got nil, want error")
+	}
+
+	if _, err := b.Object("small").ResumeWriter(ctx, "", bytes.NewReader(nil)); err == nil {
+		t.Error("resuming without a session: got nil, want error")
+	}
+}